@@ -7,11 +7,14 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -28,7 +31,7 @@ func CommandAction(cmd *cobra.Command, args []string) {
 	action := ""
 	if len(args) > 1 {
 		cmd.Help()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	} else if len(args) == 1 {
 		action = args[0]
 	}
@@ -39,6 +42,40 @@ func CommandAction(cmd *cobra.Command, args []string) {
 		log.Fatalf("  to submit your code for grading, use '%s grade'", os.Args[0])
 	}
 
+	var daycareArgs []string
+	if cmd.Flag("clear-workspace").Value.String() == "true" {
+		daycareArgs = append(daycareArgs, "clearWorkspace=true")
+	}
+	startAction(now, action, daycareArgs)
+}
+
+func CommandRepl(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	now := time.Now()
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+
+	startAction(now, "repl", nil)
+}
+
+func CommandDebug(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	now := time.Now()
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+
+	startAction(now, "debug", nil)
+}
+
+// startAction saves the student's work and runs an action interactively,
+// passing any key=value args to the daycare.
+func startAction(now time.Time, action string, args []string) {
 	// get the user ID
 	user := new(User)
 	mustGetObject("/users/me", nil, user)
@@ -48,11 +85,17 @@ func CommandAction(cmd *cobra.Command, args []string) {
 	commit.Note = "grind action " + action
 	unsigned := &CommitBundle{
 		UserID: user.ID,
-		Commit: commit,
+		Commit: deltaCommit(commit),
 	}
 
 	// if the requested action does not exist, report available choices
 	if _, exists := problemType.Actions[action]; !exists {
+		switch action {
+		case "repl":
+			fmt.Printf("problem type %s does not have a REPL\n", problemType.Name)
+		case "debug":
+			fmt.Printf("problem type %s does not have a debugger\n", problemType.Name)
+		}
 		fmt.Printf("available actions for problem type %s:\n", problemType.Name)
 		for elt := range problemType.Actions {
 			if elt == "grade" {
@@ -64,6 +107,7 @@ func CommandAction(cmd *cobra.Command, args []string) {
 	}
 
 	// send the commit bundle to the server
+	signCommitBundle(unsigned)
 	signed := new(CommitBundle)
 	mustPostObject("/commit_bundles/unsigned", nil, unsigned, signed)
 
@@ -72,7 +116,7 @@ func CommandAction(cmd *cobra.Command, args []string) {
 		log.Fatalf("server was unable to find a suitable daycare, unable to run action")
 	}
 	fmt.Printf("starting interactive session for %s step %d\n", problem.Unique, commit.Step)
-	runInteractiveSession(signed, nil, ".")
+	runInteractiveSession(signed, args, ".")
 }
 
 func runInteractiveSession(bundle *CommitBundle, args []string, directory string) {
@@ -110,6 +154,11 @@ func runInteractiveSession(bundle *CommitBundle, args []string, directory string
 		vals.Set("COLUMNS", strconv.Itoa(int(sizex)))
 		vals.Set("LINES", strconv.Itoa(int(sizey)))
 	}
+	for _, arg := range args {
+		if parts := strings.SplitN(arg, "=", 2); len(parts) == 2 {
+			vals.Set(parts[0], parts[1])
+		}
+	}
 	if term := os.Getenv("TERM"); term != "" {
 		vals.Set("TERM", term)
 	} else if runtime.GOOS == "windows" {
@@ -123,7 +172,9 @@ func runInteractiveSession(bundle *CommitBundle, args []string, directory string
 		RawQuery: vals.Encode(),
 	}
 
-	socket, resp, err := websocket.DefaultDialer.Dial(endpoint.String(), nil)
+	headers := make(http.Header)
+	headers.Set("Sec-Websocket-Protocol", SocketCompression)
+	socket, resp, err := websocket.DefaultDialer.Dial(endpoint.String(), headers)
 	if err != nil {
 		log.Printf("error dialing: %v", err)
 		if resp != nil && resp.Body != nil {
@@ -138,7 +189,7 @@ func runInteractiveSession(bundle *CommitBundle, args []string, directory string
 	// form the initial request
 	req := &DaycareRequest{CommitBundle: bundle}
 	dumpOutgoing(req)
-	if err := socket.WriteJSON(req); err != nil {
+	if err := WriteSocketJSON(socket, req); err != nil {
 		log.Printf("error writing request message: %v", err)
 		return
 	}
@@ -150,7 +201,7 @@ func runInteractiveSession(bundle *CommitBundle, args []string, directory string
 			if count == 0 && err == io.EOF {
 				closeReq := &DaycareRequest{CloseStdin: true}
 				dumpOutgoing(closeReq)
-				if err := socket.WriteJSON(closeReq); err != nil {
+				if err := WriteSocketJSON(socket, closeReq); err != nil {
 					log.Printf("error writing stdin request message: %v", err)
 					return
 				}
@@ -158,7 +209,7 @@ func runInteractiveSession(bundle *CommitBundle, args []string, directory string
 				log.Printf("terminal error: %v", err)
 				closeReq := &DaycareRequest{CloseStdin: true}
 				dumpOutgoing(closeReq)
-				if err := socket.WriteJSON(closeReq); err != nil {
+				if err := WriteSocketJSON(socket, closeReq); err != nil {
 					log.Printf("error writing stdin request message: %v", err)
 				}
 				return
@@ -169,7 +220,7 @@ func runInteractiveSession(bundle *CommitBundle, args []string, directory string
 				copy(data, buffer[:count])
 				stdinReq := &DaycareRequest{Stdin: data}
 				dumpOutgoing(stdinReq)
-				if err := socket.WriteJSON(stdinReq); err != nil {
+				if err := WriteSocketJSON(socket, stdinReq); err != nil {
 					log.Printf("error writing stdin request message: %v", err)
 					return
 				}
@@ -180,7 +231,7 @@ func runInteractiveSession(bundle *CommitBundle, args []string, directory string
 	// start listening for events
 	for {
 		reply := new(DaycareResponse)
-		if err := socket.ReadJSON(reply); err != nil {
+		if err := ReadSocketJSON(socket, reply); err != nil {
 			//log.Printf("socket error reading event: %v", err)
 			log.Printf("session closed by server\r")
 			return
@@ -203,6 +254,9 @@ func runInteractiveSession(bundle *CommitBundle, args []string, directory string
 				fmt.Fprintf(out, "%s", reply.Event.Dump())
 			case "stderr":
 				fmt.Fprintf(stderr, "%s", reply.Event.Dump())
+			case "display":
+				fmt.Fprintf(out, "%s", reply.Event.Dump())
+				openBrowser(reply.Event.DisplayURL)
 			case "files":
 				if reply.Event.Files != nil {
 					for name, contents := range reply.Event.Files {
@@ -221,6 +275,23 @@ func runInteractiveSession(bundle *CommitBundle, args []string, directory string
 	}
 }
 
+// openBrowser tries to open a page in the user's web browser,
+// quietly giving up if it cannot since the link is also printed.
+func openBrowser(link string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", link)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", link)
+	default:
+		cmd = exec.Command("xdg-open", link)
+	}
+	if err := cmd.Start(); err == nil {
+		go cmd.Wait()
+	}
+}
+
 var rawMode = false
 
 func dumpOutgoing(msg interface{}) {
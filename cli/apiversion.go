@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// serverAPIVersion is the API revision the server reported in its most
+// recent response, or zero if it has not said. Code that depends on a
+// newer endpoint can check it with atomic.LoadInt32 and fall back for
+// older servers.
+var serverAPIVersion int32
+
+// apiTransport adds the API version and the chosen locale to every
+// request and warns once about each deprecated endpoint that grind uses.
+type apiTransport struct {
+	next   http.RoundTripper
+	mutex  sync.Mutex
+	warned map[string]bool
+}
+
+func (t *apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set(APIVersionHeader, strconv.Itoa(APIVersion))
+	if Config.Locale != "" && req.Header.Get("Accept-Language") == "" {
+		req.Header.Set("Accept-Language", Config.Locale)
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if v, err := strconv.Atoi(resp.Header.Get(APIVersionHeader)); err == nil {
+		atomic.StoreInt32(&serverAPIVersion, int32(v))
+	}
+	if resp.Header.Get("Deprecation") != "" {
+		t.warnDeprecated(req, resp)
+	}
+	return resp, nil
+}
+
+func (t *apiTransport) warnDeprecated(req *http.Request, resp *http.Response) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.warned == nil {
+		t.warned = make(map[string]bool)
+	}
+	key := req.Method + " " + req.URL.Path
+	if t.warned[key] {
+		return
+	}
+	t.warned[key] = true
+
+	if sunset, err := http.ParseTime(resp.Header.Get("Sunset")); err == nil {
+		log.Printf("warning: this version of grind uses a server feature that stops working on %s", sunset.Local().Format("Jan 2, 2006"))
+	} else {
+		log.Printf("warning: this version of grind uses a server feature that will stop working soon")
+	}
+	log.Printf("  please run '%s upgrade' before then", os.Args[0])
+	debugf("deprecated endpoint: %s", key)
+}
+
+// installTransports sets up the HTTP client used for all requests.
+func installTransports() {
+	http.DefaultClient.Transport = &apiTransport{next: http.DefaultTransport}
+}
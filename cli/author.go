@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandAuthorExport(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) < 1 || len(args) > 2 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	unique := args[0]
+	filename := unique + ".tar.gz"
+	if len(args) == 2 {
+		filename = args[1]
+	}
+
+	// find the problem set
+	problemSets := []*ProblemSet{}
+	params := make(url.Values)
+	params.Add("unique", unique)
+	mustGetObject("/problem_sets", params, &problemSets)
+	if len(problemSets) != 1 {
+		log.Fatalf("no problem set found with unique ID %q", unique)
+	}
+
+	raw := doRawRequest(fmt.Sprintf("/problem_sets/%d/export", problemSets[0].ID), nil, "GET", "", nil)
+
+	// make sure it parses before saving it
+	archive, err := ReadProblemSetArchive(bytes.NewReader(raw))
+	if err != nil {
+		log.Fatalf("server returned an invalid archive: %v", err)
+	}
+	if err := ioutil.WriteFile(filename, raw, 0644); err != nil {
+		log.Fatalf("error saving %s: %v", filename, err)
+	}
+	fmt.Printf("saved problem set %s with %d problem(s) to %s\n", unique, len(archive.Problems), filename)
+}
+
+func CommandAuthorImport(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	filename := args[0]
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatalf("error reading %s: %v", filename, err)
+	}
+	archive, err := ReadProblemSetArchive(bytes.NewReader(raw))
+	if err != nil {
+		log.Fatalf("error in %s: %v", filename, err)
+	}
+	for _, elt := range archive.Problems {
+		fmt.Printf("problem %s with %d step(s)\n", elt.Problem.Unique, len(elt.Steps))
+	}
+
+	doRawRequest("/problem_sets/import", nil, "POST", "application/gzip", raw)
+	fmt.Printf("problem set %s imported\n", archive.ProblemSet.Unique)
+}
+
+func CommandAuthorPublish(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	unique := args[0]
+
+	// find the problem set
+	problemSets := []*ProblemSet{}
+	params := make(url.Values)
+	params.Add("unique", unique)
+	mustGetObject("/problem_sets", params, &problemSets)
+	if len(problemSets) != 1 {
+		log.Fatalf("no problem set found with unique ID %q", unique)
+	}
+
+	set := new(ProblemSet)
+	path := fmt.Sprintf("/problem_sets/%d/public", problemSets[0].ID)
+	if cmd.Flag("off").Value.String() == "true" {
+		mustDeleteObject(path, nil, set)
+		fmt.Printf("problem set %s is no longer public\n", unique)
+	} else {
+		mustPostObject(path, nil, nil, set)
+		fmt.Printf("problem set %s is public; anyone can practice it with '%s practice %s'\n", unique, os.Args[0], unique)
+	}
+}
+
+func CommandAuthorConvert(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) < 3 || len(args) > 4 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	format, filename, unique := args[0], args[1], args[2]
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatalf("error reading %s: %v", filename, err)
+	}
+	if _, err := os.Stat(unique); err == nil {
+		log.Fatalf("%s already exists; please remove it or choose a different unique ID", unique)
+	}
+
+	params := make(url.Values)
+	params.Add("format", format)
+	params.Add("unique", unique)
+	if len(args) == 4 {
+		params.Add("type", args[3])
+	}
+	bundle := new(ProblemBundle)
+	if err := json.Unmarshal(doRawRequest("/problem_bundles/convert", params, "POST", "application/zip", raw), bundle); err != nil {
+		log.Fatalf("failed to parse result object from server: %v", err)
+	}
+	if bundle.Problem == nil || len(bundle.ProblemSteps) != 1 {
+		log.Fatalf("unexpected result from server")
+	}
+	step := bundle.ProblemSteps[0]
+
+	// write problem.cfg
+	cfg := new(bytes.Buffer)
+	fmt.Fprintf(cfg, "[problem]\n")
+	fmt.Fprintf(cfg, "unique = %s\n", bundle.Problem.Unique)
+	fmt.Fprintf(cfg, "note = %s\n", bundle.Problem.Note)
+	fmt.Fprintf(cfg, "type = %s\n", step.ProblemType)
+	for _, tag := range bundle.Problem.Tags {
+		fmt.Fprintf(cfg, "tag = %s\n", tag)
+	}
+	files := map[string][]byte{ProblemConfigName: cfg.Bytes()}
+	for name, contents := range step.Files {
+		files[filepath.FromSlash(name)] = contents
+	}
+	updateFiles(unique, files, nil, true)
+
+	fmt.Printf("problem skeleton for %s saved in %s\n", unique, unique)
+	hasSolution := false
+	for name := range step.Files {
+		if strings.HasPrefix(name, "_solution/") {
+			hasSolution = true
+		}
+	}
+	if !hasSolution {
+		fmt.Printf("  add the solution files to %s/_solution,\n", unique)
+	}
+	fmt.Printf("  review the instructions in %s/doc, then run '%s create'\n", unique, os.Args[0])
+}
+
+func CommandAuthorNew(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	unique := args[0]
+	if url.QueryEscape(unique) != unique {
+		log.Fatalf("unique ID must be URL friendly: %s is escaped as %s", unique, url.QueryEscape(unique))
+	}
+	problemType := cmd.Flag("type").Value.String()
+	if problemType == "" {
+		log.Fatalf("you must give the problem type with --type; use '%s type' to list them", os.Args[0])
+	}
+	steps, err := strconv.Atoi(cmd.Flag("steps").Value.String())
+	if err != nil || steps < 1 {
+		log.Fatalf("--steps must be a positive number")
+	}
+	note := cmd.Flag("note").Value.String()
+	if note == "" {
+		note = unique
+	}
+	if _, err := os.Stat(unique); err == nil {
+		log.Fatalf("%s already exists; please remove it or choose a different unique ID", unique)
+	}
+
+	template := new(ProblemTemplate)
+	mustGetObject(fmt.Sprintf("/problem_types/%s/template", problemType), nil, template)
+
+	// write problem.cfg
+	cfg := new(bytes.Buffer)
+	fmt.Fprintf(cfg, "[problem]\n")
+	fmt.Fprintf(cfg, "unique = %s\n", unique)
+	fmt.Fprintf(cfg, "note = %s\n", note)
+	fmt.Fprintf(cfg, "type = %s\n", template.ProblemType)
+	if steps > 1 {
+		for i := 1; i <= steps; i++ {
+			fmt.Fprintf(cfg, "\n[step \"%d\"]\n", i)
+			fmt.Fprintf(cfg, "note = step %d\n", i)
+			fmt.Fprintf(cfg, "weight = 1.0\n")
+		}
+	}
+	files := map[string][]byte{ProblemConfigName: cfg.Bytes()}
+
+	// single-step problems keep the step files with problem.cfg;
+	// later steps get the solution but carry the starter files forward
+	for i := 1; i <= steps; i++ {
+		dir := ""
+		if steps > 1 {
+			dir = strconv.Itoa(i)
+		}
+		for name, contents := range template.Files {
+			if i > 1 && strings.HasPrefix(name, "_starter/") {
+				continue
+			}
+			contents = bytes.Replace(contents, []byte("{unique}"), []byte(unique), -1)
+			files[filepath.Join(dir, filepath.FromSlash(name))] = contents
+		}
+	}
+	updateFiles(unique, files, nil, true)
+
+	fmt.Printf("problem skeleton for %s saved in %s\n", unique, unique)
+	fmt.Printf("  edit the starter files, solution, tests, and instructions,\n")
+	fmt.Printf("  then check it with '%s author validate' and upload it with '%s create'\n", os.Args[0], os.Args[0])
+}
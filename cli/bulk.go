@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandBulk(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	best := cmd.Flag("best").Value.String() == "true"
+	reports := cmd.Flag("reports").Value.String() == "true"
+	match := strings.ToLower(cmd.Flag("match").Value.String())
+	dir := cmd.Flag("dir").Value.String()
+	params := make(url.Values)
+	if section := cmd.Flag("section").Value.String(); section != "" {
+		params.Add("section", section)
+	}
+
+	// find the instructor assignments to start from
+	var groups []*Assignment
+	if id, err := strconv.ParseInt(args[0], 10, 64); err == nil && id > 0 {
+		assignment := new(Assignment)
+		mustGetObject(fmt.Sprintf("/assignments/%d", id), nil, assignment)
+		groups = append(groups, assignment)
+	} else {
+		user := new(User)
+		mustGetObject("/users/me", nil, user)
+		var mine []*Assignment
+		mustGetObject(fmt.Sprintf("/users/%d/assignments", user.ID), nil, &mine)
+		for _, asst := range filterOutQuizzes(mine) {
+			problemSet := new(ProblemSet)
+			mustGetObject(fmt.Sprintf("/problem_sets/%d", asst.ProblemSetID), nil, problemSet)
+			if problemSet.Unique == args[0] {
+				groups = append(groups, asst)
+			}
+		}
+		if len(groups) == 0 {
+			log.Fatalf("you do not have an assignment for problem set %s", args[0])
+		}
+	}
+
+	count := 0
+	for _, group := range groups {
+		if group.ProblemSetID < 1 {
+			log.Fatalf("cannot download quiz assignments")
+		}
+		course := new(Course)
+		mustGetObject(fmt.Sprintf("/courses/%d", group.CourseID), nil, course)
+		problemSet := new(ProblemSet)
+		mustGetObject(fmt.Sprintf("/problem_sets/%d", group.ProblemSetID), nil, problemSet)
+		problemSetProblems := []*ProblemSetProblem{}
+		mustGetObject(fmt.Sprintf("/problem_sets/%d/problems", group.ProblemSetID), nil, &problemSetProblems)
+		problems := make(map[int64]*Problem)
+		for _, elt := range problemSetProblems {
+			problem := new(Problem)
+			mustGetObject(fmt.Sprintf("/problems/%d", elt.ProblemID), nil, problem)
+			problems[problem.ID] = problem
+		}
+
+		var students []*Assignment
+		mustGetObject(fmt.Sprintf("/assignments/%d/students", group.ID), params, &students)
+		for _, asst := range students {
+			user := new(User)
+			mustGetObject(fmt.Sprintf("/users/%d", asst.UserID), nil, user)
+			if match != "" && !strings.Contains(strings.ToLower(user.Name+" "+user.Email), match) {
+				continue
+			}
+			student := user.Email
+			if student == "" {
+				student = fmt.Sprintf("user-%d", user.ID)
+			}
+			target := filepath.Join(dir, courseDirectory(course.Label), problemSet.Unique, student)
+			fmt.Printf("[%s] asst %d @ %.0f%% -> %s\n", user.Name, asst.ID, asst.Score*100.0, target)
+
+			for _, problem := range problems {
+				commit := bulkCommit(asst, problem, best)
+				if commit == nil {
+					continue
+				}
+				problemDir := target
+				if len(problems) > 1 {
+					problemDir = filepath.Join(target, problem.Unique)
+				}
+				files := make(map[string][]byte)
+				for name, contents := range commit.Files {
+					files[name] = contents
+				}
+				if reports && commit.ReportCard != nil {
+					raw, err := json.MarshalIndent(commit.ReportCard, "", "    ")
+					if err != nil {
+						log.Fatalf("JSON error encoding report card: %v", err)
+					}
+					files["report_card.json"] = append(raw, '\n')
+					var transcript bytes.Buffer
+					if err := commit.DumpTranscript(&transcript); err != nil {
+						log.Fatalf("error writing transcript: %v", err)
+					}
+					if transcript.Len() > 0 {
+						files["transcript.txt"] = transcript.Bytes()
+					}
+				}
+				updateFiles(problemDir, files, nil, false)
+			}
+			count++
+		}
+	}
+	fmt.Printf("downloaded %d student assignment%s\n", count, plural(count))
+}
+
+// bulkCommit returns the latest commit for a problem, or the highest-scoring
+// saved version if best is set. It returns nil if the student has not started.
+func bulkCommit(asst *Assignment, problem *Problem, best bool) *Commit {
+	if !best {
+		commit := new(Commit)
+		if !getObject(fmt.Sprintf("/assignments/%d/problems/%d/commits/last", asst.ID, problem.ID), nil, commit) {
+			return nil
+		}
+		return commit
+	}
+
+	// the history is oldest first and omits files, so find the best one and fetch it
+	history := []*Commit{}
+	mustGetObject(fmt.Sprintf("/assignments/%d/problems/%d/commits/history", asst.ID, problem.ID), nil, &history)
+	var pick *Commit
+	for _, elt := range history {
+		if pick == nil || elt.Step > pick.Step || elt.Step == pick.Step && elt.Score >= pick.Score {
+			pick = elt
+		}
+	}
+	if pick == nil {
+		return nil
+	}
+	commit := new(Commit)
+	mustGetObject(fmt.Sprintf("/commit_history/%d", pick.ID), nil, commit)
+	return commit
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// cached responses not used for this long are removed
+	responseCacheMaxAge = 30 * 24 * time.Hour
+
+	// at most this many responses are kept, dropping the least recently used
+	responseCacheLimit = 500
+)
+
+// cachedResponse is a GET response saved along with its ETag,
+// so it can be reused when the server replies 304 Not Modified.
+type cachedResponse struct {
+	ETag string          `json:"etag"`
+	Body json.RawMessage `json:"body"`
+}
+
+// responseCachePath gives the cache file for a request URL.
+// The session cookie is part of the key, so users sharing
+// a machine never see each other's responses.
+func responseCachePath(url string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(Config.Cookie + "\n" + url))
+	return filepath.Join(dir, "codegrinder", hex.EncodeToString(sum[:]))
+}
+
+func loadCachedResponse(url string) *cachedResponse {
+	path := responseCachePath(url)
+	if path == "" {
+		return nil
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	cached := new(cachedResponse)
+	if err := json.Unmarshal(raw, cached); err != nil || cached.ETag == "" {
+		return nil
+	}
+
+	// note the use so pruning keeps it
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return cached
+}
+
+// saveCachedResponse records a response for later use.
+// Failures are ignored since the cache is only an optimization.
+func saveCachedResponse(url, etag string, body []byte) {
+	path := responseCachePath(url)
+	if path == "" {
+		return
+	}
+	raw, err := json.Marshal(&cachedResponse{ETag: etag, Body: body})
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return
+	}
+	os.Rename(tmp, path)
+	pruneResponseCache(filepath.Dir(path))
+}
+
+// pruneResponseCache removes old responses and keeps the cache
+// from growing past responseCacheLimit files.
+func pruneResponseCache(dir string) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var keep []os.FileInfo
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if time.Since(info.ModTime()) > responseCacheMaxAge {
+			os.Remove(filepath.Join(dir, info.Name()))
+		} else {
+			keep = append(keep, info)
+		}
+	}
+	if len(keep) <= responseCacheLimit {
+		return
+	}
+	sort.Slice(keep, func(i, j int) bool { return keep[i].ModTime().After(keep[j].ModTime()) })
+	for _, info := range keep[responseCacheLimit:] {
+		os.Remove(filepath.Join(dir, info.Name()))
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandCheck(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	now := time.Now()
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+
+	// get the user ID
+	user := new(User)
+	mustGetObject("/users/me", nil, user)
+
+	_, problem, _, commit, _, _ := gatherStudent(now, ".")
+	commit.Action = "grade"
+	commit.Note = "grind check"
+	unsigned := &CommitBundle{
+		UserID:    user.ID,
+		Commit:    deltaCommit(commit),
+		Ephemeral: true,
+	}
+
+	// the server signs the commit without saving it
+	signCommitBundle(unsigned)
+	signed := new(CommitBundle)
+	mustPostObject("/commit_bundles/unsigned", nil, unsigned, signed)
+	if signed.Hostname == "" {
+		log.Fatalf("server was unable to find a suitable daycare, unable to check")
+	}
+	infof("checking %s step %d without submitting it\n", problem.Unique, commit.Step)
+	graded := mustConfirmCommitBundle(signed, nil)
+
+	// report back so the time is counted, but nothing is recorded
+	toSave := &CommitBundle{
+		Hostname:        graded.Hostname,
+		UserID:          graded.UserID,
+		Commit:          deltaCommit(graded.Commit),
+		CommitSignature: graded.CommitSignature,
+		Ephemeral:       true,
+	}
+	checked := new(CommitBundle)
+	mustPostObject("/commit_bundles/signed", nil, toSave, checked)
+	commit = checked.Commit
+
+	if warnings := commit.Warnings(); len(warnings) > 0 {
+		fmt.Printf("  %d compiler warning%s:\n", len(warnings), plural(len(warnings)))
+		for _, warning := range warnings {
+			fmt.Printf("    %s\n", warning)
+		}
+	}
+
+	if commit.ReportCard != nil && commit.ReportCard.Passed && commit.Score == 1.0 {
+		fmt.Printf("  solution for step %d passed\n", commit.Step)
+	} else {
+		fmt.Printf("  solution for step %d failed\n", commit.Step)
+		if commit.ReportCard != nil {
+			fmt.Printf("  ReportCard: %s\n", commit.ReportCard.Note)
+		}
+
+		// play the transcript
+		if err := commit.DumpTranscript(os.Stdout); err != nil {
+			log.Fatalf("failed to dump transcript: %v", err)
+		}
+	}
+	infof("this was only a check and your score has not changed; use '%s grade' to submit\n", os.Args[0])
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"os"
+	"runtime"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// Each login makes a new key pair. The public key is registered with the
+// server and grind signs every commit it submits with the private key, which
+// is kept in the config file. Courses can require signed commits, e.g., for
+// exams, so that work cannot be submitted by other programs using a
+// student's session.
+
+// registerClientKey makes a key pair for this login and registers it.
+// Older servers do not accept keys, and commits are left unsigned.
+func registerClientKey() {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("error generating a key to sign your work: %v", err)
+	}
+	name := runtime.GOOS + "/" + runtime.GOARCH
+	if host := clientInfo(nil).HostHash; host != "" {
+		name += " host " + host
+	}
+	request := &ClientKey{
+		PublicKey: base64.StdEncoding.EncodeToString(public),
+		Name:      name,
+	}
+	key := new(ClientKey)
+	if !doRequest("/users/me/keys", nil, "POST", request, key, true) {
+		debugf("the server does not accept client keys, so commits will not be signed")
+		Config.ClientKey, Config.ClientKeyID = "", 0
+		return
+	}
+	Config.ClientKey = base64.StdEncoding.EncodeToString(private.Seed())
+	Config.ClientKeyID = key.ID
+}
+
+// signCommitBundle signs the commit in an unsigned bundle with the key
+// registered at login, if there is one.
+func signCommitBundle(bundle *CommitBundle) {
+	if Config.ClientKey == "" || Config.ClientKeyID == 0 {
+		return
+	}
+	seed, err := base64.StdEncoding.DecodeString(Config.ClientKey)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		log.Printf("the key grind uses to sign your work is corrupt, so this commit will not be signed")
+		log.Printf("   run '%s login' again to make a new one", os.Args[0])
+		return
+	}
+	private := ed25519.NewKeyFromSeed(seed)
+	bundle.ClientKeyID = Config.ClientKeyID
+	bundle.ClientSignature = base64.StdEncoding.EncodeToString(ed25519.Sign(private, bundle.Commit.ClientSigningData(bundle.UserID)))
+}
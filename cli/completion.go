@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandCompletion(cmd *cobra.Command, args []string) {
+	root := cmd.Root()
+	var err error
+	switch args[0] {
+	case "bash":
+		err = root.GenBashCompletion(os.Stdout)
+	case "zsh":
+		err = root.GenZshCompletion(os.Stdout)
+	case "fish":
+		err = root.GenFishCompletion(os.Stdout, true)
+	case "powershell":
+		err = root.GenPowerShellCompletion(os.Stdout)
+	}
+	if err != nil {
+		log.Fatalf("error generating %s completion: %v", args[0], err)
+	}
+}
+
+// Dynamic completions only use responses already saved in the cache, so
+// pressing tab never waits on the network or prints an error.
+
+// cachedObject loads the saved response for a GET request, if any.
+func cachedObject(path string, download interface{}) bool {
+	cached := loadCachedResponse(fmt.Sprintf("https://%s%s%s", Config.Host, urlPrefix, path))
+	return cached != nil && json.Unmarshal(cached.Body, download) == nil
+}
+
+// loadConfigQuietly loads the config file, reporting whether it worked.
+func loadConfigQuietly() bool {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return false
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(home, perUserDotFile))
+	if err != nil || json.Unmarshal(raw, &Config) != nil {
+		return false
+	}
+	return !Config.Keyring || loadSessionCookie() == nil
+}
+
+// completeAssignments offers the assignments last listed, by ID and
+// by course and problem set identifier.
+func completeAssignments(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 || !loadConfigQuietly() {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	user := new(User)
+	assignments := []*Assignment{}
+	if !cachedObject("/users/me", user) || !cachedObject(fmt.Sprintf("/users/%d/assignments", user.ID), &assignments) {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var completions []string
+	for _, asst := range assignments {
+		completions = append(completions, strconv.FormatInt(asst.ID, 10)+"\t"+asst.CanvasTitle)
+		course, problemSet := new(Course), new(ProblemSet)
+		if asst.ProblemSetID > 0 &&
+			cachedObject(fmt.Sprintf("/courses/%d", asst.CourseID), course) &&
+			cachedObject(fmt.Sprintf("/problem_sets/%d", asst.ProblemSetID), problemSet) {
+			completions = append(completions, courseDirectory(course.Label)+"/"+problemSet.Unique+"\t"+asst.CanvasTitle)
+		}
+	}
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeActions offers the actions of the problem in the current directory.
+func completeActions(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 || !loadConfigQuietly() || !inProblem(".") {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	_, info, _ := findProblemInfo(".")
+	step := new(ProblemStep)
+	problemType := new(ProblemType)
+	if !cachedObject(fmt.Sprintf("/problems/%d/steps/%d", info.StepsID(), info.Step), step) ||
+		!cachedObject(fmt.Sprintf("/problem_types/%s", step.ProblemType), problemType) {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var completions []string
+	for name, action := range problemType.Actions {
+		if name != "grade" {
+			completions = append(completions, name+"\t"+action.Message)
+		}
+	}
+	sort.Strings(completions)
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
@@ -20,11 +20,12 @@ const ProblemConfigName string = "problem.cfg"
 
 type ConfigFile struct {
 	Problem struct {
-		Unique string
-		Note   string
-		Type   string
-		Tag    []string
-		Option []string
+		Unique  string
+		Note    string
+		Type    string
+		Tag     []string
+		Option  []string
+		Variant []string
 	}
 	Step map[string]*struct {
 		Note   string
@@ -42,7 +43,7 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 		pset = args[0]
 	} else if len(args) != 0 {
 		cmd.Help()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	action := cmd.Flag("action").Value.String()
@@ -176,7 +177,10 @@ func CommandCreate(cmd *cobra.Command, args []string) {
 	}
 }
 
-func findProblemCfg(now time.Time, startDir string) (string, string, int, *Problem, []*ProblemStep, bool) {
+// findProblemDir searches startDir and its ancestors for problem.cfg,
+// returning the problem directory and the step directory below it.
+// The problem directory is empty if no problem.cfg is found.
+func findProblemDir(startDir string) (string, string) {
 	// find the absolute directory so we can walk up the tree if needed
 	directory, err := filepath.Abs(startDir)
 	if err != nil {
@@ -184,12 +188,12 @@ func findProblemCfg(now time.Time, startDir string) (string, string, int, *Probl
 	}
 
 	// find the problem.cfg file
-	stepDir, stepN := directory, 0
+	stepDir := directory
 	for {
 		path := filepath.Join(directory, ProblemConfigName)
 		_, err := os.Stat(path)
 		if err == nil {
-			break
+			return directory, stepDir
 		}
 		if !os.IsNotExist(err) {
 			log.Fatalf("error searching for %s in %s: %v", ProblemConfigName, directory, err)
@@ -199,15 +203,23 @@ func findProblemCfg(now time.Time, startDir string) (string, string, int, *Probl
 		stepDir = directory
 		directory = filepath.Dir(directory)
 		if directory == stepDir {
-			return "", "", 0, nil, nil, false
+			return "", ""
 		}
 	}
+}
+
+func findProblemCfg(now time.Time, startDir string) (string, string, int, *Problem, []*ProblemStep, bool) {
+	directory, stepDir := findProblemDir(startDir)
+	if directory == "" {
+		return "", "", 0, nil, nil, false
+	}
+	stepN := 0
 
 	// parse problem.cfg to create the problem object
 	var cfg ConfigFile
 	configPath := filepath.Join(directory, ProblemConfigName)
 	fmt.Printf("reading %s\n", configPath)
-	if err = gcfg.ReadFileInto(&cfg, configPath); err != nil {
+	if err := gcfg.ReadFileInto(&cfg, configPath); err != nil {
 		log.Fatalf("failed to parse %s: %v", configPath, err)
 	}
 	problem := &Problem{
@@ -215,6 +227,7 @@ func findProblemCfg(now time.Time, startDir string) (string, string, int, *Probl
 		Note:      cfg.Problem.Note,
 		Tags:      cfg.Problem.Tag,
 		Options:   cfg.Problem.Option,
+		Variants:  cfg.Problem.Variant,
 		CreatedAt: now,
 		UpdatedAt: now,
 	}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandDiff(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+
+	dotfile, info, problemDir := findProblemInfo(".")
+	step := new(ProblemStep)
+	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", info.StepsID(), info.Step), nil, step)
+
+	// the student's files as they are now, saved or not
+	after := make(map[string][]byte)
+	for name := range step.Whitelist {
+		contents, err := ioutil.ReadFile(filepath.Join(problemDir, filepath.FromSlash(name)))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Fatalf("error reading %s: %v", name, err)
+			}
+			continue
+		}
+		after[name] = contents
+	}
+
+	before := make(map[string][]byte)
+	against := "the starter files for step " + strconv.FormatInt(info.Step, 10)
+	if idString := cmd.Flag("commit").Value.String(); idString != "" {
+		id, err := strconv.ParseInt(idString, 10, 64)
+		if err != nil || id < 1 {
+			log.Fatalf("commit ID must be a positive number: %q", idString)
+		}
+		commit := new(Commit)
+		mustGetObject(fmt.Sprintf("/commit_history/%d", id), nil, commit)
+		if commit.AssignmentID != dotfile.AssignmentID || commit.ProblemID != info.ID {
+			log.Fatalf("commit %d is not part of this problem; run '%s history' to see a list", id, os.Args[0])
+		}
+		for name, contents := range commit.Files {
+			before[name] = contents
+		}
+		against = fmt.Sprintf("commit %d (step %d)", id, commit.Step)
+	} else {
+		for name := range step.Whitelist {
+			if contents, exists := step.Files[name]; exists {
+				before[name] = contents
+			}
+		}
+	}
+
+	diffs := DiffFiles(before, after)
+	if len(diffs) == 0 {
+		fmt.Printf("no changes from %s\n", against)
+		return
+	}
+	fmt.Printf("changes from %s:\n\n", against)
+	for _, diff := range diffs {
+		fmt.Print(diff.Diff)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/blang/semver"
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// doctor collects the results of each check so a summary can be given.
+type doctor struct {
+	problems int
+}
+
+func (d *doctor) ok(format string, args ...interface{}) {
+	fmt.Printf("ok    "+format+"\n", args...)
+}
+
+func (d *doctor) warn(fix string, format string, args ...interface{}) {
+	fmt.Printf("warn  "+format+"\n", args...)
+	fmt.Printf("      fix: %s\n", fix)
+}
+
+func (d *doctor) fail(fix string, format string, args ...interface{}) {
+	d.problems++
+	fmt.Printf("FAIL  "+format+"\n", args...)
+	fmt.Printf("      fix: %s\n", fix)
+}
+
+func CommandDoctor(cmd *cobra.Command, args []string) {
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	d := new(doctor)
+	fmt.Printf("grind %s on %s/%s\n", CurrentVersion.Version, runtime.GOOS, runtime.GOARCH)
+
+	if d.checkConfig() && d.checkServer() && d.checkSession() {
+		d.checkProblemSet(".")
+	}
+
+	if d.problems == 0 {
+		fmt.Println("no problems found")
+		return
+	}
+	fmt.Printf("found %d problem%s\n", d.problems, plural(d.problems))
+	os.Exit(1)
+}
+
+func (d *doctor) checkConfig() bool {
+	login := fmt.Sprintf("run '%s login' as described in any assignment in Canvas", os.Args[0])
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		d.fail("set the HOME environment variable", "unable to find your home directory")
+		return false
+	}
+	configFile := filepath.Join(home, perUserDotFile)
+	raw, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		d.fail(login, "unable to read %s: %v", configFile, err)
+		return false
+	}
+	if err := json.Unmarshal(raw, &Config); err != nil {
+		d.fail("delete the file and "+login, "unable to parse %s: %v", configFile, err)
+		return false
+	}
+	if Config.Host == "" {
+		d.fail(login, "no server is listed in %s", configFile)
+		return false
+	}
+	if Config.Keyring {
+		if err := loadSessionCookie(); err != nil {
+			d.fail(fmt.Sprintf("run '%s login' again, adding --no-keyring if this machine has no keyring", os.Args[0]),
+				"unable to read your session from the system keyring: %v", err)
+			return false
+		}
+		d.ok("config file %s (session kept in the system keyring)", configFile)
+	} else {
+		d.ok("config file %s", configFile)
+	}
+	if Config.Cookie == "" {
+		d.fail(login, "you are not logged in")
+		return false
+	}
+	return true
+}
+
+func (d *doctor) checkServer() bool {
+	server := new(Version)
+	start := time.Now()
+	if _, err := tryGetObject("/version", server); err != nil {
+		d.fail(fmt.Sprintf("check your internet connection and that %s is the right server", Config.Host),
+			"unable to reach %s: %v", Config.Host, err)
+		return false
+	}
+	d.ok("connected to %s in %v", Config.Host, time.Since(start).Round(time.Millisecond))
+
+	current := semver.MustParse(CurrentVersion.Version)
+	required, errRequired := semver.Parse(server.GrindVersionRequired)
+	recommended, errRecommended := semver.Parse(server.GrindVersionRecommended)
+	upgrade := fmt.Sprintf("run '%s upgrade'", os.Args[0])
+	switch {
+	case errRequired != nil || errRecommended != nil:
+		d.warn("ask your instructor to check the server configuration", "the server reported an unrecognized version")
+	case required.GT(current):
+		d.fail(upgrade, "this is grind %s, but the server requires %s or higher", CurrentVersion.Version, server.GrindVersionRequired)
+	case recommended.GT(current):
+		d.warn(upgrade, "this is grind %s, but the server recommends %s or higher", CurrentVersion.Version, server.GrindVersionRecommended)
+	default:
+		d.ok("grind %s is compatible with server version %s", CurrentVersion.Version, server.Version)
+	}
+	return true
+}
+
+func (d *doctor) checkSession() bool {
+	user := new(User)
+	status, err := tryGetObject("/users/me", user)
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		d.fail(fmt.Sprintf("run '%s login' again", os.Args[0]), "your session has expired")
+		return false
+	} else if err != nil {
+		d.fail("try again in a few minutes, and ask your instructor if this continues", "unable to check your session: %v", err)
+		return false
+	}
+	d.ok("logged in as %s <%s>", user.Name, user.Email)
+	return true
+}
+
+func (d *doctor) checkProblemSet(dir string) {
+	if !inProblemSet(dir) {
+		fmt.Println("      not in a problem directory, so no assignment checks were done")
+		return
+	}
+	fix := fmt.Sprintf("move this directory aside and run '%s get' again", os.Args[0])
+	dotfile, problemSetDir := d.findDotFile(dir)
+	if dotfile == nil {
+		return
+	}
+	if dotfile.AssignmentID < 1 || len(dotfile.Problems) == 0 {
+		d.fail(fix, "%s does not describe an assignment", dotfile.Path)
+		return
+	}
+	assignment := new(Assignment)
+	if _, err := tryGetObject(fmt.Sprintf("/assignments/%d", dotfile.AssignmentID), assignment); err != nil {
+		d.fail("make sure you are logged in as the student this assignment belongs to", "unable to load assignment %d: %v", dotfile.AssignmentID, err)
+		return
+	}
+	d.ok("assignment %s", assignment.CanvasTitle)
+
+	for unique, info := range dotfile.Problems {
+		problemDir := problemSetDir
+		if len(dotfile.Problems) > 1 {
+			problemDir = filepath.Join(problemSetDir, unique)
+		}
+		step := new(ProblemStep)
+		if _, err := tryGetObject(fmt.Sprintf("/problems/%d/steps/%d", info.StepsID(), info.Step), step); err != nil {
+			d.fail(fix, "unable to load step %d of problem %s: %v", info.Step, unique, err)
+			continue
+		}
+		bad := 0
+		for name := range step.Whitelist {
+			path := filepath.Join(problemDir, filepath.FromSlash(name))
+			stat, err := os.Stat(path)
+			switch {
+			case os.IsNotExist(err):
+				bad++
+				d.fail(fmt.Sprintf("restore it with '%s reset %s'", os.Args[0], name), "problem %s is missing %s", unique, name)
+			case err != nil:
+				bad++
+				d.fail("check the permissions of the file", "unable to check %s: %v", path, err)
+			case stat.IsDir():
+				bad++
+				d.fail("rename the directory so the file can be restored", "%s should be a file but is a directory", path)
+			case stat.Mode().Perm()&0200 == 0:
+				d.warn(fmt.Sprintf("run '%s step %d' to make your files editable again", os.Args[0], info.Step), "%s is read-only", path)
+			}
+		}
+		if bad == 0 {
+			d.ok("problem %s step %d has all %d of its files", unique, info.Step, len(step.Whitelist))
+		}
+	}
+}
+
+// findDotFile reads the .grind file, reporting problems instead of giving up.
+func (d *doctor) findDotFile(dir string) (*DotFileInfo, string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		d.fail("run this from a different directory", "unable to find the current directory: %v", err)
+		return nil, ""
+	}
+	problemSetDir := abs
+	for {
+		if _, err := os.Stat(filepath.Join(problemSetDir, perProblemSetDotFile)); err == nil {
+			break
+		}
+		problemSetDir = filepath.Dir(problemSetDir)
+	}
+	path := filepath.Join(problemSetDir, perProblemSetDotFile)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		d.fail("check the permissions of the file", "unable to read %s: %v", path, err)
+		return nil, ""
+	}
+	dotfile := new(DotFileInfo)
+	if err := json.Unmarshal(raw, dotfile); err != nil {
+		d.fail(fmt.Sprintf("move this directory aside and run '%s get' again", os.Args[0]), "unable to parse %s: %v", path, err)
+		return nil, ""
+	}
+	dotfile.Path = path
+	return dotfile, problemSetDir
+}
@@ -19,7 +19,7 @@ func CommandExportQuizzes(cmd *cobra.Command, args []string) {
 
 	if len(args) == 0 {
 		cmd.Help()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	} else if len(args) > 1 {
 		log.Printf("you must specify the assignment with quizzes to export")
 		log.Printf("   run '%s list' to see your assignments", os.Args[0])
@@ -6,8 +6,10 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	. "github.com/russross/codegrinder/types"
@@ -25,7 +27,7 @@ func CommandGet(cmd *cobra.Command, args []string) {
 
 	if len(args) == 0 {
 		cmd.Help()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	} else if len(args) > 2 {
 		log.Printf("you must specify the assignment to download")
 		log.Printf("   run '%s list' to see your assignments", os.Args[0])
@@ -82,7 +84,14 @@ func CommandGet(cmd *cobra.Command, args []string) {
 	if assignment.UserID != user.ID {
 		log.Fatalf("you do not have an assignment with number %d", assignment.ID)
 	}
-	getAssignment(assignment, rootDir, prettyRoot)
+	changeTo := getAssignment(assignment, rootDir, prettyRoot)
+
+	if cmd.Flag("git").Value.String() == "true" {
+		dotfile, problemSetDir, _ := findDotFile(changeTo)
+		dotfile.Git = true
+		saveDotFile(dotfile)
+		gitInit(problemSetDir, user)
+	}
 }
 
 func getAssignment(assignment *Assignment, rootDir, prettyRoot string) string {
@@ -108,41 +117,74 @@ func getAssignment(assignment *Assignment, rootDir, prettyRoot string) string {
 	problemSetProblems := []*ProblemSetProblem{}
 	mustGetObject(fmt.Sprintf("/problem_sets/%d/problems", assignment.ProblemSetID), nil, &problemSetProblems)
 
-	// for each problem get the problem, the most recent commit (or create one), and the corresponding step
+	// get each problem and its most recent commit
+	list := make([]*Problem, len(problemSetProblems))
+	last := make([]*Commit, len(problemSetProblems))
+	fetchParallel("problems", len(problemSetProblems), func(i int) {
+		problem, commit := new(Problem), new(Commit)
+		mustGetObject(fmt.Sprintf("/problems/%d", problemSetProblems[i].ProblemID), nil, problem)
+		if !getObject(fmt.Sprintf("/assignments/%d/problems/%d/commits/last", assignment.ID, problem.ID), nil, commit) {
+			// if there is no commit for this problem, we're starting from step one
+			commit = nil
+		}
+		list[i], last[i] = problem, commit
+	})
+
+	// choosing a variant may prompt the student, so do it one problem at a time
 	commits := make(map[string]*Commit)
 	infos := make(map[string]*ProblemInfo)
 	problems := make(map[string]*Problem)
-	steps := make(map[string]*ProblemStep)
-	types := make(map[string]*ProblemType)
-	for _, elt := range problemSetProblems {
-		problem, commit, info, step := new(Problem), new(Commit), new(ProblemInfo), new(ProblemStep)
-		mustGetObject(fmt.Sprintf("/problems/%d", elt.ProblemID), nil, problem)
-		problems[problem.Unique] = problem
-
-		if getObject(fmt.Sprintf("/assignments/%d/problems/%d/commits/last", assignment.ID, problem.ID), nil, commit) {
-			info.ID = problem.ID
-			info.Step = commit.Step
-		} else {
-			// if there is no commit for this problem, we're starting from step one
-			commit = nil
-			info.ID = problem.ID
-			info.Step = 1
+	for i, problem := range list {
+		info := &ProblemInfo{ID: problem.ID, Step: 1}
+		if last[i] != nil {
+			info.Step = last[i].Step
 		}
 
-		mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, info.Step), nil, step)
+		// a multi-language problem uses the steps of the chosen variant
+		if len(problem.Variants) > 0 {
+			info.Variant = chooseVariant(assignment, problem)
+		}
+		problems[problem.Unique] = problem
+		commits[problem.Unique] = last[i]
 		infos[problem.Unique] = info
-		commits[problem.Unique] = commit
-		steps[problem.Unique] = step
+	}
 
-		// get the problem type if we do not already have it
-		if _, exists := types[step.ProblemType]; !exists {
-			problemType := new(ProblemType)
-			mustGetObject(fmt.Sprintf("/problem_types/%s", step.ProblemType), nil, problemType)
-			types[step.ProblemType] = problemType
+	// get the current step of each problem
+	stepList := make([]*ProblemStep, len(list))
+	fetchParallel("steps", len(list), func(i int) {
+		info, step := infos[list[i].Unique], new(ProblemStep)
+		mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", info.StepsID(), info.Step), nil, step)
+		stepList[i] = step
+	})
+	steps := make(map[string]*ProblemStep)
+	var typeNames []string
+	types := make(map[string]*ProblemType)
+	for i, problem := range list {
+		steps[problem.Unique] = stepList[i]
+		if _, exists := types[stepList[i].ProblemType]; !exists {
+			types[stepList[i].ProblemType] = nil
+			typeNames = append(typeNames, stepList[i].ProblemType)
 		}
 	}
 
-	fmt.Printf("unpacking problem set in %s\n", prettyRoot)
+	// get each problem type once
+	typeList := make([]*ProblemType, len(typeNames))
+	fetchParallel("problem types", len(typeNames), func(i int) {
+		typeList[i] = new(ProblemType)
+		mustGetObject(fmt.Sprintf("/problem_types/%s", typeNames[i]), nil, typeList[i])
+	})
+	for i, name := range typeNames {
+		types[name] = typeList[i]
+	}
+
+	// unpack into a staging directory and move it into place when finished,
+	// so an interrupted download never looks like a problem set
+	staging := rootDir + ".partial"
+	if _, err := os.Stat(staging); err == nil {
+		infof("resuming an interrupted download in %s\n", prettyRoot)
+	} else {
+		infof("unpacking problem set in %s\n", prettyRoot)
+	}
 
 	mostRecentTime := time.Time{}
 	changeTo := rootDir
@@ -150,17 +192,17 @@ func getAssignment(assignment *Assignment, rootDir, prettyRoot string) string {
 		commit, problem, step := commits[unique], problems[unique], steps[unique]
 
 		// if there is only one problem in the set, use the main directory
-		target := rootDir
+		target, final := staging, rootDir
 		if len(steps) > 1 {
-			target = filepath.Join(rootDir, unique)
+			target, final = filepath.Join(staging, unique), filepath.Join(rootDir, unique)
 
 			if step.Step > 1 {
-				fmt.Printf("unpacking problem %s step %d\n", unique, step.Step)
+				infof("unpacking problem %s step %d\n", unique, step.Step)
 			} else {
-				fmt.Printf("unpacking problem %s\n", unique)
+				infof("unpacking problem %s\n", unique)
 			}
 		} else if step.Step > 1 {
-			fmt.Printf("unpacking step %d\n", step.Step)
+			infof("unpacking step %d\n", step.Step)
 		}
 
 		// save the step files
@@ -169,6 +211,10 @@ func getAssignment(assignment *Assignment, rootDir, prettyRoot string) string {
 			files[filepath.FromSlash(name)] = contents
 		}
 		files[filepath.Join("doc", "index.html")] = []byte(step.Instructions)
+		if len(step.Assets) > 0 {
+			infof("note: large files for this step are only available on the grading server (%d file%s)\n",
+				len(step.Assets), plural(len(step.Assets)))
+		}
 
 		// step files may be overwritten by commit files
 		if commit != nil {
@@ -176,11 +222,17 @@ func getAssignment(assignment *Assignment, rootDir, prettyRoot string) string {
 				// when an instructor is downloading a student assignment,
 				// change to the directory for the problem with the most recent commit
 				mostRecentTime = commit.UpdatedAt
-				changeTo = target
+				changeTo = final
 			}
 			for name, contents := range commit.Files {
 				files[filepath.FromSlash(name)] = contents
+
+				// files the student added are submitted again
+				if !step.Whitelist[name] && !hasExtraFile(infos[unique], name) {
+					infos[unique].Extra = append(infos[unique].Extra, name)
+				}
 			}
+			sort.Strings(infos[unique].Extra)
 		}
 
 		// save problem type files
@@ -192,6 +244,8 @@ func getAssignment(assignment *Assignment, rootDir, prettyRoot string) string {
 		}
 
 		updateFiles(target, files, nil, false)
+		verifyFiles(target, files)
+		saveStarterFiles(target, step)
 
 		// does this commit indicate the step was finished and needs to advance?
 		if commit != nil && commit.ReportCard != nil && commit.ReportCard.Passed && commit.Score == 1.0 {
@@ -202,8 +256,97 @@ func getAssignment(assignment *Assignment, rootDir, prettyRoot string) string {
 	dotfile := &DotFileInfo{
 		AssignmentID: assignment.ID,
 		Problems:     infos,
-		Path:         filepath.Join(rootDir, perProblemSetDotFile),
+		Path:         filepath.Join(staging, perProblemSetDotFile),
 	}
 	saveDotFile(dotfile)
+	if err := os.Rename(staging, rootDir); err != nil {
+		log.Fatalf("error moving %s into place: %v", staging, err)
+	}
 	return changeTo
 }
+
+// chooseVariant returns the ID of the variant of a multi-language problem
+// to use in an assignment, asking the student to choose one the first
+// time the problem is downloaded. It returns zero for the main problem.
+// maxFetchers limits how many requests are in flight at once.
+const maxFetchers = 8
+
+// fetchParallel calls fetch for each index from 0 to n-1 using a
+// bounded pool of workers, reporting progress as each one finishes.
+func fetchParallel(label string, n int, fetch func(i int)) {
+	jobs := make(chan int)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < maxFetchers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fetch(i)
+				done <- struct{}{}
+			}
+		}()
+	}
+	go func() {
+		for i := 0; i < n; i++ {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(done)
+	}()
+
+	finished := 0
+	for range done {
+		finished++
+		infof("\rdownloading %s: %d/%d", label, finished, n)
+	}
+	if n > 0 {
+		infof("\n")
+	}
+}
+
+func chooseVariant(assignment *Assignment, problem *Problem) int64 {
+	unique := assignment.Variants[problem.Unique]
+	if unique == "" {
+		user := new(User)
+		mustGetObject("/users/me", nil, user)
+		if assignment.UserID != user.ID {
+			// the student has not chosen yet
+			return 0
+		}
+
+		// describe each variant by the problem type of its first step
+		choices := append([]string{problem.Unique}, problem.Variants...)
+		fmt.Printf("problem %s is available in %d languages:\n", problem.Unique, len(choices))
+		for i, elt := range choices {
+			variant := findVariant(elt)
+			step := new(ProblemStep)
+			mustGetObject(fmt.Sprintf("/problems/%d/steps/1", variant.ID), nil, step)
+			fmt.Printf("  %d) %s: %s\n", i+1, step.ProblemType, variant.Note)
+		}
+		for unique == "" {
+			answer := strings.TrimSpace(prompt(fmt.Sprintf("choose a language (1-%d): ", len(choices)), false))
+			if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(choices) {
+				unique = choices[n-1]
+			}
+		}
+		mustPutObject(fmt.Sprintf("/assignments/%d/problems/%d/variant", assignment.ID, problem.ID), nil,
+			&ProblemVariantChoice{Unique: unique}, assignment)
+	}
+	if unique == problem.Unique {
+		return 0
+	}
+	return findVariant(unique).ID
+}
+
+func findVariant(unique string) *Problem {
+	problems := []*Problem{}
+	params := make(url.Values)
+	params.Add("unique", unique)
+	mustGetObject("/problems", params, &problems)
+	if len(problems) != 1 {
+		log.Fatalf("unable to find problem %s", unique)
+	}
+	return problems[0]
+}
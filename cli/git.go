@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// A problem set downloaded with 'grind get --git' is also a git repository,
+// and every graded commit is recorded there with a tag giving the step and
+// score, so students have a local history of their work.
+
+// gitIgnore lists files that grind manages or generates and that do not
+// belong in the student's history.
+const gitIgnore = `# generated by grind
+.grind-starter/
+artifacts/
+__pycache__/
+*.pyc
+*.o
+`
+
+// runGit runs a git command in a directory, including its output in any error.
+func runGit(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %v\n%s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// gitIdentity gives the options needed to commit as the student
+// when git has not been told who the user is.
+func gitIdentity(dir string, user *User) []string {
+	cmd := exec.Command("git", "config", "user.email")
+	cmd.Dir = dir
+	if out, err := cmd.Output(); err == nil && len(strings.TrimSpace(string(out))) > 0 {
+		return nil
+	}
+	return []string{"-c", "user.name=" + user.Name, "-c", "user.email=" + user.Email}
+}
+
+// gitInit turns a newly downloaded problem set into a git repository.
+func gitInit(dir string, user *User) {
+	if _, err := exec.LookPath("git"); err != nil {
+		log.Fatalf("git must be installed to use --git")
+	}
+	if err := runGit(dir, "init", "-q"); err != nil {
+		log.Fatalf("error creating git repository: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".gitignore"), []byte(gitIgnore), 0644); err != nil {
+		log.Fatalf("error saving .gitignore: %v", err)
+	}
+	gitRecord(dir, user, "grind get", "")
+	fmt.Printf("created a git repository in %s\n", dir)
+}
+
+// gitRecord commits everything in the problem set, tagging the commit if
+// a tag is given. Failures are reported but are not fatal, since the
+// work has already been saved on the server.
+func gitRecord(dir string, user *User, message, tag string) {
+	if err := runGit(dir, "add", "-A"); err != nil {
+		log.Printf("error recording your work in git: %v", err)
+		return
+	}
+	args := append(gitIdentity(dir, user), "commit", "-q", "--allow-empty", "-m", message)
+	if err := runGit(dir, args...); err != nil {
+		log.Printf("error recording your work in git: %v", err)
+		return
+	}
+	if tag != "" {
+		args := append(gitIdentity(dir, user), "tag", "-a", tag, "-m", message)
+		if err := runGit(dir, args...); err != nil {
+			log.Printf("error tagging your work in git: %v", err)
+		}
+	}
+}
+
+// gitRecordGrade records a graded commit if the problem set uses git.
+func gitRecordGrade(dotfile *DotFileInfo, user *User, problem *Problem, commit *Commit) {
+	if !dotfile.Git {
+		return
+	}
+	percent := int(commit.Score*100 + 0.5)
+	message := fmt.Sprintf("grade %s step %d: %d%%", problem.Unique, commit.Step, percent)
+	tag := fmt.Sprintf("%s/step%d-%dpct-%d", problem.Unique, commit.Step, percent, commit.ID)
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dotfile.Path), ".git")); err != nil {
+		log.Printf("this problem set was downloaded with --git, but its git repository is missing")
+		return
+	}
+	gitRecord(filepath.Dir(dotfile.Path), user, message, tag)
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	. "github.com/russross/codegrinder/types"
@@ -14,24 +17,169 @@ func CommandGrade(cmd *cobra.Command, args []string) {
 	mustLoadConfig(cmd)
 	now := time.Now()
 
-	if len(args) != 0 {
+	if len(args) != 0 && len(args) != 2 {
 		cmd.Help()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	// get the user ID
 	user := new(User)
 	mustGetObject("/users/me", nil, user)
 
-	_, problem, _, commit, dotfile, _ := gatherStudent(now, ".")
+	if len(args) == 2 {
+		commandGradeFromServer(cmd, args, user, now)
+		return
+	}
+	if cmd.Flag("step").Value.String() != "0" {
+		log.Fatalf("--step can only be used when the assignment and problem are given")
+	}
+
+	practice := cmd.Flag("practice").Value.String() == "true"
+
+	_, problem, _, commit, dotfile, problemDir := gatherStudent(now, ".")
+	warnUntrackedFiles(problemDir, dotfile.Problems[problem.Unique])
 	commit.Action = "grade"
 	commit.Note = "grind grade"
+	commit.Message = strings.TrimSpace(cmd.Flag("message").Value.String())
+	if practice {
+		infof("grading %s step %d as practice\n", problem.Unique, commit.Step)
+		commit = mustGradeCommit(user, commit, true)
+		printWarnings(commit)
+		if commit.ReportCard != nil && commit.ReportCard.Passed && commit.Score == 1.0 {
+			fmt.Printf("  solution for step %d passed\n", commit.Step)
+		} else {
+			printFailure(commit)
+		}
+		infof("this was practice and your score has not changed\n")
+		return
+	}
+	infof("submitting %s step %d for grading\n", problem.Unique, commit.Step)
+	commit = mustGradeCommit(user, commit, false)
+	gradedAt := time.Now()
+	dotfile.Problems[problem.Unique].GradedAt = &gradedAt
+	saveDotFile(dotfile)
+
+	// save any output files the daycare kept with the commit
+	if len(commit.Artifacts) > 0 {
+		artifacts := make(map[string][]byte)
+		for name, contents := range commit.Artifacts {
+			clean := filepath.Clean(filepath.FromSlash(name))
+			if filepath.IsAbs(clean) || strings.HasPrefix(clean, "..") {
+				log.Printf("skipping artifact with bad name %q", name)
+				continue
+			}
+			artifacts[filepath.Join("artifacts", clean)] = contents
+		}
+		updateFiles(".", artifacts, nil, true)
+	}
+
+	printWarnings(commit)
+
+	gitRecordGrade(dotfile, user, problem, commit)
+
+	if commit.ReportCard != nil && commit.ReportCard.Passed && commit.Score == 1.0 {
+		if nextStep(".", dotfile.Problems[problem.Unique], problem, commit, make(map[string]*ProblemType)) {
+			// save the updated dotfile with new step number
+			saveDotFile(dotfile)
+		}
+	} else {
+		printFailure(commit)
+	}
+}
+
+// commandGradeFromServer grades the most recently saved work on a problem
+// without a local copy, using the files the server has for it.
+func commandGradeFromServer(cmd *cobra.Command, args []string, user *User, now time.Time) {
+	assignmentID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || assignmentID < 1 {
+		log.Printf("unknown assignment %q", args[0])
+		log.Fatalf("   give the assignment number displayed on the left by '%s list'", os.Args[0])
+	}
+	assignment := new(Assignment)
+	mustGetObject(fmt.Sprintf("/assignments/%d", assignmentID), nil, assignment)
+	if assignment.ProblemSetID < 1 {
+		log.Fatalf("assignment %d is a quiz and cannot be graded", assignment.ID)
+	}
+
+	// find the problem in the problem set
+	problemSetProblems := []*ProblemSetProblem{}
+	mustGetObject(fmt.Sprintf("/problem_sets/%d/problems", assignment.ProblemSetID), nil, &problemSetProblems)
+	var problem *Problem
+	for _, psp := range problemSetProblems {
+		elt := new(Problem)
+		mustGetObject(fmt.Sprintf("/problems/%d", psp.ProblemID), nil, elt)
+		if elt.Unique == args[1] || strconv.FormatInt(elt.ID, 10) == args[1] {
+			problem = elt
+			break
+		}
+	}
+	if problem == nil {
+		log.Fatalf("problem %s is not part of assignment %d", args[1], assignment.ID)
+	}
+
+	// get the work saved on the server
+	last := new(Commit)
+	path := fmt.Sprintf("/assignments/%d/problems/%d/commits/last", assignment.ID, problem.ID)
+	if step, err := strconv.ParseInt(cmd.Flag("step").Value.String(), 10, 64); err == nil && step > 0 {
+		path = fmt.Sprintf("/assignments/%d/problems/%d/steps/%d/commits/last", assignment.ID, problem.ID, step)
+	}
+	if !getObject(path, nil, last) {
+		log.Printf("no saved work found for problem %s in assignment %d", problem.Unique, assignment.ID)
+		log.Fatalf("   work must be saved with '%s save' or '%s grade' before it can be graded from here", os.Args[0], os.Args[0])
+	}
+	commit := &Commit{
+		AssignmentID: assignment.ID,
+		ProblemID:    problem.ID,
+		Step:         last.Step,
+		Action:       "grade",
+		Note:         "grind grade from server files",
+		Message:      strings.TrimSpace(cmd.Flag("message").Value.String()),
+		Files:        last.Files,
+		ActiveTime:   last.ActiveTime,
+		Client:       clientInfo(nil),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	practice := cmd.Flag("practice").Value.String() == "true"
+	if practice {
+		commit.Note = "grind grade practice from server files"
+	}
+	infof("submitting %s step %d for grading using the files saved %s\n",
+		problem.Unique, commit.Step, last.UpdatedAt.Local().Format("Jan 2 15:04"))
+	commit = mustGradeCommit(user, commit, practice)
+	printWarnings(commit)
+	if practice {
+		if commit.ReportCard != nil && commit.ReportCard.Passed && commit.Score == 1.0 {
+			fmt.Printf("step %d passed\n", commit.Step)
+		} else {
+			printFailure(commit)
+		}
+		infof("this was practice and the score has not changed\n")
+		return
+	}
+
+	if commit.ReportCard != nil && commit.ReportCard.Passed && commit.Score == 1.0 {
+		fmt.Printf("step %d passed\n", commit.Step)
+		if assignment.UserID == user.ID {
+			infof("the next step will be ready when you next run '%s get' or '%s step'\n", os.Args[0], os.Args[0])
+		}
+	} else {
+		printFailure(commit)
+	}
+}
+
+// mustGradeCommit signs a commit, has a daycare grade it, and saves the
+// result with the server, returning the saved commit.
+// A practice commit is recorded apart from the student's work and not scored.
+func mustGradeCommit(user *User, commit *Commit, practice bool) *Commit {
 	unsigned := &CommitBundle{
-		UserID: user.ID,
-		Commit: commit,
+		UserID:   user.ID,
+		Commit:   deltaCommit(commit),
+		Practice: practice,
 	}
 
 	// send the commit bundle to the server
+	signCommitBundle(unsigned)
 	signed := new(CommitBundle)
 	mustPostObject("/commit_bundles/unsigned", nil, unsigned, signed)
 
@@ -39,35 +187,45 @@ func CommandGrade(cmd *cobra.Command, args []string) {
 	if signed.Hostname == "" {
 		log.Fatalf("server was unable to find a suitable daycare, unable to grade")
 	}
-	fmt.Printf("submitting %s step %d for grading\n", problem.Unique, commit.Step)
 	graded := mustConfirmCommitBundle(signed, nil)
 
 	// save the commit with report card
 	toSave := &CommitBundle{
 		Hostname:        graded.Hostname,
 		UserID:          graded.UserID,
-		Commit:          graded.Commit,
+		Commit:          deltaCommit(graded.Commit),
 		CommitSignature: graded.CommitSignature,
+		Practice:        graded.Practice,
 	}
 	saved := new(CommitBundle)
 	mustPostObject("/commit_bundles/signed", nil, toSave, saved)
 	commit = saved.Commit
-
-	if commit.ReportCard != nil && commit.ReportCard.Passed && commit.Score == 1.0 {
-		if nextStep(".", dotfile.Problems[problem.Unique], problem, commit, make(map[string]*ProblemType)) {
-			// save the updated dotfile with new step number
-			saveDotFile(dotfile)
-		}
-	} else {
-		// solution failed
-		fmt.Printf("  solution for step %d failed\n", commit.Step)
-		if commit.ReportCard != nil {
-			fmt.Printf("  ReportCard: %s\n", commit.ReportCard.Note)
+	if commit.ReportCard != nil {
+		if usage := commit.ReportCard.Usage(); usage != "" {
+			infof("  resources used: %s\n", usage)
 		}
+	}
+	return commit
+}
 
-		// play the transcript
-		if err := commit.DumpTranscript(os.Stdout); err != nil {
-			log.Fatalf("failed to dump transcript: %v", err)
+func printWarnings(commit *Commit) {
+	if warnings := commit.Warnings(); len(warnings) > 0 {
+		fmt.Printf("  %d compiler warning%s:\n", len(warnings), plural(len(warnings)))
+		for _, warning := range warnings {
+			fmt.Printf("    %s\n", warning)
 		}
 	}
 }
+
+func printFailure(commit *Commit) {
+	// solution failed
+	fmt.Printf("  solution for step %d failed\n", commit.Step)
+	if commit.ReportCard != nil {
+		fmt.Printf("  ReportCard: %s\n", commit.ReportCard.Note)
+	}
+
+	// play the transcript
+	if err := commit.DumpTranscript(os.Stdout); err != nil {
+		log.Fatalf("failed to dump transcript: %v", err)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,6 +11,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -20,12 +26,12 @@ func nextStep(directory string, info *ProblemInfo, problem *Problem, commit *Com
 
 	// advance to the next step
 	oldStep, newStep := new(ProblemStep), new(ProblemStep)
-	if !getObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, commit.Step+1), nil, newStep) {
-		fmt.Println("you have completed all steps for this problem")
+	if !getObject(fmt.Sprintf("/problems/%d/steps/%d", info.StepsID(), commit.Step+1), nil, newStep) {
+		infof("you have completed all steps for this problem\n")
 		return false
 	}
-	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, commit.Step), nil, oldStep)
-	fmt.Printf("moving to step %d\n", newStep.Step)
+	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", info.StepsID(), commit.Step), nil, oldStep)
+	infof("moving to step %d\n", newStep.Step)
 
 	if _, exists := types[oldStep.ProblemType]; !exists {
 		problemType := new(ProblemType)
@@ -68,6 +74,7 @@ func nextStep(directory string, info *ProblemInfo, problem *Problem, commit *Com
 	}
 
 	updateFiles(directory, files, oldFiles, false)
+	saveStarterFiles(directory, newStep)
 
 	info.Step++
 	return true
@@ -79,7 +86,7 @@ func updateFiles(directory string, files map[string][]byte, oldFiles map[string]
 		ondisk, err := ioutil.ReadFile(path)
 		if err != nil && os.IsNotExist(err) {
 			if chatty {
-				fmt.Printf("saving file:   %s\n", name)
+				infof("saving file:   %s\n", name)
 			}
 			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 				log.Fatalf("error creating directory %s: %v", filepath.Dir(path), err)
@@ -91,7 +98,7 @@ func updateFiles(directory string, files map[string][]byte, oldFiles map[string]
 			log.Fatalf("error reading %s: %v", name, err)
 		} else if !bytes.Equal(ondisk, contents) {
 			if chatty {
-				fmt.Printf("updating file: %s\n", name)
+				infof("updating file: %s\n", name)
 			}
 			if err := ioutil.WriteFile(path, contents, 0644); err != nil {
 				log.Fatalf("error saving %s: %v", name, err)
@@ -109,7 +116,7 @@ func updateFiles(directory string, files map[string][]byte, oldFiles map[string]
 		path := filepath.Join(directory, name)
 		if _, err := os.Stat(path); err == nil {
 			if chatty {
-				fmt.Printf("removing file: %s\n", name)
+				infof("removing file: %s\n", name)
 			}
 			if err := os.Remove(path); err != nil {
 				log.Fatalf("error deleting %s: %v", name, err)
@@ -126,38 +133,18 @@ func updateFiles(directory string, files map[string][]byte, oldFiles map[string]
 
 func gatherStudent(now time.Time, startDir string) (*ProblemType, *Problem, *Assignment, *Commit, *DotFileInfo, string) {
 	// find the .grind file containing the problem set info
-	dotfile, problemSetDir, problemDir := findDotFile(startDir)
+	dotfile, info, problemDir := findProblemInfo(startDir)
 
 	// get the assignment
 	assignment := new(Assignment)
 	mustGetObject(fmt.Sprintf("/assignments/%d", dotfile.AssignmentID), nil, assignment)
 
 	// get the problem
-	unique := ""
-	if len(dotfile.Problems) == 1 {
-		// only one problem? files should be in dotfile directory
-		for u := range dotfile.Problems {
-			unique = u
-		}
-		problemDir = problemSetDir
-	} else {
-		// use the subdirectory name to identify the problem
-		if problemDir == "" {
-			log.Printf("you must identify the problem within this problem set")
-			log.Printf("  either run this from with the problem directory, or")
-			log.Fatalf("  identify it as a parameter in the command")
-		}
-		_, unique = filepath.Split(problemDir)
-	}
-	info := dotfile.Problems[unique]
-	if info == nil {
-		log.Fatalf("unable to recognize the problem based on the directory name of %q", unique)
-	}
 	problem := new(Problem)
 	mustGetObject(fmt.Sprintf("/problems/%d", info.ID), nil, problem)
 
 	step := new(ProblemStep)
-	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, info.Step), nil, step)
+	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", info.StepsID(), info.Step), nil, step)
 
 	problemType := new(ProblemType)
 	mustGetObject(fmt.Sprintf("/problem_types/%s", step.ProblemType), nil, problemType)
@@ -177,9 +164,20 @@ func gatherStudent(now time.Time, startDir string) (*ProblemType, *Problem, *Ass
 	updateFiles(problemDir, stepFiles, nil, true)
 
 	// gather the commit files from the file system
+	norm, err := problem.FileNormalization()
+	if err != nil {
+		log.Fatalf("error in problem %s: %v", problem.Unique, err)
+	}
 	files := make(map[string][]byte)
 	var missing []string
+	names := make(map[string]bool)
 	for name := range step.Whitelist {
+		names[name] = true
+	}
+	for _, name := range info.Extra {
+		names[name] = true
+	}
+	for name := range names {
 		path := filepath.Join(problemDir, filepath.FromSlash(name))
 		contents, err := ioutil.ReadFile(path)
 		if err != nil {
@@ -187,12 +185,24 @@ func gatherStudent(now time.Time, startDir string) (*ProblemType, *Problem, *Ass
 			missing = append(missing, name)
 			continue
 		}
+		if !step.Binary[name] {
+			if fixed := norm.Apply(contents); !bytes.Equal(fixed, contents) {
+				infof("normalized line endings and encoding of %s\n", name)
+				contents = fixed
+			}
+		}
 		files[name] = contents
+		verbosef("including %s (%d bytes)\n", name, len(contents))
 	}
 	if len(missing) > 0 {
 		log.Print("did not find all the expected files")
+		sort.Strings(missing)
 		for _, name := range missing {
-			log.Printf("  %s not found", name)
+			if step.Whitelist[name] {
+				log.Printf("  %s not found", name)
+			} else {
+				log.Printf("  %s not found (use '%s rm %s' if you no longer need it)", name, os.Args[0], name)
+			}
 		}
 		log.Fatalf("all expected files must be present")
 	}
@@ -204,13 +214,143 @@ func gatherStudent(now time.Time, startDir string) (*ProblemType, *Problem, *Ass
 		ProblemID:    info.ID,
 		Step:         info.Step,
 		Files:        files,
+		Client:       clientInfo(modifiedSince(problemDir, files, info.GradedAt)),
 		CreatedAt:    now,
 		UpdatedAt:    now,
 	}
+	if Config.TrackTime[assignment.CourseID] {
+		trackActivity(info, problemDir, step.Whitelist, now)
+		saveDotFile(dotfile)
+		commit.ActiveTime = info.ActiveTime[info.Step]
+	}
 
 	return problemType, problem, assignment, commit, dotfile, problemDir
 }
 
+// clientInfo describes this copy of grind for a commit.
+func clientInfo(modified *bool) *CommitClient {
+	client := &CommitClient{
+		Version:  CurrentVersion.Version,
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+		Modified: modified,
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		// only a hash is sent, so the name itself is not revealed
+		sum := sha256.Sum256([]byte("codegrinder host:" + strings.ToLower(host)))
+		client.HostHash = hex.EncodeToString(sum[:8])
+	}
+	return client
+}
+
+// modifiedSince reports whether any of the files were changed after the
+// given time, or nil if the step has not been graded from this directory.
+func modifiedSince(problemDir string, files map[string][]byte, since *time.Time) *bool {
+	if since == nil {
+		return nil
+	}
+	modified := false
+	for name := range files {
+		if stat, err := os.Stat(filepath.Join(problemDir, filepath.FromSlash(name))); err == nil && stat.ModTime().After(*since) {
+			modified = true
+			break
+		}
+	}
+	return &modified
+}
+
+// activityGap is the longest pause that still counts as working.
+const activityGap = 15 * time.Minute
+
+// trackActivity adds the time spent on the current step since grind last
+// looked, judging activity by when the student's files were changed and
+// when grind was run. Only the running total is kept.
+func trackActivity(info *ProblemInfo, directory string, whitelist map[string]bool, now time.Time) {
+	events := []time.Time{now}
+	for name := range whitelist {
+		if stat, err := os.Stat(filepath.Join(directory, filepath.FromSlash(name))); err == nil && stat.ModTime().Before(now) {
+			events = append(events, stat.ModTime())
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Before(events[j]) })
+	if info.ActiveTime == nil {
+		info.ActiveTime = make(map[int64]int64)
+	}
+	last := now
+	if info.LastActive != nil {
+		last = *info.LastActive
+	}
+	for _, event := range events {
+		if gap := event.Sub(last); gap > 0 && gap <= activityGap {
+			info.ActiveTime[info.Step] += int64(gap / time.Second)
+		}
+		if event.After(last) {
+			last = event
+		}
+	}
+	info.LastActive = &last
+}
+
+// findProblemInfo locates the .grind file and identifies the problem that
+// startDir refers to, returning the problem directory.
+func findProblemInfo(startDir string) (*DotFileInfo, *ProblemInfo, string) {
+	dotfile, problemSetDir, problemDir := findDotFile(startDir)
+
+	unique := ""
+	if len(dotfile.Problems) == 1 {
+		// only one problem? files should be in dotfile directory
+		for u := range dotfile.Problems {
+			unique = u
+		}
+		problemDir = problemSetDir
+	} else {
+		// use the subdirectory name to identify the problem
+		if problemDir == "" {
+			log.Printf("you must identify the problem within this problem set")
+			log.Printf("  either run this from with the problem directory, or")
+			log.Fatalf("  identify it as a parameter in the command")
+		}
+		_, unique = filepath.Split(problemDir)
+	}
+	info := dotfile.Problems[unique]
+	if info == nil {
+		log.Fatalf("unable to recognize the problem based on the directory name of %q", unique)
+	}
+
+	return dotfile, info, problemDir
+}
+
+// inProblemSet reports whether a directory is inside a problem set,
+// without giving up if it is not.
+func inProblemSet(dir string) bool {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(abs, perProblemSetDotFile)); err == nil {
+			return true
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return false
+		}
+		abs = parent
+	}
+}
+
+// inProblem reports whether a directory identifies a single problem.
+func inProblem(dir string) bool {
+	if !inProblemSet(dir) {
+		return false
+	}
+	dotfile, _, problemDir := findDotFile(dir)
+	if len(dotfile.Problems) == 1 {
+		return true
+	}
+	_, unique := filepath.Split(problemDir)
+	return problemDir != "" && dotfile.Problems[unique] != nil
+}
+
 func findDotFile(startDir string) (dotfile *DotFileInfo, problemSetDir, problemDir string) {
 	abs := false
 	problemSetDir, problemDir = startDir, ""
@@ -272,7 +412,11 @@ func saveDotFile(dotfile *DotFileInfo) {
 func mustConfirmCommitBundle(bundle *CommitBundle, args []string) *CommitBundle {
 	// create a websocket connection to the server
 	headers := make(http.Header)
+	headers.Set("Sec-Websocket-Protocol", SocketCompression)
+	headers.Set(APIVersionHeader, strconv.Itoa(APIVersion))
 	url := "wss://" + bundle.Hostname + urlPrefix + "/sockets/" + bundle.ProblemType.Name + "/" + bundle.Commit.Action
+	verbosef("connecting to daycare %s\n", bundle.Hostname)
+	debugf("dialing %s", url)
 	socket, resp, err := websocket.DefaultDialer.Dial(url, headers)
 	if err != nil {
 		log.Printf("error dialing %s: %v", url, err)
@@ -286,14 +430,14 @@ func mustConfirmCommitBundle(bundle *CommitBundle, args []string) *CommitBundle
 
 	// form the initial request
 	req := &DaycareRequest{CommitBundle: bundle}
-	if err := socket.WriteJSON(req); err != nil {
+	if err := WriteSocketJSON(socket, req); err != nil {
 		log.Fatalf("error writing request message: %v", err)
 	}
 
 	// start listening for events
 	for {
 		reply := new(DaycareResponse)
-		if err := socket.ReadJSON(reply); err != nil {
+		if err := ReadSocketJSON(socket, reply); err != nil {
 			log.Fatalf("socket error reading event: %v", err)
 			break
 		}
@@ -317,3 +461,129 @@ func mustConfirmCommitBundle(bundle *CommitBundle, args []string) *CommitBundle
 	log.Fatalf("no commit returned from server")
 	return nil
 }
+
+// deltaCommit returns a copy of a commit ready to upload, with the files
+// the server already has replaced by their hashes. If the server does
+// not support this, the commit is returned unchanged.
+func deltaCommit(commit *Commit) *Commit {
+	if len(commit.Files) == 0 {
+		return commit
+	}
+	hashes := make(map[string]string)
+	query := &BlobQuery{}
+	for name, contents := range commit.Files {
+		sum := sha256.Sum256(contents)
+		hashes[name] = hex.EncodeToString(sum[:])
+		query.Hashes = append(query.Hashes, hashes[name])
+	}
+	missing := new(BlobQuery)
+	if !doRequest("/blobs/missing", nil, "POST", query, missing, true) {
+		// older server: upload everything
+		return commit
+	}
+	need := make(map[string]bool)
+	for _, hash := range missing.Hashes {
+		need[hash] = true
+	}
+
+	delta := *commit
+	delta.Files = make(map[string][]byte)
+	delta.FileHashes = make(map[string]string)
+	for name, contents := range commit.Files {
+		if need[hashes[name]] {
+			delta.Files[name] = contents
+		} else {
+			delta.FileHashes[name] = hashes[name]
+		}
+	}
+	return &delta
+}
+
+// verifyFiles checks that files were written to disk intact.
+func verifyFiles(directory string, files map[string][]byte) {
+	for name, contents := range files {
+		ondisk, err := ioutil.ReadFile(filepath.Join(directory, name))
+		if err != nil {
+			log.Fatalf("error reading %s: %v", name, err)
+		}
+		if sha256.Sum256(ondisk) != sha256.Sum256(contents) {
+			log.Printf("file %s was not saved correctly", name)
+			log.Fatalf("  check that your disk is not full and run '%s get' again to resume", os.Args[0])
+		}
+	}
+}
+
+// starterDirectory holds the starter version of each student file in a
+// problem directory, used as the common ancestor when an updated step
+// is merged with the student's work.
+const starterDirectory = ".grind-starter"
+
+// saveStarterFiles records the starter versions of a step's student files.
+// Failures are only logged since merging falls back to keeping local work.
+func saveStarterFiles(directory string, step *ProblemStep) {
+	dir := filepath.Join(directory, starterDirectory)
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("error clearing %s: %v", dir, err)
+		return
+	}
+	for name := range step.Whitelist {
+		contents, exists := step.Files[name]
+		if !exists {
+			continue
+		}
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Printf("error creating directory %s: %v", filepath.Dir(path), err)
+			continue
+		}
+		if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+			log.Printf("error saving %s: %v", path, err)
+		}
+	}
+}
+
+// mergeStarterFiles brings the student's files up to date with changes the
+// author has made to the step's starter files since they were downloaded,
+// keeping the student's edits and marking any conflicts.
+func mergeStarterFiles(directory string, step *ProblemStep) {
+	dir := filepath.Join(directory, starterDirectory)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		// nothing to merge against: start tracking from here
+		saveStarterFiles(directory, step)
+		return
+	}
+
+	changed := false
+	for name := range step.Whitelist {
+		updated, exists := step.Files[name]
+		if !exists {
+			continue
+		}
+		base, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil || bytes.Equal(base, updated) {
+			continue
+		}
+		changed = true
+
+		path := filepath.Join(directory, filepath.FromSlash(name))
+		local, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatalf("error reading %s: %v", name, err)
+		}
+		merged, conflicts := updated, 0
+		if err == nil && !bytes.Equal(local, base) {
+			merged, conflicts = Merge3(base, local, updated, "your version", "updated starter file")
+		}
+		if err := ioutil.WriteFile(path, merged, 0644); err != nil {
+			log.Fatalf("error saving %s: %v", name, err)
+		}
+		if conflicts > 0 {
+			fmt.Printf("merged starter update into %s with %d conflict%s; look for <<<<<<< markers\n", name, conflicts, plural(conflicts))
+		} else {
+			infof("merged starter update into %s\n", name)
+		}
+	}
+	if changed {
+		saveStarterFiles(directory, step)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandHistory(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+
+	dotfile, info, _ := findProblemInfo(".")
+	commits := []*Commit{}
+	mustGetObject(fmt.Sprintf("/assignments/%d/problems/%d/commits/history", dotfile.AssignmentID, info.ID), nil, &commits)
+	if len(commits) == 0 {
+		log.Fatalf("no saved commits found for this problem")
+	}
+
+	longestID := 1
+	for _, commit := range commits {
+		if n := len(strconv.FormatInt(commit.ID, 10)); n > longestID {
+			longestID = n
+		}
+	}
+	for _, commit := range commits {
+		result := "saved"
+		if commit.ReportCard != nil {
+			result = fmt.Sprintf("%3.0f%%", commit.Score*100.0)
+			if commit.Action != "" && commit.Action != "grade" {
+				result = commit.Action
+			}
+		}
+		message := ""
+		if commit.Message != "" {
+			message = fmt.Sprintf("  %q", commit.Message)
+		}
+		fmt.Printf("id:%-*d step %d  %s  %s%s\n", longestID, commit.ID, commit.Step,
+			commit.UpdatedAt.Local().Format("Jan 2 15:04:05"), result, message)
+		if c := commit.Client; c != nil {
+			verbosef("%*s  %s\n", longestID+3, "", describeClient(c))
+		}
+	}
+	fmt.Printf("\nuse '%s checkout <id>' to restore the files from one of these commits\n", os.Args[0])
+}
+
+func CommandCheckout(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || id < 1 {
+		log.Fatalf("commit ID must be a positive number: %q", args[0])
+	}
+
+	dotfile, info, problemDir := findProblemInfo(".")
+	commit := new(Commit)
+	mustGetObject(fmt.Sprintf("/commit_history/%d", id), nil, commit)
+	if commit.AssignmentID != dotfile.AssignmentID || commit.ProblemID != info.ID {
+		log.Fatalf("commit %d is not part of this problem; run '%s history' to see a list", id, os.Args[0])
+	}
+	if commit.Step != info.Step {
+		log.Printf("note: commit %d is from step %d but you are working on step %d", id, commit.Step, info.Step)
+	}
+
+	files := make(map[string][]byte)
+	for name, contents := range commit.Files {
+		files[filepath.FromSlash(name)] = contents
+	}
+	updateFiles(problemDir, files, nil, true)
+	fmt.Printf("restored files from commit %d\n", id)
+}
+
+func CommandReplay(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || id < 1 {
+		log.Fatalf("commit ID must be a positive number: %q", args[0])
+	}
+	speed, err := strconv.ParseFloat(cmd.Flag("speed").Value.String(), 64)
+	if err != nil || speed <= 0 {
+		log.Fatalf("speed must be a positive number: %q", cmd.Flag("speed").Value.String())
+	}
+
+	commit := new(Commit)
+	mustGetObject(fmt.Sprintf("/commit_history/%d", id), nil, commit)
+	if len(commit.Transcript) == 0 {
+		log.Fatalf("commit %d has no recorded session", id)
+	}
+
+	// older transcripts have no timing, so they play back all at once
+	start := time.Now()
+	for _, event := range commit.Transcript {
+		at := time.Duration(float64(event.Elapsed) / speed * float64(time.Millisecond))
+		if wait := at - time.Since(start); wait > 0 {
+			time.Sleep(wait)
+		}
+		fmt.Print(event.Dump())
+	}
+	if commit.ReportCard != nil {
+		fmt.Print(commit.ReportCard.Dump())
+	}
+}
+
+// describeClient summarizes the copy of grind that made a commit.
+func describeClient(c *CommitClient) string {
+	s := "grind " + c.Version
+	if c.Platform != "" {
+		s += " on " + c.Platform
+	}
+	if c.HostHash != "" {
+		s += ", host " + c.HostHash
+	}
+	if c.Modified != nil && *c.Modified {
+		s += ", files changed since last graded"
+	} else if c.Modified != nil {
+		s += ", files unchanged since last graded"
+	}
+	return s
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/zalando/go-keyring"
+)
+
+// The session cookie is kept in the system keyring (Keychain on macOS,
+// the Secret Service on Linux, Credential Manager on Windows) under the
+// server host name, unless the user logs in with --no-keyring.
+
+const keyringService = "codegrinder"
+
+func saveSessionCookie() error {
+	return keyring.Set(keyringService, Config.Host, Config.Cookie)
+}
+
+func loadSessionCookie() error {
+	cookie, err := keyring.Get(keyringService, Config.Host)
+	if err != nil {
+		return err
+	}
+	Config.Cookie = cookie
+	return nil
+}
@@ -15,7 +15,7 @@ func CommandList(cmd *cobra.Command, args []string) {
 
 	if len(args) != 0 {
 		cmd.Help()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	user := new(User)
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/russross/codegrinder/tty"
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandLocal(cmd *cobra.Command, args []string) {
+	mustLoadConfigOffline(cmd)
+
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	name := args[0]
+
+	// find the problem and step; these come from the saved copies when offline
+	_, info, problemDir := findProblemInfo(".")
+	step := new(ProblemStep)
+	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", info.StepsID(), info.Step), nil, step)
+	problemType := new(ProblemType)
+	mustGetObject(fmt.Sprintf("/problem_types/%s", step.ProblemType), nil, problemType)
+	action, exists := problemType.Actions[name]
+	if !exists {
+		fmt.Printf("available actions for problem type %s:\n", problemType.Name)
+		for elt := range problemType.Actions {
+			fmt.Printf("   %s\n", elt)
+		}
+		log.Fatalf("use '%s local [action]' to run an action", os.Args[0])
+	}
+
+	if _, err := exec.LookPath("docker"); err != nil {
+		log.Fatalf("docker was not found; '%s local' needs Docker installed and running", os.Args[0])
+	}
+
+	// gather the step files, problem type files, and the student's files
+	// into a scratch directory so the action cannot change the originals
+	scratch, err := ioutil.TempDir("", "grind-local-")
+	if err != nil {
+		log.Fatalf("creating scratch directory: %v", err)
+	}
+	defer os.RemoveAll(scratch)
+	files := make(map[string][]byte)
+	for name, contents := range step.Files {
+		files[name] = contents
+	}
+	for name, contents := range problemType.Files {
+		files[name] = contents
+	}
+	for name := range step.Whitelist {
+		contents, err := ioutil.ReadFile(filepath.Join(problemDir, filepath.FromSlash(name)))
+		if err != nil {
+			log.Fatalf("all expected files must be present: %v", err)
+		}
+		files[name] = contents
+	}
+	for name, contents := range files {
+		path := filepath.Join(scratch, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Fatalf("creating directory for %s: %v", name, err)
+		}
+		if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+			log.Fatalf("saving %s: %v", name, err)
+		}
+	}
+
+	// fetch the image the first time
+	if err := exec.Command("docker", "image", "inspect", problemType.Image).Run(); err != nil {
+		fmt.Printf("downloading the %s image; this only happens once\n", problemType.Image)
+		pull := exec.Command("docker", "pull", problemType.Image)
+		pull.Stdout, pull.Stderr = os.Stdout, os.Stderr
+		if err := pull.Run(); err != nil {
+			log.Fatalf("docker pull %s: %v", problemType.Image, err)
+		}
+	}
+
+	// run the action with the same limits the daycare uses
+	dockerArgs := []string{"run", "--rm", "-i",
+		"--network", "none",
+		"--memory", fmt.Sprintf("%dm", action.MaxMemory),
+		"--memory-swap", "-1",
+		"--pids-limit", strconv.FormatInt(action.MaxThreads, 10),
+		"--ulimit", fmt.Sprintf("cpu=%d", action.MaxCPU),
+		"--ulimit", fmt.Sprintf("nofile=%d", action.MaxFD),
+		"-e", "USER=student",
+		"-e", "HOME=/home/student",
+		"-v", scratch + ":/home/student",
+		"-w", "/home/student",
+	}
+	if tty.NewInStream(os.Stdin).IsTerminal() {
+		dockerArgs = append(dockerArgs, "-t")
+	}
+	if uid, gid := os.Getuid(), os.Getgid(); uid >= 0 && gid >= 0 {
+		dockerArgs = append(dockerArgs, "--user", fmt.Sprintf("%d:%d", uid, gid))
+	}
+	dockerArgs = append(dockerArgs, problemType.Image)
+	dockerArgs = append(dockerArgs, strings.Fields(action.Command)...)
+
+	fmt.Printf("*** unofficial local %s: nothing is saved or recorded, and\n", name)
+	fmt.Printf("*** results may differ from '%s grade', which is what counts\n", os.Args[0])
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(action.MaxSession)*time.Second)
+	defer cancel()
+	run := exec.CommandContext(ctx, "docker", dockerArgs...)
+	run.Stdin, run.Stdout, run.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = run.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Printf("*** stopped after %d seconds", action.MaxSession)
+	} else if exit, ok := err.(*exec.ExitError); ok {
+		log.Printf("*** exit status %d", exit.ExitCode())
+	} else if err != nil {
+		log.Printf("*** docker run: %v", err)
+	}
+}
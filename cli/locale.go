@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandLocale(cmd *cobra.Command, args []string) {
+	mustLoadConfigOffline(cmd)
+
+	if len(args) > 1 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+
+	if len(args) == 1 {
+		if args[0] == "default" {
+			Config.Locale = ""
+		} else {
+			locale := NormalizeLocale(args[0])
+			if locale == "" {
+				log.Fatalf("%q is not a language tag; try something like es or pt-BR", args[0])
+			}
+			Config.Locale = locale
+		}
+		mustWriteConfig()
+	}
+
+	if Config.Locale == "" {
+		fmt.Println("grind uses the language from your course, or English if it has none")
+		if len(args) == 0 {
+			fmt.Printf("use '%s locale <locale>' to choose a language, such as '%s locale es'\n", os.Args[0], os.Args[0])
+		}
+	} else {
+		fmt.Printf("grind asks for instructions and feedback in %s\n", Config.Locale)
+		fmt.Printf("use '%s locale default' to use the language from your course instead\n", os.Args[0])
+	}
+}
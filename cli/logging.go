@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// grind prints two kinds of output. The results a command exists to show,
+// such as lists, tables, and report cards, are printed with fmt and are
+// never silenced. Progress and status messages go through infof, verbosef,
+// and debugf so that --quiet, --verbose, and --debug mean the same thing
+// for every command. Errors are always logged to stderr.
+
+const (
+	levelQuiet = iota - 1
+	levelNormal
+	levelVerbose
+	levelDebug
+)
+
+var logLevel = levelNormal
+
+// exit codes, so scripts can tell failures apart
+const (
+	exitFailure = 1 // the command failed
+	exitUsage   = 2 // the command was used incorrectly
+	exitServer  = 3 // the server could not be reached or rejected a request
+	exitAuth    = 4 // the user is not logged in or the session has expired
+)
+
+// setLogLevel reads the output flags shared by every command.
+func setLogLevel(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	quiet, _ := flags.GetBool("quiet")
+	verbose, _ := flags.GetBool("verbose")
+	debug, _ := flags.GetBool("debug")
+	switch {
+	case debug:
+		logLevel = levelDebug
+		if api, ok := http.DefaultClient.Transport.(*apiTransport); ok {
+			// trace requests after the API headers are added
+			api.next = &tracingTransport{next: api.next}
+		} else {
+			http.DefaultClient.Transport = &tracingTransport{next: http.DefaultClient.Transport}
+		}
+	case verbose:
+		logLevel = levelVerbose
+	case quiet:
+		logLevel = levelQuiet
+	}
+	if quiet && (verbose || debug) {
+		fatalf(exitUsage, "--quiet cannot be combined with --verbose or --debug")
+	}
+}
+
+// infof prints a status message unless --quiet was given.
+func infof(format string, args ...interface{}) {
+	if logLevel >= levelNormal {
+		fmt.Printf(format, args...)
+	}
+}
+
+// verbosef prints extra detail when --verbose or --debug was given.
+func verbosef(format string, args ...interface{}) {
+	if logLevel >= levelVerbose {
+		fmt.Printf(format, args...)
+	}
+}
+
+// debugf logs a trace message to stderr when --debug was given.
+func debugf(format string, args ...interface{}) {
+	if logLevel >= levelDebug {
+		log.Printf("debug: "+format, args...)
+	}
+}
+
+// fatalf logs an error and exits with the given code.
+func fatalf(code int, format string, args ...interface{}) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
+
+// tracingTransport logs every HTTP request and its outcome.
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	debugf("%s %s", req.Method, req.URL)
+	for name := range req.Header {
+		if name != "Cookie" {
+			debugf("  > %s: %s", name, req.Header.Get(name))
+		}
+	}
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		debugf("%s %s failed after %v: %v", req.Method, req.URL.Path, elapsed, err)
+		return resp, err
+	}
+	debugf("%s %s: %s in %v (%d bytes)", req.Method, req.URL.Path, resp.Status, elapsed, resp.ContentLength)
+	for name := range resp.Header {
+		if name != "Set-Cookie" {
+			debugf("  < %s: %s", name, resp.Header.Get(name))
+		}
+	}
+	return resp, nil
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,9 +16,13 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/blang/semver"
+	"github.com/klauspost/compress/zstd"
+	"github.com/russross/codegrinder/term"
 	. "github.com/russross/codegrinder/types"
 	"github.com/spf13/cobra"
 )
@@ -27,39 +34,87 @@ const (
 	urlPrefix            = "/v2"
 )
 
+var etagHash = regexp.MustCompile(`^"[0-9a-f]{32}"$`)
+
 var Config struct {
-	Host      string `json:"host"`
-	Cookie    string `json:"cookie"`
-	apiReport bool
-	apiDump   bool
+	Host        string         `json:"host"`
+	Cookie      string         `json:"cookie"`
+	Keyring     bool           `json:"keyring,omitempty"`     // the cookie is kept in the system keyring instead
+	AutoUpgrade bool           `json:"autoUpgrade,omitempty"` // upgrade when the server recommends a newer grind
+	TrackTime   map[int64]bool `json:"trackTime,omitempty"`   // courses where time spent editing is reported
+	Locale      string         `json:"locale,omitempty"`      // language for instructions and feedback
+	ClientKey   string         `json:"clientKey,omitempty"`   // base64 ed25519 seed for signing commits
+	ClientKeyID int64          `json:"clientKeyID,omitempty"` // server ID of the matching public key
+	apiReport   bool
+	apiDump     bool
+	zstd        bool // the server has replied with zstd, so uploads can use it too
 }
 
 type DotFileInfo struct {
 	AssignmentID int64                   `json:"assignmentID"`
 	Problems     map[string]*ProblemInfo `json:"problems"`
+	Git          bool                    `json:"git,omitempty"` // record each graded commit in git
 	Path         string                  `json:"-"`
 }
 
 type ProblemInfo struct {
-	ID   int64 `json:"id"`
-	Step int64 `json:"step"`
+	ID      int64    `json:"id"`
+	Step    int64    `json:"step"`
+	Variant int64    `json:"variant,omitempty"` // the problem whose steps are used, if it is one of several variants
+	Extra   []string `json:"extra,omitempty"`   // files the student added with grind add
+
+	// when this step was last graded, to tell if the files changed since
+	GradedAt *time.Time `json:"gradedAt,omitempty"`
+
+	// editing time estimated locally when the student opts in with grind track
+	LastActive *time.Time      `json:"lastActive,omitempty"`
+	ActiveTime map[int64]int64 `json:"activeTime,omitempty"` // seconds for each step
+}
+
+// StepsID returns the ID of the problem to get steps from, which is the
+// chosen variant of a multi-language problem.
+func (info *ProblemInfo) StepsID() int64 {
+	if info.Variant > 0 {
+		return info.Variant
+	}
+	return info.ID
 }
 
 func main() {
 	isInstructor := hasInstructorFile()
 	log.SetFlags(0)
+	installTransports()
 
 	cmdGrind := &cobra.Command{
 		Use:   "grind",
 		Short: "Command-line interface to CodeGrinder",
 		Long: "A command-line tool to access CodeGrinder\n" +
-			"by Russ Ross <russ@russross.com>",
+			"by Russ Ross <russ@russross.com>\n\n" +
+			"Every command accepts --quiet, --verbose, and --debug. grind exits\n" +
+			"with status 1 when a command fails, 2 when it is used incorrectly,\n" +
+			"3 when the server cannot be reached or rejects a request, and 4\n" +
+			"when you need to log in again.",
 	}
+	cmdGrind.PersistentFlags().BoolP("quiet", "q", false, "only print results and errors")
+	cmdGrind.PersistentFlags().BoolP("verbose", "v", false, "print extra detail about what grind is doing")
+	cmdGrind.PersistentFlags().Bool("debug", false, "trace every request to the server on stderr")
+	cmdGrind.PersistentPreRun = setLogLevel
 	if isInstructor {
 		cmdGrind.PersistentFlags().BoolVarP(&Config.apiReport, "api", "", false, "report all API requests")
 		cmdGrind.PersistentFlags().BoolVarP(&Config.apiDump, "api-dump", "", false, "dump API request and response data")
 	}
 
+	cmdDoctor := &cobra.Command{
+		Use:   "doctor",
+		Short: "check for common problems with your setup",
+		Long: "Check your connection to the server, your login session,\n" +
+			"the version of grind, and the files of the assignment in the\n" +
+			"current directory, suggesting a fix for each problem found.\n\n" +
+			"Include the output when asking your instructor for help.",
+		Run: CommandDoctor,
+	}
+	cmdGrind.AddCommand(cmdDoctor)
+
 	cmdVersion := &cobra.Command{
 		Use:   "version",
 		Short: "print the version number of grind",
@@ -69,23 +124,55 @@ func main() {
 	}
 	cmdGrind.AddCommand(cmdVersion)
 
+	cmdUpgrade := &cobra.Command{
+		Use:   "upgrade",
+		Short: "upgrade grind to the latest version",
+		Long: "Download the newest version of grind for this computer from the\n" +
+			"server and install it in place of this one.\n\n" +
+			"Use --auto to have grind upgrade itself whenever the server\n" +
+			"recommends a newer version, or --no-auto to turn that off.",
+		Run: CommandUpgrade,
+	}
+	cmdUpgrade.Flags().BoolP("auto", "", false, "upgrade automatically from now on")
+	cmdUpgrade.Flags().BoolP("no-auto", "", false, "stop upgrading automatically")
+	cmdGrind.AddCommand(cmdUpgrade)
+
 	cmdLogin := &cobra.Command{
-		Use:   "login <hostname> <sessionkey>",
+		Use:   "login <hostname> [sessionkey]",
 		Short: "login to codegrinder server",
 		Long: fmt.Sprintf("To log in, click on an assignment in Canvas and follow the\n" +
 			"instructions; <hostname> and <sessionkey> will be listed there.\n\n" +
+			"Alternately, give only the <hostname> and you will be shown a\n" +
+			"code to approve in a browser where you are already signed in.\n" +
+			"Use --password to sign in with an email address and password,\n" +
+			"or --anonymous to practice without an account if the server allows it.\n\n" +
 			"You should normally only need to do this once per semester."),
 		Run: CommandLogin,
 	}
+	cmdLogin.Flags().BoolP("password", "p", false, "log in with an email address and password")
+	cmdLogin.Flags().BoolP("anonymous", "", false, "log in as a new anonymous user to practice public problem sets")
+	cmdLogin.Flags().BoolP("no-keyring", "", false, "save the session in the config file instead of the system keyring")
 	cmdGrind.AddCommand(cmdLogin)
 
 	cmdList := &cobra.Command{
-		Use:   "list",
-		Short: "list all of your active assignments",
-		Run:   CommandList,
+		Use:     "list",
+		Short:   "list all of your active assignments",
+		Run:     CommandList,
+		Aliases: []string{"ls"},
 	}
 	cmdGrind.AddCommand(cmdList)
 
+	cmdStatus := &cobra.Command{
+		Use:   "status",
+		Short: "show due dates, scores, and your progress on each assignment",
+		Long: fmt.Sprintf("Lists each of your assignments with its score, due date, and\n" +
+			"the step you last worked on. When run in a problem directory, the\n" +
+			"current problem is listed first."),
+		Run:     CommandStatus,
+		Aliases: []string{"st"},
+	}
+	cmdGrind.AddCommand(cmdStatus)
+
 	cmdGet := &cobra.Command{
 		Use:   "get <assignment id> [assignment root directory]",
 		Short: "download an assignment to work on it locally",
@@ -98,24 +185,80 @@ func main() {
 			"   Example: '%s get 342'\n\n"+
 			"   Example: '%s get CS-1400/cs1400-loops'\n\n"+
 			"Note: you must load an assignment through Canvas before you can access it.", os.Args[0], os.Args[0], os.Args[0]),
-		Run: CommandGet,
+		Run:               CommandGet,
+		ValidArgsFunction: completeAssignments,
 	}
+	cmdGet.Flags().BoolP("git", "", false, "keep the assignment in a git repository with a commit for each grade")
 	cmdGrind.AddCommand(cmdGet)
 
 	cmdSync := &cobra.Command{
 		Use:   "sync",
 		Short: "save your work to the server and update local problem files",
-		Run:   CommandSync,
+		Long: fmt.Sprintf("If the starter files for your step have been updated since you\n" +
+			"downloaded them, the changes are merged into your files. Where\n" +
+			"the update and your work conflict, both versions are kept between\n" +
+			"<<<<<<< and >>>>>>> markers for you to sort out."),
+		Run: CommandSync,
 	}
 	cmdGrind.AddCommand(cmdSync)
 
+	cmdShare := &cobra.Command{
+		Use:   "share",
+		Short: "make a read-only link to your work for someone helping you",
+		Long: fmt.Sprintf("Saves your work on the current step and prints a link that shows\n"+
+			"your files to anyone who has it, without signing in, along with the\n"+
+			"report card and transcript if those files have been graded. Post it\n"+
+			"on the course forum or give it to a TA when asking for help. Later\n"+
+			"changes are not shown; run it again for a new link. Links expire\n"+
+			"after a week, or use --hours.\n\n"+
+			"   Example: '%s share --hours 24'", os.Args[0]),
+		Run: CommandShare,
+	}
+	cmdShare.Flags().Int64("hours", ShareDefaultHours, "how long the link works")
+	cmdGrind.AddCommand(cmdShare)
+
+	cmdPractice := &cobra.Command{
+		Use:   "practice [<problem set>]",
+		Short: "list public problem sets or start practicing one",
+		Long: fmt.Sprintf("Public problem sets can be practiced by anyone, without an\n"+
+			"assignment in a course. With no argument, this lists them. Give the\n"+
+			"ID of one to start it, then download it with get. Practice scores\n"+
+			"are not sent to any course.\n\n"+
+			"   Example: '%s practice cs1400-loops'", os.Args[0]),
+		Run: CommandPractice,
+	}
+	cmdGrind.AddCommand(cmdPractice)
+
 	cmdGrade := &cobra.Command{
-		Use:   "grade",
+		Use:   "grade [<assignment> <problem>]",
 		Short: "save your work and submit it for grading",
-		Run:   CommandGrade,
+		Long: fmt.Sprintf("Run this in a problem directory to submit your work for grading.\n\n"+
+			"To grade from anywhere, give the assignment number and the problem\n"+
+			"ID instead. The work most recently saved on the server is graded,\n"+
+			"or the work for a given step with --step. This needs no local copy.\n\n"+
+			"Use --practice to re-attempt a step you have already finished, for\n"+
+			"example after going back to it with step. Practice is graded and\n"+
+			"recorded for your instructor but never changes your score, and it\n"+
+			"is allowed after the assignment is locked.\n\n"+
+			"   Example: '%s grade 1234 cs1400-loops --step 2'", os.Args[0]),
+		Run:     CommandGrade,
+		Aliases: []string{"submit"},
 	}
+	cmdGrade.Flags().StringP("message", "m", "", "a note about this submission for your instructor")
+	cmdGrade.Flags().Int64P("step", "s", 0, "grade the work saved for this step (with an assignment and problem)")
+	cmdGrade.Flags().BoolP("practice", "", false, "grade it as practice, without changing your score")
 	cmdGrind.AddCommand(cmdGrade)
 
+	cmdCheck := &cobra.Command{
+		Use:   "check",
+		Short: "grade your work without submitting it",
+		Long: "Run the full grading process on your work and show the results\n" +
+			"without saving anything: your score does not change and the\n" +
+			"commit is not recorded. Use grade when you are ready to submit.",
+		Run: CommandCheck,
+	}
+	cmdGrind.AddCommand(cmdCheck)
+
 	cmdAction := &cobra.Command{
 		Use:   "action <action name>",
 		Short: "save your work and run an action on the server",
@@ -125,21 +268,236 @@ func main() {
 			"You can interact with the server if appropriate for the action\n\n"+
 			"   Example: '%s action debug'\n\n"+
 			"Note: this has the side effect of saving your code.", os.Args[0]),
-		Run: CommandAction,
+		Run:               CommandAction,
+		Aliases:           []string{"act"},
+		ValidArgsFunction: completeActions,
 	}
+	cmdAction.Flags().BoolP("clear-workspace", "", false, "start with a fresh workspace if the problem keeps one")
 	cmdGrind.AddCommand(cmdAction)
 
+	cmdRepl := &cobra.Command{
+		Use:   "repl",
+		Short: "save your work and start the language REPL on the server",
+		Long: fmt.Sprintf("Your code will be uploaded and loaded into the REPL\n" +
+			"for the problem's language, running in the same environment\n" +
+			"that is used for grading.\n\n" +
+			"Note: this has the side effect of saving your code."),
+		Run: CommandRepl,
+	}
+	cmdGrind.AddCommand(cmdRepl)
+
+	cmdDebug := &cobra.Command{
+		Use:   "debug",
+		Short: "save your work and run your program in a debugger on the server",
+		Long: fmt.Sprintf("Your code will be uploaded, built if necessary, and started\n" +
+			"in the debugger for the problem's language (gdb, pdb, dlv, etc.),\n" +
+			"running in the same environment that is used for grading.\n\n" +
+			"Note: this has the side effect of saving your code."),
+		Run: CommandDebug,
+	}
+	cmdGrind.AddCommand(cmdDebug)
+
+	cmdWatch := &cobra.Command{
+		Use:   "watch [action]",
+		Short: "run an action on the server every time you save your work",
+		Long: fmt.Sprintf("Your code is uploaded and the action (test or run by default)\n"+
+			"is started whenever one of your files changes. A run still in\n"+
+			"progress is stopped when you save again. Your program gets no input.\n\n"+
+			"   Example: '%s watch' or '%s watch run'\n\n"+
+			"Note: this has the side effect of saving your code.", os.Args[0], os.Args[0]),
+		Run:               CommandWatch,
+		ValidArgsFunction: completeActions,
+	}
+	cmdGrind.AddCommand(cmdWatch)
+
+	cmdLocal := &cobra.Command{
+		Use:   "local <action name>",
+		Short: "run an action on your own computer using Docker",
+		Long: fmt.Sprintf("Runs an action such as test or run in the same environment\n"+
+			"the server uses, but on your computer, so it works without a network\n"+
+			"connection once you have used it for a problem step. Docker must be\n"+
+			"installed and running.\n\n"+
+			"   Example: '%s local test'\n\n"+
+			"Note: this is unofficial. Nothing is saved or recorded; use\n"+
+			"'%s grade' to submit your work.", os.Args[0], os.Args[0]),
+		Run:               CommandLocal,
+		ValidArgsFunction: completeActions,
+	}
+	cmdGrind.AddCommand(cmdLocal)
+
+	cmdTUI := &cobra.Command{
+		Use:   "tui",
+		Short: "work on your assignments in a full-screen terminal interface",
+		Long: fmt.Sprintf("Shows your assignments, the steps of the current problem, the\n" +
+			"latest report card, and live grading output side by side.\n" +
+			"Start it in a problem directory to grade and run that problem."),
+		Run: CommandTUI,
+	}
+	cmdGrind.AddCommand(cmdTUI)
+
+	cmdServe := &cobra.Command{
+		Use:   "serve",
+		Short: "offer the current problem to editor plugins over a local API",
+		Long: "Start a small JSON API on this computer that editor plugins can\n" +
+			"use to find the current problem, start grading, stream the output,\n" +
+			"and fetch the report card. Only requests from this computer are\n" +
+			"accepted. Run it in a problem directory and leave it running.",
+		Run: CommandServe,
+	}
+	cmdServe.Flags().IntP("port", "p", 7482, "port to listen on")
+	cmdGrind.AddCommand(cmdServe)
+
+	cmdTrack := &cobra.Command{
+		Use:   "track [on|off]",
+		Short: "report how long you spend on each step in this course",
+		Long: "Turn on time tracking to help your instructor learn how long\n" +
+			"problems really take. grind estimates the time you spend working\n" +
+			"on each step from when your files change and when you run grind,\n" +
+			"leaving out breaks longer than 15 minutes, and includes only the\n" +
+			"total with each commit. It is off until you turn it on, and the\n" +
+			"choice applies to the course of the assignment in this directory.",
+		Run: CommandTrack,
+	}
+	cmdGrind.AddCommand(cmdTrack)
+
+	cmdLocale := &cobra.Command{
+		Use:   "locale [<locale>|default]",
+		Short: "choose the language for instructions and feedback",
+		Long: fmt.Sprintf("Set the language the server uses for problem instructions, report\n"+
+			"cards, and error messages, given as a language tag such as es or\n"+
+			"pt-BR. Instructions are shown in English when the problem has no\n"+
+			"translation. With default, grind uses the language from your\n"+
+			"course in Canvas instead. Run '%s get' again to fetch translated\n"+
+			"instructions for problems you already have.\n\n"+
+			"   Example: '%s locale es'", os.Args[0], os.Args[0]),
+		Run: CommandLocale,
+	}
+	cmdGrind.AddCommand(cmdLocale)
+
+	cmdNotifications := &cobra.Command{
+		Use:   "notifications",
+		Short: "choose which email notifications you receive",
+		Long: fmt.Sprintf("Show or change the email the server sends you, if it is set up to\n"+
+			"send email: reminders when an assignment is due soon and is not\n"+
+			"finished, messages when your instructor regrades a problem and your\n"+
+			"score changes, and (for instructors) a weekly summary of each\n"+
+			"course. Everything is on until you turn it off.\n\n"+
+			"   Example: '%s notifications --deadlines=false'", os.Args[0]),
+		Run: CommandNotifications,
+	}
+	cmdNotifications.Flags().Bool("deadlines", true, "send reminders before assignments are due")
+	cmdNotifications.Flags().Bool("regrades", true, "send a message when a regrade changes your score")
+	cmdNotifications.Flags().Bool("digest", true, "send instructors a weekly digest of each course")
+	cmdGrind.AddCommand(cmdNotifications)
+
+	cmdAdd := &cobra.Command{
+		Use:   "add <file1> [file2] [...]",
+		Short: "submit files you created along with the files for this step",
+		Long: fmt.Sprintf("Only the files a problem provides for you to edit are normally\n"+
+			"submitted. If the problem allows it, use this to also submit files\n"+
+			"you created yourself, such as helper modules. Files are added for\n"+
+			"the rest of the problem.\n\n"+
+			"   Example: '%s add helpers.py'", os.Args[0]),
+		Run: CommandAdd,
+	}
+	cmdGrind.AddCommand(cmdAdd)
+
+	cmdRm := &cobra.Command{
+		Use:   "rm <file1> [file2] [...]",
+		Short: "stop submitting files you added with add",
+		Long: fmt.Sprintf("Stop submitting files you added with '%s add'. The files\n"+
+			"stay on your computer, and files the problem requires cannot be\n"+
+			"removed.\n\n"+
+			"   Example: '%s rm helpers.py'", os.Args[0], os.Args[0]),
+		Run: CommandRm,
+	}
+	cmdGrind.AddCommand(cmdRm)
+
 	cmdReset := &cobra.Command{
 		Use:   "reset [file1] [file2] [...]",
 		Short: "go back to the beginning of the current step for specified files",
 		Long: fmt.Sprintf("This lets you start the current step from the beginning\n" +
 			"by deleting any changes you have made.\n\n" +
 			"Files you have modified will be listed, and if you provide\n" +
-			"a list of files they will be reset to their start-of-step state."),
+			"a list of files (or --all) they will be reset to their start-of-step state."),
 		Run: CommandReset,
 	}
+	cmdReset.Flags().BoolP("all", "a", false, "reset all of your files for this step")
 	cmdGrind.AddCommand(cmdReset)
 
+	cmdStep := &cobra.Command{
+		Use:   "step <step number>",
+		Short: "go back to an earlier step, or return to the latest one",
+		Long: fmt.Sprintf("Your current work is saved, and your files are replaced with\n"+
+			"your last saved version of the given step. You can go to any step\n"+
+			"up to the furthest one you have reached.\n\n"+
+			"   Example: '%s step 2' or '%s step 2 --read-only'", os.Args[0], os.Args[0]),
+		Run: CommandStep,
+	}
+	cmdStep.Flags().BoolP("read-only", "r", false, "make the files read-only so they are only for viewing")
+	cmdStep.Flags().BoolP("fresh", "", false, "start the step over from its starter files")
+	cmdGrind.AddCommand(cmdStep)
+
+	cmdHistory := &cobra.Command{
+		Use:   "history",
+		Short: "list the saved versions of your work on the current problem",
+		Long: "List every saved and graded version of your work on the current\n" +
+			"problem. With --verbose, also show the version of grind and the\n" +
+			"kind of computer that submitted each one.",
+		Run:     CommandHistory,
+		Aliases: []string{"log"},
+	}
+	cmdGrind.AddCommand(cmdHistory)
+
+	cmdCheckout := &cobra.Command{
+		Use:   "checkout <commit id>",
+		Short: "restore your files from an earlier saved version",
+		Long: fmt.Sprintf("Give the numeric ID of a commit listed by '%s history'.\n"+
+			"Your files will be overwritten with the files from that commit.\n\n"+
+			"   Example: '%s checkout 1234'", os.Args[0], os.Args[0]),
+		Run:     CommandCheckout,
+		Aliases: []string{"co"},
+	}
+	cmdGrind.AddCommand(cmdCheckout)
+
+	cmdDiff := &cobra.Command{
+		Use:   "diff",
+		Short: "show what you have changed in the current step",
+		Long: fmt.Sprintf("Compares your files as they are now with the starter files\n"+
+			"for the current step, or with an earlier saved version.\n\n"+
+			"   Example: '%s diff' or '%s diff --commit 1234'", os.Args[0], os.Args[0]),
+		Run: CommandDiff,
+	}
+	cmdDiff.Flags().StringP("commit", "c", "", "compare with this commit from '"+os.Args[0]+" history'")
+	cmdGrind.AddCommand(cmdDiff)
+
+	cmdReplay := &cobra.Command{
+		Use:   "replay <commit id>",
+		Short: "play back the session recorded when a saved version was graded",
+		Long: fmt.Sprintf("Give the numeric ID of a commit listed by '%s history'.\n"+
+			"The output recorded while it was graded is shown with its original timing.\n\n"+
+			"   Example: '%s replay 1234' or '%s replay --speed 4 1234'", os.Args[0], os.Args[0], os.Args[0]),
+		Run: CommandReplay,
+	}
+	cmdReplay.Flags().Float64P("speed", "s", 1, "play back this many times faster than real time")
+	cmdGrind.AddCommand(cmdReplay)
+
+	cmdCompletion := &cobra.Command{
+		Use:   "completion <bash|zsh|fish|powershell>",
+		Short: "print a shell script that sets up tab completion for grind",
+		Long: fmt.Sprintf("Assignment IDs and action names are completed from the ones\n"+
+			"grind has already downloaded (bash and fish only).\n\n"+
+			"   bash:       source <(%s completion bash)\n"+
+			"   zsh:        %s completion zsh > \"${fpath[1]}/_%s\"\n"+
+			"   fish:       %s completion fish | source\n"+
+			"   powershell: %s completion powershell | Out-String | Invoke-Expression",
+			os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0]),
+		ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+		Args:      cobra.ExactValidArgs(1),
+		Run:       CommandCompletion,
+	}
+	cmdGrind.AddCommand(cmdCompletion)
+
 	if isInstructor {
 		cmdCreate := &cobra.Command{
 			Use:   "create [filename]",
@@ -163,6 +521,60 @@ func main() {
 		}
 		cmdGrind.AddCommand(cmdStudent)
 
+		cmdBulk := &cobra.Command{
+			Use:   "bulk <assignment id | problem set unique ID>",
+			Short: "download every student's work for an assignment (instructors only)",
+			Long: fmt.Sprintf("Give the numeric ID of any assignment (yours or a student's)\n"+
+				"to download the work of every student with the same assignment,\n"+
+				"or give a problem set unique ID to download every student in each\n"+
+				"of your courses using that problem set.\n\n"+
+				"Each student's files are saved in a directory named after their email.\n\n"+
+				"   Example: '%s bulk --best --reports cs1400-loops'", os.Args[0]),
+			Run:               CommandBulk,
+			ValidArgsFunction: completeAssignments,
+		}
+		cmdBulk.Flags().BoolP("best", "b", false, "download the highest-scoring commit instead of the latest")
+		cmdBulk.Flags().BoolP("reports", "r", false, "include report cards and transcripts")
+		cmdBulk.Flags().StringP("match", "m", "", "only include students whose name or email contains this text")
+		cmdBulk.Flags().StringP("section", "s", "", "only include students in this section (name or ID)")
+		cmdBulk.Flags().StringP("dir", "d", ".", "directory to download into")
+		cmdGrind.AddCommand(cmdBulk)
+
+		cmdRoster := &cobra.Command{
+			Use:   "roster <course>",
+			Short: "list the users in a course with their scores (instructors only)",
+			Long: fmt.Sprintf("Give the numeric ID, label, or name of a course you teach to list\n"+
+				"everyone enrolled with their LTI roles, when they were last active,\n"+
+				"and their score on each assignment.\n\n"+
+				"Students only appear once they launch an assignment, unless you\n"+
+				"use --sync to load the full roster from the LMS first. Then use\n"+
+				"--not-started to list the students who have not launched anything.\n"+
+				"Use --section to list only one section of a large course.\n\n"+
+				"   Example: '%s roster --csv CS-1400-01 > roster.csv'", os.Args[0]),
+			Run: CommandRoster,
+		}
+		cmdRoster.Flags().Bool("csv", false, "write the roster as CSV")
+		cmdRoster.Flags().Bool("sync", false, "load everyone enrolled from the LMS before listing")
+		cmdRoster.Flags().Bool("not-started", false, "only list students who have not launched anything")
+		cmdRoster.Flags().StringP("section", "s", "", "only list users in this section (name or ID)")
+		cmdGrind.AddCommand(cmdRoster)
+
+		cmdRegrade := &cobra.Command{
+			Use:   "regrade <problem>",
+			Short: "regrade submissions after a problem changes (instructors only)",
+			Long: fmt.Sprintf("Give the unique ID or numeric ID of a problem. The most recent graded\n"+
+				"submission of each step is sent back to a daycare, and the new scores\n"+
+				"are saved and posted to the LMS. By default every student in a course\n"+
+				"you teach is regraded; use --assignment to limit it to students with the\n"+
+				"same LMS assignment as the given assignment ID, or --user to limit it to\n"+
+				"one user ID.\n\n"+
+				"   Example: '%s regrade --assignment 1234 cs1400-loops'", os.Args[0]),
+			Run: CommandRegrade,
+		}
+		cmdRegrade.Flags().StringP("assignment", "a", "", "only regrade this assignment (by numeric ID) for every student")
+		cmdRegrade.Flags().StringP("user", "u", "", "only regrade this user (by numeric ID)")
+		cmdGrind.AddCommand(cmdRegrade)
+
 		cmdSolve := &cobra.Command{
 			Use:   "solve",
 			Short: "save the solution for the current problem step (authors only)",
@@ -193,12 +605,135 @@ func main() {
 				"User '%s list' to see a list of assignments available to you.\n\n"+
 				"The quizzes will be exported into a newly-created directory\n"+
 				"as a series of JSON files, one per quiz and one per question in each quiz.", os.Args[0]),
-			Run: CommandExportQuizzes,
+			Run:               CommandExportQuizzes,
+			ValidArgsFunction: completeAssignments,
 		}
 		cmdGrind.AddCommand(cmdExportQuizzes)
+
+		cmdAuthor := &cobra.Command{
+			Use:   "author",
+			Short: "problem authoring tools (authors only)",
+		}
+		cmdGrind.AddCommand(cmdAuthor)
+
+		cmdAuthorExport := &cobra.Command{
+			Use:   "export <problem set unique ID> [filename]",
+			Short: "download a problem set and all of its problems as a .tar.gz file",
+			Long: fmt.Sprintf("The archive contains all problems, steps, files, and solutions\n"+
+				"for the problem set and can be imported into another installation\n"+
+				"or stored in version control.\n\n"+
+				"   Example: '%s author export cs1400-loops'", os.Args[0]),
+			Run: CommandAuthorExport,
+		}
+		cmdAuthor.AddCommand(cmdAuthorExport)
+
+		cmdAuthorImport := &cobra.Command{
+			Use:   "import <filename>",
+			Short: "create a problem set from a .tar.gz file (administrators only)",
+			Long: fmt.Sprintf("The archive should be one created by '%s author export'.\n"+
+				"None of the problems in the archive may already exist.", os.Args[0]),
+			Run: CommandAuthorImport,
+		}
+		cmdAuthor.AddCommand(cmdAuthorImport)
+
+		cmdAuthorPublish := &cobra.Command{
+			Use:   "publish <problem set unique ID>",
+			Short: "let anyone practice a problem set without an assignment",
+			Long: fmt.Sprintf("Public problem sets are listed by '%s practice' and can be\n"+
+				"started by any user, including anonymous users if the server\n"+
+				"allows them. Practice scores are never sent to an LMS.\n"+
+				"Use --off to make the problem set private again.\n\n"+
+				"   Example: '%s author publish cs1400-loops'", os.Args[0], os.Args[0]),
+			Run: CommandAuthorPublish,
+		}
+		cmdAuthorPublish.Flags().Bool("off", false, "stop offering the problem set for practice")
+		cmdAuthor.AddCommand(cmdAuthorPublish)
+
+		cmdAuthorConvert := &cobra.Command{
+			Use:   "convert <icpc|gradescope> <zip file> <unique ID> [problem type]",
+			Short: "convert an autograder package from another system into a problem",
+			Long: fmt.Sprintf("Converts an ICPC/DOMjudge problem package or a Gradescope\n"+
+				"autograder zip into a problem directory skeleton named after the\n"+
+				"unique ID. Add the solution and review the instructions, then use\n"+
+				"'%s create' to create the problem.\n\n"+
+				"   Example: '%s author convert icpc hello.zip cs1400-hello python3inout'", os.Args[0], os.Args[0]),
+			Run: CommandAuthorConvert,
+		}
+		cmdAuthor.AddCommand(cmdAuthorConvert)
+
+		cmdAuthorNew := &cobra.Command{
+			Use:   "new <unique ID>",
+			Short: "start a new problem from its problem type's template",
+			Long: fmt.Sprintf("Creates a problem directory named after the unique ID with\n"+
+				"problem.cfg, instructions, and starter, solution, and test files\n"+
+				"from the template the server keeps for the problem type.\n"+
+				"Use --steps to create a multi-step problem.\n\n"+
+				"   Example: '%s author new --type gounittest cs1400-adder'", os.Args[0]),
+			Run: CommandAuthorNew,
+		}
+		cmdAuthorNew.Flags().StringP("type", "t", "", "problem type")
+		cmdAuthorNew.Flags().IntP("steps", "s", 1, "number of steps")
+		cmdAuthorNew.Flags().StringP("note", "n", "", "description of the problem")
+		cmdAuthor.AddCommand(cmdAuthorNew)
+
+		cmdAuthorValidate := &cobra.Command{
+			Use:   "validate",
+			Short: "check a problem directory before uploading it",
+			Long: fmt.Sprintf("Checks the problem in the current directory without saving it:\n"+
+				"problem.cfg and the step directories, the instructions, file sizes,\n"+
+				"and that each step's solution passes its tests on a daycare.\n"+
+				"Use --json to get the problems found as a JSON array on stdout.\n\n"+
+				"   Example: '%s author validate --json'", os.Args[0]),
+			Run: CommandAuthorValidate,
+		}
+		cmdAuthorValidate.Flags().Bool("json", false, "report problems as JSON")
+		cmdAuthor.AddCommand(cmdAuthorValidate)
+
+		cmdAuthorMatrix := &cobra.Command{
+			Use:   "matrix",
+			Short: "grade each step's solution against the tests of later steps",
+			Long: fmt.Sprintf("Grades the solution to each step of the problem in the current\n"+
+				"directory against the tests for that step and every later step,\n"+
+				"then prints the results as a table. Each solution should pass its\n"+
+				"own step and is expected to fail the steps after it.\n\n"+
+				"   Example: '%s author matrix'", os.Args[0]),
+			Run: CommandAuthorMatrix,
+		}
+		cmdAuthor.AddCommand(cmdAuthorMatrix)
+
+		cmdAuthorPreview := &cobra.Command{
+			Use:   "preview [step]",
+			Short: "preview a problem's instructions in a web browser",
+			Long: fmt.Sprintf("Builds the instructions for the problem in the current directory\n"+
+				"the same way the server will and serves them on localhost. The page\n"+
+				"reloads whenever the files in the doc directory change.\n\n"+
+				"Translations go in doc/doc.<locale>.md or doc/doc.<locale>.html,\n"+
+				"such as doc/doc.es.md, and are previewed by adding ?locale=es to\n"+
+				"the address.\n\n"+
+				"   Example: '%s author preview 2'", os.Args[0]),
+			Run: CommandAuthorPreview,
+		}
+		cmdAuthorPreview.Flags().IntP("port", "p", 7483, "port to listen on")
+		cmdAuthor.AddCommand(cmdAuthorPreview)
+
+		cmdRelease := &cobra.Command{
+			Use:   "release <version> <os>/<arch> <grind binary>",
+			Short: "publish a grind binary for students to download (administrators only)",
+			Long: fmt.Sprintf("Uploads a build of grind to the server, where students can get it\n"+
+				"with '%s upgrade' or from the download link the server advertises.\n"+
+				"Give the signature made with the release signing key using --signature.\n\n"+
+				"   Example: '%s release %s windows/amd64 grind.exe --signature grind.exe.sig'",
+				os.Args[0], os.Args[0], CurrentVersion.Version),
+			Run: CommandRelease,
+		}
+		cmdRelease.Flags().StringP("signature", "s", "", "file containing the base64 ed25519 signature of the binary")
+		cmdGrind.AddCommand(cmdRelease)
 	}
 
-	cmdGrind.Execute()
+	if err := cmdGrind.Execute(); err != nil {
+		// cobra has already reported the error
+		os.Exit(exitUsage)
+	}
 }
 
 type LoginSession struct {
@@ -206,25 +741,40 @@ type LoginSession struct {
 }
 
 func CommandLogin(cmd *cobra.Command, args []string) {
-	if len(args) != 2 {
+	anonymous := cmd.Flag("anonymous").Value.String() == "true"
+	if len(args) != 1 && len(args) != 2 || anonymous && len(args) != 1 {
 		fmt.Printf("To log in, click on an assignment in Canvas and follow the\n"+
 			"instructions given. You should run a command of the form:\n\n"+
 			"%s login <hostname> <sessionkey>\n\n"+
 			"where <hostname> and <sessionkey> are given in the instructions.\n\n"+
-			"You should normally only need to do this once per semester.\n\n", os.Args[0])
+			"Or to approve the login from a browser, run:\n\n"+
+			"%s login <hostname>\n\n"+
+			"You should normally only need to do this once per semester.\n\n", os.Args[0], os.Args[0])
 
-		log.Fatalf("Usage: %s login <hostname> <sessionkey>", os.Args[0])
+		log.Fatalf("Usage: %s login <hostname> [sessionkey]", os.Args[0])
 	}
-	hostname, key := args[0], args[1]
-	Config.Host = hostname
+	Config.Host = args[0]
 
-	params := make(url.Values)
-	params.Add("key", key)
 	session := new(LoginSession)
-	mustGetObject("/users/session", params, session)
+	if len(args) == 2 {
+		params := make(url.Values)
+		params.Add("key", args[1])
+		mustGetObject("/users/session", params, session)
+	} else if cmd.Flag("password").Value.String() == "true" {
+		req := &AccountRequest{
+			Email:    prompt("Email: ", false),
+			Password: prompt("Password: ", true),
+		}
+		mustPostObject("/accounts/login", nil, req, session)
+	} else if anonymous {
+		mustPostObject("/practice/anonymous", nil, nil, session)
+	} else {
+		session.Cookie = loginDevice()
+	}
 
 	// set up config
 	Config.Cookie = session.Cookie
+	Config.Keyring = cmd.Flag("no-keyring").Value.String() != "true"
 
 	// see if they need an upgrade
 	checkVersion()
@@ -233,16 +783,115 @@ func CommandLogin(cmd *cobra.Command, args []string) {
 	user := new(User)
 	mustGetObject("/users/me", nil, user)
 
+	// make a key to sign commits from this login
+	registerClientKey()
+
 	// save config for later use
+	if Config.Keyring {
+		if err := saveSessionCookie(); err != nil {
+			log.Printf("unable to use the system keyring: %v", err)
+			log.Printf("  saving your session in ~/%s instead", perUserDotFile)
+			Config.Keyring = false
+		}
+	}
 	mustWriteConfig()
 
 	fmt.Printf("login successful; welcome %s\n", user.Name)
 }
 
+// loginDevice runs the OAuth2 device authorization flow, asking the user
+// to approve this login in a browser, and returns the session cookie.
+func loginDevice() string {
+	auth := new(DeviceAuthorization)
+	mustPostObject("/oauth/device", nil, nil, auth)
+	fmt.Printf("To log in, open this page in a browser where you are signed in to %s:\n\n", Config.Host)
+	fmt.Printf("    %s\n\n", auth.VerificationURIComplete)
+	fmt.Printf("and confirm that it shows the code %s\n\n", auth.UserCode)
+	fmt.Printf("waiting for approval...\n")
+
+	interval := time.Duration(auth.Interval) * time.Second
+	deadline := time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	form := url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {auth.DeviceCode},
+	}
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		resp, err := http.PostForm(fmt.Sprintf("https://%s%s/oauth/token", Config.Host, urlPrefix), form)
+		if err != nil {
+			log.Fatalf("error connecting to %s: %v", Config.Host, err)
+		}
+		token := new(DeviceToken)
+		err = json.NewDecoder(resp.Body).Decode(token)
+		resp.Body.Close()
+		if err != nil {
+			log.Fatalf("failed to parse token from server: %v", err)
+		}
+		switch {
+		case resp.StatusCode == http.StatusOK && token.AccessToken != "":
+			return token.AccessToken
+		case token.Error == "authorization_pending":
+		case token.Error == "slow_down":
+			interval += 5 * time.Second
+		default:
+			log.Fatalf("login failed: %s %s", resp.Status, token.Error)
+		}
+	}
+	log.Fatalf("login was not approved in time; please try again")
+	return ""
+}
+
+// prompt asks the user for a line of input, hiding it if secret is set.
+func prompt(msg string, secret bool) string {
+	fmt.Print(msg)
+	if fd, isTerminal := term.GetFdInfo(os.Stdin); secret && isTerminal {
+		state, err := term.SaveState(fd)
+		if err != nil {
+			log.Fatalf("error reading terminal state: %v", err)
+		}
+		if err := term.DisableEcho(fd, state); err != nil {
+			log.Fatalf("error disabling echo: %v", err)
+		}
+		defer fmt.Println()
+		defer term.RestoreTerminal(fd, state)
+	}
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		log.Fatalf("error reading input: %v", err)
+	}
+	return strings.TrimSpace(line)
+}
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
 func mustGetObject(path string, params url.Values, download interface{}) {
 	doRequest(path, params, "GET", nil, download, false)
 }
 
+// tryGetObject fetches a JSON object without giving up on errors,
+// returning the HTTP status code. It skips the response cache.
+func tryGetObject(path string, download interface{}) (int, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s%s%s", Config.Host, urlPrefix, path), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Add("Cookie", Config.Cookie)
+	req.Header.Add("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		apiErr := new(APIError)
+		if err := json.NewDecoder(resp.Body).Decode(apiErr); err == nil && apiErr.Code != "" {
+			return resp.StatusCode, apiErr
+		}
+		return resp.StatusCode, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(download)
+}
+
 func getObject(path string, params url.Values, download interface{}) bool {
 	return doRequest(path, params, "GET", nil, download, true)
 }
@@ -255,6 +904,10 @@ func mustPutObject(path string, params url.Values, upload interface{}, download
 	doRequest(path, params, "PUT", upload, download, false)
 }
 
+func mustDeleteObject(path string, params url.Values, download interface{}) {
+	doRequest(path, params, "DELETE", nil, download, false)
+}
+
 func doRequest(path string, params url.Values, method string, upload interface{}, download interface{}, notfoundokay bool) bool {
 	if !strings.HasPrefix(path, "/") {
 		log.Panicf("doRequest path must start with /")
@@ -281,15 +934,33 @@ func doRequest(path string, params url.Values, method string, upload interface{}
 	req.Header.Add("Cookie", Config.Cookie)
 	if download != nil {
 		req.Header.Add("Accept", "application/json")
-		req.Header.Add("Accept-Encoding", "gzip")
+		req.Header.Add("Accept-Encoding", "zstd, gzip")
+	}
+
+	// offer the cached copy, if any
+	var cached *cachedResponse
+	if method == "GET" && download != nil {
+		if cached = loadCachedResponse(req.URL.String()); cached != nil {
+			req.Header.Add("If-None-Match", cached.ETag)
+		}
 	}
 
 	// upload the payload if any
 	if upload != nil && (method == "POST" || method == "PUT") {
 		req.Header.Add("Content-Type", "application/json")
-		req.Header.Add("Content-Encoding", "gzip")
 		payload := new(bytes.Buffer)
-		gw := gzip.NewWriter(payload)
+		var gw io.WriteCloser
+		if Config.zstd {
+			req.Header.Add("Content-Encoding", "zstd")
+			zw, err := zstd.NewWriter(payload)
+			if err != nil {
+				log.Fatalf("doRequest: zstd error encoding object to upload: %v", err)
+			}
+			gw = zw
+		} else {
+			req.Header.Add("Content-Encoding", "gzip")
+			gw = gzip.NewWriter(payload)
+		}
 		uncompressed := new(bytes.Buffer)
 		var jsontarget io.Writer
 		if Config.apiDump {
@@ -302,7 +973,7 @@ func doRequest(path string, params url.Values, method string, upload interface{}
 			log.Fatalf("doRequest: JSON error encoding object to upload: %v", err)
 		}
 		if err := gw.Close(); err != nil {
-			log.Fatalf("doRequest: gzip error encoding object to upload: %v", err)
+			log.Fatalf("doRequest: compression error encoding object to upload: %v", err)
 		}
 		req.Body = ioutil.NopCloser(payload)
 
@@ -312,34 +983,72 @@ func doRequest(path string, params url.Values, method string, upload interface{}
 	}
 
 	resp, err := http.DefaultClient.Do(req)
+	if err != nil && cached != nil {
+		// work offline from the last copy we saw
+		log.Printf("unable to reach %s, using a saved copy of %s", Config.Host, path)
+		debugf("error was: %v", err)
+		if err := json.Unmarshal(cached.Body, download); err != nil {
+			log.Fatalf("failed to parse cached result object: %v", err)
+		}
+		return true
+	}
 	if err != nil {
-		log.Fatalf("error connecting to %s: %v", Config.Host, err)
+		fatalf(exitServer, "error connecting to %s: %v", Config.Host, err)
 	}
 	defer resp.Body.Close()
 	if notfoundokay && resp.StatusCode == http.StatusNotFound {
 		return false
 	}
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		if err := json.Unmarshal(cached.Body, download); err != nil {
+			log.Fatalf("failed to parse cached result object: %v", err)
+		}
+		return true
+	}
 	if resp.StatusCode != http.StatusOK {
 		log.Printf("unexpected status from %s: %s", url, resp.Status)
-		dumpBody(resp)
-		log.Fatalf("giving up")
+		apiErr := dumpBody(resp)
+		fatalf(exitCodeFor(resp, apiErr), "giving up")
 	}
 
 	// parse the result if any
 	if download != nil {
 		body := resp.Body
-		if resp.Header.Get("Content-Encoding") == "gzip" {
+		switch resp.Header.Get("Content-Encoding") {
+		case "gzip":
 			gz, err := gzip.NewReader(body)
 			if err != nil {
 				log.Fatalf("failed to decompress gzip result: %v", err)
 			}
 			body = gz
 			defer gz.Close()
+		case "zstd":
+			zr, err := zstd.NewReader(body)
+			if err != nil {
+				log.Fatalf("failed to decompress zstd result: %v", err)
+			}
+			body = zr.IOReadCloser()
+			defer zr.Close()
+			Config.zstd = true
 		}
-		decoder := json.NewDecoder(body)
-		if err := decoder.Decode(download); err != nil {
+		raw, err := ioutil.ReadAll(body)
+		if err != nil {
+			log.Fatalf("error reading result from server: %v", err)
+		}
+		if err := json.Unmarshal(raw, download); err != nil {
 			log.Fatalf("failed to parse result object from server: %v", err)
 		}
+		if etag := resp.Header.Get("ETag"); etagHash.MatchString(etag) {
+			// the server tags responses with a hash of their contents
+			sum := sha256.Sum256(raw)
+			if etag != `"`+hex.EncodeToString(sum[:16])+`"` {
+				log.Printf("the response from %s was damaged in transit", url)
+				log.Fatalf("please try again")
+			}
+		}
+		if etag := resp.Header.Get("ETag"); method == "GET" && etag != "" {
+			saveCachedResponse(req.URL.String(), etag, raw)
+		}
 
 		if Config.apiDump {
 			raw, err := json.MarshalIndent(download, "", "    ")
@@ -354,6 +1063,49 @@ func doRequest(path string, params url.Values, method string, upload interface{}
 	return false
 }
 
+// doRawRequest is like doRequest, but uploads and downloads raw bytes
+// instead of JSON objects. It is used for archive files.
+func doRawRequest(path string, params url.Values, method string, contentType string, upload []byte) []byte {
+	if !strings.HasPrefix(path, "/") {
+		log.Panicf("doRawRequest path must start with /")
+	}
+	url := fmt.Sprintf("https://%s%s%s", Config.Host, urlPrefix, path)
+	var body io.Reader
+	if upload != nil {
+		body = bytes.NewReader(upload)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		log.Fatalf("error creating http request: %v\n", err)
+	}
+	if params != nil && len(params) > 0 {
+		req.URL.RawQuery = params.Encode()
+	}
+	if Config.apiReport {
+		fmt.Printf("%s %s\n", method, req.URL)
+	}
+	req.Header.Add("Cookie", Config.Cookie)
+	if upload != nil {
+		req.Header.Add("Content-Type", contentType)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("error connecting to %s: %v", Config.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("unexpected status from %s: %s", url, resp.Status)
+		apiErr := dumpBody(resp)
+		fatalf(exitCodeFor(resp, apiErr), "giving up")
+	}
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("error reading response from %s: %v", url, err)
+	}
+	return raw
+}
+
 func courseDirectory(label string) string {
 	re := regexp.MustCompile(`^([A-Za-z]+[- ]*\d+\w*)\b`)
 	groups := re.FindStringSubmatch(label)
@@ -373,6 +1125,12 @@ func hasInstructorFile() bool {
 }
 
 func mustLoadConfig(cmd *cobra.Command) {
+	mustLoadConfigOffline(cmd)
+	checkVersion()
+}
+
+// mustLoadConfigOffline loads the config file without contacting the server.
+func mustLoadConfigOffline(cmd *cobra.Command) {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		log.Fatalf("unable to find home directory: %v", err)
@@ -383,16 +1141,20 @@ func mustLoadConfig(cmd *cobra.Command) {
 	configFile := filepath.Join(home, perUserDotFile)
 
 	if raw, err := ioutil.ReadFile(configFile); err != nil {
-		log.Fatalf("Unable to load config file; try running '%s login'\n", os.Args[0])
+		fatalf(exitAuth, "Unable to load config file; try running '%s login'\n", os.Args[0])
 	} else if err := json.Unmarshal(raw, &Config); err != nil {
 		log.Printf("failed to parse %s: %v", configFile, err)
 		log.Fatalf("you may wish to try deleting the file and running '%s login' again\n", os.Args[0])
 	}
+	if Config.Keyring {
+		if err := loadSessionCookie(); err != nil {
+			log.Printf("unable to read your session from the system keyring: %v", err)
+			log.Fatalf("try running '%s login' again, adding --no-keyring if this machine has no keyring", os.Args[0])
+		}
+	}
 	if Config.apiDump {
 		Config.apiReport = true
 	}
-
-	checkVersion()
 }
 
 func mustWriteConfig() {
@@ -405,13 +1167,17 @@ func mustWriteConfig() {
 	}
 	configFile := filepath.Join(home, perUserDotFile)
 
-	raw, err := json.MarshalIndent(&Config, "", "    ")
+	saved := Config
+	if saved.Keyring {
+		saved.Cookie = ""
+	}
+	raw, err := json.MarshalIndent(&saved, "", "    ")
 	if err != nil {
 		log.Fatalf("JSON error encoding cookie file: %v", err)
 	}
 	raw = append(raw, '\n')
 
-	if err = ioutil.WriteFile(configFile, raw, 0644); err != nil {
+	if err = ioutil.WriteFile(configFile, raw, 0600); err != nil {
 		log.Fatalf("error writing %s: %v", configFile, err)
 	}
 }
@@ -430,28 +1196,103 @@ func checkVersion() {
 	grindRequired := semver.MustParse(server.GrindVersionRequired)
 	if grindRequired.GT(grindCurrent) {
 		log.Printf("this is grind version %s, but the server requires %s or higher", CurrentVersion.Version, server.GrindVersionRequired)
-		log.Fatalf("  you must upgrade to continue")
+		if Config.AutoUpgrade && upgradeGrind() {
+			log.Fatalf("  please run the command again")
+		}
+		if server.GrindDownloadURL != "" {
+			log.Printf("  you can download it from %s/%s/%s", server.GrindDownloadURL, runtime.GOOS, runtime.GOARCH)
+		}
+		log.Fatalf("  you must upgrade to continue; run '%s upgrade'", os.Args[0])
+	}
+	if server.APIVersionMinimum > APIVersion {
+		log.Printf("this version of grind speaks API version %d, but the server requires %d or higher", APIVersion, server.APIVersionMinimum)
+		log.Fatalf("  you must upgrade to continue; run '%s upgrade'", os.Args[0])
 	}
 	grindRecommended := semver.MustParse(server.GrindVersionRecommended)
 	if grindRecommended.GT(grindCurrent) {
 		log.Printf("this is grind version %s, but the server recommends %s or higher", CurrentVersion.Version, server.GrindVersionRecommended)
-		log.Printf("  please upgrade as soon as possible")
+		if Config.AutoUpgrade && upgradeGrind() {
+			log.Printf("  the new version will be used from now on")
+			return
+		}
+		log.Printf("  please run '%s upgrade' as soon as possible", os.Args[0])
 	}
 }
 
-func dumpBody(resp *http.Response) {
+// dumpBody reports the body of a failed response. Errors from the server
+// are APIError objects, which are shown with a suggestion of what to do
+// where there is one, and returned. Anything else is copied to stderr.
+func dumpBody(resp *http.Response) *APIError {
 	if resp.Body == nil {
-		return
+		return nil
 	}
 
-	if resp.Header.Get("Content-Encoding") == "gzip" {
+	var body io.Reader = resp.Body
+	switch resp.Header.Get("Content-Encoding") {
+	case "gzip":
 		gz, err := gzip.NewReader(resp.Body)
 		if err != nil {
 			log.Fatalf("failed to decompress gzip result: %v", err)
 		}
 		defer gz.Close()
-		io.Copy(os.Stderr, gz)
-	} else {
-		io.Copy(os.Stderr, resp.Body)
+		body = gz
+	case "zstd":
+		zr, err := zstd.NewReader(resp.Body)
+		if err != nil {
+			log.Fatalf("failed to decompress zstd result: %v", err)
+		}
+		defer zr.Close()
+		body = zr
+	}
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		log.Printf("error reading response from server: %v", err)
+	}
+
+	apiErr := new(APIError)
+	if err := json.Unmarshal(raw, apiErr); err != nil || apiErr.Code == "" {
+		os.Stderr.Write(raw)
+		return nil
+	}
+	log.Printf("   %s", apiErr.Error())
+	if hint := apiErrorHint(apiErr); hint != "" {
+		log.Printf("   %s", hint)
+	}
+	debugf("error code %s, request ID %s", apiErr.Code, apiErr.RequestID)
+	return apiErr
+}
+
+// apiErrorHint suggests what to do about an error from the server.
+func apiErrorHint(apiErr *APIError) string {
+	switch apiErr.Code {
+	case ErrorSessionExpired:
+		return fmt.Sprintf("your session has expired; run '%s login' to log in again", os.Args[0])
+	case ErrorReadOnly:
+		return "you are viewing as another user, so nothing can be changed"
+	case ErrorLocked:
+		return "the assignment is closed; ask your instructor if you need more time"
+	case ErrorUpgrade:
+		return fmt.Sprintf("this version of grind is too old for the server; run '%s upgrade'", os.Args[0])
+	case ErrorUnsigned:
+		return fmt.Sprintf("run '%s login' again on this computer so grind can sign your work", os.Args[0])
+	case ErrorRateLimited:
+		return "wait a while and try again"
+	case ErrorUnavailable:
+		return "the server is busy or down for maintenance; try again in a few minutes"
+	case ErrorInternal:
+		return fmt.Sprintf("this is a problem with the server, not your work; if it continues, "+
+			"tell your instructor and include request ID %s", apiErr.RequestID)
+	}
+	return ""
+}
+
+// exitCodeFor picks the exit code after a failed request.
+func exitCodeFor(resp *http.Response, apiErr *APIError) int {
+	if apiErr != nil && apiErr.Code == ErrorSessionExpired {
+		return exitAuth
+	}
+	if apiErr == nil && resp.StatusCode == http.StatusUnauthorized {
+		return exitAuth
 	}
+	return exitServer
 }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// grind author matrix grades the solution to each step against the tests
+// of that step and of every later step. A solution should pass its own
+// step; passing a later step usually means the later tests are too weak,
+// or that the earlier solution does more than its step asks for.
+
+type matrixCell struct {
+	ran    bool
+	passed bool
+	score  float64
+	note   string
+}
+
+func CommandAuthorMatrix(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	now := time.Now()
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+
+	unsigned := gatherAuthorDryRun(now, ".")
+	user := new(User)
+	mustGetObject("/users/me", nil, user)
+	unsigned.UserID = user.ID
+	steps := len(unsigned.ProblemSteps)
+	if steps < 2 {
+		fmt.Println("warning: this problem has only one step, so the matrix only checks its solution")
+	}
+	solutions := unsigned.Commits
+
+	matrix := make([][]*matrixCell, steps)
+	for i := 0; i < steps; i++ {
+		matrix[i] = make([]*matrixCell, steps)
+		for j := range matrix[i] {
+			matrix[i][j] = new(matrixCell)
+		}
+
+		// submit solution i for every step from i on
+		bundle := *unsigned
+		bundle.Commits = make([]*Commit, steps)
+		for j := 0; j < steps; j++ {
+			if j < i {
+				bundle.Commits[j] = solutions[j]
+			} else {
+				bundle.Commits[j] = matrixCommit(unsigned.ProblemSteps, j, solutions[i])
+			}
+		}
+		signed := new(ProblemBundle)
+		mustPostObject("/problem_bundles/unconfirmed", nil, &bundle, signed)
+		if signed.Hostname == "" {
+			log.Fatalf("server was unable to find a suitable daycare, unable to run the solutions")
+		}
+
+		for j := i; j < steps; j++ {
+			fmt.Printf("grading the solution to step %d against the tests for step %d\n", i+1, j+1)
+			problemType := signed.ProblemSteps[j].ProblemType
+			unvalidated := &CommitBundle{
+				ProblemType:          signed.ProblemTypes[problemType],
+				ProblemTypeSignature: signed.ProblemTypeSignatures[problemType],
+				Problem:              signed.Problem,
+				ProblemSteps:         signed.ProblemSteps,
+				ProblemSignature:     signed.ProblemSignature,
+				Hostname:             signed.Hostname,
+				UserID:               signed.UserID,
+				Commit:               signed.Commits[j],
+				CommitSignature:      signed.CommitSignatures[j],
+			}
+			commit := mustConfirmCommitBundle(unvalidated, nil).Commit
+			cell := matrix[i][j]
+			cell.ran = true
+			cell.score = commit.Score
+			if commit.ReportCard != nil {
+				cell.passed = commit.ReportCard.Passed && commit.Score == 1.0
+				cell.note = commit.ReportCard.Note
+			}
+		}
+	}
+
+	// print the matrix with solutions as rows and tests as columns
+	fmt.Println()
+	fmt.Printf("%-12s", "")
+	for j := 0; j < steps; j++ {
+		fmt.Printf("%10s", fmt.Sprintf("tests %d", j+1))
+	}
+	fmt.Println()
+	for i := 0; i < steps; i++ {
+		fmt.Printf("%-12s", fmt.Sprintf("solution %d", i+1))
+		for j := 0; j < steps; j++ {
+			cell := matrix[i][j]
+			label := "-"
+			if cell.ran && cell.passed {
+				label = "pass"
+			} else if cell.ran {
+				label = fmt.Sprintf("%d%%", int(cell.score*100+0.5))
+			}
+			fmt.Printf("%10s", label)
+		}
+		fmt.Println()
+	}
+	fmt.Println()
+
+	// explain anything unexpected
+	failed := false
+	for i := 0; i < steps; i++ {
+		if cell := matrix[i][i]; !cell.passed {
+			failed = true
+			fmt.Printf("error: the solution to step %d fails its own tests: %s\n", i+1, strings.TrimSpace(cell.note))
+		}
+		for j := i + 1; j < steps; j++ {
+			if matrix[i][j].passed {
+				fmt.Printf("warning: the solution to step %d already passes the tests for step %d\n", i+1, j+1)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// matrixCommit submits a solution for step n, filling in the starter
+// version of any files the solution does not have yet.
+func matrixCommit(steps []*ProblemStep, n int, solution *Commit) *Commit {
+	commit := *solution
+	commit.Files = make(map[string][]byte)
+	for name := range steps[n].Whitelist {
+		if contents, exists := solution.Files[name]; exists {
+			commit.Files[name] = contents
+			continue
+		}
+		for k := n; k >= 0; k-- {
+			if contents, exists := steps[k].Files[name]; exists {
+				commit.Files[name] = contents
+				break
+			}
+		}
+	}
+	return &commit
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandNotifications(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+
+	prefs := new(NotificationPrefs)
+	mustGetObject("/users/me/notifications", nil, prefs)
+
+	flags := cmd.Flags()
+	changed := false
+	for _, elt := range []struct {
+		flag  string
+		value *bool
+	}{
+		{"deadlines", &prefs.DeadlineReminders},
+		{"regrades", &prefs.RegradeResults},
+		{"digest", &prefs.WeeklyDigest},
+	} {
+		if flags.Changed(elt.flag) {
+			*elt.value, _ = flags.GetBool(elt.flag)
+			changed = true
+		}
+	}
+	if changed {
+		updated := new(NotificationPrefs)
+		mustPutObject("/users/me/notifications", nil, prefs, updated)
+		prefs = updated
+	}
+
+	onOff := func(on bool) string {
+		if on {
+			return "on"
+		}
+		return "off"
+	}
+	fmt.Printf("deadline reminders: %s\n", onOff(prefs.DeadlineReminders))
+	fmt.Printf("regrade results:    %s\n", onOff(prefs.RegradeResults))
+	fmt.Printf("weekly digest:      %s\n", onOff(prefs.WeeklyDigest))
+	if !changed {
+		fmt.Printf("use '%s notifications --deadlines=false' (or --regrades, --digest) to change these\n", os.Args[0])
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandPractice(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) > 1 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+
+	problemSets := []*ProblemSet{}
+	mustGetObject("/public/problem_sets", nil, &problemSets)
+
+	if len(args) == 0 {
+		if len(problemSets) == 0 {
+			fmt.Println("there are no practice problem sets on this server")
+			return
+		}
+		for _, elt := range problemSets {
+			fmt.Printf("%s: %s\n", elt.Unique, elt.Note)
+		}
+		fmt.Printf("\nto start one, run '%s practice <problem set>'\n", os.Args[0])
+		return
+	}
+
+	var set *ProblemSet
+	for _, elt := range problemSets {
+		if elt.Unique == args[0] || strconv.FormatInt(elt.ID, 10) == args[0] {
+			set = elt
+		}
+	}
+	if set == nil {
+		log.Printf("no practice problem set found matching %q", args[0])
+		log.Fatalf("   run '%s practice' to see the practice problem sets", os.Args[0])
+	}
+
+	assignment := new(Assignment)
+	mustPostObject(fmt.Sprintf("/problem_sets/%d/practice", set.ID), nil, nil, assignment)
+	fmt.Printf("practice assignment %d: %s\n", assignment.ID, set.Note)
+	fmt.Printf("your score is not sent to any course\n")
+	fmt.Printf("to download it, run '%s get %d'\n", os.Args[0], assignment.ID)
+}
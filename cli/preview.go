@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+	"gopkg.in/gcfg.v1"
+)
+
+// grind author preview serves the instructions of each step on localhost,
+// built by the same code the server uses when a problem is created. The
+// page reloads itself whenever a file in the step's doc directory changes.
+// A translation is previewed by adding ?locale=<locale> to the address.
+
+// previewReload is added to every page; it polls for changes to the
+// instructions and reloads the page when they change
+const previewReload = `<script>
+(function() {
+	var stamp = null;
+	setInterval(function() {
+		var req = new XMLHttpRequest();
+		req.onload = function() {
+			if (req.status !== 200) return;
+			if (stamp !== null && stamp !== req.responseText) location.reload();
+			stamp = req.responseText;
+		};
+		req.open("GET", location.pathname.replace(/\/?$/, "/stamp"));
+		req.send();
+	}, 1000);
+})();
+</script>
+`
+
+func CommandAuthorPreview(cmd *cobra.Command, args []string) {
+	mustLoadConfigOffline(cmd)
+
+	if len(args) > 1 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	directory, stepDir := findProblemDir(".")
+	if directory == "" {
+		log.Printf("unable to find %s in current directory or one of its ancestors", ProblemConfigName)
+		log.Fatalf("   you must run this in a problem directory")
+	}
+	var cfg ConfigFile
+	if err := gcfg.ReadFileInto(&cfg, filepath.Join(directory, ProblemConfigName)); err != nil {
+		log.Fatalf("failed to parse %s: %v", filepath.Join(directory, ProblemConfigName), err)
+	}
+	p := &preview{directory: directory, single: len(cfg.Step) == 0}
+	if !p.single {
+		for name := range cfg.Step {
+			if n, err := strconv.Atoi(name); err == nil && n > 0 {
+				p.steps = append(p.steps, n)
+			}
+		}
+		sort.Ints(p.steps)
+	}
+
+	// start on the step given or the one we are in
+	start := "/"
+	if len(args) == 1 {
+		start += args[0]
+	} else if !p.single && stepDir != directory {
+		start += filepath.Base(stepDir)
+	}
+
+	addr := net.JoinHostPort("127.0.0.1", cmd.Flag("port").Value.String())
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("unable to listen on %s: %v", addr, err)
+	}
+	fmt.Printf("previewing %s at http://%s%s\n", cfg.Problem.Unique, listener.Addr(), start)
+	fmt.Println("the page reloads when the instructions change; press ctrl-c to stop")
+	log.Fatal(http.Serve(listener, localOnly(p)))
+}
+
+type preview struct {
+	directory string
+	single    bool
+	steps     []int
+}
+
+func (p *preview) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] == "" && !p.single {
+		p.serveIndex(w)
+		return
+	}
+
+	// find the step
+	dir := p.directory
+	if !p.single {
+		found := false
+		for _, n := range p.steps {
+			if strconv.Itoa(n) == parts[0] {
+				found = true
+			}
+		}
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		dir = filepath.Join(p.directory, parts[0])
+		parts = parts[1:]
+	} else if parts[0] == "" || parts[0] == "1" {
+		parts = parts[1:]
+	}
+
+	files, err := readDocFiles(dir)
+	switch {
+	case len(parts) == 1 && parts[0] == "stamp":
+		sum := sha256.New()
+		names := []string{}
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(sum, "%s\x00%d\x00", name, len(files[name]))
+			sum.Write(files[name])
+		}
+		if err != nil {
+			fmt.Fprintf(sum, "%v", err)
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprint(w, hex.EncodeToString(sum.Sum(nil)))
+	case len(parts) == 0:
+		var page string
+		if err == nil {
+			step := &ProblemStep{Files: files}
+			if page, step.Translations, err = step.BuildInstructions(); err == nil {
+				step.Instructions = page
+				page = step.LocalizedInstructions(r.URL.Query().Get("locale"))
+			}
+		}
+		if err != nil {
+			page = fmt.Sprintf("<html><head></head><body><h1>Error building instructions</h1>\n<pre>%s</pre></body></html>",
+				html.EscapeString(err.Error()))
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprint(w, addReload(page))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *preview) serveIndex(w http.ResponseWriter) {
+	page := new(bytes.Buffer)
+	fmt.Fprintf(page, "<html><head></head><body><h1>%s</h1>\n<ul>\n", html.EscapeString(filepath.Base(p.directory)))
+	for _, n := range p.steps {
+		fmt.Fprintf(page, "<li><a href=\"/%d\">step %d</a></li>\n", n, n)
+	}
+	fmt.Fprintf(page, "</ul></body></html>")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.Bytes())
+}
+
+// readDocFiles reads the files in a step's doc directory, named the way
+// grind create names them for the server.
+func readDocFiles(dir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "doc"))
+	if err != nil {
+		return files, err
+	}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		name := filepath.Join("doc", entry.Name())
+		contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return files, err
+		}
+		files[name] = contents
+	}
+	return files, nil
+}
+
+// addReload puts the reload script at the end of the page body.
+func addReload(page string) string {
+	if i := strings.LastIndex(page, "</body>"); i >= 0 {
+		return page[:i] + previewReload + page[i:]
+	}
+	return page + previewReload
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandRegrade(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+
+	// find the problem
+	problemID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		problems := []*Problem{}
+		mustGetObject("/problems", url.Values{"unique": {args[0]}}, &problems)
+		if len(problems) != 1 {
+			log.Fatalf("no problem found with unique ID %q", args[0])
+		}
+		problemID = problems[0].ID
+	}
+
+	params := make(url.Values)
+	if s := cmd.Flag("assignment").Value.String(); s != "" {
+		params.Add("assignment", s)
+	}
+	if s := cmd.Flag("user").Value.String(); s != "" {
+		params.Add("user", s)
+	}
+	job := new(BackgroundJob)
+	mustPostObject(fmt.Sprintf("/problems/%d/regrade", problemID), params, nil, job)
+
+	// follow the job until it finishes
+	for job.FinishedAt == nil {
+		infof("\rregrading: %d/%d done, %d failed", job.Done, job.Total, job.Failed)
+		time.Sleep(2 * time.Second)
+		next := new(BackgroundJob)
+		if _, err := tryGetObject(fmt.Sprintf("/problems/%d/regrade", problemID), next); err != nil {
+			log.Printf("\nerror checking the regrade status: %v", err)
+			continue
+		}
+		job = next
+	}
+	infof("\rregrading: %d/%d done, %d failed\n", job.Done, job.Total, job.Failed)
+	if job.Error != "" {
+		log.Fatalf("regrade finished with an error: %s", job.Error)
+	}
+	fmt.Printf("regraded %d submission%s in %v\n",
+		job.Done, plural(job.Done), job.FinishedAt.Sub(job.StartedAt).Round(time.Second))
+}
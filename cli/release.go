@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandRelease(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 3 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	platform := strings.SplitN(args[1], "/", 2)
+	if len(platform) != 2 || platform[0] == "" || platform[1] == "" {
+		log.Fatalf("platform must be given as <os>/<arch>, e.g., linux/amd64")
+	}
+	binary, err := ioutil.ReadFile(args[2])
+	if err != nil {
+		log.Fatalf("error reading %s: %v", args[2], err)
+	}
+	sum := sha256.Sum256(binary)
+	release := &GrindRelease{
+		Version: args[0],
+		OS:      platform[0],
+		Arch:    platform[1],
+		SHA256:  hex.EncodeToString(sum[:]),
+		Binary:  binary,
+	}
+	if name := cmd.Flag("signature").Value.String(); name != "" {
+		sig, err := ioutil.ReadFile(name)
+		if err != nil {
+			log.Fatalf("error reading %s: %v", name, err)
+		}
+		release.Signature = strings.TrimSpace(string(sig))
+	}
+
+	saved := new(GrindRelease)
+	mustPostObject("/grind/releases", nil, release, saved)
+	fmt.Printf("grind %s for %s/%s is available at %s\n", saved.Version, saved.OS, saved.Arch, saved.URL)
+	if saved.Signature == "" {
+		fmt.Println("warning: the release is not signed, so official builds of grind will not install it")
+	}
+}
@@ -25,9 +25,17 @@ func CommandReset(cmd *cobra.Command, args []string) {
 	info := dotfile.Problems[problem.Unique]
 
 	step := new(ProblemStep)
-	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, info.Step), nil, step)
+	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", info.StepsID(), info.Step), nil, step)
 
 	listed := make(map[string]struct{})
+	if cmd.Flag("all").Value.String() == "true" {
+		if len(args) != 0 {
+			log.Fatalf("give a list of files or --all, but not both")
+		}
+		for elt := range step.Whitelist {
+			listed[elt] = struct{}{}
+		}
+	}
 	for _, requested := range args {
 		// find this file in the whitelist
 		found := false
@@ -94,7 +102,9 @@ func CommandReset(cmd *cobra.Command, args []string) {
 	}
 
 	// update non-student files and student files that were selected
+	setWritable(problemDir, step.Whitelist, true)
 	updateFiles(problemDir, files, nil, true)
+	saveStarterFiles(problemDir, step)
 
 	if !found {
 		fmt.Println("no student files have been modified since the beginning of this step")
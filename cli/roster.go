@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandRoster(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	courseID := findInstructorCourse(args[0])
+	if cmd.Flag("sync").Value.String() == "true" {
+		syncRoster(courseID)
+	}
+	params := make(url.Values)
+	if section := cmd.Flag("section").Value.String(); section != "" {
+		params.Add("section", section)
+	}
+	roster := new(CourseRoster)
+	mustGetObject(fmt.Sprintf("/courses/%d/roster", courseID), params, roster)
+
+	// build the table, with a section column if the course has sections
+	sections := len(roster.Sections) > 0
+	header := []string{"Name", "Email", "Roles", "Last active"}
+	if sections {
+		header = append(header, "Section")
+	}
+	fixed := len(header)
+	for _, asst := range roster.Assignments {
+		header = append(header, asst.Title)
+	}
+	notStarted := cmd.Flag("not-started").Value.String() == "true"
+	var table [][]string
+	count := 0
+	for _, user := range roster.Users {
+		if notStarted && (user.Started || user.Instructor) {
+			continue
+		}
+		count++
+		active := ""
+		if user.LastActivity != nil {
+			active = user.LastActivity.Format("2006-01-02 15:04")
+		}
+		row := []string{user.Name, user.Email, user.Roles, active}
+		if sections {
+			row = append(row, user.Section)
+		}
+		for _, score := range user.Scores {
+			if score == nil {
+				row = append(row, "")
+			} else {
+				row = append(row, fmt.Sprintf("%.0f%%", *score*100.0))
+			}
+		}
+		table = append(table, row)
+	}
+
+	if cmd.Flag("csv").Value.String() == "true" {
+		out := csv.NewWriter(os.Stdout)
+		out.Write(header)
+		out.WriteAll(table)
+		if err := out.Error(); err != nil {
+			log.Fatalf("error writing CSV: %v", err)
+		}
+		return
+	}
+
+	fmt.Println(roster.Course.Name)
+	fmt.Println(dashes(len(roster.Course.Name)))
+	if len(roster.Assignments) > 0 {
+		// number the assignments to keep the table narrow
+		for i, asst := range roster.Assignments {
+			header[fixed+i] = "#" + strconv.Itoa(i+1)
+			fmt.Printf("#%d: %s\n", i+1, asst.Title)
+		}
+		fmt.Println()
+	}
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, table...) {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	for _, row := range append([][]string{header}, table...) {
+		var line []string
+		for i, cell := range row {
+			line = append(line, fmt.Sprintf("%-*s", widths[i], cell))
+		}
+		fmt.Println(strings.TrimRight(strings.Join(line, "  "), " "))
+	}
+	if notStarted {
+		fmt.Printf("\n%d student%s not started\n", count, plural(count))
+	} else {
+		fmt.Printf("\n%d user%s\n", count, plural(count))
+	}
+}
+
+// syncRoster asks the server to load the course roster from the LMS
+// and waits for it to finish.
+func syncRoster(courseID int64) {
+	path := fmt.Sprintf("/courses/%d/roster/sync", courseID)
+	job := new(BackgroundJob)
+	mustPostObject(path, nil, nil, job)
+	for job.FinishedAt == nil {
+		infof("\rsyncing roster: %d/%d", job.Done, job.Total)
+		time.Sleep(time.Second)
+		next := new(BackgroundJob)
+		if _, err := tryGetObject(path, next); err != nil {
+			log.Printf("\nerror checking the roster sync status: %v", err)
+			continue
+		}
+		job = next
+	}
+	infof("\rsyncing roster: %d/%d\n", job.Done, job.Total)
+	if job.Error != "" {
+		log.Fatalf("roster sync failed: %s", job.Error)
+	}
+}
+
+// findInstructorCourse finds a course by ID, label, or name
+// among the courses where the current user is an instructor.
+func findInstructorCourse(name string) int64 {
+	if id, err := strconv.ParseInt(name, 10, 64); err == nil {
+		return id
+	}
+	user := new(User)
+	mustGetObject("/users/me", nil, user)
+	assignments := []*Assignment{}
+	mustGetObject(fmt.Sprintf("/users/%d/assignments", user.ID), nil, &assignments)
+	matches := make(map[int64]*Course)
+	seen := make(map[int64]bool)
+	for _, asst := range assignments {
+		if !asst.Instructor || seen[asst.CourseID] {
+			continue
+		}
+		seen[asst.CourseID] = true
+		course := new(Course)
+		mustGetObject(fmt.Sprintf("/courses/%d", asst.CourseID), nil, course)
+		if strings.EqualFold(course.Label, name) || strings.EqualFold(course.Name, name) || courseDirectory(course.Label) == name {
+			matches[course.ID] = course
+		}
+	}
+	switch len(matches) {
+	case 0:
+		log.Fatalf("no course found that matches %q where you are an instructor", name)
+	case 1:
+		for id := range matches {
+			return id
+		}
+	}
+	log.Printf("more than one course matches %q:", name)
+	for _, course := range matches {
+		log.Printf("  %d: %s", course.ID, course.Name)
+	}
+	log.Fatalf("please use the numeric course ID")
+	return 0
+}
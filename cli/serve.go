@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// grind serve offers a small JSON API on localhost so editor plugins can
+// work with the problem in the current directory:
+//
+//	GET  /api/problem  the current problem and step, and whether grading is running
+//	POST /api/grade    start grading the current step
+//	GET  /api/output   stream the output of the current or most recent grading run
+//	GET  /api/report   the report card of the most recent commit for the current step
+//
+// Grading runs 'grind grade' as a separate process, so the output is the
+// same as on the command line.
+
+func CommandServe(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	if !inProblem(".") {
+		log.Fatalf("run '%s serve' in the directory of a problem", os.Args[0])
+	}
+	_, _, dir := findProblemInfo(".")
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		log.Fatalf("error finding absolute path of %s: %v", dir, err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatalf("unable to find the grind executable: %v", err)
+	}
+	s := &serveState{dir: dir, executable: executable, run: newServeRun()}
+	s.run.finish()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/problem", s.handleProblem)
+	mux.HandleFunc("/api/grade", s.handleGrade)
+	mux.HandleFunc("/api/output", s.handleOutput)
+	mux.HandleFunc("/api/report", s.handleReport)
+
+	addr := net.JoinHostPort("127.0.0.1", cmd.Flag("port").Value.String())
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("unable to listen on %s: %v", addr, err)
+	}
+	fmt.Printf("serving %s at http://%s/api/\n", dir, listener.Addr())
+	log.Fatal(http.Serve(listener, localOnly(mux)))
+}
+
+// localOnly turns away requests that did not come straight from this
+// machine, including those a web page tries to make through the browser.
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if (host != "127.0.0.1" && host != "localhost") || r.Header.Get("Origin") != "" {
+			serveError(w, http.StatusForbidden, "only local requests are accepted")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func serveJSON(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
+
+func serveError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	serveJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+type serveState struct {
+	sync.Mutex
+	dir        string
+	executable string
+	run        *serveRun
+}
+
+// serveRun collects the output of one grading run for any number of readers.
+type serveRun struct {
+	mu      sync.Mutex
+	changed *sync.Cond
+	output  bytes.Buffer
+	done    bool
+	started time.Time
+}
+
+func newServeRun() *serveRun {
+	run := &serveRun{started: time.Now()}
+	run.changed = sync.NewCond(&run.mu)
+	return run
+}
+
+func (run *serveRun) Write(data []byte) (int, error) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.output.Write(data)
+	run.changed.Broadcast()
+	return len(data), nil
+}
+
+func (run *serveRun) finish() {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.done = true
+	run.changed.Broadcast()
+}
+
+// next waits for output past offset, returning it and whether the run is over.
+func (run *serveRun) next(offset int) ([]byte, bool) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	for offset >= run.output.Len() && !run.done {
+		run.changed.Wait()
+	}
+	chunk := append([]byte{}, run.output.Bytes()[offset:]...)
+	return chunk, run.done && offset+len(chunk) >= run.output.Len()
+}
+
+func (s *serveState) current() *serveRun {
+	s.Lock()
+	defer s.Unlock()
+	return s.run
+}
+
+type serveProblem struct {
+	AssignmentID int64    `json:"assignmentID"`
+	ProblemID    int64    `json:"problemID"`
+	Problem      string   `json:"problem"`
+	Note         string   `json:"note"`
+	Step         int64    `json:"step"`
+	Steps        int      `json:"steps"`
+	ProblemType  string   `json:"problemType"`
+	Directory    string   `json:"directory"`
+	Files        []string `json:"files"`
+	Grading      bool     `json:"grading"`
+}
+
+// findProblem describes the problem being served, reporting any error to the client.
+func (s *serveState) findProblem(w http.ResponseWriter) (*serveProblem, bool) {
+	if !inProblem(s.dir) {
+		serveError(w, http.StatusConflict, "%s is no longer a problem directory", s.dir)
+		return nil, false
+	}
+	dotfile, info, _ := findProblemInfo(s.dir)
+	problem := new(Problem)
+	steps := []*ProblemStep{}
+	if status, err := tryGetObject(fmt.Sprintf("/problems/%d", info.ID), problem); err != nil {
+		serveError(w, http.StatusBadGateway, "error loading problem %d: %v (%d)", info.ID, err, status)
+		return nil, false
+	}
+	if status, err := tryGetObject(fmt.Sprintf("/problems/%d/steps", info.StepsID()), &steps); err != nil {
+		serveError(w, http.StatusBadGateway, "error loading steps: %v (%d)", err, status)
+		return nil, false
+	}
+	if info.Step < 1 || info.Step > int64(len(steps)) {
+		serveError(w, http.StatusConflict, "problem %s has no step %d", problem.Unique, info.Step)
+		return nil, false
+	}
+	step := steps[info.Step-1]
+	elt := &serveProblem{
+		AssignmentID: dotfile.AssignmentID,
+		ProblemID:    problem.ID,
+		Problem:      problem.Unique,
+		Note:         step.Note,
+		Step:         info.Step,
+		Steps:        len(steps),
+		ProblemType:  step.ProblemType,
+		Directory:    s.dir,
+		Files:        []string{},
+	}
+	for name := range step.Whitelist {
+		elt.Files = append(elt.Files, name)
+	}
+	sort.Strings(elt.Files)
+	run := s.current()
+	run.mu.Lock()
+	elt.Grading = !run.done
+	run.mu.Unlock()
+	return elt, true
+}
+
+func (s *serveState) handleProblem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		serveError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	if problem, ok := s.findProblem(w); ok {
+		serveJSON(w, http.StatusOK, problem)
+	}
+}
+
+func (s *serveState) handleGrade(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		serveError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.run.mu.Lock()
+	running := !s.run.done
+	s.run.mu.Unlock()
+	if running {
+		serveError(w, http.StatusConflict, "grading is already running")
+		return
+	}
+
+	run := newServeRun()
+	s.run = run
+	go func() {
+		cmd := exec.Command(s.executable, "grade")
+		cmd.Dir = s.dir
+		cmd.Stdout, cmd.Stderr = run, run
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(run, "\n%v\n", err)
+		}
+		run.finish()
+	}()
+	serveJSON(w, http.StatusAccepted, map[string]interface{}{"grading": true, "started": run.started})
+}
+
+func (s *serveState) handleOutput(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		serveError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	run := s.current()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	flusher, _ := w.(http.Flusher)
+	for offset := 0; ; {
+		chunk, done := run.next(offset)
+		if _, err := w.Write(chunk); err != nil {
+			// the client went away
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		offset += len(chunk)
+		if done {
+			return
+		}
+	}
+}
+
+func (s *serveState) handleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		serveError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	problem, ok := s.findProblem(w)
+	if !ok {
+		return
+	}
+	commit := new(Commit)
+	path := fmt.Sprintf("/assignments/%d/problems/%d/steps/%d/commits/last", problem.AssignmentID, problem.ProblemID, problem.Step)
+	status, err := tryGetObject(path, commit)
+	if status == http.StatusNotFound {
+		serveError(w, http.StatusNotFound, "nothing has been submitted for step %d yet", problem.Step)
+		return
+	} else if err != nil {
+		serveError(w, http.StatusBadGateway, "error loading the latest commit: %v", err)
+		return
+	}
+	serveJSON(w, http.StatusOK, map[string]interface{}{
+		"step":       commit.Step,
+		"score":      commit.Score,
+		"updatedAt":  commit.UpdatedAt,
+		"reportCard": commit.ReportCard,
+	})
+}
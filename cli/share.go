@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandShare(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	now := time.Now()
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	hours, err := cmd.Flags().GetInt64("hours")
+	if err != nil || hours < 1 || hours > ShareMaxHours {
+		log.Fatalf("--hours must be a number from 1 to %d", ShareMaxHours)
+	}
+
+	// get the user ID
+	user := new(User)
+	mustGetObject("/users/me", nil, user)
+
+	// save the current files so the link shows exactly what is here
+	_, problem, _, commit, _, _ := gatherStudent(now, ".")
+	commit.Action = ""
+	commit.Note = "grind share"
+	unsigned := &CommitBundle{
+		UserID: user.ID,
+		Commit: deltaCommit(commit),
+	}
+	signCommitBundle(unsigned)
+	signed := new(CommitBundle)
+	mustPostObject("/commit_bundles/unsigned", nil, unsigned, signed)
+
+	params := make(url.Values)
+	params.Add("hours", strconv.FormatInt(hours, 10))
+	share := new(CommitShare)
+	mustPostObject(fmt.Sprintf("/commits/%d/share", signed.Commit.ID), params, nil, share)
+
+	infof("anyone with this link can see your work on %s step %d until %s:\n\n",
+		problem.Unique, commit.Step, share.ExpiresAt.Local().Format("Jan 2 15:04"))
+	fmt.Println(share.URL)
+	infof("\nshare it only with people helping you, and follow your course's rules about sharing code\n")
+}
@@ -17,7 +17,7 @@ func CommandSolve(cmd *cobra.Command, args []string) {
 
 	if len(args) != 0 {
 		cmd.Help()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	// get the user ID
@@ -27,9 +27,10 @@ func CommandSolve(cmd *cobra.Command, args []string) {
 		log.Fatalf("you must be an author or admin to use this command")
 	}
 
-	_, problem, _, commit, _, problemDir := gatherStudent(now, ".")
+	_, problem, _, commit, dotfile, problemDir := gatherStudent(now, ".")
+	info := dotfile.Problems[problem.Unique]
 	step := new(ProblemStep)
-	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", problem.ID, commit.Step), nil, step)
+	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", info.StepsID(), commit.Step), nil, step)
 
 	if step.Solution == nil || len(step.Solution) == 0 {
 		log.Fatalf("no solution files found")
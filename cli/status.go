@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandStatus(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+
+	user := new(User)
+	mustGetObject("/users/me", nil, user)
+	assignments := []*Assignment{}
+	mustGetObject(fmt.Sprintf("/users/%d/assignments", user.ID), nil, &assignments)
+	assignments = filterOutQuizzes(assignments)
+
+	// report on the problem in this directory first
+	if inProblem(".") {
+		dotfile, info, _ := findProblemInfo(".")
+		problem := new(Problem)
+		mustGetObject(fmt.Sprintf("/problems/%d", info.ID), nil, problem)
+		steps := []*ProblemStep{}
+		mustGetObject(fmt.Sprintf("/problems/%d/steps", info.StepsID()), nil, &steps)
+		fmt.Printf("this problem: %s, step %d of %d\n", problem.Unique, info.Step, len(steps))
+		for _, asst := range assignments {
+			if asst.ID != dotfile.AssignmentID {
+				continue
+			}
+			fmt.Printf("  assignment: %s, score %.0f%%%s\n", asst.CanvasTitle, asst.Score*100.0, statusDue(asst))
+		}
+		commit := new(Commit)
+		if getObject(fmt.Sprintf("/assignments/%d/problems/%d/commits/last", dotfile.AssignmentID, info.ID), nil, commit) {
+			fmt.Printf("  last activity: %s\n", statusCommit(commit))
+		}
+		fmt.Println()
+	}
+
+	if len(assignments) == 0 {
+		log.Printf("no assignments found")
+		log.Fatalf("you must start each assignment through Canvas before you can access it here")
+	}
+
+	longestID, longestName := 1, 1
+	for _, asst := range assignments {
+		if n := len(strconv.FormatInt(asst.ID, 10)); n > longestID {
+			longestID = n
+		}
+		if n := len(asst.CanvasTitle); n > longestName {
+			longestName = n
+		}
+	}
+	var course *Course
+	for _, asst := range assignments {
+		if course == nil || asst.CourseID != course.ID {
+			if course != nil {
+				fmt.Println()
+			}
+			course = new(Course)
+			mustGetObject(fmt.Sprintf("/courses/%d", asst.CourseID), nil, course)
+			fmt.Println(course.Name)
+			fmt.Println(dashes(len(course.Name)))
+		}
+
+		// find the most recent work on any problem in the set
+		problemSetProblems := []*ProblemSetProblem{}
+		mustGetObject(fmt.Sprintf("/problem_sets/%d/problems", asst.ProblemSetID), nil, &problemSetProblems)
+		var last *Commit
+		for _, psp := range problemSetProblems {
+			commit := new(Commit)
+			if getObject(fmt.Sprintf("/assignments/%d/problems/%d/commits/last", asst.ID, psp.ProblemID), nil, commit) {
+				if last == nil || commit.UpdatedAt.After(last.UpdatedAt) {
+					last = commit
+				}
+			}
+		}
+		activity := "not started"
+		if last != nil {
+			activity = statusCommit(last)
+		}
+		fmt.Printf("id:%-*d %-*s %3.0f%%%s\n", longestID, asst.ID, longestName, asst.CanvasTitle, asst.Score*100.0, statusDue(asst))
+		fmt.Printf("   %*s %s\n", longestID, "", activity)
+	}
+}
+
+func statusDue(asst *Assignment) string {
+	if asst.DueAt == nil {
+		return ""
+	}
+	due := asst.DueAt.Local().Format("Mon Jan 2 15:04")
+	if asst.DueAt.Before(time.Now()) {
+		return ", was due " + due
+	}
+	return ", due " + due
+}
+
+func statusCommit(commit *Commit) string {
+	what := "saved"
+	if commit.Action != "" {
+		what = commit.Action
+	}
+	if commit.Action == "grade" && commit.ReportCard != nil {
+		what = fmt.Sprintf("graded %.0f%%", commit.Score*100.0)
+	}
+	parts := []string{
+		fmt.Sprintf("step %d", commit.Step),
+		what,
+		commit.UpdatedAt.Local().Format("Jan 2 15:04"),
+	}
+	return strings.Join(parts, ", ")
+}
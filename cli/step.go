@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandStep(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	now := time.Now()
+
+	if len(args) != 1 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	target, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || target < 1 {
+		log.Fatalf("step must be a positive number: %q", args[0])
+	}
+	readOnly := cmd.Flag("read-only").Value.String() == "true"
+	fresh := cmd.Flag("fresh").Value.String() == "true"
+
+	// get the user ID
+	user := new(User)
+	mustGetObject("/users/me", nil, user)
+
+	// save the current work before replacing it
+	oldType, problem, assignment, commit, dotfile, problemDir := gatherStudent(now, ".")
+	info := dotfile.Problems[problem.Unique]
+	commit.Action = ""
+	commit.Note = "grind step"
+	unsigned := &CommitBundle{
+		UserID: user.ID,
+		Commit: deltaCommit(commit),
+	}
+	signCommitBundle(unsigned)
+	signed := new(CommitBundle)
+	mustPostObject("/commit_bundles/unsigned", nil, unsigned, signed)
+
+	// find the furthest step reached
+	steps := []*ProblemStep{}
+	mustGetObject(fmt.Sprintf("/problems/%d/steps", info.StepsID()), nil, &steps)
+	commits := []*Commit{}
+	mustGetObject(fmt.Sprintf("/assignments/%d/problems/%d/commits/history", assignment.ID, problem.ID), nil, &commits)
+	reached := info.Step
+	for _, elt := range commits {
+		if elt.ReportCard != nil && elt.ReportCard.Passed && elt.Score == 1.0 && elt.Step+1 > reached {
+			reached = elt.Step + 1
+		}
+	}
+	if reached > int64(len(steps)) {
+		reached = int64(len(steps))
+	}
+	if target > int64(len(steps)) {
+		log.Fatalf("problem %s only has %d step%s", problem.Unique, len(steps), plural(len(steps)))
+	}
+	if target > reached {
+		log.Fatalf("you have not reached step %d yet; the furthest you can go is step %d", target, reached)
+	}
+	oldStep, newStep := steps[info.Step-1], steps[target-1]
+
+	newType := oldType
+	if newStep.ProblemType != oldType.Name {
+		newType = new(ProblemType)
+		mustGetObject(fmt.Sprintf("/problem_types/%s", newStep.ProblemType), nil, newType)
+	}
+
+	// start from the step files, then the student's work
+	files := make(map[string][]byte)
+	for name, contents := range newStep.Files {
+		files[filepath.FromSlash(name)] = contents
+	}
+	work := new(Commit)
+	found := !fresh && getObject(fmt.Sprintf("/assignments/%d/problems/%d/steps/%d/commits/last", assignment.ID, problem.ID, target), nil, work)
+	if !found && target > 1 {
+		// begin where the previous step ended, as when first advancing
+		if getObject(fmt.Sprintf("/assignments/%d/problems/%d/steps/%d/commits/last", assignment.ID, problem.ID, target-1), nil, work) {
+			for name, contents := range work.Files {
+				if _, exists := files[filepath.FromSlash(name)]; !exists {
+					files[filepath.FromSlash(name)] = contents
+				}
+			}
+		}
+	} else if found {
+		for name, contents := range work.Files {
+			files[filepath.FromSlash(name)] = contents
+		}
+	}
+	files[filepath.Join("doc", "index.html")] = []byte(newStep.Instructions)
+	for name, contents := range newType.Files {
+		files[filepath.FromSlash(name)] = contents
+	}
+
+	// files from the old step that do not belong to the new one are removed
+	oldFiles := make(map[string]struct{})
+	for name := range oldType.Files {
+		oldFiles[filepath.FromSlash(name)] = struct{}{}
+	}
+	for name := range oldStep.Files {
+		oldFiles[filepath.FromSlash(name)] = struct{}{}
+	}
+	for name := range commit.Files {
+		oldFiles[filepath.FromSlash(name)] = struct{}{}
+	}
+
+	setWritable(problemDir, oldStep.Whitelist, true)
+	updateFiles(problemDir, files, oldFiles, true)
+	saveStarterFiles(problemDir, newStep)
+	info.Step = target
+	saveDotFile(dotfile)
+
+	if readOnly {
+		setWritable(problemDir, newStep.Whitelist, false)
+		fmt.Printf("now viewing step %d of %d; your files are read-only\n", target, len(steps))
+	} else {
+		fmt.Printf("now on step %d of %d\n", target, len(steps))
+	}
+	if target < reached {
+		infof("use '%s step %d' to return to the furthest step you have reached\n", os.Args[0], reached)
+	}
+}
+
+// setWritable marks the student's files for a step as editable or read-only.
+func setWritable(directory string, whitelist map[string]bool, writable bool) {
+	mode := os.FileMode(0444)
+	if writable {
+		mode = 0644
+	}
+	for name := range whitelist {
+		path := filepath.Join(directory, filepath.FromSlash(name))
+		if err := os.Chmod(path, mode); err != nil && !os.IsNotExist(err) {
+			log.Printf("error changing permissions of %s: %v", name, err)
+		}
+	}
+}
@@ -15,22 +15,29 @@ func CommandSync(cmd *cobra.Command, args []string) {
 
 	if len(args) != 0 {
 		cmd.Help()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	// get the user ID
 	user := new(User)
 	mustGetObject("/users/me", nil, user)
 
+	// bring in any changes to the starter files first
+	_, info, problemDir := findProblemInfo(".")
+	step := new(ProblemStep)
+	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", info.StepsID(), info.Step), nil, step)
+	mergeStarterFiles(problemDir, step)
+
 	_, problem, _, commit, _, _ := gatherStudent(now, ".")
 	commit.Action = ""
 	commit.Note = "grind sync"
 	unsigned := &CommitBundle{
 		UserID: user.ID,
-		Commit: commit,
+		Commit: deltaCommit(commit),
 	}
 
 	// send the commit to the server
+	signCommitBundle(unsigned)
 	signed := new(CommitBundle)
 	mustPostObject("/commit_bundles/unsigned", nil, unsigned, signed)
 	fmt.Printf("problem %s step %d synced\n", problem.Unique, commit.Step)
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+func CommandTrack(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) > 1 || (len(args) == 1 && args[0] != "on" && args[0] != "off") {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	dotfile, _, _ := findProblemInfo(".")
+	assignment := new(Assignment)
+	mustGetObject(fmt.Sprintf("/assignments/%d", dotfile.AssignmentID), nil, assignment)
+	course := new(Course)
+	mustGetObject(fmt.Sprintf("/courses/%d", assignment.CourseID), nil, course)
+
+	if len(args) == 1 {
+		if Config.TrackTime == nil {
+			Config.TrackTime = make(map[int64]bool)
+		}
+		if args[0] == "on" {
+			Config.TrackTime[course.ID] = true
+		} else {
+			delete(Config.TrackTime, course.ID)
+		}
+		mustWriteConfig()
+	}
+
+	if Config.TrackTime[course.ID] {
+		fmt.Printf("time tracking is on for %s\n", course.Name)
+		fmt.Printf("use '%s track off' to stop reporting your time\n", os.Args[0])
+	} else {
+		fmt.Printf("time tracking is off for %s\n", course.Name)
+		if len(args) == 0 {
+			fmt.Printf("use '%s track on' to report how long you spend on each step\n", os.Args[0])
+		}
+	}
+	if len(args) == 1 && args[0] == "off" {
+		fmt.Println("times already reported with earlier commits are kept by the server")
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+const tuiHelp = "[::b]g[::-] grade  [::b]r[::-] run  [::b]s[::-] sync  [::b]n[::-] next problem  " +
+	"[::b]l[::-] reload  [::b]tab[::-] switch pane  [::b]q[::-] quit"
+
+// tuiState is the full-screen interface. Grading runs "grind grade" as a
+// child process with its output streamed into the output pane; anything
+// that needs the terminal or could fail fatally (running a program,
+// reloading from the server) suspends the interface while it runs.
+type tuiState struct {
+	app         *tview.Application
+	assignments *tview.List
+	problem     *tview.TextView
+	output      *tview.TextView
+	dir         string
+	busy        bool
+}
+
+func CommandTUI(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatalf("unable to find the grind executable: %v", err)
+	}
+
+	s := &tuiState{
+		app:         tview.NewApplication(),
+		assignments: tview.NewList(),
+		problem:     tview.NewTextView(),
+		output:      tview.NewTextView(),
+		dir:         ".",
+	}
+	s.assignments.SetBorder(true).SetTitle(" Assignments ")
+	s.problem.SetDynamicColors(true).SetScrollable(true).SetBorder(true).SetTitle(" Problem ")
+	s.output.SetDynamicColors(true).SetScrollable(true).SetBorder(true).SetTitle(" Output ")
+	s.output.SetChangedFunc(func() { s.app.Draw() })
+	help := tview.NewTextView().SetDynamicColors(true).SetText(tuiHelp)
+
+	right := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(s.problem, 0, 1, false).
+		AddItem(s.output, 0, 2, false)
+	main := tview.NewFlex().
+		AddItem(s.assignments, 0, 1, true).
+		AddItem(right, 0, 2, false)
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(main, 0, 1, true).
+		AddItem(help, 1, 0, false)
+	panes := []tview.Primitive{s.assignments, s.problem, s.output}
+
+	s.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyTab {
+			for i, pane := range panes {
+				if pane.HasFocus() {
+					s.app.SetFocus(panes[(i+1)%len(panes)])
+					return nil
+				}
+			}
+			s.app.SetFocus(panes[0])
+			return nil
+		}
+		if event.Key() != tcell.KeyRune {
+			return event
+		}
+		switch event.Rune() {
+		case 'q':
+			s.app.Stop()
+		case 'g':
+			s.grade(executable)
+		case 'r':
+			s.interactive(executable, "action", "run")
+		case 's':
+			s.stream(executable, "sync")
+		case 'n':
+			s.nextProblem()
+		case 'l':
+			s.app.Suspend(s.load)
+		default:
+			return event
+		}
+		return nil
+	})
+
+	s.load()
+	if err := s.app.SetRoot(layout, true).Run(); err != nil {
+		log.Fatalf("terminal error: %v", err)
+	}
+}
+
+// load fetches the assignment list and the current problem from the server.
+func (s *tuiState) load() {
+	user := new(User)
+	mustGetObject("/users/me", nil, user)
+	assignments := []*Assignment{}
+	mustGetObject(fmt.Sprintf("/users/%d/assignments", user.ID), nil, &assignments)
+
+	courses := make(map[int64]*Course)
+	current := s.assignments.GetCurrentItem()
+	s.assignments.Clear()
+	for _, asst := range assignments {
+		course := courses[asst.CourseID]
+		if course == nil {
+			course = new(Course)
+			mustGetObject(fmt.Sprintf("/courses/%d", asst.CourseID), nil, course)
+			courses[asst.CourseID] = course
+		}
+		secondary := fmt.Sprintf("%s  %3.0f%%", course.Name, asst.Score*100.0)
+		if asst.DueAt != nil {
+			secondary += "  due " + asst.DueAt.Local().Format("Jan 2 15:04")
+		}
+		s.assignments.AddItem(asst.CanvasTitle, secondary, 0, nil)
+	}
+	if current < s.assignments.GetItemCount() {
+		s.assignments.SetCurrentItem(current)
+	}
+
+	s.problem.SetText(s.describeProblem())
+	s.problem.ScrollToBeginning()
+}
+
+func (s *tuiState) describeProblem() string {
+	if !inProblem(s.dir) {
+		return fmt.Sprintf("Start '%s tui' in a problem directory to see its steps\nand grade it from here.", os.Args[0])
+	}
+	dotfile, info, _ := findProblemInfo(s.dir)
+	problem := new(Problem)
+	mustGetObject(fmt.Sprintf("/problems/%d", info.ID), nil, problem)
+	steps := []*ProblemStep{}
+	mustGetObject(fmt.Sprintf("/problems/%d/steps", info.StepsID()), nil, &steps)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[::b]%s[::-]", tview.Escape(problem.Unique))
+	if problem.Note != "" {
+		fmt.Fprintf(&b, ": %s", tview.Escape(problem.Note))
+	}
+	b.WriteString("\n\n")
+	for _, step := range steps {
+		mark := "  "
+		switch {
+		case step.Step < info.Step:
+			mark = "[green]✓[-] "
+		case step.Step == info.Step:
+			mark = "[yellow]▶[-] "
+		}
+		fmt.Fprintf(&b, "%sstep %d: %s\n", mark, step.Step, tview.Escape(step.Note))
+	}
+
+	// show the report card from the most recent grading
+	commits := []*Commit{}
+	mustGetObject(fmt.Sprintf("/assignments/%d/problems/%d/commits/history", dotfile.AssignmentID, info.ID), nil, &commits)
+	var graded *Commit
+	for _, commit := range commits {
+		if commit.ReportCard != nil && commit.Action == "grade" && (graded == nil || commit.UpdatedAt.After(graded.UpdatedAt)) {
+			graded = commit
+		}
+	}
+	if graded == nil {
+		b.WriteString("\nnot graded yet\n")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "\n[::b]last graded[::-] step %d at %s: %3.0f%%\n",
+		graded.Step, graded.UpdatedAt.Local().Format("Jan 2 15:04"), graded.Score*100.0)
+	if graded.ReportCard.Note != "" {
+		fmt.Fprintf(&b, "%s\n", tview.Escape(graded.ReportCard.Note))
+	}
+	for _, result := range graded.ReportCard.Results {
+		color := "red"
+		if result.Passing() {
+			color = "green"
+		}
+		fmt.Fprintf(&b, "  [%s]%-8s[-] %s\n", color, result.Outcome, tview.Escape(result.Name))
+	}
+	return b.String()
+}
+
+// stream runs a grind command in the current problem directory with its
+// output shown in the output pane, then reloads.
+func (s *tuiState) stream(executable string, args ...string) {
+	if s.busy || !inProblem(s.dir) {
+		return
+	}
+	s.busy = true
+	s.output.Clear()
+	w := tview.ANSIWriter(s.output)
+	fmt.Fprintf(w, "$ %s %s\n", os.Args[0], strings.Join(args, " "))
+	go func() {
+		cmd := exec.Command(executable, args...)
+		cmd.Dir = s.dir
+		cmd.Stdout, cmd.Stderr = w, w
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(w, "\n%v\n", err)
+		}
+		s.app.QueueUpdateDraw(func() {
+			s.busy = false
+			s.app.Suspend(s.load)
+		})
+	}()
+}
+
+func (s *tuiState) grade(executable string) {
+	s.stream(executable, "grade")
+}
+
+// interactive runs a grind command with the terminal to itself.
+func (s *tuiState) interactive(executable string, args ...string) {
+	if s.busy || !inProblem(s.dir) {
+		return
+	}
+	s.app.Suspend(func() {
+		cmd := exec.Command(executable, args...)
+		cmd.Dir = s.dir
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Printf("%v\n", err)
+		}
+		fmt.Printf("press Enter to return to %s tui", os.Args[0])
+		bufio.NewReader(os.Stdin).ReadString('\n')
+	})
+}
+
+// nextProblem moves to the next problem in the problem set, if any.
+func (s *tuiState) nextProblem() {
+	if s.busy || !inProblemSet(s.dir) {
+		return
+	}
+	dotfile, problemSetDir, problemDir := findDotFile(s.dir)
+	if len(dotfile.Problems) < 2 {
+		return
+	}
+	var uniques []string
+	for unique := range dotfile.Problems {
+		uniques = append(uniques, unique)
+	}
+	sort.Strings(uniques)
+	_, current := filepath.Split(problemDir)
+	next := uniques[0]
+	for i, unique := range uniques {
+		if unique == current && i+1 < len(uniques) {
+			next = uniques[i+1]
+		}
+	}
+	s.dir = filepath.Join(problemSetDir, next)
+	s.app.Suspend(s.load)
+}
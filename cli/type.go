@@ -68,7 +68,7 @@ func CommandType(cmd *cobra.Command, args []string) {
 		problemTypeName = args[0]
 	} else {
 		cmd.Help()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
 	// download files for the given problem type
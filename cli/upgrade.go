@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/blang/semver"
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// releaseKey is the base64 ed25519 public key that grind releases are
+// signed with. Official builds set it with
+//
+//	go build -ldflags "-X main.releaseKey=<key>"
+//
+// and refuse to install an upgrade without a valid signature.
+// A copy of grind built without one never replaces itself.
+var releaseKey string
+
+const noReleaseKey = "this copy of grind was built without a release key, so it cannot check an upgrade and will not install one"
+
+func CommandUpgrade(cmd *cobra.Command, args []string) {
+	mustLoadConfigOffline(cmd)
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	auto, noAuto := cmd.Flag("auto").Value.String() == "true", cmd.Flag("no-auto").Value.String() == "true"
+	if auto && noAuto {
+		log.Fatalf("give --auto or --no-auto, but not both")
+	}
+	if auto && releaseKey == "" {
+		log.Fatalf("%s", noReleaseKey)
+	}
+	if auto || noAuto {
+		Config.AutoUpgrade = auto
+		mustWriteConfig()
+		if auto {
+			fmt.Println("grind will upgrade itself when the server recommends a newer version")
+		} else {
+			fmt.Println("grind will no longer upgrade itself automatically")
+		}
+	}
+
+	if releaseKey == "" {
+		if noAuto {
+			return
+		}
+		log.Printf("%s", noReleaseKey)
+		log.Fatalf("  please download the new version of grind yourself")
+	}
+	if !upgradeGrind() {
+		fmt.Printf("grind %s is the latest version available\n", CurrentVersion.Version)
+	}
+}
+
+// upgradeGrind replaces the running executable with the newest release
+// for this platform, reporting whether an upgrade was installed.
+func upgradeGrind() bool {
+	if releaseKey == "" {
+		log.Printf("%s", noReleaseKey)
+		return false
+	}
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		log.Fatalf("unable to find the grind executable: %v", err)
+	}
+
+	// clean up after an earlier upgrade on windows
+	os.Remove(exe + ".old")
+
+	params := make(url.Values)
+	params.Add("os", runtime.GOOS)
+	params.Add("arch", runtime.GOARCH)
+	releases := []*GrindRelease{}
+	mustGetObject("/grind/releases", params, &releases)
+	if len(releases) == 0 {
+		log.Printf("the server does not offer grind for %s/%s", runtime.GOOS, runtime.GOARCH)
+		return false
+	}
+	release := releases[0]
+	if !semver.MustParse(release.Version).GT(semver.MustParse(CurrentVersion.Version)) {
+		return false
+	}
+
+	fmt.Printf("downloading grind %s for %s/%s\n", release.Version, release.OS, release.Arch)
+	resp, err := http.Get(release.URL)
+	if err != nil {
+		log.Fatalf("error connecting to %s: %v", Config.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("unexpected status from %s: %s", release.URL, resp.Status)
+		dumpBody(resp)
+		log.Fatalf("giving up")
+	}
+	binary, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("error downloading grind %s: %v", release.Version, err)
+	}
+
+	// make sure it is the release the server described
+	sum := sha256.Sum256(binary)
+	if int64(len(binary)) != release.Size || hex.EncodeToString(sum[:]) != release.SHA256 {
+		log.Fatalf("the download of grind %s was damaged; please try again", release.Version)
+	}
+	key, err := base64.StdEncoding.DecodeString(releaseKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		log.Fatalf("the release key built into this copy of grind is not valid")
+	}
+	sig, err := base64.StdEncoding.DecodeString(release.Signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), binary, sig) {
+		log.Printf("grind %s is not signed by the release key", release.Version)
+		log.Fatalf("  refusing to install it; please tell your instructor")
+	}
+
+	replaceExecutable(exe, binary)
+	fmt.Printf("upgraded grind from %s to %s\n", CurrentVersion.Version, release.Version)
+	return true
+}
+
+// replaceExecutable installs a new binary in place of the running one.
+// The new file is written next to the old one and renamed into place,
+// so an interrupted upgrade never leaves a broken grind behind.
+func replaceExecutable(exe string, binary []byte) {
+	mode := os.FileMode(0755)
+	if info, err := os.Stat(exe); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp := exe + ".new"
+	if err := ioutil.WriteFile(tmp, binary, mode); err != nil {
+		log.Printf("error saving the new version of grind: %v", err)
+		log.Fatalf("  you may need to run this command as an administrator")
+	}
+	if written, err := ioutil.ReadFile(tmp); err != nil || !bytes.Equal(written, binary) {
+		os.Remove(tmp)
+		log.Fatalf("the new version of grind was not saved correctly; check that your disk is not full")
+	}
+
+	if runtime.GOOS == "windows" {
+		// windows will not replace a running program, but it will rename one
+		old := exe + ".old"
+		if err := os.Rename(exe, old); err != nil {
+			os.Remove(tmp)
+			log.Fatalf("error moving the old version of grind aside: %v", err)
+		}
+		if err := os.Rename(tmp, exe); err != nil {
+			os.Rename(old, exe)
+			os.Remove(tmp)
+			log.Fatalf("error installing the new version of grind: %v", err)
+		}
+		return
+	}
+	if err := os.Rename(tmp, exe); err != nil {
+		os.Remove(tmp)
+		log.Fatalf("error installing the new version of grind: %v", err)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+	"gopkg.in/gcfg.v1"
+)
+
+// grind author validate checks a problem directory the way grind create
+// would, including a dry run of every step's solution on a daycare, but
+// never saves anything. With --json the problems found are written to
+// stdout as a JSON array and everything else goes to stderr.
+
+// ValidationIssue is a single problem found while validating a problem directory.
+type ValidationIssue struct {
+	Severity string `json:"severity"`
+	Step     int64  `json:"step,omitempty"`
+	File     string `json:"file,omitempty"`
+	Message  string `json:"message"`
+}
+
+type validator struct {
+	issues []*ValidationIssue
+}
+
+func (v *validator) add(severity string, step int64, file, format string, args ...interface{}) {
+	v.issues = append(v.issues, &ValidationIssue{
+		Severity: severity,
+		Step:     step,
+		File:     file,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) errorf(step int64, file, format string, args ...interface{}) {
+	v.add("error", step, file, format, args...)
+}
+
+func (v *validator) warnf(step int64, file, format string, args ...interface{}) {
+	v.add("warning", step, file, format, args...)
+}
+
+func (v *validator) failed() bool {
+	for _, issue := range v.issues {
+		if issue.Severity == "error" {
+			return true
+		}
+	}
+	return false
+}
+
+func CommandAuthorValidate(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+	now := time.Now()
+
+	if len(args) != 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+
+	// keep stdout clean for the report
+	asJSON := cmd.Flag("json").Value.String() == "true"
+	stdout := os.Stdout
+	if asJSON {
+		os.Stdout = os.Stderr
+	}
+
+	v := new(validator)
+	directory, _ := findProblemDir(".")
+	if directory == "" {
+		log.Printf("unable to find %s in current directory or one of its ancestors", ProblemConfigName)
+		log.Fatalf("   you must run this in a problem directory")
+	}
+
+	// these would stop grind create before it looked at any files
+	v.checkConfig(directory)
+	if !v.failed() {
+		v.checkBundle(now, directory)
+	}
+
+	if asJSON {
+		os.Stdout = stdout
+		if v.issues == nil {
+			v.issues = []*ValidationIssue{}
+		}
+		raw, err := json.MarshalIndent(v.issues, "", "    ")
+		if err != nil {
+			log.Fatalf("JSON error encoding report: %v", err)
+		}
+		fmt.Printf("%s\n", raw)
+	} else {
+		for _, issue := range v.issues {
+			where := ""
+			if issue.Step > 0 {
+				where += fmt.Sprintf("step %d: ", issue.Step)
+			}
+			if issue.File != "" {
+				where += issue.File + ": "
+			}
+			fmt.Printf("%s: %s%s\n", issue.Severity, where, issue.Message)
+		}
+		if !v.failed() {
+			fmt.Println("problem is ready to upload")
+		}
+	}
+	if v.failed() {
+		os.Exit(1)
+	}
+}
+
+// checkConfig looks for mistakes in problem.cfg and the step layout.
+func (v *validator) checkConfig(directory string) {
+	var cfg ConfigFile
+	if err := gcfg.ReadFileInto(&cfg, filepath.Join(directory, ProblemConfigName)); err != nil {
+		v.errorf(0, ProblemConfigName, "%v", err)
+		return
+	}
+	unique := strings.TrimSpace(cfg.Problem.Unique)
+	switch {
+	case unique == "":
+		v.errorf(0, ProblemConfigName, "missing unique ID")
+	case url.QueryEscape(unique) != unique:
+		v.errorf(0, ProblemConfigName, "unique ID %q is not URL friendly", unique)
+	case filepath.Base(directory) != unique:
+		v.errorf(0, "", "directory name %q does not match the unique ID %q", filepath.Base(directory), unique)
+	}
+	if strings.TrimSpace(cfg.Problem.Note) == "" {
+		v.errorf(0, ProblemConfigName, "missing note for the problem")
+	}
+
+	// single-step problems keep the step files with problem.cfg
+	if len(cfg.Step) == 0 {
+		if cfg.Problem.Type == "" {
+			v.errorf(0, ProblemConfigName, "missing problem type")
+		}
+		if info, err := os.Stat(filepath.Join(directory, "1")); err == nil && info.IsDir() {
+			v.errorf(0, "1", "a single-step problem must keep its files in the problem directory, not in a directory named 1")
+		}
+		return
+	}
+
+	// steps must be numbered 1, 2, 3, ...
+	var numbers []int
+	for name, step := range cfg.Step {
+		n, err := strconv.Atoi(name)
+		if err != nil || n < 1 || strconv.Itoa(n) != name {
+			v.errorf(0, ProblemConfigName, "step %q must be named with a positive number", name)
+			continue
+		}
+		numbers = append(numbers, n)
+		if (step.Type == "") == (cfg.Problem.Type == "") {
+			v.errorf(int64(n), ProblemConfigName, "problem type must be given for the problem as a whole or for each step, but not both")
+		}
+		if strings.TrimSpace(step.Note) == "" {
+			v.errorf(int64(n), ProblemConfigName, "missing note for step %d", n)
+		}
+		if step.Weight < 0.0 {
+			v.errorf(int64(n), ProblemConfigName, "step weight cannot be negative")
+		}
+	}
+	sort.Ints(numbers)
+	for i, n := range numbers {
+		if n != i+1 {
+			v.errorf(int64(i+1), ProblemConfigName, "steps must be numbered consecutively starting at 1, but step %d is missing", i+1)
+			break
+		}
+	}
+
+	// the step directories must match
+	for _, n := range numbers {
+		info, err := os.Stat(filepath.Join(directory, strconv.Itoa(n)))
+		if err != nil || !info.IsDir() {
+			v.errorf(int64(n), strconv.Itoa(n), "missing directory for step %d", n)
+		}
+	}
+	entries, err := ioutil.ReadDir(directory)
+	if err != nil {
+		v.errorf(0, "", "error reading problem directory: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if n, err := strconv.Atoi(entry.Name()); err == nil && entry.IsDir() && cfg.Step[entry.Name()] == nil {
+			v.warnf(int64(n), entry.Name(), "directory %s is not listed as a step in %s and will be ignored", entry.Name(), ProblemConfigName)
+		}
+	}
+}
+
+// checkBundle gathers the problem the same way grind create does, checks
+// the files and instructions, then has a daycare grade each step's solution.
+func (v *validator) checkBundle(now time.Time, directory string) {
+	unsigned := gatherAuthorDryRun(now, directory)
+
+	// build the instructions for every step so all failures are reported
+	for _, step := range unsigned.ProblemSteps {
+		if _, _, err := step.BuildInstructions(); err != nil {
+			v.errorf(step.Step, "doc", "instructions: %v", err)
+		}
+		if len(step.Whitelist) == 0 {
+			v.errorf(step.Step, "", "no files for the student to edit")
+		}
+	}
+	if v.failed() {
+		return
+	}
+	if err := unsigned.Problem.Normalize(now, unsigned.ProblemSteps); err != nil {
+		v.errorf(0, "", "%v", err)
+		return
+	}
+
+	user := new(User)
+	mustGetObject("/users/me", nil, user)
+	unsigned.UserID = user.ID
+	signed := new(ProblemBundle)
+	mustPostObject("/problem_bundles/unconfirmed", nil, unsigned, signed)
+
+	// files cannot be larger than the sandbox allows
+	for n, step := range signed.ProblemSteps {
+		limit := maxFileSize(signed.ProblemTypes[step.ProblemType], signed.Problem)
+		if limit <= 0 {
+			continue
+		}
+		check := func(name string, contents []byte) {
+			if int64(len(contents)) > limit*1024*1024 {
+				v.errorf(step.Step, name, "file is %d bytes, but the limit for problem type %s is %d MB", len(contents), step.ProblemType, limit)
+			}
+		}
+		for name, contents := range step.Files {
+			check(name, contents)
+		}
+		for name, contents := range signed.Commits[n].Files {
+			check(filepath.ToSlash(filepath.Join("_solution", name)), contents)
+		}
+	}
+	if v.failed() {
+		return
+	}
+
+	if signed.Hostname == "" {
+		v.errorf(0, "", "server was unable to find a suitable daycare, unable to run the solutions")
+		return
+	}
+	for n, step := range signed.ProblemSteps {
+		fmt.Printf("validating solution for step %d\n", n+1)
+		unvalidated := &CommitBundle{
+			ProblemType:          signed.ProblemTypes[step.ProblemType],
+			ProblemTypeSignature: signed.ProblemTypeSignatures[step.ProblemType],
+			Problem:              signed.Problem,
+			ProblemSteps:         signed.ProblemSteps,
+			ProblemSignature:     signed.ProblemSignature,
+			Hostname:             signed.Hostname,
+			UserID:               signed.UserID,
+			Commit:               signed.Commits[n],
+			CommitSignature:      signed.CommitSignatures[n],
+		}
+		validated := mustConfirmCommitBundle(unvalidated, nil)
+		commit := validated.Commit
+		if commit.ReportCard == nil || commit.Score != 1.0 || !commit.ReportCard.Passed {
+			note := "no report card"
+			if commit.ReportCard != nil {
+				note = commit.ReportCard.Note
+			}
+			v.errorf(step.Step, "_solution", "solution failed: %s", note)
+			if err := commit.DumpTranscript(os.Stdout); err != nil {
+				log.Printf("failed to dump transcript: %v", err)
+			}
+		}
+		for _, warning := range commit.Warnings() {
+			v.warnf(step.Step, "_solution", "compiler warning: %s", warning)
+		}
+	}
+}
+
+// gatherAuthorDryRun gathers a problem for testing, where it does not
+// matter whether the problem is new or an update.
+func gatherAuthorDryRun(now time.Time, directory string) *ProblemBundle {
+	_, _, _, problem, _, _ := findProblemCfg(now, directory)
+	if problem == nil {
+		log.Printf("unable to find %s in current directory or one of its ancestors", ProblemConfigName)
+		log.Fatalf("   you must run this in a problem directory")
+	}
+	existing := []*Problem{}
+	params := make(url.Values)
+	params.Add("unique", problem.Unique)
+	mustGetObject("/problems", params, &existing)
+	unsigned, _, _ := gatherAuthor(now, len(existing) > 0, "", directory)
+	return unsigned
+}
+
+// maxFileSize gives the largest file in MB that the grade action allows,
+// taking problem options into account.
+func maxFileSize(problemType *ProblemType, problem *Problem) int64 {
+	var limit int64
+	if problemType != nil && problemType.Actions["grade"] != nil {
+		limit = problemType.Actions["grade"].MaxFileSize
+	}
+	for _, option := range problem.Options {
+		parts := strings.Split(option, "=")
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "maxFileSize" {
+			if val, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 63); err == nil {
+				limit = val
+			}
+		}
+	}
+	return limit
+}
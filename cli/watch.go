@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+const (
+	watchPoll     = 500 * time.Millisecond
+	watchDebounce = 750 * time.Millisecond
+)
+
+func CommandWatch(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	action := ""
+	if len(args) > 1 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	} else if len(args) == 1 {
+		action = args[0]
+	}
+	if action == "grade" {
+		log.Printf("'%s watch' is for testing code, not for grading", os.Args[0])
+		log.Fatalf("  to submit your code for grading, use '%s grade'", os.Args[0])
+	}
+
+	// get the user ID
+	user := new(User)
+	mustGetObject("/users/me", nil, user)
+
+	for {
+		now := time.Now()
+		problemType, problem, _, commit, _, problemDir := gatherStudent(now, ".")
+
+		// pick the action if none was given
+		if action == "" {
+			for _, elt := range []string{"test", "run"} {
+				if _, exists := problemType.Actions[elt]; exists {
+					action = elt
+					break
+				}
+			}
+			if action == "" {
+				log.Fatalf("problem type %s has no test or run action; use '%s watch [action]' to pick one", problemType.Name, os.Args[0])
+			}
+		}
+		if _, exists := problemType.Actions[action]; !exists {
+			log.Fatalf("problem type %s does not have a %s action; use '%s action' to list them", problemType.Name, action, os.Args[0])
+		}
+
+		// note the state of the student's files before starting
+		var names []string
+		for name := range commit.Files {
+			names = append(names, filepath.FromSlash(name))
+		}
+		sort.Strings(names)
+		snapshot := watchSnapshot(problemDir, names)
+		changed := make(chan struct{})
+		go watchForChange(problemDir, names, snapshot, changed)
+
+		commit.Action = action
+		commit.Note = "grind watch " + action
+		unsigned := &CommitBundle{
+			UserID: user.ID,
+			Commit: deltaCommit(commit),
+		}
+		signCommitBundle(unsigned)
+		signed := new(CommitBundle)
+		mustPostObject("/commit_bundles/unsigned", nil, unsigned, signed)
+		if signed.Hostname == "" {
+			log.Fatalf("server was unable to find a suitable daycare, unable to run action")
+		}
+
+		fmt.Printf("=== %s: running %s for %s step %d\n", time.Now().Format("15:04:05"), action, problem.Unique, commit.Step)
+		if runWatchedSession(signed, problemDir, changed) {
+			fmt.Printf("=== files changed, starting over\n")
+			continue
+		}
+		fmt.Printf("=== waiting for changes (press Ctrl-C to quit)\n")
+		<-changed
+	}
+}
+
+// runWatchedSession runs an action without input, printing its output,
+// and stops early if changed is closed. It reports whether it was stopped.
+func runWatchedSession(bundle *CommitBundle, directory string, changed <-chan struct{}) bool {
+	vals := url.Values{}
+	if term := os.Getenv("TERM"); term != "" {
+		vals.Set("TERM", term)
+	}
+	endpoint := &url.URL{
+		Scheme:   "wss",
+		Host:     bundle.Hostname,
+		Path:     urlPrefix + "/sockets/" + bundle.ProblemType.Name + "/" + bundle.Commit.Action,
+		RawQuery: vals.Encode(),
+	}
+
+	headers := make(http.Header)
+	headers.Set("Sec-Websocket-Protocol", SocketCompression)
+	socket, resp, err := websocket.DefaultDialer.Dial(endpoint.String(), headers)
+	if err != nil {
+		log.Printf("error dialing: %v", err)
+		if resp != nil && resp.Body != nil {
+			dumpBody(resp)
+			resp.Body.Close()
+		}
+		<-changed
+		return true
+	}
+	defer socket.Close()
+
+	// send the request with no input for the program
+	for _, req := range []*DaycareRequest{{CommitBundle: bundle}, {CloseStdin: true}} {
+		dumpOutgoing(req)
+		if err := WriteSocketJSON(socket, req); err != nil {
+			log.Printf("error writing request message: %v", err)
+			<-changed
+			return true
+		}
+	}
+
+	// closing the socket ends the session on the daycare
+	done := make(chan struct{})
+	defer close(done)
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-changed:
+			close(stopped)
+			socket.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		reply := new(DaycareResponse)
+		if err := ReadSocketJSON(socket, reply); err != nil {
+			select {
+			case <-stopped:
+				return true
+			default:
+			}
+			log.Printf("session closed by server")
+			return false
+		}
+		dumpIncoming(reply)
+
+		switch {
+		case reply.Error != "":
+			log.Printf("server returned an error:")
+			log.Printf("  %s", reply.Error)
+			return false
+
+		case reply.CommitBundle != nil:
+			return false
+
+		case reply.Event != nil:
+			switch reply.Event.Event {
+			case "exec", "stdout", "exit", "error", "abuse":
+				fmt.Fprintf(os.Stdout, "%s", reply.Event.Dump())
+			case "stderr":
+				fmt.Fprintf(os.Stderr, "%s", reply.Event.Dump())
+			case "display":
+				fmt.Fprintf(os.Stdout, "%s", reply.Event.Dump())
+				openBrowser(reply.Event.DisplayURL)
+			}
+
+		default:
+			log.Printf("unexpected reply from server")
+			return false
+		}
+	}
+}
+
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+func watchSnapshot(directory string, names []string) map[string]watchedFile {
+	snapshot := make(map[string]watchedFile)
+	for _, name := range names {
+		if info, err := os.Stat(filepath.Join(directory, name)); err == nil {
+			snapshot[name] = watchedFile{size: info.Size(), modTime: info.ModTime()}
+		}
+	}
+	return snapshot
+}
+
+func sameSnapshot(a, b map[string]watchedFile) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, elt := range a {
+		if other, present := b[name]; !present || other != elt {
+			return false
+		}
+	}
+	return true
+}
+
+// watchForChange polls the student's files and closes changed once they
+// differ from the snapshot and have stopped changing for a moment, so
+// an editor saving several files at once only triggers one run.
+func watchForChange(directory string, names []string, snapshot map[string]watchedFile, changed chan<- struct{}) {
+	for {
+		time.Sleep(watchPoll)
+		current := watchSnapshot(directory, names)
+		if sameSnapshot(snapshot, current) {
+			continue
+		}
+		for {
+			time.Sleep(watchDebounce)
+			next := watchSnapshot(directory, names)
+			if sameSnapshot(current, next) {
+				break
+			}
+			current = next
+		}
+		close(changed)
+		return
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/spf13/cobra"
+)
+
+// Only the files on a step's whitelist are submitted. Problems with an
+// extra=<pattern> option also accept files the student adds, which are
+// recorded in the .grind file with grind add and dropped with grind rm.
+
+func CommandAdd(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) == 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	dotfile, info, problemDir := findProblemInfo(".")
+	problem := new(Problem)
+	mustGetObject(fmt.Sprintf("/problems/%d", info.StepsID()), nil, problem)
+	step := new(ProblemStep)
+	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", info.StepsID(), info.Step), nil, step)
+	patterns := problem.ExtraFiles()
+
+	changed := false
+	for _, arg := range args {
+		name := studentFileName(problemDir, arg)
+		if stat, err := os.Stat(filepath.Join(problemDir, filepath.FromSlash(name))); err != nil {
+			log.Fatalf("cannot add %s: %v", arg, err)
+		} else if !stat.Mode().IsRegular() {
+			log.Fatalf("cannot add %s: only regular files can be added", arg)
+		}
+		_, provided := step.Files[name]
+		switch {
+		case step.Whitelist[name]:
+			fmt.Printf("%s is already one of the files you submit for this step\n", name)
+		case hasExtraFile(info, name):
+			fmt.Printf("%s has already been added\n", name)
+		case provided:
+			log.Fatalf("%s is part of the problem and cannot be replaced", name)
+		case len(patterns) == 0:
+			log.Printf("this problem does not accept files other than the ones it provides")
+			log.Fatalf("   move the code in %s into one of those files instead", name)
+		case !step.AcceptsExtraFile(patterns, name):
+			log.Fatalf("%s cannot be added: this problem only accepts added files matching %s", name, strings.Join(patterns, ", "))
+		default:
+			info.Extra = append(info.Extra, name)
+			changed = true
+			fmt.Printf("added %s, which will be submitted with your other files\n", name)
+		}
+	}
+	if changed {
+		sort.Strings(info.Extra)
+		saveDotFile(dotfile)
+	}
+}
+
+func CommandRm(cmd *cobra.Command, args []string) {
+	mustLoadConfig(cmd)
+
+	if len(args) == 0 {
+		cmd.Help()
+		os.Exit(exitUsage)
+	}
+	dotfile, info, problemDir := findProblemInfo(".")
+	step := new(ProblemStep)
+	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", info.StepsID(), info.Step), nil, step)
+
+	changed := false
+	for _, arg := range args {
+		name := studentFileName(problemDir, arg)
+		if step.Whitelist[name] {
+			log.Fatalf("%s is required for this step and cannot be removed", name)
+		}
+		if !hasExtraFile(info, name) {
+			log.Fatalf("%s has not been added", name)
+		}
+		var kept []string
+		for _, elt := range info.Extra {
+			if elt != name {
+				kept = append(kept, elt)
+			}
+		}
+		info.Extra = kept
+		changed = true
+		fmt.Printf("%s will no longer be submitted; the file itself was not deleted\n", name)
+	}
+	if changed {
+		saveDotFile(dotfile)
+	}
+}
+
+// studentFileName converts a path given on the command line to the
+// name of a file within the problem directory, as the server names it.
+func studentFileName(problemDir, arg string) string {
+	abs, err := filepath.Abs(arg)
+	if err != nil {
+		log.Fatalf("error finding absolute path of %s: %v", arg, err)
+	}
+	dir, err := filepath.Abs(problemDir)
+	if err != nil {
+		log.Fatalf("error finding absolute path of %s: %v", problemDir, err)
+	}
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil || rel == "." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || rel == ".." {
+		log.Fatalf("%s is not in the problem directory %s", arg, problemDir)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func hasExtraFile(info *ProblemInfo, name string) bool {
+	for _, elt := range info.Extra {
+		if elt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// warnUntrackedFiles points out files in the problem directory that look
+// like source code but will not be submitted, judging by the extensions
+// of the files that are.
+func warnUntrackedFiles(problemDir string, info *ProblemInfo) {
+	step := new(ProblemStep)
+	mustGetObject(fmt.Sprintf("/problems/%d/steps/%d", info.StepsID(), info.Step), nil, step)
+	problemType := new(ProblemType)
+	mustGetObject(fmt.Sprintf("/problem_types/%s", step.ProblemType), nil, problemType)
+
+	known := make(map[string]bool)
+	extensions := make(map[string]bool)
+	for name := range step.Whitelist {
+		known[name] = true
+		if ext := path.Ext(name); ext != "" {
+			extensions[ext] = true
+		}
+	}
+	for _, name := range info.Extra {
+		known[name] = true
+	}
+	for name := range step.Files {
+		known[name] = true
+	}
+	for name := range problemType.Files {
+		known[name] = true
+	}
+
+	var untracked []string
+	filepath.Walk(problemDir, func(p string, stat os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(problemDir, p)
+		if err != nil || rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		if stat.IsDir() {
+			if strings.HasPrefix(stat.Name(), ".") || name == "doc" || name == "artifacts" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if stat.Mode().IsRegular() && !known[name] && extensions[path.Ext(name)] {
+			untracked = append(untracked, name)
+		}
+		return nil
+	})
+	if len(untracked) == 0 {
+		return
+	}
+	sort.Strings(untracked)
+	fmt.Println()
+	fmt.Printf("WARNING: %d file%s in this directory will NOT be submitted for grading:\n", len(untracked), plural(len(untracked)))
+	for _, name := range untracked {
+		fmt.Printf("    %s\n", name)
+	}
+	fmt.Printf("  if your code needs them, use '%s add <file>' to submit them too\n", os.Args[0])
+	fmt.Println()
+}
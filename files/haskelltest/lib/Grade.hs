@@ -0,0 +1,9 @@
+-- | Test drivers call gradeMain instead of defaultMain so that
+-- grading can write JUnit XML results with --xml=test_detail.xml.
+module Grade (gradeMain) where
+
+import Test.Tasty
+import Test.Tasty.Runners.AntXML (antXMLRunner)
+
+gradeMain :: TestTree -> IO ()
+gradeMain = defaultMainWithIngredients (antXMLRunner : defaultIngredients)
@@ -0,0 +1,143 @@
+# Run a program through a scripted interactive session, as in expect.
+# A dialogue script has one command per line:
+#
+#     timeout 5                     # seconds to wait for each expect (default 5)
+#     expect "Enter your name: "    # wait for the program to print this
+#     expect /[Aa]ge\??\s*$/        # or to print something matching a regex
+#     send "Alice"                  # type a line of input
+#     expect eof                    # wait for the program to finish
+#     exit 0                        # and check its exit status
+#
+# The program runs on a terminal, so it sees prompts flushed as it
+# would when run by hand.
+
+import ast
+import os
+import pty
+import re
+import select
+import subprocess
+import termios
+import time
+
+
+def parse_script(filename):
+    steps = []
+    with open(filename) as fp:
+        for lineno, line in enumerate(fp, 1):
+            line = line.strip()
+            if line == '' or line.startswith('#'):
+                continue
+            parts = line.split(None, 1)
+            command, arg = parts[0], parts[1] if len(parts) > 1 else ''
+            where = '{}:{}'.format(filename, lineno)
+            if command == 'timeout':
+                steps.append((command, float(arg), where))
+            elif command == 'exit':
+                steps.append((command, int(arg), where))
+            elif command == 'expect' and arg == 'eof':
+                steps.append(('eof', None, where))
+            elif command == 'expect' and len(arg) >= 2 and arg.startswith('/') and arg.endswith('/'):
+                steps.append((command, re.compile(arg[1:-1]), where))
+            elif command in ('expect', 'send'):
+                try:
+                    text = ast.literal_eval(arg)
+                except (ValueError, SyntaxError):
+                    text = None
+                if not isinstance(text, str):
+                    raise ValueError('{}: {} needs a quoted string'.format(where, command))
+                steps.append((command, text, where))
+            else:
+                raise ValueError('{}: unknown command: {}'.format(where, line))
+    return steps
+
+
+def run_dialogue(cmd, filename):
+    # returns a transcript of the session and a list of failure messages
+    steps = parse_script(filename)
+
+    master, slave = pty.openpty()
+    attrs = termios.tcgetattr(slave)
+    attrs[3] &= ~termios.ECHO
+    termios.tcsetattr(slave, termios.TCSANOW, attrs)
+    proc = subprocess.Popen(cmd, stdin=slave, stdout=slave, stderr=slave, close_fds=True, start_new_session=True)
+    os.close(slave)
+
+    output, pos, eof = '', 0, False
+    transcript, msgs = [], []
+    limit = 5.0
+
+    def read(deadline):
+        # read whatever is available before the deadline; returns False at eof
+        nonlocal output
+        wait = deadline - time.time()
+        if wait <= 0:
+            return True
+        ready, _, _ = select.select([master], [], [], wait)
+        if not ready:
+            return True
+        try:
+            data = os.read(master, 4096)
+        except OSError:
+            data = b''
+        if data == b'':
+            return False
+        output += data.decode('utf-8', 'replace').replace('\r\n', '\n')
+        return True
+
+    try:
+        for command, arg, where in steps:
+            if command == 'timeout':
+                limit = arg
+            elif command == 'send':
+                transcript.append(output[pos:])
+                transcript.append('<<< ' + arg + '\n')
+                pos = len(output)
+                try:
+                    os.write(master, (arg + '\n').encode('utf-8'))
+                except OSError:
+                    msgs.append('{}: the program had already finished when it should have read {!r}'.format(where, arg))
+                    break
+            elif command in ('expect', 'eof'):
+                deadline = time.time() + limit
+                found = None
+                while True:
+                    if command == 'expect':
+                        if isinstance(arg, str):
+                            i = output.find(arg, pos)
+                            found = None if i < 0 else i + len(arg)
+                        else:
+                            m = arg.search(output, pos)
+                            found = None if m is None else m.end()
+                        if found is not None:
+                            break
+                    if eof or time.time() >= deadline:
+                        break
+                    eof = not read(deadline)
+                if command == 'eof' and not eof:
+                    msgs.append('{}: the program should have finished, but it was still running after {} seconds'.format(where, limit))
+                    break
+                if command == 'expect' and found is None:
+                    want = repr(arg) if isinstance(arg, str) else '/' + arg.pattern + '/'
+                    how = 'it finished' if eof else 'nothing matched within {} seconds'.format(limit)
+                    msgs.append('{}: expected the program to print {}, but {}'.format(where, want, how))
+                    break
+                if command == 'expect':
+                    transcript.append(output[pos:found])
+                    pos = found
+            elif command == 'exit':
+                try:
+                    status = proc.wait(timeout=limit)
+                except subprocess.TimeoutExpired:
+                    msgs.append('{}: the program was still running after {} seconds'.format(where, limit))
+                    break
+                if status != arg:
+                    msgs.append('{}: the exit status should have been {}, but it was {}'.format(where, arg, status))
+    finally:
+        if proc.poll() is None:
+            proc.kill()
+        proc.wait()
+        os.close(master)
+
+    transcript.append(output[pos:])
+    return ''.join(transcript), msgs
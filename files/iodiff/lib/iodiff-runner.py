@@ -0,0 +1,288 @@
+#!/usr/bin/env python3
+
+# Run the program on each inputs/<name>.input and compare its output
+# with inputs/<name>.expected. How strict the comparison is comes from
+# iodiff.cfg, with one setting per line:
+#
+#     trailing-whitespace     # ignore trailing spaces and blank lines at the end
+#     whitespace              # treat any run of spaces and tabs as a single space
+#     ignore-case
+#     epsilon 1e-6            # numbers may differ by this much (absolute or relative)
+#     unordered               # the lines may come in any order
+#
+# Interactive programs are tested with inputs/<name>.dialogue scripts
+# instead, which alternate between sending lines of input and waiting
+# for the program to print prompts and responses (see dialogue.py).
+#
+# usage: iodiff-runner.py command...
+
+import difflib
+import glob
+import os, os.path
+import subprocess
+import sys
+import time
+import xml.etree.ElementTree as ET
+
+from dialogue import run_dialogue
+
+cmd = sys.argv[1:]
+timeout = 10
+
+RED = '\x1b[31m'
+GREEN = '\x1b[32m'
+CYAN = '\x1b[36m'
+RESET = '\x1b[0m'
+
+
+def read_config():
+    config = {'trailing-whitespace': False, 'whitespace': False, 'ignore-case': False, 'epsilon': None, 'unordered': False}
+    if not os.path.exists('iodiff.cfg'):
+        return config
+    with open('iodiff.cfg') as fp:
+        for line in fp:
+            fields = line.split('#', 1)[0].split()
+            if len(fields) == 0:
+                continue
+            if fields[0] == 'epsilon' and len(fields) == 2:
+                config['epsilon'] = float(fields[1])
+            elif fields[0] in config and len(fields) == 1:
+                config[fields[0]] = True
+            else:
+                print('iodiff.cfg: unknown setting: ' + line.strip())
+    return config
+
+
+def normalize(text, config):
+    # returns the lines to compare
+    lines = text.split('\n')
+    if lines and lines[-1] == '':
+        lines = lines[:-1]
+    if config['whitespace']:
+        lines = [' '.join(line.split()) for line in lines]
+    elif config['trailing-whitespace']:
+        lines = [line.rstrip() for line in lines]
+    if config['trailing-whitespace'] or config['whitespace']:
+        while lines and lines[-1] == '':
+            lines = lines[:-1]
+    if config['ignore-case']:
+        lines = [line.lower() for line in lines]
+    return lines
+
+
+def number(token):
+    try:
+        return float(token)
+    except ValueError:
+        return None
+
+
+def same_line(actual, expected, config):
+    if actual == expected:
+        return True
+    epsilon = config['epsilon']
+    if epsilon is None:
+        return False
+    a, b = actual.split(), expected.split()
+    if len(a) != len(b):
+        return False
+    for x, y in zip(a, b):
+        if x == y:
+            continue
+        fx, fy = number(x), number(y)
+        if fx is None or fy is None:
+            return False
+        if abs(fx - fy) > epsilon and abs(fx - fy) > epsilon * abs(fy):
+            return False
+    return True
+
+
+def compare(actual, expected, config):
+    # returns a list of (kind, line) pairs describing a diff
+    # or None if the output is acceptable
+    if config['unordered']:
+        remaining = list(expected)
+        extra = []
+        for line in actual:
+            for i, want in enumerate(remaining):
+                if same_line(line, want, config):
+                    del remaining[i]
+                    break
+            else:
+                extra.append(line)
+        if not remaining and not extra:
+            return None
+        return [('-', line) for line in remaining] + [('+', line) for line in extra]
+
+    if len(actual) == len(expected) and all(same_line(a, e, config) for a, e in zip(actual, expected)):
+        return None
+    diff = []
+    for line in difflib.unified_diff(expected, actual, 'expected', 'actual', lineterm='', n=2):
+        if line.startswith('---') or line.startswith('+++'):
+            continue
+        diff.append((line[:1], line[1:]))
+    return diff
+
+
+def show_diff(diff):
+    # returns plain text for the report card and colored text for the transcript
+    plain, colored = [], []
+    for kind, line in diff:
+        if kind == '@':
+            plain.append('@' + line)
+            colored.append(CYAN + '@' + line + RESET)
+        elif kind == '-':
+            plain.append('-' + line)
+            colored.append(RED + '-' + line + RESET)
+        elif kind == '+':
+            plain.append('+' + line)
+            colored.append(GREEN + '+' + line + RESET)
+        else:
+            plain.append(' ' + line)
+            colored.append(' ' + line)
+    return '\n'.join(plain), '\n'.join(colored)
+
+
+config = read_config()
+
+# get the list of input files to process
+infiles = sorted(glob.glob('inputs/*.input'))
+
+testsuites = ET.Element('testsuites')
+suite = ET.SubElement(testsuites, 'testsuite')
+(tests, failures, disabled, skipped, errors) = (0, 0, 0, 0, 0)
+totaltime = 0.0
+
+prevpassed = True
+for infile in infiles:
+    if not prevpassed:
+        print()
+
+    outfile = infile[:-len('.input')] + '.expected'
+
+    # get the input
+    with open(infile, 'rb') as fp:
+        input = fp.read()
+
+    # get the expected output
+    with open(outfile, 'rb') as fp:
+        expected = fp.read()
+
+    # report the result in XML
+    case = ET.SubElement(suite, 'testcase')
+    case.set('name', infile)
+
+    # run the program to get the actual output
+    body = ' '.join(cmd) + ' < ' + infile
+    print(body)
+    body += '\n'
+    start = time.time()
+    passed = True
+    try:
+        proc = subprocess.run(cmd, input=input, capture_output=True, timeout=timeout)
+        actual, stderr, status = proc.stdout, proc.stderr, proc.returncode
+    except subprocess.TimeoutExpired:
+        actual, stderr, status = None, b'', None
+    seconds = time.time() - start
+
+    # check the output
+    if actual is None:
+        msg = '\n!!! timed out after {} seconds'.format(timeout)
+        print(msg)
+        body += msg + '\n'
+        passed = False
+    else:
+        if status != 0:
+            msg = '\n!!! returned non-zero status code {}'.format(status)
+            print(msg)
+            body += msg + '\n'
+            passed = False
+
+        if stderr != b'':
+            msg = '\n!!! stderr should have been empty, but instead the program printed:'
+            for line in str(stderr, 'utf-8', 'replace').rstrip('\n').split('\n'):
+                msg += '\n> ' + line
+            print(msg)
+            body += msg + '\n'
+            passed = False
+
+        diff = compare(
+            normalize(str(actual, 'utf-8', 'replace'), config),
+            normalize(str(expected, 'utf-8', 'replace'), config),
+            config)
+        if diff is not None:
+            plain, colored = show_diff(diff)
+            msg = '\n!!! output is incorrect ({}-expected{} {}+actual{}):\n'.format(RED, RESET, GREEN, RESET)
+            print(msg + colored)
+            body += '\n!!! output is incorrect (-expected +actual):\n' + plain + '\n'
+            passed = False
+
+    tests += 1
+    totaltime += seconds
+    case.set('time', str(seconds))
+    if not passed:
+        failures += 1
+        case.set('status', 'failed')
+        failure = ET.SubElement(case, 'failure')
+        failure.set('type', 'failure')
+        failure.text = body
+
+    prevpassed = passed
+
+for script in sorted(glob.glob('inputs/*.dialogue')):
+    if not prevpassed:
+        print()
+
+    # report the result in XML
+    case = ET.SubElement(suite, 'testcase')
+    case.set('name', script)
+
+    body = ' '.join(cmd) + ' <> ' + script
+    print(body)
+    body += '\n'
+    start = time.time()
+    try:
+        transcript, msgs = run_dialogue(cmd, script)
+    except ValueError as err:
+        transcript, msgs = '', [str(err)]
+    seconds = time.time() - start
+
+    # check the session
+    passed = len(msgs) == 0
+    if transcript != '':
+        print(transcript.rstrip('\n'))
+        body += transcript.rstrip('\n') + '\n'
+    for msg in msgs:
+        msg = '\n!!! ' + msg
+        print(msg)
+        body += msg + '\n'
+
+    tests += 1
+    totaltime += seconds
+    case.set('time', str(seconds))
+    if not passed:
+        failures += 1
+        case.set('status', 'failed')
+        failure = ET.SubElement(case, 'failure')
+        failure.set('type', 'failure')
+        failure.text = body
+
+    prevpassed = passed
+
+suite.set('tests', str(tests))
+suite.set('failures', str(failures))
+suite.set('disabled', str(disabled))
+suite.set('skipped', str(skipped))
+suite.set('errors', str(errors))
+suite.set('time', str(totaltime))
+testsuites.set('tests', str(tests))
+testsuites.set('failures', str(failures))
+testsuites.set('disabled', str(disabled))
+testsuites.set('skipped', str(skipped))
+testsuites.set('errors', str(errors))
+testsuites.set('time', str(totaltime))
+
+tree = ET.ElementTree(element=testsuites)
+tree.write('test_detail.xml', encoding='utf-8', xml_declaration=True)
+
+print('\nPassed {}/{} tests in {:.2} seconds'.format(tests-failures, tests, totaltime))
@@ -0,0 +1,21 @@
+# Loaded at startup when lib is on PYTHONPATH. If the problem uses
+# property-based tests, run hypothesis with the number of examples and
+# the seed chosen by the daycare, so a student sees the same examples
+# on every run.
+
+import os
+
+if os.environ.get('PROPERTY_SEED'):
+    try:
+        import hypothesis
+        import hypothesis.core
+    except ImportError:
+        hypothesis = None
+    if hypothesis is not None:
+        hypothesis.settings.register_profile('codegrinder',
+            max_examples=int(os.environ.get('PROPERTY_EXAMPLES', '100')),
+            database=None,
+            deadline=None,
+            print_blob=False)
+        hypothesis.settings.load_profile('codegrinder')
+        hypothesis.core.global_force_seed = int(os.environ['PROPERTY_SEED'])
@@ -0,0 +1,40 @@
+# gdb script for state-runner.py: connect to the program waiting under
+# qemu, stop at the breakpoint named in the assertions, and print the
+# values the assertions ask for as a single JSON object.
+
+import json
+import os
+
+import gdb
+
+port = os.environ['STATE_PORT']
+symbol = os.environ['STATE_BREAK']
+reads = json.loads(os.environ['STATE_READS'])
+
+gdb.execute('set confirm off')
+gdb.execute('target remote localhost:' + port, to_string=True)
+breakpoint = gdb.Breakpoint(symbol)
+
+result = {}
+try:
+    gdb.execute('continue', to_string=True)
+    frame = gdb.selected_frame()
+    for elt in reads:
+        if elt['kind'] == 'register':
+            result[elt['key']] = int(frame.read_register(elt['name']))
+        else:
+            addr = int(gdb.parse_and_eval('(long)&' + elt['base'])) + elt['offset']
+            data = gdb.selected_inferior().read_memory(addr, elt['size']).tobytes()
+            result[elt['key']] = list(data)
+    result['stopped'] = True
+except gdb.error as err:
+    result = {'stopped': False, 'error': str(err)}
+
+print('STATE ' + json.dumps(result))
+
+# let the program finish
+try:
+    breakpoint.delete()
+    gdb.execute('continue', to_string=True)
+except gdb.error:
+    pass
@@ -0,0 +1,224 @@
+#!/usr/bin/env python3
+
+# Run the program once for each test in inputs/, checking its output
+# against inputs/<name>.expected and its registers and memory against
+# the assertions in inputs/<name>.assert. A test needs at least one of
+# the two; inputs/<name>.input is optional and is fed to stdin.
+#
+# Assertions are checked when the program reaches a label, one per line:
+#
+#     at done                 # stop at this label (the default is done)
+#     a0 = 42                 # register values
+#     s1 = -1
+#     word result = 7         # 4 bytes at a label (byte, half, word, dword)
+#     dword array+8 = 0x10    # with an offset in bytes
+#     string msg = "hello"    # NUL-terminated string at a label
+#
+# usage: state-runner.py qemu-command... ./a.out
+
+import difflib
+import glob
+import json
+import os, os.path
+import re
+import shlex
+import socket
+import subprocess
+import sys
+import time
+import xml.etree.ElementTree as ET
+
+cmd = sys.argv[1:]
+sizes = {'byte': 1, 'half': 2, 'word': 4, 'dword': 8}
+timeout = 10
+
+assert_line = re.compile(r'^(?:(byte|half|word|dword|string)\s+([A-Za-z_.$][\w.$]*)(?:\s*\+\s*(\w+))?|([a-z][a-z0-9]*))\s*=\s*(.+)$')
+
+
+def parse_asserts(filename):
+    # returns the breakpoint label and a list of assertions
+    symbol, asserts = 'done', []
+    with open(filename) as fp:
+        for lineno, line in enumerate(fp, 1):
+            line = line.split('#', 1)[0].strip()
+            if line == '':
+                continue
+            if line.startswith('at '):
+                symbol = line[3:].strip()
+                continue
+            m = assert_line.match(line)
+            if m is None:
+                raise ValueError('{}:{}: cannot parse assertion: {}'.format(filename, lineno, line))
+            kind, base, offset, register, value = m.groups()
+            key = 'a{}'.format(len(asserts))
+            if register is not None:
+                asserts.append({'key': key, 'kind': 'register', 'name': register, 'size': 8,
+                    'text': register, 'want': int(value, 0)})
+            elif kind == 'string':
+                want = shlex.split(value)[0].encode('utf-8') + b'\0'
+                asserts.append({'key': key, 'kind': 'memory', 'base': base, 'offset': int(offset or '0', 0),
+                    'size': len(want), 'text': line.split('=')[0].strip(), 'string': True, 'want': want})
+            else:
+                asserts.append({'key': key, 'kind': 'memory', 'base': base, 'offset': int(offset or '0', 0),
+                    'size': sizes[kind], 'text': line.split('=')[0].strip(), 'want': int(value, 0)})
+    return symbol, asserts
+
+
+def check_asserts(asserts, state):
+    # returns a list of messages describing failed assertions
+    msgs = []
+    for elt in asserts:
+        got = state.get(elt['key'])
+        if elt.get('string'):
+            want = elt['want']
+            if bytes(got) != want:
+                msgs.append('{} should be {!r} but it is {!r}'.format(
+                    elt['text'], want[:-1].decode('utf-8', 'replace'),
+                    bytes(got).split(b'\0')[0].decode('utf-8', 'replace')))
+            continue
+        if isinstance(got, list):
+            got = int.from_bytes(bytes(got), 'little')
+        bits = 8 * elt['size']
+        mask = (1 << bits) - 1
+        if (got - elt['want']) & mask != 0:
+            got &= mask
+            signed = got - (1 << bits) if got >> (bits - 1) else got
+            msgs.append('{} should be {} but it is {} (0x{:x})'.format(elt['text'], elt['want'], signed, got))
+    return msgs
+
+
+def free_port():
+    with socket.socket() as s:
+        s.bind(('127.0.0.1', 0))
+        return str(s.getsockname()[1])
+
+
+def run(input, asserts, symbol):
+    # returns stdout, stderr, exit status, state (or None), and an error message
+    if not asserts:
+        proc = subprocess.run(cmd, input=input, capture_output=True, timeout=timeout)
+        return proc.stdout, proc.stderr, proc.returncode, None, None
+
+    port = free_port()
+    proc = subprocess.Popen(cmd[:-1] + ['-g', port] + cmd[-1:],
+        stdin=subprocess.PIPE, stdout=subprocess.PIPE, stderr=subprocess.PIPE)
+    reads = [{key: elt[key] for key in ('key', 'kind', 'name', 'base', 'offset', 'size') if key in elt} for elt in asserts]
+    env = dict(os.environ, STATE_PORT=port, STATE_BREAK=symbol, STATE_READS=json.dumps(reads))
+    time.sleep(0.2)
+    gdb = subprocess.Popen(['gdb-multiarch', '-batch', '-nx', '-x', 'lib/state-check.py', cmd[-1]],
+        stdout=subprocess.PIPE, stderr=subprocess.PIPE, env=env)
+    try:
+        (actual, stderr) = proc.communicate(input, timeout=timeout)
+        (gdbout, gdberr) = gdb.communicate(timeout=timeout)
+    except subprocess.TimeoutExpired:
+        proc.kill()
+        gdb.kill()
+        raise
+
+    state, error = None, 'the program never reached the label {}'.format(symbol)
+    for line in gdbout.decode('utf-8', 'replace').split('\n'):
+        if line.startswith('STATE '):
+            state = json.loads(line[len('STATE '):])
+    if state is not None and not state['stopped']:
+        error = 'stopping at {} failed: {}'.format(symbol, state['error'])
+        state = None
+    return actual, stderr, proc.returncode, state, error
+
+
+# get the list of tests to run
+names = sorted(set(os.path.splitext(elt)[0]
+    for pattern in ('inputs/*.expected', 'inputs/*.assert') for elt in glob.glob(pattern)))
+
+testsuites = ET.Element('testsuites')
+suite = ET.SubElement(testsuites, 'testsuite')
+(tests, failures, disabled, skipped, errors) = (0, 0, 0, 0, 0)
+totaltime = 0.0
+
+prevpassed = True
+for name in names:
+    if not prevpassed:
+        print()
+
+    # get the input, expected output, and assertions
+    input = b''
+    if os.path.exists(name + '.input'):
+        with open(name + '.input', 'rb') as fp:
+            input = fp.read()
+    expected = None
+    if os.path.exists(name + '.expected'):
+        with open(name + '.expected', 'rb') as fp:
+            expected = fp.read()
+
+    # report the result in XML
+    case = ET.SubElement(suite, 'testcase')
+    case.set('name', name)
+
+    body = ' '.join(cmd)
+    if os.path.exists(name + '.input'):
+        body += ' < ' + name + '.input'
+    print(body)
+    body += '\n'
+    start = time.time()
+    msgs = []
+    try:
+        symbol, asserts = 'done', []
+        if os.path.exists(name + '.assert'):
+            symbol, asserts = parse_asserts(name + '.assert')
+        actual, stderr, status, state, error = run(input, asserts, symbol)
+        if status != 0:
+            msgs.append('returned non-zero status code {}'.format(status))
+        if stderr != b'':
+            msgs.append('stderr should have been empty, but instead the program printed:\n> ' +
+                str(stderr, 'utf-8', 'replace').rstrip('\n').replace('\n', '\n> '))
+        if expected is not None and actual != expected:
+            diff = difflib.unified_diff(
+                str(expected, 'utf-8', 'replace').splitlines(),
+                str(actual, 'utf-8', 'replace').splitlines(),
+                'expected', 'actual', lineterm='')
+            msgs.append('output is incorrect:\n' + '\n'.join(diff))
+        if asserts and state is None:
+            msgs.append(error)
+        elif asserts:
+            msgs.extend(check_asserts(asserts, state))
+    except subprocess.TimeoutExpired:
+        msgs.append('timed out after {} seconds'.format(timeout))
+    except ValueError as err:
+        msgs.append(str(err))
+    seconds = time.time() - start
+
+    # check the results
+    passed = len(msgs) == 0
+    for msg in msgs:
+        msg = '\n!!! ' + msg
+        print(msg)
+        body += msg + '\n'
+
+    tests += 1
+    totaltime += seconds
+    case.set('time', str(seconds))
+    if not passed:
+        failures += 1
+        case.set('status', 'failed')
+        failure = ET.SubElement(case, 'failure')
+        failure.set('type', 'failure')
+        failure.text = body
+
+    prevpassed = passed
+
+suite.set('tests', str(tests))
+suite.set('failures', str(failures))
+suite.set('disabled', str(disabled))
+suite.set('skipped', str(skipped))
+suite.set('errors', str(errors))
+suite.set('time', str(totaltime))
+testsuites.set('tests', str(tests))
+testsuites.set('failures', str(failures))
+testsuites.set('disabled', str(disabled))
+testsuites.set('skipped', str(skipped))
+testsuites.set('errors', str(errors))
+testsuites.set('time', str(totaltime))
+
+tree = ET.ElementTree(element=testsuites)
+tree.write('test_detail.xml', encoding='utf-8', xml_declaration=True)
+
+print('\nPassed {}/{} tests in {:.2} seconds'.format(tests-failures, tests, totaltime))
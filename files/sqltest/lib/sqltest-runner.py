@@ -0,0 +1,255 @@
+#!/usr/bin/env python3
+
+# Run each query in *.sql against a fresh database loaded from the seed
+# files and compare the result set with expected/<name>.csv, where the
+# first row holds the column names. Row order only matters if the
+# expected file is named expected/<name>.ordered.csv instead.
+#
+# usage: sqltest-runner.py sqlite|postgres [--generate|--shell]
+
+import collections
+import csv
+import difflib
+import glob
+import io
+import os, os.path
+import shutil
+import sqlite3
+import subprocess
+import sys
+import time
+import xml.etree.ElementTree as ET
+
+engine = sys.argv[1]
+mode = sys.argv[2] if len(sys.argv) > 2 else ''
+pgdata = os.path.abspath('pgdata')
+
+
+def start_database():
+    if engine == 'sqlite':
+        if os.path.exists('database.db'):
+            os.remove('database.db')
+        db = sqlite3.connect('database.db', isolation_level=None)
+        for seed in sorted(glob.glob('seed/*.sql')):
+            with open(seed) as fp:
+                db.executescript(fp.read())
+        return db
+
+    import psycopg2
+    shutil.rmtree(pgdata, ignore_errors=True)
+    subprocess.run(['initdb', '-D', pgdata, '-A', 'trust', '-U', 'student', '--no-sync'],
+        check=True, stdout=subprocess.DEVNULL)
+    subprocess.run(['pg_ctl', '-D', pgdata, '-l', os.path.join(pgdata, 'log'), '-w',
+        '-o', "-k {} -c listen_addresses='' -c fsync=off".format(pgdata), 'start'],
+        check=True, stdout=subprocess.DEVNULL)
+    db = psycopg2.connect(host=pgdata, user='student', dbname='postgres')
+    db.autocommit = True
+    for seed in sorted(glob.glob('seed/*.pgsql')):
+        with open(seed) as fp:
+            db.cursor().execute(fp.read())
+    db.autocommit = False
+    return db
+
+
+def stop_database(db):
+    db.close()
+    if engine == 'postgres':
+        subprocess.run(['pg_ctl', '-D', pgdata, '-m', 'immediate', 'stop'], stdout=subprocess.DEVNULL)
+
+
+def run_query(db, text):
+    # run every statement, keeping the result of the last one to return rows,
+    # and roll back any changes so that each query sees the seed data
+    columns, rows = None, []
+    if engine == 'sqlite':
+        statements, statement = [], ''
+        for ch in text:
+            statement += ch
+            if ch == ';' and sqlite3.complete_statement(statement):
+                statements.append(statement)
+                statement = ''
+        if statement.strip() != '':
+            # allow the final semicolon to be left off
+            if not sqlite3.complete_statement(statement + ';'):
+                raise sqlite3.Error('incomplete statement: ' + statement.strip())
+            statements.append(statement)
+        db.execute('BEGIN')
+        try:
+            for statement in statements:
+                cur = db.execute(statement)
+                if cur.description is not None:
+                    columns = [elt[0] for elt in cur.description]
+                    rows = cur.fetchall()
+        finally:
+            db.execute('ROLLBACK')
+    else:
+        try:
+            cur = db.cursor()
+            cur.execute(text)
+            if cur.description is not None:
+                columns = [elt[0] for elt in cur.description]
+                rows = cur.fetchall()
+        finally:
+            db.rollback()
+    if columns is None:
+        raise ValueError('the query did not return any rows (is it missing a SELECT?)')
+    return columns, [[format_value(value) for value in row] for row in rows]
+
+
+def format_value(value):
+    if value is None:
+        return 'NULL'
+    if isinstance(value, bool):
+        return 'true' if value else 'false'
+    if isinstance(value, float):
+        return '{:.6g}'.format(value)
+    if isinstance(value, (bytes, memoryview)):
+        return bytes(value).hex()
+    return str(value)
+
+
+def format_row(row):
+    out = io.StringIO()
+    csv.writer(out, lineterminator='').writerow(row)
+    return out.getvalue()
+
+
+def expected_file(name):
+    ordered = os.path.join('expected', name + '.ordered.csv')
+    if os.path.exists(ordered):
+        return ordered, True
+    return os.path.join('expected', name + '.csv'), False
+
+
+def compare(columns, rows, expected, ordered):
+    # returns a list of messages describing the differences
+    msgs = []
+    if len(expected) == 0:
+        return ['the expected output file is empty']
+    if [elt.lower() for elt in columns] != [elt.lower() for elt in expected[0]]:
+        msgs.append('the columns should be:\n    {}\nbut instead they are:\n    {}'.format(
+            format_row(expected[0]), format_row(columns)))
+    want = [format_row(row) for row in expected[1:]]
+    got = [format_row(row) for row in rows]
+    if ordered:
+        if want != got:
+            diff = difflib.unified_diff(want, got, 'expected', 'actual', lineterm='', n=2)
+            msgs.append('rows are incorrect or out of order:\n' + '\n'.join(diff))
+        return msgs
+    missing = collections.Counter(want) - collections.Counter(got)
+    extra = collections.Counter(got) - collections.Counter(want)
+    if missing:
+        msgs.append('{} expected row(s) missing:\n'.format(sum(missing.values())) +
+            '\n'.join('  - ' + row for row in sorted(missing.elements())))
+    if extra:
+        msgs.append('{} unexpected row(s):\n'.format(sum(extra.values())) +
+            '\n'.join('  + ' + row for row in sorted(extra.elements())))
+    return msgs
+
+
+if mode == '--shell':
+    db = start_database()
+    db.close()
+    if engine == 'sqlite':
+        subprocess.call(['sqlite3', 'database.db'])
+    else:
+        subprocess.call(['psql', '-h', pgdata, '-U', 'student', 'postgres'])
+        subprocess.run(['pg_ctl', '-D', pgdata, '-m', 'immediate', 'stop'], stdout=subprocess.DEVNULL)
+    sys.exit(0)
+
+db = start_database()
+
+# get the list of queries to process
+infiles = sorted(glob.glob('*.sql'))
+
+if mode == '--generate':
+    os.makedirs('expected', exist_ok=True)
+    for infile in infiles:
+        name = infile[:-len('.sql')]
+        outfile, ordered = expected_file(name)
+        with open(infile) as fp:
+            columns, rows = run_query(db, fp.read())
+        with open(outfile, 'w', newline='') as fp:
+            writer = csv.writer(fp)
+            writer.writerow(columns)
+            writer.writerows(rows)
+        print('wrote {} ({} row{})'.format(outfile, len(rows), '' if len(rows) == 1 else 's'))
+    stop_database(db)
+    sys.exit(0)
+
+testsuites = ET.Element('testsuites')
+suite = ET.SubElement(testsuites, 'testsuite')
+(tests, failures, disabled, skipped, errors) = (0, 0, 0, 0, 0)
+totaltime = 0.0
+
+prevpassed = True
+for infile in infiles:
+    if not prevpassed:
+        print()
+
+    name = infile[:-len('.sql')]
+    outfile, ordered = expected_file(name)
+
+    # get the query
+    with open(infile) as fp:
+        query = fp.read()
+
+    # get the expected result set
+    with open(outfile, newline='') as fp:
+        expected = list(csv.reader(fp))
+
+    # report the result in XML
+    case = ET.SubElement(suite, 'testcase')
+    case.set('name', infile)
+
+    # run the query to get the actual result set
+    body = '{} < {}'.format(engine, infile)
+    print(body)
+    body += '\n'
+    start = time.time()
+    passed = True
+    try:
+        columns, rows = run_query(db, query)
+        msgs = compare(columns, rows, expected, ordered)
+    except Exception as err:
+        msgs = ['the query failed with an error:\n> ' + str(err).strip().replace('\n', '\n> ')]
+    seconds = time.time() - start
+
+    # check the output
+    for msg in msgs:
+        msg = '\n!!! ' + msg
+        print(msg)
+        body += msg + '\n'
+        passed = False
+
+    tests += 1
+    totaltime += seconds
+    case.set('time', str(seconds))
+    if not passed:
+        failures += 1
+        case.set('status', 'failed')
+        failure = ET.SubElement(case, 'failure')
+        failure.set('type', 'failure')
+        failure.text = body
+
+    prevpassed = passed
+
+stop_database(db)
+
+suite.set('tests', str(tests))
+suite.set('failures', str(failures))
+suite.set('disabled', str(disabled))
+suite.set('skipped', str(skipped))
+suite.set('errors', str(errors))
+suite.set('time', str(totaltime))
+testsuites.set('tests', str(tests))
+testsuites.set('failures', str(failures))
+testsuites.set('disabled', str(disabled))
+testsuites.set('skipped', str(skipped))
+testsuites.set('errors', str(errors))
+testsuites.set('time', str(totaltime))
+
+tree = ET.ElementTree(element=testsuites)
+tree.write('test_detail.xml', encoding='utf-8', xml_declaration=True)
+
+print('\nPassed {}/{} tests in {:.2} seconds'.format(tests-failures, tests, totaltime))
@@ -0,0 +1,47 @@
+// Jest environment that starts a headless browser for each test file
+// and saves a screenshot of the page whenever a test fails.
+
+const fs = require('fs');
+const NodeEnvironment = require('jest-environment-node').TestEnvironment;
+const puppeteer = require('puppeteer-core');
+
+const port = process.env.PORT || '8000';
+
+class BrowserEnvironment extends NodeEnvironment {
+    async setup() {
+        await super.setup();
+        this.browser = await puppeteer.launch({
+            executablePath: process.env.CHROMIUM || '/usr/bin/chromium',
+            args: ['--no-sandbox', '--disable-gpu', '--disable-dev-shm-usage'],
+        });
+        this.global.browser = this.browser;
+        this.global.page = await this.browser.newPage();
+        this.global.baseURL = 'http://127.0.0.1:' + port + '/';
+    }
+
+    async teardown() {
+        if (this.browser) {
+            await this.browser.close();
+        }
+        await super.teardown();
+    }
+
+    async handleTestEvent(event) {
+        if (event.name !== 'test_done' || event.test.errors.length === 0 || !this.global.page) {
+            return;
+        }
+        const names = [];
+        for (let block = event.test; block && block.name !== 'ROOT_DESCRIBE_BLOCK'; block = block.parent) {
+            names.unshift(block.name);
+        }
+        const name = names.join(' ').replace(/[^A-Za-z0-9._-]+/g, '_');
+        fs.mkdirSync('screenshots', { recursive: true });
+        try {
+            await this.global.page.screenshot({ path: 'screenshots/' + name + '.png', fullPage: true });
+        } catch (err) {
+            // the page may have crashed or closed
+        }
+    }
+}
+
+module.exports = BrowserEnvironment;
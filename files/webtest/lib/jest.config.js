@@ -0,0 +1,8 @@
+// tests in tests/*.test.js get a puppeteer browser and page as globals,
+// with the student's files served at baseURL
+module.exports = {
+    rootDir: '..',
+    testMatch: ['<rootDir>/tests/**/*.test.js'],
+    testEnvironment: '<rootDir>/lib/browser-environment.js',
+    testTimeout: 15000,
+};
@@ -8,20 +8,24 @@ require (
 	github.com/blang/semver v3.5.1+incompatible
 	github.com/codegangsta/inject v0.0.0-20150114235600-33e0aa1cb7c0 // indirect
 	github.com/fsouza/go-dockerclient v1.6.5
+	github.com/gdamore/tcell/v2 v2.0.1-0.20201017141208-acf90d56d591
 	github.com/go-martini/martini v0.0.0-20170121215854-22fa46961aab
 	github.com/gorilla/securecookie v1.1.1
 	github.com/gorilla/websocket v1.4.2
+	github.com/klauspost/compress v1.11.13
 	github.com/martini-contrib/binding v0.0.0-20160701174519-05d3e151b6cf
 	github.com/martini-contrib/gzip v0.0.0-20151124214156-6c035326b43f
 	github.com/martini-contrib/render v0.0.0-20150707142108-ec18f8345a11
 	github.com/mattn/go-sqlite3 v1.14.15
 	github.com/opencontainers/runc v1.0.0-rc1.0.20160613132442-8fbe19e02015 // indirect
 	github.com/oxtoacart/bpool v0.0.0-20150712133111-4e1c5567d7c2 // indirect
+	github.com/rivo/tview v0.0.0-20210125085121-dbc1f32bb1d0
 	github.com/russross/blackfriday/v2 v2.0.1
-	github.com/russross/meddler v1.0.1 // indirect
+	github.com/russross/meddler v1.0.1
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/stretchr/testify v1.5.1 // indirect
+	github.com/zalando/go-keyring v0.1.1
 	golang.org/x/crypto v0.0.0-20220126234351-aa10faf2a1f8
 	golang.org/x/net v0.0.0-20211112202133-69e39bad7dc2
 	gopkg.in/gcfg.v1 v1.2.3
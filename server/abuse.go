@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	// containers are checked for network use this often
+	abuseNetworkInterval = 2 * time.Second
+
+	// a container is killed if its processes use more than this many
+	// times the per-process CPU limit in total
+	abuseCPUFactor = 2
+
+	// a container is killed if it is at its process limit for this many
+	// consecutive samples (roughly one per second)
+	abuseForkSamples = 3
+)
+
+// containerUsage is what the monitor learned about a container:
+// the resources its processes used and, if it was killed, the reason.
+type containerUsage struct {
+	abuse      string
+	peakMemory int64
+	cpuTime    time.Duration
+}
+
+func (u *containerUsage) sample(elt *docker.Stats) {
+	peak := elt.MemoryStats.MaxUsage
+	if elt.MemoryStats.Usage > peak {
+		peak = elt.MemoryStats.Usage
+	}
+	if int64(peak) > u.peakMemory {
+		u.peakMemory = int64(peak)
+	}
+	if cpu := time.Duration(elt.CPUStats.CPUUsage.TotalUsage); cpu > u.cpuTime {
+		u.cpuTime = cpu
+	}
+}
+
+// monitor watches a running container, tracking the resources it uses
+// and looking for signs that the code in it is attacking the daycare
+// rather than solving the problem: trying to connect to the network,
+// using far more CPU time than the limits allow by spreading it across
+// processes, or filling the process table. A container caught doing any
+// of these is killed.
+// Exactly one report is sent on the result channel when the container
+// is gone or stop is closed.
+func (n *Nanny) monitor(limits *limits, stop <-chan bool, result chan<- *containerUsage) {
+	stats := make(chan *docker.Stats)
+	go func() {
+		err := dockerClient.Stats(docker.StatsOptions{
+			ID:     n.Container.ID,
+			Stats:  stats,
+			Stream: true,
+			Done:   stop,
+		})
+		if err != nil && !n.Closed {
+			log.Printf("error gathering stats for %s: %v", n.Name, err)
+		}
+	}()
+
+	usage := new(containerUsage)
+	atLimit := 0
+	var lastNetworkCheck time.Time
+	for elt := range stats {
+		if usage.abuse != "" {
+			// drain the channel until the container is gone
+			continue
+		}
+		usage.sample(elt)
+
+		// fork bombs sit at the process limit
+		if limits.maxThreads > 0 && elt.PidsStats.Current >= uint64(limits.maxThreads) {
+			atLimit++
+		} else {
+			atLimit = 0
+		}
+		cpuLimit := time.Duration(limits.maxCPU*abuseCPUFactor) * time.Second
+
+		switch {
+		case atLimit >= abuseForkSamples:
+			usage.abuse = fmt.Sprintf("too many processes: %d running, limit is %d", elt.PidsStats.Current, limits.maxThreads)
+
+		case limits.maxCPU > 0 && usage.cpuTime > cpuLimit:
+			usage.abuse = fmt.Sprintf("too much CPU time: %v used by all processes, limit is %ds per process", usage.cpuTime.Round(time.Second), limits.maxCPU)
+
+		case time.Since(lastNetworkCheck) >= abuseNetworkInterval:
+			// the container starts with only a loopback interface, so any
+			// attempt to reach another host fails for lack of a route
+			lastNetworkCheck = time.Now()
+			count, err := n.countNoRoutes()
+			if err != nil {
+				// the container may be shutting down
+				break
+			}
+			if count > 0 {
+				usage.abuse = fmt.Sprintf("outbound network connection attempted (%d packets with no route)", count)
+			}
+		}
+
+		if usage.abuse != "" {
+			log.Printf("abuse detected in %s: %s", n.Name, usage.abuse)
+			if err := n.Shutdown("abuse"); err != nil {
+				log.Printf("error shutting down container: %v", err)
+			}
+		}
+	}
+
+	// stats arrive about once a second, so read the final totals
+	// directly to measure short runs
+	if usage.abuse == "" && !n.Closed {
+		if err := n.readCgroupUsage(usage); err != nil {
+			log.Printf("error reading resource usage for %s: %v", n.Name, err)
+		}
+	}
+
+	result <- usage
+}
+
+// readCgroupUsage updates the usage totals from the container's cgroup,
+// which is visible inside the container under either cgroup v1 or v2.
+func (n *Nanny) readCgroupUsage(usage *containerUsage) error {
+	out, err := n.execQuiet([]string{"/bin/grep", "-s", "-H", "",
+		"/sys/fs/cgroup/memory.peak",
+		"/sys/fs/cgroup/cpu.stat",
+		"/sys/fs/cgroup/memory/memory.max_usage_in_bytes",
+		"/sys/fs/cgroup/cpuacct/cpuacct.usage",
+	})
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) == 0 {
+			continue
+		}
+		val, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(parts[0], "memory.peak"), strings.HasSuffix(parts[0], "memory.max_usage_in_bytes"):
+			if val > usage.peakMemory {
+				usage.peakMemory = val
+			}
+		case strings.HasSuffix(parts[0], "cpu.stat") && fields[0] == "usage_usec":
+			if cpu := time.Duration(val) * time.Microsecond; cpu > usage.cpuTime {
+				usage.cpuTime = cpu
+			}
+		case strings.HasSuffix(parts[0], "cpuacct.usage"):
+			if cpu := time.Duration(val); cpu > usage.cpuTime {
+				usage.cpuTime = cpu
+			}
+		}
+	}
+	return nil
+}
+
+// countNoRoutes reports how many packets the container has tried
+// to send to addresses it has no route to, for both IPv4 and IPv6.
+func (n *Nanny) countNoRoutes() (int64, error) {
+	out, err := n.execQuiet([]string{"/bin/cat", "/proc/net/snmp", "/proc/net/snmp6"})
+	if err != nil {
+		return 0, err
+	}
+	return parseNoRoutes(out), nil
+}
+
+// execQuiet runs a command in the container and returns its output.
+// Unlike Exec, it does not record anything in the transcript.
+func (n *Nanny) execQuiet(cmd []string) ([]byte, error) {
+	exec, err := dockerClient.CreateExec(docker.CreateExecOptions{
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          false,
+		Cmd:          cmd,
+		Container:    n.Container.ID,
+		User:         uidgid(n.UID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := new(bytes.Buffer)
+	err = dockerClient.StartExec(exec.ID, docker.StartExecOptions{
+		Detach:       false,
+		Tty:          false,
+		OutputStream: out,
+		ErrorStream:  new(bytes.Buffer),
+		RawTerminal:  false,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// parseNoRoutes finds the OutNoRoutes counters in the contents of
+// /proc/net/snmp and /proc/net/snmp6 and returns their sum.
+// snmp lists IPv4 counters as a line of names followed by a line of values,
+// while snmp6 has one name and value per line.
+func parseNoRoutes(data []byte) int64 {
+	var total int64
+	var header []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 2 && fields[0] == "Ip6OutNoRoutes":
+			if val, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				total += val
+			}
+
+		case len(fields) > 1 && fields[0] == "Ip:":
+			if header == nil {
+				header = fields
+				continue
+			}
+			for i, name := range header {
+				if name == "OutNoRoutes" && i < len(fields) {
+					if val, err := strconv.ParseInt(fields[i], 10, 64); err == nil {
+						total += val
+					}
+				}
+			}
+			header = nil
+		}
+	}
+	return total
+}
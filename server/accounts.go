@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	accountMinPassword   = 8
+	accountVerifyTimeout = 48 * time.Hour
+	accountResetTimeout  = time.Hour
+)
+
+// account holds the login credentials for a user who registered
+// directly instead of launching through an LMS.
+// The token is used both to verify the email address and to reset
+// the password, and is cleared once it has been used.
+type account struct {
+	ID             int64     `meddler:"id,pk"`
+	UserID         int64     `meddler:"user_id"`
+	Email          string    `meddler:"email"`
+	PasswordHash   string    `meddler:"password_hash"`
+	Verified       bool      `meddler:"verified"`
+	Token          string    `meddler:"token"`
+	TokenExpiresAt time.Time `meddler:"token_expires_at,localtime"`
+	CreatedAt      time.Time `meddler:"created_at,localtime"`
+	UpdatedAt      time.Time `meddler:"updated_at,localtime"`
+}
+
+func (a *account) newToken(now time.Time, timeout time.Duration) error {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return fmt.Errorf("error generating token: %v", err)
+	}
+	a.Token = base64.RawURLEncoding.EncodeToString(raw)
+	a.TokenExpiresAt = now.Add(timeout)
+	return nil
+}
+
+func (a *account) setPassword(password string) error {
+	if len(password) < accountMinPassword {
+		return fmt.Errorf("password must be at least %d characters long", accountMinPassword)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("error hashing password: %v", err)
+	}
+	a.PasswordHash = string(hash)
+	return nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// getAccountByToken finds the account with an unexpired token,
+// reporting an error to the client if there is none.
+func getAccountByToken(w http.ResponseWriter, tx *sql.Tx, token string, now time.Time) (*account, bool) {
+	if token == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "missing token")
+		return nil, false
+	}
+	acct := new(account)
+	if err := meddler.QueryRow(tx, acct, `SELECT * FROM accounts WHERE token = ?`, token); err != nil {
+		if err == sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusNotFound, "token not found; it may have already been used")
+		} else {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		}
+		return nil, false
+	}
+	if acct.TokenExpiresAt.Before(now) {
+		loggedHTTPErrorf(w, http.StatusGone, "token has expired; please request a new one")
+		return nil, false
+	}
+	return acct, true
+}
+
+// PostAccount handles requests to /v2/accounts,
+// registering a new user with an email address and password.
+// The account cannot be used until the address is verified
+// using the link sent to it.
+// The response is the same whether or not the account already exists,
+// in which case the owner is sent a notice instead.
+func PostAccount(w http.ResponseWriter, tx *sql.Tx, req AccountRequest) {
+	now := time.Now()
+
+	req.Name = strings.TrimSpace(req.Name)
+	req.Email = normalizeEmail(req.Email)
+	if req.Name == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if !strings.Contains(req.Email, "@") {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "a valid email address is required")
+		return
+	}
+	acct := &account{
+		Email:     req.Email,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := acct.setPassword(req.Password); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM accounts WHERE email = ?`, req.Email).Scan(&count); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if count > 0 {
+		// do not reveal which addresses have accounts
+		link := fmt.Sprintf("https://%s/login/", Config.Hostname)
+		body := fmt.Sprintf("Someone asked to create a %s account for this address, but you already have one.\n\n"+
+			"To log in or reset your password, go to:\n\n%s\n\n"+
+			"If you did not ask for an account, you can ignore this message.\n",
+			Config.ToolName, link)
+		if err := sendEmail([]string{req.Email}, Config.ToolName+" account already exists", body); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "error sending account notice email: %v", err)
+			return
+		}
+		log.Printf("account registration repeated for existing address %s", req.Email)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if err := acct.newToken(now, accountVerifyTimeout); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	// the LTI fields must be unique, so fill them with placeholders;
+	// the canvas ID is left empty until the user launches from Canvas
+	user := &User{
+		Name:           req.Name,
+		Email:          req.Email,
+		LtiID:          "account:" + req.Email,
+		CanvasLogin:    "account:" + req.Email,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		LastSignedInAt: now,
+	}
+	if err := meddler.Insert(tx, "users", user); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	acct.UserID = user.ID
+	if err := meddler.Insert(tx, "accounts", acct); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	link := fmt.Sprintf("https://%s/v2/accounts/verify?token=%s", Config.Hostname, url.QueryEscape(acct.Token))
+	body := fmt.Sprintf("Welcome to %s, %s.\n\n"+
+		"To finish creating your account, open this link within %v:\n\n%s\n\n"+
+		"If you did not ask for an account, you can ignore this message.\n",
+		Config.ToolName, user.Name, accountVerifyTimeout, link)
+	if err := sendEmail([]string{acct.Email}, Config.ToolName+" account verification", body); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error sending verification email: %v", err)
+		return
+	}
+	log.Printf("account created for user %d (%s)", user.ID, user.Email)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// GetAccountVerify handles requests to /v2/accounts/verify,
+// confirming the email address of a new account.
+//
+// Parameter token=<...> must be present, and must be the token emailed to the user.
+// The user is redirected to the login page.
+func GetAccountVerify(w http.ResponseWriter, r *http.Request, tx *sql.Tx) {
+	now := time.Now()
+
+	acct, ok := getAccountByToken(w, tx, r.FormValue("token"), now)
+	if !ok {
+		return
+	}
+	acct.Verified = true
+	acct.Token = ""
+	acct.UpdatedAt = now
+	if err := meddler.Update(tx, "accounts", acct); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("account verified for user %d (%s)", acct.UserID, acct.Email)
+
+	http.Redirect(w, r, "/login/?verified=true", http.StatusSeeOther)
+}
+
+// PostAccountLogin handles requests to /v2/accounts/login,
+// checking an email address and password and signing the user in.
+// It returns a session cookie for use by grind and a session key
+// that can be given to grind login.
+func PostAccountLogin(w http.ResponseWriter, tx *sql.Tx, req AccountRequest, render render.Render) {
+	now := time.Now()
+
+	acct := new(account)
+	if err := meddler.QueryRow(tx, acct, `SELECT * FROM accounts WHERE email = ?`, normalizeEmail(req.Email)); err != nil {
+		if err == sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusUnauthorized, "incorrect email address or password")
+		} else {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		}
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(acct.PasswordHash), []byte(req.Password)); err != nil {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "incorrect email address or password")
+		return
+	}
+	if !acct.Verified {
+		loggedHTTPErrorf(w, http.StatusForbidden, "you must verify your email address using the link sent to %s before logging in", acct.Email)
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET last_signed_in_at = ? WHERE id = ?`, now.UTC(), acct.UserID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	session := NewSession(acct.UserID)
+	cookie := session.Save(w)
+	key := loginRecords.Insert(acct.UserID)
+
+	result := map[string]string{"cookie": cookie, "session": key}
+	render.JSON(http.StatusOK, result)
+}
+
+// PostAccountReset handles requests to /v2/accounts/reset,
+// emailing a link to reset the password of an account.
+// The response is the same whether or not the account exists.
+func PostAccountReset(w http.ResponseWriter, tx *sql.Tx, req AccountRequest) {
+	now := time.Now()
+
+	acct := new(account)
+	if err := meddler.QueryRow(tx, acct, `SELECT * FROM accounts WHERE email = ?`, normalizeEmail(req.Email)); err != nil {
+		if err != sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		}
+		return
+	}
+
+	// an unverified account keeps its longer verification window
+	timeout := accountResetTimeout
+	if !acct.Verified {
+		timeout = accountVerifyTimeout
+	}
+	if err := acct.newToken(now, timeout); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	acct.UpdatedAt = now
+	if err := meddler.Update(tx, "accounts", acct); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	link := fmt.Sprintf("https://%s/login/?reset=%s", Config.Hostname, url.QueryEscape(acct.Token))
+	body := fmt.Sprintf("Someone asked to reset the password for your %s account.\n\n"+
+		"To choose a new password, open this link within %v:\n\n%s\n\n"+
+		"If you did not ask for this, you can ignore this message.\n",
+		Config.ToolName, timeout, link)
+	if err := sendEmail([]string{acct.Email}, Config.ToolName+" password reset", body); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error sending password reset email: %v", err)
+		return
+	}
+}
+
+// PostAccountPassword handles requests to /v2/accounts/password,
+// setting a new password using the token from a password reset email.
+// Since the token was sent by email, this also verifies the address.
+func PostAccountPassword(w http.ResponseWriter, tx *sql.Tx, req AccountRequest) {
+	now := time.Now()
+
+	acct, ok := getAccountByToken(w, tx, req.Token, now)
+	if !ok {
+		return
+	}
+	if err := acct.setPassword(req.Password); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	acct.Verified = true
+	acct.Token = ""
+	acct.UpdatedAt = now
+	if err := meddler.Update(tx, "accounts", acct); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("password reset for user %d (%s)", acct.UserID, acct.Email)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// GetAssignmentAnalytics handles requests to /v2/assignments/:assignment_id/analytics,
+// returning progress statistics for every student with this assignment.
+//
+// If parameter section=<...> present, only students in that section are counted.
+func GetAssignmentAnalytics(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignment, ok := getInstructorAssignment(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+	if assignment.ProblemSetID == 0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "assignment %d does not have a problem set", assignment.ID)
+		return
+	}
+	sectionID, ok := getSectionFilter(w, r, tx, assignment.CourseID)
+	if !ok {
+		return
+	}
+
+	where, args := sectionWhere(`course_id = ? AND lti_id = ? AND NOT instructor`, []interface{}{assignment.CourseID, assignment.LtiID}, sectionID)
+	analytics, err := computeAnalytics(tx, assignment.ProblemSetID, where, args...)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, analytics)
+}
+
+// GetProblemSetAnalytics handles requests to /v2/problem_sets/:problem_set_id/analytics,
+// returning progress statistics for every student assigned this problem set
+// in any course.
+func GetProblemSetAnalytics(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	problemSet := new(ProblemSet)
+	if err := meddler.Load(tx, "problem_sets", problemSet, problemSetID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	analytics, err := computeAnalytics(tx, problemSetID, `problem_set_id = ? AND NOT instructor`, problemSetID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, analytics)
+}
+
+// computeAnalytics gathers statistics for the steps in a problem set across
+// the student assignments selected by the given WHERE clause.
+func computeAnalytics(tx *sql.Tx, problemSetID int64, where string, args ...interface{}) (*Analytics, error) {
+	analytics := &Analytics{ScoreDistribution: make([]int, 10), Steps: []*StepAnalytics{}}
+
+	// list every step of every problem in the set
+	type stepKey struct {
+		problemID int64
+		step      int64
+	}
+	steps := make(map[stepKey]*StepAnalytics)
+	rows, err := tx.Query(`SELECT problems.id, problems.unique_id, problem_steps.step `+
+		`FROM problem_set_problems `+
+		`JOIN problems ON problem_set_problems.problem_id = problems.id `+
+		`JOIN problem_steps ON problems.id = problem_steps.problem_id `+
+		`WHERE problem_set_problems.problem_set_id = ? `+
+		`ORDER BY problems.unique_id, problem_steps.step`, problemSetID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		elt := new(StepAnalytics)
+		if err := rows.Scan(&elt.ProblemID, &elt.ProblemUnique, &elt.Step); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		analytics.Steps = append(analytics.Steps, elt)
+		steps[stepKey{elt.ProblemID, elt.Step}] = elt
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// score distribution
+	var assignments []*Assignment
+	if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments WHERE `+where, args...); err != nil {
+		return nil, err
+	}
+	analytics.Students = len(assignments)
+	total := 0.0
+	for _, asst := range assignments {
+		total += asst.Score
+		bucket := int(asst.Score * 10.0)
+		if bucket > 9 {
+			bucket = 9
+		} else if bucket < 0 {
+			bucket = 0
+		}
+		analytics.ScoreDistribution[bucket]++
+	}
+	if len(assignments) > 0 {
+		analytics.AverageScore = total / float64(len(assignments))
+	}
+
+	// walk the commit history in order for each student and step
+	rows, err = tx.Query(`SELECT assignment_id, problem_id, step, report_card != 'null', score, COALESCE(active_time, 0), created_at, updated_at `+
+		`FROM commit_history WHERE assignment_id IN (SELECT id FROM assignments WHERE `+where+`) `+
+		`ORDER BY assignment_id, problem_id, step, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	times := make(map[stepKey][]float64)
+	activeTimes := make(map[stepKey][]float64)
+	type progress struct {
+		assignmentID int64
+		key          stepKey
+		first        time.Time
+		attempts     int
+		passed       bool
+	}
+	var current *progress
+	attempts := make(map[stepKey]int)
+	finish := func() {
+		if current == nil {
+			return
+		}
+		if elt := steps[current.key]; elt != nil {
+			elt.Started++
+			attempts[current.key] += current.attempts
+			if current.passed {
+				elt.Passed++
+			}
+		}
+	}
+	for rows.Next() {
+		var assignmentID, problemID, step int64
+		var graded bool
+		var score sql.NullFloat64
+		var activeTime int64
+		var createdAt, updatedAt time.Time
+		if err := rows.Scan(&assignmentID, &problemID, &step, &graded, &score, &activeTime, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		key := stepKey{problemID, step}
+		if current == nil || current.assignmentID != assignmentID || current.key != key {
+			finish()
+			current = &progress{assignmentID: assignmentID, key: key, first: createdAt}
+		}
+		if graded {
+			current.attempts++
+		}
+		if !current.passed && score.Valid && score.Float64 >= 1.0 {
+			current.passed = true
+			// the step was opened when the first version was created,
+			// and each version was submitted at its updated time
+			times[key] = append(times[key], updatedAt.Sub(current.first).Seconds())
+
+			// only students who track their time report it
+			if activeTime > 0 {
+				activeTimes[key] = append(activeTimes[key], float64(activeTime))
+			}
+		}
+	}
+	finish()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for key, elt := range steps {
+		if analytics.Students > 0 {
+			elt.CompletionPercent = 100.0 * float64(elt.Passed) / float64(analytics.Students)
+		}
+		if elt.Started > 0 {
+			elt.AverageAttempts = float64(attempts[key]) / float64(elt.Started)
+		}
+		if list := times[key]; len(list) > 0 {
+			elt.MedianTimeToPass = median(list)
+		}
+		if list := activeTimes[key]; len(list) > 0 {
+			elt.MedianActiveTime = median(list)
+		}
+	}
+
+	return analytics, nil
+}
+
+// GetProblemFlaky handles requests to /v2/problems/:problem_id/flaky,
+// returning every test in the problem that has been marked flaky in any
+// graded submission, most often flaky first.
+func GetProblemFlaky(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	problem := new(Problem)
+	if err := meddler.Load(tx, "problems", problem, problemID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	type testKey struct {
+		step int64
+		name string
+	}
+	tests := make(map[testKey]*FlakyTest)
+	rows, err := tx.Query(`SELECT step, report_card FROM commit_history WHERE problem_id = ? AND report_card != 'null'`, problem.ID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var step int64
+		var raw []byte
+		if err := rows.Scan(&step, &raw); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		reportCard := new(ReportCard)
+		if err := json.Unmarshal(raw, reportCard); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "error decoding report card: %v", err)
+			return
+		}
+		for _, result := range reportCard.Results {
+			key := testKey{step, result.Name}
+			elt, exists := tests[key]
+			if !exists {
+				elt = &FlakyTest{ProblemID: problem.ID, Step: step, Name: result.Name}
+				tests[key] = elt
+			}
+			elt.Runs++
+			if result.Outcome == "flaky" {
+				elt.Flaky++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	flaky := []*FlakyTest{}
+	for _, elt := range tests {
+		if elt.Flaky > 0 {
+			elt.FlakeRate = float64(elt.Flaky) / float64(elt.Runs)
+			flaky = append(flaky, elt)
+		}
+	}
+	sort.Slice(flaky, func(i, j int) bool {
+		if flaky[i].FlakeRate != flaky[j].FlakeRate {
+			return flaky[i].FlakeRate > flaky[j].FlakeRate
+		}
+		if flaky[i].Step != flaky[j].Step {
+			return flaky[i].Step < flaky[j].Step
+		}
+		return flaky[i].Name < flaky[j].Name
+	})
+
+	render.JSON(http.StatusOK, flaky)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	. "github.com/russross/codegrinder/types"
+)
+
+// Every response carries the API revision of the server, and grind sends
+// its own with every request. Requests from clients older than
+// APIVersionMinimum are refused so that old behavior can be dropped,
+// except for the requests grind makes to upgrade itself.
+// Requests without the header come from browsers and older clients and
+// are always accepted.
+//
+// An endpoint that is going away is wrapped with deprecated, which adds
+// the Deprecation and Sunset headers of RFC 8594 so that grind can warn
+// users well before it stops working:
+//
+//	r.Get("/v2/old", counter, deprecated("2027-01-01", "/v2/new"), withTx, ...)
+
+func negotiateAPIVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(APIVersionHeader, strconv.Itoa(APIVersion))
+	s := r.Header.Get(APIVersionHeader)
+	if s == "" {
+		return
+	}
+	version, err := strconv.Atoi(s)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "invalid %s header: %q", APIVersionHeader, s)
+		return
+	}
+	// old clients must still be able to upgrade themselves
+	if r.URL.Path == "/v2/version" || strings.HasPrefix(r.URL.Path, "/v2/grind/") {
+		return
+	}
+	if version < APIVersionMinimum {
+		loggedHTTPCodedErrorf(w, http.StatusUpgradeRequired, ErrorUpgrade,
+			"this client uses API version %d, but the server requires version %d or later; please upgrade", version, APIVersionMinimum)
+		return
+	}
+}
+
+// deprecated marks an endpoint that will be removed on the sunset date,
+// given as YYYY-MM-DD, optionally naming the endpoint that replaces it.
+func deprecated(sunset string, successor string) martini.Handler {
+	when, err := time.Parse("2006-01-02", sunset)
+	if err != nil {
+		log.Fatalf("bad sunset date %q for deprecated endpoint: %v", sunset, err)
+	}
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Sunset", when.UTC().Format(http.TimeFormat))
+		if successor != "" {
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+		}
+	}
+}
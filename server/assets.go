@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// Large problem files (datasets, images, etc.) can be kept in an
+// S3-compatible object store instead of the database. When a problem is
+// saved, step files at least Config.AssetThreshold bytes long are uploaded
+// and replaced by a reference to their sha256 hash in step.Assets. Daycares
+// download assets when they are needed and keep a local cache, so the
+// files are not shipped through every commit bundle.
+
+var assetClient = &http.Client{Timeout: 5 * time.Minute}
+
+func assetsEnabled() bool {
+	return Config.AssetEndpoint != "" && Config.AssetBucket != ""
+}
+
+func assetKey(hash string) string {
+	return "assets/" + hash
+}
+
+func hashAsset(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+// storeStepAssets moves the large files of a problem step into the
+// asset store, recording their hashes in step.Assets.
+// Assets already in the store are not uploaded again.
+func storeStepAssets(step *ProblemStep) error {
+	if !assetsEnabled() {
+		return nil
+	}
+	if step.Assets == nil {
+		step.Assets = make(map[string]string)
+	}
+	for name, contents := range step.Files {
+		if len(contents) < Config.AssetThreshold {
+			continue
+		}
+		hash := hashAsset(contents)
+		if err := putAsset(hash, contents); err != nil {
+			return fmt.Errorf("storing asset %s for step %d: %v", name, step.Step, err)
+		}
+		step.Assets[name] = hash
+		delete(step.Files, name)
+		log.Printf("stored %s (%d bytes) for step %d as asset %s", name, len(contents), step.Step, hash)
+	}
+	return nil
+}
+
+// fetchStepAssets gathers the contents of all the assets referenced by
+// a problem step, using the local cache where possible.
+func fetchStepAssets(step *ProblemStep) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for name, hash := range step.Assets {
+		contents, err := getAsset(hash)
+		if err != nil {
+			return nil, fmt.Errorf("fetching asset %s (%s): %v", name, hash, err)
+		}
+		files[name] = contents
+	}
+	return files, nil
+}
+
+func putAsset(hash string, contents []byte) error {
+	resp, err := assetRequest("HEAD", hash, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = assetRequest("PUT", hash, contents)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("object store returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func getAsset(hash string) ([]byte, error) {
+	if len(hash) != sha256.Size*2 || strings.Trim(hash, "0123456789abcdef") != "" {
+		return nil, fmt.Errorf("invalid asset hash")
+	}
+	cached := filepath.Join(Config.AssetCache, hash)
+	if contents, err := ioutil.ReadFile(cached); err == nil && hashAsset(contents) == hash {
+		return contents, nil
+	}
+	if !assetsEnabled() {
+		return nil, fmt.Errorf("asset storage is not configured on this daycare")
+	}
+
+	resp, err := assetRequest("GET", hash, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("object store returned %s: %s", resp.Status, bytes.TrimSpace(contents))
+	}
+	if hashAsset(contents) != hash {
+		return nil, fmt.Errorf("downloaded asset does not match its hash")
+	}
+
+	// write the cache file under a temporary name so a partial file is never used
+	if err := os.MkdirAll(Config.AssetCache, 0755); err != nil {
+		log.Printf("unable to create asset cache directory: %v", err)
+		return contents, nil
+	}
+	// each download gets its own temporary file, since two actions
+	// may fetch the same asset at once
+	tmp, err := ioutil.TempFile(Config.AssetCache, hash+".tmp")
+	if err != nil {
+		log.Printf("unable to cache asset %s: %v", hash, err)
+		return contents, nil
+	}
+	_, err = tmp.Write(contents)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cached)
+	}
+	if err != nil {
+		log.Printf("unable to cache asset %s: %v", hash, err)
+		os.Remove(tmp.Name())
+	}
+	return contents, nil
+}
+
+// assetRequest sends a request for an asset to the object store,
+// signed using AWS signature version 4. Path-style URLs are used
+// so that MinIO and similar servers work without DNS setup.
+func assetRequest(method, hash string, body []byte) (*http.Response, error) {
+	u, err := url.Parse(strings.TrimSuffix(Config.AssetEndpoint, "/") + "/" + Config.AssetBucket + "/" + assetKey(hash))
+	if err != nil {
+		return nil, fmt.Errorf("bad asset endpoint: %v", err)
+	}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body = nil
+		req.ContentLength = 0
+	}
+	signAssetRequest(req, body, time.Now())
+	return assetClient.Do(req)
+}
+
+func signAssetRequest(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := hashAsset(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders string
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + Config.AssetRegion + "/s3/aws4_request"
+	toSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hashAsset([]byte(canonical)),
+	}, "\n")
+
+	key := []byte("AWS4" + Config.AssetSecretKey)
+	for _, part := range []string{day, Config.AssetRegion, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		Config.AssetAccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
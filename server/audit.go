@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// audit records an action in the audit log as part of the current transaction.
+func audit(tx *sql.Tx, currentUser *User, action, objectType string, objectID int64, format string, args ...interface{}) error {
+	event := &AuditEvent{
+		UserID:     currentUser.ID,
+		Action:     action,
+		ObjectType: objectType,
+		ObjectID:   objectID,
+		Detail:     fmt.Sprintf(format, args...),
+		CreatedAt:  time.Now(),
+	}
+	if err := meddler.Insert(tx, "audit_log", event); err != nil {
+		return fmt.Errorf("db error recording audit event: %v", err)
+	}
+	return nil
+}
+
+// GetAuditLog handles /v2/audit_log requests,
+// returning a list of audit events, newest first.
+//
+// If parameter userID=<...> present, results will be limited to actions by that user.
+// If parameter action=<...> present, results will be limited to that action.
+// If parameter objectType=<...> present, results will be limited to that type of object.
+// If parameter objectID=<...> present, results will be limited to that object.
+// If parameter limit=<...> present, at most that many events are returned.
+func GetAuditLog(w http.ResponseWriter, r *http.Request, tx *sql.Tx, render render.Render) {
+	if err := r.ParseForm(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "parsing form data: %v", err)
+		return
+	}
+	where := ""
+	args := []interface{}{}
+
+	if userID := r.FormValue("userID"); userID != "" {
+		id, err := parseID(w, "userID", userID)
+		if err != nil {
+			return
+		}
+		where, args = addWhereEq(where, args, "user_id", id)
+	}
+	if action := r.FormValue("action"); action != "" {
+		where, args = addWhereEq(where, args, "action", action)
+	}
+	if objectType := r.FormValue("objectType"); objectType != "" {
+		where, args = addWhereEq(where, args, "object_type", objectType)
+	}
+	if objectID := r.FormValue("objectID"); objectID != "" {
+		id, err := parseID(w, "objectID", objectID)
+		if err != nil {
+			return
+		}
+		where, args = addWhereEq(where, args, "object_id", id)
+	}
+	limit := 1000
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		limit = n
+	}
+
+	events := []*AuditEvent{}
+	if err := meddler.QueryAll(tx, &events, `SELECT * FROM audit_log`+where+` ORDER BY id DESC LIMIT `+strconv.Itoa(limit), args...); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, events)
+}
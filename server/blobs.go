@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// File contents for problem steps and commits are stored once in the
+// blobs table, keyed by their sha256 hash. The files columns hold a JSON
+// object mapping each file name to "sha256:<hash>", so the many commits
+// that leave starter files unchanged all share a single copy.
+//
+// Fields tagged with the blobs meddler are stored and reconstructed
+// automatically. Older rows that hold the contents inline (base64
+// encoded, as written by the json meddler) are still read correctly,
+// and are converted the next time they are saved.
+
+const blobPrefix = "sha256:"
+
+// blobTx is the transaction in progress. All database access is
+// serialized through dbMutex, so there is at most one at a time,
+// and the blobs meddler uses it to store and fetch file contents.
+var blobTx *sql.Tx
+
+func init() {
+	meddler.Register("blobs", blobMeddler{})
+}
+
+// beginTx starts a transaction and makes it available to the blobs meddler.
+// The caller must hold dbMutex and call endTx when the transaction is done.
+func beginTx() (*sql.Tx, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	blobTx = tx
+	return tx, nil
+}
+
+func endTx() {
+	blobTx = nil
+}
+
+type blobMeddler struct{}
+
+func (blobMeddler) PreRead(fieldAddr interface{}) (interface{}, error) {
+	return new([]byte), nil
+}
+
+func (blobMeddler) PostRead(fieldAddr, scanTarget interface{}) error {
+	field, ok := fieldAddr.(*map[string][]byte)
+	if !ok {
+		return fmt.Errorf("blobMeddler.PostRead: unknown struct field type: %T", fieldAddr)
+	}
+	raw := *scanTarget.(*[]byte)
+	var refs map[string]string
+	if err := json.Unmarshal(raw, &refs); err != nil {
+		return fmt.Errorf("blobMeddler.PostRead: %v", err)
+	}
+	if refs == nil {
+		*field = nil
+		return nil
+	}
+
+	files := make(map[string][]byte)
+	for name, ref := range refs {
+		if !strings.HasPrefix(ref, blobPrefix) {
+			// contents stored inline by an older version
+			contents, err := base64.StdEncoding.DecodeString(ref)
+			if err != nil {
+				return fmt.Errorf("blobMeddler.PostRead: decoding %s: %v", name, err)
+			}
+			files[name] = contents
+			continue
+		}
+		if blobTx == nil {
+			return fmt.Errorf("blobMeddler.PostRead: no transaction in progress")
+		}
+		var contents []byte
+		if err := blobTx.QueryRow(`SELECT contents FROM blobs WHERE hash = ?`, ref[len(blobPrefix):]).Scan(&contents); err != nil {
+			return fmt.Errorf("blobMeddler.PostRead: loading %s: %v", name, err)
+		}
+		if contents == nil {
+			contents = []byte{}
+		}
+		files[name] = contents
+	}
+	*field = files
+	return nil
+}
+
+func (blobMeddler) PreWrite(field interface{}) (interface{}, error) {
+	files, ok := field.(map[string][]byte)
+	if !ok {
+		return nil, fmt.Errorf("blobMeddler.PreWrite: unknown struct field type: %T", field)
+	}
+	if files == nil {
+		return []byte("null"), nil
+	}
+	if blobTx == nil {
+		return nil, fmt.Errorf("blobMeddler.PreWrite: no transaction in progress")
+	}
+
+	now := time.Now()
+	refs := make(map[string]string)
+	for name, contents := range files {
+		sum := sha256.Sum256(contents)
+		hash := hex.EncodeToString(sum[:])
+		if contents == nil {
+			contents = []byte{}
+		}
+		if _, err := blobTx.Exec(`INSERT OR IGNORE INTO blobs (hash, contents, created_at) VALUES (?, ?, ?)`, hash, contents, now.UTC()); err != nil {
+			return nil, fmt.Errorf("blobMeddler.PreWrite: storing %s: %v", name, err)
+		}
+		refs[name] = blobPrefix + hash
+	}
+	raw, err := json.Marshal(refs)
+	if err != nil {
+		return nil, fmt.Errorf("blobMeddler.PreWrite: %v", err)
+	}
+	return raw, nil
+}
+
+func validBlobHash(hash string) bool {
+	return len(hash) == sha256.Size*2 && strings.Trim(hash, "0123456789abcdef") == ""
+}
+
+// PostBlobsMissing handles requests to /v2/blobs/missing,
+// taking a list of file content hashes and returning the ones
+// that are not already stored on the server.
+func PostBlobsMissing(w http.ResponseWriter, tx *sql.Tx, query BlobQuery, render render.Render) {
+	missing := BlobQuery{Hashes: []string{}}
+	for _, hash := range query.Hashes {
+		if !validBlobHash(hash) {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "invalid hash %q", hash)
+			return
+		}
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM blobs WHERE hash = ?`, hash).Scan(&count); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if count == 0 {
+			missing.Hashes = append(missing.Hashes, hash)
+		}
+	}
+	render.JSON(http.StatusOK, &missing)
+}
+
+// resolveFileHashes fills in the contents of commit files that
+// the client uploaded by hash only. Hashes are never sent to clients,
+// so knowing one means already having the contents.
+func resolveFileHashes(tx *sql.Tx, commit *Commit) error {
+	if len(commit.FileHashes) == 0 {
+		commit.FileHashes = nil
+		return nil
+	}
+	if commit.Files == nil {
+		commit.Files = make(map[string][]byte)
+	}
+	for name, hash := range commit.FileHashes {
+		if _, exists := commit.Files[name]; exists {
+			return fmt.Errorf("file %s was uploaded both by contents and by hash", name)
+		}
+		if !validBlobHash(hash) {
+			return fmt.Errorf("invalid hash for file %s", name)
+		}
+		var contents []byte
+		if err := tx.QueryRow(`SELECT contents FROM blobs WHERE hash = ?`, hash).Scan(&contents); err == sql.ErrNoRows {
+			return fmt.Errorf("file %s was uploaded by hash, but the server does not have it", name)
+		} else if err != nil {
+			return fmt.Errorf("db error loading file %s: %v", name, err)
+		}
+		if contents == nil {
+			contents = []byte{}
+		}
+		commit.Files[name] = contents
+	}
+	commit.FileHashes = nil
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// CargoTestEvent is one line of the JSON output of the Rust test harness
+// (cargo test -- -Z unstable-options --format json). Each test binary
+// (unit tests, each integration test file, and doc tests) reports its own
+// suite, so the names are unique only within one run of cargo test.
+type CargoTestEvent struct {
+	Type     string  `json:"type"`  // "suite" or "test"
+	Event    string  `json:"event"` // "started", "ok", "failed", "ignored", "timeout"
+	Name     string  `json:"name"`
+	Stdout   string  `json:"stdout"`
+	Message  string  `json:"message"`
+	ExecTime float64 `json:"exec_time"`
+}
+
+func runAndParseCargoTest(n *Nanny, cmd []string) {
+	// run tests with JSON output on stdout
+	stdout, stderr, _, status, err := n.Exec(cmd, nil, false)
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error running unit tests: %v", err)
+		return
+	}
+
+	// did it end in a segfault?
+	if status > 127 {
+		n.ReportCard.LogAndFailf("Crashed with exit status %d while running unit tests", status)
+		n.ReportCard.AddResult(strings.Join(cmd, " "), exitOutcome(status), fmt.Sprintf("exit status %d", status), "")
+		return
+	}
+	n.ReportCard.Passed = status == 0
+
+	parseCargoTest(n, stdout.Bytes(), stderr.Bytes())
+}
+
+var testFailureContextRust = regexp.MustCompile(`([^/\s()'"]+\.rs):(\d+):\d+`)
+
+func rustFailureContext(body string) string {
+	if groups := testFailureContextRust.FindStringSubmatch(body); len(groups) > 2 {
+		return groups[1] + ":" + groups[2]
+	}
+	return ""
+}
+
+func parseCargoTest(n *Nanny, stdout, stderr []byte) {
+	passed, total := 0, 0
+	scanner := bufio.NewScanner(bytes.NewReader(stdout))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] != '{' {
+			// the harness prints plain text around the JSON events
+			continue
+		}
+		event := new(CargoTestEvent)
+		if err := json.Unmarshal(line, event); err != nil {
+			continue
+		}
+		if event.Type != "test" {
+			continue
+		}
+		switch event.Event {
+		case "ok":
+			total++
+			passed++
+			n.ReportCard.AddPassedResult(event.Name, "")
+		case "failed":
+			total++
+			body := event.Stdout
+			if event.Message != "" {
+				body = strings.TrimSpace(event.Message + "\n" + body)
+			}
+			n.ReportCard.AddResult(event.Name, "failed", body, rustFailureContext(body))
+		case "ignored":
+			total++
+			n.ReportCard.AddResult(event.Name, "skipped", "test is ignored", "")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		n.ReportCard.LogAndFailf("error reading unit test results: %v", err)
+		return
+	}
+
+	// no tests ran, which usually means the code did not compile
+	if total == 0 {
+		details := strings.TrimSpace(string(stderr))
+		if details == "" {
+			details = "no test results found"
+		}
+		n.ReportCard.LogAndFailf("No unit test results found")
+		n.ReportCard.AddResult("cargo test", "error", details, rustFailureContext(details))
+		return
+	}
+
+	// form a report card
+	n.ReportCard.Passed = n.ReportCard.Passed && passed == total
+	n.ReportCard.Note = localize(n.Locale, "Passed %d/%d tests in %v", passed, total, time.Since(n.Start))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCargoTest(t *testing.T) {
+	stdout := `
+running 3 tests
+{ "type": "suite", "event": "started", "test_count": 3 }
+{ "type": "test", "event": "started", "name": "tests::adds" }
+{ "type": "test", "name": "tests::adds", "event": "ok", "exec_time": 0.001 }
+{ "type": "test", "event": "started", "name": "tests::subtracts" }
+{ "type": "test", "name": "tests::subtracts", "event": "failed", "exec_time": 0.002, "stdout": "thread 'tests::subtracts' panicked at 'assertion failed: ` + "`(left == right)`" + `\n  left: ` + "`1`" + `,\n right: ` + "`2`" + `', src/lib.rs:14:9\n" }
+{ "type": "test", "event": "ignored", "name": "tests::slow" }
+{ "type": "suite", "event": "failed", "passed": 1, "failed": 1, "ignored": 1, "exec_time": 0.01 }
+`
+	n := newTestNanny()
+	parseCargoTest(n, []byte(stdout), nil)
+
+	if n.ReportCard.Passed {
+		t.Errorf("report card passed with a failed test")
+	}
+	if len(n.ReportCard.Results) != 3 {
+		t.Fatalf("got %d results, want 3", len(n.ReportCard.Results))
+	}
+	for i, want := range []struct{ name, outcome, context string }{
+		{"tests::adds", "passed", ""},
+		{"tests::subtracts", "failed", "lib.rs:14"},
+		{"tests::slow", "skipped", ""},
+	} {
+		got := n.ReportCard.Results[i]
+		if got.Name != want.name || got.Outcome != want.outcome || got.Context != want.context {
+			t.Errorf("result %d: got %s %s %q, want %s %s %q", i+1, got.Name, got.Outcome, got.Context, want.name, want.outcome, want.context)
+		}
+	}
+	if !strings.HasPrefix(n.ReportCard.Note, "Passed 1/3 tests in ") {
+		t.Errorf("note: got %q", n.ReportCard.Note)
+	}
+}
+
+func TestParseCargoTestCompileError(t *testing.T) {
+	stderr := "error[E0425]: cannot find value `x` in this scope\n --> src/lib.rs:3:5\n"
+	n := newTestNanny()
+	parseCargoTest(n, []byte("\n"), []byte(stderr))
+
+	if n.ReportCard.Passed {
+		t.Errorf("report card passed with no tests")
+	}
+	if len(n.ReportCard.Results) != 1 {
+		t.Fatalf("got %d results, want 1", len(n.ReportCard.Results))
+	}
+	got := n.ReportCard.Results[0]
+	if got.Outcome != "error" || !strings.Contains(got.Details, "E0425") || got.Context != "lib.rs:3" {
+		t.Errorf("got %s %q %q, want the compiler error", got.Outcome, got.Details, got.Context)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// GetUserMeKeys handles /v2/users/me/keys requests,
+// returning the client keys the current user has registered.
+func GetUserMeKeys(w http.ResponseWriter, tx *sql.Tx, currentUser *User, render render.Render) {
+	keys := []*ClientKey{}
+	if err := meddler.QueryAll(tx, &keys, `SELECT * FROM client_keys WHERE user_id = ? ORDER BY id`, currentUser.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, keys)
+}
+
+// PostUserMeKey handles POST /v2/users/me/keys requests,
+// registering the public key of a copy of grind the user has logged in to.
+// Registering a key that is already registered returns the existing record.
+func PostUserMeKey(w http.ResponseWriter, tx *sql.Tx, currentUser *User, key ClientKey, render render.Render) {
+	now := time.Now()
+
+	raw, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "public key must be a base64-encoded ed25519 public key")
+		return
+	}
+	key.Name = strings.TrimSpace(key.Name)
+	if len(key.Name) > 100 {
+		key.Name = key.Name[:100]
+	}
+
+	old := new(ClientKey)
+	if err := meddler.QueryRow(tx, old, `SELECT * FROM client_keys WHERE public_key = ?`, key.PublicKey); err == nil {
+		if old.UserID != currentUser.ID {
+			loggedHTTPErrorf(w, http.StatusConflict, "that key is registered to another user")
+			return
+		}
+		render.JSON(http.StatusOK, old)
+		return
+	} else if err != sql.ErrNoRows {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	key.ID = 0
+	key.UserID = currentUser.ID
+	key.CreatedAt = now
+	key.LastUsedAt = nil
+	if err := meddler.Insert(tx, "client_keys", &key); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := audit(tx, currentUser, "register", "client key", key.ID, "%s", key.Name); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	log.Printf("user %d (%s) registered client key %d (%s)", currentUser.ID, currentUser.Email, key.ID, key.Name)
+	render.JSON(http.StatusOK, &key)
+}
+
+// DeleteUserMeKey handles DELETE /v2/users/me/keys/:key_id requests,
+// revoking one of the current user's client keys.
+// Commits already signed with the key keep their record of it.
+func DeleteUserMeKey(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	keyID, err := parseID(w, "key_id", params["key_id"])
+	if err != nil {
+		return
+	}
+	result, err := tx.Exec(`DELETE FROM client_keys WHERE id = ? AND user_id = ?`, keyID, currentUser.ID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	} else if n == 0 {
+		loggedHTTPErrorf(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err := audit(tx, currentUser, "revoke", "client key", keyID, ""); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+}
+
+// verifyClientSignature checks the client signature on an unsigned commit
+// bundle, returning the ID of the key that signed it, or zero if the bundle
+// was not signed. The commit must have all of its files present.
+func verifyClientSignature(tx *sql.Tx, bundle *CommitBundle, now time.Time) (int64, error) {
+	if bundle.ClientKeyID == 0 && bundle.ClientSignature == "" {
+		return 0, nil
+	}
+	key := new(ClientKey)
+	if err := meddler.QueryRow(tx, key, `SELECT * FROM client_keys WHERE id = ? AND user_id = ?`, bundle.ClientKeyID, bundle.UserID); err == sql.ErrNoRows {
+		return 0, fmt.Errorf("the commit was signed with a key that is not registered to this user")
+	} else if err != nil {
+		return 0, fmt.Errorf("db error loading client key: %v", err)
+	}
+	public, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	if err != nil || len(public) != ed25519.PublicKeySize {
+		return 0, fmt.Errorf("client key %d is corrupt", key.ID)
+	}
+	sig, err := base64.StdEncoding.DecodeString(bundle.ClientSignature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(public), bundle.Commit.ClientSigningData(bundle.UserID), sig) {
+		return 0, fmt.Errorf("the client signature on the commit is not valid")
+	}
+	if _, err := tx.Exec(`UPDATE client_keys SET last_used_at = ? WHERE id = ?`, now.UTC(), key.ID); err != nil {
+		return 0, fmt.Errorf("db error updating client key: %v", err)
+	}
+	return key.ID, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-martini/martini"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Request and response bodies may be compressed with gzip or zstd.
+// Clients that understand zstd say so in Accept-Encoding, and grind
+// switches to zstd for uploads once it sees the server reply with it.
+
+// serveZstd compresses responses with zstd for clients that accept it.
+// It must come before the gzip handler, which it disables for these requests.
+func serveZstd(c martini.Context, w http.ResponseWriter, r *http.Request) {
+	if !acceptsEncoding(r, "zstd") {
+		return
+	}
+	r.Header.Del("Accept-Encoding")
+
+	headers := w.Header()
+	headers.Set("Content-Encoding", "zstd")
+	headers.Set("Vary", "Accept-Encoding")
+
+	zw, err := getZstdEncoder(w)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "zstd error: %v", err)
+		return
+	}
+	defer putZstdEncoder(zw)
+
+	zrw := zstdResponseWriter{zw, w.(martini.ResponseWriter)}
+	c.MapTo(zrw, (*http.ResponseWriter)(nil))
+
+	c.Next()
+
+	zrw.Header().Del("Content-Length")
+}
+
+// zstd encoders are expensive to create, so they are reused
+var zstdEncoders sync.Pool
+
+func getZstdEncoder(w io.Writer) (*zstd.Encoder, error) {
+	if zw, ok := zstdEncoders.Get().(*zstd.Encoder); ok {
+		zw.Reset(w)
+		return zw, nil
+	}
+	return zstd.NewWriter(w)
+}
+
+// putZstdEncoder finishes the stream and returns the encoder to the pool.
+func putZstdEncoder(zw *zstd.Encoder) {
+	zw.Close()
+	zw.Reset(nil)
+	zstdEncoders.Put(zw)
+}
+
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, elt := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(elt, ";", 2)[0]) == encoding {
+			return true
+		}
+	}
+	return false
+}
+
+type zstdResponseWriter struct {
+	w *zstd.Encoder
+	martini.ResponseWriter
+}
+
+func (zrw zstdResponseWriter) Write(p []byte) (int, error) {
+	if len(zrw.Header().Get("Content-Type")) == 0 {
+		zrw.Header().Set("Content-Type", http.DetectContentType(p))
+	}
+	return zrw.w.Write(p)
+}
+
+func (zrw zstdResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := zrw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the ResponseWriter doesn't support the Hijacker interface")
+	}
+	return hijacker.Hijack()
+}
+
+// decompress is a martini handler that decodes gzip or zstd request bodies.
+func decompress(c martini.Context, w http.ResponseWriter, r *http.Request) {
+	encoding := r.Header.Get("Content-Encoding")
+	if encoding != "gzip" && encoding != "zstd" {
+		return
+	}
+
+	r.Header.Del("Content-Encoding")
+	body := r.Body
+	defer body.Close()
+	var reader io.ReadCloser
+	if encoding == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "gzip error in request: %v", err)
+			return
+		}
+		reader = gz
+	} else {
+		zr, err := zstd.NewReader(body)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "zstd error in request: %v", err)
+			return
+		}
+		reader = zr.IOReadCloser()
+	}
+	defer reader.Close()
+	r.Body = reader
+	c.Next()
+}
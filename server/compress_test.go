@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestZstdEncoderPool(t *testing.T) {
+	// reused encoders must start a fresh stream each time
+	for i := 0; i < 3; i++ {
+		out := new(bytes.Buffer)
+		zw, err := getZstdEncoder(out)
+		if err != nil {
+			t.Fatalf("getZstdEncoder: %v", err)
+		}
+		want := fmt.Sprintf("response %d", i)
+		if _, err := zw.Write([]byte(want)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		putZstdEncoder(zw)
+
+		zr, err := zstd.NewReader(out)
+		if err != nil {
+			t.Fatalf("zstd.NewReader: %v", err)
+		}
+		got, err := ioutil.ReadAll(zr)
+		zr.Close()
+		if err != nil || string(got) != want {
+			t.Errorf("round %d: got %q (err %v), want %q", i, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+func courseArchiveJobName(courseID int64) string {
+	return fmt.Sprintf("archive-%d", courseID)
+}
+
+func courseArchivePath(courseID int64) string {
+	return filepath.Join(root, "archives", fmt.Sprintf("course-%d.zip", courseID))
+}
+
+// PostCourseArchive handles requests to /v2/courses/:course_id/archive,
+// starting a background job that builds a zip file of every student's
+// final submission for each problem, with report cards and a grade summary.
+// The result replaces any earlier archive of the same course and can be
+// downloaded from the same URL when the job finishes.
+func PostCourseArchive(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	course, ok := getInstructorCourse(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+
+	courseID := course.ID
+	job, err := jobs.Start(courseArchiveJobName(courseID), func() error {
+		return runCourseArchive(courseID)
+	})
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusConflict, "%v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, job)
+}
+
+// GetCourseArchive handles requests to /v2/courses/:course_id/archive,
+// returning the most recent archive of the course as a zip file.
+// If the archive job is still running, the job status is returned instead.
+func GetCourseArchive(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	course, ok := getInstructorCourse(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+
+	if job := jobs.Get(courseArchiveJobName(course.ID)); job != nil && job.FinishedAt == nil {
+		render.JSON(http.StatusAccepted, job)
+		return
+	}
+	filename := courseArchivePath(course.ID)
+	if _, err := os.Stat(filename); err != nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "no archive found for course %d", course.ID)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", archiveName(course.Label)+".zip"))
+	http.ServeFile(w, r, filename)
+}
+
+// archiveName makes a string safe to use as a file or directory name.
+func archiveName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if s == "" || s == "." || s == ".." {
+		s = "_"
+	}
+	return s
+}
+
+type archiveEntry struct {
+	dir    string
+	commit *Commit
+}
+
+func runCourseArchive(courseID int64) error {
+	var header []string
+	var table [][]string
+	var entries []*archiveEntry
+	err := withBackgroundTx(func(tx *sql.Tx) error {
+		var err error
+		if header, table, err = courseGrades(tx, courseID, 0, 0.0); err != nil {
+			return err
+		}
+
+		// the final commit for each student and problem is the one with the highest step
+		var commits []*Commit
+		if err := meddler.QueryAll(tx, &commits, `SELECT commits.* FROM commits `+
+			`JOIN assignments ON commits.assignment_id = assignments.id `+
+			`WHERE assignments.course_id = ? AND NOT assignments.instructor `+
+			`ORDER BY commits.assignment_id, commits.problem_id, commits.step`, courseID); err != nil {
+			return err
+		}
+		final := make(map[[2]int64]*Commit)
+		for _, commit := range commits {
+			final[[2]int64{commit.AssignmentID, commit.ProblemID}] = commit
+		}
+
+		for _, commit := range final {
+			var email, name, title, unique string
+			if err := tx.QueryRow(`SELECT users.email, users.name, assignments.canvas_title FROM assignments `+
+				`JOIN users ON assignments.user_id = users.id WHERE assignments.id = ?`, commit.AssignmentID).Scan(&email, &name, &title); err != nil {
+				return err
+			}
+			if err := tx.QueryRow(`SELECT unique_id FROM problems WHERE id = ?`, commit.ProblemID).Scan(&unique); err != nil {
+				return err
+			}
+			student := email
+			if student == "" {
+				student = fmt.Sprintf("%s-%d", name, commit.AssignmentID)
+			}
+			dir := path.Join("submissions", archiveName(student), archiveName(title), archiveName(unique))
+			entries = append(entries, &archiveEntry{dir: dir, commit: commit})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].dir < entries[j].dir })
+
+	// write to a temporary file and move it into place when complete
+	filename := courseArchivePath(courseID)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	fp, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := writeCourseArchive(fp, header, table, entries); err != nil {
+		fp.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+func writeCourseArchive(fp *os.File, header []string, table [][]string, entries []*archiveEntry) error {
+	z := zip.NewWriter(fp)
+	now := time.Now()
+	add := func(name string, contents []byte, modified time.Time) error {
+		f, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(contents)
+		return err
+	}
+
+	var grades bytes.Buffer
+	if err := writeCSV(&grades, header, table); err != nil {
+		return err
+	}
+	if err := add("grades.csv", grades.Bytes(), now); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		commit := entry.commit
+		var names []string
+		for name := range commit.Files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := add(path.Join(entry.dir, "files", name), commit.Files[name], commit.UpdatedAt); err != nil {
+				return err
+			}
+		}
+		names = nil
+		for name := range commit.Artifacts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := add(path.Join(entry.dir, "artifacts", name), commit.Artifacts[name], commit.UpdatedAt); err != nil {
+				return err
+			}
+		}
+
+		info := struct {
+			CommitID   int64       `json:"commitID"`
+			Step       int64       `json:"step"`
+			Score      float64     `json:"score"`
+			UpdatedAt  time.Time   `json:"updatedAt"`
+			ReportCard *ReportCard `json:"reportCard"`
+		}{commit.ID, commit.Step, commit.Score, commit.UpdatedAt, commit.ReportCard}
+		raw, err := json.MarshalIndent(&info, "", "    ")
+		if err != nil {
+			return err
+		}
+		if err := add(path.Join(entry.dir, "report_card.json"), append(raw, '\n'), commit.UpdatedAt); err != nil {
+			return err
+		}
+
+		if len(commit.Transcript) > 0 {
+			var transcript bytes.Buffer
+			if err := commit.DumpTranscript(&transcript); err != nil {
+				return err
+			}
+			if err := add(path.Join(entry.dir, "transcript.txt"), transcript.Bytes(), commit.UpdatedAt); err != nil {
+				return err
+			}
+		}
+	}
+
+	return z.Close()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// getCourseLimits returns the limits for a course,
+// or an empty set of limits if none have been set.
+func getCourseLimits(tx *sql.Tx, courseID int64) (*CourseLimits, error) {
+	limits := new(CourseLimits)
+	if err := meddler.QueryRow(tx, limits, `SELECT * FROM course_limits WHERE course_id = ?`, courseID); err != nil {
+		if err != sql.ErrNoRows {
+			return nil, fmt.Errorf("db error loading limits for course %d: %v", courseID, err)
+		}
+		limits.CourseID = courseID
+		limits.ProblemTypes = []string{}
+	}
+	return limits, nil
+}
+
+// GetCourseLimits handles requests to /v2/courses/:course_id/limits,
+// returning the problem types and daily daycare time allowed for the course.
+func GetCourseLimits(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	limits, err := getCourseLimits(tx, courseID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	render.JSON(http.StatusOK, limits)
+}
+
+// PutCourseLimits handles requests to /v2/courses/:course_id/limits,
+// setting the problem types a course may use and the number of minutes
+// per day its students may use on the daycares, both for the course as
+// a whole and for each student, and whether commits must be signed by a
+// copy of grind the student logged in to.
+func PutCourseLimits(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, limits CourseLimits, render render.Render) {
+	now := time.Now()
+
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	course := new(Course)
+	if err := meddler.Load(tx, "courses", course, courseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if limits.DailyMinutes < 0 || limits.UserMinutes < 0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "limits cannot be negative")
+		return
+	}
+	if limits.ProblemTypes == nil {
+		limits.ProblemTypes = []string{}
+	}
+	for _, name := range limits.ProblemTypes {
+		if _, err := getProblemType(tx, name); err != nil {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "unknown problem type %q", name)
+			return
+		}
+	}
+	sort.Strings(limits.ProblemTypes)
+
+	old, err := getCourseLimits(tx, course.ID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	limits.ID = old.ID
+	limits.CourseID = course.ID
+	limits.CreatedAt = old.CreatedAt
+	if limits.ID == 0 {
+		limits.CreatedAt = now
+	}
+	limits.UpdatedAt = now
+	if err := meddler.Save(tx, "course_limits", &limits); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	if err := audit(tx, currentUser, "limits", "course", course.ID, "problem types %v, %d minutes per day, %d minutes per student per day, signed commits %v",
+		limits.ProblemTypes, limits.DailyMinutes, limits.UserMinutes, limits.SignedCommits); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, &limits)
+}
+
+// checkCourseLimits reports whether a commit of the given problem type
+// may be run for an assignment, returning an HTTP status and error if not.
+// Daily time limits are only checked if a daycare is being assigned.
+func checkCourseLimits(tx *sql.Tx, assignment *Assignment, problemType string, checkTime bool, now time.Time) (int, error) {
+	limits, err := getCourseLimits(tx, assignment.CourseID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if len(limits.ProblemTypes) > 0 {
+		n := sort.SearchStrings(limits.ProblemTypes, problemType)
+		if n >= len(limits.ProblemTypes) || limits.ProblemTypes[n] != problemType {
+			return http.StatusForbidden, fmt.Errorf("problem type %s is not allowed in this course", problemType)
+		}
+	}
+	if !checkTime {
+		return http.StatusOK, nil
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).UTC()
+	if limits.DailyMinutes > 0 {
+		var seconds float64
+		if err := tx.QueryRow(`SELECT COALESCE(SUM(seconds), 0) FROM daycare_usage WHERE course_id = ? AND created_at >= ?`,
+			assignment.CourseID, midnight).Scan(&seconds); err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("db error: %v", err)
+		}
+		if seconds >= float64(limits.DailyMinutes*60) {
+			return http.StatusTooManyRequests, fmt.Errorf("this course has used its %d minutes of grading time for today; please try again tomorrow", limits.DailyMinutes)
+		}
+	}
+	if limits.UserMinutes > 0 {
+		var seconds float64
+		if err := tx.QueryRow(`SELECT COALESCE(SUM(seconds), 0) FROM daycare_usage WHERE course_id = ? AND user_id = ? AND created_at >= ?`,
+			assignment.CourseID, assignment.UserID, midnight).Scan(&seconds); err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("db error: %v", err)
+		}
+		if seconds >= float64(limits.UserMinutes*60) {
+			return http.StatusTooManyRequests, fmt.Errorf("you have used your %d minutes of grading time for today; please try again tomorrow", limits.UserMinutes)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// recordDaycareUsage notes the time a daycare reported spending
+// on actions for an assignment.
+func recordDaycareUsage(tx *sql.Tx, assignmentID, userID int64, seconds float64, now time.Time) error {
+	if seconds <= 0 {
+		return nil
+	}
+	var courseID int64
+	if err := tx.QueryRow(`SELECT course_id FROM assignments WHERE id = ?`, assignmentID).Scan(&courseID); err != nil {
+		if err == sql.ErrNoRows {
+			// the assignment is gone, so there is no course to charge
+			return nil
+		}
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO daycare_usage (course_id, assignment_id, user_id, seconds, created_at) VALUES (?, ?, ?, ?, ?)`,
+		courseID, assignmentID, userID, seconds, now.UTC())
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+func TestDaycareUsageLimits(t *testing.T) {
+	d := newTestDB(t)
+	course := d.course("cs1")
+	user := d.user("student")
+	asst := d.assignment(course, user, d.problemSet("loops-set"), false)
+	d.insert("course_limits", &CourseLimits{
+		CourseID:     course.ID,
+		ProblemTypes: []string{},
+		UserMinutes:  1,
+		CreatedAt:    d.now,
+		UpdatedAt:    d.now,
+	})
+
+	// usage noted on the daycare is reported whole and then cleared
+	noteDaycareUsage(asst.ID, user.ID, 40*time.Second)
+	noteDaycareUsage(asst.ID, user.ID, 30*time.Second)
+	noteDaycareUsage(0, user.ID, time.Hour)
+	usage := takeDaycareUsage()
+	if len(usage) != 1 || usage[0].AssignmentID != asst.ID || usage[0].UserID != user.ID || usage[0].Seconds != 70 {
+		t.Fatalf("got usage %v, want 70 seconds for assignment %d", usage, asst.ID)
+	}
+	if again := takeDaycareUsage(); len(again) != 0 {
+		t.Errorf("usage was reported twice: %v", again)
+	}
+
+	// usage that could not be reported is kept for the next attempt
+	restoreDaycareUsage(usage)
+	if again := takeDaycareUsage(); len(again) != 1 || again[0].Seconds != 70 {
+		t.Errorf("got restored usage %v, want the same 70 seconds", again)
+	}
+
+	// the registration signature covers the usage
+	reg := &DaycareRegistration{Hostname: "daycare", Time: d.now, Usage: usage}
+	sig := reg.ComputeSignature("secret")
+	reg.Usage[0].Seconds = 1
+	if reg.ComputeSignature("secret") == sig {
+		t.Errorf("changing the usage did not change the signature")
+	}
+	reg.Usage[0].Seconds = 70
+
+	if status, err := checkCourseLimits(d.tx, asst, "python3unittest", true, d.now); err != nil {
+		t.Fatalf("before any usage: got %d %v", status, err)
+	}
+	for _, elt := range usage {
+		if err := recordDaycareUsage(d.tx, elt.AssignmentID, elt.UserID, elt.Seconds, d.now); err != nil {
+			t.Fatalf("recordDaycareUsage: %v", err)
+		}
+	}
+	if status, _ := checkCourseLimits(d.tx, asst, "python3unittest", true, d.now); status != http.StatusTooManyRequests {
+		t.Errorf("after 70 seconds against a one minute limit: got status %d, want %d", status, http.StatusTooManyRequests)
+	}
+
+	// usage for an assignment that is gone is dropped
+	if err := recordDaycareUsage(d.tx, asst.ID+100, user.ID, 10, d.now); err != nil {
+		t.Errorf("usage for a missing assignment: %v", err)
+	}
+}
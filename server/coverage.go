@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A problem with the coverage option measures how much of the student's
+// code is exercised by their own tests after grading. The problem type's
+// Makefile provides a coverage target that runs the tests again under
+// go cover, coverage.py, or gcov and writes the total percentage to
+// coverage.txt. The percentage is recorded in the report card; with
+// coverage=N it must be at least N percent, which is added as a result
+// weighted by the coverageWeight option. With coverage=report it is
+// only recorded.
+
+const (
+	coverageFile   = "coverage.txt"
+	coverageResult = "test coverage"
+)
+
+func runCoverage(n *Nanny, limits *limits) {
+	minimum := -1.0
+	if limits.coverage != "report" {
+		val, err := strconv.ParseFloat(limits.coverage, 64)
+		if err != nil || val < 0 || val > 100 {
+			n.ReportCard.LogAndFailf("coverage option must be report or a percentage, found %q", limits.coverage)
+			return
+		}
+		minimum = val
+	}
+
+	cmd := []string{"make", "coverage"}
+	if _, _, _, _, err := n.Exec(cmd, nil, false); err != nil {
+		n.ReportCard.LogAndFailf("Error measuring test coverage: %v", err)
+		return
+	}
+	files, err := n.GetFiles([]string{coverageFile})
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error getting test coverage results: %v", err)
+		return
+	}
+	percent, err := parseCoverage(files[coverageFile])
+	if err != nil {
+		if minimum >= 0 {
+			n.ReportCard.AddFailedResult(coverageResult, fmt.Sprintf("test coverage could not be measured: %v", err), "").Weight = float64(limits.coverageWeight)
+		}
+		return
+	}
+
+	n.ReportCard.Coverage = percent
+	if n.ReportCard.Note != "" {
+		n.ReportCard.Note += ", "
+	}
+	n.ReportCard.Note += fmt.Sprintf("%.1f%% test coverage", percent)
+	if minimum < 0 {
+		return
+	}
+	details := fmt.Sprintf("your tests cover %.1f%% of your code, minimum is %g%%", percent, minimum)
+	if percent < minimum {
+		n.ReportCard.AddFailedResult(coverageResult, details, "").Weight = float64(limits.coverageWeight)
+	} else {
+		n.ReportCard.AddPassedResult(coverageResult, details).Weight = float64(limits.coverageWeight)
+	}
+}
+
+func parseCoverage(contents []byte) (float64, error) {
+	text := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(string(contents)), "%"))
+	if text == "" {
+		return 0, fmt.Errorf("no coverage report found")
+	}
+	percent, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse coverage report %q", text)
+	}
+	return percent, nil
+}
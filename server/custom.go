@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// A problem that does not fit any standard harness can grade with its
+// own script by setting the grader option to the name of a file in the
+// problem, e.g., grader=tests/grade.py. The script replaces the grade
+// action of the problem type and runs in the same container with file
+// descriptor 3 open for writing. It must write a report card to fd 3 as
+// JSON in the same form as types.ReportCard:
+//
+//	{"passed": false, "note": "2/3 checks passed", "results": [
+//	    {"name": "parses input", "outcome": "passed"},
+//	    {"name": "handles EOF", "outcome": "failed", "details": "..."}]}
+//
+// Fd 3 is the stdout pipe of the exec, which only the nanny reads; the
+// script's own stdout is sent to stderr. Nothing is read back from the
+// working directory, since student code can write there. The nanny
+// validates the report card before accepting it. The script should close
+// fd 3 in any student code that it runs.
+
+// a custom report card larger than this is rejected
+const customReportLimit = 1024 * 1024
+
+var customOutcomes = map[string]bool{
+	"passed":         true,
+	"failed":         true,
+	"timeout":        true,
+	"crash":          true,
+	"resource-limit": true,
+	"flaky":          true,
+	"error":          true,
+	"skipped":        true,
+}
+
+// customGraderCommand returns the command to run a problem's grader
+// script, or an error if the grader option does not name a file in
+// the problem directory.
+func customGraderCommand(grader string) ([]string, error) {
+	clean := path.Clean(grader)
+	if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return nil, fmt.Errorf("grader must be a file in the problem, found %q", grader)
+	}
+	script := `exec 3>&1 1>&2; chmod +x "$0" && exec "$0"`
+	return []string{"/bin/sh", "-c", script, "./" + clean}, nil
+}
+
+func runAndParseCustom(n *Nanny, cmd []string) {
+	// run the grader script
+	stdout, _, _, status, err := n.Exec(cmd, nil, false)
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error running grader: %v", err)
+		return
+	}
+
+	// did it end in a segfault?
+	if status > 127 {
+		n.ReportCard.LogAndFailf("Crashed with exit status %d while grading", status)
+		n.ReportCard.AddResult(cmd[3], exitOutcome(status), fmt.Sprintf("exit status %d", status), "")
+		return
+	}
+	n.ReportCard.Passed = status == 0
+	parseCustom(n, stdout.Bytes())
+}
+
+func parseCustom(n *Nanny, contents []byte) {
+	if len(bytes.TrimSpace(contents)) == 0 {
+		n.ReportCard.LogAndFailf("The grader did not write a report card")
+		return
+	}
+	if len(contents) > customReportLimit {
+		n.ReportCard.LogAndFailf("The grader's report card is %d bytes, the limit is %d", len(contents), customReportLimit)
+		return
+	}
+	rc := new(ReportCard)
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(rc); err != nil {
+		n.ReportCard.LogAndFailf("error parsing the grader's report card: %v", err)
+		return
+	}
+	if len(rc.Results) == 0 {
+		n.ReportCard.LogAndFailf("The grader's report card has no results")
+		return
+	}
+
+	// only take the results from the grader; the nanny measures the rest
+	passed, total := 0, 0
+	for i, elt := range rc.Results {
+		if elt == nil || strings.TrimSpace(elt.Name) == "" {
+			n.ReportCard.LogAndFailf("result %d in the grader's report card has no name", i+1)
+			return
+		}
+		if !customOutcomes[elt.Outcome] {
+			n.ReportCard.LogAndFailf("result %q in the grader's report card has unknown outcome %q", elt.Name, elt.Outcome)
+			return
+		}
+		if elt.Weight < 0 {
+			n.ReportCard.LogAndFailf("result %q in the grader's report card has negative weight", elt.Name)
+			return
+		}
+		if len(elt.Details) > MaxDetailsLen {
+			elt.Details = elt.Details[:MaxDetailsLen] + "\n… (truncated)"
+		}
+		total++
+		if elt.Passing() {
+			passed++
+		}
+	}
+	for _, elt := range rc.Results {
+		n.ReportCard.Results = append(n.ReportCard.Results, elt)
+	}
+
+	// form a report card
+	n.ReportCard.Passed = n.ReportCard.Passed && rc.Passed && passed == total
+	note := strings.TrimSpace(rc.Note)
+	if note == "" {
+		note = localize(n.Locale, "Passed %d/%d tests", passed, total)
+	}
+	n.ReportCard.Note = localize(n.Locale, "%s in %v", note, time.Since(n.Start))
+}
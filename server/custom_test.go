@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+func newTestNanny() *Nanny {
+	return &Nanny{
+		Name:       "test",
+		Start:      time.Now(),
+		ReportCard: NewReportCard(),
+	}
+}
+
+func TestCustomGraderCommandPaths(t *testing.T) {
+	for _, grader := range []string{"/bin/grade", "..", "../grade.sh", "tests/../../grade.sh", "."} {
+		if cmd, err := customGraderCommand(grader); err == nil {
+			t.Errorf("grader %q accepted as %q", grader, cmd)
+		}
+	}
+	for _, grader := range []string{"grade.sh", "tests/grade.py", "./tests/grade.py"} {
+		if _, err := customGraderCommand(grader); err != nil {
+			t.Errorf("grader %q rejected: %v", grader, err)
+		}
+	}
+}
+
+// The report card must come from the grader's fd 3 and nothing else:
+// not its stdout, and not a file that student code could have written.
+func TestCustomGraderReportCardChannel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "custom")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	forged := `{"passed": true, "results": [{"name": "forged", "outcome": "passed"}]}`
+	real := `{"passed": false, "results": [{"name": "real", "outcome": "failed"}]}`
+	files := map[string]string{
+		"grade.sh": "#!/bin/sh\n" +
+			"echo 'grader output'\n" +
+			"echo '" + real + "' >&3\n",
+		".reportcard.json": forged,
+		"reportcard.json":  forged,
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	cmd, err := customGraderCommand("grade.sh")
+	if err != nil {
+		t.Fatalf("customGraderCommand: %v", err)
+	}
+	for _, elt := range cmd {
+		if strings.Contains(elt, "reportcard") {
+			t.Errorf("grader command %q refers to a report card file", cmd)
+		}
+	}
+
+	// the nanny runs the command in the working directory and reads its stdout
+	run := exec.Command(cmd[0], cmd[1:]...)
+	run.Dir = dir
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	run.Stdout, run.Stderr = stdout, stderr
+	if err := run.Run(); err != nil {
+		t.Fatalf("running %q: %v: %s", cmd, err, stderr.String())
+	}
+	if strings.TrimSpace(stdout.String()) != real {
+		t.Errorf("report card channel: got %q, want %q", stdout.String(), real)
+	}
+	if !strings.Contains(stderr.String(), "grader output") {
+		t.Errorf("grader's own output should go to stderr, got %q", stderr.String())
+	}
+
+	n := newTestNanny()
+	parseCustom(n, stdout.Bytes())
+	if n.ReportCard.Passed || len(n.ReportCard.Results) != 1 || n.ReportCard.Results[0].Name != "real" {
+		t.Errorf("parsed report card: passed=%v results=%v", n.ReportCard.Passed, n.ReportCard.Results)
+	}
+}
+
+func TestParseCustom(t *testing.T) {
+	n := newTestNanny()
+	parseCustom(n, []byte(`{"passed": true, "note": "2/3 checks", "results": [
+		{"name": "one", "outcome": "passed"},
+		{"name": "two", "outcome": "flaky"},
+		{"name": "three", "outcome": "failed", "details": "expected 3"}]}`))
+	if n.ReportCard.Passed {
+		t.Errorf("a failed result should fail the report card even if the grader says it passed")
+	}
+	if len(n.ReportCard.Results) != 3 {
+		t.Fatalf("got %d results, want 3", len(n.ReportCard.Results))
+	}
+	if !strings.HasPrefix(n.ReportCard.Note, "2/3 checks in ") {
+		t.Errorf("note: got %q", n.ReportCard.Note)
+	}
+
+	n = newTestNanny()
+	parseCustom(n, []byte(`{"passed": true, "results": [{"name": "one", "outcome": "passed"}]}`))
+	if !n.ReportCard.Passed || !strings.HasPrefix(n.ReportCard.Note, "Passed 1/1 tests in ") {
+		t.Errorf("passing report card: passed=%v note=%q", n.ReportCard.Passed, n.ReportCard.Note)
+	}
+}
+
+func TestParseCustomRejects(t *testing.T) {
+	for label, contents := range map[string]string{
+		"empty":           "",
+		"not json":        "all tests passed",
+		"no results":      `{"passed": true, "results": []}`,
+		"unknown field":   `{"passed": true, "score": 1, "results": [{"name": "one", "outcome": "passed"}]}`,
+		"nameless result": `{"passed": true, "results": [{"outcome": "passed"}]}`,
+		"unknown outcome": `{"passed": true, "results": [{"name": "one", "outcome": "great"}]}`,
+		"negative weight": `{"passed": true, "results": [{"name": "one", "outcome": "passed", "weight": -1}]}`,
+		"too large":       `{"passed": true, "note": "` + strings.Repeat("x", customReportLimit) + `", "results": []}`,
+		"null result":     `{"passed": true, "results": [null]}`,
+		"only whitespace": " \n\t ",
+		"truncated":       `{"passed": true, "results": [{"name": "one", "outcome": "passed"}]`,
+	} {
+		n := newTestNanny()
+		parseCustom(n, []byte(contents))
+		if n.ReportCard.Passed {
+			t.Errorf("%s: report card passed", label)
+		}
+		if len(n.ReportCard.Results) != 0 {
+			t.Errorf("%s: got results %v from a rejected report card", label, n.ReportCard.Results)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,6 +24,12 @@ import (
 
 var dockerClient *docker.Client
 
+// defaultArtifacts are saved with graded commits for every problem
+// of a type, in addition to any the problem asks for
+var defaultArtifacts = map[string][]string{
+	"webtest": {"screenshots/*.png"},
+}
+
 type limits struct {
 	maxCPU      int64
 	maxSession  int64
@@ -31,6 +38,59 @@ type limits struct {
 	maxFileSize int64
 	maxMemory   int64
 	maxThreads  int64
+
+	// optional thresholds that fail a submission without stopping it,
+	// set only by problem options
+	failMemory  int64 // peak memory in megabytes
+	failCPUTime int64 // CPU time in milliseconds
+	failWall    int64 // wall time in milliseconds
+
+	// re-run failed grading once in a fresh container if non-zero
+	retry int64
+
+	// check graded code for memory errors with valgrind or asan,
+	// weighting each memory check result by memcheckWeight
+	memcheck       string
+	memcheckWeight int64
+
+	// run property-based tests with this many examples per property,
+	// seeded for each user and assignment
+	properties int64
+	seed       int64
+
+	// measure test coverage: "report" or a minimum percentage,
+	// with the coverage result weighted by coverageWeight
+	coverage       string
+	coverageWeight int64
+
+	// run these style checkers (comma separated) after grading,
+	// weighting each checker's result by lintWeight
+	lint       string
+	lintWeight int64
+
+	// grade with this script from the problem instead of the
+	// problem type's grade action
+	grader string
+
+	// give interactive actions a virtual display of this size (WxH)
+	// viewed in a browser, closed after displayIdle idle seconds
+	display     string
+	displayIdle int64
+
+	// keep this directory between actions for each user and problem,
+	// saved at workspaceHost on the daycare
+	workspace     string
+	workspaceSize int64
+	workspaceHost string
+
+	// grade these independent test groups (comma separated),
+	// running up to parallel of them at a time
+	groups   string
+	parallel int64
+
+	// keep at most this many kilobytes of each output stream,
+	// split between its beginning and its end
+	maxOutput int64
 }
 
 func newLimits(t *ProblemTypeAction) *limits {
@@ -51,6 +111,34 @@ func (l *limits) override(options []string) {
 		if len(parts) != 2 {
 			continue
 		}
+		if strings.TrimSpace(parts[0]) == "memcheck" {
+			l.memcheck = strings.TrimSpace(parts[1])
+			continue
+		}
+		if strings.TrimSpace(parts[0]) == "coverage" {
+			l.coverage = strings.TrimSpace(parts[1])
+			continue
+		}
+		if strings.TrimSpace(parts[0]) == "lint" {
+			l.lint = strings.TrimSpace(parts[1])
+			continue
+		}
+		if strings.TrimSpace(parts[0]) == "grader" {
+			l.grader = strings.TrimSpace(parts[1])
+			continue
+		}
+		if strings.TrimSpace(parts[0]) == "display" {
+			l.display = strings.TrimSpace(parts[1])
+			continue
+		}
+		if strings.TrimSpace(parts[0]) == "workspace" {
+			l.workspace = strings.TrimSpace(parts[1])
+			continue
+		}
+		if strings.TrimSpace(parts[0]) == "groups" {
+			l.groups = strings.TrimSpace(parts[1])
+			continue
+		}
 		val, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 63)
 		if err != nil {
 			continue
@@ -70,10 +158,207 @@ func (l *limits) override(options []string) {
 			l.maxMemory = val
 		case "maxThreads":
 			l.maxThreads = val
+		case "failMemory":
+			l.failMemory = val
+		case "failCPUTime":
+			l.failCPUTime = val
+		case "failWall":
+			l.failWall = val
+		case "retry":
+			l.retry = val
+		case "memcheckWeight":
+			l.memcheckWeight = val
+		case "properties":
+			l.properties = val
+		case "coverageWeight":
+			l.coverageWeight = val
+		case "lintWeight":
+			l.lintWeight = val
+		case "displayIdle":
+			l.displayIdle = val
+		case "workspaceSize":
+			l.workspaceSize = val
+		case "parallel":
+			l.parallel = val
+		case "maxOutput":
+			l.maxOutput = val
 		}
 	}
 }
 
+// check fails a report card if the action used more resources than
+// the problem allows.
+func (l *limits) check(rc *ReportCard) {
+	if l.failMemory > 0 && rc.PeakMemory > l.failMemory*1024*1024 {
+		msg := fmt.Sprintf("used %.1f MB of memory, limit is %d MB", float64(rc.PeakMemory)/(1024*1024), l.failMemory)
+		rc.Failf("%s", msg)
+		rc.AddResult("memory", "resource-limit", msg, "")
+	}
+	if l.failCPUTime > 0 && rc.CPUTime > time.Duration(l.failCPUTime)*time.Millisecond {
+		msg := fmt.Sprintf("used %v of CPU time, limit is %v", rc.CPUTime.Round(time.Millisecond), time.Duration(l.failCPUTime)*time.Millisecond)
+		rc.Failf("%s", msg)
+		rc.AddResult("CPU time", "resource-limit", msg, "")
+	}
+	if l.failWall > 0 && rc.WallTime > time.Duration(l.failWall)*time.Millisecond {
+		msg := fmt.Sprintf("took %v to run, limit is %v", rc.WallTime.Round(time.Millisecond), time.Duration(l.failWall)*time.Millisecond)
+		rc.Failf("%s", msg)
+		rc.AddResult("wall time", "resource-limit", msg, "")
+	}
+}
+
+// runAction copies the files into a nanny's container, runs the action,
+// and fills in the nanny's report card, including the resources used.
+// It returns false if the action could not be run at all.
+func (n *Nanny) runAction(action *ProblemTypeAction, limits *limits, files map[string][]byte, stdin io.Reader) bool {
+	// track resource use and watch for misbehaving code
+	stopWatching := make(chan bool)
+	usage := make(chan *containerUsage, 1)
+	go n.monitor(limits, stopWatching, usage)
+	defer func() {
+		if stopWatching != nil {
+			close(stopWatching)
+		}
+	}()
+
+	// copy the files to the container
+	if err := n.PutFiles(files, 0666); err != nil {
+		n.ReportCard.LogAndFailf("uploading files: %v", err)
+		return false
+	}
+	if limits.workspaceHost != "" {
+		if err := restoreWorkspace(n, limits); err != nil {
+			// carry on with an empty workspace
+			log.Printf("restoring workspace %s: %v", limits.workspaceHost, err)
+		}
+	}
+
+	// run the action
+	//log.Printf("%s: %s", action.ProblemType, action.Message)
+	cmd, parser := strings.Fields(action.Command), action.Parser
+	if limits.grader != "" && action.Action == "grade" {
+		custom, err := customGraderCommand(limits.grader)
+		if err != nil {
+			n.ReportCard.LogAndFailf("%v", err)
+			return false
+		}
+		cmd, parser = custom, "custom"
+	}
+	started := time.Now()
+	switch {
+	case parser == "xunit" && limits.groups != "" && action.Action == "grade":
+		runAndParseXUnitGroups(n, limits)
+
+	case problemTypeParsers[parser] != nil:
+		problemTypeParsers[parser](n, cmd)
+
+	case parser != "":
+		n.ReportCard.LogAndFailf("unknown parser %q for problem type %s action %s",
+			action.Parser, action.ProblemType, action.Action)
+		return false
+
+	default:
+		if limits.display != "" && action.Interactive {
+			if err := startDisplay(n, limits); err != nil {
+				n.ReportCard.LogAndFailf("starting display: %v", err)
+				return false
+			}
+		}
+		_, _, _, status, err := n.Exec(cmd, stdin, true)
+		if err != nil {
+			n.ReportCard.LogAndFailf("%q exec error: %v", strings.Join(cmd, " "), err)
+		}
+		if status != 0 {
+			err := fmt.Errorf("%q failed with exit status %d", strings.Join(cmd, " "), status)
+			n.ReportCard.LogAndFailf("%v", err)
+			if outcome := exitOutcome(status); outcome != "failed" {
+				n.ReportCard.AddResult(strings.Join(cmd, " "), outcome, err.Error(), "")
+			}
+		}
+	}
+	if limits.properties > 0 && parser != "" {
+		reportCounterexamples(n)
+	}
+	if limits.memcheck != "" && action.Action == "grade" && parser != "" && n.Reason == "" {
+		runMemCheck(n, limits)
+	}
+	if limits.coverage != "" && action.Action == "grade" && parser != "" && n.Reason == "" {
+		runCoverage(n, limits)
+	}
+	if limits.lint != "" && action.Action == "grade" && parser != "" && n.Reason == "" {
+		runLint(n, limits)
+	}
+	if n.Reason == "timeout" {
+		n.ReportCard.AddResult(strings.Join(cmd, " "), "timeout",
+			fmt.Sprintf("no activity for %d seconds", limits.maxTimeout), "")
+	}
+
+	n.ReportCard.WallTime = time.Since(started)
+	if limits.workspaceHost != "" && n.Reason == "" {
+		if err := saveWorkspace(n, limits); err != nil {
+			log.Printf("saving workspace %s: %v", limits.workspaceHost, err)
+		}
+	}
+
+	// record the resources used and whether the container was killed for misbehaving
+	close(stopWatching)
+	stopWatching = nil
+	used := <-usage
+	n.ReportCard.PeakMemory = used.peakMemory
+	n.ReportCard.CPUTime = used.cpuTime
+	if used.abuse != "" {
+		n.ReportCard.LogAndFailf("container killed: %s", used.abuse)
+		n.ReportCard.AddResult("container", "resource-limit", used.abuse, "")
+		n.ReportCard.Abuse = used.abuse
+		n.Events <- &EventMessage{Time: time.Now(), Event: "abuse", Error: used.abuse}
+	} else {
+		limits.check(n.ReportCard)
+	}
+	return true
+}
+
+// markFlaky compares the results of a failed run with those of a retry
+// in a fresh container, marking tests that passed in only one of the two
+// runs as flaky. Flaky tests count as passing, so a submission whose only
+// failures were flaky passes.
+func markFlaky(first, retry *ReportCard) {
+	before := make(map[string]string)
+	for _, elt := range first.Results {
+		before[elt.Name] = elt.Outcome
+	}
+	flaky := 0
+	passed := len(retry.Results) > 0
+	for _, elt := range retry.Results {
+		if old, exists := before[elt.Name]; exists && old != elt.Outcome && (old == "passed" || elt.Outcome == "passed") {
+			elt.Details = fmt.Sprintf("%s on the first run, %s on the retry\n%s", old, elt.Outcome, elt.Details)
+			elt.Outcome = "flaky"
+			flaky++
+		}
+		if !elt.Passing() {
+			passed = false
+		}
+	}
+	if flaky > 0 {
+		retry.Passed = retry.Passed || passed
+		retry.Note += fmt.Sprintf(" (retried, %d flaky)", flaky)
+	}
+}
+
+// exitOutcome classifies a non-zero exit status as a report card outcome.
+// Processes killed by SIGKILL (usually the out-of-memory killer), SIGXCPU,
+// or SIGXFSZ hit a resource limit; those killed by other signals crashed.
+func exitOutcome(status int) string {
+	switch {
+	case status == 0:
+		return "passed"
+	case status == 128+9, status == 128+24, status == 128+25:
+		return "resource-limit"
+	case status > 128:
+		return "crash"
+	default:
+		return "failed"
+	}
+}
+
 // SocketProblemTypeAction handles a request to /sockets/:problem_type/:action
 // It expects a websocket connection, which will receive a series of DaycareRequest objects
 // and will respond with DaycareResponse objects, though not in a one-to-one fashion.
@@ -85,8 +370,14 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 	// CORS header for browser-based requests if the TA is a different host than the daycare
 	w.Header().Set("Access-Control-Allow-Origin", "https://"+Config.TAHostname)
 
-	// get a websocket
-	socket, err := websocket.Upgrade(w, r, nil, 1024, 1024)
+	// get a websocket, agreeing to compressed frames if the client asks
+	responseHeader := make(http.Header)
+	for _, protocol := range websocket.Subprotocols(r) {
+		if protocol == SocketCompression {
+			responseHeader.Set("Sec-Websocket-Protocol", SocketCompression)
+		}
+	}
+	socket, err := websocket.Upgrade(w, r, responseHeader, 1024, 1024)
 	if err != nil {
 		loggedHTTPErrorf(w, http.StatusBadRequest, "websocket error: %v", err)
 		return
@@ -99,14 +390,14 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		msg := fmt.Sprintf(format, args...)
 		log.Print(msg)
 		res := &DaycareResponse{Error: msg}
-		if err := socket.WriteJSON(res); err != nil {
+		if err := WriteSocketJSON(socket, res); err != nil {
 			// what can we do? we already logged the error
 		}
 	}
 
 	// get the first message
 	req := new(DaycareRequest)
-	if err := socket.ReadJSON(req); err != nil {
+	if err := ReadSocketJSON(socket, req); err != nil {
 		logAndTransmitErrorf("error reading first request message: %v", err)
 		return
 	}
@@ -231,7 +522,7 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		return
 	}
 	if step.ProblemType != problemType.Name {
-		logAndTransmitErrorf("step number %d in the problem has problem type %q but the commit bundle included problem type %q", step.ProblemType, problemType)
+		logAndTransmitErrorf("step number %d in the problem has problem type %q but the commit bundle included problem type %q", commit.Step, step.ProblemType, problemType.Name)
 		return
 	}
 
@@ -240,6 +531,16 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 	for name, contents := range step.Files {
 		files[name] = contents
 	}
+	if len(step.Assets) > 0 {
+		assets, err := fetchStepAssets(step)
+		if err != nil {
+			logAndTransmitErrorf("%v", err)
+			return
+		}
+		for name, contents := range assets {
+			files[name] = contents
+		}
+	}
 	for name, contents := range commit.Files {
 		files[name] = contents
 	}
@@ -252,13 +553,36 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 	//log.Printf("launching container for %s", nannyName)
 	limits := newLimits(action)
 	limits.override(problem.Options)
+	limits.seed = propertySeed(commit.AssignmentID, req.CommitBundle.UserID)
+	if limits.workspace != "" {
+		limits.workspaceHost, err = prepareWorkspace(limits, req.CommitBundle.UserID, problem.ID, r.Form.Get("clearWorkspace") == "true")
+		if err != nil {
+			logAndTransmitErrorf("error preparing workspace: %v", err)
+			return
+		}
+	}
 	n, err := NewNanny(req.CommitBundle.ProblemType, problem, action.Interactive, action.Action, args, limits, nannyName)
 	if err != nil {
 		logAndTransmitErrorf("error creating container: %v", err)
 		return
 	}
+	n.Locale = req.CommitBundle.Locale
 	rw := newReadWriteBuffer()
 
+	// charge the time to the student however the action ends
+	defer func() {
+		noteDaycareUsage(commit.AssignmentID, req.CommitBundle.UserID, time.Since(now))
+	}()
+
+	// a failed grade may be retried in a fresh container, so the
+	// goroutines below must shut down whichever nanny is running
+	var nannyMutex sync.Mutex
+	running := func() *Nanny {
+		nannyMutex.Lock()
+		defer nannyMutex.Unlock()
+		return n
+	}
+
 	// watch for timeouts
 	alive := make(chan bool)
 	go func() {
@@ -277,7 +601,7 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 					alive = nil
 				}
 			case <-t.C:
-				if err := n.Shutdown("timeout"); err != nil {
+				if err := running().Shutdown("timeout"); err != nil {
 					log.Printf("error shutting down container: %v", err)
 				}
 			}
@@ -289,7 +613,7 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		broken := false
 		for {
 			msg := new(DaycareRequest)
-			if err := socket.ReadJSON(msg); err != nil {
+			if err := ReadSocketJSON(socket, msg); err != nil {
 				if strings.Contains(err.Error(), "use of closed network connection") || strings.Contains(err.Error(), "close 1005") {
 					// websocket closed
 				} else {
@@ -318,7 +642,7 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 
 		// if the connection closed on the client side, kill the container
 		if broken {
-			if err := n.Shutdown("broken websocket"); err != nil {
+			if err := running().Shutdown("broken websocket"); err != nil {
 				log.Printf("error shutting down container: %v", err)
 			}
 		}
@@ -327,17 +651,27 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 
 	// relay container events to the socket
 	eventListenerClosed := make(chan struct{})
-	go func() {
+	streams := map[string]*streamLimiter{
+		"stdout": newStreamLimiter("stdout", limits.maxOutput),
+		"stderr": newStreamLimiter("stderr", limits.maxOutput),
+	}
+	go func(events chan *EventMessage) {
 		count, overflow, discarded := 0, 0, 0
-		for event := range n.Events {
+		relay := func(event *EventMessage) {
 			if count > TranscriptDataLimit {
 				overflow += len(event.StreamData)
 			} else {
 				count += len(event.StreamData)
 
-				// record the event
+				// record the event, merging bursts of output so long as
+				// the merged event keeps the timing close enough for playback
+				if !event.Time.IsZero() {
+					event.Elapsed = int64(event.Time.Sub(now) / time.Millisecond)
+				}
 				if len(commit.Transcript) > 0 && commit.Transcript[len(commit.Transcript)-1].Event == event.Event &&
-					(event.Event == "stdin" || event.Event == "stdout" || event.Event == "stderr") {
+					(event.Event == "stdin" || event.Event == "stdout" || event.Event == "stderr") &&
+					(event.Elapsed-commit.Transcript[len(commit.Transcript)-1].Elapsed < TranscriptMergeWindow ||
+						len(commit.Transcript) >= TranscriptEventCountLimit) {
 					// merge this with the previous event
 					prev := commit.Transcript[len(commit.Transcript)-1]
 
@@ -355,12 +689,12 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 
 			// transmit the message to the client
 			switch event.Event {
-			case "exec", "exit", "stdin", "stdout", "stderr", "stdinclosed", "error", "files":
+			case "exec", "exit", "stdin", "stdout", "stderr", "stdinclosed", "error", "abuse", "warning", "files", "artifact", "display":
 				if event.Event == "files" {
 					log.Printf("%s", event)
 				}
 				res := &DaycareResponse{Event: event}
-				if err := socket.WriteJSON(res); err != nil {
+				if err := WriteSocketJSON(socket, res); err != nil {
 					if strings.Contains(err.Error(), "use of closed network connection") {
 						// websocket closed
 					} else {
@@ -374,6 +708,23 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 				// ignore other event types
 			}
 		}
+		for event := range events {
+			// hold back output past the beginning of each stream
+			if limiter := streams[event.Event]; limiter != nil {
+				event.StreamData = limiter.admit(event.StreamData)
+				if len(event.StreamData) == 0 {
+					continue
+				}
+			}
+			relay(event)
+		}
+
+		// finish with the end of any stream that was held back
+		for _, name := range []string{"stdout", "stderr"} {
+			for _, event := range streams[name].flush() {
+				relay(event)
+			}
+		}
 		rw.Close()
 
 		// report any truncation
@@ -382,41 +733,38 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		}
 
 		eventListenerClosed <- struct{}{}
-	}()
-
-	// copy the files to the container
-	if err = n.PutFiles(files, 0666); err != nil {
-		n.ReportCard.LogAndFailf("uploading files: %v", err)
-		return
-	}
+	}(n.Events)
 
 	// run the action
-	//log.Printf("%s: %s", action.ProblemType, action.Message)
 	var stdin io.Reader
 	if action.Interactive {
 		stdin = rw
 	}
-	cmd := strings.Fields(action.Command)
-	switch {
-	case action.Parser == "xunit":
-		runAndParseXUnit(n, cmd)
-
-	case action.Parser == "check":
-		runAndParseCheckXML(n, cmd)
-
-	case action.Parser != "":
-		n.ReportCard.LogAndFailf("unknown parser %q for problem type %s action %s",
-			action.Parser, action.ProblemType, action.Action)
+	if !n.runAction(action, limits, files, stdin) {
 		return
+	}
 
-	default:
-		_, _, _, status, err := n.Exec(cmd, stdin, true)
-		if err != nil {
-			n.ReportCard.LogAndFailf("%q exec error: %v", strings.Join(cmd, " "), err)
+	// give a failed submission a second chance in a fresh container
+	// to find tests that only fail some of the time
+	if limits.retry > 0 && commit.Action == "grade" && !action.Interactive && !n.ReportCard.Passed && n.ReportCard.Abuse == "" {
+		first := n.ReportCard
+		if err := n.Shutdown("retry"); err != nil {
+			log.Printf("error shutting down container: %v", err)
 		}
-		if status != 0 {
-			err := fmt.Errorf("%q failed with exit status %d", strings.Join(cmd, " "), status)
-			n.ReportCard.LogAndFailf("%v", err)
+		fresh, err := NewNanny(req.CommitBundle.ProblemType, problem, action.Interactive, action.Action, args, limits, nannyName)
+		if err != nil {
+			log.Printf("error creating container to retry failed tests: %v", err)
+		} else {
+			// keep sending events to the same transcript
+			fresh.Events = n.Events
+			fresh.Locale = n.Locale
+			nannyMutex.Lock()
+			n = fresh
+			nannyMutex.Unlock()
+			if !n.runAction(action, limits, files, nil) {
+				return
+			}
+			markFlaky(first, n.ReportCard)
 		}
 	}
 
@@ -436,6 +784,31 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		}
 	}
 
+	// save any artifacts with the graded commit
+	if commit.Action == "grade" {
+		patterns := append([]string{}, defaultArtifacts[req.CommitBundle.ProblemType.Name]...)
+		for _, option := range problem.Options {
+			parts := strings.SplitN(option, "=", 2)
+			if len(parts) == 2 && parts[0] == "artifact" {
+				patterns = append(patterns, strings.Split(parts[1], ",")...)
+			}
+		}
+		if len(patterns) > 0 {
+			artifacts, err := n.GetArtifacts(patterns)
+			if err != nil {
+				log.Printf("error trying to get artifacts from container: %v", err)
+			} else if len(artifacts) > 0 {
+				commit.Artifacts = artifacts
+				names := make([]string, 0, len(artifacts))
+				for name := range artifacts {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				n.Events <- &EventMessage{Time: time.Now(), Event: "artifact", Artifacts: names}
+			}
+		}
+	}
+
 	// shutdown the nanny
 	if err := n.Shutdown("action finished"); err != nil {
 		logAndTransmitErrorf("nanny shutdown error: %v", err)
@@ -444,6 +817,9 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 	// wait for listener to finish
 	close(n.Events)
 	<-eventListenerClosed
+	if commit.ReportCard != nil {
+		commit.ReportCard.OutputDiscarded = streams["stdout"].discarded + streams["stderr"].discarded
+	}
 
 	// send the final commit back to the client
 	if commit.Action == "grade" {
@@ -458,7 +834,7 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 			// compute partial credit for this step
 			passed := 0
 			for _, elt := range commit.ReportCard.Results {
-				if elt.Outcome == "passed" {
+				if elt.Passing() {
 					passed++
 				}
 			}
@@ -468,7 +844,7 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		req.CommitBundle.CommitSignature = commit.ComputeSignature(Config.DaycareSecret, req.CommitBundle.ProblemTypeSignature, req.CommitBundle.ProblemSignature, req.CommitBundle.Hostname, req.CommitBundle.UserID)
 
 		res := &DaycareResponse{CommitBundle: req.CommitBundle}
-		if err := socket.WriteJSON(res); err != nil {
+		if err := WriteSocketJSON(socket, res); err != nil {
 			logAndTransmitErrorf("error writing final commit JSON: %v", err)
 			return
 		}
@@ -486,7 +862,11 @@ type Nanny struct {
 	Events     chan *EventMessage
 	Transcript []*EventMessage
 	Closed     bool
+	Reason     string
 	Files      map[string][]byte
+	Display    string
+	Locale     string // language for the report card note
+	Workspace  string // directory kept between actions, left out of GetFiles
 }
 
 var getContainerIDRE = regexp.MustCompile(`The name .* is already in use by container (.*)\. You have to delete \(or rename\) that container to be able to reuse that name`)
@@ -533,6 +913,10 @@ func NewNanny(problemType *ProblemType, problem *Problem, interactive bool, acti
 			config.Env = append(config.Env, s)
 		}
 	}
+	config.Env = append(config.Env, propertyEnv(limits)...)
+	if limits.display != "" && interactive {
+		config.Env = append(config.Env, "DISPLAY=:0")
+	}
 
 	hostConfig := &docker.HostConfig{
 		CapDrop: []string{
@@ -569,6 +953,13 @@ func NewNanny(problemType *ProblemType, problem *Problem, interactive bool, acti
 		},
 		ReadonlyRootfs: true,
 	}
+	workspace := ""
+	if limits.workspaceHost != "" {
+		// the workspace gets its own tmpfs so it can be larger than the
+		// rest of the home directory, but no larger than its own limit
+		workspace = limits.workspace
+		hostConfig.Tmpfs["/home/student/"+workspace] = fmt.Sprintf("rw,exec,nosuid,nodev,size=%dk,uid=%d,gid=%d", limits.workspaceSize*1024, uid, uid)
+	}
 
 	log.Printf("new container %s; action %s on %s (%s); params cpu=%d, fd=%d, file=%d, mem=%d, threads=%d",
 		name, action, problem.Unique, problemType.Name,
@@ -628,6 +1019,7 @@ func NewNanny(problemType *ProblemType, problem *Problem, interactive bool, acti
 		Transcript: []*EventMessage{},
 		Closed:     false,
 		Files:      nil,
+		Workspace:  workspace,
 	}, nil
 }
 
@@ -636,6 +1028,8 @@ func (n *Nanny) Shutdown(msg string) error {
 		return nil
 	}
 	n.Closed = true
+	n.Reason = msg
+	stopDisplay(n)
 
 	// shut down the container
 	//log.Printf("shutting down %s: %s", n.Name, msg)
@@ -651,6 +1045,33 @@ func (n *Nanny) Shutdown(msg string) error {
 	return nil
 }
 
+// GetArtifacts gathers the files matching the given patterns from the
+// container to be saved with the commit. Files are taken in name order
+// until ArtifactDataLimit is reached; any that do not fit are skipped.
+func (n *Nanny) GetArtifacts(patterns []string) (map[string][]byte, error) {
+	files, err := n.GetFiles(patterns)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	artifacts := make(map[string][]byte)
+	total := 0
+	for _, name := range names {
+		if total+len(files[name]) > ArtifactDataLimit {
+			log.Printf("artifact %s skipped: %d bytes would exceed the limit of %d", name, len(files[name]), int(ArtifactDataLimit))
+			continue
+		}
+		total += len(files[name])
+		artifacts[name] = files[name]
+	}
+	return artifacts, nil
+}
+
 // PutFiles copies a set of files to the given container.
 // The container must be running.
 func (n *Nanny) PutFiles(files map[string][]byte, mode int64) error {
@@ -767,12 +1188,16 @@ func (n *Nanny) GetFiles(filenames []string) (map[string][]byte, error) {
 		}
 
 		// exec tar in the container
+		cmd := []string{"/bin/tar", "cf", "-", "-C", "/home/student"}
+		if n.Workspace != "" {
+			cmd = append(cmd, "--exclude=./"+n.Workspace)
+		}
 		exec, err := dockerClient.CreateExec(docker.CreateExecOptions{
 			AttachStdin:  false,
 			AttachStdout: true,
 			AttachStderr: true,
 			Tty:          false,
-			Cmd:          []string{"/bin/tar", "cf", "-", "-C", "/home/student", "."},
+			Cmd:          append(cmd, "."),
 			Container:    n.Container.ID,
 			User:         uidgid(n.UID),
 		})
@@ -981,6 +1406,56 @@ func allocUID() (int64, error) {
 	}
 }
 
+// activeNannies returns the number of containers currently running.
+func activeNannies() int {
+	uidsMutex.Lock()
+	defer uidsMutex.Unlock()
+	return len(uidsInUse)
+}
+
+// daycareUsage is the time spent running actions for each assignment
+// and user since it was last reported to the TA.
+var daycareUsage = struct {
+	sync.Mutex
+	seconds map[[2]int64]float64
+}{seconds: make(map[[2]int64]float64)}
+
+func noteDaycareUsage(assignmentID, userID int64, elapsed time.Duration) {
+	if assignmentID < 1 || userID < 1 || elapsed <= 0 {
+		return
+	}
+	daycareUsage.Lock()
+	defer daycareUsage.Unlock()
+	daycareUsage.seconds[[2]int64{assignmentID, userID}] += elapsed.Seconds()
+}
+
+// takeDaycareUsage returns the usage noted since the last call.
+func takeDaycareUsage() []*DaycareUsage {
+	daycareUsage.Lock()
+	defer daycareUsage.Unlock()
+	var list []*DaycareUsage
+	for key, seconds := range daycareUsage.seconds {
+		list = append(list, &DaycareUsage{AssignmentID: key[0], UserID: key[1], Seconds: seconds})
+		delete(daycareUsage.seconds, key)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].AssignmentID != list[j].AssignmentID {
+			return list[i].AssignmentID < list[j].AssignmentID
+		}
+		return list[i].UserID < list[j].UserID
+	})
+	return list
+}
+
+// restoreDaycareUsage puts back usage that could not be reported.
+func restoreDaycareUsage(list []*DaycareUsage) {
+	daycareUsage.Lock()
+	defer daycareUsage.Unlock()
+	for _, elt := range list {
+		daycareUsage.seconds[[2]int64{elt.AssignmentID, elt.UserID}] += elt.Seconds
+	}
+}
+
 func releaseUID(uid int64) {
 	uidsMutex.Lock()
 	defer uidsMutex.Unlock()
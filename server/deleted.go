@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// softDelete marks a single row as deleted and records it in the audit log.
+// The response has been written if it fails.
+func softDelete(w http.ResponseWriter, tx *sql.Tx, currentUser *User, table, objectType string, id int64) {
+	result, err := tx.Exec(`UPDATE `+table+` SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`, time.Now().UTC(), id)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	} else if n == 0 {
+		loggedHTTPErrorf(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err := audit(tx, currentUser, "delete", objectType, id, "deleted %s %d", objectType, id); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+	}
+}
+
+// GetDeleted handles /v2/deleted requests,
+// returning every problem, problem set, and course that has been deleted
+// but not purged.
+func GetDeleted(w http.ResponseWriter, tx *sql.Tx, render render.Render) {
+	deleted := &DeletedObjects{
+		Problems:    []*Problem{},
+		ProblemSets: []*ProblemSet{},
+		Courses:     []*Course{},
+	}
+	if err := meddler.QueryAll(tx, &deleted.Problems, `SELECT * FROM problems WHERE deleted_at IS NOT NULL ORDER BY id`); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := meddler.QueryAll(tx, &deleted.ProblemSets, `SELECT * FROM problem_sets WHERE deleted_at IS NOT NULL ORDER BY id`); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := meddler.QueryAll(tx, &deleted.Courses, `SELECT * FROM courses WHERE deleted_at IS NOT NULL ORDER BY id`); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, deleted)
+}
+
+// PostProblemRestore handles /v2/problems/:problem_id/restore requests,
+// undoing the deletion of a problem.
+func PostProblemRestore(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	restoreDeleted(w, tx, currentUser, "problems", "problem", problemID)
+}
+
+// PostProblemSetRestore handles /v2/problem_sets/:problem_set_id/restore requests,
+// undoing the deletion of a problem set.
+func PostProblemSetRestore(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	restoreDeleted(w, tx, currentUser, "problem_sets", "problem_set", problemSetID)
+}
+
+// PostCourseRestore handles /v2/courses/:course_id/restore requests,
+// undoing the deletion of a course.
+func PostCourseRestore(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	restoreDeleted(w, tx, currentUser, "courses", "course", courseID)
+}
+
+// PurgeProblem handles /v2/problems/:problem_id/purge requests,
+// permanently removing a deleted problem.
+// Note: this deletes all steps, assignments, and commits related to the problem,
+// and it removes it from any problem sets it was part of.
+func PurgeProblem(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	purgeDeleted(w, tx, currentUser, "problems", "problem", problemID)
+}
+
+// PurgeProblemSet handles /v2/problem_sets/:problem_set_id/purge requests,
+// permanently removing a deleted problem set.
+// Note: this deletes all assignments and commits related to the problem set.
+func PurgeProblemSet(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	purgeDeleted(w, tx, currentUser, "problem_sets", "problem_set", problemSetID)
+}
+
+// PurgeCourse handles /v2/courses/:course_id/purge requests,
+// permanently removing a deleted course.
+// This will also delete all assignments and commits related to the course.
+func PurgeCourse(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return
+	}
+	purgeDeleted(w, tx, currentUser, "courses", "course", courseID)
+}
+
+func restoreDeleted(w http.ResponseWriter, tx *sql.Tx, currentUser *User, table, objectType string, id int64) {
+	result, err := tx.Exec(`UPDATE `+table+` SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	} else if n == 0 {
+		loggedHTTPErrorf(w, http.StatusNotFound, "no deleted %s with ID %d", objectType, id)
+		return
+	}
+	if err := audit(tx, currentUser, "restore", objectType, id, "restored %s %d", objectType, id); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+	}
+}
+
+func purgeDeleted(w http.ResponseWriter, tx *sql.Tx, currentUser *User, table, objectType string, id int64) {
+	result, err := tx.Exec(`DELETE FROM `+table+` WHERE id = ? AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	} else if n == 0 {
+		loggedHTTPErrorf(w, http.StatusNotFound, "no deleted %s with ID %d; it must be deleted before it can be purged", objectType, id)
+		return
+	}
+	if err := audit(tx, currentUser, "purge", objectType, id, "purged %s %d", objectType, id); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+	}
+}
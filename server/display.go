@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/go-martini/martini"
+	"github.com/gorilla/websocket"
+	. "github.com/russross/codegrinder/types"
+)
+
+// A problem with the display option, e.g., display=800x600, gives its
+// interactive actions a virtual X display so GUI programs (tkinter,
+// pygame) can run. The nanny starts Xvfb and a VNC server inside the
+// container and sends a display event with a link to noVNC, served by
+// the daycare from www/novnc. The noVNC client connects back to the
+// daycare, which relays the VNC stream to the container through socat
+// since the container has no network. The display closes when the
+// action ends or when the browser has been idle for displayIdle seconds.
+// The problem type's image must include Xvfb, x11vnc, and socat.
+
+const (
+	displayMaxWidth    = 1920
+	displayMaxHeight   = 1080
+	displayDefaultIdle = 300
+	displayPort        = 5900
+)
+
+var displaySize = regexp.MustCompile(`^(\d+)x(\d+)$`)
+
+type displaySession struct {
+	nanny *Nanny
+	idle  time.Duration
+}
+
+var displays = make(map[string]*displaySession)
+var displaysMutex sync.Mutex
+
+// parseDisplaySize checks a WxH display size against the limits.
+func parseDisplaySize(size string) (width, height int, err error) {
+	groups := displaySize.FindStringSubmatch(size)
+	if groups == nil {
+		return 0, 0, fmt.Errorf("display size must be WIDTHxHEIGHT, found %q", size)
+	}
+	width, _ = strconv.Atoi(groups[1])
+	height, _ = strconv.Atoi(groups[2])
+	if width < 320 || height < 200 || width > displayMaxWidth || height > displayMaxHeight {
+		return 0, 0, fmt.Errorf("display size must be between 320x200 and %dx%d, found %s",
+			displayMaxWidth, displayMaxHeight, size)
+	}
+	return width, height, nil
+}
+
+func startDisplay(n *Nanny, limits *limits) error {
+	width, height, err := parseDisplaySize(limits.display)
+	if err != nil {
+		return err
+	}
+	idle := limits.displayIdle
+	if idle <= 0 {
+		idle = displayDefaultIdle
+	}
+
+	// start the X server and VNC server in the background
+	script := fmt.Sprintf("Xvfb :0 -screen 0 %dx%dx24 -nolisten tcp >/dev/null 2>&1 & "+
+		"for i in 1 2 3 4 5 6 7 8 9 10; do [ -e /tmp/.X11-unix/X0 ] && break; sleep 0.2; done; "+
+		"exec x11vnc -display :0 -localhost -rfbport %d -forever -shared -nopw -quiet >/dev/null 2>&1",
+		width, height, displayPort)
+	exec, err := dockerClient.CreateExec(docker.CreateExecOptions{
+		Cmd:       []string{"/bin/sh", "-c", script},
+		Container: n.Container.ID,
+		User:      uidgid(n.UID),
+	})
+	if err != nil {
+		return err
+	}
+	if err := dockerClient.StartExec(exec.ID, docker.StartExecOptions{Detach: true}); err != nil {
+		return err
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(raw)
+	displaysMutex.Lock()
+	displays[token] = &displaySession{nanny: n, idle: time.Duration(idle) * time.Second}
+	displaysMutex.Unlock()
+	n.Display = token
+
+	n.Events <- &EventMessage{
+		Time:  time.Now(),
+		Event: "display",
+		DisplayURL: fmt.Sprintf("https://%s/novnc/vnc.html?path=v2/displays/%s&autoconnect=true&resize=scale",
+			Config.Hostname, token),
+	}
+	return nil
+}
+
+func stopDisplay(n *Nanny) {
+	if n.Display == "" {
+		return
+	}
+	displaysMutex.Lock()
+	delete(displays, n.Display)
+	displaysMutex.Unlock()
+	n.Display = ""
+}
+
+type displayWriter struct {
+	socket *websocket.Conn
+}
+
+func (out *displayWriter) Write(data []byte) (int, error) {
+	if err := out.socket.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// SocketDisplay handles requests to /v2/displays/:token,
+// relaying a noVNC websocket to the VNC server in a nanny's container.
+func SocketDisplay(w http.ResponseWriter, r *http.Request, params martini.Params) {
+	displaysMutex.Lock()
+	session := displays[params["token"]]
+	displaysMutex.Unlock()
+	if session == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "display not found; it may have been closed")
+		return
+	}
+	n := session.nanny
+
+	responseHeader := make(http.Header)
+	for _, protocol := range websocket.Subprotocols(r) {
+		if protocol == "binary" {
+			responseHeader.Set("Sec-Websocket-Protocol", "binary")
+		}
+	}
+	socket, err := websocket.Upgrade(w, r, responseHeader, 4096, 4096)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "websocket error: %v", err)
+		return
+	}
+	defer socket.Close()
+
+	exec, err := dockerClient.CreateExec(docker.CreateExecOptions{
+		AttachStdin:  true,
+		AttachStdout: true,
+		Cmd:          []string{"socat", "STDIO", fmt.Sprintf("TCP:127.0.0.1:%d", displayPort)},
+		Container:    n.Container.ID,
+		User:         uidgid(n.UID),
+	})
+	if err != nil {
+		log.Printf("display for %s: %v", n.Name, err)
+		return
+	}
+
+	// relay the browser's messages to the VNC server,
+	// closing the display if the browser goes idle
+	input, pipe := io.Pipe()
+	go func() {
+		idle := time.AfterFunc(session.idle, func() {
+			log.Printf("display for %s idle for %v, closing", n.Name, session.idle)
+			socket.Close()
+		})
+		defer idle.Stop()
+		for {
+			_, data, err := socket.ReadMessage()
+			if err != nil {
+				break
+			}
+			idle.Reset(session.idle)
+			if _, err := pipe.Write(data); err != nil {
+				break
+			}
+		}
+		pipe.Close()
+	}()
+
+	err = dockerClient.StartExec(exec.ID, docker.StartExecOptions{
+		InputStream:  input,
+		OutputStream: &displayWriter{socket: socket},
+		ErrorStream:  ioutil.Discard,
+	})
+	if err != nil {
+		log.Printf("display for %s: %v", n.Name, err)
+	}
+	input.Close()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// sendEmail sends a plain text message using the mail server from the config file.
+// It fails if no mail server is configured.
+func sendEmail(to []string, subject, body string) error {
+	if Config.SMTPAddress == "" || Config.EmailFrom == "" {
+		return fmt.Errorf("smtpAddress and emailFrom must be set in the config file to send email")
+	}
+	if len(to) == 0 {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(Config.SMTPAddress)
+	if err != nil {
+		return fmt.Errorf("bad smtpAddress %q: %v", Config.SMTPAddress, err)
+	}
+	var auth smtp.Auth
+	if Config.SMTPUser != "" {
+		auth = smtp.PlainAuth("", Config.SMTPUser, Config.SMTPPassword, host)
+	}
+
+	msg := new(strings.Builder)
+	fmt.Fprintf(msg, "From: %s\r\n", Config.EmailFrom)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", headerText(subject))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(msg, "\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	return smtp.SendMail(Config.SMTPAddress, auth, Config.EmailFrom, to, []byte(msg.String()))
+}
+
+// headerText prepares text to go in a message header. Line breaks would
+// let the text add headers of its own, so they are replaced with spaces,
+// and anything outside of printable ASCII is encoded.
+func headerText(text string) string {
+	text = strings.Join(strings.FieldsFunc(text, func(r rune) bool { return r == '\r' || r == '\n' }), " ")
+	return mime.QEncoding.Encode("utf-8", text)
+}
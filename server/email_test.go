@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHeaderText(t *testing.T) {
+	for _, elt := range []struct{ in, want string }{
+		{"CodeGrinder password reset", "CodeGrinder password reset"},
+		{"Grades\r\nBcc: victim@example.com", "Grades Bcc: victim@example.com"},
+		{"line\nbreak\r", "line break"},
+		{"Réinitialisation", "=?utf-8?q?R=C3=A9initialisation?="},
+	} {
+		got := headerText(elt.in)
+		if got != elt.want {
+			t.Errorf("headerText(%q): got %q, want %q", elt.in, got, elt.want)
+		}
+		if strings.ContainsAny(got, "\r\n") {
+			t.Errorf("headerText(%q) contains a line break: %q", elt.in, got)
+		}
+	}
+}
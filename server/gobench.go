@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Go benchmark thresholds are declared in benchmarks.txt, one benchmark per line:
+//
+//	BenchmarkSort ns/op<=50000 allocs/op<=10
+//
+// Each benchmark is run several times (go test -count) and the median of
+// each measurement is compared with its limit. Benchmarks without a line
+// in benchmarks.txt are reported but cannot fail.
+
+const benchmarkThresholdsFile = "benchmarks.txt"
+
+var benchmarkLine = regexp.MustCompile(`^(Benchmark\S*?)(?:-\d+)?\s+\d+\s+(.*)$`)
+var benchmarkLimit = regexp.MustCompile(`^(\S+)<=([0-9.eE+]+)$`)
+
+type benchmarkThreshold struct {
+	unit  string
+	limit float64
+}
+
+func runAndParseGoBench(n *Nanny, cmd []string) {
+	stdout, _, _, status, err := n.Exec(cmd, nil, false)
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error running benchmarks: %v", err)
+		return
+	}
+
+	// did it end in a segfault?
+	if status > 127 {
+		n.ReportCard.LogAndFailf("Crashed with exit status %d while running benchmarks", status)
+		n.ReportCard.AddResult(strings.Join(cmd, " "), exitOutcome(status), fmt.Sprintf("exit status %d", status), "")
+		return
+	}
+	n.ReportCard.Passed = status == 0
+
+	files, err := n.GetFiles([]string{benchmarkThresholdsFile})
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error getting benchmark thresholds")
+		return
+	}
+	thresholds, order, err := parseBenchmarkThresholds(files[benchmarkThresholdsFile])
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error in %s: %v", benchmarkThresholdsFile, err)
+		return
+	}
+
+	parseGoBench(n, stdout.Bytes(), thresholds, order)
+}
+
+func parseBenchmarkThresholds(contents []byte) (map[string][]benchmarkThreshold, []string, error) {
+	thresholds := make(map[string][]benchmarkThreshold)
+	var order []string
+	for i, line := range strings.Split(string(contents), "\n") {
+		if hash := strings.Index(line, "#"); hash >= 0 {
+			line = line[:hash]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		name := fields[0]
+		if _, exists := thresholds[name]; !exists {
+			thresholds[name] = nil
+			order = append(order, name)
+		}
+		for _, field := range fields[1:] {
+			groups := benchmarkLimit.FindStringSubmatch(field)
+			if groups == nil {
+				return nil, nil, fmt.Errorf("line %d: cannot parse limit %q", i+1, field)
+			}
+			limit, err := strconv.ParseFloat(groups[2], 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: bad number in %q", i+1, field)
+			}
+			thresholds[name] = append(thresholds[name], benchmarkThreshold{unit: groups[1], limit: limit})
+		}
+	}
+	return thresholds, order, nil
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func parseGoBench(n *Nanny, output []byte, thresholds map[string][]benchmarkThreshold, order []string) {
+	// gather every measurement of every benchmark
+	measured := make(map[string]map[string][]float64)
+	units := make(map[string][]string)
+	var names []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		groups := benchmarkLine.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if groups == nil {
+			continue
+		}
+		name := groups[1]
+		if measured[name] == nil {
+			measured[name] = make(map[string][]float64)
+			names = append(names, name)
+		}
+		fields := strings.Fields(groups[2])
+		for i := 0; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				continue
+			}
+			unit := fields[i+1]
+			if measured[name][unit] == nil {
+				units[name] = append(units[name], unit)
+			}
+			measured[name][unit] = append(measured[name][unit], value)
+		}
+	}
+
+	// benchmarks with thresholds come first, in the order they were declared
+	for _, name := range names {
+		if _, exists := thresholds[name]; !exists {
+			order = append(order, name)
+		}
+	}
+
+	passed, total := 0, 0
+	for _, name := range order {
+		total++
+		values, ran := measured[name]
+		if !ran {
+			n.ReportCard.AddFailedResult(name, "benchmark did not run", "")
+			continue
+		}
+
+		var lines []string
+		failed := false
+		limits := make(map[string]float64)
+		for _, threshold := range thresholds[name] {
+			limits[threshold.unit] = threshold.limit
+			if _, exists := values[threshold.unit]; !exists {
+				lines = append(lines, fmt.Sprintf("%s: not measured (limit %g); use b.ReportAllocs or -benchmem", threshold.unit, threshold.limit))
+				failed = true
+			}
+		}
+		for _, unit := range units[name] {
+			runs := values[unit]
+			m := median(runs)
+			line := fmt.Sprintf("%s: %g (median of %d)", unit, m, len(runs))
+			if limit, exists := limits[unit]; exists {
+				if m > limit {
+					line += fmt.Sprintf(", over the limit of %g", limit)
+					failed = true
+				} else {
+					line += fmt.Sprintf(", limit %g", limit)
+				}
+			}
+			lines = append(lines, line)
+		}
+		details := strings.Join(lines, "\n")
+		if failed {
+			n.ReportCard.AddFailedResult(name, details, "")
+		} else {
+			passed++
+			n.ReportCard.AddPassedResult(name, details)
+		}
+	}
+
+	// form a report card
+	n.ReportCard.Passed = n.ReportCard.Passed && total > 0 && passed == total
+	n.ReportCard.Note = localize(n.Locale, "Passed %d/%d benchmarks in %v", passed, total, time.Since(n.Start))
+}
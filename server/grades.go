@@ -0,0 +1,415 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// GetCourseGrades handles requests to /v2/courses/:course_id/grades.csv
+// and /v2/courses/:course_id/grades.xlsx, returning a table with one row
+// per student and one column per assignment giving the student's score.
+//
+// If parameter latePenalty=<...> present, scores are recomputed from the
+// commit history and any work submitted after the due date loses this fraction
+// of its value per day late, e.g., latePenalty=0.1 for 10% per day.
+// Otherwise the raw scores are reported.
+//
+// Instructor score overrides take precedence in either case.
+//
+// If parameter section=<...> present, only students in that section are listed.
+func GetCourseGrades(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User) {
+	course, ok := getInstructorCourse(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+	penalty, ok := parseLatePenalty(w, r)
+	if !ok {
+		return
+	}
+	sectionID, ok := getSectionFilter(w, r, tx, course.ID)
+	if !ok {
+		return
+	}
+
+	header, table, err := courseGrades(tx, course.ID, sectionID, penalty)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	writeGrades(w, r, course.Label+"-grades", header, table)
+}
+
+// GetAssignmentGrades handles requests to /v2/assignments/:assignment_id/grades.csv
+// and /v2/assignments/:assignment_id/grades.xlsx, returning a table with one
+// row per student with this assignment giving the overall score and the score
+// for each problem.
+//
+// If parameter latePenalty=<...> present, scores are penalized as in GetCourseGrades.
+// If parameter section=<...> present, only students in that section are listed.
+func GetAssignmentGrades(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User) {
+	assignment, ok := getInstructorAssignment(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+	penalty, ok := parseLatePenalty(w, r)
+	if !ok {
+		return
+	}
+	sectionID, ok := getSectionFilter(w, r, tx, assignment.CourseID)
+	if !ok {
+		return
+	}
+
+	var assignments []*Assignment
+	where, args := sectionWhere(`course_id = ? AND lti_id = ? AND NOT instructor`, []interface{}{assignment.CourseID, assignment.LtiID}, sectionID)
+	if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments WHERE `+where, args...); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// one column per problem
+	var uniques []string
+	if assignment.ProblemSetID != 0 {
+		majorWeights, _, err := GetProblemWeights(tx, assignment)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		for unique := range majorWeights {
+			uniques = append(uniques, unique)
+		}
+		sort.Strings(uniques)
+	}
+
+	byUser := make(map[int64]*Assignment)
+	var userIDs []int64
+	for _, asst := range assignments {
+		if penalty > 0.0 {
+			if err := applyLatePenalty(tx, asst, penalty); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+				return
+			}
+		}
+		if err := applyScoreOverride(tx, asst); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		byUser[asst.UserID] = asst
+		userIDs = append(userIDs, asst.UserID)
+	}
+
+	header := []string{"Name", "Email", "Score"}
+	header = append(header, uniques...)
+	users, err := gradeUsers(tx, userIDs)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	var table [][]string
+	for _, user := range users {
+		asst := byUser[user.ID]
+		row := []string{user.Name, user.Email, formatGrade(asst.Score)}
+		if len(uniques) > 0 {
+			majorWeights, minorWeights, err := GetProblemWeights(tx, asst)
+			if err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+				return
+			}
+			for _, unique := range uniques {
+				one := &Assignment{RawScores: map[string][]float64{unique: asst.RawScores[unique]}}
+				score, err := one.ComputeScore(map[string]float64{unique: majorWeights[unique]}, minorWeights)
+				if err != nil {
+					loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+					return
+				}
+				row = append(row, formatGrade(score))
+			}
+		}
+		table = append(table, row)
+	}
+
+	writeGrades(w, r, assignment.CanvasTitle+"-grades", header, table)
+}
+
+// courseGrades builds the table of scores for every student in a course,
+// or only those in one section if sectionID is not zero.
+func courseGrades(tx *sql.Tx, courseID, sectionID int64, penalty float64) ([]string, [][]string, error) {
+	var assignments []*Assignment
+	where, args := sectionWhere(`course_id = ? AND NOT instructor`, []interface{}{courseID}, sectionID)
+	if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments WHERE `+where+` ORDER BY created_at`, args...); err != nil {
+		return nil, nil, fmt.Errorf("db error: %v", err)
+	}
+
+	// one column per canvas assignment, in the order they were first launched
+	var columns []string
+	titles := make(map[string]string)
+	scores := make(map[int64]map[string]float64)
+	var userIDs []int64
+	for _, asst := range assignments {
+		if _, exists := titles[asst.LtiID]; !exists {
+			titles[asst.LtiID] = asst.CanvasTitle
+			columns = append(columns, asst.LtiID)
+		}
+		if penalty > 0.0 {
+			if err := applyLatePenalty(tx, asst, penalty); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err := applyScoreOverride(tx, asst); err != nil {
+			return nil, nil, err
+		}
+		if scores[asst.UserID] == nil {
+			scores[asst.UserID] = make(map[string]float64)
+			userIDs = append(userIDs, asst.UserID)
+		}
+		scores[asst.UserID][asst.LtiID] = asst.Score
+	}
+
+	header := []string{"Name", "Email"}
+	for _, ltiID := range columns {
+		header = append(header, titles[ltiID])
+	}
+	users, err := gradeUsers(tx, userIDs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("db error: %v", err)
+	}
+	var table [][]string
+	for _, user := range users {
+		row := []string{user.Name, user.Email}
+		for _, ltiID := range columns {
+			if score, exists := scores[user.ID][ltiID]; exists {
+				row = append(row, formatGrade(score))
+			} else {
+				row = append(row, "")
+			}
+		}
+		table = append(table, row)
+	}
+	return header, table, nil
+}
+
+func parseLatePenalty(w http.ResponseWriter, r *http.Request) (float64, bool) {
+	if err := r.ParseForm(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "parsing form data: %v", err)
+		return 0.0, false
+	}
+	value := r.FormValue("latePenalty")
+	if value == "" {
+		return 0.0, true
+	}
+	penalty, err := strconv.ParseFloat(value, 64)
+	if err != nil || penalty < 0.0 || penalty > 1.0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "latePenalty must be a number between 0 and 1")
+		return 0.0, false
+	}
+	return penalty, true
+}
+
+// applyLatePenalty recomputes the raw scores and overall score of an assignment
+// from its commit history, reducing the value of each graded commit submitted
+// after the due date. It does not save the result. Quizzes and assignments with
+// no due date or no history are left unchanged.
+func applyLatePenalty(tx *sql.Tx, asst *Assignment, penalty float64) error {
+	if asst.ProblemSetID == 0 || asst.DueAt == nil {
+		return nil
+	}
+
+	rows, err := tx.Query(`SELECT problems.unique_id, commit_history.step, commit_history.score, commit_history.updated_at `+
+		`FROM commit_history JOIN problems ON commit_history.problem_id = problems.id `+
+		`WHERE commit_history.assignment_id = ? AND commit_history.score IS NOT NULL`, asst.ID)
+	if err != nil {
+		return fmt.Errorf("db error: %v", err)
+	}
+	defer rows.Close()
+
+	raw := make(map[string][]float64)
+	found := false
+	for rows.Next() {
+		var unique string
+		var step int
+		var score float64
+		var submittedAt time.Time
+		if err := rows.Scan(&unique, &step, &score, &submittedAt); err != nil {
+			return fmt.Errorf("db error: %v", err)
+		}
+		found = true
+		if late := submittedAt.Sub(*asst.DueAt); late > 0 {
+			days := math.Ceil(late.Hours() / 24.0)
+			score *= math.Max(0.0, 1.0-penalty*days)
+		}
+		scores := raw[unique]
+		for len(scores) < step {
+			scores = append(scores, 0.0)
+		}
+		if score > scores[step-1] {
+			scores[step-1] = score
+		}
+		raw[unique] = scores
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("db error: %v", err)
+	}
+	if !found {
+		return nil
+	}
+
+	majorWeights, minorWeights, err := GetProblemWeights(tx, asst)
+	if err != nil {
+		return err
+	}
+	asst.RawScores = raw
+	score, err := asst.ComputeScore(majorWeights, minorWeights)
+	if err != nil {
+		return err
+	}
+	asst.Score = score
+	return nil
+}
+
+// gradeUsers loads the given users, sorted by name.
+func gradeUsers(tx *sql.Tx, ids []int64) ([]*User, error) {
+	var users []*User
+	for _, id := range ids {
+		user := new(User)
+		if err := meddler.Load(tx, "users", user, id); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Name != users[j].Name {
+			return users[i].Name < users[j].Name
+		}
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+func formatGrade(score float64) string {
+	return strconv.FormatFloat(math.Round(score*10000.0)/100.0, 'f', -1, 64)
+}
+
+// writeGrades writes a table as CSV or xlsx depending on the extension of the request path.
+func writeGrades(w http.ResponseWriter, r *http.Request, name string, header []string, table [][]string) {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	switch path.Ext(r.URL.Path) {
+	case ".xlsx":
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".xlsx"))
+		if err := writeXLSX(w, header, table); err != nil {
+			log.Printf("error writing xlsx grades: %v", err)
+		}
+	default:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".csv"))
+		if err := writeCSV(w, header, table); err != nil {
+			log.Printf("error writing csv grades: %v", err)
+		}
+	}
+}
+
+func writeCSV(w io.Writer, header []string, table [][]string) error {
+	out := csv.NewWriter(w)
+	out.Write(csvRow(header))
+	for _, row := range table {
+		out.Write(csvRow(row))
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// csvRow quotes cells that a spreadsheet would run as a formula, such as a
+// student name that starts with =. Numbers are left alone.
+func csvRow(row []string) []string {
+	safe := make([]string, len(row))
+	for i, cell := range row {
+		safe[i] = cell
+		if cell == "" || !strings.ContainsAny(cell[:1], "=+-@") {
+			continue
+		}
+		if _, err := strconv.ParseFloat(cell, 64); err != nil {
+			safe[i] = "'" + cell
+		}
+	}
+	return safe
+}
+
+// writeXLSX writes a minimal single-sheet spreadsheet. Cells that parse as
+// numbers are stored as numbers and everything else as inline strings.
+func writeXLSX(w http.ResponseWriter, header []string, table [][]string) error {
+	z := zip.NewWriter(w)
+	files := []struct {
+		name, contents string
+	}{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+			`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+			`</Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+			`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Grades" sheetId="1" r:id="rId1"/></sheets>` +
+			`</workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+			`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+			`</Relationships>`},
+	}
+
+	sheet := new(strings.Builder)
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range append([][]string{header}, table...) {
+		fmt.Fprintf(sheet, `<row r="%d">`, i+1)
+		for _, cell := range row {
+			if _, err := strconv.ParseFloat(cell, 64); err == nil && i > 0 {
+				fmt.Fprintf(sheet, `<c><v>%s</v></c>`, cell)
+			} else {
+				fmt.Fprintf(sheet, `<c t="inlineStr"><is><t>%s</t></is></c>`, html.EscapeString(cell))
+			}
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+	files = append(files, struct{ name, contents string }{"xl/worksheets/sheet1.xml", sheet.String()})
+
+	for _, file := range files {
+		f, err := z.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write([]byte(file.contents)); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
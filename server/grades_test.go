@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteCSVFormulas(t *testing.T) {
+	out := new(bytes.Buffer)
+	header := []string{"name", "score"}
+	table := [][]string{
+		{"=HYPERLINK(\"http://example.com\")", "95"},
+		{"+1 note", "-5"},
+		{"@SUM(A1)", "1e3"},
+		{"-dash", ""},
+		{"Ada Lovelace", "100"},
+	}
+	if err := writeCSV(out, header, table); err != nil {
+		t.Fatalf("writeCSV: %v", err)
+	}
+	want := "name,score\n" +
+		"\"'=HYPERLINK(\"\"http://example.com\"\")\",95\n" +
+		"'+1 note,-5\n" +
+		"'@SUM(A1),1e3\n" +
+		"'-dash,\n" +
+		"Ada Lovelace,100\n"
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
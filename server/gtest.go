@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// compilerWarning matches gcc and clang warnings, e.g.,
+// "tests/list.cpp:12:5: warning: unused variable 'x' [-Wunused-variable]"
+var compilerWarning = regexp.MustCompile(`(?m)^([^\s:]+:\d+(?::\d+)?): warning: (.*)$`)
+
+// reportCompilerWarnings adds a warning event to the transcript
+// for each compiler warning found in the output of a build.
+func reportCompilerWarnings(n *Nanny, outputs ...*bytes.Buffer) {
+	seen := make(map[string]bool)
+	for _, output := range outputs {
+		if output == nil {
+			continue
+		}
+		for _, groups := range compilerWarning.FindAllStringSubmatch(output.String(), -1) {
+			msg := strings.TrimSpace(groups[1] + ": " + groups[2])
+			if seen[msg] {
+				continue
+			}
+			seen[msg] = true
+			n.Events <- &EventMessage{Time: time.Now(), Event: "warning", Error: msg}
+		}
+	}
+}
+
+// runAndParseGTest builds and runs GoogleTest unit tests,
+// reporting compiler warnings and then parsing the XML results.
+func runAndParseGTest(n *Nanny, cmd []string) {
+	filename := "test_detail.xml"
+
+	// build and run tests with XML output
+	stdout, stderr, _, status, err := n.Exec(cmd, nil, false)
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error running unit tests: %v", err)
+		return
+	}
+	reportCompilerWarnings(n, stdout, stderr)
+
+	// did it end in a segfault?
+	if status > 127 {
+		n.ReportCard.LogAndFailf("Crashed with exit status %d while running unit tests", status)
+		n.ReportCard.AddResult(strings.Join(cmd, " "), exitOutcome(status), fmt.Sprintf("exit status %d", status), "")
+		return
+	}
+	n.ReportCard.Passed = status == 0
+
+	// parse the test results
+	xmlfiles, err := n.GetFiles([]string{filename})
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error getting unit test results")
+		return
+	}
+
+	parseXUnit(n, xmlfiles[filename])
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// Messages that students read, such as report card notes and the reasons a
+// commit was refused, are written in English and translated through the
+// catalog below. The English format string is the key, so a message with no
+// translation is simply shown in English. Server logs are always in English.
+//
+// The locale for a request comes from its Accept-Language header, which grind
+// sets from its locale setting, or else from the locale of the student's most
+// recent LTI launch. Instructions are translated by problem authors instead;
+// see ProblemStep.BuildInstructions.
+
+var catalog = map[string]map[string]string{
+	"es": {
+		"Passed %d/%d tests":            "Pasó %d/%d pruebas",
+		"Passed %d/%d tests in %v":      "Pasó %d/%d pruebas en %v",
+		"Passed %d/%d benchmarks in %v": "Pasó %d/%d pruebas de rendimiento en %v",
+		"No test results found in %v":   "No se encontraron resultados de pruebas en %v",
+		"%s in %v":                      "%s en %v",
+
+		"a commit cannot be submitted after the assignment is locked":                           "no se puede enviar el trabajo después de que la tarea se haya cerrado",
+		"commit is for step %d, but user has not passed step %d":                                "el trabajo es para el paso %d, pero aún no ha aprobado el paso %d",
+		"commit is for step %d, but user has already started work on step %d":                   "el trabajo es para el paso %d, pero ya comenzó a trabajar en el paso %d",
+		"this course only accepts work submitted by a copy of grind that you have logged in to": "este curso solo acepta trabajos enviados desde una copia de grind en la que haya iniciado sesión",
+	},
+}
+
+// localize formats a message in the given locale, falling back to English.
+func localize(locale, format string, args ...interface{}) string {
+	for _, elt := range LocaleFallbacks(locale) {
+		if translated, ok := catalog[elt][format]; ok {
+			return fmt.Sprintf(translated, args...)
+		}
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// requestLocale picks the locale for a request: the preferred language in
+// the Accept-Language header, or the locale from the user's LTI launch.
+func requestLocale(r *http.Request, user *User) string {
+	if locale := acceptLanguage(r.Header.Get("Accept-Language")); locale != "" {
+		return locale
+	}
+	if user != nil {
+		return user.Locale
+	}
+	return ""
+}
+
+// acceptLanguage returns the language with the highest weight in an
+// Accept-Language header, ignoring wildcards and malformed entries.
+func acceptLanguage(header string) string {
+	type choice struct {
+		locale string
+		weight float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		locale := NormalizeLocale(fields[0])
+		if locale == "" {
+			continue
+		}
+		weight := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					weight = q
+				}
+			}
+		}
+		if weight > 0 {
+			choices = append(choices, choice{locale: locale, weight: weight})
+		}
+	}
+	if len(choices) == 0 {
+		return ""
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].weight > choices[j].weight })
+	return choices[0].locale
+}
+
+// localizedHTTPErrorf is like loggedHTTPCodedErrorf, but the message sent
+// to the client is translated into the locale. The log gets the English.
+func localizedHTTPErrorf(w http.ResponseWriter, locale string, status int, code string, format string, params ...interface{}) error {
+	msg := fmt.Sprintf(format, params...)
+	id := writeAPIError(w, status, code, localize(locale, format, params...), "")
+	log.Printf("%s[%s] %s", logPrefix(), id, msg)
+	return fmt.Errorf("%s", msg)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// backgroundJobs tracks running and recently finished jobs by name so that
+// the same job is not run twice at once and clients can poll for status.
+type backgroundJobs struct {
+	sync.Mutex
+	jobs map[string]*BackgroundJob
+}
+
+var jobs = &backgroundJobs{jobs: make(map[string]*BackgroundJob)}
+
+// Start runs f in a new goroutine under the given name.
+// It fails if a job with the same name is already running.
+func (j *backgroundJobs) Start(name string, f func() error) (*BackgroundJob, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	if old, exists := j.jobs[name]; exists && old.FinishedAt == nil {
+		return nil, fmt.Errorf("job %s is already running (started %v)", name, old.StartedAt)
+	}
+	job := &BackgroundJob{Name: name, StartedAt: time.Now()}
+	j.jobs[name] = job
+
+	go func() {
+		start := time.Now()
+		err := f()
+
+		j.Lock()
+		defer j.Unlock()
+		now := time.Now()
+		job.FinishedAt = &now
+		if err != nil {
+			job.Error = err.Error()
+			log.Printf("job %s failed after %v: %v", name, now.Sub(start), err)
+		} else {
+			log.Printf("job %s finished in %v", name, now.Sub(start))
+		}
+	}()
+
+	copy := *job
+	return &copy, nil
+}
+
+// Progress records how far along a running job is, for jobs that
+// work through a list of items.
+func (j *backgroundJobs) Progress(name string, done, failed, total int) {
+	j.Lock()
+	defer j.Unlock()
+
+	if job, exists := j.jobs[name]; exists {
+		job.Done, job.Failed, job.Total = done, failed, total
+	}
+}
+
+// Get returns the status of the named job, or nil if it has not been run.
+func (j *backgroundJobs) Get(name string) *BackgroundJob {
+	j.Lock()
+	defer j.Unlock()
+
+	job, exists := j.jobs[name]
+	if !exists {
+		return nil
+	}
+	copy := *job
+	return &copy
+}
+
+// withBackgroundTx runs f in a transaction outside of any request,
+// committing if it succeeds and rolling back otherwise.
+// Jobs should keep transactions short since they block all requests.
+func withBackgroundTx(f func(tx *sql.Tx) error) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	tx, err := beginTx()
+	if err != nil {
+		return fmt.Errorf("db error starting transaction: %v", err)
+	}
+	defer endTx()
+	if err := f(tx); err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			log.Printf("db error rolling back transaction: %v", rerr)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("db error committing transaction: %v", err)
+	}
+	return nil
+}
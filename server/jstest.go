@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// JSTestResults holds the output of either the Jest JSON reporter
+// (jest --json) or the Mocha JSON reporter (mocha --reporter json).
+// Mocha output is recognized by the presence of stats.
+type JSTestResults struct {
+	// Jest
+	TestResults []*JestSuite `json:"testResults"`
+
+	// Mocha
+	Stats   *MochaStats  `json:"stats"`
+	Tests   []*MochaTest `json:"tests"`
+	Pending []*MochaTest `json:"pending"`
+}
+
+type JestSuite struct {
+	Name             string        `json:"name"`
+	Status           string        `json:"status"`
+	Message          string        `json:"message"`
+	AssertionResults []*JestResult `json:"assertionResults"`
+}
+
+type JestResult struct {
+	FullName        string   `json:"fullName"`
+	Title           string   `json:"title"`
+	Status          string   `json:"status"`
+	FailureMessages []string `json:"failureMessages"`
+}
+
+type MochaStats struct {
+	Tests    int `json:"tests"`
+	Passes   int `json:"passes"`
+	Failures int `json:"failures"`
+}
+
+type MochaTest struct {
+	FullTitle string    `json:"fullTitle"`
+	File      string    `json:"file"`
+	Err       *MochaErr `json:"err"`
+}
+
+type MochaErr struct {
+	Message string `json:"message"`
+	Stack   string `json:"stack"`
+}
+
+func runAndParseJSTest(n *Nanny, cmd []string) {
+	filename := "test_detail.json"
+
+	// run tests with JSON output
+	_, _, _, status, err := n.Exec(cmd, nil, false)
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error running unit tests: %v", err)
+		return
+	}
+
+	// did it end in a segfault?
+	if status > 127 {
+		n.ReportCard.LogAndFailf("Crashed with exit status %d while running unit tests", status)
+		n.ReportCard.AddResult(strings.Join(cmd, " "), exitOutcome(status), fmt.Sprintf("exit status %d", status), "")
+		return
+	}
+	n.ReportCard.Passed = status == 0
+
+	// parse the test results
+	jsonfiles, err := n.GetFiles([]string{filename})
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error getting unit test results")
+		return
+	}
+
+	parseJSTest(n, jsonfiles[filename])
+}
+
+var testFailureContextJS = regexp.MustCompile(`([^/\s()]+\.(?:js|mjs|cjs|ts)):(\d+):\d+`)
+
+func jsFailureContext(body string) string {
+	if groups := testFailureContextJS.FindStringSubmatch(body); len(groups) > 2 {
+		return groups[1] + ":" + groups[2]
+	}
+	return ""
+}
+
+func addJSFailure(n *Nanny, name, body string) {
+	outcome := "failed"
+	if isTimeout("", body) {
+		outcome = "timeout"
+	}
+	n.ReportCard.AddResult(name, outcome, body, jsFailureContext(body))
+}
+
+func parseJSTest(n *Nanny, contents []byte) {
+	if len(contents) == 0 {
+		n.ReportCard.LogAndFailf("No unit test results found")
+		return
+	}
+
+	results := new(JSTestResults)
+	if err := json.Unmarshal(contents, results); err != nil {
+		n.ReportCard.LogAndFailf("error parsing unit test results: %v", err)
+		return
+	}
+
+	passed, total := 0, 0
+	if results.Stats != nil {
+		// mocha lists pending tests in tests as well as pending;
+		// they count as failures, as skipped tests do for xunit
+		pending := make(map[string]bool)
+		for _, test := range results.Pending {
+			pending[test.FullTitle] = true
+			total++
+			n.ReportCard.AddResult(test.FullTitle, "skipped", "test is pending", "")
+		}
+		for _, test := range results.Tests {
+			if pending[test.FullTitle] {
+				continue
+			}
+			total++
+			if test.Err != nil && test.Err.Message != "" {
+				addJSFailure(n, test.FullTitle, test.Err.Message+"\n"+test.Err.Stack)
+			} else {
+				passed++
+				n.ReportCard.AddPassedResult(test.FullTitle, "")
+			}
+		}
+	} else {
+		// jest
+		for _, suite := range results.TestResults {
+			if len(suite.AssertionResults) == 0 && suite.Status == "failed" {
+				// the test file itself failed to load
+				total++
+				addJSFailure(n, suite.Name, suite.Message)
+				continue
+			}
+			for _, test := range suite.AssertionResults {
+				name := test.FullName
+				if name == "" {
+					name = test.Title
+				}
+				total++
+				switch test.Status {
+				case "passed":
+					passed++
+					n.ReportCard.AddPassedResult(name, "")
+				case "pending", "skipped", "todo", "disabled":
+					n.ReportCard.AddResult(name, "skipped", "test is "+test.Status, "")
+				default:
+					addJSFailure(n, name, strings.Join(test.FailureMessages, "\n"))
+				}
+			}
+		}
+	}
+
+	// form a report card
+	n.ReportCard.Passed = n.ReportCard.Passed && total > 0 && passed == total
+	n.ReportCard.Note = localize(n.Locale, "Passed %d/%d tests in %v", passed, total, time.Since(n.Start))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A problem with the lint option runs style checkers over the student's
+// code after grading, e.g., lint=gofmt,vet or lint=flake8. For each
+// checker the problem type's Makefile provides a lint-<name> target that
+// writes its findings to lint-<name>.txt, which is empty if there are
+// none. Each checker is added to the report card as one result, weighted
+// by the lintWeight option.
+
+const (
+	// only this many lines of findings are reported for each checker
+	lintDetailLimit = 50
+)
+
+var lintLinter = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+var lintLocation = regexp.MustCompile(`^(?:\./)?([^\s:]+:\d+)`)
+
+func runLint(n *Nanny, limits *limits) {
+	weight := float64(limits.lintWeight)
+	for _, linter := range strings.Split(limits.lint, ",") {
+		linter = strings.TrimSpace(linter)
+		if !lintLinter.MatchString(linter) {
+			n.ReportCard.LogAndFailf("invalid lint checker name %q", linter)
+			return
+		}
+		name := "style: " + linter
+		filename := "lint-" + linter + ".txt"
+
+		cmd := []string{"make", "lint-" + linter}
+		if _, _, _, status, err := n.Exec(cmd, nil, false); err != nil {
+			n.ReportCard.LogAndFailf("Error running %s: %v", linter, err)
+			return
+		} else if status != 0 {
+			n.ReportCard.AddFailedResult(name, fmt.Sprintf("%s could not be run (status %d)", linter, status), "").Weight = weight
+			continue
+		}
+		files, err := n.GetFiles([]string{filename})
+		if err != nil {
+			n.ReportCard.LogAndFailf("Error getting %s results: %v", linter, err)
+			return
+		}
+		addLintResult(n, name, files[filename], weight)
+	}
+}
+
+func addLintResult(n *Nanny, name string, contents []byte, weight float64) {
+	text := strings.TrimRight(string(contents), "\n")
+	if strings.TrimSpace(text) == "" {
+		n.ReportCard.AddPassedResult(name, "").Weight = weight
+		return
+	}
+
+	// note the first location mentioned in the findings
+	lines := strings.Split(text, "\n")
+	context := ""
+	for _, line := range lines {
+		if groups := lintLocation.FindStringSubmatch(line); groups != nil {
+			context = groups[1]
+			break
+		}
+	}
+	if len(lines) > lintDetailLimit {
+		lines = append(lines[:lintDetailLimit:lintDetailLimit],
+			fmt.Sprintf("… and %d more lines", len(lines)-lintDetailLimit))
+	}
+	n.ReportCard.AddResult(name, "failed", strings.Join(lines, "\n"), context).Weight = weight
+}
@@ -74,6 +74,16 @@ type LTIRequest struct {
 	CanvasAssignmentUnlockAt         string  `form:"custom_canvas_assignment_unlock_at"`       // 2019-10-20T21:00:00Z
 	CanvasAssignmentDueAt            string  `form:"custom_canvas_assignment_due_at"`          // 2019-10-20T21:00:00Z
 	CanvasAssignmentLockAt           string  `form:"custom_canvas_assignment_lock_at"`         // 2019-10-20T21:00:00Z
+	ContextMembershipsURL            string  `form:"custom_context_memberships_url"`           // https://... to list the course roster
+	CanvasSectionIDs                 string  `form:"custom_canvas_course_section_ids"`         // 2142,2143: sections the user is enrolled in
+	CanvasSectionNames               string  `form:"custom_canvas_user_section_names"`         // ["CS-1400-01","CS-1400-02"] in the same order
+
+	// content item (deep linking) requests and launches
+	ContentItemReturnURL              string `form:"content_item_return_url"`              // https://... to post the selection
+	AcceptMediaTypes                  string `form:"accept_media_types"`                   // application/vnd.ims.lti.v1.ltilink
+	AcceptPresentationDocumentTargets string `form:"accept_presentation_document_targets"` // embed,frame,iframe,window
+	Data                              string `form:"data"`                                 // <opaque>: returned with the selection
+	CustomProblem                     string `form:"custom_problem"`                       // cs1400-loops-1: the problem an embedded link is for
 }
 
 // GradeResponse is the XML format to post a grade back to the LMS.
@@ -133,7 +143,20 @@ type LTICartridge struct {
 }
 
 // GetConfigXML handles /lti/config.xml requests, returning an XML file to configure the LMS to use this tool.
-func GetConfigXML(w http.ResponseWriter) {
+func GetConfigXML(w http.ResponseWriter, r *http.Request, tx *sql.Tx) {
+	// if parameter consumer=<consumer key> is present,
+	// label the tool with the name of that consumer
+	title, description := Config.ToolName, Config.ToolDescription
+	if key := r.FormValue("consumer"); key != "" {
+		consumer := new(LTIConsumer)
+		if err := meddler.QueryRow(tx, consumer, `SELECT * FROM lti_consumers WHERE consumer_key = ?`, key); err != nil {
+			loggedHTTPDBNotFoundError(w, err)
+			return
+		}
+		title = fmt.Sprintf("%s (%s)", Config.ToolName, consumer.Name)
+		description = fmt.Sprintf("%s for %s", Config.ToolDescription, consumer.Name)
+	}
+
 	c := &LTIConfig{
 		Namespace:      "http://www.imsglobal.org/xsd/imslticc_v1p0",
 		NamespaceBLTI:  "http://www.imsglobal.org/xsd/imsbasiclti_v1p0",
@@ -144,8 +167,8 @@ func GetConfigXML(w http.ResponseWriter) {
 			" http://www.imsglobal.org/xsd/imsbasiclti_v1p0 http://www.imsglobal.org/xsd/lti/ltiv1p0/imsbasiclti_v1p0.xsd" +
 			" http://www.imsglobal.org/xsd/imslticm_v1p0 http://www.imsglobal.org/xsd/lti/ltiv1p0/imslticm_v1p0.xsd" +
 			" http://www.imsglobal.org/xsd/imslticp_v1p0 http://www.imsglobal.org/xsd/lti/ltiv1p0/imslticp_v1p0.xsd",
-		Title:       Config.ToolName,
-		Description: Config.ToolDescription,
+		Title:       title,
+		Description: description,
 		Extensions: LTIConfigExtensions{
 			Platform: "canvas.instructure.com",
 			Extensions: []LTIConfigExtension{
@@ -154,12 +177,18 @@ func GetConfigXML(w http.ResponseWriter) {
 				LTIConfigExtension{Name: "domain", Value: Config.Hostname},
 			},
 			Options: []LTIConfigOptions{
+				contentItemPlacement("editor_button"),
+				contentItemPlacement("link_selection"),
+				contentItemPlacement("assignment_selection"),
 				LTIConfigOptions{
 					Name: "custom_fields",
 					Options: []LTIConfigExtension{
 						LTIConfigExtension{Name: "canvas_assignment_unlock_at", Value: "$Canvas.assignment.unlockAt.iso8601"},
 						LTIConfigExtension{Name: "canvas_assignment_due_at", Value: "$Canvas.assignment.dueAt.iso8601"},
 						LTIConfigExtension{Name: "canvas_assignment_lock_at", Value: "$Canvas.assignment.lockAt.iso8601"},
+						LTIConfigExtension{Name: "context_memberships_url", Value: "$ToolProxyBinding.memberships.url"},
+						LTIConfigExtension{Name: "canvas_course_section_ids", Value: "$Canvas.course.sectionIds"},
+						LTIConfigExtension{Name: "canvas_user_section_names", Value: "$com.instructure.User.sectionNames"},
 					},
 				},
 			},
@@ -191,6 +220,22 @@ func GetConfigXML(w http.ResponseWriter) {
 	}
 }
 
+// contentItemPlacement configures a Canvas placement where an instructor
+// can pick a problem to embed, see LtiContentItems.
+func contentItemPlacement(name string) LTIConfigOptions {
+	return LTIConfigOptions{
+		Name: name,
+		Options: []LTIConfigExtension{
+			LTIConfigExtension{Name: "message_type", Value: "ContentItemSelectionRequest"},
+			LTIConfigExtension{Name: "url", Value: "https://" + Config.Hostname + "/v2/lti/content_items"},
+			LTIConfigExtension{Name: "text", Value: Config.ToolName},
+			LTIConfigExtension{Name: "selection_width", Value: "640"},
+			LTIConfigExtension{Name: "selection_height", Value: "640"},
+			LTIConfigExtension{Name: "enabled", Value: "true"},
+		},
+	}
+}
+
 func signXMLRequest(consumerKey, method, targetURL string, content []byte, secret string) string {
 	sum := sha1.Sum(content)
 	bodyHash := base64.StdEncoding.EncodeToString(sum[:])
@@ -237,7 +282,7 @@ func getMyURL(r *http.Request, withPath bool) *url.URL {
 	return u
 }
 
-func checkOAuthSignature(w http.ResponseWriter, r *http.Request) {
+func checkOAuthSignature(w http.ResponseWriter, r *http.Request, tx *sql.Tx) {
 	// make sure this is a signed request
 	r.ParseForm()
 	expected := r.Form.Get("oauth_signature")
@@ -246,8 +291,21 @@ func checkOAuthSignature(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// find the secret for this consumer; users and courses are scoped by the
+	// consumer key, so the keys used for local users cannot launch
+	consumerKey := r.Form.Get("oauth_consumer_key")
+	if consumerKey == "" || consumerKey == practiceConsumerKey {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "Missing or reserved oauth_consumer_key")
+		return
+	}
+	secret, err := getLTISecret(tx, consumerKey)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
 	// compute the signature
-	sig := computeOAuthSignature(r.Method, getMyURL(r, true).String(), r.Form, Config.LTISecret)
+	sig := computeOAuthSignature(r.Method, getMyURL(r, true).String(), r.Form, secret)
 
 	// verify it
 	if sig != expected {
@@ -344,10 +402,13 @@ func encode(v url.Values) []byte {
 // LtiProblem handles /lti/problem_sets/:ui/:unique requests.
 // It creates the user/course/assignment if necessary, creates a session,
 // and redirects the user to the main UI URL.
+//
+// The embed UI is the editor shown for links added through LtiContentItems,
+// which is limited to the problem given in the custom_problem parameter.
 func LtiProblemSet(w http.ResponseWriter, r *http.Request, tx *sql.Tx, form LTIRequest, params martini.Params) {
 	ui := params["ui"]
-	if ui != "cli" {
-		loggedHTTPErrorf(w, http.StatusBadRequest, "UI type must be cli, not %q", ui)
+	if ui != "cli" && ui != "embed" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "UI type must be cli or embed, not %q", ui)
 		return
 	}
 	unique := params["unique"]
@@ -370,7 +431,7 @@ func LtiProblemSet(w http.ResponseWriter, r *http.Request, tx *sql.Tx, form LTIR
 	problemSet := new(ProblemSet)
 
 	if unique != bootstrapAssignmentName {
-		if err := meddler.QueryRow(tx, problemSet, `SELECT * FROM problem_sets WHERE unique_id = ?`, unique); err != nil {
+		if err := meddler.QueryRow(tx, problemSet, `SELECT * FROM problem_sets WHERE unique_id = ? AND deleted_at IS NULL`, unique); err != nil {
 			loggedHTTPDBNotFoundError(w, err)
 			return
 		}
@@ -390,6 +451,12 @@ func LtiProblemSet(w http.ResponseWriter, r *http.Request, tx *sql.Tx, form LTIR
 		return
 	}
 
+	// note the enrollment and section
+	if err := getUpdateMember(tx, &form, now, course, user); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
 	// load the assignment
 	asst := new(Assignment)
 
@@ -400,11 +467,32 @@ func LtiProblemSet(w http.ResponseWriter, r *http.Request, tx *sql.Tx, form LTIR
 		}
 	}
 
-	// sign the user in
+	// the embedded editor gets a session for just its problem,
+	// which must be part of the problem set that was launched
+	if ui == "embed" {
+		if unique == bootstrapAssignmentName {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "the %s problem set cannot be embedded", bootstrapAssignmentName)
+			return
+		}
+		problem := new(Problem)
+		if err := meddler.QueryRow(tx, problem, `SELECT problems.* FROM problems `+
+			`JOIN problem_set_problems ON problems.id = problem_set_problems.problem_id `+
+			`WHERE problem_set_problems.problem_set_id = ? AND problems.unique_id = ? AND problems.deleted_at IS NULL`,
+			problemSet.ID, form.CustomProblem); err == sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "problem %q is not part of problem set %s", form.CustomProblem, problemSet.Unique)
+			return
+		} else if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		NewEmbedSession(user.ID, asst.ID, problem.ID).Save(w)
+		http.Redirect(w, r, fmt.Sprintf("/%s/?assignment=%d&problem=%d", ui, asst.ID, problem.ID), http.StatusSeeOther)
+		return
+	}
+
+	// sign the user in and redirect to the console
 	session := NewSession(user.ID)
 	session.Save(w)
-
-	// redirect to the console
 	key := loginRecords.Insert(user.ID)
 	http.Redirect(w, r, fmt.Sprintf("/%s/?assignment=%d&session=%s", ui, asst.ID, key), http.StatusSeeOther)
 }
@@ -429,6 +517,12 @@ func LtiQuizzes(w http.ResponseWriter, r *http.Request, tx *sql.Tx, form LTIRequ
 		return
 	}
 
+	// note the enrollment and section
+	if err := getUpdateMember(tx, &form, now, course, user); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
 	// load the assignment
 	asst := new(Assignment)
 	if asst, err = getUpdateAssignment(tx, &form, now, course, nil, user); err != nil {
@@ -451,7 +545,34 @@ func LtiQuizzes(w http.ResponseWriter, r *http.Request, tx *sql.Tx, form LTIRequ
 // get/create/update this user
 func getUpdateUser(tx *sql.Tx, form *LTIRequest, now time.Time) (*User, error) {
 	user := new(User)
-	if err := meddler.QueryRow(tx, user, `SELECT * FROM users WHERE lti_id = ?`, form.UserID); err != nil {
+
+	// LTI user IDs are only unique within one consumer
+
+	// an LTI ID that was merged into another user signs in as that user,
+	// leaving its identity fields alone
+	if err := meddler.QueryRow(tx, user, `SELECT users.* FROM users JOIN user_links ON users.id = user_links.user_id `+
+		`WHERE user_links.consumer_key = ? AND user_links.lti_id = ?`, form.OAuthConsumerKey, form.UserID); err == nil {
+		user.LastSignedInAt = now
+		if locale := NormalizeLocale(form.LaunchPresentationLocale); locale != "" {
+			user.Locale = locale
+		}
+		if err := meddler.Update(tx, "users", user); err != nil {
+			log.Printf("db error updating user %d (%s): %v", user.ID, user.Email, err)
+			return nil, err
+		}
+		return user, nil
+	} else if err != sql.ErrNoRows {
+		log.Printf("db error loading linked user %s (%s): %v", form.UserID, form.PersonContactEmailPrimary, err)
+		return nil, err
+	}
+
+	err := meddler.QueryRow(tx, user, `SELECT * FROM users WHERE consumer_key = ? AND lti_id = ?`, form.OAuthConsumerKey, form.UserID)
+	if err == sql.ErrNoRows && form.CanvasUserID > 0 {
+		// a roster sync through the Canvas API may have added this user before the first launch
+		err = meddler.QueryRow(tx, user, `SELECT * FROM users WHERE consumer_key = ? AND canvas_id = ? AND lti_id LIKE ?`,
+			form.OAuthConsumerKey, form.CanvasUserID, rosterCanvasPrefix+"%")
+	}
+	if err != nil {
 		if err != sql.ErrNoRows {
 			log.Printf("db error loading user %s (%s): %v", form.UserID, form.PersonContactEmailPrimary, err)
 			return nil, err
@@ -466,6 +587,7 @@ func getUpdateUser(tx *sql.Tx, form *LTIRequest, now time.Time) (*User, error) {
 	changed := user.Name != form.PersonNameFull ||
 		user.Email != form.PersonContactEmailPrimary ||
 		user.LtiID != form.UserID ||
+		user.ConsumerKey != form.OAuthConsumerKey ||
 		user.ImageURL != form.UserImage ||
 		user.CanvasLogin != form.CanvasUserLoginID ||
 		user.CanvasID != form.CanvasUserID
@@ -474,9 +596,14 @@ func getUpdateUser(tx *sql.Tx, form *LTIRequest, now time.Time) (*User, error) {
 	user.Name = form.PersonNameFull
 	user.Email = form.PersonContactEmailPrimary
 	user.LtiID = form.UserID
+	user.ConsumerKey = form.OAuthConsumerKey
 	user.ImageURL = form.UserImage
 	user.CanvasLogin = form.CanvasUserLoginID
 	user.CanvasID = form.CanvasUserID
+	if locale := NormalizeLocale(form.LaunchPresentationLocale); locale != "" {
+		// follow the language the student chose in the LMS
+		user.Locale = locale
+	}
 	if user.ID > 0 && changed {
 		// if something changed, note the update time
 		log.Printf("user %d (%s) updated because of new LTI request", user.ID, user.Email)
@@ -496,7 +623,7 @@ func getUpdateUser(tx *sql.Tx, form *LTIRequest, now time.Time) (*User, error) {
 // get/create/update this course
 func getUpdateCourse(tx *sql.Tx, form *LTIRequest, now time.Time) (*Course, error) {
 	course := new(Course)
-	if err := meddler.QueryRow(tx, course, `SELECT * FROM courses WHERE lti_id = ?`, form.ContextID); err != nil {
+	if err := meddler.QueryRow(tx, course, `SELECT * FROM courses WHERE consumer_key = ? AND lti_id = ?`, form.OAuthConsumerKey, form.ContextID); err != nil {
 		if err != sql.ErrNoRows {
 			log.Printf("db error loading course %s (%s): %v", form.ContextID, form.ContextTitle, err)
 			return nil, err
@@ -506,18 +633,31 @@ func getUpdateCourse(tx *sql.Tx, form *LTIRequest, now time.Time) (*Course, erro
 		course.CreatedAt = now
 		course.UpdatedAt = now
 	}
+	if course.DeletedAt != nil {
+		return nil, fmt.Errorf("course %d (%s) has been deleted", course.ID, course.Name)
+	}
 
 	// any changes?
 	changed := course.Name != form.ContextTitle ||
 		course.Label != form.ContextLabel ||
 		course.LtiID != form.ContextID ||
+		course.ConsumerKey != form.OAuthConsumerKey ||
 		course.CanvasID != form.CanvasCourseID
 
+	// the LMS sends the variable name unchanged if it has no membership service
+	membershipsURL := course.MembershipsURL
+	if strings.HasPrefix(form.ContextMembershipsURL, "https://") {
+		membershipsURL = form.ContextMembershipsURL
+	}
+	changed = changed || course.MembershipsURL != membershipsURL
+
 	// make any changes
 	course.Name = form.ContextTitle
 	course.Label = form.ContextLabel
 	course.LtiID = form.ContextID
+	course.ConsumerKey = form.OAuthConsumerKey
 	course.CanvasID = form.CanvasCourseID
+	course.MembershipsURL = membershipsURL
 	if course.ID < 1 || changed {
 		// if something changed, note the update time and save
 		if course.ID > 0 {
@@ -533,6 +673,63 @@ func getUpdateCourse(tx *sql.Tx, form *LTIRequest, now time.Time) (*Course, erro
 	return course, nil
 }
 
+// get/create/update this user's enrollment in the course, including their
+// section if the LMS sends it
+func getUpdateMember(tx *sql.Tx, form *LTIRequest, now time.Time, course *Course, user *User) error {
+	member := new(CourseMember)
+	if err := meddler.QueryRow(tx, member, `SELECT * FROM course_members WHERE course_id = ? AND user_id = ?`, course.ID, user.ID); err == sql.ErrNoRows {
+		member = &CourseMember{CourseID: course.ID, UserID: user.ID, CreatedAt: now}
+	} else if err != nil {
+		log.Printf("db error loading enrollment for course %d user %d: %v", course.ID, user.ID, err)
+		return err
+	}
+
+	// the LMS sends the variable names unchanged if it does not know them
+	var ids, names []string
+	if !strings.HasPrefix(form.CanvasSectionIDs, "$") {
+		for _, id := range strings.Split(form.CanvasSectionIDs, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) > 0 && !strings.HasPrefix(form.CanvasSectionNames, "$") {
+		if err := json.Unmarshal([]byte(form.CanvasSectionNames), &names); err != nil || len(names) != len(ids) {
+			names = nil
+		}
+	}
+	if len(ids) > 0 {
+		section := new(CourseSection)
+		if err := meddler.QueryRow(tx, section, `SELECT * FROM course_sections WHERE course_id = ? AND lti_id = ?`, course.ID, ids[0]); err == sql.ErrNoRows {
+			section = &CourseSection{CourseID: course.ID, LtiID: ids[0], Name: "Section " + ids[0], CreatedAt: now}
+		} else if err != nil {
+			log.Printf("db error loading section %s for course %d: %v", ids[0], course.ID, err)
+			return err
+		}
+		if section.ID == 0 || len(names) > 0 && section.Name != names[0] {
+			if len(names) > 0 {
+				section.Name = names[0]
+			}
+			section.UpdatedAt = now
+			if err := meddler.Save(tx, "course_sections", section); err != nil {
+				log.Printf("db error saving section %s for course %d: %v", ids[0], course.ID, err)
+				return err
+			}
+		}
+		member.SectionID = section.ID
+	}
+
+	member.Roles = form.Roles
+	member.Active = true
+	member.SyncedAt = now
+	member.UpdatedAt = now
+	if err := meddler.Save(tx, "course_members", member); err != nil {
+		log.Printf("db error saving enrollment for course %d user %d: %v", course.ID, user.ID, err)
+		return err
+	}
+	return nil
+}
+
 // get/create/update this assignment
 func getUpdateAssignment(tx *sql.Tx, form *LTIRequest, now time.Time, course *Course, problemSet *ProblemSet, user *User) (*Assignment, error) {
 	asst := new(Assignment)
@@ -717,7 +914,16 @@ func saveGrade(asst *Assignment, text string) error {
 	result := []byte(fmt.Sprintf("%s%s\n", xml.Header, raw))
 
 	// sign the request
-	auth := signXMLRequest(asst.ConsumerKey, "POST", outcomeURL, result, Config.LTISecret)
+	var secret string
+	if err := withBackgroundTx(func(tx *sql.Tx) error {
+		var err error
+		secret, err = getLTISecret(tx, asst.ConsumerKey)
+		return err
+	}); err != nil {
+		log.Printf("error finding secret to post grade: %v", err)
+		return err
+	}
+	auth := signXMLRequest(asst.ConsumerKey, "POST", outcomeURL, result, secret)
 
 	// POST the grade
 	req, err := http.NewRequest("POST", outcomeURL, bytes.NewReader(result))
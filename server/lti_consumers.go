@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// getLTISecret returns the shared secret for an LTI consumer key.
+// Keys that are not registered in lti_consumers use the secret
+// from the config file, so a single-institution install needs no setup.
+// A key that differs from a registered one only by case or spacing is
+// refused rather than falling back to that secret.
+func getLTISecret(tx *sql.Tx, consumerKey string) (string, error) {
+	consumer := new(LTIConsumer)
+	if err := meddler.QueryRow(tx, consumer, `SELECT * FROM lti_consumers WHERE consumer_key = ?`, consumerKey); err != nil {
+		if err != sql.ErrNoRows {
+			return "", fmt.Errorf("db error loading LTI consumer %q: %v", consumerKey, err)
+		}
+		err := meddler.QueryRow(tx, consumer, `SELECT * FROM lti_consumers WHERE lower(trim(consumer_key)) = lower(trim(?))`, consumerKey)
+		if err == sql.ErrNoRows {
+			return Config.LTISecret, nil
+		} else if err != nil {
+			return "", fmt.Errorf("db error loading LTI consumer %q: %v", consumerKey, err)
+		}
+		return "", fmt.Errorf("LTI consumer key %q does not match registered consumer %q", consumerKey, consumer.Key)
+	}
+	return consumer.Secret, nil
+}
+
+// GetLTIConsumers handles requests to /v2/lti_consumers,
+// returning a list of all registered LTI consumers with their secrets.
+func GetLTIConsumers(w http.ResponseWriter, tx *sql.Tx, render render.Render) {
+	consumers := []*LTIConsumer{}
+	if err := meddler.QueryAll(tx, &consumers, `SELECT * FROM lti_consumers ORDER BY consumer_key`); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, consumers)
+}
+
+// PostLTIConsumer handles requests to /v2/lti_consumers,
+// registering a new LTI consumer or updating an existing one with the same key.
+// If no secret is given, a random one is generated.
+// The LMS should be configured with the key and secret that are returned,
+// and with the URL /v2/lti/config.xml?consumer=<key>.
+func PostLTIConsumer(w http.ResponseWriter, tx *sql.Tx, currentUser *User, consumer LTIConsumer, render render.Render) {
+	now := time.Now()
+
+	consumer.Key = strings.TrimSpace(consumer.Key)
+	consumer.Name = strings.TrimSpace(consumer.Name)
+	if consumer.Key == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "consumer key is required")
+		return
+	}
+	if consumer.Name == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "consumer name is required")
+		return
+	}
+	if consumer.Secret == "" {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "error generating secret: %v", err)
+			return
+		}
+		consumer.Secret = base64.StdEncoding.EncodeToString(raw)
+	}
+
+	old := new(LTIConsumer)
+	if err := meddler.QueryRow(tx, old, `SELECT * FROM lti_consumers WHERE consumer_key = ?`, consumer.Key); err != nil {
+		if err != sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		consumer.ID = 0
+		consumer.CreatedAt = now
+	} else {
+		consumer.ID = old.ID
+		consumer.CreatedAt = old.CreatedAt
+	}
+	consumer.UpdatedAt = now
+	if err := meddler.Save(tx, "lti_consumers", &consumer); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	if err := audit(tx, currentUser, "save", "lti_consumer", consumer.ID, "saved LTI consumer %q (%s)", consumer.Key, consumer.Name); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, &consumer)
+}
+
+// DeleteLTIConsumer handles requests to /v2/lti_consumers/:consumer_id,
+// removing a registered LTI consumer. Launches using its key fall back
+// to the secret in the config file. Courses from the consumer are kept.
+func DeleteLTIConsumer(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	consumerID, err := parseID(w, "consumer_id", params["consumer_id"])
+	if err != nil {
+		return
+	}
+	consumer := new(LTIConsumer)
+	if err := meddler.Load(tx, "lti_consumers", consumer, consumerID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM lti_consumers WHERE id = ?`, consumer.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	if err := audit(tx, currentUser, "delete", "lti_consumer", consumer.ID, "deleted LTI consumer %q (%s)", consumer.Key, consumer.Name); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	. "github.com/russross/codegrinder/types"
+)
+
+// Deep linking uses the LTI 1.1 content item messages that Canvas sends from
+// the rich content editor, module, and assignment placements. The instructor
+// picks a problem and the LMS stores a link that launches the embedded editor
+// for just that problem, with the problem given as a custom parameter.
+// Launches go through LtiProblemSet like any other, so grades are posted back
+// in the usual way whenever the link is an assignment. The editor gets its
+// own session cookie, which can only be used for the embedded problem.
+
+const (
+	contentItemContext   = "http://purl.imsglobal.org/ctx/lti/v1/ContentItem"
+	contentItemMediaType = "application/vnd.ims.lti.v1.ltilink"
+)
+
+// ContentItems is the JSON-LD list of items returned to the LMS.
+type ContentItems struct {
+	Context string         `json:"@context"`
+	Graph   []*ContentItem `json:"@graph"`
+}
+
+// ContentItem is a single LTI link returned to the LMS.
+type ContentItem struct {
+	Type            string                `json:"@type"`
+	MediaType       string                `json:"mediaType"`
+	Title           string                `json:"title"`
+	Text            string                `json:"text,omitempty"`
+	URL             string                `json:"url"`
+	PlacementAdvice *ContentItemPlacement `json:"placementAdvice,omitempty"`
+	Custom          map[string]string     `json:"custom,omitempty"`
+}
+
+// ContentItemPlacement suggests how the LMS should show a link.
+type ContentItemPlacement struct {
+	PresentationDocumentTarget string `json:"presentationDocumentTarget"`
+	DisplayWidth               int    `json:"displayWidth,omitempty"`
+	DisplayHeight              int    `json:"displayHeight,omitempty"`
+}
+
+// LtiContentItems handles /v2/lti/content_items requests.
+// It shows an instructor the problems that can be embedded. Choosing one
+// posts a signed content item selection back to the LMS.
+func LtiContentItems(w http.ResponseWriter, r *http.Request, tx *sql.Tx, form LTIRequest) {
+	now := time.Now()
+
+	if form.LTIMessageType != "ContentItemSelectionRequest" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "expected a ContentItemSelectionRequest message, not %q", form.LTIMessageType)
+		return
+	}
+	if form.ContentItemReturnURL == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "missing content_item_return_url")
+		return
+	}
+	if !(&Assignment{Roles: form.Roles}).IsInstructorRole() {
+		loggedHTTPErrorf(w, http.StatusForbidden, "only instructors can add %s problems", Config.ToolName)
+		return
+	}
+	if form.AcceptMediaTypes != "" && !acceptsContentItem(form.AcceptMediaTypes) {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "the LMS does not accept LTI links here (accepts %s)", form.AcceptMediaTypes)
+		return
+	}
+	secret, err := getLTISecret(tx, form.OAuthConsumerKey)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	target := contentItemTarget(form.AcceptPresentationDocumentTargets)
+
+	rows, err := tx.Query(`SELECT problem_sets.unique_id, problem_sets.note, problems.unique_id, problems.note ` +
+		`FROM problem_sets JOIN problem_set_problems ON problem_sets.id = problem_set_problems.problem_set_id ` +
+		`JOIN problems ON problem_set_problems.problem_id = problems.id ` +
+		`WHERE problem_sets.deleted_at IS NULL AND problems.deleted_at IS NULL ` +
+		`ORDER BY problem_sets.unique_id, problems.unique_id`)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	var page bytes.Buffer
+	fmt.Fprintf(&page, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>Add a %s problem</title>\n", html.EscapeString(Config.ToolName))
+	fmt.Fprintf(&page, "<style>body{font-family:\"Lato\",\"Helvetica Neue\",Helvetica,Arial,sans-serif;color:#333;font-size:14px}"+
+		"li{margin:.3em 0}button{color:#fff;background-color:#ba1c21;border:none;border-radius:5px;padding:.3em .8em;cursor:pointer}</style>\n")
+	fmt.Fprintf(&page, "</head><body>\n<h1>Add a %s problem</h1>\n", html.EscapeString(Config.ToolName))
+	fmt.Fprintf(&page, "<p>Students will work on the problem and grade it right in the page.</p>\n")
+	fmt.Fprintf(&page, "<p><input type=\"search\" id=\"filter\" placeholder=\"Search\" autofocus></p>\n<ul id=\"problems\">\n")
+	count := 0
+	for rows.Next() {
+		var setUnique, setNote, problemUnique, problemNote string
+		if err := rows.Scan(&setUnique, &setNote, &problemUnique, &problemNote); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		item := &ContentItem{
+			Type:      "LtiLinkItem",
+			MediaType: contentItemMediaType,
+			Title:     problemNote,
+			Text:      setNote,
+			URL:       fmt.Sprintf("https://%s/v2/lti/problem_sets/embed/%s", Config.Hostname, setUnique),
+			PlacementAdvice: &ContentItemPlacement{
+				PresentationDocumentTarget: target,
+				DisplayWidth:               800,
+				DisplayHeight:              600,
+			},
+			Custom: map[string]string{"problem": problemUnique},
+		}
+		fields, err := signContentItems(form, []*ContentItem{item}, secret, now)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		fmt.Fprintf(&page, "<li><form method=\"post\" action=\"%s\">", html.EscapeString(form.ContentItemReturnURL))
+		for key, values := range fields {
+			fmt.Fprintf(&page, "<input type=\"hidden\" name=\"%s\" value=\"%s\">", html.EscapeString(key), html.EscapeString(values[0]))
+		}
+		fmt.Fprintf(&page, "<button type=\"submit\">Add</button> <b>%s</b> (%s) from problem set %s</form></li>\n",
+			html.EscapeString(problemNote), html.EscapeString(problemUnique), html.EscapeString(setUnique))
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	fmt.Fprintf(&page, "</ul>\n")
+	if count == 0 {
+		fmt.Fprintf(&page, "<p>There are no problems to add yet.</p>\n")
+	}
+	fmt.Fprintf(&page, "<script>document.getElementById('filter').addEventListener('input', function (e) {\n"+
+		"  var text = e.target.value.toLowerCase();\n"+
+		"  var items = document.getElementById('problems').children;\n"+
+		"  for (var i = 0; i < items.length; i++) {\n"+
+		"    items[i].style.display = items[i].textContent.toLowerCase().indexOf(text) >= 0 ? '' : 'none';\n"+
+		"  }\n"+
+		"});</script>\n</body></html>\n")
+
+	log.Printf("content item selection for %s (%s) in course %s with %d problem(s)",
+		form.PersonNameFull, form.PersonContactEmailPrimary, form.ContextTitle, count)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(page.Bytes())
+}
+
+// embedRoutes are the requests the embedded editor makes. An embedded
+// session cannot be used for anything else.
+var embedRoutes = []struct {
+	method string
+	path   *regexp.Regexp
+}{
+	{"GET", regexp.MustCompile(`^/v2/users/me$`)},
+	{"GET", regexp.MustCompile(`^/v2/assignments/[0-9]+$`)},
+	{"GET", regexp.MustCompile(`^/v2/problems/[0-9]+$`)},
+	{"GET", regexp.MustCompile(`^/v2/problems/[0-9]+/steps$`)},
+	{"GET", regexp.MustCompile(`^/v2/assignments/[0-9]+/problems/[0-9]+/steps/[0-9]+/commits/last$`)},
+	{"POST", regexp.MustCompile(`^/v2/commit_bundles/(un)?signed$`)},
+}
+
+// embedAllows checks that a request made with an embedded editor session
+// is one the editor needs and is for the session's assignment and problem.
+// Requests that change anything must also come from the editor's script.
+// Commit bundles are checked by the handlers, see embedAllowsCommit.
+func embedAllows(r *http.Request, params martini.Params, session *CookieSession) error {
+	found := false
+	for _, route := range embedRoutes {
+		if r.Method == route.method && route.path.MatchString(r.URL.Path) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("this session can only be used by the embedded editor")
+	}
+	if r.Method != "GET" && !fromScript(r) {
+		return fmt.Errorf("this request must be JSON with the %s header", requestedWithHeader)
+	}
+	if s, present := params["assignment_id"]; present && s != strconv.FormatInt(session.EmbedAssignmentID, 10) {
+		return fmt.Errorf("this session can only be used for assignment %d", session.EmbedAssignmentID)
+	}
+	if s, present := params["problem_id"]; present && s != strconv.FormatInt(session.EmbedProblemID, 10) {
+		return fmt.Errorf("this session can only be used for problem %d", session.EmbedProblemID)
+	}
+	return nil
+}
+
+// embedAllowsCommit checks that a commit sent with an embedded editor
+// session is for the session's assignment and problem.
+func embedAllowsCommit(session *CookieSession, commit *Commit) bool {
+	return !session.embedded() || commit.AssignmentID == session.EmbedAssignmentID && commit.ProblemID == session.EmbedProblemID
+}
+
+// acceptsContentItem reports whether a list of accepted media types,
+// such as "application/vnd.ims.lti.v1.ltilink,*/*", includes LTI links.
+func acceptsContentItem(accept string) bool {
+	for _, elt := range strings.Split(accept, ",") {
+		switch strings.TrimSpace(elt) {
+		case contentItemMediaType, "application/*", "*/*":
+			return true
+		}
+	}
+	return false
+}
+
+// contentItemTarget picks how the embedded editor should be shown,
+// preferring an iframe in the page.
+func contentItemTarget(accept string) string {
+	targets := strings.Split(accept, ",")
+	for _, preferred := range []string{"iframe", "window", "frame"} {
+		for _, elt := range targets {
+			if strings.TrimSpace(elt) == preferred {
+				return preferred
+			}
+		}
+	}
+	if accept == "" {
+		return "iframe"
+	}
+	return strings.TrimSpace(targets[0])
+}
+
+// signContentItems returns the form fields to post a content item selection
+// back to the LMS, signed with the consumer's secret.
+func signContentItems(form LTIRequest, items []*ContentItem, secret string, now time.Time) (url.Values, error) {
+	raw, err := json.Marshal(&ContentItems{Context: contentItemContext, Graph: items})
+	if err != nil {
+		return nil, fmt.Errorf("error encoding content items: %v", err)
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %v", err)
+	}
+
+	v := url.Values{}
+	v.Set("lti_message_type", "ContentItemSelection")
+	v.Set("lti_version", "LTI-1p0")
+	v.Set("content_items", string(raw))
+	if form.Data != "" {
+		v.Set("data", form.Data)
+	}
+	v.Set("oauth_version", "1.0")
+	v.Set("oauth_nonce", base64.RawURLEncoding.EncodeToString(nonce))
+	v.Set("oauth_timestamp", strconv.FormatInt(now.Unix(), 10))
+	v.Set("oauth_consumer_key", form.OAuthConsumerKey)
+	v.Set("oauth_signature_method", "HMAC-SHA1")
+	v.Set("oauth_callback", "about:blank")
+
+	// parameters in the return URL are signed but not repeated in the form
+	returnURL, err := url.Parse(form.ContentItemReturnURL)
+	if err != nil {
+		return nil, fmt.Errorf("bad content_item_return_url: %v", err)
+	}
+	signed := url.Values{}
+	for key, values := range v {
+		signed[key] = values
+	}
+	for key, values := range returnURL.Query() {
+		signed[key] = append(signed[key], values...)
+	}
+	v.Set("oauth_signature", computeOAuthSignature("POST", form.ContentItemReturnURL, signed, secret))
+	return v, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-martini/martini"
+	. "github.com/russross/codegrinder/types"
+)
+
+func TestEmbedSessionCookie(t *testing.T) {
+	useTestSessions(t)
+
+	r := sessionRequest(t, "GET", "/v2/problems/7", NewEmbedSession(5, 3, 7))
+	if _, err := r.Cookie(CookieName); err == nil {
+		t.Errorf("embedded session was saved in the normal session cookie")
+	}
+	cookie, err := r.Cookie(embedCookieName)
+	if err != nil {
+		t.Fatalf("embedded session was not saved in %s: %v", embedCookieName, err)
+	}
+	session, err := GetSession(r)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if !session.embedded() || session.EmbedAssignmentID != 3 || session.EmbedProblemID != 7 {
+		t.Errorf("got session %+v, want assignment 3 problem 7", session)
+	}
+
+	// the normal session wins when both are present
+	r = sessionRequest(t, "GET", "/v2/problems/7", NewSession(9))
+	r.AddCookie(cookie)
+	if session, err := GetSession(r); err != nil || session.embedded() || session.UserID != 9 {
+		t.Errorf("with both cookies: got %+v, %v, want the normal session for user 9", session, err)
+	}
+
+	// an embedded session cannot be passed off as a normal one
+	swapped := sessionRequest(t, "GET", "/v2/problems/7", NewEmbedSession(5, 3, 7))
+	swapped.Header.Del("Cookie")
+	cookie.Name = CookieName
+	swapped.AddCookie(cookie)
+	if _, err := GetSession(swapped); err == nil {
+		t.Errorf("embedded session was accepted from the normal session cookie")
+	}
+}
+
+// embedRequest is a request like one from the embedded editor's script,
+// or like one from a cross-site form if script is false.
+func embedRequest(method, path string, script bool) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader("{}"))
+	if script {
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(requestedWithHeader, "codegrinder")
+	} else if method != "GET" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return r
+}
+
+func TestEmbedAllows(t *testing.T) {
+	session := NewEmbedSession(5, 3, 7)
+	for _, elt := range []struct {
+		method string
+		path   string
+		params martini.Params
+		script bool
+		ok     bool
+	}{
+		{"GET", "/v2/users/me", nil, false, true},
+		{"GET", "/v2/assignments/3", martini.Params{"assignment_id": "3"}, false, true},
+		{"GET", "/v2/problems/7", martini.Params{"problem_id": "7"}, false, true},
+		{"GET", "/v2/problems/7/steps", martini.Params{"problem_id": "7"}, false, true},
+		{"GET", "/v2/assignments/3/problems/7/steps/1/commits/last",
+			martini.Params{"assignment_id": "3", "problem_id": "7", "step": "1"}, false, true},
+		{"POST", "/v2/commit_bundles/unsigned", nil, true, true},
+		{"POST", "/v2/commit_bundles/signed", nil, true, true},
+
+		// other assignments and problems
+		{"GET", "/v2/assignments/4", martini.Params{"assignment_id": "4"}, false, false},
+		{"GET", "/v2/problems/8", martini.Params{"problem_id": "8"}, false, false},
+		{"GET", "/v2/assignments/3/problems/8/steps/1/commits/last",
+			martini.Params{"assignment_id": "3", "problem_id": "8", "step": "1"}, false, false},
+
+		// a cross-site form cannot submit a commit
+		{"POST", "/v2/commit_bundles/unsigned", nil, false, false},
+
+		// anything else the session could reach
+		{"GET", "/v2/users/me/cookie", nil, false, false},
+		{"GET", "/v2/assignments/3/problems/7/steps/1/commits", nil, false, false},
+		{"GET", "/v2/users", nil, false, false},
+		{"PUT", "/v2/users/me/notifications", nil, true, false},
+		{"DELETE", "/v2/users/5", martini.Params{"user_id": "5"}, true, false},
+		{"POST", "/v2/users/5/impersonate", martini.Params{"user_id": "5"}, true, false},
+		{"GET", "/v2/problems", nil, false, false},
+	} {
+		r := embedRequest(elt.method, elt.path, elt.script)
+		err := embedAllows(r, elt.params, session)
+		if elt.ok && err != nil {
+			t.Errorf("%s %s: rejected: %v", elt.method, elt.path, err)
+		} else if !elt.ok && err == nil {
+			t.Errorf("%s %s: allowed", elt.method, elt.path)
+		}
+	}
+}
+
+func TestEmbedAllowsCommit(t *testing.T) {
+	session := NewEmbedSession(5, 3, 7)
+	for _, elt := range []struct {
+		assignmentID, problemID int64
+		ok                      bool
+	}{
+		{3, 7, true},
+		{3, 8, false},
+		{4, 7, false},
+	} {
+		commit := &Commit{AssignmentID: elt.assignmentID, ProblemID: elt.problemID}
+		if got := embedAllowsCommit(session, commit); got != elt.ok {
+			t.Errorf("commit for assignment %d problem %d: got %v, want %v", elt.assignmentID, elt.problemID, got, elt.ok)
+		}
+		if !embedAllowsCommit(NewSession(5), commit) {
+			t.Errorf("normal session cannot commit to assignment %d problem %d", elt.assignmentID, elt.problemID)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+func TestGetUpdateUserScopedByConsumer(t *testing.T) {
+	d := newTestDB(t)
+	launch := func(consumerKey, userID, login string) int64 {
+		t.Helper()
+		form := &LTIRequest{
+			OAuthConsumerKey:          consumerKey,
+			UserID:                    userID,
+			PersonNameFull:            "Student " + login,
+			PersonContactEmailPrimary: login + "@example.com",
+			CanvasUserLoginID:         login,
+		}
+		user, err := getUpdateUser(d.tx, form, d.now)
+		if err != nil {
+			t.Fatalf("getUpdateUser(%s, %s): %v", consumerKey, userID, err)
+		}
+		return user.ID
+	}
+
+	first := launch("school-a", "1234", "alice")
+	if again := launch("school-a", "1234", "alice"); again != first {
+		t.Errorf("second launch from the same consumer: got user %d, want %d", again, first)
+	}
+
+	// the same user ID and login from another consumer is someone else
+	other := launch("school-b", "1234", "alice")
+	if other == first {
+		t.Errorf("launch from another consumer signed in as user %d", first)
+	}
+
+	// a local account's ID cannot be claimed through a launch
+	local := d.user("local")
+	if id := launch("school-b", local.LtiID, "mallory"); id == local.ID {
+		t.Errorf("launch claimed local user %d", local.ID)
+	}
+
+	// merged identities are scoped the same way
+	if _, err := d.tx.Exec(`INSERT INTO user_links (consumer_key, lti_id, user_id, created_at) VALUES ('school-a', 'old', ?, ?)`,
+		first, d.now); err != nil {
+		t.Fatalf("inserting link: %v", err)
+	}
+	if id := launch("school-a", "old", "alice"); id != first {
+		t.Errorf("linked launch: got user %d, want %d", id, first)
+	}
+	if id := launch("school-b", "old", "bob"); id == first {
+		t.Errorf("linked launch from another consumer signed in as user %d", first)
+	}
+}
+
+func TestRosterPlaceholderScopedByConsumer(t *testing.T) {
+	d := newTestDB(t)
+
+	// a roster sync through the Canvas API adds a student before their first launch
+	placeholder, added, err := getRosterUser(d.tx, "canvas", &rosterMember{canvasID: 42, login: "carol", name: "Carol"}, d.now)
+	if err != nil || !added {
+		t.Fatalf("getRosterUser: got added=%v, err %v", added, err)
+	}
+	if again, added, err := getRosterUser(d.tx, "other", &rosterMember{canvasID: 42, login: "carol", name: "Carol"}, d.now); err != nil || !added || again.ID == placeholder.ID {
+		t.Errorf("roster sync for another consumer reused user %d (added=%v, err %v)", placeholder.ID, added, err)
+	}
+
+	launch := func(consumerKey string) int64 {
+		t.Helper()
+		form := &LTIRequest{
+			OAuthConsumerKey:          consumerKey,
+			UserID:                    "carol-" + consumerKey,
+			PersonNameFull:            "Carol",
+			PersonContactEmailPrimary: "carol@example.com",
+			CanvasUserLoginID:         "carol",
+			CanvasUserID:              42,
+		}
+		user, err := getUpdateUser(d.tx, form, d.now)
+		if err != nil {
+			t.Fatalf("getUpdateUser(%s): %v", consumerKey, err)
+		}
+		return user.ID
+	}
+
+	// another consumer claiming the same Canvas user ID gets a user of its own
+	if id := launch("mallory"); id == placeholder.ID {
+		t.Errorf("launch from another consumer claimed roster user %d", placeholder.ID)
+	}
+	if id := launch("canvas"); id != placeholder.ID {
+		t.Errorf("first launch: got user %d, want roster user %d", id, placeholder.ID)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"expvar"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+)
+
+const (
+	daycareUsageRetention = 30 * 24 * time.Hour
+	blobGracePeriod       = 24 * time.Hour
+)
+
+// maintenanceTask is recurring work done by the TA.
+// Only failures are logged, since most tasks run every few seconds or minutes.
+type maintenanceTask struct {
+	name     string
+	interval time.Duration
+	run      func() error
+
+	sync.Mutex
+	lastRun *BackgroundJob
+}
+
+var maintenanceTasks = []*maintenanceTask{
+	{name: "expire-daycares", interval: daycareRegistrationInterval, run: expireDaycares},
+	{name: "prune-sessions", interval: time.Minute, run: pruneSessions},
+	{name: "refresh-stats", interval: time.Hour, run: refreshStats},
+	{name: "retention", interval: 24 * time.Hour, run: runRetention},
+}
+
+// MaintenanceStatus reports the schedule and most recent run of a maintenance task.
+type MaintenanceStatus struct {
+	Name     string         `json:"name"`
+	Interval string         `json:"interval"`
+	LastRun  *BackgroundJob `json:"lastRun,omitempty"`
+}
+
+// scheduleMaintenance runs each maintenance task at its interval for the life of the server.
+func scheduleMaintenance() {
+	for _, task := range maintenanceTasks {
+		go func(task *maintenanceTask) {
+			for {
+				time.Sleep(task.interval)
+				task.runOnce()
+			}
+		}(task)
+	}
+}
+
+func (task *maintenanceTask) runOnce() {
+	job := &BackgroundJob{Name: task.name, StartedAt: time.Now()}
+	task.Lock()
+	task.lastRun = job
+	task.Unlock()
+
+	err := task.run()
+
+	task.Lock()
+	defer task.Unlock()
+	now := time.Now()
+	job.FinishedAt = &now
+	if err != nil {
+		job.Error = err.Error()
+		log.Printf("maintenance task %s failed after %v: %v", task.name, now.Sub(job.StartedAt), err)
+	}
+}
+
+func (task *maintenanceTask) status() *MaintenanceStatus {
+	task.Lock()
+	defer task.Unlock()
+
+	status := &MaintenanceStatus{
+		Name:     task.name,
+		Interval: task.interval.String(),
+	}
+	if task.lastRun != nil {
+		copy := *task.lastRun
+		status.LastRun = &copy
+	}
+	return status
+}
+
+// GetMaintenance handles requests to /v2/maintenance,
+// returning the status of the scheduled maintenance tasks.
+func GetMaintenance(w http.ResponseWriter, render render.Render) {
+	status := []*MaintenanceStatus{}
+	for _, task := range maintenanceTasks {
+		status = append(status, task.status())
+	}
+	render.JSON(http.StatusOK, status)
+}
+
+func expireDaycares() error {
+	daycareRegistrations.Expire()
+	return nil
+}
+
+// pruneSessions drops expired grind login keys and device authorizations.
+func pruneSessions() error {
+	loginRecords.Lock()
+	loginRecords.expire()
+	loginRecords.Unlock()
+
+	deviceRecords.Lock()
+	deviceRecords.expire(time.Now())
+	deviceRecords.Unlock()
+	return nil
+}
+
+var databaseStats = expvar.NewMap("database")
+
+// refreshStats updates the table sizes reported by /v2/stats.
+func refreshStats() error {
+	counts := make(map[string]int64)
+	tables := []string{"users", "courses", "assignments", "problems", "commits", "commit_history", "blobs"}
+	var blobBytes int64
+	err := withBackgroundTx(func(tx *sql.Tx) error {
+		for _, table := range tables {
+			var count int64
+			if err := tx.QueryRow(`SELECT COUNT(1) FROM ` + table).Scan(&count); err != nil {
+				return err
+			}
+			counts[table] = count
+		}
+		return tx.QueryRow(`SELECT COALESCE(SUM(LENGTH(contents)), 0) FROM blobs`).Scan(&blobBytes)
+	})
+	if err != nil {
+		return err
+	}
+	for table, count := range counts {
+		v := new(expvar.Int)
+		v.Set(count)
+		databaseStats.Set(table, v)
+	}
+	v := new(expvar.Int)
+	v.Set(blobBytes)
+	databaseStats.Set("blobBytes", v)
+	return nil
+}
+
+// runRetention deletes data that is no longer needed: daycare usage
+// records too old to count against daily limits, anonymous users who
+// have stopped practicing, and file blobs that no step or commit refers
+// to. Recently stored blobs are always kept.
+func runRetention() error {
+	now := time.Now()
+	return withBackgroundTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM daycare_usage WHERE created_at < ?`, now.Add(-daycareUsageRetention).UTC()); err != nil {
+			return err
+		}
+		if count, err := expireAnonymousUsers(tx, now); err != nil {
+			return err
+		} else if count > 0 {
+			log.Printf("deleted %d anonymous practice users", count)
+		}
+
+		// gather every blob that is referenced
+		used := make(map[string]bool)
+		queries := []string{
+			`SELECT files FROM problem_steps`,
+			`SELECT solution FROM problem_steps`,
+			`SELECT files FROM commits`,
+			`SELECT files FROM commit_history`,
+			`SELECT files FROM practice_commits`,
+		}
+		for _, query := range queries {
+			rows, err := tx.Query(query)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var raw []byte
+				if err := rows.Scan(&raw); err != nil {
+					rows.Close()
+					return err
+				}
+				var refs map[string]string
+				if err := json.Unmarshal(raw, &refs); err != nil {
+					rows.Close()
+					return err
+				}
+				for _, ref := range refs {
+					if strings.HasPrefix(ref, blobPrefix) {
+						used[ref[len(blobPrefix):]] = true
+					}
+				}
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+		}
+
+		var unused []string
+		rows, err := tx.Query(`SELECT hash FROM blobs WHERE created_at < ?`, now.Add(-blobGracePeriod).UTC())
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var hash string
+			if err := rows.Scan(&hash); err != nil {
+				rows.Close()
+				return err
+			}
+			if !used[hash] {
+				unused = append(unused, hash)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, hash := range unused {
+			if _, err := tx.Exec(`DELETE FROM blobs WHERE hash = ?`, hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
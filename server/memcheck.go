@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A problem with the memcheck=valgrind or memcheck=asan option gets a
+// memory check after grading. The problem type's Makefile provides
+// memcheck-valgrind and memcheck-asan targets that run the graded code
+// again and write reports to memcheck-*.xml or memcheck-asan.*, and the
+// errors found are added to the report card as two results, one for
+// leaks and one for invalid memory accesses. The memcheckWeight option
+// sets how much each counts relative to a single unit test.
+
+const (
+	memcheckLeaks    = "memory check: leaks"
+	memcheckAccesses = "memory check: invalid accesses"
+
+	// only this many errors of each kind are reported in detail
+	memcheckDetailLimit = 10
+)
+
+// valgrind --xml=yes output
+type ValgrindOutput struct {
+	Errors []*ValgrindError `xml:"error"`
+}
+
+type ValgrindError struct {
+	Kind   string           `xml:"kind"`
+	What   string           `xml:"what"`
+	XWhat  string           `xml:"xwhat>text"`
+	Frames []*ValgrindFrame `xml:"stack>frame"`
+}
+
+type ValgrindFrame struct {
+	Function string `xml:"fn"`
+	Dir      string `xml:"dir"`
+	File     string `xml:"file"`
+	Line     int    `xml:"line"`
+}
+
+// memcheckFindings collects the memory errors of each kind.
+type memcheckFindings struct {
+	leaks, accesses         []string
+	leakContext, accContext string
+}
+
+func (f *memcheckFindings) add(leak bool, details, context string) {
+	if leak {
+		f.leaks = append(f.leaks, details)
+		if f.leakContext == "" {
+			f.leakContext = context
+		}
+	} else {
+		f.accesses = append(f.accesses, details)
+		if f.accContext == "" {
+			f.accContext = context
+		}
+	}
+}
+
+func runMemCheck(n *Nanny, limits *limits) {
+	var pattern string
+	switch limits.memcheck {
+	case "valgrind":
+		pattern = "memcheck-*.xml"
+	case "asan":
+		pattern = "memcheck-asan.*"
+	default:
+		n.ReportCard.LogAndFailf("unknown memcheck tool %q", limits.memcheck)
+		return
+	}
+
+	cmd := []string{"make", "memcheck-" + limits.memcheck}
+	if _, _, _, _, err := n.Exec(cmd, nil, false); err != nil {
+		n.ReportCard.LogAndFailf("Error running memory check: %v", err)
+		return
+	}
+	reports, err := n.GetFiles([]string{pattern})
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error getting memory check results: %v", err)
+		return
+	}
+	names := make([]string, 0, len(reports))
+	for name := range reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	findings := new(memcheckFindings)
+	for _, name := range names {
+		if limits.memcheck == "valgrind" {
+			if err := parseValgrindXML(findings, reports[name]); err != nil {
+				n.ReportCard.LogAndFailf("error parsing memory check results: %v", err)
+				return
+			}
+		} else {
+			parseASanLog(findings, reports[name])
+		}
+	}
+
+	weight := float64(limits.memcheckWeight)
+	addMemCheckResult(n, memcheckLeaks, findings.leaks, findings.leakContext, weight)
+	addMemCheckResult(n, memcheckAccesses, findings.accesses, findings.accContext, weight)
+}
+
+func addMemCheckResult(n *Nanny, name string, errors []string, context string, weight float64) {
+	if len(errors) == 0 {
+		n.ReportCard.AddPassedResult(name, "").Weight = weight
+		return
+	}
+	details := errors
+	if len(details) > memcheckDetailLimit {
+		details = append(details[:memcheckDetailLimit:memcheckDetailLimit],
+			fmt.Sprintf("… and %d more", len(errors)-memcheckDetailLimit))
+	}
+	n.ReportCard.AddResult(name, "failed", strings.Join(details, "\n\n"), context).Weight = weight
+}
+
+func parseValgrindXML(findings *memcheckFindings, contents []byte) error {
+	if len(contents) == 0 {
+		// valgrind did not finish, e.g., the program was killed
+		return nil
+	}
+	output := new(ValgrindOutput)
+	if err := xml.Unmarshal(contents, output); err != nil {
+		return err
+	}
+	for _, elt := range output.Errors {
+		if elt.Kind == "Leak_StillReachable" {
+			continue
+		}
+		what := elt.What
+		if what == "" {
+			what = elt.XWhat
+		}
+		var lines []string
+		context := ""
+		for _, frame := range elt.Frames {
+			if frame.File == "" || strings.HasPrefix(frame.Dir, "/usr/") {
+				continue
+			}
+			where := fmt.Sprintf("%s:%d", frame.File, frame.Line)
+			if context == "" {
+				context = where
+			}
+			lines = append(lines, fmt.Sprintf("    in %s (%s)", frame.Function, where))
+		}
+		details := strings.Join(append([]string{what}, lines...), "\n")
+		findings.add(strings.HasPrefix(elt.Kind, "Leak_"), details, context)
+	}
+	return nil
+}
+
+var asanError = regexp.MustCompile(`ERROR: (AddressSanitizer|LeakSanitizer): (.*)`)
+var asanLeak = regexp.MustCompile(`^(Direct|Indirect) leak of .*`)
+var asanFrame = regexp.MustCompile(`^\s*#\d+ 0x[0-9a-f]+ in (\S+) (\S+:\d+)`)
+
+// parseASanLog reads an AddressSanitizer log. A log records at most one
+// invalid access, since the program stops there, but may list many leaks.
+func parseASanLog(findings *memcheckFindings, contents []byte) {
+	var current []string
+	leak, context := false, ""
+	flush := func() {
+		if len(current) > 0 {
+			findings.add(leak, strings.Join(current, "\n"), context)
+		}
+		current, context = nil, ""
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		if groups := asanError.FindStringSubmatch(line); groups != nil {
+			flush()
+			leak = groups[1] == "LeakSanitizer"
+			if !leak {
+				current = []string{groups[2]}
+			}
+		} else if groups := asanLeak.FindStringSubmatch(line); groups != nil && leak {
+			flush()
+			current = []string{groups[0]}
+		} else if groups := asanFrame.FindStringSubmatch(line); groups != nil && len(current) > 0 {
+			if strings.HasPrefix(groups[2], "/usr/") || strings.Contains(groups[2], "/libsanitizer/") {
+				continue
+			}
+			where := groups[2]
+			if i := strings.LastIndex(where, "/"); i >= 0 {
+				where = where[i+1:]
+			}
+			if context == "" {
+				context = where
+			}
+			current = append(current, fmt.Sprintf("    in %s (%s)", groups[1], where))
+		} else if strings.HasPrefix(line, "SUMMARY:") {
+			flush()
+		}
+	}
+	flush()
+}
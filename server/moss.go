@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+const (
+	mossAddress = "moss.stanford.edu:7690"
+	mossTimeout = 10 * time.Minute
+)
+
+// mossLanguages maps problem type prefixes to MOSS language names.
+// Anything not listed is submitted as plain text, including assembly
+// for architectures MOSS does not know.
+var mossLanguages = []struct {
+	prefix   string
+	language string
+}{
+	{"python", "python"},
+	{"cpp", "cc"},
+	{"cinout", "c"},
+	{"cunittest", "c"},
+	{"java", "java"},
+	{"standardml", "ml"},
+	{"prolog", "prolog"},
+	{"haskell", "haskell"},
+	{"racket", "scheme"},
+	{"node", "javascript"},
+}
+
+func mossLanguage(problemType string) string {
+	for _, elt := range mossLanguages {
+		if strings.HasPrefix(problemType, elt.prefix) {
+			return elt.language
+		}
+	}
+	return "ascii"
+}
+
+func mossJobName(courseID int64, ltiID string) string {
+	return fmt.Sprintf("moss-%d-%s", courseID, ltiID)
+}
+
+// PostAssignmentMoss handles requests to /v2/assignments/:assignment_id/moss,
+// starting a background job that submits the final submissions of every
+// student with this assignment to MOSS, one report per problem.
+//
+// If parameter mossUserID=<...> present, it is used as the MOSS account.
+// Otherwise the mossUserID from the server config is used.
+func PostAssignmentMoss(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignment, ok := getInstructorAssignment(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "parsing form data: %v", err)
+		return
+	}
+	userID := r.FormValue("mossUserID")
+	if userID == "" {
+		userID = Config.MossUserID
+	}
+	if userID == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "a MOSS user ID is required")
+		return
+	}
+
+	courseID, ltiID := assignment.CourseID, assignment.LtiID
+	job, err := jobs.Start(mossJobName(courseID, ltiID), func() error {
+		return runMoss(courseID, ltiID, userID)
+	})
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusConflict, "%v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, job)
+}
+
+// GetAssignmentMoss handles requests to /v2/assignments/:assignment_id/moss,
+// returning the MOSS report URLs for this assignment, newest first.
+func GetAssignmentMoss(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignment, ok := getInstructorAssignment(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+
+	reports := []*MossReport{}
+	if err := meddler.QueryAll(tx, &reports, `SELECT * FROM moss_reports WHERE course_id = ? AND lti_id = ? ORDER BY id DESC`,
+		assignment.CourseID, assignment.LtiID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// reports only show user IDs, so give the names that go with them
+	if len(reports) > 0 {
+		students := make(map[string]string)
+		rows, err := tx.Query(`SELECT users.id, users.name, users.email FROM users JOIN assignments ON users.id = assignments.user_id `+
+			`WHERE assignments.course_id = ? AND assignments.lti_id = ?`, assignment.CourseID, assignment.LtiID)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			var name, email string
+			if err := rows.Scan(&id, &name, &email); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+			students[mossLabel(id)] = fmt.Sprintf("%s <%s>", name, email)
+		}
+		if err := rows.Err(); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		for _, report := range reports {
+			report.Students = students
+		}
+	}
+
+	render.JSON(http.StatusOK, reports)
+}
+
+// mossLabel names a student's submission in a MOSS report. MOSS is run by
+// a third party, so nothing that identifies the student is sent there.
+func mossLabel(userID int64) string {
+	return fmt.Sprintf("user%d", userID)
+}
+
+type mossSubmission struct {
+	label string
+	files map[string][]byte
+}
+
+func runMoss(courseID int64, ltiID, userID string) error {
+	// gather the submissions, labeled by user ID
+	type problemSubmissions struct {
+		language string
+		starter  map[string][]byte
+		subs     []*mossSubmission
+	}
+	byProblem := make(map[int64]*problemSubmissions)
+	err := withBackgroundTx(func(tx *sql.Tx) error {
+		final, err := getFinalSubmissions(tx, courseID, ltiID)
+		if err != nil {
+			return err
+		}
+		for problemID, commits := range final {
+			var problemType string
+			if err := tx.QueryRow(`SELECT problem_type FROM problem_steps WHERE problem_id = ? ORDER BY step LIMIT 1`, problemID).Scan(&problemType); err != nil {
+				return err
+			}
+			starter, err := getStarterFiles(tx, problemID)
+			if err != nil {
+				return err
+			}
+			elt := &problemSubmissions{language: mossLanguage(problemType), starter: starter}
+			for _, commit := range commits {
+				var userID int64
+				if err := tx.QueryRow(`SELECT user_id FROM assignments WHERE id = ?`, commit.AssignmentID).Scan(&userID); err != nil {
+					return err
+				}
+				elt.subs = append(elt.subs, &mossSubmission{label: mossLabel(userID), files: commit.Files})
+			}
+			byProblem[problemID] = elt
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// submit each problem separately
+	now := time.Now()
+	var reports []*MossReport
+	for problemID, elt := range byProblem {
+		if len(elt.subs) < 2 {
+			continue
+		}
+		url, err := submitMoss(userID, elt.language, elt.starter, elt.subs)
+		if err != nil {
+			return fmt.Errorf("MOSS error for problem %d: %v", problemID, err)
+		}
+		reports = append(reports, &MossReport{
+			CourseID:  courseID,
+			LtiID:     ltiID,
+			ProblemID: problemID,
+			Language:  elt.language,
+			URL:       url,
+			CreatedAt: now,
+		})
+	}
+
+	return withBackgroundTx(func(tx *sql.Tx) error {
+		for _, report := range reports {
+			if err := meddler.Insert(tx, "moss_reports", report); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// submitMoss sends a set of submissions to the MOSS server using its
+// line-based protocol and returns the URL of the report.
+func submitMoss(userID, language string, base map[string][]byte, subs []*mossSubmission) (string, error) {
+	conn, err := net.DialTimeout("tcp", mossAddress, 30*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(mossTimeout))
+	in := bufio.NewReader(conn)
+
+	fmt.Fprintf(conn, "moss %s\n", userID)
+	fmt.Fprintf(conn, "directory 1\n")
+	fmt.Fprintf(conn, "X 0\n")
+	fmt.Fprintf(conn, "maxmatches 10\n")
+	fmt.Fprintf(conn, "show 250\n")
+	fmt.Fprintf(conn, "language %s\n", language)
+	reply, err := in.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(reply) != "yes" {
+		return "", fmt.Errorf("MOSS does not recognize language %q", language)
+	}
+
+	send := func(id int, name string, contents []byte) error {
+		// MOSS does not accept spaces in file names
+		name = strings.Replace(name, " ", "_", -1)
+		_, err := fmt.Fprintf(conn, "file %d %s %d %s\n%s", id, language, len(contents), name, contents)
+		return err
+	}
+	sorted := func(files map[string][]byte) []string {
+		var names []string
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// starter code is sent as base files so it is not reported as a match
+	for _, name := range sorted(base) {
+		if err := send(0, path.Join("starter", name), base[name]); err != nil {
+			return "", err
+		}
+	}
+	for i, sub := range subs {
+		for _, name := range sorted(sub.files) {
+			if err := send(i+1, path.Join(sub.label, name), sub.files[name]); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	fmt.Fprintf(conn, "query 0 CodeGrinder\n")
+	url, err := in.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(conn, "end\n")
+	url = strings.TrimSpace(url)
+	if !strings.HasPrefix(url, "http") {
+		return "", fmt.Errorf("unexpected response from MOSS: %s", url)
+	}
+	return url, nil
+}
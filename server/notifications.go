@@ -0,0 +1,541 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// Notifications are emails sent to users who have not turned them off:
+// a reminder to students when an assignment is due soon and not finished,
+// a note when a regrade changes a student's score, and a weekly summary of
+// each course for instructors. Each message is rendered from a pair of
+// templates named <kind>-subject and <kind>-body. The built-in templates can
+// be replaced by files of the same name with a .tmpl extension in the
+// notificationTemplates directory. Every message sent is recorded in the
+// notifications table so that reminders are not repeated.
+
+const (
+	remindersJobName = "deadline-reminders"
+	digestJobName    = "weekly-digest"
+
+	// the weekly digest goes out on this day at this hour (local time)
+	digestWeekday = time.Monday
+	digestHour    = 7
+
+	// the digest covers assignments with activity or a deadline this close to now
+	digestWindow = 7 * 24 * time.Hour
+)
+
+const builtinNotificationTemplates = `
+{{- define "deadline-subject"}}{{.ToolName}}: {{.Assignment}} is due {{date .DueAt}}{{end}}
+
+{{- define "deadline-body"}}Hi {{.Name}},
+
+{{.Assignment}} in {{.Course}} is due {{date .DueAt}}, and your
+score so far is {{percent .Score}}. Run "grind list" to see your assignments.
+
+To stop these reminders, run "grind notifications --deadlines=false".
+{{end}}
+
+{{- define "regrade-subject"}}{{.ToolName}}: {{.Problem}} was regraded{{end}}
+
+{{- define "regrade-body"}}Hi {{.Name}},
+
+Your submissions for {{.Problem}} in {{.Assignment}} ({{.Course}})
+were graded again. Your score for the assignment is now {{percent .Score}}
+(it was {{percent .OldScore}}).
+
+To stop these messages, run "grind notifications --regrades=false".
+{{end}}
+
+{{- define "digest-subject"}}{{.ToolName}}: your courses this week{{end}}
+
+{{- define "digest-body"}}Hi {{.Name}},
+
+Here is this week's summary of your courses.
+{{range .Courses}}
+{{.Name}}
+{{- if .NotStarted}}
+  {{.NotStarted}} enrolled student(s) have not started anything yet
+{{- end}}
+{{- if .Warnings}}
+  {{.Warnings}} student(s) flagged as possibly needing help
+{{- end}}
+{{- range .Assignments}}
+* {{.Title}}{{with .DueAt}}, due {{date .}}{{end}}: {{.Started}} of {{.Students}} started, {{.Finished}} finished, average {{percent .Average}}
+{{- end}}
+{{end}}
+To stop this digest, run "grind notifications --digest=false".
+{{end}}
+`
+
+var notificationTemplates *template.Template
+
+var notificationFuncs = template.FuncMap{
+	"percent": func(score float64) string { return fmt.Sprintf("%.0f%%", score*100.0) },
+	"date":    func(t time.Time) string { return t.Local().Format("Mon Jan 2 15:04") },
+}
+
+// loadNotificationTemplates parses the built-in templates and then any
+// replacements from the config file's notificationTemplates directory.
+func loadNotificationTemplates() {
+	tmpl := template.Must(template.New("notifications").Funcs(notificationFuncs).Parse(builtinNotificationTemplates))
+	if Config.NotificationTemplates != "" {
+		files, err := filepath.Glob(filepath.Join(Config.NotificationTemplates, "*.tmpl"))
+		if err != nil {
+			log.Fatalf("bad notificationTemplates directory %q: %v", Config.NotificationTemplates, err)
+		}
+		for _, file := range files {
+			raw, err := ioutil.ReadFile(file)
+			if err != nil {
+				log.Fatalf("error loading notification template: %v", err)
+			}
+			name := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+			if _, err := tmpl.New(name).Parse(string(raw)); err != nil {
+				log.Fatalf("error parsing notification template %s: %v", file, err)
+			}
+		}
+		log.Printf("loaded %d notification template(s) from %s", len(files), Config.NotificationTemplates)
+	}
+	notificationTemplates = tmpl
+}
+
+// notification is the data available to the templates.
+// Which fields are filled in depends on the kind of message.
+type notification struct {
+	ToolName   string
+	Name       string
+	Course     string
+	Assignment string
+	DueAt      time.Time
+	Problem    string
+	OldScore   float64
+	Score      float64
+	Courses    []*digestCourse
+}
+
+type digestCourse struct {
+	Name        string
+	NotStarted  int
+	Warnings    int
+	Assignments []*digestAssignment
+}
+
+type digestAssignment struct {
+	Title    string
+	DueAt    *time.Time
+	Students int
+	Started  int
+	Finished int
+	Average  float64
+
+	updatedAt time.Time
+	total     float64
+}
+
+// renderNotification fills in the subject and body templates for one kind of message.
+func renderNotification(kind string, data *notification) (string, string, error) {
+	data.ToolName = Config.ToolName
+	var subject, body strings.Builder
+	if err := notificationTemplates.ExecuteTemplate(&subject, kind+"-subject", data); err != nil {
+		return "", "", err
+	}
+	if err := notificationTemplates.ExecuteTemplate(&body, kind+"-body", data); err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(subject.String()), body.String(), nil
+}
+
+// pendingNotification is a rendered message waiting to be sent.
+type pendingNotification struct {
+	to   string
+	body string
+	log  *Notification
+}
+
+// queueNotification renders a message and adds it to a list to be sent.
+func queueNotification(list []*pendingNotification, user *User, kind string, assignmentID int64, data *notification, now time.Time) ([]*pendingNotification, error) {
+	data.Name = user.Name
+	subject, body, err := renderNotification(kind, data)
+	if err != nil {
+		return list, fmt.Errorf("rendering %s notification: %v", kind, err)
+	}
+	return append(list, &pendingNotification{
+		to:   user.Email,
+		body: body,
+		log: &Notification{
+			UserID:       user.ID,
+			Kind:         kind,
+			AssignmentID: assignmentID,
+			Subject:      subject,
+			CreatedAt:    now,
+		},
+	}), nil
+}
+
+// sendNotifications sends each message and then records the ones that were sent.
+// Email is sent outside of any transaction since the mail server may be slow.
+func sendNotifications(list []*pendingNotification) (int, error) {
+	var sent []*Notification
+	for _, elt := range list {
+		if err := sendEmail([]string{elt.to}, elt.log.Subject, elt.body); err != nil {
+			log.Printf("sending %s notification to user %d: %v", elt.log.Kind, elt.log.UserID, err)
+			continue
+		}
+		sent = append(sent, elt.log)
+	}
+	err := withBackgroundTx(func(tx *sql.Tx) error {
+		for _, elt := range sent {
+			if err := meddler.Insert(tx, "notifications", elt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return len(sent), err
+	}
+	if len(sent) < len(list) {
+		return len(sent), fmt.Errorf("%d of %d notification(s) could not be sent", len(list)-len(sent), len(list))
+	}
+	return len(sent), nil
+}
+
+// scheduleNotifications checks for deadline reminders every hour
+// and sends the weekly digest for the life of the server.
+func scheduleNotifications() {
+	for {
+		now := time.Now()
+		next := now.Truncate(time.Hour).Add(time.Hour)
+		time.Sleep(next.Sub(now))
+
+		if _, err := jobs.Start(remindersJobName, runDeadlineReminders); err != nil {
+			log.Printf("%v", err)
+		}
+		if next.Weekday() == digestWeekday && next.Hour() == digestHour {
+			if _, err := jobs.Start(digestJobName, runWeeklyDigest); err != nil {
+				log.Printf("%v", err)
+			}
+		}
+	}
+}
+
+// runDeadlineReminders emails each student with an unfinished assignment that
+// is due within the next reminderHours. Each assignment gets one reminder.
+func runDeadlineReminders() error {
+	now := time.Now()
+
+	var list []*pendingNotification
+	err := withBackgroundTx(func(tx *sql.Tx) error {
+		var assignments []*Assignment
+		if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments `+
+			`WHERE NOT instructor AND problem_set_id IS NOT NULL AND due_at > ? AND due_at <= ? AND (score IS NULL OR score < 1) `+
+			`AND NOT EXISTS (SELECT 1 FROM notifications WHERE notifications.assignment_id = assignments.id AND notifications.kind = 'deadline') `+
+			`ORDER BY id`,
+			now.UTC(), now.Add(time.Duration(Config.ReminderHours)*time.Hour).UTC()); err != nil {
+			return err
+		}
+
+		users := make(map[int64]*User)
+		courses := make(map[int64]*Course)
+		for _, asst := range assignments {
+			user := users[asst.UserID]
+			if user == nil {
+				user = new(User)
+				if err := meddler.Load(tx, "users", user, asst.UserID); err != nil {
+					return err
+				}
+				users[user.ID] = user
+			}
+			if user.Email == "" || !user.NotificationPrefs().DeadlineReminders {
+				continue
+			}
+			course := courses[asst.CourseID]
+			if course == nil {
+				course = new(Course)
+				if err := meddler.Load(tx, "courses", course, asst.CourseID); err != nil {
+					return err
+				}
+				courses[course.ID] = course
+			}
+
+			data := &notification{
+				Course:     course.Name,
+				Assignment: asst.CanvasTitle,
+				DueAt:      *asst.DueAt,
+				Score:      asst.Score,
+			}
+			var err error
+			if list, err = queueNotification(list, user, "deadline", asst.ID, data, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	total := len(list)
+	jobs.Progress(remindersJobName, 0, 0, total)
+	sent, err := sendNotifications(list)
+	jobs.Progress(remindersJobName, total, total-sent, total)
+	if sent > 0 {
+		log.Printf("sent %d deadline reminder(s)", sent)
+	}
+	return err
+}
+
+// notifyRegrade emails each student whose score changed when a problem was
+// regraded. before holds the scores from before the regrade. Students with
+// a score override in effect are skipped, since their grade did not change.
+func notifyRegrade(problem *Problem, before map[int64]float64) error {
+	now := time.Now()
+
+	var list []*pendingNotification
+	err := withBackgroundTx(func(tx *sql.Tx) error {
+		for assignmentID, old := range before {
+			asst := new(Assignment)
+			if err := meddler.Load(tx, "assignments", asst, assignmentID); err != nil {
+				return err
+			}
+			if asst.Score == old {
+				continue
+			}
+			posted := *asst
+			if err := applyScoreOverride(tx, &posted); err != nil {
+				return err
+			}
+			if posted.Score != asst.Score {
+				continue
+			}
+			user := new(User)
+			if err := meddler.Load(tx, "users", user, asst.UserID); err != nil {
+				return err
+			}
+			if user.Email == "" || !user.NotificationPrefs().RegradeResults {
+				continue
+			}
+			course := new(Course)
+			if err := meddler.Load(tx, "courses", course, asst.CourseID); err != nil {
+				return err
+			}
+
+			data := &notification{
+				Course:     course.Name,
+				Assignment: asst.CanvasTitle,
+				Problem:    problem.Note,
+				OldScore:   old,
+				Score:      asst.Score,
+			}
+			var err error
+			if list, err = queueNotification(list, user, "regrade", asst.ID, data, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sent, err := sendNotifications(list)
+	if sent > 0 {
+		log.Printf("sent %d regrade notification(s) for problem %s", sent, problem.Unique)
+	}
+	return err
+}
+
+// runWeeklyDigest emails each instructor a summary of their courses:
+// progress on assignments with recent activity or deadlines,
+// enrolled students who have not started, and students flagged by the
+// nightly warnings job.
+func runWeeklyDigest() error {
+	now := time.Now()
+
+	var list []*pendingNotification
+	err := withBackgroundTx(func(tx *sql.Tx) error {
+		// find the instructors and their courses
+		teaching := make(map[int64][]int64)
+		var instructorIDs []int64
+		rows, err := tx.Query(`SELECT DISTINCT user_id, course_id FROM assignments WHERE instructor ORDER BY user_id, course_id`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var userID, courseID int64
+			if err := rows.Scan(&userID, &courseID); err != nil {
+				rows.Close()
+				return err
+			}
+			if _, present := teaching[userID]; !present {
+				instructorIDs = append(instructorIDs, userID)
+			}
+			teaching[userID] = append(teaching[userID], courseID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		summaries := make(map[int64]*digestCourse)
+		for _, userID := range instructorIDs {
+			user := new(User)
+			if err := meddler.Load(tx, "users", user, userID); err != nil {
+				return err
+			}
+			if user.Email == "" || !user.NotificationPrefs().WeeklyDigest {
+				continue
+			}
+
+			data := new(notification)
+			for _, courseID := range teaching[userID] {
+				summary, present := summaries[courseID]
+				if !present {
+					if summary, err = summarizeCourse(tx, courseID, now); err != nil {
+						return err
+					}
+					summaries[courseID] = summary
+				}
+				if summary != nil {
+					data.Courses = append(data.Courses, summary)
+				}
+			}
+			if len(data.Courses) == 0 {
+				continue
+			}
+			if list, err = queueNotification(list, user, "digest", 0, data, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	total := len(list)
+	jobs.Progress(digestJobName, 0, 0, total)
+	sent, err := sendNotifications(list)
+	jobs.Progress(digestJobName, total, total-sent, total)
+	if sent > 0 {
+		log.Printf("sent %d weekly digest(s)", sent)
+	}
+	return err
+}
+
+// summarizeCourse gathers the weekly digest for one course.
+// It returns nil if nothing happened in the course recently.
+func summarizeCourse(tx *sql.Tx, courseID int64, now time.Time) (*digestCourse, error) {
+	course := new(Course)
+	if err := meddler.Load(tx, "courses", course, courseID); err != nil {
+		return nil, err
+	}
+	var assignments []*Assignment
+	if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments WHERE course_id = ? AND NOT instructor AND problem_set_id IS NOT NULL ORDER BY id`,
+		courseID); err != nil {
+		return nil, err
+	}
+	started := make(map[int64]bool)
+	rows, err := tx.Query(`SELECT DISTINCT commits.assignment_id FROM commits JOIN assignments ON commits.assignment_id = assignments.id `+
+		`WHERE assignments.course_id = ?`, courseID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		started[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// group the assignments by LMS assignment
+	summary := &digestCourse{Name: course.Name}
+	groups := make(map[string]*digestAssignment)
+	students := make(map[int64]bool)
+	for _, asst := range assignments {
+		students[asst.UserID] = true
+		group := groups[asst.LtiID]
+		if group == nil {
+			group = &digestAssignment{Title: asst.CanvasTitle, DueAt: asst.DueAt}
+			groups[asst.LtiID] = group
+			summary.Assignments = append(summary.Assignments, group)
+		}
+		group.Students++
+		if started[asst.ID] {
+			group.Started++
+		}
+		if asst.Score >= 1.0 {
+			group.Finished++
+		}
+		group.total += asst.Score
+		if asst.UpdatedAt.After(group.updatedAt) {
+			group.updatedAt = asst.UpdatedAt
+		}
+	}
+	var recent []*digestAssignment
+	for _, group := range summary.Assignments {
+		due := group.DueAt != nil && group.DueAt.After(now.Add(-digestWindow)) && group.DueAt.Before(now.Add(digestWindow))
+		if due || now.Sub(group.updatedAt) < digestWindow {
+			group.Average = group.total / float64(group.Students)
+			recent = append(recent, group)
+		}
+	}
+	summary.Assignments = recent
+
+	// enrolled students who have no assignments yet
+	var members []*CourseMember
+	if err := meddler.QueryAll(tx, &members, `SELECT * FROM course_members WHERE course_id = ? AND active`, courseID); err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if !students[member.UserID] && !(&Assignment{Roles: member.Roles}).IsInstructorRole() {
+			summary.NotStarted++
+		}
+	}
+	if err := tx.QueryRow(`SELECT COUNT(DISTINCT user_id) FROM student_warnings WHERE course_id = ?`, courseID).Scan(&summary.Warnings); err != nil {
+		return nil, err
+	}
+
+	if len(summary.Assignments) == 0 && summary.NotStarted == 0 && summary.Warnings == 0 {
+		return nil, nil
+	}
+	return summary, nil
+}
+
+// GetUserMeNotifications handles /v2/users/me/notifications requests,
+// returning the current user's notification preferences.
+func GetUserMeNotifications(w http.ResponseWriter, tx *sql.Tx, currentUser *User, render render.Render) {
+	render.JSON(http.StatusOK, currentUser.NotificationPrefs())
+}
+
+// PutUserMeNotifications handles PUT /v2/users/me/notifications requests,
+// replacing the current user's notification preferences.
+func PutUserMeNotifications(w http.ResponseWriter, tx *sql.Tx, currentUser *User, prefs NotificationPrefs, render render.Render) {
+	currentUser.Notifications = &prefs
+	currentUser.UpdatedAt = time.Now()
+	if err := meddler.Update(tx, "users", currentUser); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("user %d (%s) set notifications to deadlines=%t regrades=%t digest=%t",
+		currentUser.ID, currentUser.Email, prefs.DeadlineReminders, prefs.RegradeResults, prefs.WeeklyDigest)
+	render.JSON(http.StatusOK, &prefs)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+)
+
+const (
+	deviceCodeTimeout  = 10 * time.Minute
+	deviceCodeInterval = 5 * time.Second
+
+	// anyone can ask for a code, so cap how many can be waiting
+	deviceCodeLimit           = 1000
+	deviceCodeLimitPerAddress = 10
+
+	// no vowels so codes do not spell words; no digits to avoid 0/O and 1/I
+	deviceUserCodeCharSet = "BCDFGHJKLMNPQRSTVWXZ"
+)
+
+type deviceRecord struct {
+	userCode   string
+	addr       string
+	userID     int64
+	expiresAt  time.Time
+	lastPolled time.Time
+}
+
+// devices tracks pending device authorizations by device code.
+// Like session keys, these are kept in memory and do not survive a restart.
+type devices struct {
+	sync.Mutex
+	records map[string]*deviceRecord
+}
+
+var deviceRecords = devices{records: make(map[string]*deviceRecord)}
+
+func (d *devices) expire(now time.Time) {
+	for code, elt := range d.records {
+		if now.After(elt.expiresAt) {
+			delete(d.records, code)
+		}
+	}
+}
+
+var errTooManyDeviceCodes = errors.New("too many device codes are waiting to be approved; try again later")
+
+// Insert creates a new pending device authorization for a client at addr.
+func (d *devices) Insert(now time.Time, addr string) (string, string, error) {
+	d.Lock()
+	defer d.Unlock()
+	d.expire(now)
+
+	if len(d.records) >= deviceCodeLimit {
+		return "", "", errTooManyDeviceCodes
+	}
+	fromAddr := 0
+	for _, elt := range d.records {
+		if elt.addr == addr {
+			fromAddr++
+		}
+	}
+	if fromAddr >= deviceCodeLimitPerAddress {
+		return "", "", errTooManyDeviceCodes
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	deviceCode := base64.RawURLEncoding.EncodeToString(raw)
+
+	var userCode string
+	for {
+		var sb strings.Builder
+		max := big.NewInt(int64(len(deviceUserCodeCharSet)))
+		for i := 0; i < 8; i++ {
+			if i == 4 {
+				sb.WriteByte('-')
+			}
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", "", err
+			}
+			sb.WriteByte(deviceUserCodeCharSet[n.Int64()])
+		}
+		userCode = sb.String()
+		if d.find(userCode) == nil {
+			break
+		}
+	}
+
+	d.records[deviceCode] = &deviceRecord{
+		userCode:  userCode,
+		addr:      addr,
+		expiresAt: now.Add(deviceCodeTimeout),
+	}
+	return deviceCode, userCode, nil
+}
+
+func (d *devices) find(userCode string) *deviceRecord {
+	for _, elt := range d.records {
+		if elt.userCode == userCode {
+			return elt
+		}
+	}
+	return nil
+}
+
+// Approve links the pending request with the given user code to a user.
+func (d *devices) Approve(userCode string, userID int64, now time.Time) error {
+	d.Lock()
+	defer d.Unlock()
+	d.expire(now)
+
+	// accept codes typed in lower case or without the dash
+	userCode = strings.ToUpper(strings.Replace(strings.TrimSpace(userCode), "-", "", -1))
+	if len(userCode) == 8 {
+		userCode = userCode[:4] + "-" + userCode[4:]
+	}
+	elt := d.find(userCode)
+	if elt == nil {
+		return fmt.Errorf("code %q not found: codes expire after %v", userCode, deviceCodeTimeout)
+	}
+	if elt.userID > 0 {
+		return fmt.Errorf("code %q has already been approved", userCode)
+	}
+	elt.userID = userID
+	return nil
+}
+
+// Poll checks on a device code, returning the approved user ID
+// or an OAuth2 error code. An approved code is removed after its first use.
+func (d *devices) Poll(deviceCode string, now time.Time) (int64, string) {
+	d.Lock()
+	defer d.Unlock()
+
+	elt, exists := d.records[deviceCode]
+	if !exists {
+		return 0, "expired_token"
+	}
+	if now.After(elt.expiresAt) {
+		delete(d.records, deviceCode)
+		return 0, "expired_token"
+	}
+	if elt.userID > 0 {
+		delete(d.records, deviceCode)
+		return elt.userID, ""
+	}
+	tooSoon := now.Sub(elt.lastPolled) < deviceCodeInterval
+	elt.lastPolled = now
+	if tooSoon {
+		return 0, "slow_down"
+	}
+	return 0, "authorization_pending"
+}
+
+// PostOAuthDevice handles requests to /v2/oauth/device,
+// starting an OAuth2 device authorization for a client such as grind.
+// The client shows the user code to the user, who approves it in a browser
+// while signed in, and the client polls /v2/oauth/token for a session.
+func PostOAuthDevice(w http.ResponseWriter, r *http.Request, render render.Render) {
+	now := time.Now()
+
+	deviceCode, userCode, err := deviceRecords.Insert(now, remoteHost(r))
+	if err == errTooManyDeviceCodes {
+		loggedHTTPErrorf(w, http.StatusTooManyRequests, "%v", err)
+		return
+	} else if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error generating device code: %v", err)
+		return
+	}
+
+	base := "https://" + Config.Hostname + "/device/"
+	render.JSON(http.StatusOK, &DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         base,
+		VerificationURIComplete: base + "?code=" + url.QueryEscape(userCode),
+		ExpiresIn:               int64(deviceCodeTimeout.Seconds()),
+		Interval:                int64(deviceCodeInterval.Seconds()),
+	})
+}
+
+// remoteHost gives the address a request came from, without the port.
+// The TA terminates TLS itself, so forwarding headers are not trusted.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestedWithHeader must be set by scripts on our own pages for requests
+// that a cross-site form must not be able to make. Browsers only send a
+// custom header cross-site after a CORS preflight, which the TA never allows.
+const requestedWithHeader = "X-Requested-With"
+
+// fromScript reports whether a request was made by a script on one of our
+// own pages, which a cross-site form cannot forge: JSON with a custom header.
+func fromScript(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") && r.Header.Get(requestedWithHeader) != ""
+}
+
+// PostOAuthDeviceApprove handles requests to /v2/oauth/device/approve,
+// granting the pending device authorization a session as the current user.
+// The request must come from the device page, so the body is JSON with
+// the code shown by the client.
+func PostOAuthDeviceApprove(w http.ResponseWriter, currentUser *User, approval DeviceApproval) {
+	if err := deviceRecords.Approve(approval.UserCode, currentUser.ID, time.Now()); err != nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "%v", err)
+		return
+	}
+	log.Printf("device login approved for user %d (%s)", currentUser.ID, currentUser.Name)
+}
+
+// PostOAuthToken handles requests to /v2/oauth/token,
+// returning a session cookie once a device authorization has been approved.
+// Following RFC 8628, a pending request returns status 400 with the error
+// authorization_pending, or slow_down if the client polls too often.
+//
+// Parameters grant_type=urn:ietf:params:oauth:grant-type:device_code and
+// device_code=<...> must be present.
+func PostOAuthToken(w http.ResponseWriter, r *http.Request, render render.Render) {
+	if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:device_code" {
+		render.JSON(http.StatusBadRequest, &DeviceToken{Error: "unsupported_grant_type"})
+		return
+	}
+	userID, errorCode := deviceRecords.Poll(r.FormValue("device_code"), time.Now())
+	if errorCode != "" {
+		render.JSON(http.StatusBadRequest, &DeviceToken{Error: errorCode})
+		return
+	}
+
+	session := NewSession(userID)
+	cookie := session.Save(w)
+	if cookie == "" {
+		return
+	}
+	render.JSON(http.StatusOK, &DeviceToken{
+		AccessToken: cookie,
+		TokenType:   "cookie",
+		ExpiresIn:   int64(time.Until(session.ExpiresAt).Seconds()),
+	})
+}
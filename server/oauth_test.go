@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+func TestDeviceFlow(t *testing.T) {
+	d := &devices{records: make(map[string]*deviceRecord)}
+	now := time.Now()
+
+	deviceCode, userCode, err := d.Insert(now, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if len(userCode) != 9 || userCode[4] != '-' {
+		t.Fatalf("user code %q is not in XXXX-XXXX form", userCode)
+	}
+
+	// polling before approval
+	if id, code := d.Poll(deviceCode, now); id != 0 || code != "authorization_pending" {
+		t.Errorf("first poll: got %d, %q, want authorization_pending", id, code)
+	}
+	if id, code := d.Poll(deviceCode, now.Add(time.Second)); id != 0 || code != "slow_down" {
+		t.Errorf("quick second poll: got %d, %q, want slow_down", id, code)
+	}
+
+	// the code can be typed in lower case without the dash, but only approved once
+	typed := strings.ToLower(strings.Replace(userCode, "-", "", -1))
+	if err := d.Approve(typed, 42, now); err != nil {
+		t.Fatalf("Approve(%q): %v", typed, err)
+	}
+	if err := d.Approve(userCode, 43, now); err == nil {
+		t.Errorf("second approval of %q succeeded", userCode)
+	}
+
+	// an approved code yields the user once
+	if id, code := d.Poll(deviceCode, now.Add(deviceCodeInterval)); id != 42 || code != "" {
+		t.Errorf("poll after approval: got %d, %q, want user 42", id, code)
+	}
+	if id, code := d.Poll(deviceCode, now.Add(2*deviceCodeInterval)); id != 0 || code != "expired_token" {
+		t.Errorf("poll after use: got %d, %q, want expired_token", id, code)
+	}
+
+	if err := d.Approve("AAAA-AAAA", 42, now); err == nil {
+		t.Errorf("approving an unknown code succeeded")
+	}
+}
+
+func TestDeviceFlowExpires(t *testing.T) {
+	d := &devices{records: make(map[string]*deviceRecord)}
+	now := time.Now()
+
+	deviceCode, userCode, err := d.Insert(now, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	late := now.Add(deviceCodeTimeout + time.Second)
+	if err := d.Approve(userCode, 42, late); err == nil {
+		t.Errorf("approving an expired code succeeded")
+	}
+	if id, code := d.Poll(deviceCode, late); id != 0 || code != "expired_token" {
+		t.Errorf("poll after expiring: got %d, %q, want expired_token", id, code)
+	}
+}
+
+func TestDeviceFlowLimits(t *testing.T) {
+	d := &devices{records: make(map[string]*deviceRecord)}
+	now := time.Now()
+
+	for i := 0; i < deviceCodeLimitPerAddress; i++ {
+		if _, _, err := d.Insert(now, "192.0.2.1"); err != nil {
+			t.Fatalf("Insert %d: %v", i, err)
+		}
+	}
+	if _, _, err := d.Insert(now, "192.0.2.1"); err != errTooManyDeviceCodes {
+		t.Errorf("one more code from the same address: got %v, want %v", err, errTooManyDeviceCodes)
+	}
+	if _, _, err := d.Insert(now, "192.0.2.2"); err != nil {
+		t.Errorf("code from another address: %v", err)
+	}
+
+	// the total is capped no matter where the requests come from
+	for i := len(d.records); i < deviceCodeLimit; i++ {
+		if _, _, err := d.Insert(now, fmt.Sprintf("198.51.100.%d", i)); err != nil {
+			t.Fatalf("Insert %d: %v", i, err)
+		}
+	}
+	if _, _, err := d.Insert(now, "203.0.113.1"); err != errTooManyDeviceCodes {
+		t.Errorf("code beyond the global limit: got %v, want %v", err, errTooManyDeviceCodes)
+	}
+
+	// expired codes make room again
+	if _, _, err := d.Insert(now.Add(deviceCodeTimeout+time.Second), "192.0.2.1"); err != nil {
+		t.Errorf("code after the others expired: %v", err)
+	}
+}
+
+func TestDeviceApproveFromScript(t *testing.T) {
+	for _, elt := range []struct {
+		contentType string
+		header      string
+		want        bool
+	}{
+		// what a cross-site form can send
+		{"application/x-www-form-urlencoded", "", false},
+		{"text/plain", "", false},
+		{"multipart/form-data; boundary=x", "", false},
+
+		// a custom header needs a preflight, but is not enough alone
+		{"text/plain", "codegrinder", false},
+		{"application/json", "", false},
+
+		// what the device page sends
+		{"application/json", "codegrinder", true},
+		{"application/json; charset=utf-8", "codegrinder", true},
+	} {
+		r := httptest.NewRequest("POST", "/v2/oauth/device/approve", strings.NewReader(`{"user_code":"BCDF-GHJK"}`))
+		r.Header.Set("Content-Type", elt.contentType)
+		if elt.header != "" {
+			r.Header.Set(requestedWithHeader, elt.header)
+		}
+		if got := fromScript(r); got != elt.want {
+			t.Errorf("fromScript with Content-Type %q and %s %q: got %v, want %v",
+				elt.contentType, requestedWithHeader, elt.header, got, elt.want)
+		}
+	}
+}
+
+func TestPostOAuthDeviceApprove(t *testing.T) {
+	saved := deviceRecords.records
+	deviceRecords.records = make(map[string]*deviceRecord)
+	defer func() { deviceRecords.records = saved }()
+
+	deviceCode, userCode, err := deviceRecords.Insert(time.Now(), "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	user := &User{ID: 7, Name: "student"}
+
+	w := httptest.NewRecorder()
+	PostOAuthDeviceApprove(w, user, DeviceApproval{UserCode: "AAAA-AAAA"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("approving an unknown code: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w = httptest.NewRecorder()
+	PostOAuthDeviceApprove(w, user, DeviceApproval{UserCode: userCode})
+	if w.Code != http.StatusOK {
+		t.Errorf("approving %q: got status %d: %s", userCode, w.Code, w.Body.String())
+	}
+	if id, code := deviceRecords.Poll(deviceCode, time.Now()); id != user.ID {
+		t.Errorf("poll after approval: got %d, %q, want user %d", id, code, user.ID)
+	}
+}
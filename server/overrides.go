@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// GetAssignmentScores handles requests to /v2/assignments/:assignment_id/scores,
+// returning every score override recorded for this assignment, oldest first.
+func GetAssignmentScores(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignment, ok := getInstructorAssignment(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+
+	overrides := []*ScoreOverride{}
+	if err := meddler.QueryAll(tx, &overrides, `SELECT * FROM score_overrides WHERE assignment_id = ? ORDER BY id`, assignment.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, overrides)
+}
+
+// PutAssignmentScores handles requests to /v2/assignments/:assignment_id/scores,
+// setting a score by hand that takes precedence over the computed score.
+// The request body gives the score (0 to 1) and the reason for the override;
+// a null score removes any earlier override. The new effective score is
+// posted to the LMS.
+func PutAssignmentScores(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, override ScoreOverride, render render.Render) {
+	now := time.Now()
+
+	assignment, ok := getInstructorAssignment(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+	if assignment.Instructor {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "cannot override the score of an instructor assignment")
+		return
+	}
+	if override.Score != nil && (*override.Score < 0.0 || *override.Score > 1.0) {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "score must be between 0 and 1")
+		return
+	}
+	override.Reason = strings.TrimSpace(override.Reason)
+	if override.Reason == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "a reason is required when overriding a score")
+		return
+	}
+
+	override.ID = 0
+	override.AssignmentID = assignment.ID
+	override.UserID = currentUser.ID
+	override.CreatedAt = now
+	if err := meddler.Insert(tx, "score_overrides", &override); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	detail := "removed override"
+	if override.Score != nil {
+		detail = fmt.Sprintf("set score to %.2f%% (computed score %.2f%%)", *override.Score*100.0, assignment.Score*100.0)
+	}
+	if err := audit(tx, currentUser, "score_override", "assignment", assignment.ID, "%s: %s", detail, override.Reason); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	// post the effective score to the LMS
+	posted := *assignment
+	if err := applyScoreOverride(tx, &posted); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	go postGrade(&posted, "")
+
+	render.JSON(http.StatusOK, &override)
+}
+
+// applyScoreOverride replaces the score of an assignment with the
+// instructor override currently in effect, if any. The result is for
+// reporting grades and should never be saved back to the database,
+// where the computed score is kept.
+func applyScoreOverride(tx *sql.Tx, assignment *Assignment) error {
+	override := new(ScoreOverride)
+	if err := meddler.QueryRow(tx, override, `SELECT * FROM score_overrides WHERE assignment_id = ? ORDER BY id DESC LIMIT 1`, assignment.ID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("db error: %v", err)
+	}
+	if override.Score != nil {
+		assignment.Score = *override.Score
+	}
+	return nil
+}
+
+// postGrade sends the score for an assignment to the LMS,
+// retrying with backoff if it fails.
+func postGrade(asst *Assignment, msg string) {
+	// try up to 10 times before giving up
+	tries := 10
+	minSleepTime := 10 * time.Second
+	maxSleepTime := 5 * time.Minute
+	sleepTime := minSleepTime
+	for i := 0; i < tries; i++ {
+		err := saveGrade(asst, msg)
+		if err == nil {
+			return
+		}
+		log.Printf("error posting grade back to LMS (attempt %d/%d): %v", i+1, tries, err)
+		if i+1 < tries {
+			log.Printf("  will try again in %v", sleepTime)
+			time.Sleep(sleepTime)
+			sleepTime *= 2
+			if sleepTime > maxSleepTime {
+				sleepTime = maxSleepTime
+			}
+		} else {
+			log.Printf("  giving up")
+		}
+	}
+}
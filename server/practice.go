@@ -0,0 +1,291 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// Public problem sets can be practiced by anyone who is signed in, without an
+// LTI assignment. Each student who starts one gets an ordinary assignment in
+// a practice course that the server creates for itself, so fetching, saving,
+// and grading all work as usual. Practice assignments have no grade ID or
+// outcome URL, so no grades are ever sent to an LMS.
+//
+// If the anonymousPractice option is set, a visitor can also get a session
+// as a new anonymous user. Anonymous users practice in a separate course
+// whose daily grading time is limited by anonymousMinutes for each student
+// and anonymousDailyMinutes for all of them together. Only so many anonymous
+// users may be created each day, in total and from each address, and those
+// that have not been used for anonymousUserRetention are deleted.
+
+const (
+	practiceConsumerKey = "practice"
+	anonymousLtiPrefix  = "anonymous:"
+
+	anonymousUsersPerDay           = 500
+	anonymousUsersPerAddressPerDay = 5
+	anonymousUserRetention         = 30 * 24 * time.Hour
+)
+
+// anonymousCreations records when each address last created anonymous users.
+var anonymousCreations = struct {
+	sync.Mutex
+	times map[string][]time.Time
+}{times: make(map[string][]time.Time)}
+
+// allowAnonymousUser reports whether addr may create another anonymous
+// user today, and if so counts the new user against it.
+func allowAnonymousUser(addr string, now time.Time) bool {
+	anonymousCreations.Lock()
+	defer anonymousCreations.Unlock()
+
+	for key, times := range anonymousCreations.times {
+		recent := times[:0]
+		for _, elt := range times {
+			if now.Sub(elt) < 24*time.Hour {
+				recent = append(recent, elt)
+			}
+		}
+		if len(recent) == 0 {
+			delete(anonymousCreations.times, key)
+		} else {
+			anonymousCreations.times[key] = recent
+		}
+	}
+	if len(anonymousCreations.times[addr]) >= anonymousUsersPerAddressPerDay {
+		return false
+	}
+	anonymousCreations.times[addr] = append(anonymousCreations.times[addr], now)
+	return true
+}
+
+// expireAnonymousUsers deletes anonymous users who have not signed in
+// or worked on an assignment for anonymousUserRetention.
+func expireAnonymousUsers(tx *sql.Tx, now time.Time) (int64, error) {
+	cutoff := now.Add(-anonymousUserRetention).UTC()
+	result, err := tx.Exec(`DELETE FROM users WHERE lti_id LIKE ? AND last_signed_in_at < ? `+
+		`AND NOT EXISTS (SELECT 1 FROM assignments WHERE assignments.user_id = users.id AND assignments.updated_at >= ?)`,
+		anonymousLtiPrefix+"%", cutoff, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// getPracticeCourse returns the course used for practice assignments,
+// creating it if necessary. Anonymous users have a course of their own.
+func getPracticeCourse(tx *sql.Tx, anonymous bool, now time.Time) (*Course, error) {
+	course := &Course{
+		Name:        "Practice",
+		Label:       "practice",
+		LtiID:       "codegrinder:practice",
+		ConsumerKey: practiceConsumerKey,
+		CanvasID:    -1,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if anonymous {
+		course.Name = "Anonymous practice"
+		course.Label = "practice-anonymous"
+		course.LtiID = "codegrinder:practice:anonymous"
+		course.CanvasID = -2
+	}
+
+	old := new(Course)
+	if err := meddler.QueryRow(tx, old, `SELECT * FROM courses WHERE consumer_key = ? AND lti_id = ?`, course.ConsumerKey, course.LtiID); err == nil {
+		if old.DeletedAt != nil {
+			return nil, fmt.Errorf("the practice course has been deleted")
+		}
+		return old, nil
+	} else if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("db error loading practice course: %v", err)
+	}
+
+	log.Printf("creating new course %s (%s)", course.LtiID, course.Name)
+	if err := meddler.Insert(tx, "courses", course); err != nil {
+		return nil, fmt.Errorf("db error creating practice course: %v", err)
+	}
+	if anonymous && (Config.AnonymousMinutes > 0 || Config.AnonymousDailyMinutes > 0) {
+		limits := &CourseLimits{
+			CourseID:     course.ID,
+			ProblemTypes: []string{},
+			DailyMinutes: Config.AnonymousDailyMinutes,
+			UserMinutes:  Config.AnonymousMinutes,
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		}
+		if err := meddler.Insert(tx, "course_limits", limits); err != nil {
+			return nil, fmt.Errorf("db error creating limits for practice course: %v", err)
+		}
+	}
+	return course, nil
+}
+
+// GetPublicProblemSets handles requests to /v2/public/problem_sets,
+// returning the problem sets that anyone may practice.
+func GetPublicProblemSets(w http.ResponseWriter, tx *sql.Tx, render render.Render) {
+	problemSets := []*ProblemSet{}
+	if err := meddler.QueryAll(tx, &problemSets, `SELECT * FROM problem_sets WHERE public AND deleted_at IS NULL ORDER BY unique_id`); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, problemSets)
+}
+
+// PostProblemSetPublic handles requests to /v2/problem_sets/:problem_set_id/public,
+// letting anyone practice a problem set.
+func PostProblemSetPublic(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	setProblemSetPublic(w, tx, params, currentUser, render, true)
+}
+
+// DeleteProblemSetPublic handles DELETE requests to /v2/problem_sets/:problem_set_id/public,
+// making a problem set available only through LTI assignments again.
+// Practice assignments already started are left alone.
+func DeleteProblemSetPublic(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	setProblemSetPublic(w, tx, params, currentUser, render, false)
+}
+
+func setProblemSetPublic(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render, public bool) {
+	now := time.Now()
+
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	set := new(ProblemSet)
+	if err := meddler.QueryRow(tx, set, `SELECT * FROM problem_sets WHERE id = ? AND deleted_at IS NULL`, problemSetID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if set.Public != public {
+		set.Public = public
+		set.UpdatedAt = now
+		if err := meddler.Update(tx, "problem_sets", set); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		action := "unpublish"
+		if public {
+			action = "publish"
+		}
+		if err := audit(tx, currentUser, action, "problem set", set.ID, "%s", set.Unique); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		log.Printf("user %d (%s) set problem set %s (%d) public=%v", currentUser.ID, currentUser.Email, set.Unique, set.ID, public)
+	}
+	render.JSON(http.StatusOK, set)
+}
+
+// PostProblemSetPractice handles requests to /v2/problem_sets/:problem_set_id/practice,
+// returning the current user's practice assignment for a public problem set,
+// creating it if necessary.
+func PostProblemSetPractice(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	now := time.Now()
+
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+	set := new(ProblemSet)
+	if err := meddler.QueryRow(tx, set, `SELECT * FROM problem_sets WHERE id = ? AND public AND deleted_at IS NULL`, problemSetID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	course, err := getPracticeCourse(tx, strings.HasPrefix(currentUser.LtiID, anonymousLtiPrefix), now)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	ltiID := fmt.Sprintf("practice:%d", set.ID)
+	asst := new(Assignment)
+	if err := meddler.QueryRow(tx, asst, `SELECT * FROM assignments WHERE course_id = ? AND lti_id = ? AND user_id = ?`,
+		course.ID, ltiID, currentUser.ID); err == nil {
+		render.JSON(http.StatusOK, asst)
+		return
+	} else if err != sql.ErrNoRows {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	asst = &Assignment{
+		CourseID:     course.ID,
+		ProblemSetID: set.ID,
+		UserID:       currentUser.ID,
+		Roles:        "Learner",
+		RawScores:    map[string][]float64{},
+		Variants:     map[string]string{},
+		LtiID:        ltiID,
+		CanvasTitle:  set.Note,
+		CanvasID:     -set.ID,
+		ConsumerKey:  practiceConsumerKey,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := meddler.Insert(tx, "assignments", asst); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("new practice assignment %d, %q for user %s (%d)", asst.ID, set.Unique, currentUser.Name, currentUser.ID)
+
+	render.JSON(http.StatusOK, asst)
+}
+
+// PostPracticeAnonymous handles requests to /v2/practice/anonymous,
+// creating a new anonymous user and returning a session cookie for it.
+// It is only available if the anonymousPractice option is set.
+func PostPracticeAnonymous(w http.ResponseWriter, r *http.Request, tx *sql.Tx, render render.Render) {
+	now := time.Now()
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).UTC()
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM users WHERE lti_id LIKE ? AND created_at >= ?`, anonymousLtiPrefix+"%", midnight).Scan(&count); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if count >= anonymousUsersPerDay || !allowAnonymousUser(remoteHost(r), now) {
+		loggedHTTPErrorf(w, http.StatusTooManyRequests, "too many visitors are practicing today; please try again tomorrow")
+		return
+	}
+
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error generating user ID: %v", err)
+		return
+	}
+	id := anonymousLtiPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	// as with accounts, the canvas ID is left empty
+	user := &User{
+		Name:           "Anonymous",
+		LtiID:          id,
+		CanvasLogin:    id,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		LastSignedInAt: now,
+	}
+	if err := meddler.Insert(tx, "users", user); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("anonymous practice user %d created", user.ID)
+
+	session := NewSession(user.ID)
+	cookie := session.Save(w)
+
+	result := map[string]string{"cookie": cookie}
+	render.JSON(http.StatusOK, result)
+}
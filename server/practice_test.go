@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+func TestAllowAnonymousUser(t *testing.T) {
+	now := time.Now()
+	for i := 0; i < anonymousUsersPerAddressPerDay; i++ {
+		if !allowAnonymousUser("192.0.2.1", now) {
+			t.Fatalf("anonymous user %d from one address was refused", i+1)
+		}
+	}
+	if allowAnonymousUser("192.0.2.1", now) {
+		t.Errorf("address was allowed more than %d anonymous users in a day", anonymousUsersPerAddressPerDay)
+	}
+	if !allowAnonymousUser("192.0.2.2", now) {
+		t.Errorf("another address was refused")
+	}
+	if !allowAnonymousUser("192.0.2.1", now.Add(25*time.Hour)) {
+		t.Errorf("address was still refused the next day")
+	}
+}
+
+func TestExpireAnonymousUsers(t *testing.T) {
+	d := newTestDB(t)
+	old := d.now.Add(-anonymousUserRetention - time.Hour)
+	anonymous := func(name string) *User {
+		t.Helper()
+		user := d.user(name)
+		user.LtiID = anonymousLtiPrefix + name
+		user.LastSignedInAt = old
+		if err := meddler.Update(d.tx, "users", user); err != nil {
+			t.Fatalf("updating user: %v", err)
+		}
+		return user
+	}
+
+	anonymous("gone")
+	working := anonymous("working")
+	d.assignment(d.course("practice"), working, d.problemSet("loops-set"), false)
+	student := d.user("student")
+	student.LastSignedInAt = old
+	if err := meddler.Update(d.tx, "users", student); err != nil {
+		t.Fatalf("updating user: %v", err)
+	}
+
+	count, err := expireAnonymousUsers(d.tx, d.now)
+	if err != nil {
+		t.Fatalf("expireAnonymousUsers: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("deleted %d users, want 1", count)
+	}
+	expectIDs(t, "users", d.ids(`SELECT id FROM users ORDER BY id`), working.ID, student.ID)
+}
@@ -37,7 +37,7 @@ func GetProblemTypes(w http.ResponseWriter, tx *sql.Tx, render render.Render) {
 
 // GetProblemType handles a request to /v2/problemtypes/:name,
 // returning a single problem type with the given name.
-func GetProblemType(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+func GetProblemType(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params) {
 	name := params["name"]
 
 	problemType, err := getProblemType(tx, name)
@@ -46,7 +46,55 @@ func GetProblemType(w http.ResponseWriter, tx *sql.Tx, params martini.Params, re
 		return
 	}
 
-	render.JSON(http.StatusOK, problemType)
+	renderJSONWithETag(w, r, problemType)
+}
+
+// GetProblemTypeTemplate handles a request to /v2/problem_types/:name/template,
+// returning the files to start a new problem of the given type with.
+// Templates are kept in templates/<name> under the server root, and
+// templates/default is used for problem types that do not have one.
+func GetProblemTypeTemplate(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	name := params["name"]
+
+	if _, err := getProblemType(tx, name); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	dir := filepath.Join(root, "templates", name)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		dir = filepath.Join(root, "templates", "default")
+	}
+	template := &ProblemTemplate{
+		ProblemType: name,
+		Files:       make(map[string][]byte),
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relpath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		template.Files[filepath.ToSlash(relpath)] = raw
+		return nil
+	})
+	if os.IsNotExist(err) {
+		loggedHTTPErrorf(w, http.StatusNotFound, "no template found for problem type %s", name)
+		return
+	} else if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error loading template for problem type %s: %v", name, err)
+		return
+	}
+
+	render.JSON(http.StatusOK, template)
 }
 
 func getProblemType(tx *sql.Tx, name string) (*ProblemType, error) {
@@ -73,6 +121,9 @@ func getProblemType(tx *sql.Tx, name string) (*ProblemType, error) {
 			if err != nil {
 				return err
 			}
+			if relpath == problemTypeConfigFile {
+				return nil
+			}
 			raw, err := ioutil.ReadFile(path)
 			if err != nil {
 				return err
@@ -106,7 +157,7 @@ func getProblemType(tx *sql.Tx, name string) (*ProblemType, error) {
 // If parameter unique=<...> present, results will be filtered by matching Unique field.
 // If parameter problemType=<...> present, results will be filtered by matching ProblemType.
 // If parameter note=<...> present, results will be filtered by case-insensitive substring match on Note field.
-func GetProblems(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User, render render.Render) {
+func GetProblems(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User) {
 	// build search terms
 	where := ""
 	args := []interface{}{}
@@ -124,6 +175,7 @@ func GetProblems(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser
 	}
 
 	// get the problems
+	where = addWhereNull(where, "problems.deleted_at")
 	problems := []*Problem{}
 	var err error
 
@@ -139,12 +191,12 @@ func GetProblems(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser
 		return
 	}
 
-	render.JSON(http.StatusOK, problems)
+	renderJSONWithETag(w, r, problems)
 }
 
 // GetProblem handles a request to /v2/problems/:problem_id,
 // returning a single problem.
-func GetProblem(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+func GetProblem(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User) {
 	problemID, err := parseID(w, "problem_id", params["problem_id"])
 	if err != nil {
 		return
@@ -153,11 +205,11 @@ func GetProblem(w http.ResponseWriter, tx *sql.Tx, params martini.Params, curren
 	problem := new(Problem)
 
 	if currentUser.Admin || currentUser.Author {
-		err = meddler.Load(tx, "problems", problem, problemID)
+		err = meddler.QueryRow(tx, problem, `SELECT * FROM problems WHERE id = ? AND deleted_at IS NULL`, problemID)
 	} else {
 		err = meddler.QueryRow(tx, problem, `SELECT problems.* `+
 			`FROM problems JOIN user_problems ON problems.id = problem_id `+
-			`WHERE user_id = ? AND problem_id = ?`,
+			`WHERE user_id = ? AND problem_id = ? AND problems.deleted_at IS NULL`,
 			currentUser.ID, problemID)
 	}
 
@@ -166,29 +218,26 @@ func GetProblem(w http.ResponseWriter, tx *sql.Tx, params martini.Params, curren
 		return
 	}
 
-	render.JSON(http.StatusOK, problem)
+	renderJSONWithETag(w, r, problem)
 }
 
 // DeleteProblem handles request to /v2/problems/:problem_id,
-// deleting the given problem.
-// Note: this deletes all steps, assignments, and commits related to the problem,
-// and it removes it from any problem sets it was part of.
-func DeleteProblem(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+// marking the given problem as deleted.
+// Note: the problem is hidden but its steps and student work are kept
+// until it is purged, see PurgeProblem.
+func DeleteProblem(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
 	problemID, err := strconv.ParseInt(params["problem_id"], 10, 64)
 	if err != nil {
 		loggedHTTPErrorf(w, http.StatusBadRequest, "error parsing problem_id from URL: %v", err)
 		return
 	}
 
-	if _, err := tx.Exec(`DELETE FROM problems WHERE id = ?`, problemID); err != nil {
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
-		return
-	}
+	softDelete(w, tx, currentUser, "problems", "problem", problemID)
 }
 
 // GetProblemSteps handles a request to /v2/problems/:problem_id/steps,
 // returning a list of all steps for a problem.
-func GetProblemSteps(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+func GetProblemSteps(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User) {
 	problemID, err := parseID(w, "problem_id", params["problem_id"])
 	if err != nil {
 		return
@@ -217,18 +266,18 @@ func GetProblemSteps(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params
 		return
 	}
 
-	if !currentUser.Admin && !currentUser.Author {
-		for _, elt := range problemSteps {
-			elt.Solution = nil
-		}
+	locale := requestLocale(r, currentUser)
+	for _, elt := range problemSteps {
+		localizeStep(elt, locale, currentUser)
 	}
+	w.Header().Add("Vary", "Accept-Language")
 
-	render.JSON(http.StatusOK, problemSteps)
+	renderJSONWithETag(w, r, problemSteps)
 }
 
 // GetProblemStep handles a request to /v2/problems/:problem_id/steps/:step,
 // returning a single problem step.
-func GetProblemStep(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+func GetProblemStep(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User) {
 	problemID, err := parseID(w, "problem_id", params["problem_id"])
 	if err != nil {
 		return
@@ -254,10 +303,20 @@ func GetProblemStep(w http.ResponseWriter, tx *sql.Tx, params martini.Params, cu
 		return
 	}
 
+	localizeStep(problemStep, requestLocale(r, currentUser), currentUser)
+	w.Header().Add("Vary", "Accept-Language")
+	renderJSONWithETag(w, r, problemStep)
+}
+
+// localizeStep prepares a step for the user, with the instructions in
+// their language. Students do not get the solution or the other
+// translations.
+func localizeStep(step *ProblemStep, locale string, currentUser *User) {
+	step.Instructions = step.LocalizedInstructions(locale)
 	if !currentUser.Admin && !currentUser.Author {
-		problemStep.Solution = nil
+		step.Solution = nil
+		step.Translations = nil
 	}
-	render.JSON(http.StatusOK, problemStep)
 }
 
 // GetProblemSets handles a request to /v2/problem_sets,
@@ -294,6 +353,7 @@ func GetProblemSets(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentU
 	}
 
 	// get the problemsets
+	where = addWhereNull(where, "problem_sets.deleted_at")
 	problemSets := []*ProblemSet{}
 	var err error
 
@@ -324,7 +384,7 @@ func GetProblemSets(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentU
 
 // GetProblemSet handles a request to /v2/problem_sets/:problem_set_id,
 // returning a single problem set.
-func GetProblemSet(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+func GetProblemSet(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User) {
 	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
 	if err != nil {
 		return
@@ -333,11 +393,11 @@ func GetProblemSet(w http.ResponseWriter, tx *sql.Tx, params martini.Params, cur
 	problemSet := new(ProblemSet)
 
 	if currentUser.Admin || currentUser.Author {
-		err = meddler.Load(tx, "problem_sets", problemSet, problemSetID)
+		err = meddler.QueryRow(tx, problemSet, `SELECT * FROM problem_sets WHERE id = ? AND deleted_at IS NULL`, problemSetID)
 	} else {
 		err = meddler.QueryRow(tx, problemSet, `SELECT problem_sets.* `+
 			`FROM problem_sets JOIN user_problem_sets ON problem_sets.id = problem_set_id `+
-			`WHERE user_id = ? AND problem_set_id = ?`,
+			`WHERE user_id = ? AND problem_set_id = ? AND problem_sets.deleted_at IS NULL`,
 			currentUser.ID, problemSetID)
 	}
 
@@ -346,7 +406,7 @@ func GetProblemSet(w http.ResponseWriter, tx *sql.Tx, params martini.Params, cur
 		return
 	}
 
-	render.JSON(http.StatusOK, problemSet)
+	renderJSONWithETag(w, r, problemSet)
 }
 
 // GetProblemSetProblems handles a request to /v2/problem_sets/:problem_set_id/problems,
@@ -382,16 +442,13 @@ func GetProblemSetProblems(w http.ResponseWriter, r *http.Request, tx *sql.Tx, p
 }
 
 // DeleteProblemSet handles request to /v2/problem_sets/:problem_set_id,
-// deleting the given problem set.
-// Note: this deletes all assignments and commits related to the problem set.
-func DeleteProblemSet(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+// marking the given problem set as deleted.
+// Note: assignments and commits are kept until it is purged, see PurgeProblemSet.
+func DeleteProblemSet(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
 	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
 	if err != nil {
 		return
 	}
 
-	if _, err := tx.Exec(`DELETE FROM problem_sets WHERE id = ?`, problemSetID); err != nil {
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
-		return
-	}
+	softDelete(w, tx, currentUser, "problem_sets", "problem_set", problemSetID)
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// GetProblemSetExport handles a request to /v2/problem_sets/:problem_set_id/export,
+// returning a tar.gz archive of the problem set with all of its problems and steps.
+func GetProblemSetExport(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	problemSetID, err := parseID(w, "problem_set_id", params["problem_set_id"])
+	if err != nil {
+		return
+	}
+
+	archive, err := loadProblemSetArchive(tx, problemSetID)
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", archive.ProblemSet.Unique+".tar.gz"))
+	if err := archive.WriteTarGz(w); err != nil {
+		log.Printf("error writing archive for problem set %d: %v", problemSetID, err)
+	}
+}
+
+func loadProblemSetArchive(tx *sql.Tx, problemSetID int64) (*ProblemSetArchive, error) {
+	archive := &ProblemSetArchive{ProblemSet: new(ProblemSet)}
+	if err := meddler.Load(tx, "problem_sets", archive.ProblemSet, problemSetID); err != nil {
+		return nil, err
+	}
+
+	var psps []*ProblemSetProblem
+	if err := meddler.QueryAll(tx, &psps, `SELECT * FROM problem_set_problems WHERE problem_set_id = ? ORDER BY problem_id`, problemSetID); err != nil {
+		return nil, err
+	}
+	for _, psp := range psps {
+		elt := &ProblemArchive{Problem: new(Problem), Weight: psp.Weight}
+		if err := meddler.Load(tx, "problems", elt.Problem, psp.ProblemID); err != nil {
+			return nil, err
+		}
+		if err := meddler.QueryAll(tx, &elt.Steps, `SELECT * FROM problem_steps WHERE problem_id = ? ORDER BY step`, psp.ProblemID); err != nil {
+			return nil, err
+		}
+
+		// the asset store is not shared between installations,
+		// so assets travel with the other step files
+		for _, step := range elt.Steps {
+			assets, err := fetchStepAssets(step)
+			if err != nil {
+				return nil, fmt.Errorf("problem %s: %v", elt.Problem.Unique, err)
+			}
+			if len(assets) > 0 && step.Files == nil {
+				step.Files = make(map[string][]byte)
+			}
+			for name, contents := range assets {
+				step.Files[name] = contents
+			}
+			step.Assets = nil
+		}
+		archive.Problems = append(archive.Problems, elt)
+	}
+
+	return archive, nil
+}
+
+// PostProblemSetImport handles a request to /v2/problem_sets/import,
+// creating a problem set and all of its problems from a tar.gz archive
+// produced by GetProblemSetExport.
+// Note: problems are not re-validated on a daycare, so this is restricted
+// to administrators.
+func PostProblemSetImport(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User, render render.Render) {
+	now := time.Now()
+
+	archive, err := ReadProblemSetArchive(r.Body)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if len(archive.Problems) == 0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "a problem set must have at least one problem")
+		return
+	}
+
+	// create the problems
+	var psps []*ProblemSetProblem
+	for _, elt := range archive.Problems {
+		problem := elt.Problem
+		problem.ID = 0
+		problem.CreatedAt = now
+		problem.UpdatedAt = now
+		if err := problem.Normalize(now, elt.Steps); err != nil {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "problem %s: %v", problem.Unique, err)
+			return
+		}
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM problems WHERE unique_id = ?`, problem.Unique).Scan(&count); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if count > 0 {
+			loggedHTTPErrorf(w, http.StatusConflict, "a problem with unique ID %s already exists", problem.Unique)
+			return
+		}
+		if err := meddler.Insert(tx, "problems", problem); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		for _, step := range elt.Steps {
+			if err := storeStepAssets(step); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+				return
+			}
+			step.ProblemID = problem.ID
+			if err := meddler.Insert(tx, "problem_steps", step); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+		}
+		weight := elt.Weight
+		if weight <= 0.0 {
+			weight = 1.0
+		}
+		psps = append(psps, &ProblemSetProblem{ProblemID: problem.ID, Weight: weight})
+	}
+
+	// create the problem set
+	set := archive.ProblemSet
+	set.ID = 0
+	set.Public = false
+	set.CreatedAt = now
+	set.UpdatedAt = now
+	if err := set.Normalize(now); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if err := meddler.Insert(tx, "problem_sets", set); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, psp := range psps {
+		psp.ProblemSetID = set.ID
+		if err := meddler.Insert(tx, "problem_set_problems", psp); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+	}
+
+	log.Printf("problem set %s (%d) with %d problem(s) imported by %s", set.Unique, set.ID, len(psps), currentUser.Name)
+
+	bundle := &ProblemSetBundle{ProblemSet: set, ProblemSetProblems: psps}
+	render.JSON(http.StatusOK, bundle)
+}
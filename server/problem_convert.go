@@ -0,0 +1,312 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+)
+
+// PostProblemBundleConvert handles a request to /v2/problem_bundles/convert,
+// converting an autograder package from another system into the skeleton
+// of a problem bundle. The request body is the package as a zip file.
+//
+// Parameter format=<...> is required, and must be "icpc" for an ICPC/DOMjudge
+// problem package or "gradescope" for a Gradescope autograder zip.
+//
+// Parameter unique=<...> is required, and gives the unique ID of the new problem.
+//
+// If parameter type=<...> present, it names the problem type to target.
+// Otherwise a default is chosen based on the format.
+//
+// The result is not signed and has no commits; the author is expected to
+// fill in the solution and create the problem in the usual way.
+func PostProblemBundleConvert(w http.ResponseWriter, r *http.Request, tx *sql.Tx, render render.Render) {
+	now := time.Now()
+
+	if err := r.ParseForm(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "parsing form data: %v", err)
+		return
+	}
+	unique := r.FormValue("unique")
+	if unique == "" {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "unique ID is required")
+		return
+	}
+
+	raw, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "error reading request body: %v", err)
+		return
+	}
+	zr, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "error reading zip file: %v", err)
+		return
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "error opening %s in zip file: %v", f.Name, err)
+			return
+		}
+		contents, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "error reading %s in zip file: %v", f.Name, err)
+			return
+		}
+		files[path.Clean(f.Name)] = contents
+	}
+	files = stripCommonPrefix(files)
+
+	var problem *Problem
+	var step *ProblemStep
+	switch format := r.FormValue("format"); format {
+	case "icpc":
+		problem, step, err = convertICPCPackage(files, r.FormValue("type"))
+	case "gradescope":
+		problem, step, err = convertGradescopePackage(files, r.FormValue("type"))
+	default:
+		loggedHTTPErrorf(w, http.StatusBadRequest, "unknown package format %q; must be icpc or gradescope", format)
+		return
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	problem.Unique = unique
+	problem.CreatedAt = now
+	problem.UpdatedAt = now
+
+	// make sure the target problem type exists
+	problemType, err := getProblemType(tx, step.ProblemType)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "unknown problem type %q", step.ProblemType)
+		return
+	}
+
+	bundle := &ProblemBundle{
+		ProblemTypes: map[string]*ProblemType{problemType.Name: problemType},
+		Problem:      problem,
+		ProblemSteps: []*ProblemStep{step},
+	}
+	render.JSON(http.StatusOK, bundle)
+}
+
+// stripCommonPrefix removes a single top-level directory shared by every file,
+// which is common when a package is zipped from its parent directory.
+func stripCommonPrefix(files map[string][]byte) map[string][]byte {
+	prefix := ""
+	for name := range files {
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) != 2 || (prefix != "" && parts[0] != prefix) {
+			return files
+		}
+		prefix = parts[0]
+	}
+	if prefix == "" {
+		return files
+	}
+	stripped := make(map[string][]byte)
+	for name, contents := range files {
+		stripped[strings.TrimPrefix(name, prefix+"/")] = contents
+	}
+	return stripped
+}
+
+var icpcNamePattern = regexp.MustCompile(`(?m)^name:\s*['"]?(.*?)['"]?\s*$`)
+
+// convertICPCPackage converts an ICPC problem package
+// (problem.yaml, problem_statement/, data/sample/, data/secret/)
+// into a single input/output step.
+func convertICPCPackage(files map[string][]byte, problemType string) (*Problem, *ProblemStep, error) {
+	if problemType == "" {
+		problemType = "python3inout"
+	}
+	if !strings.HasSuffix(problemType, "inout") {
+		return nil, nil, fmt.Errorf("ICPC packages can only be converted to input/output problem types")
+	}
+
+	note := ""
+	if yaml, exists := files["problem.yaml"]; exists {
+		if groups := icpcNamePattern.FindSubmatch(yaml); groups != nil {
+			note = string(groups[1])
+		}
+	} else {
+		return nil, nil, fmt.Errorf("package does not contain problem.yaml")
+	}
+	if note == "" {
+		note = "converted ICPC problem"
+	}
+
+	step := &ProblemStep{
+		Step:        1,
+		ProblemType: problemType,
+		Weight:      1.0,
+		Files:       make(map[string][]byte),
+		Whitelist:   make(map[string]bool),
+	}
+
+	// test cases: data/<group>/<name>.in and data/<group>/<name>.ans
+	count := 0
+	for name, contents := range files {
+		if !strings.HasPrefix(name, "data/") || !strings.HasSuffix(name, ".in") {
+			continue
+		}
+		base := strings.TrimSuffix(name, ".in")
+		answer, exists := files[base+".ans"]
+		if !exists {
+			return nil, nil, fmt.Errorf("found %s with no matching .ans file", name)
+		}
+		dir, file := path.Split(base)
+		label := path.Base(dir) + "-" + file
+		step.Files["inputs/"+label+".input"] = contents
+		step.Files["inputs/"+label+".expected"] = answer
+		count++
+	}
+	if count == 0 {
+		return nil, nil, fmt.Errorf("no test cases found under data/")
+	}
+
+	// problem statement: markdown is used as-is and LaTeX is
+	// included for the author to convert by hand
+	var tex []byte
+	for name, contents := range files {
+		if !strings.HasPrefix(name, "problem_statement/") {
+			continue
+		}
+		rel := strings.TrimPrefix(name, "problem_statement/")
+		switch {
+		case strings.HasSuffix(rel, ".md") && !strings.Contains(rel, "/"):
+			step.Files["doc/doc.md"] = contents
+		case strings.HasSuffix(rel, ".tex"):
+			tex = append(tex, contents...)
+		default:
+			step.Files["doc/"+rel] = contents
+		}
+	}
+	if _, exists := step.Files["doc/doc.md"]; !exists {
+		doc := "# " + note + "\n\nTODO: convert the problem statement\n"
+		if len(tex) > 0 {
+			doc += "\n```\n" + string(tex) + "\n```\n"
+		}
+		step.Files["doc/doc.md"] = []byte(doc)
+	}
+
+	step.Note = note
+	problem := &Problem{Note: note, Tags: []string{"icpc"}, Options: []string{}}
+	return problem, step, nil
+}
+
+// convertGradescopePackage converts a Gradescope autograder zip into a single
+// unit test step. Test files are kept and the Gradescope harness
+// (setup.sh, run_autograder, etc.) is dropped.
+func convertGradescopePackage(files map[string][]byte, problemType string) (*Problem, *ProblemStep, error) {
+	if _, exists := files["run_autograder"]; !exists {
+		return nil, nil, fmt.Errorf("package does not contain run_autograder")
+	}
+
+	// guess the problem type from the test files
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if problemType == "" {
+		for _, name := range names {
+			switch path.Ext(name) {
+			case ".py":
+				problemType = "python3unittest"
+			case ".cpp":
+				problemType = "cppunittest"
+			case ".c":
+				problemType = "cunittest"
+			case ".go":
+				problemType = "gounittest"
+			case ".hs":
+				problemType = "haskelltest"
+			case ".java":
+				problemType = "javajunit"
+			case ".js":
+				problemType = "nodetest"
+			case ".rkt":
+				problemType = "rackettest"
+			case ".rs":
+				problemType = "rustunittest"
+			case ".sh":
+				problemType = "shelltest"
+			}
+			if problemType != "" {
+				break
+			}
+		}
+	}
+	if problemType == "" {
+		return nil, nil, fmt.Errorf("unable to guess the problem type; please specify one")
+	}
+
+	step := &ProblemStep{
+		Step:        1,
+		Note:        "converted Gradescope autograder",
+		ProblemType: problemType,
+		Weight:      1.0,
+		Files:       make(map[string][]byte),
+		Whitelist:   make(map[string]bool),
+	}
+	harness := map[string]bool{
+		"setup.sh":             true,
+		"run_autograder":       true,
+		"run_tests.py":         true,
+		"requirements.txt":     true,
+		"results/results.json": true,
+	}
+	var dropped []string
+	source := make(map[string]string)
+	for _, name := range names {
+		var target string
+		switch {
+		case harness[name]:
+			dropped = append(dropped, name)
+			continue
+		case strings.HasPrefix(name, "tests/"):
+			target = name
+		case strings.HasPrefix(name, "solution/"):
+			target = "_solution/" + strings.TrimPrefix(name, "solution/")
+		default:
+			target = "tests/" + path.Base(name)
+		}
+
+		// files from different directories can land on the same name in tests/
+		if prior, exists := source[target]; exists {
+			return nil, nil, fmt.Errorf("%s and %s would both be stored as %s", prior, name, target)
+		}
+		source[target] = name
+		step.Files[target] = files[name]
+	}
+	doc := "# Converted Gradescope autograder\n\nTODO: write the instructions for this problem\n"
+	if len(dropped) > 0 {
+		doc += "\nThe following Gradescope harness files were not converted:\n\n"
+		for _, name := range dropped {
+			doc += "* `" + name + "`\n"
+		}
+	}
+	step.Files["doc/doc.md"] = []byte(doc)
+
+	problem := &Problem{Note: step.Note, Tags: []string{"gradescope"}, Options: []string{}}
+	return problem, step, nil
+}
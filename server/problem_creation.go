@@ -105,6 +105,11 @@ func saveProblemBundleCommon(w http.ResponseWriter, tx *sql.Tx, currentUser *Use
 
 	// note: unique constraint will be checked by the database
 
+	if err := checkProblemVariants(tx, problem, steps); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
 	// make sure the set of problem types included matches the list of steps
 	typeSet := make(map[string]bool)
 	for _, elt := range bundle.ProblemSteps {
@@ -195,6 +200,14 @@ func saveProblemBundleCommon(w http.ResponseWriter, tx *sql.Tx, currentUser *Use
 		return
 	}
 
+	// move large files to the asset store
+	for _, step := range steps {
+		if err := storeStepAssets(step); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+	}
+
 	// insert/update all the new steps
 	for _, step := range steps {
 		step.ProblemID = problem.ID
@@ -208,9 +221,14 @@ func saveProblemBundleCommon(w http.ResponseWriter, tx *sql.Tx, currentUser *Use
 		} else {
 			// update an existing record
 			// meddler only understands integer primary keys, so we have to do it the long way
-			filesJSON, err := json.Marshal(step.Files)
+			filesJSON, err := blobMeddler{}.PreWrite(step.Files)
 			if err != nil {
-				loggedHTTPErrorf(w, http.StatusInternalServerError, "json encoding error for step.Files: %v", err)
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "error storing step.Files: %v", err)
+				return
+			}
+			translationsJSON, err := json.Marshal(step.Translations)
+			if err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "json encoding error for step.Translations: %v", err)
 				return
 			}
 			whitelistJSON, err := json.Marshal(step.Whitelist)
@@ -218,26 +236,42 @@ func saveProblemBundleCommon(w http.ResponseWriter, tx *sql.Tx, currentUser *Use
 				loggedHTTPErrorf(w, http.StatusInternalServerError, "json encoding error for step.Whitelist: %v", err)
 				return
 			}
-			solutionJSON, err := json.Marshal(step.Solution)
+			binaryJSON, err := json.Marshal(step.Binary)
+			if err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "json encoding error for step.Binary: %v", err)
+				return
+			}
+			assetsJSON, err := json.Marshal(step.Assets)
+			if err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "json encoding error for step.Assets: %v", err)
+				return
+			}
+			solutionJSON, err := blobMeddler{}.PreWrite(step.Solution)
 			if err != nil {
-				loggedHTTPErrorf(w, http.StatusInternalServerError, "json encoding error for step.Solution: %v", err)
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "error storing step.Solution: %v", err)
 				return
 			}
 			result, err := tx.Exec(`UPDATE problem_steps SET `+
 				`problem_type=?, `+
 				`note=?, `+
 				`instructions=?, `+
+				`translations=?, `+
 				`weight=?, `+
 				`files=?, `+
 				`whitelist=?, `+
+				`binary_files=?, `+
+				`assets=?, `+
 				`solution=? `+
 				`WHERE problem_id=? AND step=?`,
 				step.ProblemType,
 				step.Note,
 				step.Instructions,
+				translationsJSON,
 				step.Weight,
 				filesJSON,
 				whitelistJSON,
+				binaryJSON,
+				assetsJSON,
 				solutionJSON,
 				step.ProblemID,
 				step.Step)
@@ -379,6 +413,10 @@ func PostProblemBundleUnconfirmed(w http.ResponseWriter, tx *sql.Tx, currentUser
 		}
 	}
 	if conflict.ID != 0 && conflict.ID != bundle.Problem.ID {
+		if conflict.DeletedAt != nil {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "unique ID %q is in use by deleted problem %d, which must be restored or purged first", bundle.Problem.Unique, conflict.ID)
+			return
+		}
 		loggedHTTPErrorf(w, http.StatusBadRequest, "unique ID %q is already in use by problem %d", bundle.Problem.Unique, conflict.ID)
 		return
 	}
@@ -390,7 +428,7 @@ func PostProblemBundleUnconfirmed(w http.ResponseWriter, tx *sql.Tx, currentUser
 	bundle.ProblemSignature = bundle.Problem.ComputeSignature(Config.DaycareSecret, bundle.ProblemSteps)
 
 	// assign a daycare host
-	host, err := daycareRegistrations.Assign(typeSet)
+	host, err := daycareRegistrations.Assign(typeSet, 0, 0)
 	if err != nil {
 		names := ""
 		for name := range typeSet {
@@ -420,10 +458,11 @@ func PostProblemBundleUnconfirmed(w http.ResponseWriter, tx *sql.Tx, currentUser
 		}
 		commit.Transcript = []*EventMessage{}
 		commit.ReportCard = nil
+		commit.Artifacts = nil
 		commit.Score = 0.0
 		commit.CreatedAt = now
 		commit.UpdatedAt = now
-		if err := commit.Normalize(now, bundle.ProblemSteps[n].Whitelist); err != nil {
+		if err := commit.Normalize(now, bundle.ProblemSteps[n].Whitelist, bundle.ProblemSteps[n].Binary); err != nil {
 			loggedHTTPErrorf(w, http.StatusBadRequest, "commit %d: %v", n, err)
 			return
 		}
@@ -455,7 +494,9 @@ func PostProblemSetBundle(w http.ResponseWriter, tx *sql.Tx, bundle ProblemSetBu
 		return
 	}
 
-	// clean up basic fields and do some checks
+	// clean up basic fields and do some checks;
+	// a problem set is made public separately, by an author
+	set.Public = false
 	set.CreatedAt = now
 	set.UpdatedAt = now
 	if err := set.Normalize(now); err != nil {
@@ -521,6 +562,7 @@ func PutProblemSetBundle(w http.ResponseWriter, tx *sql.Tx, bundle ProblemSetBun
 	}
 
 	// clean up basic fields and do some checks
+	set.Public = old.Public
 	set.UpdatedAt = now
 	if err := set.Normalize(now); err != nil {
 		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+	"gopkg.in/gcfg.v1"
+)
+
+// A problem type can be defined by a problemtype.cfg file in its
+// directory under files/ instead of by rows in setup/problemtypes.sql.
+// The TA loads every definition at startup and again when it receives
+// SIGHUP, replacing the problem type and its actions in the database,
+// so new and changed problem types do not require a rebuild. Daycares
+// only need the image and the problem type name in their config file.
+//
+//	[problemtype]
+//	image = codegrinder/forth
+//	command = make {action}
+//	maxCPU = 10
+//	...
+//
+//	[action "grade"]
+//	parser = xunit
+//	message = Grading‥
+//
+//	[action "run"]
+//	message = Running‥
+//	interactive = true
+//	maxSession = 1800
+//
+// The command and limits in the problemtype section are defaults for
+// every action, with {action} replaced by the action name. A definition
+// that fails validation is logged and skipped, leaving the database
+// unchanged for that problem type. Problem types are never deleted by
+// reloading.
+
+const problemTypeConfigFile = "problemtype.cfg"
+
+// problemTypeParsers runs an action's command and fills in the report
+// card from its output, by the parser name given for the action.
+// Problem types may only name parsers listed here.
+var problemTypeParsers = map[string]func(n *Nanny, cmd []string){
+	"xunit":     runAndParseXUnit,
+	"check":     runAndParseCheckXML,
+	"gobench":   runAndParseGoBench,
+	"gtest":     runAndParseGTest,
+	"jstest":    runAndParseJSTest,
+	"cargotest": runAndParseCargoTest,
+	"custom":    runAndParseCustom,
+}
+
+var problemTypeName = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+type problemTypeConfig struct {
+	ProblemType struct {
+		Image   string
+		Command string
+
+		MaxCPU      int64
+		MaxSession  int64
+		MaxTimeout  int64
+		MaxFD       int64
+		MaxFileSize int64
+		MaxMemory   int64
+		MaxThreads  int64
+	}
+	Action map[string]*struct {
+		Command     string
+		Parser      string
+		Message     string
+		Interactive bool
+
+		MaxCPU      int64
+		MaxSession  int64
+		MaxTimeout  int64
+		MaxFD       int64
+		MaxFileSize int64
+		MaxMemory   int64
+		MaxThreads  int64
+	}
+}
+
+// readProblemTypeConfig parses and validates a problem type definition.
+func readProblemTypeConfig(name, path string) (*ProblemType, error) {
+	var cfg problemTypeConfig
+	if err := gcfg.ReadFileInto(&cfg, path); err != nil {
+		return nil, err
+	}
+	if !problemTypeName.MatchString(name) {
+		return nil, fmt.Errorf("invalid problem type name %q", name)
+	}
+	if strings.TrimSpace(cfg.ProblemType.Image) == "" {
+		return nil, fmt.Errorf("no image given")
+	}
+	if len(cfg.Action) == 0 {
+		return nil, fmt.Errorf("no actions defined")
+	}
+
+	problemType := &ProblemType{
+		Name:    name,
+		Image:   strings.TrimSpace(cfg.ProblemType.Image),
+		Actions: make(map[string]*ProblemTypeAction),
+	}
+	defaults := &cfg.ProblemType
+	for action, elt := range cfg.Action {
+		if !problemTypeName.MatchString(action) {
+			return nil, fmt.Errorf("invalid action name %q", action)
+		}
+		command := elt.Command
+		if command == "" {
+			command = strings.Replace(cfg.ProblemType.Command, "{action}", action, -1)
+		}
+		if len(strings.Fields(command)) == 0 {
+			return nil, fmt.Errorf("action %s has no command", action)
+		}
+		if elt.Parser != "" && problemTypeParsers[elt.Parser] == nil {
+			return nil, fmt.Errorf("action %s has unknown parser %q", action, elt.Parser)
+		}
+		if elt.Parser != "" && elt.Interactive {
+			return nil, fmt.Errorf("action %s is interactive, so it cannot have a parser", action)
+		}
+		if elt.Message == "" {
+			return nil, fmt.Errorf("action %s has no message", action)
+		}
+
+		pick := func(label string, value, fallback int64) (int64, error) {
+			if value == 0 {
+				value = fallback
+			}
+			if value <= 0 {
+				return 0, fmt.Errorf("action %s must have a positive %s", action, label)
+			}
+			return value, nil
+		}
+		a := &ProblemTypeAction{
+			ProblemType: name,
+			Action:      action,
+			Command:     strings.Join(strings.Fields(command), " "),
+			Parser:      elt.Parser,
+			Message:     elt.Message,
+			Interactive: elt.Interactive,
+		}
+		var err error
+		if a.MaxCPU, err = pick("maxCPU", elt.MaxCPU, defaults.MaxCPU); err != nil {
+			return nil, err
+		}
+		if a.MaxSession, err = pick("maxSession", elt.MaxSession, defaults.MaxSession); err != nil {
+			return nil, err
+		}
+		if a.MaxTimeout, err = pick("maxTimeout", elt.MaxTimeout, defaults.MaxTimeout); err != nil {
+			return nil, err
+		}
+		if a.MaxFD, err = pick("maxFD", elt.MaxFD, defaults.MaxFD); err != nil {
+			return nil, err
+		}
+		if a.MaxFileSize, err = pick("maxFileSize", elt.MaxFileSize, defaults.MaxFileSize); err != nil {
+			return nil, err
+		}
+		if a.MaxMemory, err = pick("maxMemory", elt.MaxMemory, defaults.MaxMemory); err != nil {
+			return nil, err
+		}
+		if a.MaxThreads, err = pick("maxThreads", elt.MaxThreads, defaults.MaxThreads); err != nil {
+			return nil, err
+		}
+		problemType.Actions[action] = a
+	}
+	return problemType, nil
+}
+
+// saveProblemType replaces a problem type and its actions in the database.
+func saveProblemType(tx *sql.Tx, problemType *ProblemType) error {
+	res, err := tx.Exec(`UPDATE problem_types SET image = ? WHERE name = ?`, problemType.Image, problemType.Name)
+	if err != nil {
+		return fmt.Errorf("db error updating problem type %s: %v", problemType.Name, err)
+	}
+	if count, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("db error updating problem type %s: %v", problemType.Name, err)
+	} else if count == 0 {
+		if err := meddler.Insert(tx, "problem_types", problemType); err != nil {
+			return fmt.Errorf("db error inserting problem type %s: %v", problemType.Name, err)
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM problem_type_actions WHERE problem_type = ?`, problemType.Name); err != nil {
+		return fmt.Errorf("db error deleting actions for problem type %s: %v", problemType.Name, err)
+	}
+	actions := []string{}
+	for action := range problemType.Actions {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	for _, action := range actions {
+		if err := meddler.Insert(tx, "problem_type_actions", problemType.Actions[action]); err != nil {
+			return fmt.Errorf("db error inserting action %s for problem type %s: %v", action, problemType.Name, err)
+		}
+	}
+	return nil
+}
+
+// loadProblemTypes reads every problem type definition under files/
+// and saves the ones that are valid.
+func loadProblemTypes() {
+	dirs, err := ioutil.ReadDir(filepath.Join(root, "files"))
+	if err != nil {
+		log.Printf("loading problem types: %v", err)
+		return
+	}
+	loaded := []string{}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		path := filepath.Join(root, "files", dir.Name(), problemTypeConfigFile)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		problemType, err := readProblemTypeConfig(dir.Name(), path)
+		if err != nil {
+			log.Printf("skipping problem type %s: %s: %v", dir.Name(), path, err)
+			continue
+		}
+		err = withBackgroundTx(func(tx *sql.Tx) error {
+			return saveProblemType(tx, problemType)
+		})
+		if err != nil {
+			log.Printf("skipping problem type %s: %v", dir.Name(), err)
+			continue
+		}
+		loaded = append(loaded, problemType.Name)
+	}
+	if len(loaded) > 0 {
+		log.Printf("loaded problem types: %s", strings.Join(loaded, ", "))
+	}
+}
+
+// reloadProblemTypesOnHUP reloads problem type definitions whenever
+// the process receives SIGHUP.
+func reloadProblemTypesOnHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Printf("SIGHUP received, reloading problem types")
+			loadProblemTypes()
+		}
+	}()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// the parsers named by the shipped problem types must all be runnable,
+// whether they come from setup/problemtypes.sql or a problemtype.cfg file
+func TestProblemTypeParsersKnown(t *testing.T) {
+	d := newTestDB(t)
+	seed, err := ioutil.ReadFile("../setup/problemtypes.sql")
+	if err != nil {
+		t.Fatalf("reading problem types: %v", err)
+	}
+	if _, err := d.tx.Exec(string(seed)); err != nil {
+		t.Fatalf("loading problem types: %v", err)
+	}
+	rows, err := d.tx.Query(`SELECT problem_type, action, parser FROM problem_type_actions WHERE parser IS NOT NULL`)
+	if err != nil {
+		t.Fatalf("db error: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var problemType, action, parser string
+		if err := rows.Scan(&problemType, &action, &parser); err != nil {
+			t.Fatalf("db error: %v", err)
+		}
+		if problemTypeParsers[parser] == nil {
+			t.Errorf("problem type %s action %s has unknown parser %q", problemType, action, parser)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("db error: %v", err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join("..", "files", "*", problemTypeConfigFile))
+	if err != nil {
+		t.Fatalf("finding problem type files: %v", err)
+	}
+	for _, path := range paths {
+		if _, err := readProblemTypeConfig(filepath.Base(filepath.Dir(path)), path); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// Property-based tests are enabled by the properties=N problem option,
+// which asks the test framework for N examples per property. Each
+// student gets a fixed seed for each assignment, passed to the container
+// as PROPERTY_SEED (with PROPERTY_EXAMPLES set to N), so every run of a
+// student's tests explores the same examples and a failure can be
+// reproduced. The problem type Makefiles pass these on to hypothesis or
+// QuickCheck.
+
+// propertySeed returns the seed for a user's runs of an assignment.
+func propertySeed(assignmentID, userID int64) int64 {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d/%d", assignmentID, userID)
+	return int64(h.Sum32() & 0x7fffffff)
+}
+
+// propertyEnv returns the environment variables that configure
+// property-based tests, or nil if the problem does not use them.
+func propertyEnv(limits *limits) []string {
+	if limits.properties <= 0 {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf("PROPERTY_SEED=%d", limits.seed),
+		fmt.Sprintf("PROPERTY_EXAMPLES=%d", limits.properties),
+	}
+}
+
+// propertyCounterexample finds the minimal counterexample reported by
+// hypothesis or QuickCheck in the output of a failed test.
+// It returns "" if there is none.
+func propertyCounterexample(details string) string {
+	lines := strings.Split(details, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "Falsifying example:"):
+			// hypothesis:
+			//	Falsifying example: test_reverse(
+			//	    self=<tests.ReverseTest testMethod=test_reverse>,
+			//	    xs=[0, 1],
+			//	)
+			example := []string{strings.TrimSpace(strings.TrimPrefix(trimmed, "Falsifying example:"))}
+			if strings.HasSuffix(trimmed, "(") {
+				for _, next := range lines[i+1:] {
+					next = strings.TrimSpace(next)
+					if next == "" {
+						break
+					}
+					if strings.HasPrefix(next, "self=") {
+						continue
+					}
+					if next == ")" {
+						example = append(example, next)
+						break
+					}
+					example = append(example, "    "+next)
+				}
+			}
+			return strings.Join(example, "\n")
+
+		case strings.Contains(trimmed, "Falsified (after") || strings.Contains(trimmed, "Failed! Exception"):
+			// QuickCheck, with one shrunk argument per line:
+			//	*** Failed! Falsified (after 3 tests and 2 shrinks):
+			//	[0,1]
+			//	Use --quickcheck-replay=... to reproduce.
+			var example []string
+			for _, next := range lines[i+1:] {
+				next = strings.TrimSpace(next)
+				if next == "" || strings.HasPrefix(next, "Use --quickcheck-replay") {
+					break
+				}
+				example = append(example, next)
+			}
+			return strings.Join(example, "\n")
+		}
+	}
+	return ""
+}
+
+// reportCounterexamples puts the counterexample for each failed property
+// at the top of its report card entry.
+func reportCounterexamples(n *Nanny) {
+	for _, elt := range n.ReportCard.Results {
+		if elt.Passing() {
+			continue
+		}
+		if example := propertyCounterexample(elt.Details); example != "" {
+			elt.Details = fmt.Sprintf("minimal counterexample:\n%s\n\n%s", example, elt.Details)
+		}
+	}
+}
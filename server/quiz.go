@@ -708,6 +708,12 @@ func gradeQuizClass(now time.Time, tx *sql.Tx, quizID int64) error {
 			return err
 		}
 
+		// an instructor override takes precedence over the computed score
+		// note: the assignment has already been saved with the computed score
+		if err := applyScoreOverride(tx, assignment); err != nil {
+			return err
+		}
+
 		// post grade to LMS using LTI
 		var report bytes.Buffer
 
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/gorilla/websocket"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// A regrade sends the most recent graded submission for every step of a
+// problem back to a daycare, usually after the tests have been fixed.
+// The TA talks to the daycare itself, just as grind would, then saves the
+// new report card and score and posts the updated grade to the LMS. Each
+// submission is handled in its own short transaction since grading is slow.
+
+func regradeJobName(problemID int64) string {
+	return fmt.Sprintf("regrade-%d", problemID)
+}
+
+// regradeTarget is a commit to be regraded
+type regradeTarget struct {
+	commitID     int64
+	assignmentID int64
+}
+
+// PostProblemRegrade handles requests to /v2/problems/:problem_id/regrade,
+// starting a background job that regrades submissions for the problem.
+//
+// If parameter assignment=<...> present, only students with the same LMS
+// assignment as the given assignment are regraded.
+// If parameter user=<...> present, only that user is regraded.
+// Otherwise every student in a course the current user teaches is regraded,
+// or every student anywhere for administrators.
+func PostProblemRegrade(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	problem := new(Problem)
+	if err := meddler.Load(tx, "problems", problem, problemID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "parsing form data: %v", err)
+		return
+	}
+
+	// find the submissions to regrade
+	where := `commits.problem_id = ? AND commits.action = 'grade'`
+	args := []interface{}{problemID}
+	if s := r.FormValue("assignment"); s != "" {
+		assignment, ok := getInstructorAssignment(w, tx, martini.Params{"assignment_id": s}, currentUser)
+		if !ok {
+			return
+		}
+		where += ` AND assignments.course_id = ? AND assignments.lti_id = ?`
+		args = append(args, assignment.CourseID, assignment.LtiID)
+	} else if !currentUser.Admin {
+		where += ` AND assignments.course_id IN (SELECT course_id FROM assignments WHERE user_id = ? AND instructor)`
+		args = append(args, currentUser.ID)
+	}
+	if s := r.FormValue("user"); s != "" {
+		userID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || userID < 1 {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "error parsing user ID %q", s)
+			return
+		}
+		where += ` AND assignments.user_id = ?`
+		args = append(args, userID)
+	}
+	rows, err := tx.Query(`SELECT commits.id, commits.assignment_id FROM commits JOIN assignments ON commits.assignment_id = assignments.id `+
+		`WHERE NOT assignments.instructor AND `+where+` ORDER BY commits.assignment_id, commits.step`, args...)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	defer rows.Close()
+	var targets []*regradeTarget
+	for rows.Next() {
+		target := new(regradeTarget)
+		if err := rows.Scan(&target.commitID, &target.assignmentID); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		targets = append(targets, target)
+	}
+	if err := rows.Err(); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if len(targets) == 0 {
+		loggedHTTPErrorf(w, http.StatusNotFound, "no graded submissions found for problem %s", problem.Unique)
+		return
+	}
+
+	name := regradeJobName(problemID)
+	job, err := jobs.Start(name, func() error {
+		return runRegrade(name, problem, targets)
+	})
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusConflict, "%v", err)
+		return
+	}
+	job.Total = len(targets)
+	log.Printf("user %s (%d) started regrading %d submission(s) for problem %s", currentUser.Name, currentUser.ID, len(targets), problem.Unique)
+
+	render.JSON(http.StatusOK, job)
+}
+
+// GetProblemRegrade handles requests to /v2/problems/:problem_id/regrade,
+// returning the status of the most recent regrade of the problem.
+func GetProblemRegrade(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	job := jobs.Get(regradeJobName(problemID))
+	if job == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem %d has not been regraded", problemID)
+		return
+	}
+
+	render.JSON(http.StatusOK, job)
+}
+
+// runRegrade regrades each target in turn. A submission that cannot be
+// regraded is logged and counted, and the job moves on to the next one.
+// If notifications are enabled, students whose scores changed are told
+// once every submission has been regraded.
+func runRegrade(name string, problem *Problem, targets []*regradeTarget) error {
+	var before map[int64]float64
+	if Config.Notifications {
+		before = make(map[int64]float64)
+		err := withBackgroundTx(func(tx *sql.Tx) error {
+			for _, target := range targets {
+				if _, present := before[target.assignmentID]; present {
+					continue
+				}
+				var score float64
+				if err := tx.QueryRow(`SELECT COALESCE(score, 0) FROM assignments WHERE id = ?`, target.assignmentID).Scan(&score); err != nil {
+					return err
+				}
+				before[target.assignmentID] = score
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("db error loading scores: %v", err)
+		}
+	}
+
+	done, failed := 0, 0
+	jobs.Progress(name, done, failed, len(targets))
+	for _, target := range targets {
+		if err := regradeCommit(target); err != nil {
+			log.Printf("regrading commit %d for assignment %d: %v", target.commitID, target.assignmentID, err)
+			failed++
+		}
+		done++
+		jobs.Progress(name, done, failed, len(targets))
+	}
+	if before != nil {
+		if err := notifyRegrade(problem, before); err != nil {
+			log.Printf("sending regrade notifications for problem %s: %v", problem.Unique, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d submission(s) could not be regraded", failed, len(targets))
+	}
+	return nil
+}
+
+func regradeCommit(target *regradeTarget) error {
+	now := time.Now()
+
+	// sign the old submission for a daycare
+	var signed *CommitBundle
+	var problem *Problem
+	var submitted time.Time
+	err := withBackgroundTx(func(tx *sql.Tx) error {
+		commit := new(Commit)
+		if err := meddler.Load(tx, "commits", commit, target.commitID); err != nil {
+			return fmt.Errorf("db error loading commit: %v", err)
+		}
+		assignment := new(Assignment)
+		if err := meddler.Load(tx, "assignments", assignment, target.assignmentID); err != nil {
+			return fmt.Errorf("db error loading assignment: %v", err)
+		}
+		// the report card is written in the student's language
+		var locale string
+		if err := tx.QueryRow(`SELECT locale FROM users WHERE id = ?`, assignment.UserID).Scan(&locale); err != nil {
+			return fmt.Errorf("db error loading user: %v", err)
+		}
+		problem = new(Problem)
+		if err := meddler.Load(tx, "problems", problem, commit.ProblemID); err != nil {
+			return fmt.Errorf("db error loading problem: %v", err)
+		}
+		variant, steps, err := getProblemVariant(tx, assignment, problem)
+		if err != nil {
+			return err
+		}
+		if commit.Step < 1 || commit.Step > int64(len(steps)) {
+			return fmt.Errorf("commit is for step %d, but the problem now has %d step(s)", commit.Step, len(steps))
+		}
+		problemType, err := getProblemType(tx, steps[commit.Step-1].ProblemType)
+		if err != nil {
+			return fmt.Errorf("error loading problem type: %v", err)
+		}
+		host, err := daycareRegistrations.Assign(map[string]bool{problemType.Name: true}, assignment.UserID, problem.ID)
+		if err != nil {
+			return fmt.Errorf("error assigning a daycare: %v", err)
+		}
+
+		submitted = commit.UpdatedAt
+		commit.Transcript = []*EventMessage{}
+		commit.ReportCard = nil
+		commit.Artifacts = nil
+		commit.Score = 0.0
+		commit.UpdatedAt = now
+		typeSig := problemType.ComputeSignature(Config.DaycareSecret)
+		problemSig := variant.ComputeSignature(Config.DaycareSecret, steps)
+		signed = &CommitBundle{
+			ProblemType:          problemType,
+			ProblemTypeSignature: typeSig,
+			Problem:              variant,
+			ProblemSteps:         steps,
+			ProblemSignature:     problemSig,
+			Hostname:             host,
+			UserID:               assignment.UserID,
+			Commit:               commit,
+			CommitSignature:      commit.ComputeSignature(Config.DaycareSecret, typeSig, problemSig, host, assignment.UserID),
+			Locale:               locale,
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	graded, err := gradeOnDaycare(signed)
+	if err != nil {
+		return err
+	}
+	commit := graded.Commit
+	sig := commit.ComputeSignature(Config.DaycareSecret, signed.ProblemTypeSignature, signed.ProblemSignature, signed.Hostname, signed.UserID)
+	if graded.CommitSignature != sig {
+		return fmt.Errorf("daycare returned commit signature %s, but expected %s", graded.CommitSignature, sig)
+	}
+	if commit.ReportCard == nil {
+		return fmt.Errorf("daycare did not return a report card")
+	}
+	commit.Score = commit.ReportCard.ComputeScore()
+
+	// save the new results and update the grade
+	return withBackgroundTx(func(tx *sql.Tx) error {
+		old := new(Commit)
+		if err := meddler.Load(tx, "commits", old, target.commitID); err != nil {
+			return fmt.Errorf("db error loading commit: %v", err)
+		}
+		if !old.UpdatedAt.Equal(submitted) {
+			return fmt.Errorf("the student submitted again while the commit was being regraded")
+		}
+		commit.ID = old.ID
+		commit.CreatedAt = old.CreatedAt
+		commit.Message = old.Message
+		commit.ActiveTime = old.ActiveTime
+		commit.Client = old.Client
+		commit.ClientKeyID = old.ClientKeyID
+		commit.Note = "regraded"
+		if err := meddler.Save(tx, "commits", commit); err != nil {
+			return fmt.Errorf("db error saving commit: %v", err)
+		}
+		if err := saveCommitHistory(tx, commit); err != nil {
+			return fmt.Errorf("db error saving commit history: %v", err)
+		}
+
+		assignment := new(Assignment)
+		if err := meddler.Load(tx, "assignments", assignment, target.assignmentID); err != nil {
+			return fmt.Errorf("db error loading assignment: %v", err)
+		}
+		if assignment.RawScores == nil {
+			assignment.RawScores = map[string][]float64{}
+		}
+		assignment.SetMinorScore(problem.Unique, int(commit.Step-1), commit.Score)
+		majorWeights, minorWeights, err := GetProblemWeights(tx, assignment)
+		if err != nil {
+			return err
+		}
+		if assignment.Score, err = assignment.ComputeScore(majorWeights, minorWeights); err != nil {
+			return err
+		}
+		assignment.UpdatedAt = time.Now()
+		if err := meddler.Save(tx, "assignments", assignment); err != nil {
+			return fmt.Errorf("db error saving assignment: %v", err)
+		}
+
+		var transcript bytes.Buffer
+		if err := commit.DumpTranscript(&transcript); err != nil {
+			return fmt.Errorf("error writing transcript: %v", err)
+		}
+		report := fmt.Sprintf("<h1>Regrading transcript for problem %s step %d</h1>\n<pre>%s</pre>\n",
+			html.EscapeString(problem.Unique), commit.Step, html.EscapeString(transcript.String()))
+		posted := *assignment
+		if err := applyScoreOverride(tx, &posted); err != nil {
+			return err
+		}
+		go postGrade(&posted, report)
+		return nil
+	})
+}
+
+// gradeOnDaycare sends a signed commit bundle to its daycare for grading
+// and waits for the graded bundle, ignoring the events streamed back.
+func gradeOnDaycare(bundle *CommitBundle) (*CommitBundle, error) {
+	headers := make(http.Header)
+	headers.Set("Sec-Websocket-Protocol", SocketCompression)
+	url := "wss://" + bundle.Hostname + "/v2/sockets/" + bundle.ProblemType.Name + "/" + bundle.Commit.Action
+	socket, resp, err := websocket.DefaultDialer.Dial(url, headers)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("error dialing %s: %v (%s)", url, err, resp.Status)
+		}
+		return nil, fmt.Errorf("error dialing %s: %v", url, err)
+	}
+	defer socket.Close()
+
+	if err := WriteSocketJSON(socket, &DaycareRequest{CommitBundle: bundle}); err != nil {
+		return nil, fmt.Errorf("error writing request message: %v", err)
+	}
+	for {
+		reply := new(DaycareResponse)
+		if err := ReadSocketJSON(socket, reply); err != nil {
+			return nil, fmt.Errorf("socket error reading event: %v", err)
+		}
+		switch {
+		case reply.Error != "":
+			return nil, fmt.Errorf("daycare returned an error: %s", reply.Error)
+		case reply.CommitBundle != nil:
+			return reply.CommitBundle, nil
+		case reply.Event != nil:
+			// ignore the streamed data
+		default:
+			return nil, fmt.Errorf("unexpected reply from daycare")
+		}
+	}
+}
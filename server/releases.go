@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+)
+
+// Grind binaries are kept on the TA so students can upgrade without
+// an external distribution channel. Administrators upload them with
+// 'grind release', and each one is saved in a file named
+//
+//	$CODEGRINDERROOT/grind/<version>/<os>_<arch>/grind[.exe]
+//
+// optionally next to grind.sig, holding the base64 ed25519 signature
+// of the binary made with the release signing key.
+
+func grindReleasePath(version, platform string) string {
+	name := "grind"
+	if strings.HasPrefix(platform, "windows_") {
+		name = "grind.exe"
+	}
+	return filepath.Join(root, "grind", version, platform, name)
+}
+
+// grindHashes caches the hash of each release binary by path,
+// recomputing it only when the file changes.
+var grindHashes = struct {
+	sync.Mutex
+	sums map[string]grindHash
+}{sums: make(map[string]grindHash)}
+
+type grindHash struct {
+	modTime time.Time
+	size    int64
+	sum     string
+}
+
+func hashGrindRelease(path string, info os.FileInfo) (string, error) {
+	grindHashes.Lock()
+	defer grindHashes.Unlock()
+	if elt, present := grindHashes.sums[path]; present && elt.modTime.Equal(info.ModTime()) && elt.size == info.Size() {
+		return elt.sum, nil
+	}
+	fp, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fp); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	grindHashes.sums[path] = grindHash{modTime: info.ModTime(), size: info.Size(), sum: sum}
+	return sum, nil
+}
+
+// listGrindReleases finds all release binaries, newest first.
+func listGrindReleases() ([]*GrindRelease, error) {
+	versions, err := ioutil.ReadDir(filepath.Join(root, "grind"))
+	if os.IsNotExist(err) {
+		return []*GrindRelease{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	releases := []*GrindRelease{}
+	for _, version := range versions {
+		if _, err := semver.Parse(version.Name()); err != nil || !version.IsDir() {
+			continue
+		}
+		platforms, err := ioutil.ReadDir(filepath.Join(root, "grind", version.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, platform := range platforms {
+			parts := strings.SplitN(platform.Name(), "_", 2)
+			if len(parts) != 2 || !platform.IsDir() {
+				continue
+			}
+			path := grindReleasePath(version.Name(), platform.Name())
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			sum, err := hashGrindRelease(path, info)
+			if err != nil {
+				return nil, err
+			}
+			release := &GrindRelease{
+				Version: version.Name(),
+				OS:      parts[0],
+				Arch:    parts[1],
+				Size:    info.Size(),
+				SHA256:  sum,
+				URL:     "https://" + Config.Hostname + "/v2/grind/releases/" + version.Name() + "/" + platform.Name(),
+			}
+			if sig, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), "grind.sig")); err == nil {
+				release.Signature = strings.TrimSpace(string(sig))
+			}
+			releases = append(releases, release)
+		}
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return semver.MustParse(releases[i].Version).GT(semver.MustParse(releases[j].Version))
+	})
+	return releases, nil
+}
+
+// GetGrindReleases handles requests to /v2/grind/releases,
+// listing the grind binaries available for download, newest first.
+// Parameters os=<goos> and arch=<goarch> limit it to one platform.
+func GetGrindReleases(w http.ResponseWriter, r *http.Request, render render.Render) {
+	releases, err := listGrindReleases()
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error listing grind releases: %v", err)
+		return
+	}
+	goos, arch := r.URL.Query().Get("os"), r.URL.Query().Get("arch")
+	matches := []*GrindRelease{}
+	for _, release := range releases {
+		if (goos == "" || goos == release.OS) && (arch == "" || arch == release.Arch) {
+			matches = append(matches, release)
+		}
+	}
+	render.JSON(http.StatusOK, matches)
+}
+
+// GetGrindRelease handles requests to /v2/grind/releases/:version/:platform,
+// sending a single grind binary.
+func GetGrindRelease(w http.ResponseWriter, r *http.Request, params martini.Params) {
+	version, platform := params["version"], params["platform"]
+	if _, err := semver.Parse(version); err != nil || strings.ContainsAny(platform, `/\.`) {
+		loggedHTTPErrorf(w, http.StatusNotFound, "no grind release %s for %s", version, platform)
+		return
+	}
+	path := grindReleasePath(version, platform)
+	if _, err := os.Stat(path); err != nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "no grind release %s for %s", version, platform)
+		return
+	}
+	serveGrindRelease(w, r, path)
+}
+
+func serveGrindRelease(w http.ResponseWriter, r *http.Request, path string) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filepath.Base(path))
+	http.ServeFile(w, r, path)
+}
+
+// GetGrindDownload handles requests to /v2/grind/download/:os/:arch,
+// sending the newest grind binary for a platform.
+func GetGrindDownload(w http.ResponseWriter, r *http.Request, params martini.Params) {
+	releases, err := listGrindReleases()
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error listing grind releases: %v", err)
+		return
+	}
+	for _, release := range releases {
+		if release.OS == params["os"] && release.Arch == params["arch"] {
+			serveGrindRelease(w, r, grindReleasePath(release.Version, release.OS+"_"+release.Arch))
+			return
+		}
+	}
+	loggedHTTPErrorf(w, http.StatusNotFound, "no grind release for %s/%s", params["os"], params["arch"])
+}
+
+var grindPlatformName = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// PostGrindRelease handles requests to /v2/grind/releases,
+// saving an uploaded grind binary as a new release.
+// A release already saved for the same version and platform is replaced.
+func PostGrindRelease(w http.ResponseWriter, release GrindRelease, render render.Render) {
+	if _, err := semver.Parse(release.Version); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "invalid version %q: %v", release.Version, err)
+		return
+	}
+	if !grindPlatformName.MatchString(release.OS) || !grindPlatformName.MatchString(release.Arch) {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "invalid platform %s/%s", release.OS, release.Arch)
+		return
+	}
+	if len(release.Binary) == 0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "release is missing the grind binary")
+		return
+	}
+	sum := sha256.Sum256(release.Binary)
+	if release.SHA256 != "" && release.SHA256 != hex.EncodeToString(sum[:]) {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "grind binary does not match its sha256 hash")
+		return
+	}
+
+	// write the new files in place of any old ones
+	path := grindReleasePath(release.Version, release.OS+"_"+release.Arch)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error creating release directory: %v", err)
+		return
+	}
+	sigPath := filepath.Join(filepath.Dir(path), "grind.sig")
+	if release.Signature != "" {
+		if err := ioutil.WriteFile(sigPath, []byte(release.Signature+"\n"), 0644); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "error saving release signature: %v", err)
+			return
+		}
+	} else if err := os.Remove(sigPath); err != nil && !os.IsNotExist(err) {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error removing old release signature: %v", err)
+		return
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, release.Binary, 0644); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error saving grind binary: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error saving grind binary: %v", err)
+		return
+	}
+	log.Printf("saved grind %s for %s/%s (%d bytes)", release.Version, release.OS, release.Arch, len(release.Binary))
+
+	release.Size = int64(len(release.Binary))
+	release.Binary = nil
+	release.SHA256 = hex.EncodeToString(sum[:])
+	release.URL = "https://" + Config.Hostname + "/v2/grind/releases/" + release.Version + "/" + release.OS + "_" + release.Arch
+	render.JSON(http.StatusOK, &release)
+}
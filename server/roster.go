@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// GetCourseRoster handles requests to /v2/courses/:course_id/roster,
+// returning every user with an assignment in the course, their LTI roles
+// and most recent activity, and their score on each assignment in the
+// order the assignments were first launched. Instructor score overrides
+// take precedence. Active members found by a roster sync who have not
+// launched anything are included too. Only instructors for the course
+// may see the roster.
+//
+// If parameter section=<...> present, only users in that section are listed.
+func GetCourseRoster(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	course, ok := getInstructorCourse(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+	sectionID, ok := getSectionFilter(w, r, tx, course.ID)
+	if !ok {
+		return
+	}
+
+	var assignments []*Assignment
+	where, args := sectionWhere(`course_id = ?`, []interface{}{course.ID}, sectionID)
+	if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments WHERE `+where+` ORDER BY created_at`, args...); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	roster := &CourseRoster{
+		Course:      course,
+		Sections:    []*CourseSection{},
+		Assignments: []*RosterAssignment{},
+		Users:       []*RosterUser{},
+	}
+	if err := meddler.QueryAll(tx, &roster.Sections, `SELECT * FROM course_sections WHERE course_id = ? ORDER BY name`, course.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	sectionNames := make(map[int64]string)
+	for _, section := range roster.Sections {
+		sectionNames[section.ID] = section.Name
+	}
+	columns := make(map[string]int)
+	byUser := make(map[int64]*RosterUser)
+	launched := make(map[int64]time.Time)
+	var userIDs []int64
+	for _, asst := range assignments {
+		// one column per LMS assignment
+		column, exists := columns[asst.LtiID]
+		if !exists {
+			column = len(roster.Assignments)
+			columns[asst.LtiID] = column
+			roster.Assignments = append(roster.Assignments, &RosterAssignment{
+				Title:        asst.CanvasTitle,
+				ProblemSetID: asst.ProblemSetID,
+				DueAt:        asst.DueAt,
+			})
+		}
+
+		if err := applyScoreOverride(tx, asst); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		elt := byUser[asst.UserID]
+		if elt == nil {
+			elt = &RosterUser{ID: asst.UserID, Started: true}
+			byUser[asst.UserID] = elt
+			userIDs = append(userIDs, asst.UserID)
+		}
+		for len(elt.Scores) <= column {
+			elt.Scores = append(elt.Scores, nil)
+		}
+		score := asst.Score
+		elt.Scores[column] = &score
+
+		// roles come from the most recent launch
+		if asst.UpdatedAt.After(launched[asst.UserID]) {
+			launched[asst.UserID] = asst.UpdatedAt
+			elt.Roles = asst.Roles
+		}
+		if asst.Instructor {
+			elt.Instructor = true
+		}
+		active := asst.UpdatedAt
+		var last time.Time
+		err := tx.QueryRow(`SELECT updated_at FROM commits WHERE assignment_id = ? ORDER BY updated_at DESC LIMIT 1`, asst.ID).Scan(&last)
+		if err != nil && err != sql.ErrNoRows {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
+		if err == nil && last.After(active) {
+			active = last
+		}
+		if elt.LastActivity == nil || active.After(*elt.LastActivity) {
+			active = active.Local()
+			elt.LastActivity = &active
+		}
+	}
+
+	// enrolled users who have not started, and everyone's section
+	var members []*CourseMember
+	if err := meddler.QueryAll(tx, &members, `SELECT * FROM course_members WHERE course_id = ? ORDER BY id`, course.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, member := range members {
+		elt := byUser[member.UserID]
+		if elt == nil {
+			if !member.Active || sectionID != 0 && member.SectionID != sectionID {
+				continue
+			}
+			elt = &RosterUser{
+				ID:         member.UserID,
+				Roles:      member.Roles,
+				Instructor: (&Assignment{Roles: member.Roles}).IsInstructorRole(),
+			}
+			byUser[member.UserID] = elt
+			userIDs = append(userIDs, member.UserID)
+		}
+		elt.Section = sectionNames[member.SectionID]
+	}
+
+	users, err := gradeUsers(tx, userIDs)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	for _, user := range users {
+		elt := byUser[user.ID]
+		elt.Name = user.Name
+		elt.Email = user.Email
+		for len(elt.Scores) < len(roster.Assignments) {
+			elt.Scores = append(elt.Scores, nil)
+		}
+		roster.Users = append(roster.Users, elt)
+	}
+
+	// instructors first, then students, each by name as gradeUsers sorted them
+	sort.SliceStable(roster.Users, func(i, j int) bool {
+		return roster.Users[i].Instructor && !roster.Users[j].Instructor
+	})
+
+	render.JSON(http.StatusOK, roster)
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// A roster sync fills in who is enrolled in a course before anyone launches
+// an assignment, so instructors can see which students have not started.
+// It uses the LTI membership service if the LMS gave one at launch, and
+// falls back to the Canvas API if the canvasToken option is set. Users the
+// server has never seen are created with placeholder identities that their
+// first launch replaces, see getUpdateUser.
+
+const (
+	rosterCanvasPrefix   = "canvas:"
+	rosterPageLimit      = 100
+	membershipMediaType  = "application/vnd.ims.lti-nrps.v2.membershipcontainer+json"
+	legacyMembershipType = "application/vnd.ims.lis.v2.membershipcontainer+json"
+)
+
+// canvasEnrollmentRoles maps Canvas enrollment types to LTI roles.
+var canvasEnrollmentRoles = map[string]string{
+	"StudentEnrollment":  "Learner",
+	"TeacherEnrollment":  "Instructor",
+	"TaEnrollment":       "TeachingAssistant",
+	"DesignerEnrollment": "ContentDeveloper",
+	"ObserverEnrollment": "Mentor",
+}
+
+// rosterMember is one enrollment as reported by the LMS.
+type rosterMember struct {
+	ltiID    string // LTI user ID, if known
+	canvasID int64  // Canvas user ID, if known
+	login    string
+	name     string
+	email    string
+	roles    []string
+	active   bool
+	section  string // the LMS's ID for the section, if known
+}
+
+// rosterSection is one section as reported by the LMS.
+type rosterSection struct {
+	ltiID string
+	name  string
+}
+
+func rosterSyncJobName(courseID int64) string {
+	return fmt.Sprintf("roster-%d", courseID)
+}
+
+// PostCourseRosterSync handles requests to /v2/courses/:course_id/roster/sync,
+// starting a background job that loads the course roster from the LMS.
+// Only instructors for the course may sync it.
+func PostCourseRosterSync(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	course, ok := getInstructorCourse(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+	if course.ConsumerKey == practiceConsumerKey {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "practice courses have no LMS roster")
+		return
+	}
+
+	// pick a source for the roster
+	var fetch func() ([]*rosterMember, []*rosterSection, error)
+	source := ""
+	switch {
+	case course.MembershipsURL != "":
+		secret, err := getLTISecret(tx, course.ConsumerKey)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		membershipsURL, consumerKey := course.MembershipsURL, course.ConsumerKey
+		fetch = func() ([]*rosterMember, []*rosterSection, error) {
+			members, err := fetchMemberships(membershipsURL, consumerKey, secret)
+			return members, nil, err
+		}
+		source = "LTI membership service"
+
+	case Config.CanvasToken != "" && course.CanvasID > 0:
+		// any consumer can claim a Canvas course ID in its launches,
+		// so the token is only used for the consumer it was configured for
+		if course.ConsumerKey != Config.CanvasConsumerKey {
+			loggedHTTPErrorf(w, http.StatusForbidden, "course %d was launched through consumer %q, but the Canvas API token is for %q",
+				course.ID, course.ConsumerKey, Config.CanvasConsumerKey)
+			return
+		}
+		canvasID := course.CanvasID
+		fetch = func() ([]*rosterMember, []*rosterSection, error) {
+			return fetchCanvasRoster(canvasID)
+		}
+		source = "Canvas API at " + Config.CanvasAPIDomain
+
+	default:
+		loggedHTTPErrorf(w, http.StatusBadRequest, "course %d has no LTI membership service and no Canvas API access is configured", course.ID)
+		return
+	}
+
+	courseID := course.ID
+	name := rosterSyncJobName(courseID)
+	job, err := jobs.Start(name, func() error {
+		return runRosterSync(name, courseID, fetch)
+	})
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusConflict, "%v", err)
+		return
+	}
+	log.Printf("user %s (%d) started a roster sync for course %s (%d) using the %s", currentUser.Name, currentUser.ID, course.Name, course.ID, source)
+
+	render.JSON(http.StatusOK, job)
+}
+
+// GetCourseRosterSync handles requests to /v2/courses/:course_id/roster/sync,
+// returning the status of the most recent roster sync for the course.
+func GetCourseRosterSync(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	course, ok := getInstructorCourse(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+	job := jobs.Get(rosterSyncJobName(course.ID))
+	if job == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "the roster for course %d has not been synced", course.ID)
+		return
+	}
+
+	render.JSON(http.StatusOK, job)
+}
+
+// runRosterSync loads the roster from the LMS and then records it.
+// Members from an earlier sync who are no longer listed are marked inactive.
+func runRosterSync(name string, courseID int64, fetch func() ([]*rosterMember, []*rosterSection, error)) error {
+	now := time.Now()
+
+	members, sections, err := fetch()
+	if err != nil {
+		return err
+	}
+	jobs.Progress(name, 0, 0, len(members))
+
+	added := 0
+	err = withBackgroundTx(func(tx *sql.Tx) error {
+		// roster user IDs come from the course's LTI consumer
+		var consumerKey string
+		if err := tx.QueryRow(`SELECT consumer_key FROM courses WHERE id = ?`, courseID).Scan(&consumerKey); err != nil {
+			return err
+		}
+
+		sectionIDs := make(map[string]int64)
+		for _, elt := range sections {
+			section := new(CourseSection)
+			if err := meddler.QueryRow(tx, section, `SELECT * FROM course_sections WHERE course_id = ? AND lti_id = ?`, courseID, elt.ltiID); err == sql.ErrNoRows {
+				section = &CourseSection{CourseID: courseID, LtiID: elt.ltiID, CreatedAt: now}
+			} else if err != nil {
+				return err
+			}
+			section.Name = elt.name
+			section.UpdatedAt = now
+			if err := meddler.Save(tx, "course_sections", section); err != nil {
+				return err
+			}
+			sectionIDs[elt.ltiID] = section.ID
+		}
+
+		seen := make(map[int64]bool)
+		for i, elt := range members {
+			user, created, err := getRosterUser(tx, consumerKey, elt, now)
+			if err != nil {
+				return err
+			}
+			if created {
+				added++
+			}
+			seen[user.ID] = true
+
+			member := new(CourseMember)
+			if err := meddler.QueryRow(tx, member, `SELECT * FROM course_members WHERE course_id = ? AND user_id = ?`, courseID, user.ID); err == sql.ErrNoRows {
+				member = &CourseMember{CourseID: courseID, UserID: user.ID, CreatedAt: now}
+			} else if err != nil {
+				return err
+			}
+			if elt.section != "" {
+				member.SectionID = sectionIDs[elt.section]
+			}
+			member.Roles = strings.Join(elt.roles, ",")
+			member.Active = elt.active
+			member.SyncedAt = now
+			member.UpdatedAt = now
+			if err := meddler.Save(tx, "course_members", member); err != nil {
+				return err
+			}
+			jobs.Progress(name, i+1, 0, len(members))
+		}
+
+		var old []*CourseMember
+		if err := meddler.QueryAll(tx, &old, `SELECT * FROM course_members WHERE course_id = ? AND active`, courseID); err != nil {
+			return err
+		}
+		for _, member := range old {
+			if !seen[member.UserID] {
+				member.Active = false
+				member.UpdatedAt = now
+				if err := meddler.Update(tx, "course_members", member); err != nil {
+					return err
+				}
+			}
+		}
+
+		course := new(Course)
+		if err := meddler.Load(tx, "courses", course, courseID); err != nil {
+			return err
+		}
+		course.RosterSyncedAt = &now
+		return meddler.Update(tx, "courses", course)
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("roster sync for course %d found %d member(s) in %d section(s), %d of them new users", courseID, len(members), len(sections), added)
+	return nil
+}
+
+// getRosterUser finds the user for a roster entry, creating a new user if
+// they have never launched. An existing user's identity is left alone, since
+// launches keep it up to date.
+func getRosterUser(tx *sql.Tx, consumerKey string, elt *rosterMember, now time.Time) (*User, bool, error) {
+	user := new(User)
+	if elt.ltiID != "" {
+		err := meddler.QueryRow(tx, user, `SELECT users.* FROM users JOIN user_links ON users.id = user_links.user_id `+
+			`WHERE user_links.consumer_key = ? AND user_links.lti_id = ?`, consumerKey, elt.ltiID)
+		if err == sql.ErrNoRows {
+			err = meddler.QueryRow(tx, user, `SELECT * FROM users WHERE consumer_key = ? AND lti_id = ?`, consumerKey, elt.ltiID)
+		}
+		if err == nil {
+			return user, false, nil
+		} else if err != sql.ErrNoRows {
+			return nil, false, err
+		}
+	}
+	if elt.canvasID > 0 {
+		if err := meddler.QueryRow(tx, user, `SELECT * FROM users WHERE consumer_key = ? AND canvas_id = ?`, consumerKey, elt.canvasID); err == nil {
+			return user, false, nil
+		} else if err != sql.ErrNoRows {
+			return nil, false, err
+		}
+	}
+
+	ltiID := elt.ltiID
+	if ltiID == "" {
+		ltiID = rosterCanvasPrefix + strconv.FormatInt(elt.canvasID, 10)
+	}
+	login := elt.login
+	if login != "" {
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM users WHERE consumer_key = ? AND canvas_login = ?`, consumerKey, login).Scan(&count); err != nil {
+			return nil, false, err
+		}
+		if count > 0 {
+			login = ""
+		}
+	}
+	if login == "" {
+		login = ltiID
+	}
+
+	// as with accounts, the canvas ID is left empty until the first launch if it is not known
+	canvasID := elt.canvasID
+	if canvasID < 0 {
+		canvasID = 0
+	}
+	user = &User{
+		Name:        elt.name,
+		Email:       elt.email,
+		LtiID:       ltiID,
+		ConsumerKey: consumerKey,
+		CanvasLogin: login,
+		CanvasID:    canvasID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := meddler.Insert(tx, "users", user); err != nil {
+		return nil, false, err
+	}
+	log.Printf("roster sync created user %d (%s)", user.ID, user.Email)
+	return user, true, nil
+}
+
+// membershipContainer is a page of results from an LTI membership service.
+// It accepts both the names and role provisioning services 2.0 format and
+// the older membership service 1.0 format.
+type membershipContainer struct {
+	Members []struct {
+		Status       string   `json:"status"`
+		Name         string   `json:"name"`
+		Email        string   `json:"email"`
+		UserID       string   `json:"user_id"`
+		LegacyUserID string   `json:"lti11_legacy_user_id"`
+		Roles        []string `json:"roles"`
+	} `json:"members"`
+
+	PageOf struct {
+		MembershipSubject struct {
+			Membership []struct {
+				Status string `json:"status"`
+				Member struct {
+					UserID string `json:"userId"`
+					Name   string `json:"name"`
+					Email  string `json:"email"`
+				} `json:"member"`
+				Role []string `json:"role"`
+			} `json:"membership"`
+		} `json:"membershipSubject"`
+	} `json:"pageOf"`
+	NextPage string `json:"nextPage"`
+}
+
+// fetchMemberships loads every page of a course's LTI membership service.
+func fetchMemberships(membershipsURL, consumerKey, secret string) ([]*rosterMember, error) {
+	var members []*rosterMember
+	next := membershipsURL
+	for page := 0; next != ""; page++ {
+		if page >= rosterPageLimit {
+			return nil, fmt.Errorf("the membership service returned more than %d pages", rosterPageLimit)
+		}
+		auth, err := signOAuthGet(consumerKey, next, secret)
+		if err != nil {
+			return nil, err
+		}
+		container := new(membershipContainer)
+		link, err := getRosterPage(next, auth, membershipMediaType+", "+legacyMembershipType, container)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, elt := range container.Members {
+			// LTI 1.1 launches use the legacy ID where there is one
+			id := elt.LegacyUserID
+			if id == "" {
+				id = elt.UserID
+			}
+			members = append(members, &rosterMember{
+				ltiID:  id,
+				name:   elt.Name,
+				email:  elt.Email,
+				roles:  normalizeRoles(elt.Roles),
+				active: membershipActive(elt.Status),
+			})
+		}
+		for _, elt := range container.PageOf.MembershipSubject.Membership {
+			members = append(members, &rosterMember{
+				ltiID:  elt.Member.UserID,
+				name:   elt.Member.Name,
+				email:  elt.Member.Email,
+				roles:  normalizeRoles(elt.Role),
+				active: membershipActive(elt.Status),
+			})
+		}
+
+		next = link
+		if next == "" {
+			next = container.NextPage
+		}
+	}
+	return members, nil
+}
+
+// canvasUser is a user as returned by the Canvas API course users list.
+type canvasUser struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	LoginID     string `json:"login_id"`
+	Email       string `json:"email"`
+	Enrollments []struct {
+		Type            string `json:"type"`
+		CourseSectionID int64  `json:"course_section_id"`
+		EnrollmentState string `json:"enrollment_state"`
+	} `json:"enrollments"`
+}
+
+// fetchCanvasRoster loads the sections and enrolled users of a course
+// through the Canvas API. The token is only ever sent to the Canvas API
+// domain from the config file, including when following page links.
+func fetchCanvasRoster(canvasCourseID int64) ([]*rosterMember, []*rosterSection, error) {
+	auth := "Bearer " + Config.CanvasToken
+	base := fmt.Sprintf("https://%s/api/v1/courses/%d", Config.CanvasAPIDomain, canvasCourseID)
+	getPage := func(target string, elt interface{}) (string, error) {
+		u, err := url.Parse(target)
+		if err != nil || u.Scheme != "https" || !strings.EqualFold(u.Host, Config.CanvasAPIDomain) {
+			return "", fmt.Errorf("refusing to send the Canvas API token to %q", target)
+		}
+		return getRosterPage(target, auth, "application/json", elt)
+	}
+
+	var sections []*rosterSection
+	next := base + "/sections?per_page=100"
+	for page := 0; next != ""; page++ {
+		if page >= rosterPageLimit {
+			return nil, nil, fmt.Errorf("the Canvas API returned more than %d pages of sections", rosterPageLimit)
+		}
+		var list []struct {
+			ID   int64  `json:"id"`
+			Name string `json:"name"`
+		}
+		link, err := getPage(next, &list)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, elt := range list {
+			sections = append(sections, &rosterSection{ltiID: strconv.FormatInt(elt.ID, 10), name: elt.Name})
+		}
+		next = link
+	}
+
+	var members []*rosterMember
+	next = base + "/users?include[]=email&include[]=enrollments&enrollment_state[]=active&enrollment_state[]=invited&per_page=100"
+	for page := 0; next != ""; page++ {
+		if page >= rosterPageLimit {
+			return nil, nil, fmt.Errorf("the Canvas API returned more than %d pages of users", rosterPageLimit)
+		}
+		var list []*canvasUser
+		link, err := getPage(next, &list)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, elt := range list {
+			member := &rosterMember{
+				canvasID: elt.ID,
+				login:    elt.LoginID,
+				name:     elt.Name,
+				email:    elt.Email,
+			}
+
+			// one entry per user, placed in a student section if there is one
+			for _, enrollment := range elt.Enrollments {
+				role, known := canvasEnrollmentRoles[enrollment.Type]
+				if !known {
+					continue
+				}
+				member.roles = appendRole(member.roles, role)
+				member.active = member.active || enrollment.EnrollmentState == "active" || enrollment.EnrollmentState == "invited"
+				if enrollment.CourseSectionID > 0 && (member.section == "" || role == "Learner") {
+					member.section = strconv.FormatInt(enrollment.CourseSectionID, 10)
+				}
+			}
+			if len(member.roles) > 0 {
+				members = append(members, member)
+			}
+		}
+		next = link
+	}
+	return members, sections, nil
+}
+
+// getRosterPage fetches one page of results from an LMS and decodes the
+// JSON into elt. It returns the URL of the next page, if any.
+func getRosterPage(target, auth, accept string, elt interface{}) (string, error) {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return "", fmt.Errorf("error preparing roster request: %v", err)
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Accept", accept)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending roster request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("result status %d (%s) when loading roster from %s", resp.StatusCode, resp.Status, req.URL.Host)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(elt); err != nil {
+		return "", fmt.Errorf("error decoding roster from %s: %v", req.URL.Host, err)
+	}
+	return nextPageLink(resp.Header.Get("Link")), nil
+}
+
+// nextPageLink finds the rel="next" URL in a Link header.
+func nextPageLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		for _, param := range parts[1:] {
+			if strings.Replace(strings.TrimSpace(param), " ", "", -1) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+	return ""
+}
+
+// normalizeRoles converts role URIs such as
+// http://purl.imsglobal.org/vocab/lis/v2/membership#Instructor
+// to the short names used in LTI 1.1 launches.
+func normalizeRoles(roles []string) []string {
+	var out []string
+	for _, role := range roles {
+		if i := strings.LastIndexAny(role, "#/"); i >= 0 {
+			role = role[i+1:]
+		}
+		if role != "" {
+			out = appendRole(out, role)
+		}
+	}
+	return out
+}
+
+func appendRole(roles []string, role string) []string {
+	for _, elt := range roles {
+		if elt == role {
+			return roles
+		}
+	}
+	return append(roles, role)
+}
+
+// membershipActive reports whether a membership status such as
+// "Active", "liss:Active", or "Inactive" means the member is enrolled.
+func membershipActive(status string) bool {
+	status = strings.ToLower(status)
+	return status == "" || strings.HasSuffix(status, "active") && !strings.HasSuffix(status, "inactive")
+}
+
+// signOAuthGet returns the Authorization header for a GET request to an
+// LTI service. Query parameters are signed but stay in the URL.
+func signOAuthGet(consumerKey, targetURL, secret string) (string, error) {
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return "", fmt.Errorf("bad roster URL: %v", err)
+	}
+
+	v := url.Values{}
+	v.Set("oauth_consumer_key", consumerKey)
+	v.Set("oauth_signature_method", "HMAC-SHA1")
+	v.Set("oauth_timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+	v.Set("oauth_version", "1.0")
+	v.Set("oauth_nonce", strconv.FormatInt(time.Now().UnixNano(), 10))
+
+	signed := url.Values{}
+	for key, values := range v {
+		signed[key] = values
+	}
+	for key, values := range u.Query() {
+		signed[key] = append(signed[key], values...)
+	}
+	v.Set("oauth_signature", computeOAuthSignature("GET", targetURL, signed, secret))
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf(`OAuth realm="%s"`, escape("https://"+Config.Hostname)))
+	for key, val := range v {
+		buf.WriteString(fmt.Sprintf(`,%s="%s"`, key, escape(val[0])))
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// testDB holds a transaction on an empty in-memory database with the full schema,
+// along with helpers to fill it in.
+type testDB struct {
+	t   *testing.T
+	tx  *sql.Tx
+	now time.Time
+	seq int
+}
+
+func newTestDB(t *testing.T) *testDB {
+	t.Helper()
+	meddler.Default = meddler.SQLite
+
+	schema, err := ioutil.ReadFile("../setup/schema.sql")
+	if err != nil {
+		t.Fatalf("reading schema: %v", err)
+	}
+	db, err := sql.Open("sqlite3", ":memory:?_foreign_keys=ON&_case_sensitive_like=OFF")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+
+	// each connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatalf("loading schema: %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("starting transaction: %v", err)
+	}
+
+	// the blobs meddler stores file contents through the current transaction
+	blobTx = tx
+	t.Cleanup(func() {
+		blobTx = nil
+		tx.Rollback()
+		db.Close()
+	})
+	return &testDB{t: t, tx: tx, now: time.Now().Round(time.Second)}
+}
+
+func (d *testDB) insert(table string, elt interface{}) {
+	d.t.Helper()
+	if err := meddler.Insert(d.tx, table, elt); err != nil {
+		d.t.Fatalf("inserting into %s: %v", table, err)
+	}
+}
+
+func (d *testDB) next() int {
+	d.seq++
+	return d.seq
+}
+
+func (d *testDB) user(name string) *User {
+	d.t.Helper()
+	id := fmt.Sprintf("test:%s:%d", name, d.next())
+	user := &User{
+		Name:           name,
+		Email:          name + "@example.com",
+		LtiID:          id,
+		CanvasLogin:    id,
+		CreatedAt:      d.now,
+		UpdatedAt:      d.now,
+		LastSignedInAt: d.now,
+	}
+	d.insert("users", user)
+	return user
+}
+
+func (d *testDB) course(name string) *Course {
+	d.t.Helper()
+	n := d.next()
+	course := &Course{
+		Name:        name,
+		Label:       name,
+		LtiID:       fmt.Sprintf("test:%s:%d", name, n),
+		ConsumerKey: "test",
+		CanvasID:    int64(n),
+		CreatedAt:   d.now,
+		UpdatedAt:   d.now,
+	}
+	d.insert("courses", course)
+	return course
+}
+
+func (d *testDB) problem(unique string, variants ...string) *Problem {
+	d.t.Helper()
+	problem := &Problem{
+		Unique:    unique,
+		Note:      unique,
+		Tags:      []string{},
+		Options:   []string{},
+		Variants:  variants,
+		CreatedAt: d.now,
+		UpdatedAt: d.now,
+	}
+	if problem.Variants == nil {
+		problem.Variants = []string{}
+	}
+	d.insert("problems", problem)
+	return problem
+}
+
+func (d *testDB) problemSet(unique string, problems ...*Problem) *ProblemSet {
+	d.t.Helper()
+	set := &ProblemSet{
+		Unique:    unique,
+		Note:      unique,
+		Tags:      []string{},
+		CreatedAt: d.now,
+		UpdatedAt: d.now,
+	}
+	d.insert("problem_sets", set)
+	for _, problem := range problems {
+		d.insert("problem_set_problems", &ProblemSetProblem{ProblemSetID: set.ID, ProblemID: problem.ID, Weight: 1.0})
+	}
+	return set
+}
+
+func (d *testDB) assignment(course *Course, user *User, set *ProblemSet, instructor bool) *Assignment {
+	d.t.Helper()
+	n := d.next()
+	asst := &Assignment{
+		CourseID:     course.ID,
+		ProblemSetID: set.ID,
+		UserID:       user.ID,
+		Roles:        "Learner",
+		Instructor:   instructor,
+		RawScores:    map[string][]float64{},
+		Variants:     map[string]string{},
+		LtiID:        fmt.Sprintf("test:%s:%d", set.Unique, n),
+		CanvasTitle:  set.Note,
+		CanvasID:     int64(n),
+		ConsumerKey:  "test",
+		CreatedAt:    d.now,
+		UpdatedAt:    d.now,
+	}
+	if instructor {
+		asst.Roles = "Instructor"
+	}
+	d.insert("assignments", asst)
+	return asst
+}
+
+func (d *testDB) step(problem *Problem, step int64) *ProblemStep {
+	d.t.Helper()
+	if _, err := d.tx.Exec(`INSERT OR IGNORE INTO problem_types (name, image) VALUES ('test', 'test')`); err != nil {
+		d.t.Fatalf("inserting problem type: %v", err)
+	}
+	elt := &ProblemStep{
+		ProblemID:    problem.ID,
+		Step:         step,
+		ProblemType:  "test",
+		Note:         fmt.Sprintf("step %d", step),
+		Instructions: "",
+		Translations: map[string]string{},
+		Weight:       1.0,
+		Files:        map[string][]byte{},
+		Whitelist:    map[string]bool{},
+		Binary:       map[string]bool{},
+		Assets:       map[string]string{},
+		Solution:     map[string][]byte{},
+	}
+	d.insert("problem_steps", elt)
+	return elt
+}
+
+func (d *testDB) commit(asst *Assignment, problem *Problem, step int64, updatedAt time.Time, score float64) *Commit {
+	d.t.Helper()
+	commit := &Commit{
+		AssignmentID: asst.ID,
+		ProblemID:    problem.ID,
+		Step:         step,
+		Action:       "grade",
+		Files:        map[string][]byte{"main.py": []byte(fmt.Sprintf("# %s\n", updatedAt))},
+		ReportCard:   NewReportCard(),
+		Artifacts:    map[string][]byte{},
+		Score:        score,
+		CreatedAt:    updatedAt,
+		UpdatedAt:    updatedAt,
+	}
+	d.insert("commits", commit)
+	return commit
+}
+
+// ids runs a query that returns a column of IDs and returns them in order.
+func (d *testDB) ids(query string, args ...interface{}) []int64 {
+	d.t.Helper()
+	rows, err := d.tx.Query(query, args...)
+	if err != nil {
+		d.t.Fatalf("query %q: %v", query, err)
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			d.t.Fatalf("scanning %q: %v", query, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		d.t.Fatalf("query %q: %v", query, err)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func expectIDs(t *testing.T, label string, got []int64, want ...int64) {
+	t.Helper()
+	if want == nil {
+		want = []int64{}
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i] < want[j] })
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s: got %v, want %v", label, got, want)
+	}
+}
+
+func TestUserProblemSetsVariants(t *testing.T) {
+	d := newTestDB(t)
+	python := d.problem("hello-python")
+	golang := d.problem("hello-go")
+	base := d.problem("hello", python.Unique, golang.Unique)
+	set := d.problemSet("hello-set", base)
+
+	// pad the problem sets so problem IDs and problem set IDs do not line up
+	d.problemSet("padding-1")
+	d.problemSet("padding-2")
+	d.problemSet("padding-3")
+
+	course := d.course("cs1")
+	student := d.user("student")
+	teacher := d.user("teacher")
+	d.assignment(course, student, set, false)
+	d.assignment(course, teacher, set, true)
+
+	for _, user := range []*User{student, teacher} {
+		expectIDs(t, user.Name+" problem sets",
+			d.ids(`SELECT problem_set_id FROM user_problem_sets WHERE user_id = ?`, user.ID),
+			set.ID)
+	}
+	for _, user := range []*User{student, teacher} {
+		expectIDs(t, user.Name+" problems",
+			d.ids(`SELECT problem_id FROM user_problems WHERE user_id = ?`, user.ID),
+			base.ID, python.ID, golang.ID)
+	}
+
+	// someone with no assignment sees nothing
+	outsider := d.user("outsider")
+	expectIDs(t, "outsider problem sets", d.ids(`SELECT problem_set_id FROM user_problem_sets WHERE user_id = ?`, outsider.ID))
+	expectIDs(t, "outsider problems", d.ids(`SELECT problem_id FROM user_problems WHERE user_id = ?`, outsider.ID))
+}
+
+func TestAccessViewsSoftDelete(t *testing.T) {
+	d := newTestDB(t)
+	python := d.problem("sum-python")
+	base := d.problem("sum", python.Unique)
+	other := d.problem("product")
+	set := d.problemSet("arith", base, other)
+
+	course := d.course("cs1")
+	student := d.user("student")
+	teacher := d.user("teacher")
+	asst := d.assignment(course, student, set, false)
+	teacherAsst := d.assignment(course, teacher, set, true)
+
+	problems := func(user *User) []int64 {
+		return d.ids(`SELECT problem_id FROM user_problems WHERE user_id = ?`, user.ID)
+	}
+	problemSets := func(user *User) []int64 {
+		return d.ids(`SELECT problem_set_id FROM user_problem_sets WHERE user_id = ?`, user.ID)
+	}
+	assignments := func(user *User) []int64 {
+		return d.ids(`SELECT assignment_id FROM user_assignments WHERE user_id = ?`, user.ID)
+	}
+	otherUsers := func(user *User) []int64 {
+		return d.ids(`SELECT other_user_id FROM user_users WHERE user_id = ?`, user.ID)
+	}
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		if _, err := d.tx.Exec(query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	expectIDs(t, "problems", problems(student), base.ID, python.ID, other.ID)
+	expectIDs(t, "instructor's assignments", assignments(teacher), asst.ID, teacherAsst.ID)
+	expectIDs(t, "instructor's students", otherUsers(teacher), student.ID, teacher.ID)
+
+	// a deleted variant is gone, but the problem is not
+	exec(`UPDATE problems SET deleted_at = ? WHERE id = ?`, d.now, python.ID)
+	expectIDs(t, "problems after deleting a variant", problems(student), base.ID, other.ID)
+
+	// a deleted problem takes its remaining variants with it
+	exec(`UPDATE problems SET deleted_at = NULL WHERE id = ?`, python.ID)
+	exec(`UPDATE problems SET deleted_at = ? WHERE id = ?`, d.now, base.ID)
+	expectIDs(t, "problems after deleting a problem", problems(student), other.ID)
+	exec(`UPDATE problems SET deleted_at = NULL WHERE id = ?`, base.ID)
+
+	// a deleted problem set hides all of its problems
+	exec(`UPDATE problem_sets SET deleted_at = ? WHERE id = ?`, d.now, set.ID)
+	for _, user := range []*User{student, teacher} {
+		expectIDs(t, user.Name+" problem sets after deleting the set", problemSets(user))
+		expectIDs(t, user.Name+" problems after deleting the set", problems(user))
+	}
+	exec(`UPDATE problem_sets SET deleted_at = NULL WHERE id = ?`, set.ID)
+
+	// a deleted course hides everything reached through its assignments
+	exec(`UPDATE courses SET deleted_at = ? WHERE id = ?`, d.now, course.ID)
+	for _, user := range []*User{student, teacher} {
+		expectIDs(t, user.Name+" problem sets after deleting the course", problemSets(user))
+		expectIDs(t, user.Name+" problems after deleting the course", problems(user))
+		expectIDs(t, user.Name+" assignments after deleting the course", assignments(user))
+		expectIDs(t, user.Name+" users after deleting the course", otherUsers(user), user.ID)
+	}
+}
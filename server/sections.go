@@ -0,0 +1,74 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// GetCourseSections handles requests to /v2/courses/:course_id/sections,
+// returning the sections of a course that are known from launches and
+// roster syncs. Only instructors for the course may see them.
+func GetCourseSections(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	course, ok := getInstructorCourse(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+
+	sections := []*CourseSection{}
+	if err := meddler.QueryAll(tx, &sections, `SELECT * FROM course_sections WHERE course_id = ? ORDER BY name`, course.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, sections)
+}
+
+// getSectionFilter reads the section=<...> parameter, which may give the ID,
+// LMS ID, or name of a section of the course. It returns zero if there is no
+// such parameter. The response has been written if it fails.
+func getSectionFilter(w http.ResponseWriter, r *http.Request, tx *sql.Tx, courseID int64) (int64, bool) {
+	if err := r.ParseForm(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "parsing form data: %v", err)
+		return 0, false
+	}
+	value := r.FormValue("section")
+	if value == "" {
+		return 0, true
+	}
+
+	var sections []*CourseSection
+	if err := meddler.QueryAll(tx, &sections, `SELECT * FROM course_sections WHERE course_id = ?`, courseID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return 0, false
+	}
+	var match *CourseSection
+	for _, section := range sections {
+		if strconv.FormatInt(section.ID, 10) == value || section.LtiID == value || section.Name == value {
+			if match != nil && match.ID != section.ID {
+				loggedHTTPErrorf(w, http.StatusBadRequest, "more than one section of course %d matches %q", courseID, value)
+				return 0, false
+			}
+			match = section
+		}
+	}
+	if match == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "course %d has no section %q", courseID, value)
+		return 0, false
+	}
+	return match.ID, true
+}
+
+// sectionWhere extends a WHERE clause on assignments to only include
+// users in the given section. It changes nothing if sectionID is zero.
+func sectionWhere(where string, args []interface{}, sectionID int64) (string, []interface{}) {
+	if sectionID == 0 {
+		return where, args
+	}
+	return where + ` AND user_id IN (SELECT user_id FROM course_members WHERE section_id = ?)`, append(args, sectionID)
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bytes"
-	"compress/gzip"
 	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/tls"
@@ -48,7 +47,7 @@ var Config struct {
 	AcmeURL       string `json:"acmeURL"`       // URL of ACME certificate provider. If omitted, use letsencrypt
 
 	// ta-only required parameters
-	LTISecret     string `json:"ltiSecret"`     // LTI authentication shared secret. Must match that given to Canvas course: `head -c 32 /dev/urandom | base64`
+	LTISecret     string `json:"ltiSecret"`     // LTI shared secret for consumer keys not listed in lti_consumers. Must match that given to Canvas course: `head -c 32 /dev/urandom | base64`
 	SessionSecret string `json:"sessionSecret"` // Random string used to sign cookie sessions: `head -c 32 /dev/urandom | base64`
 
 	// daycare-only required parameters
@@ -63,9 +62,46 @@ var Config struct {
 	AcmeCache       string      `json:"acmeDir"`         // Full path of Acme cache file: default "$CODEGRINDERROOT/acme"
 	SQLite3Path     string      `json:"sqlite3Path"`     // path to the sqlite database file: default "$CODEGRINDERROOT/db/codegrinder.db"
 	SessionsExpire  []time.Time `json:"sessionsExpire"`  // times/dates when sessions should expire (year is ignored)
+
+	// ta-only optional parameters
+	MossUserID    string `json:"mossUserID"`    // MOSS account number used when an instructor does not supply one: default none
+	SMTPAddress   string `json:"smtpAddress"`   // Mail server used to send email: "smtp.foo.com:587". If omitted, no email is sent
+	SMTPUser      string `json:"smtpUser"`      // Login for the mail server: default none
+	SMTPPassword  string `json:"smtpPassword"`  // Password for the mail server: default none
+	EmailFrom     string `json:"emailFrom"`     // From address for outgoing email: "codegrinder@foo.com"
+	WarningDigest bool   `json:"warningDigest"` // Email instructors a nightly digest of struggling students: default false
+	Accounts      bool   `json:"accounts"`      // Allow self-registration with email and password (requires email): default false
+
+	Notifications         bool   `json:"notifications"`         // Email deadline reminders and regrade results to students and a weekly digest to instructors (requires email): default false
+	ReminderHours         int64  `json:"reminderHours"`         // Send deadline reminders this many hours before an assignment is due: default 24
+	NotificationTemplates string `json:"notificationTemplates"` // Directory of *.tmpl files that replace the built-in notification templates: default none
+
+	AnonymousPractice     bool  `json:"anonymousPractice"`     // Let visitors practice public problem sets without signing in: default false
+	AnonymousMinutes      int64 `json:"anonymousMinutes"`      // Daily grading minutes for each anonymous user: default 15
+	AnonymousDailyMinutes int64 `json:"anonymousDailyMinutes"` // Daily grading minutes for all anonymous users together: default 600
+
+	CanvasToken       string `json:"canvasToken"`       // Canvas API access token used to sync rosters of courses with no LTI membership service: default none
+	CanvasAPIDomain   string `json:"canvasAPIDomain"`   // Canvas host the token is for: "canvas.foo.edu"
+	CanvasConsumerKey string `json:"canvasConsumerKey"` // LTI consumer key of that Canvas instance. Only courses launched through this consumer are synced with the token
+
+	// optional asset storage (both roles; ta uploads, daycare downloads)
+	AssetEndpoint  string `json:"assetEndpoint"`  // S3-compatible object store: "https://s3.us-west-2.amazonaws.com" or "http://minio:9000". If omitted, files stay in the database
+	AssetBucket    string `json:"assetBucket"`    // Bucket that holds assets: "codegrinder-assets"
+	AssetRegion    string `json:"assetRegion"`    // Region used to sign requests: default "us-east-1"
+	AssetAccessKey string `json:"assetAccessKey"` // Access key for the object store
+	AssetSecretKey string `json:"assetSecretKey"` // Secret key for the object store
+	AssetThreshold int    `json:"assetThreshold"` // Problem files at least this many bytes are stored as assets: default 1048576
+	AssetCache     string `json:"assetCache"`     // Directory where daycares cache downloaded assets: default "$CODEGRINDERROOT/assets"
+
+	// daycare-only optional parameters
+	WorkspaceCache string `json:"workspaceCache"` // Directory where daycares keep per-student workspaces: default "$CODEGRINDERROOT/workspaces"
 }
 var root string
 
+// db is the TA database. All access is serialized through dbMutex.
+var db *sql.DB
+var dbMutex sync.Mutex
+
 const daycareRegistrationInterval = 10 * time.Second
 
 func main() {
@@ -97,6 +133,13 @@ func main() {
 	Config.ToolDescription = "Programming exercises with grading"
 	Config.AcmeCache = filepath.Join(root, "acme")
 	Config.SQLite3Path = filepath.Join(root, "db", "codegrinder.db")
+	Config.AssetRegion = "us-east-1"
+	Config.AssetThreshold = 1 << 20
+	Config.ReminderHours = 24
+	Config.AnonymousMinutes = 15
+	Config.AnonymousDailyMinutes = 600
+	Config.AssetCache = filepath.Join(root, "assets")
+	Config.WorkspaceCache = filepath.Join(root, "workspaces")
 	Config.SessionsExpire = []time.Time{
 		time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local),
 		time.Date(2020, 7, 1, 0, 0, 0, 0, time.Local),
@@ -126,6 +169,7 @@ func main() {
 	m.Logger(log.New(os.Stderr, "", log.Lshortfile))
 	//m.Use(martini.Logger())
 	m.Use(martini.Recovery())
+	m.Use(negotiateAPIVersion)
 	m.MapTo(r, (*martini.Routes)(nil))
 	m.Action(r.Handle)
 
@@ -180,6 +224,9 @@ func main() {
 		}
 
 		r.Get("/v2/sockets/:problem_type/:action", SocketProblemTypeAction)
+		r.Get("/v2/displays/:token", SocketDisplay)
+		go expireWorkspaces()
+		m.Use(martini.Static(filepath.Join(root, "www", "novnc"), martini.StaticOptions{Prefix: "/novnc", SkipLogging: true}))
 
 		// register with the TA periodically
 		go func() {
@@ -196,8 +243,10 @@ func main() {
 					Hostname:     Config.Hostname,
 					ProblemTypes: Config.ProblemTypes,
 					Capacity:     Config.Capacity,
+					Load:         activeNannies(),
 					Time:         time.Now(),
 					Version:      CurrentVersion.Version,
+					Usage:        takeDaycareUsage(),
 				}
 				reg.Signature = reg.ComputeSignature(Config.DaycareSecret)
 				raw, err := json.MarshalIndent(&reg, "", "    ")
@@ -219,6 +268,7 @@ func main() {
 						log.Printf("attempt took %v", time.Since(start))
 					}
 					status = "failed"
+					restoreDaycareUsage(reg.Usage)
 				} else {
 					body, err := ioutil.ReadAll(res.Body)
 					if err != nil {
@@ -241,6 +291,7 @@ func main() {
 							}
 						}
 						status = "failed"
+						restoreDaycareUsage(reg.Usage)
 					}
 				}
 				time.Sleep(daycareRegistrationInterval)
@@ -260,14 +311,34 @@ func main() {
 		if Config.SQLite3Path == "" {
 			log.Fatalf("cannot run TA role with no sqlite3Path in the config file")
 		}
+		if Config.Accounts && (Config.SMTPAddress == "" || Config.EmailFrom == "") {
+			log.Fatalf("cannot enable accounts with no smtpAddress and emailFrom in the config file")
+		}
+		if Config.Notifications && (Config.SMTPAddress == "" || Config.EmailFrom == "") {
+			log.Fatalf("cannot enable notifications with no smtpAddress and emailFrom in the config file")
+		}
+		if Config.CanvasToken != "" && (Config.CanvasAPIDomain == "" || Config.CanvasConsumerKey == "") {
+			log.Fatalf("cannot use canvasToken with no canvasAPIDomain and canvasConsumerKey in the config file")
+		}
+		if Config.Notifications && Config.ReminderHours < 1 {
+			log.Fatalf("reminderHours must be at least 1")
+		}
 
+		m.Use(serveZstd)
 		m.Use(mgzip.All())
 		m.Use(martini.Static(filepath.Join(root, "www"), martini.StaticOptions{SkipLogging: true}))
-		m.Use(render.Renderer(render.Options{IndentJSON: false}))
+		m.Use(render.Renderer(renderOptions))
 
 		// set up the database
-		db := setupDB(Config.SQLite3Path)
-		var dbMutex sync.Mutex
+		db = setupDB(Config.SQLite3Path)
+		loadProblemTypes()
+		reloadProblemTypesOnHUP()
+		go scheduleWarnings()
+		if Config.Notifications {
+			loadNotificationTemplates()
+			go scheduleNotifications()
+		}
+		scheduleMaintenance()
 
 		// martini service: wrap handler in a transaction
 		withTx := func(c martini.Context, w http.ResponseWriter) {
@@ -275,12 +346,14 @@ func main() {
 			dbMutex.Lock()
 			defer dbMutex.Unlock()
 
-			tx, err := db.Begin()
+			tx, err := beginTx()
 			if err != nil {
 				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error starting transaction: %v", err)
 				return
 			}
 
+			defer endTx()
+
 			// pass it on to the main handler
 			c.Map(tx)
 			c.Next()
@@ -305,19 +378,23 @@ func main() {
 
 		// martini service: to require an active logged-in session
 		auth := func(w http.ResponseWriter, r *http.Request) {
-			_, err := GetSession(r)
+			session, err := GetSession(r)
 			if err != nil {
-				loggedHTTPErrorf(w, http.StatusUnauthorized, "authentication failed: try logging in again")
+				loggedHTTPCodedErrorf(w, http.StatusUnauthorized, ErrorSessionExpired, "authentication failed: try logging in again")
 				log.Printf("%v", err)
 				return
 			}
+			if session.embedded() {
+				loggedHTTPErrorf(w, http.StatusForbidden, "this session can only be used by the embedded editor")
+				return
+			}
 		}
 
 		// martini service: include the current logged-in user (requires withTx)
-		withCurrentUser := func(c martini.Context, w http.ResponseWriter, r *http.Request, tx *sql.Tx) {
+		withCurrentUser := func(c martini.Context, w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params) {
 			session, err := GetSession(r)
 			if err != nil {
-				loggedHTTPErrorf(w, http.StatusUnauthorized, "authentication failed: try logging in again")
+				loggedHTTPCodedErrorf(w, http.StatusUnauthorized, ErrorSessionExpired, "authentication failed: try logging in again")
 				log.Printf("%v", err)
 				return
 			}
@@ -329,15 +406,30 @@ func main() {
 				session.Delete(w)
 
 				if err == sql.ErrNoRows {
-					loggedHTTPErrorf(w, http.StatusUnauthorized, "user %d not found", userID)
+					loggedHTTPCodedErrorf(w, http.StatusUnauthorized, ErrorSessionExpired, "user %d not found", userID)
 					return
 				}
 				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 				return
 			}
 
-			// map the current user to the request context
+			// impersonation sessions can look but not touch
+			if !session.allowsMethod(r.Method) {
+				loggedHTTPCodedErrorf(w, http.StatusForbidden, ErrorReadOnly, "this session is read-only because user %d is viewing as user %d", session.ImpersonatorID, user.ID)
+				return
+			}
+
+			// embedded editor sessions are limited to their problem
+			if session.embedded() {
+				if err := embedAllows(r, params, session); err != nil {
+					loggedHTTPErrorf(w, http.StatusForbidden, "%v", err)
+					return
+				}
+			}
+
+			// map the current user and session to the request context
 			c.Map(user)
+			c.Map(session)
 		}
 
 		// martini service: require logged in user to be an administrator (requires withCurrentUser)
@@ -348,6 +440,14 @@ func main() {
 			}
 		}
 
+		// martini service: require a request made by a script on one of our own pages
+		scriptOnly := func(w http.ResponseWriter, r *http.Request) {
+			if !fromScript(r) {
+				loggedHTTPErrorf(w, http.StatusForbidden, "this request must be JSON with the %s header", requestedWithHeader)
+				return
+			}
+		}
+
 		// martini service: require logged in user to be an author or administrator (requires withCurrentUser)
 		authorOnly := func(w http.ResponseWriter, tx *sql.Tx, currentUser *User) {
 			if currentUser.Admin {
@@ -359,42 +459,49 @@ func main() {
 			}
 		}
 
-		// martini middleware: decompress incoming requests
-		gunzip := func(c martini.Context, w http.ResponseWriter, r *http.Request) {
-			if r.Header.Get("Content-Encoding") != "gzip" {
-				return
-			}
-
-			r.Header.Del("Content-Encoding")
-			body := r.Body
-			var err error
-			r.Body, err = gzip.NewReader(body)
-			defer body.Close()
-			if err != nil {
-				loggedHTTPErrorf(w, http.StatusBadRequest, "gzip error in request: %v", err)
-				return
-			}
-			c.Next()
-		}
-
 		// version
 		r.Get("/v2/version", counter, func(w http.ResponseWriter, render render.Render) {
-			render.JSON(http.StatusOK, &CurrentVersion)
+			version := CurrentVersion
+			version.GrindDownloadURL = "https://" + Config.Hostname + "/v2/grind/download"
+			version.APIVersion = APIVersion
+			version.APIVersionMinimum = APIVersionMinimum
+			render.JSON(http.StatusOK, &version)
 		})
 
+		// grind releases
+		r.Get("/v2/grind/releases", counter, GetGrindReleases)
+		r.Post("/v2/grind/releases", counter, withTx, withCurrentUser, administratorOnly, decompress, binding.Json(GrindRelease{}), PostGrindRelease)
+		r.Get("/v2/grind/releases/:version/:platform", counter, GetGrindRelease)
+		r.Get("/v2/grind/download/:os/:arch", counter, GetGrindDownload)
+
 		// daycare registration
 		r.Get("/v2/daycare_registrations",
 			func(w http.ResponseWriter, render render.Render) {
 				daycareRegistrations.Expire()
 				render.JSON(http.StatusOK, daycareRegistrations.daycares)
 			})
-		r.Post("/v2/daycare_registrations", gunzip, binding.Json(DaycareRegistration{}),
+		r.Post("/v2/daycare_registrations", decompress, binding.Json(DaycareRegistration{}),
 			func(w http.ResponseWriter, reg DaycareRegistration) {
 				daycareRegistrations.Expire()
 				if err := daycareRegistrations.Insert(&reg); err != nil {
 					loggedHTTPErrorf(w, http.StatusBadRequest, "bad daycare registration: %v", err)
 					return
 				}
+				if len(reg.Usage) > 0 {
+					now := time.Now()
+					err := withBackgroundTx(func(tx *sql.Tx) error {
+						for _, elt := range reg.Usage {
+							if err := recordDaycareUsage(tx, elt.AssignmentID, elt.UserID, elt.Seconds, now); err != nil {
+								return err
+							}
+						}
+						return nil
+					})
+					if err != nil {
+						loggedHTTPErrorf(w, http.StatusInternalServerError, "db error recording daycare usage: %v", err)
+						return
+					}
+				}
 			})
 
 		// stats
@@ -413,71 +520,153 @@ func main() {
 		})
 
 		// LTI
-		r.Get("/v2/lti/config.xml", counter, GetConfigXML)
-		//r.Post("/v2/lti/problem_sets", counter, gunzip, binding.Bind(LTIRequest{}), checkOAuthSignature, withTx, LtiProblemSets)
-		r.Post("/v2/lti/problem_sets/:ui/:unique", counter, gunzip, binding.Bind(LTIRequest{}), checkOAuthSignature, withTx, LtiProblemSet)
-		r.Post("/v2/lti/quizzes", counter, gunzip, binding.Bind(LTIRequest{}), checkOAuthSignature, withTx, LtiQuizzes)
+		r.Get("/v2/lti/config.xml", counter, withTx, GetConfigXML)
+		r.Get("/v2/maintenance", counter, withTx, withCurrentUser, administratorOnly, GetMaintenance)
+		r.Get("/v2/lti_consumers", counter, withTx, withCurrentUser, administratorOnly, GetLTIConsumers)
+		r.Post("/v2/lti_consumers", counter, withTx, withCurrentUser, administratorOnly, binding.Json(LTIConsumer{}), PostLTIConsumer)
+		r.Delete("/v2/lti_consumers/:consumer_id", counter, withTx, withCurrentUser, administratorOnly, DeleteLTIConsumer)
+		//r.Post("/v2/lti/problem_sets", counter, decompress, binding.Bind(LTIRequest{}), checkOAuthSignature, withTx, LtiProblemSets)
+		r.Post("/v2/lti/problem_sets/:ui/:unique", counter, decompress, binding.Bind(LTIRequest{}), withTx, checkOAuthSignature, LtiProblemSet)
+		r.Post("/v2/lti/content_items", counter, decompress, binding.Bind(LTIRequest{}), withTx, checkOAuthSignature, LtiContentItems)
+		r.Post("/v2/lti/quizzes", counter, decompress, binding.Bind(LTIRequest{}), withTx, checkOAuthSignature, LtiQuizzes)
 
 		// problem bundles--for problem creation only
-		r.Post("/v2/problem_bundles/unconfirmed", counter, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemBundle{}), PostProblemBundleUnconfirmed)
-		r.Post("/v2/problem_bundles/confirmed", counter, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemBundle{}), PostProblemBundleConfirmed)
-		r.Put("/v2/problem_bundles/:problem_id", counter, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemBundle{}), PutProblemBundle)
+		r.Post("/v2/problem_bundles/unconfirmed", counter, withTx, withCurrentUser, authorOnly, decompress, binding.Json(ProblemBundle{}), PostProblemBundleUnconfirmed)
+		r.Post("/v2/problem_bundles/confirmed", counter, withTx, withCurrentUser, authorOnly, decompress, binding.Json(ProblemBundle{}), PostProblemBundleConfirmed)
+		r.Post("/v2/problem_bundles/convert", counter, withTx, withCurrentUser, authorOnly, PostProblemBundleConvert)
+		r.Put("/v2/problem_bundles/:problem_id", counter, withTx, withCurrentUser, authorOnly, decompress, binding.Json(ProblemBundle{}), PutProblemBundle)
 
 		// problem set bundles--for problem set creation only
-		r.Post("/v2/problem_set_bundles", counter, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemSetBundle{}), PostProblemSetBundle)
-		r.Put("/v2/problem_set_bundles/:problem_set_id", counter, withTx, withCurrentUser, authorOnly, gunzip, binding.Json(ProblemSetBundle{}), PutProblemSetBundle)
+		r.Post("/v2/problem_set_bundles", counter, withTx, withCurrentUser, authorOnly, decompress, binding.Json(ProblemSetBundle{}), PostProblemSetBundle)
+		r.Put("/v2/problem_set_bundles/:problem_set_id", counter, withTx, withCurrentUser, authorOnly, decompress, binding.Json(ProblemSetBundle{}), PutProblemSetBundle)
 
 		// problem types
 		r.Get("/v2/problem_types", counter, auth, withTx, GetProblemTypes)
 		r.Get("/v2/problem_types/:name", counter, auth, withTx, GetProblemType)
+		r.Get("/v2/problem_types/:name/template", counter, withTx, withCurrentUser, authorOnly, GetProblemTypeTemplate)
 
 		// problems
 		r.Get("/v2/problems", counter, withTx, withCurrentUser, GetProblems)
 		r.Get("/v2/problems/:problem_id", counter, withTx, withCurrentUser, GetProblem)
 		r.Get("/v2/problems/:problem_id/steps", counter, withTx, withCurrentUser, GetProblemSteps)
 		r.Get("/v2/problems/:problem_id/steps/:step", counter, withTx, withCurrentUser, GetProblemStep)
+		r.Get("/v2/problems/:problem_id/flaky", counter, withTx, withCurrentUser, authorOnly, GetProblemFlaky)
+		r.Post("/v2/problems/:problem_id/regrade", counter, withTx, withCurrentUser, PostProblemRegrade)
+		r.Get("/v2/problems/:problem_id/regrade", counter, withTx, withCurrentUser, GetProblemRegrade)
 		r.Delete("/v2/problems/:problem_id", counter, withTx, withCurrentUser, administratorOnly, DeleteProblem)
+		r.Post("/v2/problems/:problem_id/restore", counter, withTx, withCurrentUser, administratorOnly, PostProblemRestore)
+		r.Delete("/v2/problems/:problem_id/purge", counter, withTx, withCurrentUser, administratorOnly, PurgeProblem)
 
 		// problem sets
 		r.Get("/v2/problem_sets", counter, withTx, withCurrentUser, GetProblemSets)
 		r.Get("/v2/problem_sets/:problem_set_id", counter, withTx, withCurrentUser, GetProblemSet)
 		r.Get("/v2/problem_sets/:problem_set_id/problems", counter, withTx, withCurrentUser, GetProblemSetProblems)
+		r.Get("/v2/problem_sets/:problem_set_id/analytics", counter, withTx, withCurrentUser, authorOnly, GetProblemSetAnalytics)
+		r.Get("/v2/problem_sets/:problem_set_id/export", counter, withTx, withCurrentUser, authorOnly, GetProblemSetExport)
+		r.Post("/v2/problem_sets/import", counter, withTx, withCurrentUser, administratorOnly, PostProblemSetImport)
 		r.Delete("/v2/problem_sets/:problem_set_id", counter, withTx, withCurrentUser, administratorOnly, DeleteProblemSet)
+		r.Post("/v2/problem_sets/:problem_set_id/restore", counter, withTx, withCurrentUser, administratorOnly, PostProblemSetRestore)
+		r.Delete("/v2/problem_sets/:problem_set_id/purge", counter, withTx, withCurrentUser, administratorOnly, PurgeProblemSet)
+		r.Post("/v2/problem_sets/:problem_set_id/public", counter, withTx, withCurrentUser, authorOnly, PostProblemSetPublic)
+		r.Delete("/v2/problem_sets/:problem_set_id/public", counter, withTx, withCurrentUser, authorOnly, DeleteProblemSetPublic)
+		r.Post("/v2/problem_sets/:problem_set_id/practice", counter, withTx, withCurrentUser, PostProblemSetPractice)
+		r.Get("/v2/public/problem_sets", counter, withTx, withCurrentUser, GetPublicProblemSets)
+		if Config.AnonymousPractice {
+			r.Post("/v2/practice/anonymous", counter, withTx, PostPracticeAnonymous)
+		}
 
 		// courses
 		r.Get("/v2/courses", counter, withTx, withCurrentUser, GetCourses)
 		r.Get("/v2/courses/:course_id", counter, withTx, withCurrentUser, GetCourse)
+		r.Get("/v2/courses/:course_id/grades.csv", counter, withTx, withCurrentUser, GetCourseGrades)
+		r.Get("/v2/courses/:course_id/grades.xlsx", counter, withTx, withCurrentUser, GetCourseGrades)
+		r.Get("/v2/courses/:course_id/roster", counter, withTx, withCurrentUser, GetCourseRoster)
+		r.Get("/v2/courses/:course_id/sections", counter, withTx, withCurrentUser, GetCourseSections)
+		r.Get("/v2/courses/:course_id/roster/sync", counter, withTx, withCurrentUser, GetCourseRosterSync)
+		r.Post("/v2/courses/:course_id/roster/sync", counter, withTx, withCurrentUser, PostCourseRosterSync)
+		r.Get("/v2/courses/:course_id/archive", counter, withTx, withCurrentUser, GetCourseArchive)
+		r.Post("/v2/courses/:course_id/archive", counter, withTx, withCurrentUser, PostCourseArchive)
+		r.Get("/v2/courses/:course_id/limits", counter, withTx, withCurrentUser, administratorOnly, GetCourseLimits)
+		r.Put("/v2/courses/:course_id/limits", counter, withTx, withCurrentUser, administratorOnly, decompress, binding.Json(CourseLimits{}), PutCourseLimits)
 		r.Delete("/v2/courses/:course_id", counter, withTx, withCurrentUser, administratorOnly, DeleteCourse)
+		r.Post("/v2/courses/:course_id/restore", counter, withTx, withCurrentUser, administratorOnly, PostCourseRestore)
+		r.Delete("/v2/courses/:course_id/purge", counter, withTx, withCurrentUser, administratorOnly, PurgeCourse)
 
 		// users
 		r.Get("/v2/users", counter, withTx, withCurrentUser, GetUsers)
 		r.Get("/v2/users/me", counter, withTx, withCurrentUser, GetUserMe)
+		r.Get("/v2/users/me/keys", counter, withTx, withCurrentUser, GetUserMeKeys)
+		r.Post("/v2/users/me/keys", counter, withTx, withCurrentUser, decompress, binding.Json(ClientKey{}), PostUserMeKey)
+		r.Delete("/v2/users/me/keys/:key_id", counter, withTx, withCurrentUser, DeleteUserMeKey)
+		r.Get("/v2/users/me/notifications", counter, withTx, withCurrentUser, GetUserMeNotifications)
+		r.Put("/v2/users/me/notifications", counter, withTx, withCurrentUser, decompress, binding.Json(NotificationPrefs{}), PutUserMeNotifications)
 		r.Get("/v2/users/session", counter, GetUserSession)
+		r.Post("/v2/oauth/device", counter, PostOAuthDevice)
+		r.Post("/v2/oauth/device/approve", counter, scriptOnly, withTx, withCurrentUser, binding.Json(DeviceApproval{}), PostOAuthDeviceApprove)
+		r.Post("/v2/oauth/token", counter, PostOAuthToken)
+		if Config.Accounts {
+			r.Post("/v2/accounts", counter, withTx, decompress, binding.Json(AccountRequest{}), PostAccount)
+			r.Get("/v2/accounts/verify", counter, withTx, GetAccountVerify)
+			r.Post("/v2/accounts/login", counter, withTx, decompress, binding.Json(AccountRequest{}), PostAccountLogin)
+			r.Post("/v2/accounts/reset", counter, withTx, decompress, binding.Json(AccountRequest{}), PostAccountReset)
+			r.Post("/v2/accounts/password", counter, withTx, decompress, binding.Json(AccountRequest{}), PostAccountPassword)
+		}
 		r.Get("/v2/users/:user_id", counter, withTx, withCurrentUser, GetUser)
 		r.Get("/v2/courses/:course_id/users", counter, withTx, withCurrentUser, GetCourseUsers)
 		r.Delete("/v2/users/:user_id", counter, withTx, withCurrentUser, administratorOnly, DeleteUser)
+		r.Get("/v2/users/:user_id/export", counter, withTx, withCurrentUser, administratorOnly, GetUserExport)
+		r.Post("/v2/users/:user_id/impersonate", counter, withTx, withCurrentUser, administratorOnly, PostUserImpersonate)
+		r.Post("/v2/users/:user_id/merge", counter, withTx, withCurrentUser, administratorOnly, PostUserMerge)
+		r.Post("/v2/users/:user_id/anonymize", counter, withTx, withCurrentUser, administratorOnly, PostUserAnonymize)
 
 		// assignments
 		r.Get("/v2/users/:user_id/assignments", counter, withTx, withCurrentUser, GetUserAssignments)
 		r.Get("/v2/courses/:course_id/users/:user_id/assignments", counter, withTx, withCurrentUser, GetCourseUserAssignments)
 		r.Get("/v2/assignments", counter, withTx, withCurrentUser, GetAssignments)
 		r.Get("/v2/assignments/:assignment_id", counter, withTx, withCurrentUser, GetAssignment)
+		r.Get("/v2/assignments/:assignment_id/students", counter, withTx, withCurrentUser, GetAssignmentStudents)
+		r.Get("/v2/assignments/:assignment_id/analytics", counter, withTx, withCurrentUser, GetAssignmentAnalytics)
+		r.Get("/v2/assignments/:assignment_id/similarities", counter, withTx, withCurrentUser, GetAssignmentSimilarities)
+		r.Post("/v2/assignments/:assignment_id/similarities", counter, withTx, withCurrentUser, PostAssignmentSimilarities)
+		r.Get("/v2/assignments/:assignment_id/scores", counter, withTx, withCurrentUser, GetAssignmentScores)
+		r.Put("/v2/assignments/:assignment_id/scores", counter, withTx, withCurrentUser, binding.Json(ScoreOverride{}), PutAssignmentScores)
+		r.Get("/v2/assignments/:assignment_id/grades.csv", counter, withTx, withCurrentUser, GetAssignmentGrades)
+		r.Get("/v2/assignments/:assignment_id/grades.xlsx", counter, withTx, withCurrentUser, GetAssignmentGrades)
+		r.Get("/v2/assignments/:assignment_id/warnings", counter, withTx, withCurrentUser, GetAssignmentWarnings)
+		r.Get("/v2/assignments/:assignment_id/moss", counter, withTx, withCurrentUser, GetAssignmentMoss)
+		r.Post("/v2/assignments/:assignment_id/moss", counter, withTx, withCurrentUser, PostAssignmentMoss)
+		r.Get("/v2/similarities/:similarity_id", counter, withTx, withCurrentUser, GetSimilarity)
 		r.Delete("/v2/assignments/:assignment_id", counter, withTx, withCurrentUser, administratorOnly, DeleteAssignment)
 
+		// audit log
+		r.Get("/v2/audit_log", counter, withTx, withCurrentUser, administratorOnly, GetAuditLog)
+		r.Get("/v2/deleted", counter, withTx, withCurrentUser, administratorOnly, GetDeleted)
+
 		// commits
+		r.Put("/v2/assignments/:assignment_id/problems/:problem_id/variant", counter, withTx, withCurrentUser, decompress, binding.Json(ProblemVariantChoice{}), PutAssignmentProblemVariant)
 		r.Get("/v2/assignments/:assignment_id/problems/:problem_id/commits/last", counter, withTx, withCurrentUser, GetAssignmentProblemCommitLast)
 		r.Get("/v2/assignments/:assignment_id/problems/:problem_id/steps/:step/commits/last", counter, withTx, withCurrentUser, GetAssignmentProblemStepCommitLast)
+		r.Get("/v2/assignments/:assignment_id/problems/:problem_id/commits/history", counter, withTx, withCurrentUser, GetAssignmentProblemCommitHistory)
+		r.Get("/v2/commit_history/:commit_history_id", counter, withTx, withCurrentUser, GetCommitHistory)
+		r.Get("/v2/assignments/:assignment_id/practice", counter, withTx, withCurrentUser, GetAssignmentPractice)
+		r.Get("/v2/commits/:commit_id/diff", counter, withTx, withCurrentUser, GetCommitDiff)
+		r.Get("/v2/commits/:commit_id/artifacts/**", counter, withTx, withCurrentUser, GetCommitArtifact)
+		r.Get("/v2/commits/:commit_id/transcript", counter, withTx, withCurrentUser, GetCommitTranscript)
+		r.Post("/v2/commits/:commit_id/share", counter, withTx, withCurrentUser, PostCommitShare)
+		r.Get("/v2/shares/:token", counter, withTx, GetShare)
+		r.Get("/v2/shares/:token/commit", counter, withTx, GetShareCommit)
 		r.Delete("/v2/commits/:commit_id", counter, withTx, withCurrentUser, administratorOnly, DeleteCommit)
 
 		// commit bundles
-		r.Post("/v2/commit_bundles/unsigned", counter, withTx, withCurrentUser, gunzip, binding.Json(CommitBundle{}), PostCommitBundlesUnsigned)
-		r.Post("/v2/commit_bundles/signed", counter, withTx, withCurrentUser, gunzip, binding.Json(CommitBundle{}), PostCommitBundlesSigned)
+		r.Post("/v2/blobs/missing", counter, withTx, withCurrentUser, decompress, binding.Json(BlobQuery{}), PostBlobsMissing)
+		r.Post("/v2/commit_bundles/unsigned", counter, withTx, withCurrentUser, decompress, binding.Json(CommitBundle{}), PostCommitBundlesUnsigned)
+		r.Post("/v2/commit_bundles/signed", counter, withTx, withCurrentUser, decompress, binding.Json(CommitBundle{}), PostCommitBundlesSigned)
 
 		// quizzes
 		r.Get("/v2/assignments/:assignment_id/quizzes", counter, withTx, withCurrentUser, GetAssignmentQuizzes)
 		r.Get("/v2/quizzes/:quiz_id", counter, withTx, withCurrentUser, GetQuiz)
-		r.Patch("/v2/quizzes/:quiz_id", counter, withTx, withCurrentUser, gunzip, binding.Json(QuizPatch{}), PatchQuiz)
-		r.Post("/v2/quizzes", counter, withTx, withCurrentUser, gunzip, binding.Json(Quiz{}), PostQuiz)
+		r.Patch("/v2/quizzes/:quiz_id", counter, withTx, withCurrentUser, decompress, binding.Json(QuizPatch{}), PatchQuiz)
+		r.Post("/v2/quizzes", counter, withTx, withCurrentUser, decompress, binding.Json(Quiz{}), PostQuiz)
 		r.Delete("/v2/quizzes/:quiz_id", counter, withTx, withCurrentUser, DeleteQuiz)
 
 		// questions
@@ -485,13 +674,13 @@ func main() {
 		r.Get("/v2/assignments/:assignment_id/questions/open", counter, withTx, withCurrentUser, GetAssignmentQuestionsOpen)
 		//r.Get("/v2/assignments/:assignment_id/questions/mock", counter, withTx, withCurrentUser, MockGetAssignmentQuestionsOpen)
 		r.Get("/v2/questions/:question_id", counter, withTx, withCurrentUser, GetQuestion)
-		r.Patch("/v2/questions/:question_id", counter, withTx, withCurrentUser, gunzip, binding.Json(QuestionPatch{}), PatchQuestion)
-		r.Post("/v2/questions", counter, withTx, withCurrentUser, gunzip, binding.Json(Question{}), PostQuestion)
+		r.Patch("/v2/questions/:question_id", counter, withTx, withCurrentUser, decompress, binding.Json(QuestionPatch{}), PatchQuestion)
+		r.Post("/v2/questions", counter, withTx, withCurrentUser, decompress, binding.Json(Question{}), PostQuestion)
 		r.Delete("/v2/questions/:question_id", counter, withTx, withCurrentUser, DeleteQuestion)
 
 		// responses
 		r.Get("/v2/questions/:question_id/responses", counter, withTx, withCurrentUser, GetQuestionResponses)
-		r.Post("/v2/responses", counter, withTx, withCurrentUser, gunzip, binding.Json(Response{}), PostResponse)
+		r.Post("/v2/responses", counter, withTx, withCurrentUser, decompress, binding.Json(Response{}), PostResponse)
 	}
 
 	// set up automatic TLS certificates
@@ -534,7 +723,7 @@ func main() {
 
 		// make sure the request is for the right host name
 		if Config.Hostname != r.Host {
-			http.Error(w, "http request to invalid host", http.StatusBadRequest)
+			writeAPIError(w, http.StatusBadRequest, ErrorBadRequest, "http request to invalid host", "")
 			return
 		}
 		var u url.URL = *r.URL
@@ -598,24 +787,56 @@ func addWhereLike(where string, args []interface{}, label string, value string)
 	return where, args
 }
 
+func addWhereNull(where string, label string) string {
+	if where == "" {
+		where = " WHERE"
+	} else {
+		where += " AND"
+	}
+	return where + fmt.Sprintf(" %s IS NULL", label)
+}
+
 func loggedHTTPDBNotFoundError(w http.ResponseWriter, err error) {
-	msg := "not found"
-	status := http.StatusNotFound
-	if err != sql.ErrNoRows {
-		msg = fmt.Sprintf("db error: %v", err)
-		status = http.StatusInternalServerError
-	}
-	//log.Print(logPrefix(), msg)
-	http.Error(w, msg, status)
+	if err == sql.ErrNoRows {
+		writeAPIError(w, http.StatusNotFound, ErrorNotFound, "not found", "")
+		return
+	}
+	id := writeAPIError(w, http.StatusInternalServerError, ErrorInternal, "db error", err.Error())
+	log.Printf("%s[%s] db error: %v", logPrefix(), id, err)
 }
 
 func loggedHTTPErrorf(w http.ResponseWriter, status int, format string, params ...interface{}) error {
 	msg := fmt.Sprintf(format, params...)
-	log.Print(logPrefix() + msg)
-	http.Error(w, msg, status)
+	id := writeAPIError(w, status, ErrorCodeForStatus(status), msg, "")
+	log.Printf("%s[%s] %s", logPrefix(), id, msg)
 	return fmt.Errorf("%s", msg)
 }
 
+// loggedHTTPCodedErrorf is like loggedHTTPErrorf, but with a specific
+// error code for clients to act on instead of the one for the status.
+func loggedHTTPCodedErrorf(w http.ResponseWriter, status int, code string, format string, params ...interface{}) error {
+	msg := fmt.Sprintf(format, params...)
+	id := writeAPIError(w, status, code, msg, "")
+	log.Printf("%s[%s] %s", logPrefix(), id, msg)
+	return fmt.Errorf("%s", msg)
+}
+
+// writeAPIError sends an error response as an APIError,
+// returning the request ID that identifies it in the log.
+func writeAPIError(w http.ResponseWriter, status int, code, msg, details string) string {
+	apiErr := &APIError{
+		Code:      code,
+		Message:   msg,
+		Details:   details,
+		RequestID: fmt.Sprintf("%016x", rand.Int63()),
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(mustMarshal(apiErr))
+	return apiErr.RequestID
+}
+
 func loggedErrorf(f string, params ...interface{}) error {
 	log.Print(logPrefix() + fmt.Sprintf(f, params...))
 	return fmt.Errorf(f, params...)
@@ -666,12 +887,30 @@ func unBase64(s string) string {
 type daycares struct {
 	sync.Mutex
 	daycares map[string]*DaycareRegistration
+
+	// the daycare last assigned to each (user ID, problem ID) pair
+	sticky map[[2]int64]*stickyDaycare
 }
 
+type stickyDaycare struct {
+	hostname string
+	time     time.Time
+}
+
+const (
+	// forget which daycare a user was on after this long
+	daycareStickyExpiry = 12 * time.Hour
+
+	// do not stick with a daycare that has this many more containers
+	// per unit of capacity than the least loaded eligible daycare
+	daycareStickySlack = 2
+)
+
 var daycareRegistrations daycares
 
 func init() {
 	daycareRegistrations.daycares = make(map[string]*DaycareRegistration)
+	daycareRegistrations.sticky = make(map[[2]int64]*stickyDaycare)
 }
 
 func (m *daycares) Expire() {
@@ -684,6 +923,11 @@ func (m *daycares) Expire() {
 			delete(m.daycares, host)
 		}
 	}
+	for key, elt := range m.sticky {
+		if time.Since(elt.time) > daycareStickyExpiry {
+			delete(m.sticky, key)
+		}
+	}
 }
 
 func (m *daycares) Insert(reg *DaycareRegistration) error {
@@ -719,59 +963,98 @@ func (m *daycares) Insert(reg *DaycareRegistration) error {
 	return nil
 }
 
-func (m *daycares) Assign(problemTypes map[string]bool) (string, error) {
+// Assign picks a daycare that supports all of the given problem types.
+// If userID and problemID are non-zero, it prefers the daycare last
+// assigned to that user and problem so long as it is still registered
+// and not much busier than the others, so interactive work keeps its
+// warm caches and workspaces.
+func (m *daycares) Assign(problemTypes map[string]bool, userID, problemID int64) (string, error) {
 	m.Lock()
 	defer m.Unlock()
 
 	// gather the total weights of all of the eligible daycare hosts
 	totalWeight := 0
+	leastLoad := -1.0
 	for _, elt := range m.daycares {
 		// does this daycare support all required problem types?
-		supported := true
-		for problemType := range problemTypes {
-			n := sort.SearchStrings(elt.ProblemTypes, problemType)
-			if n >= len(elt.ProblemTypes) || elt.ProblemTypes[n] != problemType {
-				supported = false
-				break
-			}
-		}
-		if supported {
+		if elt.supports(problemTypes) {
 			totalWeight += elt.Capacity
+			if load := elt.relativeLoad(); leastLoad < 0 || load < leastLoad {
+				leastLoad = load
+			}
 		}
 	}
 	if totalWeight == 0 {
 		return "", fmt.Errorf("no eligible daycare found")
 	}
 
+	// stick with the last daycare if it is still a good choice
+	key := [2]int64{userID, problemID}
+	if userID > 0 && problemID > 0 {
+		if last := m.sticky[key]; last != nil {
+			elt := m.daycares[last.hostname]
+			if elt != nil && elt.supports(problemTypes) && elt.relativeLoad() <= leastLoad+daycareStickySlack {
+				last.time = time.Now()
+				return last.hostname, nil
+			}
+		}
+	}
+	remember := func(host string) string {
+		if userID > 0 && problemID > 0 {
+			m.sticky[key] = &stickyDaycare{hostname: host, time: time.Now()}
+		}
+		return host
+	}
+
 	// pick a random point in pool of weights
 	point := rand.Intn(totalWeight)
 	skippedWeight := 0
 	for host, elt := range m.daycares {
-		supported := true
-		for problemType := range problemTypes {
-			n := sort.SearchStrings(elt.ProblemTypes, problemType)
-			if n >= len(elt.ProblemTypes) || elt.ProblemTypes[n] != problemType {
-				supported = false
-				break
-			}
-		}
-		if supported {
+		if elt.supports(problemTypes) {
 			skippedWeight += elt.Capacity
 		}
 		if point < skippedWeight {
-			return host, nil
+			return remember(host), nil
 		}
 	}
 	return "", fmt.Errorf("failed to find daycare, please report this error")
 }
 
+func (reg *DaycareRegistration) supports(problemTypes map[string]bool) bool {
+	for problemType := range problemTypes {
+		n := sort.SearchStrings(reg.ProblemTypes, problemType)
+		if n >= len(reg.ProblemTypes) || reg.ProblemTypes[n] != problemType {
+			return false
+		}
+	}
+	return true
+}
+
+// relativeLoad is the number of running containers per unit of capacity.
+func (reg *DaycareRegistration) relativeLoad() float64 {
+	if reg.Capacity <= 0 {
+		return float64(reg.Load)
+	}
+	return float64(reg.Load) / float64(reg.Capacity)
+}
+
 type DaycareRegistration struct {
 	Hostname     string    `json:"hostname"`
 	ProblemTypes []string  `json:"problemTypes"`
 	Capacity     int       `json:"capacity"`
+	Load         int       `json:"load"`
 	Time         time.Time `json:"time"`
 	Version      string    `json:"version,omitempty"`
 	Signature    string    `json:"signature,omitempty"`
+
+	// time spent on actions since the last registration
+	Usage []*DaycareUsage `json:"usage,omitempty"`
+}
+
+type DaycareUsage struct {
+	AssignmentID int64   `json:"assignmentID"`
+	UserID       int64   `json:"userID"`
+	Seconds      float64 `json:"seconds"`
 }
 
 func (reg *DaycareRegistration) ComputeSignature(secret string) string {
@@ -784,8 +1067,12 @@ func (reg *DaycareRegistration) ComputeSignature(secret string) string {
 		v.Add(fmt.Sprintf("problemType-%d", n), elt)
 	}
 	v.Add("capacity", strconv.Itoa(reg.Capacity))
+	v.Add("load", strconv.Itoa(reg.Load))
 	v.Add("time", reg.Time.Round(time.Second).UTC().Format(time.RFC3339))
 	v.Add("version", reg.Version)
+	for n, elt := range reg.Usage {
+		v.Add(fmt.Sprintf("usage-%d", n), fmt.Sprintf("%d:%d:%g", elt.AssignmentID, elt.UserID, elt.Seconds))
+	}
 
 	// compute signature
 	mac := hmac.New(sha256.New, []byte(secret))
@@ -13,8 +13,26 @@ type CookieSession struct {
 	ExpiresAt time.Time
 	UserID    int64
 	path      string
+
+	// set when an administrator is viewing the site as this user;
+	// such sessions are read-only
+	ImpersonatorID int64
+
+	// set for the embedded editor, which runs in a frame inside an LMS page.
+	// Such sessions use their own cookie and can only reach the endpoints
+	// the editor needs for this assignment and problem; see embedAllows.
+	EmbedAssignmentID int64
+	EmbedProblemID    int64
 }
 
+// embedCookieName is the cookie for embedded editor sessions. It must be sent
+// in frames from other sites, so it is kept apart from the normal session
+// cookie, which is not.
+const embedCookieName = "codegrinder-embed"
+
+// impersonationTimeout is the longest an impersonation session lasts.
+const impersonationTimeout = time.Hour
+
 func NewSession(id int64) *CookieSession {
 	now := time.Now()
 	expires := now
@@ -39,22 +57,64 @@ func NewSession(id int64) *CookieSession {
 	}
 }
 
+// NewImpersonationSession creates a read-only session as a user
+// on behalf of an administrator.
+func NewImpersonationSession(userID, adminID int64) *CookieSession {
+	session := NewSession(userID)
+	session.ImpersonatorID = adminID
+	if limit := time.Now().Add(impersonationTimeout); limit.Before(session.ExpiresAt) {
+		session.ExpiresAt = limit
+	}
+	return session
+}
+
+// NewEmbedSession creates a session for the embedded editor
+// that is limited to one problem in one assignment.
+func NewEmbedSession(userID, assignmentID, problemID int64) *CookieSession {
+	session := NewSession(userID)
+	session.EmbedAssignmentID = assignmentID
+	session.EmbedProblemID = problemID
+	return session
+}
+
+// allowsMethod reports whether the session can be used for a request
+// with the given method. Impersonation sessions can look but not touch.
+func (session *CookieSession) allowsMethod(method string) bool {
+	return session.ImpersonatorID == 0 || method == "GET" || method == "HEAD"
+}
+
+// embedded reports whether this is an embedded editor session.
+func (session *CookieSession) embedded() bool {
+	return session.EmbedProblemID > 0
+}
+
+// GetSession finds the session for a request, preferring the normal
+// session cookie over an embedded editor cookie.
 func GetSession(r *http.Request) (*CookieSession, error) {
 	now := time.Now()
 
-	cookie, err := r.Cookie(CookieName)
+	name := CookieName
+	cookie, err := r.Cookie(name)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read session cookie")
+		name = embedCookieName
+		if cookie, err = r.Cookie(name); err != nil {
+			return nil, fmt.Errorf("unable to read session cookie")
+		}
 	}
 
 	// decode and verify signature
 	session := new(CookieSession)
 	secure := securecookie.New([]byte(Config.SessionSecret), nil)
 	secure.MaxAge(0)
-	if err = secure.Decode(CookieName, cookie.Value, session); err != nil {
+	if err = secure.Decode(name, cookie.Value, session); err != nil {
 		return nil, fmt.Errorf("unable to decode session cookie")
 	}
 
+	// an embedded session must come from its own cookie
+	if session.embedded() != (name == embedCookieName) {
+		return nil, fmt.Errorf("session cookie does not match its type")
+	}
+
 	// check expiration
 	if session.ExpiresAt.Before(now) {
 		return nil, fmt.Errorf("session is expired; must log in again to continue")
@@ -68,32 +128,60 @@ func GetSession(r *http.Request) (*CookieSession, error) {
 	return session, nil
 }
 
-func (session *CookieSession) Save(w http.ResponseWriter) string {
-	// encode and sign
+// cookieName is the name of the cookie that holds this session.
+func (session *CookieSession) cookieName() string {
+	if session.embedded() {
+		return embedCookieName
+	}
+	return CookieName
+}
+
+// encode signs the session for its cookie.
+func (session *CookieSession) encode() (string, error) {
 	secure := securecookie.New([]byte(Config.SessionSecret), nil)
 	secure.MaxAge(0)
-	encoded, err := secure.Encode(CookieName, session)
+	return secure.Encode(session.cookieName(), session)
+}
+
+// Cookie returns the session as a cookie header value without setting it
+// in the response.
+func (session *CookieSession) Cookie(w http.ResponseWriter) string {
+	encoded, err := session.encode()
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "creating session: %v", err)
+		return ""
+	}
+	return fmt.Sprintf("%s=%s", session.cookieName(), encoded)
+}
+
+func (session *CookieSession) Save(w http.ResponseWriter) string {
+	// encode and sign
+	name := session.cookieName()
+	encoded, err := session.encode()
 	if err != nil {
 		loggedHTTPErrorf(w, http.StatusInternalServerError, "creating session: %v", err)
 		return ""
 	}
 
 	cookie := &http.Cookie{
-		Name:    CookieName,
+		Name:    name,
 		Value:   encoded,
 		Path:    session.path,
 		Expires: session.ExpiresAt,
 		MaxAge:  int(time.Until(session.ExpiresAt).Seconds()),
 		Secure:  true,
 	}
+	if session.embedded() {
+		cookie.SameSite = http.SameSiteNoneMode
+	}
 	http.SetCookie(w, cookie)
-	return fmt.Sprintf("%s=%s", CookieName, encoded)
+	return fmt.Sprintf("%s=%s", name, encoded)
 }
 
 func (session *CookieSession) Delete(w http.ResponseWriter) {
 	epoch := time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
 	cookie := &http.Cookie{
-		Name:    CookieName,
+		Name:    session.cookieName(),
 		Value:   "deleted",
 		Path:    session.path,
 		Expires: epoch,
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// useTestSessions sets up the configuration needed to sign session cookies.
+func useTestSessions(t *testing.T) {
+	t.Helper()
+	secret, expire := Config.SessionSecret, Config.SessionsExpire
+	Config.SessionSecret = "0123456789abcdef0123456789abcdef"
+	Config.SessionsExpire = []time.Time{time.Now().Add(30 * 24 * time.Hour)}
+	t.Cleanup(func() {
+		Config.SessionSecret, Config.SessionsExpire = secret, expire
+	})
+}
+
+// sessionRequest is a request carrying the cookies set by saving a session.
+func sessionRequest(t *testing.T, method, path string, session *CookieSession) *http.Request {
+	t.Helper()
+	w := httptest.NewRecorder()
+	if session.Save(w) == "" {
+		t.Fatalf("saving session: %s", w.Body.String())
+	}
+	r := httptest.NewRequest(method, path, nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestImpersonationIsReadOnly(t *testing.T) {
+	useTestSessions(t)
+
+	r := sessionRequest(t, "GET", "/v2/users/me", NewImpersonationSession(5, 1))
+	session, err := GetSession(r)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if session.UserID != 5 || session.ImpersonatorID != 1 {
+		t.Fatalf("got user %d impersonated by %d, want 5 by 1", session.UserID, session.ImpersonatorID)
+	}
+	if limit := time.Now().Add(impersonationTimeout); session.ExpiresAt.After(limit) {
+		t.Errorf("impersonation session expires at %v, after %v", session.ExpiresAt, limit)
+	}
+
+	for method, want := range map[string]bool{
+		"GET":    true,
+		"HEAD":   true,
+		"POST":   false,
+		"PUT":    false,
+		"PATCH":  false,
+		"DELETE": false,
+	} {
+		if got := session.allowsMethod(method); got != want {
+			t.Errorf("impersonation session allows %s: got %v, want %v", method, got, want)
+		}
+		if !NewSession(5).allowsMethod(method) {
+			t.Errorf("normal session does not allow %s", method)
+		}
+	}
+}
+
+func TestImpersonationCookieCannotBeAltered(t *testing.T) {
+	useTestSessions(t)
+
+	r := sessionRequest(t, "GET", "/v2/users/me", NewImpersonationSession(5, 1))
+	cookie, err := r.Cookie(CookieName)
+	if err != nil {
+		t.Fatalf("impersonation session was not saved in %s: %v", CookieName, err)
+	}
+
+	// the impersonator cannot be edited out of the cookie without breaking its signature
+	altered := httptest.NewRequest("POST", "/v2/users/me", nil)
+	tampered := []byte(cookie.Value)
+	tampered[len(tampered)/2] ^= 1
+	altered.AddCookie(&http.Cookie{Name: CookieName, Value: string(tampered)})
+	if _, err := GetSession(altered); err == nil {
+		t.Errorf("GetSession accepted an altered cookie")
+	}
+}
+
+func TestImpersonationCookieIsNotSet(t *testing.T) {
+	useTestSessions(t)
+
+	w := httptest.NewRecorder()
+	value := NewImpersonationSession(5, 1).Cookie(w)
+	if value == "" {
+		t.Fatalf("creating cookie: %s", w.Body.String())
+	}
+	if set := w.Header().Get("Set-Cookie"); set != "" {
+		t.Errorf("impersonation replaced the administrator's cookie: %s", set)
+	}
+
+	r := httptest.NewRequest("GET", "/v2/users/me", nil)
+	r.Header.Set("Cookie", value)
+	session, err := GetSession(r)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if session.UserID != 5 || session.ImpersonatorID != 1 {
+		t.Errorf("got user %d impersonated by %d, want 5 by 1", session.UserID, session.ImpersonatorID)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// PostCommitShare handles requests to /v2/commits/:commit_id/share,
+// making a link that shows the latest saved version of the commit to
+// anyone who has it, without signing in.
+//
+// If parameter hours=<...> present, the link expires after that many hours
+// instead of the default of one week, up to 30 days.
+func PostCommitShare(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	now := time.Now()
+
+	commitID, err := parseID(w, "commit_id", params["commit_id"])
+	if err != nil {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "parsing form data: %v", err)
+		return
+	}
+	hours := int64(ShareDefaultHours)
+	if s := r.FormValue("hours"); s != "" {
+		if hours, err = strconv.ParseInt(s, 10, 64); err != nil || hours < 1 || hours > ShareMaxHours {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "hours must be a number from 1 to %d", ShareMaxHours)
+			return
+		}
+	}
+
+	commit, err := getCommitForUser(tx, currentUser, commitID)
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	// the most recent version is the one saved with the commit's current files
+	share := &CommitShare{
+		CommitID:  commit.ID,
+		UserID:    currentUser.ID,
+		ExpiresAt: now.Add(time.Duration(hours) * time.Hour),
+		CreatedAt: now,
+	}
+	if err := tx.QueryRow(`SELECT id FROM commit_history WHERE assignment_id = ? AND problem_id = ? AND step = ? ORDER BY id DESC LIMIT 1`,
+		commit.AssignmentID, commit.ProblemID, commit.Step).Scan(&share.HistoryID); err == sql.ErrNoRows {
+		loggedHTTPErrorf(w, http.StatusNotFound, "commit %d has no saved version to share", commit.ID)
+		return
+	} else if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error generating token: %v", err)
+		return
+	}
+	share.Token = base64.RawURLEncoding.EncodeToString(raw)
+
+	// clear out old links while we are here
+	if _, err := tx.Exec(`DELETE FROM commit_shares WHERE expires_at < ?`, now.UTC()); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if err := meddler.Insert(tx, "commit_shares", share); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("user %d (%s) shared commit %d until %s", currentUser.ID, currentUser.Email, commit.ID, share.ExpiresAt.Format(time.RFC3339))
+
+	share.URL = fmt.Sprintf("https://%s/v2/shares/%s", Config.Hostname, url.PathEscape(share.Token))
+	render.JSON(http.StatusOK, share)
+}
+
+// getShare loads the commit version a share link points to.
+func getShare(w http.ResponseWriter, tx *sql.Tx, token string) (*Commit, *Problem, *CommitShare, bool) {
+	share := new(CommitShare)
+	if err := meddler.QueryRow(tx, share, `SELECT * FROM commit_shares WHERE token = ?`, token); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return nil, nil, nil, false
+	}
+	if time.Now().After(share.ExpiresAt) {
+		loggedHTTPErrorf(w, http.StatusNotFound, "this link expired on %s", share.ExpiresAt.Format("Jan 2, 2006"))
+		return nil, nil, nil, false
+	}
+	commit := new(Commit)
+	if err := meddler.Load(tx, "commit_history", commit, share.HistoryID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return nil, nil, nil, false
+	}
+	problem := new(Problem)
+	if err := meddler.Load(tx, "problems", problem, commit.ProblemID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return nil, nil, nil, false
+	}
+
+	// links are for getting help, so they should not turn up in searches
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	w.Header().Set("Cache-Control", "private, no-store")
+	return commit, problem, share, true
+}
+
+// GetShareCommit handles requests to /v2/shares/:token/commit,
+// returning the shared version of a commit. No session is needed.
+func GetShareCommit(w http.ResponseWriter, tx *sql.Tx, params martini.Params, render render.Render) {
+	commit, _, _, ok := getShare(w, tx, params["token"])
+	if !ok {
+		return
+	}
+
+	// do not reveal which student or assignment this is
+	commit.ID = 0
+	commit.AssignmentID = 0
+	commit.Client = nil
+	commit.ClientKeyID = 0
+	commit.ActiveTime = 0
+	render.JSON(http.StatusOK, commit)
+}
+
+// GetShare handles requests to /v2/shares/:token,
+// showing the shared version of a commit as a web page. No session is needed.
+func GetShare(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	commit, problem, share, ok := getShare(w, tx, params["token"])
+	if !ok {
+		return
+	}
+
+	var page bytes.Buffer
+	fmt.Fprintf(&page, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>%s step %d</title></head><body>\n",
+		html.EscapeString(problem.Unique), commit.Step)
+	fmt.Fprintf(&page, "<h1>%s, step %d</h1>\n", html.EscapeString(problem.Note), commit.Step)
+	fmt.Fprintf(&page, "<p>Saved %s. This read-only link expires %s.</p>\n",
+		commit.UpdatedAt.Format("Jan 2, 2006 15:04 MST"), share.ExpiresAt.Format("Jan 2, 2006 15:04 MST"))
+	if commit.Message != "" {
+		fmt.Fprintf(&page, "<p>Student note: %s</p>\n", html.EscapeString(commit.Message))
+	}
+	if commit.ReportCard != nil {
+		result := "failed"
+		if commit.ReportCard.Passed {
+			result = "passed"
+		}
+		fmt.Fprintf(&page, "<h2>Report card: %s</h2>\n<p>%s</p>\n", result, html.EscapeString(commit.ReportCard.Note))
+	} else {
+		fmt.Fprintf(&page, "<h2>Not graded</h2>\n")
+	}
+	if len(commit.Transcript) > 0 || commit.ReportCard != nil {
+		var transcript bytes.Buffer
+		if err := commit.DumpTranscript(&transcript); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "error writing transcript: %v", err)
+			return
+		}
+		fmt.Fprintf(&page, "<h2>Transcript</h2>\n<pre>%s</pre>\n",
+			html.EscapeString(strings.Replace(transcript.String(), "\r\n", "\n", -1)))
+	}
+
+	var names []string
+	for name := range commit.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		contents := commit.Files[name]
+		if utf8.Valid(contents) {
+			fmt.Fprintf(&page, "<h2>File: <code>%s</code></h2>\n<pre><code>%s</code></pre>\n",
+				html.EscapeString(name), html.EscapeString(string(contents)))
+		} else {
+			fmt.Fprintf(&page, "<h2>File: <code>%s</code> (binary contents)</h2>\n", html.EscapeString(name))
+		}
+	}
+	fmt.Fprintf(&page, "</body></html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	w.Write(page.Bytes())
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+	"unicode"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+const (
+	// winnowing parameters: fingerprints are chosen from k-grams of tokens,
+	// one per window of w consecutive k-grams
+	similarityK = 12
+	similarityW = 6
+)
+
+// similarityKeywords are kept as-is when tokenizing; all other identifiers
+// are normalized so that renaming variables does not hide copying.
+var similarityKeywords = map[string]bool{
+	"if": true, "else": true, "elif": true, "for": true, "while": true, "do": true,
+	"switch": true, "case": true, "default": true, "break": true, "continue": true,
+	"return": true, "def": true, "class": true, "func": true, "fn": true, "struct": true,
+	"try": true, "except": true, "catch": true, "finally": true, "raise": true, "throw": true,
+	"import": true, "from": true, "package": true, "in": true, "not": true, "and": true, "or": true,
+	"let": true, "var": true, "const": true, "new": true, "delete": true, "match": true,
+	"int": true, "float": true, "double": true, "char": true, "bool": true, "void": true,
+	"string": true, "true": true, "false": true, "none": true, "nil": true, "null": true,
+}
+
+func similarityJobName(courseID int64, ltiID string) string {
+	return fmt.Sprintf("similarity-%d-%s", courseID, ltiID)
+}
+
+// isCourseInstructor returns true if the user is an administrator or
+// an instructor in the given course.
+func isCourseInstructor(tx *sql.Tx, currentUser *User, courseID int64) (bool, error) {
+	if currentUser.Admin {
+		return true, nil
+	}
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM assignments WHERE user_id = ? AND course_id = ? AND instructor`, currentUser.ID, courseID).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// getInstructorAssignment loads an assignment and checks that the current user
+// is an instructor for its course. The response has been written if it fails.
+func getInstructorAssignment(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) (*Assignment, bool) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return nil, false
+	}
+	assignment := new(Assignment)
+	if err := meddler.Load(tx, "assignments", assignment, assignmentID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return nil, false
+	}
+	ok, err := isCourseInstructor(tx, currentUser, assignment.CourseID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return nil, false
+	}
+	if !ok {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "user %d (%s) is not an instructor for this course", currentUser.ID, currentUser.Name)
+		return nil, false
+	}
+	return assignment, true
+}
+
+// getInstructorCourse loads a course and checks that the current user
+// is an instructor for it. The response has been written if it fails.
+func getInstructorCourse(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) (*Course, bool) {
+	courseID, err := parseID(w, "course_id", params["course_id"])
+	if err != nil {
+		return nil, false
+	}
+	course := new(Course)
+	if err := meddler.Load(tx, "courses", course, courseID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return nil, false
+	}
+	ok, err := isCourseInstructor(tx, currentUser, courseID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return nil, false
+	}
+	if !ok {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "user %d (%s) is not an instructor for this course", currentUser.ID, currentUser.Name)
+		return nil, false
+	}
+	return course, true
+}
+
+// PostAssignmentSimilarities handles requests to /v2/assignments/:assignment_id/similarities,
+// starting a background job that compares the final submissions of every
+// student with this assignment. Any earlier results are replaced.
+func PostAssignmentSimilarities(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignment, ok := getInstructorAssignment(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+
+	courseID, ltiID := assignment.CourseID, assignment.LtiID
+	job, err := jobs.Start(similarityJobName(courseID, ltiID), func() error {
+		return runSimilarity(courseID, ltiID)
+	})
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusConflict, "%v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, job)
+}
+
+// GetAssignmentSimilarities handles requests to /v2/assignments/:assignment_id/similarities,
+// returning the pairwise similarity scores for this assignment, highest first.
+//
+// If parameter min=<...> present, only pairs with at least that score are returned.
+func GetAssignmentSimilarities(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignment, ok := getInstructorAssignment(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "parsing form data: %v", err)
+		return
+	}
+	min := 0.0
+	if s := r.FormValue("min"); s != "" {
+		var err error
+		if min, err = strconv.ParseFloat(s, 64); err != nil {
+			loggedHTTPErrorf(w, http.StatusBadRequest, "error parsing min value %q: %v", s, err)
+			return
+		}
+	}
+
+	similarities := []*Similarity{}
+	if err := meddler.QueryAll(tx, &similarities, `SELECT * FROM similarities WHERE course_id = ? AND lti_id = ? AND score >= ? ORDER BY score DESC`,
+		assignment.CourseID, assignment.LtiID, min); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	if job := jobs.Get(similarityJobName(assignment.CourseID, assignment.LtiID)); job != nil && job.FinishedAt == nil {
+		w.Header().Set("X-Job-Status", "running")
+	}
+
+	render.JSON(http.StatusOK, similarities)
+}
+
+// GetSimilarity handles requests to /v2/similarities/:similarity_id,
+// returning both users and submissions for side-by-side review.
+func GetSimilarity(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	similarityID, err := parseID(w, "similarity_id", params["similarity_id"])
+	if err != nil {
+		return
+	}
+	similarity := new(Similarity)
+	if err := meddler.Load(tx, "similarities", similarity, similarityID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	ok, err := isCourseInstructor(tx, currentUser, similarity.CourseID)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if !ok {
+		loggedHTTPErrorf(w, http.StatusUnauthorized, "user %d (%s) is not an instructor for this course", currentUser.ID, currentUser.Name)
+		return
+	}
+
+	result := &SimilarityComparison{
+		Similarity: similarity,
+		UserA:      new(User),
+		UserB:      new(User),
+		CommitA:    new(Commit),
+		CommitB:    new(Commit),
+	}
+	if err := meddler.Load(tx, "commits", result.CommitA, similarity.CommitAID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if err := meddler.Load(tx, "commits", result.CommitB, similarity.CommitBID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if err := meddler.QueryRow(tx, result.UserA, `SELECT users.* FROM users JOIN assignments ON users.id = assignments.user_id WHERE assignments.id = ?`, similarity.AssignmentAID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if err := meddler.QueryRow(tx, result.UserB, `SELECT users.* FROM users JOIN assignments ON users.id = assignments.user_id WHERE assignments.id = ?`, similarity.AssignmentBID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	result.CommitA.Transcript = nil
+	result.CommitB.Transcript = nil
+
+	render.JSON(http.StatusOK, result)
+}
+
+type similaritySubmission struct {
+	commit       *Commit
+	fingerprints map[uint64]bool
+}
+
+// runSimilarity computes similarity scores for all students with the given
+// assignment and replaces any earlier results.
+func runSimilarity(courseID int64, ltiID string) error {
+	// gather the submissions; starter code is excluded from comparisons
+	byProblem := make(map[int64][]*similaritySubmission)
+	starters := make(map[int64]map[uint64]bool)
+	err := withBackgroundTx(func(tx *sql.Tx) error {
+		final, err := getFinalSubmissions(tx, courseID, ltiID)
+		if err != nil {
+			return err
+		}
+		for problemID, commits := range final {
+			for _, commit := range commits {
+				byProblem[problemID] = append(byProblem[problemID], &similaritySubmission{commit: commit})
+			}
+			starter, err := getStarterFiles(tx, problemID)
+			if err != nil {
+				return err
+			}
+			starters[problemID] = winnow(starter)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// compare every pair of submissions for each problem
+	now := time.Now()
+	var results []*Similarity
+	for problemID, subs := range byProblem {
+		for _, sub := range subs {
+			sub.fingerprints = winnow(sub.commit.Files)
+			for fp := range starters[problemID] {
+				delete(sub.fingerprints, fp)
+			}
+		}
+		for i, a := range subs {
+			for _, b := range subs[i+1:] {
+				score := similarityScore(a.fingerprints, b.fingerprints)
+				if score == 0.0 {
+					continue
+				}
+				results = append(results, &Similarity{
+					CourseID:      courseID,
+					LtiID:         ltiID,
+					ProblemID:     problemID,
+					AssignmentAID: a.commit.AssignmentID,
+					AssignmentBID: b.commit.AssignmentID,
+					CommitAID:     a.commit.ID,
+					CommitBID:     b.commit.ID,
+					Score:         score,
+					CreatedAt:     now,
+				})
+			}
+		}
+	}
+
+	// replace the old results
+	return withBackgroundTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM similarities WHERE course_id = ? AND lti_id = ?`, courseID, ltiID); err != nil {
+			return err
+		}
+		for _, elt := range results {
+			if err := meddler.Insert(tx, "similarities", elt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// getFinalSubmissions gathers the latest commit from each student with the
+// given assignment for each problem, grouped by problem ID.
+func getFinalSubmissions(tx *sql.Tx, courseID int64, ltiID string) (map[int64][]*Commit, error) {
+	var commits []*Commit
+	if err := meddler.QueryAll(tx, &commits, `SELECT commits.* FROM commits `+
+		`JOIN assignments ON commits.assignment_id = assignments.id `+
+		`WHERE assignments.course_id = ? AND assignments.lti_id = ? AND NOT assignments.instructor `+
+		`ORDER BY commits.assignment_id, commits.problem_id, commits.step`, courseID, ltiID); err != nil {
+		return nil, err
+	}
+	final := make(map[int64][]*Commit)
+	for i, commit := range commits {
+		if i+1 < len(commits) && commits[i+1].AssignmentID == commit.AssignmentID && commits[i+1].ProblemID == commit.ProblemID {
+			// a later step exists
+			continue
+		}
+		final[commit.ProblemID] = append(final[commit.ProblemID], commit)
+	}
+	return final, nil
+}
+
+// getStarterFiles gathers the starter versions of the student files from
+// every step of a problem, named <step>/<file>.
+func getStarterFiles(tx *sql.Tx, problemID int64) (map[string][]byte, error) {
+	var steps []*ProblemStep
+	if err := meddler.QueryAll(tx, &steps, `SELECT * FROM problem_steps WHERE problem_id = ?`, problemID); err != nil {
+		return nil, err
+	}
+	starter := make(map[string][]byte)
+	for _, step := range steps {
+		for name := range step.Whitelist {
+			if contents, exists := step.Files[name]; exists {
+				starter[fmt.Sprintf("%d/%s", step.Step, name)] = contents
+			}
+		}
+	}
+	return starter, nil
+}
+
+// similarityScore is the fraction of the smaller set of fingerprints that
+// is also found in the larger set.
+func similarityScore(a, b map[uint64]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0.0
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	shared := 0
+	for fp := range a {
+		if b[fp] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a))
+}
+
+// winnow computes the winnowing fingerprints of a set of files.
+func winnow(files map[string][]byte) map[uint64]bool {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var tokens []string
+	for _, name := range names {
+		tokens = append(tokens, similarityTokens(files[name])...)
+	}
+
+	// hash every k-gram
+	var hashes []uint64
+	for i := 0; i+similarityK <= len(tokens); i++ {
+		h := fnv.New64a()
+		for _, tok := range tokens[i : i+similarityK] {
+			h.Write([]byte(tok))
+			h.Write([]byte{0})
+		}
+		hashes = append(hashes, h.Sum64())
+	}
+
+	// pick the minimum hash in each window
+	fingerprints := make(map[uint64]bool)
+	if len(hashes) > 0 && len(hashes) < similarityW {
+		for _, h := range hashes {
+			fingerprints[h] = true
+		}
+	}
+	for i := 0; i+similarityW <= len(hashes); i++ {
+		min := hashes[i]
+		for _, h := range hashes[i+1 : i+similarityW] {
+			if h <= min {
+				min = h
+			}
+		}
+		fingerprints[min] = true
+	}
+	return fingerprints
+}
+
+// similarityTokens splits source code into tokens, dropping whitespace and
+// comments and normalizing identifiers and numbers.
+func similarityTokens(src []byte) []string {
+	var tokens []string
+	s := []rune(string(src))
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case unicode.IsSpace(ch):
+			i++
+		case ch == '#' || (ch == '/' && i+1 < len(s) && s[i+1] == '/'):
+			// line comment
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case ch == '/' && i+1 < len(s) && s[i+1] == '*':
+			// block comment
+			i += 2
+			for i+1 < len(s) && !(s[i] == '*' && s[i+1] == '/') {
+				i++
+			}
+			i += 2
+		case unicode.IsLetter(ch) || ch == '_':
+			start := i
+			for i < len(s) && (unicode.IsLetter(s[i]) || unicode.IsDigit(s[i]) || s[i] == '_') {
+				i++
+			}
+			word := string(s[start:i])
+			if similarityKeywords[word] {
+				tokens = append(tokens, word)
+			} else {
+				tokens = append(tokens, "v")
+			}
+		case unicode.IsDigit(ch):
+			for i < len(s) && (unicode.IsDigit(s[i]) || s[i] == '.' || unicode.IsLetter(s[i])) {
+				i++
+			}
+			tokens = append(tokens, "0")
+		default:
+			tokens = append(tokens, string(ch))
+			i++
+		}
+	}
+	return tokens
+}
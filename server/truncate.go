@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// Each output stream (stdout and stderr) of an action is capped at
+// maxOutput kilobytes. Once a stream passes half of its cap, the rest
+// of its output is neither recorded nor sent to the client as it
+// arrives; only the last half of the cap is kept, and it is sent along
+// with a marker noting how much was discarded when the action ends.
+// The total number of bytes discarded is recorded in the report card.
+
+const outputDefaultLimit = 64
+
+type streamLimiter struct {
+	stream    string
+	headLimit int
+	tailLimit int
+	head      int
+	tail      []byte
+	discarded int64
+}
+
+func newStreamLimiter(stream string, limitKB int64) *streamLimiter {
+	if limitKB <= 0 {
+		limitKB = outputDefaultLimit
+	}
+	limit := int(limitKB) * 1024
+	return &streamLimiter{
+		stream:    stream,
+		headLimit: limit / 2,
+		tailLimit: limit - limit/2,
+	}
+}
+
+// admit returns the part of the data that should be recorded and sent now,
+// holding on to the rest as the tail of the stream.
+func (s *streamLimiter) admit(data []byte) []byte {
+	if room := s.headLimit - s.head; room > 0 {
+		if len(data) <= room {
+			s.head += len(data)
+			return data
+		}
+		s.head = s.headLimit
+		s.keep(data[room:])
+		return data[:room]
+	}
+	s.keep(data)
+	return nil
+}
+
+func (s *streamLimiter) keep(data []byte) {
+	s.tail = append(s.tail, data...)
+	if extra := len(s.tail) - s.tailLimit; extra > 0 {
+		s.discarded += int64(extra)
+		s.tail = append(s.tail[:0:0], s.tail[extra:]...)
+	}
+}
+
+// flush returns the events that finish a truncated stream: a marker
+// followed by the tail of the output.
+func (s *streamLimiter) flush() []*EventMessage {
+	if len(s.tail) == 0 {
+		return nil
+	}
+	now := time.Now()
+	if s.discarded == 0 {
+		return []*EventMessage{{Time: now, Event: s.stream, StreamData: s.tail}}
+	}
+	marker := fmt.Sprintf("\r\n… %d bytes of %s discarded …\r\n", s.discarded, s.stream)
+	return []*EventMessage{
+		{Time: now, Event: s.stream, StreamData: []byte(marker)},
+		{Time: now, Event: s.stream, StreamData: s.tail},
+	}
+}
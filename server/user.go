@@ -7,7 +7,9 @@ import (
 	"html"
 	"log"
 	"math/rand"
+	"mime"
 	"net/http"
+	"path"
 	"sort"
 	"strconv"
 	"sync"
@@ -39,6 +41,7 @@ func GetCourses(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser
 		where, args = addWhereLike(where, args, "name", name)
 	}
 
+	where = addWhereNull(where, "courses.deleted_at")
 	courses := []*Course{}
 	var err error
 
@@ -60,7 +63,7 @@ func GetCourses(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser
 
 // GetCourse handles /v2/courses/:course_id requests,
 // returning a single course.
-func GetCourse(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+func GetCourse(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User) {
 	courseID, err := parseID(w, "course_id", params["course_id"])
 	if err != nil {
 		return
@@ -69,11 +72,11 @@ func GetCourse(w http.ResponseWriter, tx *sql.Tx, params martini.Params, current
 	course := new(Course)
 
 	if currentUser.Admin {
-		err = meddler.Load(tx, "courses", course, courseID)
+		err = meddler.QueryRow(tx, course, `SELECT * FROM courses WHERE id = ? AND deleted_at IS NULL`, courseID)
 	} else {
 		err = meddler.QueryRow(tx, course, `SELECT courses.* `+
 			`FROM courses JOIN assignments ON courses.id = assignments.course_id `+
-			`WHERE assignments.user_id = ? AND assignments.course_id = ?`,
+			`WHERE assignments.user_id = ? AND assignments.course_id = ? AND courses.deleted_at IS NULL`,
 			currentUser.ID, courseID)
 	}
 
@@ -81,22 +84,19 @@ func GetCourse(w http.ResponseWriter, tx *sql.Tx, params martini.Params, current
 		loggedHTTPDBNotFoundError(w, err)
 		return
 	}
-	render.JSON(http.StatusOK, course)
+	renderJSONWithETag(w, r, course)
 }
 
 // DeleteCourse handles /v2/courses/:course_id requests,
-// deleting a single course.
-// This will also delete all assignments and commits related to the course.
-func DeleteCourse(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+// marking a single course as deleted.
+// Assignments and commits are kept until it is purged, see PurgeCourse.
+func DeleteCourse(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
 	courseID, err := parseID(w, "course_id", params["course_id"])
 	if err != nil {
 		return
 	}
 
-	if _, err := tx.Exec(`DELETE FROM courses WHERE id = ?`, courseID); err != nil {
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
-		return
-	}
+	softDelete(w, tx, currentUser, "courses", "course", courseID)
 }
 
 // GetUsers handles /v2/users requests,
@@ -189,6 +189,42 @@ func GetUserSession(w http.ResponseWriter, r *http.Request, render render.Render
 	render.JSON(http.StatusOK, result)
 }
 
+// PostUserImpersonate handles /v2/users/:user_id/impersonate requests,
+// returning a cookie for a read-only session as the given user so an
+// administrator can see exactly what the user sees. The cookie expires
+// after an hour. It is not set in the response, so the administrator's
+// own session is left in place.
+func PostUserImpersonate(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	userID, err := parseID(w, "user_id", params["user_id"])
+	if err != nil {
+		return
+	}
+	user := new(User)
+	if err := meddler.Load(tx, "users", user, userID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if user.ID == currentUser.ID {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "you cannot impersonate yourself")
+		return
+	}
+
+	session := NewImpersonationSession(user.ID, currentUser.ID)
+	if err := audit(tx, currentUser, "impersonate", "user", user.ID, "started read-only session as user %d until %s",
+		user.ID, session.ExpiresAt.Format(time.RFC3339)); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	cookie := session.Cookie(w)
+	if cookie == "" {
+		return
+	}
+	log.Printf("user %d (%s) is impersonating user %d (%s)", currentUser.ID, currentUser.Name, user.ID, user.Name)
+
+	result := map[string]string{"cookie": cookie, "expiresAt": session.ExpiresAt.Format(time.RFC3339)}
+	render.JSON(http.StatusOK, result)
+}
+
 // GetUser handles /v2/users/:user_id requests,
 // returning a single user.
 func GetUser(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
@@ -275,7 +311,7 @@ func DeleteUser(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
 // related to the assignment, including the assignment canvas title, user name, user email, course name,
 // problem set unique ID, problem set note, and problem set tags. The returned assignments match
 // all search terms.
-func GetAssignments(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User, render render.Render) {
+func GetAssignments(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User) {
 	if err := r.ParseForm(); err != nil {
 		loggedHTTPErrorf(w, http.StatusBadRequest, "parsing form data: %v", err)
 		return
@@ -304,12 +340,12 @@ func GetAssignments(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentU
 		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 		return
 	}
-	render.JSON(http.StatusOK, assignments)
+	renderJSONWithETag(w, r, assignments)
 }
 
 // GetUserAssignments handles requests to /v2/users/:user_id/assignments,
 // returning a list of assignments for the given user.
-func GetUserAssignments(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+func GetUserAssignments(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User) {
 	userID, err := parseID(w, "user_id", params["user_id"])
 	if err != nil {
 		return
@@ -334,12 +370,12 @@ func GetUserAssignments(w http.ResponseWriter, tx *sql.Tx, params martini.Params
 		return
 	}
 
-	render.JSON(http.StatusOK, assignments)
+	renderJSONWithETag(w, r, assignments)
 }
 
 // GetCourseUserAssignments handles requests to /v2/courses/:course_id/users/:user_id/assignments,
 // returning a list of assignments for the given user in the given course.
-func GetCourseUserAssignments(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+func GetCourseUserAssignments(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User) {
 	courseID, err := parseID(w, "course_id", params["course_id"])
 	if err != nil {
 		return
@@ -373,12 +409,12 @@ func GetCourseUserAssignments(w http.ResponseWriter, tx *sql.Tx, params martini.
 		return
 	}
 
-	render.JSON(http.StatusOK, assignments)
+	renderJSONWithETag(w, r, assignments)
 }
 
 // GetAssignment handles requests to /v2/assignments/:assignment_id,
 // returning the given assignment.
-func GetAssignment(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+func GetAssignment(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User) {
 	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
 	if err != nil {
 		return
@@ -400,7 +436,32 @@ func GetAssignment(w http.ResponseWriter, tx *sql.Tx, params martini.Params, cur
 		return
 	}
 
-	render.JSON(http.StatusOK, assignment)
+	renderJSONWithETag(w, r, assignment)
+}
+
+// GetAssignmentStudents handles requests to /v2/assignments/:assignment_id/students,
+// returning the assignments of every student in the same course
+// that were launched from the same LMS assignment.
+//
+// If parameter section=<...> present, only students in that section are listed.
+func GetAssignmentStudents(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignment, ok := getInstructorAssignment(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+	sectionID, ok := getSectionFilter(w, r, tx, assignment.CourseID)
+	if !ok {
+		return
+	}
+
+	assignments := []*Assignment{}
+	where, args := sectionWhere(`course_id = ? AND lti_id = ? AND NOT instructor`, []interface{}{assignment.CourseID, assignment.LtiID}, sectionID)
+	if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments WHERE `+where+` ORDER BY id`, args...); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, assignments)
 }
 
 // DeleteAssignment handles requests to /v2/assignments/:assignment_id,
@@ -499,16 +560,300 @@ func DeleteCommit(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
 	}
 }
 
+// GetCommitDiff handles requests to /v2/commits/:commit_id/diff,
+// returning per-file unified diffs between two commits.
+//
+// If parameter against=<...> present, the commit is compared with the commit
+// having that ID. Otherwise it is compared with the starter files for its step.
+func GetCommitDiff(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	commitID, err := parseID(w, "commit_id", params["commit_id"])
+	if err != nil {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "parsing form data: %v", err)
+		return
+	}
+
+	commit, err := getCommitForUser(tx, currentUser, commitID)
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	diff := &CommitDiff{CommitID: commit.ID}
+	var before map[string][]byte
+	if against := r.FormValue("against"); against != "" {
+		otherID, err := parseID(w, "against", against)
+		if err != nil {
+			return
+		}
+		other, err := getCommitForUser(tx, currentUser, otherID)
+		if err != nil {
+			loggedHTTPDBNotFoundError(w, err)
+			return
+		}
+		diff.AgainstID = other.ID
+		before = other.Files
+	} else {
+		step := new(ProblemStep)
+		if err := meddler.QueryRow(tx, step, `SELECT * FROM problem_steps WHERE problem_id = ? AND step = ?`, commit.ProblemID, commit.Step); err != nil {
+			loggedHTTPDBNotFoundError(w, err)
+			return
+		}
+		before = make(map[string][]byte)
+		for name := range step.Whitelist {
+			if contents, exists := step.Files[name]; exists {
+				before[name] = contents
+			}
+		}
+	}
+	diff.Files = DiffFiles(before, commit.Files)
+
+	render.JSON(http.StatusOK, diff)
+}
+
+// GetCommitArtifact handles requests to /v2/commits/:commit_id/artifacts/**,
+// returning the contents of an output file saved from the grading container.
+// The file is named by the rest of the path.
+func GetCommitArtifact(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	commitID, err := parseID(w, "commit_id", params["commit_id"])
+	if err != nil {
+		return
+	}
+	commit, err := getCommitForUser(tx, currentUser, commitID)
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	name := params["_1"]
+	contents, exists := commit.Artifacts[name]
+	if !exists {
+		loggedHTTPErrorf(w, http.StatusNotFound, "commit %d has no artifact named %q", commit.ID, name)
+		return
+	}
+
+	// artifacts are student output, so never let a browser run them as part of this site
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(contents)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	w.Write(contents)
+}
+
+// GetCommitTranscript handles requests to /v2/commits/:commit_id/transcript,
+// returning the timed events recorded while the commit was graded.
+func GetCommitTranscript(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	commitID, err := parseID(w, "commit_id", params["commit_id"])
+	if err != nil {
+		return
+	}
+	commit, err := getCommitForUser(tx, currentUser, commitID)
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	transcript := commit.Transcript
+	if transcript == nil {
+		transcript = []*EventMessage{}
+	}
+	render.JSON(http.StatusOK, transcript)
+}
+
+// getCommitForUser loads a single commit, checking that the user has access to it.
+func getCommitForUser(tx *sql.Tx, currentUser *User, commitID int64) (*Commit, error) {
+	commit := new(Commit)
+	var err error
+	if currentUser.Admin {
+		err = meddler.Load(tx, "commits", commit, commitID)
+	} else {
+		err = meddler.QueryRow(tx, commit, `SELECT commits.* `+
+			`FROM commits JOIN user_assignments ON commits.assignment_id = user_assignments.assignment_id `+
+			`WHERE commits.id = ? AND user_assignments.user_id = ?`,
+			commitID, currentUser.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+// GetAssignmentProblemCommitHistory handles requests to
+// /v2/assignments/:assignment_id/problems/:problem_id/commits/history,
+// returning a list of every saved version of the commits for the given
+// problem, oldest first. Files and transcripts are omitted.
+//
+// If parameter step=<...> present, results will be limited to that step.
+func GetAssignmentProblemCommitHistory(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return
+	}
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "parsing form data: %v", err)
+		return
+	}
+
+	where, args := addWhereEq("", nil, "commit_history.assignment_id", assignmentID)
+	where, args = addWhereEq(where, args, "commit_history.problem_id", problemID)
+	if step := r.FormValue("step"); step != "" {
+		n, err := parseID(w, "step", step)
+		if err != nil {
+			return
+		}
+		where, args = addWhereEq(where, args, "commit_history.step", n)
+	}
+
+	commits := []*Commit{}
+	if currentUser.Admin {
+		err = meddler.QueryAll(tx, &commits, `SELECT * FROM commit_history`+where+` ORDER BY id`, args...)
+	} else {
+		where, args = addWhereEq(where, args, "user_assignments.user_id", currentUser.ID)
+		err = meddler.QueryAll(tx, &commits, `SELECT commit_history.* `+
+			`FROM commit_history JOIN user_assignments ON commit_history.assignment_id = user_assignments.assignment_id`+
+			where+` ORDER BY commit_history.id`, args...)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	for _, commit := range commits {
+		commit.Files = nil
+		commit.Transcript = nil
+		commit.Artifacts = nil
+	}
+
+	render.JSON(http.StatusOK, commits)
+}
+
+// GetAssignmentPractice handles requests to /v2/assignments/:assignment_id/practice,
+// returning the graded practice attempts for an assignment, oldest first.
+// Files and transcripts are omitted.
+//
+// If parameter problem_id=<...> present, results will be limited to that problem.
+func GetAssignmentPractice(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "parsing form data: %v", err)
+		return
+	}
+
+	where, args := addWhereEq("", nil, "practice_commits.assignment_id", assignmentID)
+	if problem := r.FormValue("problem_id"); problem != "" {
+		problemID, err := parseID(w, "problem_id", problem)
+		if err != nil {
+			return
+		}
+		where, args = addWhereEq(where, args, "practice_commits.problem_id", problemID)
+	}
+
+	commits := []*Commit{}
+	if currentUser.Admin {
+		err = meddler.QueryAll(tx, &commits, `SELECT * FROM practice_commits`+where+` ORDER BY id`, args...)
+	} else {
+		where, args = addWhereEq(where, args, "user_assignments.user_id", currentUser.ID)
+		err = meddler.QueryAll(tx, &commits, `SELECT practice_commits.* `+
+			`FROM practice_commits JOIN user_assignments ON practice_commits.assignment_id = user_assignments.assignment_id`+
+			where+` ORDER BY practice_commits.id`, args...)
+	}
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	for _, commit := range commits {
+		commit.Files = nil
+		commit.Transcript = nil
+		commit.Artifacts = nil
+	}
+
+	render.JSON(http.StatusOK, commits)
+}
+
+// GetCommitHistory handles requests to /v2/commit_history/:commit_history_id,
+// returning a single saved version of a commit.
+func GetCommitHistory(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	historyID, err := parseID(w, "commit_history_id", params["commit_history_id"])
+	if err != nil {
+		return
+	}
+
+	commit := new(Commit)
+	if currentUser.Admin {
+		err = meddler.Load(tx, "commit_history", commit, historyID)
+	} else {
+		err = meddler.QueryRow(tx, commit, `SELECT commit_history.* `+
+			`FROM commit_history JOIN user_assignments ON commit_history.assignment_id = user_assignments.assignment_id `+
+			`WHERE commit_history.id = ? AND user_assignments.user_id = ?`,
+			historyID, currentUser.ID)
+	}
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	render.JSON(http.StatusOK, commit)
+}
+
+// saveCommitHistory records a copy of a commit that was just saved.
+// A copy is kept if the commit was graded or if its files changed since the
+// last recorded version.
+func saveCommitHistory(tx *sql.Tx, commit *Commit) error {
+	if commit.ReportCard == nil {
+		last := new(Commit)
+		err := meddler.QueryRow(tx, last, `SELECT * FROM commit_history WHERE assignment_id = ? AND problem_id = ? AND step = ? ORDER BY id DESC LIMIT 1`,
+			commit.AssignmentID, commit.ProblemID, commit.Step)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil && sameFiles(last.Files, commit.Files) {
+			return nil
+		}
+	}
+
+	elt := *commit
+	elt.ID = 0
+	return meddler.Insert(tx, "commit_history", &elt)
+}
+
+func sameFiles(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, contents := range a {
+		if other, exists := b[name]; !exists || !bytes.Equal(contents, other) {
+			return false
+		}
+	}
+	return true
+}
+
 // PostCommitBundlesUnsigned handles requests to /v2/commit_bundles/unsigned,
 // saving a new commit (or updating the most recent one), gathering the problem data,
 // signing everything, and returning it in a form ready to send to the daycare.
-func PostCommitBundlesUnsigned(w http.ResponseWriter, tx *sql.Tx, currentUser *User, bundle CommitBundle, render render.Render) {
+func PostCommitBundlesUnsigned(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User, session *CookieSession, bundle CommitBundle, render render.Render) {
 	now := time.Now()
 
 	if bundle.Commit == nil {
 		loggedHTTPErrorf(w, http.StatusBadRequest, "bundle must include a commit object")
 		return
 	}
+	if !embedAllowsCommit(session, bundle.Commit) {
+		loggedHTTPErrorf(w, http.StatusForbidden, "this session can only be used for problem %d in assignment %d", session.EmbedProblemID, session.EmbedAssignmentID)
+		return
+	}
 	if len(bundle.CommitSignature) != 0 {
 		loggedHTTPErrorf(w, http.StatusBadRequest, "bundle must not include commit signature")
 		return
@@ -523,30 +868,39 @@ func PostCommitBundlesUnsigned(w http.ResponseWriter, tx *sql.Tx, currentUser *U
 	bundle.Hostname = ""
 	bundle.Commit.Transcript = []*EventMessage{}
 	bundle.Commit.ReportCard = nil
+	bundle.Commit.Artifacts = nil
 	bundle.Commit.Score = 0.0
+	bundle.Commit.ClientKeyID = 0
 	bundle.Commit.CreatedAt = now
 	bundle.Commit.UpdatedAt = now
-	saveCommitBundleCommon(now, w, tx, currentUser, bundle, render)
+	saveCommitBundleCommon(now, w, r, tx, currentUser, bundle, render)
 }
 
 // PostCommitBundlesSigned handles requests to /v2/commit_bundles/signed,
 // saving a new commit (or updating the most recent one), gathering the problem data,
 // verifying signatures, and posting a grade (if appropriate).
-func PostCommitBundlesSigned(w http.ResponseWriter, tx *sql.Tx, currentUser *User, bundle CommitBundle, render render.Render) {
+func PostCommitBundlesSigned(w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User, session *CookieSession, bundle CommitBundle, render render.Render) {
 	now := time.Now()
 
 	if bundle.Commit == nil {
 		loggedHTTPErrorf(w, http.StatusBadRequest, "bundle must include a commit object")
 		return
 	}
+	if !embedAllowsCommit(session, bundle.Commit) {
+		loggedHTTPErrorf(w, http.StatusForbidden, "this session can only be used for problem %d in assignment %d", session.EmbedProblemID, session.EmbedAssignmentID)
+		return
+	}
 	if len(bundle.CommitSignature) == 0 {
 		loggedHTTPErrorf(w, http.StatusBadRequest, "bundle must include commit signature")
 		return
 	}
-	saveCommitBundleCommon(now, w, tx, currentUser, bundle, render)
+	saveCommitBundleCommon(now, w, r, tx, currentUser, bundle, render)
 }
 
-func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, currentUser *User, bundle CommitBundle, render render.Render) {
+func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, r *http.Request, tx *sql.Tx, currentUser *User, bundle CommitBundle, render render.Render) {
+	// messages for the student are sent in their language
+	locale := requestLocale(r, currentUser)
+
 	if bundle.ProblemType != nil {
 		loggedHTTPErrorf(w, http.StatusBadRequest, "bundle must not include a problem type object")
 		return
@@ -575,12 +929,33 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 		loggedHTTPErrorf(w, http.StatusBadRequest, "bundle must include user's ID")
 		return
 	}
+	if bundle.Ephemeral && bundle.Practice {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "bundle cannot be both ephemeral and practice")
+		return
+	}
 	commit := bundle.Commit
+	if err := resolveFileHashes(tx, commit); err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	// note the key that grind signed a new commit with; once the commit
+	// is signed by the server the key ID is covered by that signature
+	if bundle.CommitSignature == "" {
+		keyID, err := verifyClientSignature(tx, &bundle, now)
+		if err != nil {
+			loggedHTTPCodedErrorf(w, http.StatusForbidden, ErrorUnsigned, "%v", err)
+			return
+		}
+		commit.ClientKeyID = keyID
+	}
 
 	// get the assignment and figure out if this is the student or the instructor
 	isInstructor := false
 	assignment := new(Assignment)
-	err := meddler.QueryRow(tx, assignment, `SELECT * FROM assignments WHERE id = ? AND user_id = ?`, commit.AssignmentID, currentUser.ID)
+	// user_assignments leaves out deleted courses, so work cannot be saved to them
+	err := meddler.QueryRow(tx, assignment, `SELECT assignments.* FROM assignments JOIN user_assignments ON assignments.id = user_assignments.assignment_id `+
+		`WHERE assignments.id = ? AND assignments.user_id = ? AND user_assignments.user_id = ?`, commit.AssignmentID, currentUser.ID, currentUser.ID)
 	if err == sql.ErrNoRows {
 		// try loading it as the instructor
 		err = meddler.QueryRow(tx, assignment, `SELECT assignments.* FROM assignments JOIN user_assignments ON assignments.id = user_assignments.assignment_id `+
@@ -607,34 +982,37 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 	//     * else accept
 	// * else if the course-wide lock at has passed, reject
 	// * else accept
+	// * practice is allowed after the deadline, since it does not affect the score
 	var courseWideLockAt time.Time
 	err = tx.QueryRow(`SELECT lock_at FROM assignments WHERE instructor AND lti_id = ? AND lock_at IS NOT NULL ORDER BY lock_at DESC LIMIT 1`,
 		assignment.LtiID).Scan(&courseWideLockAt)
 	if err != nil && err != sql.ErrNoRows {
 		loggedHTTPDBNotFoundError(w, err)
 		return
-	} else if err == nil {
+	} else if err == nil && !bundle.Practice {
 		// there is a course-wide deadline, should we reject?
 		if (assignment.LockAt != nil && now.After(*assignment.LockAt)) ||
 			(assignment.LockAt == nil && now.After(courseWideLockAt)) {
-			loggedHTTPErrorf(w, http.StatusForbidden, "a commit cannot be submitted after the assignment is locked")
+			localizedHTTPErrorf(w, locale, http.StatusForbidden, ErrorLocked, "a commit cannot be submitted after the assignment is locked")
 			return
 		}
 	}
 
-	// get the problem
+	// get the problem, which must be a live part of the assignment's problem set
 	problem := new(Problem)
-	if err = meddler.QueryRow(tx, problem, `SELECT * FROM problems WHERE id = ?`, commit.ProblemID); err != nil {
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
-		return
-	}
-	steps := []*ProblemStep{}
-	if err = meddler.QueryAll(tx, &steps, `SELECT * FROM problem_steps WHERE problem_id = ? ORDER BY step`, commit.ProblemID); err != nil {
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+	if err = meddler.QueryRow(tx, problem, `SELECT problems.* FROM problems `+
+		`JOIN problem_set_problems ON problems.id = problem_set_problems.problem_id `+
+		`JOIN problem_sets ON problem_set_problems.problem_set_id = problem_sets.id `+
+		`WHERE problems.id = ? AND problem_sets.id = ? AND problems.deleted_at IS NULL AND problem_sets.deleted_at IS NULL`,
+		commit.ProblemID, assignment.ProblemSetID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
 		return
 	}
-	if len(steps) == 0 {
-		loggedHTTPErrorf(w, http.StatusInternalServerError, "no steps found for problem %s (%d)", problem.Unique, problem.ID)
+
+	// a multi-language problem uses the steps of the variant the student chose
+	variant, steps, err := getProblemVariant(tx, assignment, problem)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
 		return
 	}
 
@@ -654,6 +1032,25 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 		return
 	}
 
+	// check the problem type and daily daycare time against the course limits
+	if status, err := checkCourseLimits(tx, assignment, problemType.Name, bundle.Hostname == "" && !isInstructor, now); err != nil {
+		loggedHTTPErrorf(w, status, "%v", err)
+		return
+	}
+
+	// a course may require that commits come from a registered copy of grind
+	if bundle.CommitSignature == "" && !isInstructor && commit.ClientKeyID == 0 {
+		limits, err := getCourseLimits(tx, assignment.CourseID)
+		if err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		if limits.SignedCommits {
+			localizedHTTPErrorf(w, locale, http.StatusForbidden, ErrorUnsigned, "this course only accepts work submitted by a copy of grind that you have logged in to")
+			return
+		}
+	}
+
 	if assignment.RawScores == nil {
 		assignment.RawScores = map[string][]float64{}
 	}
@@ -662,33 +1059,39 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 	scores := assignment.RawScores[problem.Unique]
 	for i := 0; i < int(commit.Step)-1; i++ {
 		if i >= len(scores) || scores[i] != 1.0 {
-			loggedHTTPErrorf(w, http.StatusBadRequest, "commit is for step %d, but user has not passed step %d", commit.Step, i+1)
+			localizedHTTPErrorf(w, locale, http.StatusBadRequest, ErrorBadRequest, "commit is for step %d, but user has not passed step %d", commit.Step, i+1)
 			return
 		}
 	}
 
-	// reject commit if user has started work on a later step
+	// reject commit if user has started work on a later step,
+	// unless they are going back to practice an earlier one
 	var latestStep int64
 	if err = tx.QueryRow(`SELECT step FROM commits WHERE assignment_id = ? AND problem_id = ? ORDER BY step DESC LIMIT 1`, commit.AssignmentID, commit.ProblemID).Scan(&latestStep); err != nil {
 		if err != sql.ErrNoRows {
 			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 			return
 		}
-	} else if latestStep > commit.Step {
-		loggedHTTPErrorf(w, http.StatusBadRequest, "commit is for step %d, but user has already started work on step %d", commit.Step, latestStep)
+	} else if latestStep > commit.Step && !bundle.Practice {
+		localizedHTTPErrorf(w, locale, http.StatusBadRequest, ErrorBadRequest, "commit is for step %d, but user has already started work on step %d", commit.Step, latestStep)
 		return
 	}
 
 	// validate commit
-	if err := commit.Normalize(now, steps[commit.Step-1].Whitelist); err != nil {
+	// students may add files of their own when the problem allows it
+	allowed := steps[commit.Step-1].AllowedFiles(variant.ExtraFiles(), commit.Files)
+	if err := commit.Normalize(now, allowed, steps[commit.Step-1].Binary); err != nil {
 		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
 		return
 	}
 
 	// update an existing commit if it exists
 	// note: this used to include AND action IS NULL AND updated_at > now.Add(-OpenCommitTimeout)
+	// practice commits never replace the real one, so they have no ID
 	openCommit := new(Commit)
-	if err := meddler.QueryRow(tx, openCommit, `SELECT * FROM commits WHERE assignment_id = ? AND problem_id = ? AND step = ? LIMIT 1`, commit.AssignmentID, commit.ProblemID, commit.Step); err != nil {
+	if bundle.Practice {
+		commit.ID = 0
+	} else if err := meddler.QueryRow(tx, openCommit, `SELECT * FROM commits WHERE assignment_id = ? AND problem_id = ? AND step = ? LIMIT 1`, commit.AssignmentID, commit.ProblemID, commit.Step); err != nil {
 		if err == sql.ErrNoRows {
 			commit.ID = 0
 		} else {
@@ -702,7 +1105,7 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 
 	// sign the problem and the commit
 	typeSig := problemType.ComputeSignature(Config.DaycareSecret)
-	problemSig := problem.ComputeSignature(Config.DaycareSecret, steps)
+	problemSig := variant.ComputeSignature(Config.DaycareSecret, steps)
 	commitSig := commit.ComputeSignature(Config.DaycareSecret, typeSig, problemSig, bundle.Hostname, bundle.UserID)
 
 	// verify signature
@@ -729,11 +1132,26 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 	}
 	if isInstructor {
 		log.Printf("instructor is testing student code, skipping save step")
+	} else if bundle.Ephemeral {
+		log.Printf("student is checking their code, skipping save step")
+	} else if bundle.Practice {
+		// graded practice is recorded on its own, away from the real commits
+		if bundle.CommitSignature != "" && commit.ReportCard != nil {
+			elt := *commit
+			if err := meddler.Insert(tx, "practice_commits", &elt); err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+				return
+			}
+		}
 	} else {
 		if err := meddler.Save(tx, "commits", commit); err != nil {
 			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
 			return
 		}
+		if err := saveCommitHistory(tx, commit); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+			return
+		}
 
 		// save an updated timestamp on the assignment if it would otherwise not be updated
 		if commit.ReportCard == nil {
@@ -746,11 +1164,19 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 	}
 	commit.Action = action
 
+	// a signed commit has come back from a daycare
+	if bundle.CommitSignature != "" {
+		if commit.ReportCard != nil && commit.ReportCard.Abuse != "" {
+			log.Printf("daycare %s killed the container for user %d (%s) on assignment %d: %s",
+				bundle.Hostname, currentUser.ID, currentUser.Name, assignment.ID, commit.ReportCard.Abuse)
+		}
+	}
+
 	// assign a daycare host if needed
 	if bundle.Hostname == "" {
 		typeSet := map[string]bool{problemType.Name: true}
 
-		host, err := daycareRegistrations.Assign(typeSet)
+		host, err := daycareRegistrations.Assign(typeSet, currentUser.ID, problem.ID)
 		if err != nil {
 			log.Printf("error assigning a daycare for this commit: %v", err)
 		} else {
@@ -763,17 +1189,20 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 	signed := &CommitBundle{
 		ProblemType:          problemType,
 		ProblemTypeSignature: typeSig,
-		Problem:              problem,
+		Problem:              variant,
 		ProblemSteps:         steps,
 		ProblemSignature:     problemSig,
 		Hostname:             bundle.Hostname,
 		UserID:               bundle.UserID,
 		Commit:               commit,
 		CommitSignature:      commitSig,
+		Ephemeral:            bundle.Ephemeral,
+		Practice:             bundle.Practice,
+		Locale:               locale,
 	}
 
 	// save the grade update
-	if !isInstructor && signed.Commit.ReportCard != nil {
+	if !isInstructor && !bundle.Ephemeral && !bundle.Practice && signed.Commit.ReportCard != nil {
 		assignment.SetMinorScore(problem.Unique, int(signed.Commit.Step-1), signed.Commit.ReportCard.ComputeScore())
 
 		// get the weight of each step in the problem and problem in the set
@@ -808,14 +1237,17 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 		// record the grading transcript
 		var report bytes.Buffer
 		if len(majorWeights) > 1 && len(signed.ProblemSteps) > 1 {
-			fmt.Fprintf(&report, "<h1>Grading transcript for problem %s step %d</h1>\n", signed.Problem.Unique, signed.Commit.Step)
+			fmt.Fprintf(&report, "<h1>Grading transcript for problem %s step %d</h1>\n", problem.Unique, signed.Commit.Step)
 		} else if len(majorWeights) > 1 {
-			fmt.Fprintf(&report, "<h1>Grading transcript for problem %s</h1>\n", signed.Problem.Unique)
+			fmt.Fprintf(&report, "<h1>Grading transcript for problem %s</h1>\n", problem.Unique)
 		} else if len(signed.ProblemSteps) > 1 {
 			fmt.Fprintf(&report, "<h1>Grading transcript for step %d</h1>\n", signed.Commit.Step)
 		} else {
 			fmt.Fprintf(&report, "<h1>Grading transcript</h1>\n")
 		}
+		if signed.Commit.Message != "" {
+			fmt.Fprintf(&report, "<p>Student note: %s</p>\n", html.EscapeString(signed.Commit.Message))
+		}
 		fmt.Fprintf(&report, "<pre>%s</pre>\n", html.EscapeString(transcript.String()))
 
 		// add all of the student files
@@ -834,38 +1266,28 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 			}
 		}
 
+		// an instructor override takes precedence over the computed score
+		posted := *assignment
+		if err := applyScoreOverride(tx, &posted); err != nil {
+			loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+
 		// send grade to the LMS in a goroutine
 		// so we can wrap up the transaction and return to the user
-		go func(asst *Assignment, msg string) {
-			// try up to 10 times before giving up
-			tries := 10
-			minSleepTime := 10 * time.Second
-			maxSleepTime := 5 * time.Minute
-			sleepTime := minSleepTime
-			for i := 0; i < tries; i++ {
-				err := saveGrade(asst, msg)
-				if err == nil {
-					return
-				}
-				log.Printf("error posting grade back to LMS (attempt %d/%d): %v", i+1, tries, err)
-				if i+1 < 10 {
-					log.Printf("  will try again in %v", sleepTime)
-					time.Sleep(sleepTime)
-					sleepTime *= 2
-					if sleepTime > maxSleepTime {
-						sleepTime = maxSleepTime
-					}
-				} else {
-					log.Printf("  giving up")
-				}
-			}
-		}(assignment, report.String())
+		go postGrade(&posted, report.String())
 	}
 
 	note := ""
 	if bundle.Commit.Note != "" {
 		note = " (" + bundle.Commit.Note + ")"
 	}
+	if bundle.Ephemeral {
+		note += " [not saved]"
+	}
+	if bundle.Practice {
+		note += " [practice]"
+	}
 	if bundle.Commit.Action == "" && bundle.CommitSignature == "" {
 		log.Printf("save request: user %s saving %s step %d%s",
 			currentUser.Name, problem.Note, bundle.Commit.Step, note)
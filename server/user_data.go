@@ -0,0 +1,229 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// GetUserExport handles /v2/users/:user_id/export requests,
+// returning a zip file with all of the data held about a user:
+// the user profile, assignments, commits (with transcripts and report cards),
+// saved commit history, quiz responses, score overrides, warnings,
+// and the notifications sent to the user.
+func GetUserExport(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User) {
+	now := time.Now()
+
+	userID, err := parseID(w, "user_id", params["user_id"])
+	if err != nil {
+		return
+	}
+	user := new(User)
+	if err := meddler.Load(tx, "users", user, userID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	add := func(name string, elt interface{}) error {
+		raw, err := json.MarshalIndent(elt, "", "    ")
+		if err != nil {
+			return err
+		}
+		f, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(append(raw, '\n'))
+		return err
+	}
+	if err := writeUserExport(tx, user, add); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error exporting user %d: %v", userID, err)
+		return
+	}
+	if err := z.Close(); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error writing zip file: %v", err)
+		return
+	}
+
+	if err := audit(tx, currentUser, "export", "user", userID, "exported all data for user %d", user.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("user-%d.zip", userID)))
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Printf("error writing export for user %d: %v", userID, err)
+	}
+}
+
+func writeUserExport(tx *sql.Tx, user *User, add func(name string, elt interface{}) error) error {
+	if err := add("user.json", user); err != nil {
+		return err
+	}
+
+	var assignments []*Assignment
+	if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments WHERE user_id = ? ORDER BY id`, user.ID); err != nil {
+		return err
+	}
+	for _, asst := range assignments {
+		dir := fmt.Sprintf("assignments/%d/", asst.ID)
+		if err := add(dir+"assignment.json", asst); err != nil {
+			return err
+		}
+
+		var commits []*Commit
+		if err := meddler.QueryAll(tx, &commits, `SELECT * FROM commits WHERE assignment_id = ? ORDER BY id`, asst.ID); err != nil {
+			return err
+		}
+		for _, commit := range commits {
+			if err := add(fmt.Sprintf("%scommits/%d.json", dir, commit.ID), commit); err != nil {
+				return err
+			}
+		}
+
+		var history []*Commit
+		if err := meddler.QueryAll(tx, &history, `SELECT * FROM commit_history WHERE assignment_id = ? ORDER BY id`, asst.ID); err != nil {
+			return err
+		}
+		for _, commit := range history {
+			if err := add(fmt.Sprintf("%scommit_history/%d.json", dir, commit.ID), commit); err != nil {
+				return err
+			}
+		}
+
+		var practice []*Commit
+		if err := meddler.QueryAll(tx, &practice, `SELECT * FROM practice_commits WHERE assignment_id = ? ORDER BY id`, asst.ID); err != nil {
+			return err
+		}
+		for _, commit := range practice {
+			if err := add(fmt.Sprintf("%spractice_commits/%d.json", dir, commit.ID), commit); err != nil {
+				return err
+			}
+		}
+
+		responses := []*Response{}
+		if err := meddler.QueryAll(tx, &responses, `SELECT * FROM responses WHERE assignment_id = ? ORDER BY id`, asst.ID); err != nil {
+			return err
+		}
+		if len(responses) > 0 {
+			if err := add(dir+"responses.json", responses); err != nil {
+				return err
+			}
+		}
+
+		overrides := []*ScoreOverride{}
+		if err := meddler.QueryAll(tx, &overrides, `SELECT * FROM score_overrides WHERE assignment_id = ? ORDER BY id`, asst.ID); err != nil {
+			return err
+		}
+		if len(overrides) > 0 {
+			if err := add(dir+"score_overrides.json", overrides); err != nil {
+				return err
+			}
+		}
+	}
+
+	warnings := []*StudentWarning{}
+	if err := meddler.QueryAll(tx, &warnings, `SELECT * FROM student_warnings WHERE user_id = ? ORDER BY id`, user.ID); err != nil {
+		return err
+	}
+	if len(warnings) > 0 {
+		if err := add("warnings.json", warnings); err != nil {
+			return err
+		}
+	}
+
+	notifications := []*Notification{}
+	if err := meddler.QueryAll(tx, &notifications, `SELECT * FROM notifications WHERE user_id = ? ORDER BY id`, user.ID); err != nil {
+		return err
+	}
+	if len(notifications) > 0 {
+		if err := add("notifications.json", notifications); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PostUserAnonymize handles /v2/users/:user_id/anonymize requests,
+// replacing the identifying fields of a user with placeholders.
+// Assignments, commits, and scores are kept so that course statistics
+// are unchanged, but the links back to the LMS are removed and grades
+// are no longer posted for this user. A later LTI launch by the same
+// person creates a new user.
+func PostUserAnonymize(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	now := time.Now()
+
+	userID, err := parseID(w, "user_id", params["user_id"])
+	if err != nil {
+		return
+	}
+	user := new(User)
+	if err := meddler.Load(tx, "users", user, userID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if user.ID == currentUser.ID {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "you cannot anonymize yourself")
+		return
+	}
+
+	placeholder := fmt.Sprintf("anonymized-%d", user.ID)
+	user.Name = fmt.Sprintf("Anonymous %d", user.ID)
+	user.Email = ""
+	user.LtiID = placeholder
+	user.ImageURL = ""
+	user.CanvasLogin = placeholder
+	user.CanvasID = 0
+	user.Author = false
+	user.Admin = false
+	user.UpdatedAt = now
+	if err := meddler.Update(tx, "users", user); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE assignments SET grade_id = NULL, outcome_url = '', outcome_ext_url = '', `+
+		`outcome_ext_accepted = '', finished_url = '', canvas_api_domain = '' WHERE user_id = ?`, user.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM student_warnings WHERE user_id = ?`, user.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM notifications WHERE user_id = ?`, user.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM accounts WHERE user_id = ?`, user.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if _, err := tx.Exec(`DELETE FROM user_links WHERE user_id = ?`, user.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	// note: the audit record does not repeat the old identity
+	if err := audit(tx, currentUser, "anonymize", "user", user.ID, "anonymized user %d", user.ID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	log.Printf("user %d anonymized by %s", user.ID, currentUser.Name)
+
+	render.JSON(http.StatusOK, user)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// PostUserMerge handles /v2/users/:user_id/merge requests,
+// moving everything that belongs to another user into this one and
+// deleting the other user. This is for students who appear under more
+// than one LTI identity, such as after an institution changes systems.
+// The other user's LTI ID is recorded so that future launches
+// with it sign in as this user.
+//
+// Parameter from=<user ID> must be present, and gives the user to merge
+// into this one.
+//
+// Assignments are moved to this user. If both users have an assignment
+// for the same LTI resource, the two are combined: commits for steps
+// this user has not worked on are moved over, a step both users have
+// worked on keeps the most recently updated version, all history is
+// kept, and the assignment keeps the higher of the two scores.
+func PostUserMerge(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	now := time.Now()
+
+	userID, err := parseID(w, "user_id", params["user_id"])
+	if err != nil {
+		return
+	}
+	fromID, err := strconv.ParseInt(r.FormValue("from"), 10, 64)
+	if err != nil || fromID < 1 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "from=<user ID> parameter is required")
+		return
+	}
+	if fromID == userID {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "cannot merge user %d with itself", userID)
+		return
+	}
+	user, from := new(User), new(User)
+	if err := meddler.Load(tx, "users", user, userID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if err := meddler.Load(tx, "users", from, fromID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	moved, combined, err := mergeUser(tx, user, from, now)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "error merging user %d into user %d: %v", from.ID, user.ID, err)
+		return
+	}
+
+	if err := audit(tx, currentUser, "merge", "user", user.ID, "merged user %d (lti id %s) into user %d: assignments moved: %d, combined: %d",
+		from.ID, from.LtiID, user.ID, moved, combined); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	log.Printf("user %d (%s) merged into user %d (%s) by %s", from.ID, from.Name, user.ID, user.Name, currentUser.Name)
+
+	render.JSON(http.StatusOK, user)
+}
+
+func mergeUser(tx *sql.Tx, user, from *User, now time.Time) (moved, combined int, err error) {
+	var assignments []*Assignment
+	if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments WHERE user_id = ? ORDER BY id`, from.ID); err != nil {
+		return 0, 0, err
+	}
+	for _, asst := range assignments {
+		target := new(Assignment)
+		err := meddler.QueryRow(tx, target, `SELECT * FROM assignments WHERE user_id = ? AND lti_id = ?`, user.ID, asst.LtiID)
+		if err == sql.ErrNoRows {
+			if _, err := tx.Exec(`UPDATE assignments SET user_id = ? WHERE id = ?`, user.ID, asst.ID); err != nil {
+				return 0, 0, err
+			}
+			if _, err := tx.Exec(`UPDATE student_warnings SET user_id = ? WHERE assignment_id = ?`, user.ID, asst.ID); err != nil {
+				return 0, 0, err
+			}
+			moved++
+			continue
+		} else if err != nil {
+			return 0, 0, err
+		}
+
+		if err := combineAssignments(tx, target, asst, now); err != nil {
+			return 0, 0, err
+		}
+		combined++
+	}
+
+	// score overrides record the instructor who made them
+	if _, err := tx.Exec(`UPDATE score_overrides SET user_id = ? WHERE user_id = ?`, user.ID, from.ID); err != nil {
+		return 0, 0, err
+	}
+	if _, err := tx.Exec(`UPDATE daycare_usage SET user_id = ? WHERE user_id = ?`, user.ID, from.ID); err != nil {
+		return 0, 0, err
+	}
+	if _, err := tx.Exec(`UPDATE client_keys SET user_id = ? WHERE user_id = ?`, user.ID, from.ID); err != nil {
+		return 0, 0, err
+	}
+	if _, err := tx.Exec(`UPDATE notifications SET user_id = ? WHERE user_id = ?`, user.ID, from.ID); err != nil {
+		return 0, 0, err
+	}
+
+	// roster entries move unless the user is already listed in the same course
+	if _, err := tx.Exec(`DELETE FROM course_members WHERE user_id = ? AND course_id IN `+
+		`(SELECT course_id FROM course_members WHERE user_id = ?)`, from.ID, user.ID); err != nil {
+		return 0, 0, err
+	}
+	if _, err := tx.Exec(`UPDATE course_members SET user_id = ? WHERE user_id = ?`, user.ID, from.ID); err != nil {
+		return 0, 0, err
+	}
+
+	// keep a login account only if this user does not already have one
+	var accounts int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM accounts WHERE user_id = ?`, user.ID).Scan(&accounts); err != nil {
+		return 0, 0, err
+	}
+	if accounts == 0 {
+		if _, err := tx.Exec(`UPDATE accounts SET user_id = ? WHERE user_id = ?`, user.ID, from.ID); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// send future launches by the old identity (and anything merged into it) here
+	if _, err := tx.Exec(`UPDATE user_links SET user_id = ? WHERE user_id = ?`, user.ID, from.ID); err != nil {
+		return 0, 0, err
+	}
+	if _, err := tx.Exec(`INSERT INTO user_links (consumer_key, lti_id, user_id, created_at) VALUES (?, ?, ?, ?)`,
+		from.ConsumerKey, from.LtiID, user.ID, now.UTC()); err != nil {
+		return 0, 0, err
+	}
+
+	user.Author = user.Author || from.Author
+	user.Admin = user.Admin || from.Admin
+	user.UpdatedAt = now
+	if err := meddler.Update(tx, "users", user); err != nil {
+		return 0, 0, err
+	}
+
+	// everything left behind goes with the old user
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, from.ID); err != nil {
+		return 0, 0, err
+	}
+	return moved, combined, nil
+}
+
+// combineAssignments moves the work from one assignment into another
+// for the same LTI resource, then deletes the emptied assignment.
+func combineAssignments(tx *sql.Tx, target, asst *Assignment, now time.Time) error {
+	var commits []*Commit
+	if err := meddler.QueryAll(tx, &commits, `SELECT * FROM commits WHERE assignment_id = ?`, asst.ID); err != nil {
+		return err
+	}
+	for _, commit := range commits {
+		existing := new(Commit)
+		err := meddler.QueryRow(tx, existing, `SELECT * FROM commits WHERE assignment_id = ? AND problem_id = ? AND step = ?`,
+			target.ID, commit.ProblemID, commit.Step)
+		if err == nil {
+			if !commit.UpdatedAt.After(existing.UpdatedAt) {
+				continue
+			}
+			if _, err := tx.Exec(`DELETE FROM commits WHERE id = ?`, existing.ID); err != nil {
+				return err
+			}
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE commits SET assignment_id = ? WHERE id = ?`, target.ID, commit.ID); err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"commit_history", "practice_commits", "score_overrides", "daycare_usage"} {
+		if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET assignment_id = ? WHERE assignment_id = ?`, table), target.ID, asst.ID); err != nil {
+			return err
+		}
+	}
+
+	// quiz responses are only moved for questions this user has not answered
+	if _, err := tx.Exec(`UPDATE responses SET assignment_id = ? WHERE assignment_id = ? AND question_id NOT IN `+
+		`(SELECT question_id FROM responses WHERE assignment_id = ?)`, target.ID, asst.ID, target.ID); err != nil {
+		return err
+	}
+
+	if asst.Score > target.Score {
+		target.Score = asst.Score
+		target.RawScores = asst.RawScores
+		target.UpdatedAt = now
+		if err := meddler.Update(tx, "assignments", target); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(`DELETE FROM assignments WHERE id = ?`, asst.ID)
+	return err
+}
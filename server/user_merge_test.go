@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+func TestMergeUser(t *testing.T) {
+	d := newTestDB(t)
+	problem := d.problem("loops")
+	for step := int64(1); step <= 3; step++ {
+		d.step(problem, step)
+	}
+	set := d.problemSet("loops-set", problem)
+	otherSet := d.problemSet("other-set")
+	course := d.course("cs1")
+
+	user := d.user("kept")
+	from := d.user("duplicate")
+	from.Admin = true
+	if err := meddler.Update(d.tx, "users", from); err != nil {
+		t.Fatalf("updating user: %v", err)
+	}
+
+	// the same LTI resource under both identities
+	target := d.assignment(course, user, set, false)
+	target.Score = 0.25
+	asst := d.assignment(course, from, set, false)
+	asst.LtiID = target.LtiID
+	asst.Score = 0.75
+	for _, elt := range []*Assignment{target, asst} {
+		if err := meddler.Update(d.tx, "assignments", elt); err != nil {
+			t.Fatalf("updating assignment: %v", err)
+		}
+	}
+
+	// an assignment only the duplicate has
+	only := d.assignment(course, from, otherSet, false)
+
+	old, recent := d.now.Add(-time.Hour), d.now
+	keptStep1 := d.commit(target, problem, 1, old, 1.0)
+	newerStep1 := d.commit(asst, problem, 1, recent, 1.0)
+	newerStep2 := d.commit(asst, problem, 2, old, 0.5)
+	keptStep3 := d.commit(target, problem, 3, recent, 0.0)
+	olderStep3 := d.commit(asst, problem, 3, old, 1.0)
+	history := *olderStep3
+	history.ID = 0
+	d.insert("commit_history", &history)
+
+	moved, combined, err := mergeUser(d.tx, user, from, d.now)
+	if err != nil {
+		t.Fatalf("mergeUser: %v", err)
+	}
+	if moved != 1 || combined != 1 {
+		t.Errorf("got %d moved and %d combined, want 1 and 1", moved, combined)
+	}
+
+	// the duplicate is gone, and its identity leads here
+	var count int
+	if err := d.tx.QueryRow(`SELECT COUNT(1) FROM users WHERE id = ?`, from.ID).Scan(&count); err != nil || count != 0 {
+		t.Errorf("merged user still exists (count %d, err %v)", count, err)
+	}
+	var linked int64
+	if err := d.tx.QueryRow(`SELECT user_id FROM user_links WHERE lti_id = ?`, from.LtiID).Scan(&linked); err != nil || linked != user.ID {
+		t.Errorf("link for %s: got user %d (err %v), want %d", from.LtiID, linked, err, user.ID)
+	}
+	reloaded := new(User)
+	if err := meddler.Load(d.tx, "users", reloaded, user.ID); err != nil {
+		t.Fatalf("loading user: %v", err)
+	}
+	if !reloaded.Admin {
+		t.Errorf("merged user lost the administrator flag")
+	}
+
+	// assignments
+	expectIDs(t, "assignments", d.ids(`SELECT id FROM assignments WHERE user_id = ?`, user.ID), target.ID, only.ID)
+	expectIDs(t, "assignments left behind", d.ids(`SELECT id FROM assignments WHERE id = ?`, asst.ID))
+	combinedAsst := new(Assignment)
+	if err := meddler.Load(d.tx, "assignments", combinedAsst, target.ID); err != nil {
+		t.Fatalf("loading assignment: %v", err)
+	}
+	if combinedAsst.Score != 0.75 {
+		t.Errorf("combined assignment score: got %v, want the higher 0.75", combinedAsst.Score)
+	}
+
+	// each step keeps the most recently updated commit
+	expectIDs(t, "commits", d.ids(`SELECT id FROM commits WHERE assignment_id = ?`, target.ID),
+		newerStep1.ID, newerStep2.ID, keptStep3.ID)
+	expectIDs(t, "replaced commits", d.ids(`SELECT id FROM commits WHERE id IN (?, ?)`, keptStep1.ID, olderStep3.ID))
+
+	// history is kept
+	expectIDs(t, "history", d.ids(`SELECT id FROM commit_history WHERE assignment_id = ?`, target.ID), history.ID)
+}
+
+func TestMergeUserAccounts(t *testing.T) {
+	d := newTestDB(t)
+	user := d.user("kept")
+	from := d.user("duplicate")
+	acct := &account{
+		UserID:         from.ID,
+		Email:          from.Email,
+		PasswordHash:   "x",
+		Verified:       true,
+		TokenExpiresAt: d.now,
+		CreatedAt:      d.now,
+		UpdatedAt:      d.now,
+	}
+	d.insert("accounts", acct)
+
+	// a link that pointed at the duplicate now points here too
+	if _, err := d.tx.Exec(`INSERT INTO user_links (consumer_key, lti_id, user_id, created_at) VALUES ('', 'older', ?, ?)`, from.ID, d.now); err != nil {
+		t.Fatalf("inserting link: %v", err)
+	}
+
+	if _, _, err := mergeUser(d.tx, user, from, d.now); err != nil {
+		t.Fatalf("mergeUser: %v", err)
+	}
+	expectIDs(t, "account", d.ids(`SELECT id FROM accounts WHERE user_id = ?`, user.ID), acct.ID)
+	expectIDs(t, "links", d.ids(`SELECT user_id FROM user_links ORDER BY lti_id`), user.ID, user.ID)
+}
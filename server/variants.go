@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+// A multi-language problem lists the unique IDs of other problems that
+// are the same exercise in other languages (i.e., with other problem
+// types). The problem set only includes the main problem, and scores
+// and commits are recorded against it, but each student picks one of
+// the variants when first downloading the problem and the steps of that
+// variant are used from then on, so it is graded with the matching
+// problem type and image.
+
+// getProblemVariant returns the problem whose steps a student should
+// see for a problem in an assignment, along with those steps.
+func getProblemVariant(tx *sql.Tx, assignment *Assignment, problem *Problem) (*Problem, []*ProblemStep, error) {
+	variant := problem
+	if unique := assignment.Variants[problem.Unique]; unique != "" && unique != problem.Unique {
+		if !isProblemVariant(problem, unique) {
+			return nil, nil, fmt.Errorf("%s is not a variant of problem %s", unique, problem.Unique)
+		}
+		variant = new(Problem)
+		if err := meddler.QueryRow(tx, variant, `SELECT * FROM problems WHERE unique_id = ? AND deleted_at IS NULL`, unique); err != nil {
+			return nil, nil, fmt.Errorf("db error loading variant %s of problem %s: %v", unique, problem.Unique, err)
+		}
+	}
+	steps := []*ProblemStep{}
+	if err := meddler.QueryAll(tx, &steps, `SELECT * FROM problem_steps WHERE problem_id = ? ORDER BY step`, variant.ID); err != nil {
+		return nil, nil, fmt.Errorf("db error: %v", err)
+	}
+	if len(steps) == 0 {
+		return nil, nil, fmt.Errorf("no steps found for problem %s (%d)", variant.Unique, variant.ID)
+	}
+	return variant, steps, nil
+}
+
+func isProblemVariant(problem *Problem, unique string) bool {
+	for _, elt := range problem.Variants {
+		if elt == unique {
+			return true
+		}
+	}
+	return false
+}
+
+// checkProblemVariants makes sure every variant listed by a problem
+// exists and has the same number of steps, so scores line up no matter
+// which variant a student chooses.
+func checkProblemVariants(tx *sql.Tx, problem *Problem, steps []*ProblemStep) error {
+	for _, unique := range problem.Variants {
+		var id, count int64
+		if err := tx.QueryRow(`SELECT id FROM problems WHERE unique_id = ? AND deleted_at IS NULL`, unique).Scan(&id); err == sql.ErrNoRows {
+			return fmt.Errorf("variant %s not found; it must be created before problem %s", unique, problem.Unique)
+		} else if err != nil {
+			return fmt.Errorf("db error: %v", err)
+		}
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM problem_steps WHERE problem_id = ?`, id).Scan(&count); err != nil {
+			return fmt.Errorf("db error: %v", err)
+		}
+		if count != int64(len(steps)) {
+			return fmt.Errorf("variant %s has %d steps, but problem %s has %d", unique, count, problem.Unique, len(steps))
+		}
+	}
+	return nil
+}
+
+// PutAssignmentProblemVariant handles requests to
+// /v2/assignments/:assignment_id/problems/:problem_id/variant,
+// recording which variant of a multi-language problem the student
+// will work on. The choice cannot be changed once work has started.
+func PutAssignmentProblemVariant(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, choice ProblemVariantChoice, render render.Render) {
+	now := time.Now()
+
+	assignmentID, err := parseID(w, "assignment_id", params["assignment_id"])
+	if err != nil {
+		return
+	}
+	problemID, err := parseID(w, "problem_id", params["problem_id"])
+	if err != nil {
+		return
+	}
+
+	assignment := new(Assignment)
+	if err := meddler.QueryRow(tx, assignment, `SELECT * FROM assignments WHERE id = ? AND user_id = ?`, assignmentID, currentUser.ID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	problem := new(Problem)
+	if err := meddler.QueryRow(tx, problem, `SELECT problems.* FROM problems `+
+		`JOIN problem_set_problems ON problems.id = problem_set_problems.problem_id `+
+		`WHERE problem_set_problems.problem_set_id = ? AND problems.id = ?`,
+		assignment.ProblemSetID, problemID); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	if choice.Unique != problem.Unique && !isProblemVariant(problem, choice.Unique) {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "%s is not a variant of problem %s", choice.Unique, problem.Unique)
+		return
+	}
+
+	old := assignment.Variants[problem.Unique]
+	if old == choice.Unique {
+		render.JSON(http.StatusOK, assignment)
+		return
+	}
+	var count int64
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM commits WHERE assignment_id = ? AND problem_id = ?`, assignment.ID, problem.ID).Scan(&count); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	if count > 0 {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "the variant of problem %s cannot be changed after work on it has started", problem.Unique)
+		return
+	}
+
+	if assignment.Variants == nil {
+		assignment.Variants = make(map[string]string)
+	}
+	assignment.Variants[problem.Unique] = choice.Unique
+	assignment.UpdatedAt = now
+	if err := meddler.Save(tx, "assignments", assignment); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	render.JSON(http.StatusOK, assignment)
+}
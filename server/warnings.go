@@ -0,0 +1,281 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+	"github.com/russross/meddler"
+)
+
+const (
+	// a student is flagged for attempts if they have at least warningMinAttempts
+	// graded attempts and more than warningAttemptsFactor times the class median
+	warningMinAttempts    = 10
+	warningAttemptsFactor = 3.0
+
+	// a student who has started is flagged if they have not worked on an
+	// unfinished assignment for this long
+	warningGap = 7 * 24 * time.Hour
+
+	// a student is flagged if they have not started and the deadline is this close
+	warningDeadline = 48 * time.Hour
+
+	// the nightly warnings job runs at this hour (local time)
+	warningHour = 3
+
+	warningsJobName = "warnings"
+)
+
+// GetAssignmentWarnings handles requests to /v2/assignments/:assignment_id/warnings,
+// returning the students flagged as struggling with this assignment
+// by the most recent nightly check.
+func GetAssignmentWarnings(w http.ResponseWriter, tx *sql.Tx, params martini.Params, currentUser *User, render render.Render) {
+	assignment, ok := getInstructorAssignment(w, tx, params, currentUser)
+	if !ok {
+		return
+	}
+
+	warnings := []*StudentWarning{}
+	if err := meddler.QueryAll(tx, &warnings, `SELECT * FROM student_warnings WHERE course_id = ? AND lti_id = ? ORDER BY kind, assignment_id`,
+		assignment.CourseID, assignment.LtiID); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+
+	render.JSON(http.StatusOK, warnings)
+}
+
+// scheduleWarnings runs the warnings job once a day for the life of the server.
+func scheduleWarnings() {
+	for {
+		now := time.Now()
+		next := time.Date(now.Year(), now.Month(), now.Day(), warningHour, 0, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		time.Sleep(next.Sub(now))
+
+		if _, err := jobs.Start(warningsJobName, runWarnings); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+}
+
+type warningGroup struct {
+	courseID    int64
+	ltiID       string
+	assignments []*Assignment
+}
+
+// runWarnings recomputes the warnings for every open assignment
+// and emails a digest to instructors if configured.
+func runWarnings() error {
+	now := time.Now()
+
+	var warnings []*StudentWarning
+	instructors := make(map[int64][]string)
+	err := withBackgroundTx(func(tx *sql.Tx) error {
+		var assignments []*Assignment
+		if err := meddler.QueryAll(tx, &assignments, `SELECT * FROM assignments WHERE NOT instructor AND problem_set_id IS NOT NULL`); err != nil {
+			return err
+		}
+
+		// graded attempts and latest activity per assignment
+		attempts := make(map[int64]int)
+		rows, err := tx.Query(`SELECT assignment_id, COUNT(1) FROM commit_history WHERE report_card != 'null' GROUP BY assignment_id`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			var count int
+			if err := rows.Scan(&id, &count); err != nil {
+				rows.Close()
+				return err
+			}
+			attempts[id] = count
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		latest := make(map[int64]time.Time)
+		rows, err = tx.Query(`SELECT assignment_id, updated_at FROM commits`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			var updatedAt time.Time
+			if err := rows.Scan(&id, &updatedAt); err != nil {
+				rows.Close()
+				return err
+			}
+			if updatedAt.After(latest[id]) {
+				latest[id] = updatedAt
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// group open assignments by course and canvas assignment
+		groups := make(map[string]*warningGroup)
+		for _, asst := range assignments {
+			if asst.UnlockAt != nil && asst.UnlockAt.After(now) || asst.LockAt != nil && asst.LockAt.Before(now) {
+				continue
+			}
+			if asst.DueAt != nil && asst.DueAt.Before(now) {
+				continue
+			}
+			key := fmt.Sprintf("%d-%s", asst.CourseID, asst.LtiID)
+			group, exists := groups[key]
+			if !exists {
+				group = &warningGroup{courseID: asst.CourseID, ltiID: asst.LtiID}
+				groups[key] = group
+			}
+			group.assignments = append(group.assignments, asst)
+		}
+
+		for _, group := range groups {
+			warnings = append(warnings, groupWarnings(group, attempts, latest, now)...)
+		}
+
+		// replace the old warnings
+		if _, err := tx.Exec(`DELETE FROM student_warnings`); err != nil {
+			return err
+		}
+		for _, elt := range warnings {
+			if err := meddler.Insert(tx, "student_warnings", elt); err != nil {
+				return err
+			}
+		}
+
+		// find the instructors to notify
+		if Config.WarningDigest && len(warnings) > 0 {
+			rows, err := tx.Query(`SELECT DISTINCT assignments.course_id, users.email FROM assignments ` +
+				`JOIN users ON assignments.user_id = users.id ` +
+				`WHERE assignments.instructor AND users.email != ''`)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var courseID int64
+				var email string
+				if err := rows.Scan(&courseID, &email); err != nil {
+					return err
+				}
+				instructors[courseID] = append(instructors[courseID], email)
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if Config.WarningDigest {
+		sendWarningDigests(warnings, instructors)
+	}
+	return nil
+}
+
+// groupWarnings flags the struggling students in one group of assignments.
+func groupWarnings(group *warningGroup, attempts map[int64]int, latest map[int64]time.Time, now time.Time) []*StudentWarning {
+	// median attempts among students who have started
+	var counts []int
+	for _, asst := range group.assignments {
+		if n := attempts[asst.ID]; n > 0 {
+			counts = append(counts, n)
+		}
+	}
+	sort.Ints(counts)
+	median := 0
+	if len(counts) > 0 {
+		median = counts[len(counts)/2]
+	}
+
+	var warnings []*StudentWarning
+	flag := func(asst *Assignment, kind, detail string) {
+		warnings = append(warnings, &StudentWarning{
+			CourseID:     group.courseID,
+			LtiID:        group.ltiID,
+			AssignmentID: asst.ID,
+			UserID:       asst.UserID,
+			Kind:         kind,
+			Detail:       detail,
+			CreatedAt:    now,
+		})
+	}
+	for _, asst := range group.assignments {
+		if asst.Score >= 1.0 {
+			continue
+		}
+		n := attempts[asst.ID]
+		last, started := latest[asst.ID]
+		switch {
+		case !started && asst.DueAt != nil && asst.DueAt.Sub(now) < warningDeadline:
+			flag(asst, "inactive", fmt.Sprintf("no activity and due %s", asst.DueAt.Format("Mon Jan 2 15:04")))
+		case started && now.Sub(last) > warningGap:
+			flag(asst, "gap", fmt.Sprintf("no activity for %d days with score %.0f%%", int(now.Sub(last).Hours()/24), asst.Score*100.0))
+		case n >= warningMinAttempts && float64(n) > warningAttemptsFactor*float64(median):
+			flag(asst, "attempts", fmt.Sprintf("%d graded attempts (class median %d) with score %.0f%%", n, median, asst.Score*100.0))
+		}
+	}
+	return warnings
+}
+
+// sendWarningDigests emails each course's instructors the list of flagged students.
+func sendWarningDigests(warnings []*StudentWarning, instructors map[int64][]string) {
+	byCourse := make(map[int64][]*StudentWarning)
+	for _, elt := range warnings {
+		byCourse[elt.CourseID] = append(byCourse[elt.CourseID], elt)
+	}
+
+	for courseID, list := range byCourse {
+		to := instructors[courseID]
+		if len(to) == 0 {
+			continue
+		}
+		var users map[int64]string
+		var titles map[int64]string
+		err := withBackgroundTx(func(tx *sql.Tx) error {
+			users, titles = make(map[int64]string), make(map[int64]string)
+			for _, elt := range list {
+				var name, title string
+				if err := tx.QueryRow(`SELECT users.name, assignments.canvas_title FROM assignments JOIN users ON assignments.user_id = users.id WHERE assignments.id = ?`,
+					elt.AssignmentID).Scan(&name, &title); err != nil {
+					return err
+				}
+				users[elt.UserID], titles[elt.AssignmentID] = name, title
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("gathering warning digest for course %d: %v", courseID, err)
+			continue
+		}
+
+		body := new(strings.Builder)
+		fmt.Fprintf(body, "The following students may need help:\n\n")
+		for _, elt := range list {
+			fmt.Fprintf(body, "* %s, %s: %s\n", users[elt.UserID], titles[elt.AssignmentID], elt.Detail)
+		}
+		if err := sendEmail(to, fmt.Sprintf("%s: %d students may need help", Config.ToolName, len(list)), body.String()); err != nil {
+			log.Printf("sending warning digest for course %d: %v", courseID, err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// A problem with the workspace option, e.g., workspace=build, keeps that
+// directory (relative to the student's home directory) on the daycare
+// between actions and steps, so large build products do not have to be
+// rebuilt from scratch every time. Each user has one workspace per
+// problem, capped at workspaceSize megabytes. While an action runs the
+// workspace is a tmpfs of that size inside the container; afterward it
+// is saved as a tar file on the daycare, and a workspace that will not
+// fit is dropped instead. Workspaces that have not been used recently are
+// removed, and a client can ask for a fresh one by passing
+// clearWorkspace=true when opening the socket.
+
+const (
+	workspaceDefaultSize = 200
+	workspaceMaxSize     = 2048
+	workspaceExpiry      = 14 * 24 * time.Hour
+	workspaceSweep       = time.Hour
+)
+
+var errWorkspaceTooLarge = errors.New("workspace is larger than its limit")
+
+// prepareWorkspace finds the saved workspace for a user and problem,
+// returning the path of its tar file on the daycare.
+func prepareWorkspace(limits *limits, userID, problemID int64, clear bool) (string, error) {
+	clean := path.Clean(limits.workspace)
+	if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("workspace must be a directory in the problem, found %q", limits.workspace)
+	}
+	limits.workspace = clean
+	if limits.workspaceSize <= 0 {
+		limits.workspaceSize = workspaceDefaultSize
+	}
+	if limits.workspaceSize > workspaceMaxSize {
+		limits.workspaceSize = workspaceMaxSize
+	}
+
+	if err := os.MkdirAll(Config.WorkspaceCache, 0700); err != nil {
+		return "", err
+	}
+	saved := filepath.Join(Config.WorkspaceCache, fmt.Sprintf("%d-%d.tar", userID, problemID))
+	if clear {
+		log.Printf("clearing workspace %s on request", saved)
+		if err := os.Remove(saved); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	now := time.Now()
+	if err := os.Chtimes(saved, now, now); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return saved, nil
+}
+
+// restoreWorkspace unpacks the saved workspace, if any, into the container.
+// A saved workspace that cannot be restored is removed.
+func restoreWorkspace(n *Nanny, limits *limits) error {
+	fp, err := os.Open(limits.workspaceHost)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	out := new(bytes.Buffer)
+	err = workspaceTar(n, []string{"/bin/tar", "xf", "-", "-C", "/home/student/" + limits.workspace}, fp, out, out)
+	if err == nil && out.Len() != 0 {
+		err = fmt.Errorf("tar gave non-empty output: %q", out.String())
+	}
+	if err != nil {
+		os.Remove(limits.workspaceHost)
+	}
+	return err
+}
+
+// saveWorkspace copies the workspace out of the container. The tar file
+// is written under a temporary name and only replaces the saved copy if
+// it is complete and within the size limit; otherwise the workspace is
+// dropped so the next action starts fresh.
+func saveWorkspace(n *Nanny, limits *limits) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(limits.workspaceHost), filepath.Base(limits.workspaceHost)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	out := &limitedWriter{w: tmp, remaining: limits.workspaceSize * 1024 * 1024}
+	errOut := new(bytes.Buffer)
+	err = workspaceTar(n, []string{"/bin/tar", "cf", "-", "-C", "/home/student/" + limits.workspace, "."}, nil, out, errOut)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if out.overflow {
+		err = errWorkspaceTooLarge
+	}
+	if err == nil && errOut.Len() != 0 {
+		err = fmt.Errorf("tar gave non-empty error output: %q", errOut.String())
+	}
+	if err != nil {
+		os.Remove(limits.workspaceHost)
+		return err
+	}
+	return os.Rename(tmp.Name(), limits.workspaceHost)
+}
+
+// workspaceTar runs tar in the container as the student,
+// streaming its input and output.
+func workspaceTar(n *Nanny, cmd []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	exec, err := dockerClient.CreateExec(docker.CreateExecOptions{
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          false,
+		Cmd:          cmd,
+		Container:    n.Container.ID,
+		User:         uidgid(n.UID),
+	})
+	if err != nil {
+		return err
+	}
+	return dockerClient.StartExec(exec.ID, docker.StartExecOptions{
+		Detach:       false,
+		Tty:          false,
+		InputStream:  stdin,
+		OutputStream: stdout,
+		ErrorStream:  stderr,
+		RawTerminal:  false,
+	})
+}
+
+// limitedWriter passes along at most remaining bytes,
+// failing and noting the overflow once the limit is passed.
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+	overflow  bool
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > lw.remaining {
+		lw.overflow = true
+		return 0, errWorkspaceTooLarge
+	}
+	lw.remaining -= int64(len(p))
+	return lw.w.Write(p)
+}
+
+// expireWorkspaces periodically removes workspaces that have not been used recently.
+func expireWorkspaces() {
+	for {
+		entries, err := ioutil.ReadDir(Config.WorkspaceCache)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("expiring workspaces: %v", err)
+		}
+		for _, elt := range entries {
+			if time.Since(elt.ModTime()) < workspaceExpiry {
+				continue
+			}
+			name := filepath.Join(Config.WorkspaceCache, elt.Name())
+			if err := os.RemoveAll(name); err != nil {
+				log.Printf("expiring workspace %s: %v", name, err)
+			}
+		}
+		time.Sleep(workspaceSweep)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -79,6 +80,7 @@ func runAndParseXUnit(n *Nanny, cmd []string) {
 	// did it end in a segfault?
 	if status > 127 {
 		n.ReportCard.LogAndFailf("Crashed with exit status %d while running unit tests", status)
+		n.ReportCard.AddResult(strings.Join(cmd, " "), exitOutcome(status), fmt.Sprintf("exit status %d", status), "")
 		return
 	}
 	n.ReportCard.Passed = status == 0
@@ -102,17 +104,27 @@ func parseXUnit(n *Nanny, contents []byte) {
 		return
 	}
 
+	results, err := decodeXUnit(contents)
+	if err != nil {
+		n.ReportCard.LogAndFailf("error parsing unit test results: %v", err)
+		return
+	}
+	reportXUnit(n, results)
+}
+
+func decodeXUnit(contents []byte) (*XUnitProgram, error) {
 	results := new(XUnitProgram)
 	if err := xml.Unmarshal(contents, results); err != nil {
 		// try parsing as a list of testsuite into the outer container
 		results.Suites = nil
-		err := xml.Unmarshal(contents, &results.Suites)
-		if err != nil {
-			n.ReportCard.LogAndFailf("error parsing unit test results: %v", err)
-			return
+		if err := xml.Unmarshal(contents, &results.Suites); err != nil {
+			return nil, err
 		}
 	}
+	return results, nil
+}
 
+func reportXUnit(n *Nanny, results *XUnitProgram) {
 	// build summary results
 	results.Tests = 0
 	results.Failures = 0
@@ -132,7 +144,7 @@ func parseXUnit(n *Nanny, contents []byte) {
 
 	// form a report card
 	fails := results.Failures + results.Disabled + results.Skipped + results.Errors
-	n.ReportCard.Note = fmt.Sprintf("Passed %d/%d tests in %v",
+	n.ReportCard.Note = localize(n.Locale, "Passed %d/%d tests in %v",
 		results.Tests-fails, results.Tests, time.Since(n.Start))
 	n.ReportCard.Passed = n.ReportCard.Passed && results.Tests > 0 && fails == 0
 
@@ -150,15 +162,19 @@ func parseXUnit(n *Nanny, contents []byte) {
 				testCase.Skipped == nil {
 				n.ReportCard.AddPassedResult(name, "")
 			} else {
-				body := ""
+				body, message := "", ""
 				if testCase.Failure != nil {
-					body = testCase.Failure.Body
+					body, message = testCase.Failure.Body, testCase.Failure.Message
 				} else if testCase.Error != nil {
-					body = testCase.Error.Body
+					body, message = testCase.Error.Body, testCase.Error.Message
 				} else if testCase.Disabled != nil {
-					body = testCase.Disabled.Body
+					body, message = testCase.Disabled.Body, testCase.Disabled.Message
 				} else if testCase.Skipped != nil {
-					body = testCase.Skipped.Body
+					body, message = testCase.Skipped.Body, testCase.Skipped.Message
+				}
+				if strings.TrimSpace(body) == "" {
+					// some frameworks (e.g., tasty) only give a message attribute
+					body = message
 				}
 
 				// try to parse context
@@ -168,12 +184,24 @@ func parseXUnit(n *Nanny, contents []byte) {
 				} else if groups := testFailureContextPython.FindStringSubmatch(body); len(groups) > 1 {
 					ctx = groups[1] + ":" + groups[2]
 				}
-				n.ReportCard.AddFailedResult(name, body, ctx)
+				outcome := "failed"
+				if testCase.Failure != nil && isTimeout(testCase.Failure.Type, testCase.Failure.Message) ||
+					testCase.Error != nil && isTimeout(testCase.Error.Type, testCase.Error.Message) {
+					outcome = "timeout"
+				}
+				n.ReportCard.AddResult(name, outcome, body, ctx)
 			}
 		}
 	}
 }
 
+// isTimeout reports whether a test failure was caused by a timeout,
+// judging by the type and message the test framework gave it.
+func isTimeout(kind, message string) bool {
+	text := strings.ToLower(kind + " " + message)
+	return strings.Contains(text, "timeout") || strings.Contains(text, "timed out")
+}
+
 // check XML types
 type CheckXMLProgram struct {
 	XMLName   xml.Name         `xml:"testsuites"`
@@ -212,6 +240,7 @@ func runAndParseCheckXML(n *Nanny, cmd []string) {
 	// did it end in a segfault?
 	if status > 127 {
 		n.ReportCard.LogAndFailf("Crashed with exit status %d while running unit tests", status)
+		n.ReportCard.AddResult(strings.Join(cmd, " "), exitOutcome(status), fmt.Sprintf("exit status %d", status), "")
 		return
 	}
 	n.ReportCard.Passed = status == 0
@@ -249,8 +278,13 @@ func parseCheckXML(n *Nanny, contents []byte) {
 				failures++
 				n.ReportCard.AddFailedResult(test.ID, test.Message, test.Function)
 			case "error":
+				// check reports tests that crash or time out as errors
 				errors++
-				n.ReportCard.AddFailedResult(test.ID, test.Message, test.Function)
+				outcome := "crash"
+				if isTimeout("", test.Message) {
+					outcome = "timeout"
+				}
+				n.ReportCard.AddResult(test.ID, outcome, test.Message, test.Function)
 			default:
 				errors++
 				n.ReportCard.AddFailedResult(test.ID, test.Message, test.Function)
@@ -261,8 +295,8 @@ func parseCheckXML(n *Nanny, contents []byte) {
 	// form a report card
 	n.ReportCard.Passed = successes > 0 && failures == 0 && errors == 0
 	if successes+failures+errors < 1 {
-		n.ReportCard.Note = fmt.Sprintf("No test results found in %v", time.Since(n.Start))
+		n.ReportCard.Note = localize(n.Locale, "No test results found in %v", time.Since(n.Start))
 	} else {
-		n.ReportCard.Note = fmt.Sprintf("Passed %d/%d tests in %v", successes, successes+failures+errors, time.Since(n.Start))
+		n.ReportCard.Note = localize(n.Locale, "Passed %d/%d tests in %v", successes, successes+failures+errors, time.Since(n.Start))
 	}
 }
@@ -20,6 +20,12 @@ INSERT INTO problem_type_actions (problem_type, action, command, parser, message
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('arm64unittest', 'run', 'make run', NULL, 'Running‥', 1, 60, 1800, 300, 100, 10, 256, 20);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('arm64unittest', 'valgrind', 'make valgrind', NULL, 'Running valgrind‥', 1, 60, 120, 120, 100, 10, 256, 20);
 
+INSERT INTO problem_types (name, image) VALUES ('cppgtest', 'codegrinder/cpp');
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('cppgtest', 'grade', 'make grade', 'gtest', 'Grading‥', 0, 60, 120, 120, 100, 20, 256, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('cppgtest', 'test', 'make test', NULL, 'Testing‥', 0, 60, 120, 120, 100, 20, 256, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('cppgtest', 'debug', 'make debug', NULL, 'Running gdb‥', 1, 60, 1800, 300, 100, 20, 256, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('cppgtest', 'run', 'make run', NULL, 'Running‥', 1, 60, 1800, 300, 100, 20, 256, 200);
+
 INSERT INTO problem_types (name, image) VALUES ('cppunittest', 'codegrinder/cpp');
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('cppunittest', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 60, 120, 120, 100, 20, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('cppunittest', 'test', 'make test', NULL, 'Testing‥', 0, 60, 120, 120, 100, 20, 256, 200);
@@ -42,16 +48,14 @@ INSERT INTO problem_type_actions (problem_type, action, command, parser, message
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('cunittest', 'run', 'make run', NULL, 'Running‥', 1, 60, 1800, 300, 100, 10, 256, 20);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('cunittest', 'valgrind', 'make valgrind', NULL, 'Running valgrind‥', 1, 60, 120, 120, 100, 10, 256, 20);
 
-INSERT INTO problem_types (name, image) VALUES ('forthinout', 'codegrinder/forth');
-INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('forthinout', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 10, 20, 20, 100, 10, 256, 50);
-INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('forthinout', 'test', 'make test', NULL, 'Testing‥', 0, 10, 20, 20, 100, 10, 256, 50);
-INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('forthinout', 'step', 'make step', NULL, 'Stepping‥', 0, 10, 1800, 300, 100, 10, 256, 50);
-INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('forthinout', 'run', 'make run', NULL, 'Running‥', 1, 10, 1800, 300, 100, 10, 256, 50);
-INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('forthinout', 'shell', 'make shell', NULL, 'Running gforth shell‥', 1, 10, 1800, 300, 100, 10, 256, 50);
+INSERT INTO problem_types (name, image) VALUES ('gobench', 'codegrinder/go');
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('gobench', 'grade', 'make grade', 'gobench', 'Benchmarking‥', 0, 120, 240, 240, 200, 10, 512, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('gobench', 'test', 'make test', NULL, 'Benchmarking‥', 0, 120, 240, 240, 200, 10, 512, 200);
 
 INSERT INTO problem_types (name, image) VALUES ('gounittest', 'codegrinder/go');
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('gounittest', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 10, 20, 20, 200, 10, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('gounittest', 'test', 'make test', NULL, 'Testing‥', 0, 10, 20, 20, 200, 10, 256, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('gounittest', 'debug', 'make debug', NULL, 'Running dlv‥', 1, 60, 1800, 300, 200, 10, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('gounittest', 'run', 'make run', NULL, 'Running‥', 1, 10, 1800, 300, 200, 10, 256, 200);
 
 INSERT INTO problem_types (name, image) VALUES ('goinout', 'codegrinder/go');
@@ -59,16 +63,43 @@ INSERT INTO problem_type_actions (problem_type, action, command, parser, message
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('goinout', 'test', 'make test', NULL, 'Testing‥', 0, 10, 20, 20, 200, 20, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('goinout', 'step', 'make step', NULL, 'Stepping‥', 0, 10, 20, 20, 200, 20, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('goinout', 'run', 'make run', NULL, 'Running‥', 1, 10, 600, 60, 200, 20, 256, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('goinout', 'debug', 'make debug', NULL, 'Running dlv‥', 1, 60, 1800, 300, 200, 20, 256, 200);
+
+INSERT INTO problem_types (name, image) VALUES ('haskelltest', 'codegrinder/haskell');
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('haskelltest', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 120, 180, 180, 100, 50, 1024, 50);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('haskelltest', 'test', 'make test', NULL, 'Testing‥', 0, 120, 180, 180, 100, 50, 1024, 50);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('haskelltest', 'run', 'make run', NULL, 'Running‥', 1, 60, 1800, 300, 100, 50, 1024, 50);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('haskelltest', 'shell', 'make shell', NULL, 'Running ghci‥', 1, 60, 1800, 300, 100, 50, 1024, 50);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('haskelltest', 'repl', 'make repl', NULL, 'Running ghci‥', 1, 60, 1800, 300, 100, 50, 1024, 50);
+
+INSERT INTO problem_types (name, image) VALUES ('iodiff', 'codegrinder/cpp');
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('iodiff', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 60, 120, 120, 100, 10, 256, 30);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('iodiff', 'test', 'make test', NULL, 'Testing‥', 0, 60, 120, 120, 100, 10, 256, 30);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('iodiff', 'run', 'make run', NULL, 'Running‥', 1, 60, 1800, 300, 100, 10, 256, 30);
+
+INSERT INTO problem_types (name, image) VALUES ('javajunit', 'codegrinder/java');
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('javajunit', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 60, 120, 120, 200, 20, 1024, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('javajunit', 'test', 'make test', NULL, 'Testing‥', 0, 60, 120, 120, 200, 20, 1024, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('javajunit', 'debug', 'make debug', NULL, 'Running jdb‥', 1, 60, 1800, 300, 200, 20, 1024, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('javajunit', 'run', 'make run', NULL, 'Running‥', 1, 60, 1800, 300, 200, 20, 1024, 200);
 
 INSERT INTO problem_types (name, image) VALUES ('nand2tetris', 'codegrinder/nand2tetris');
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('nand2tetris', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 20, 20, 20, 100, 10, 1024, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('nand2tetris', 'test', 'make test', NULL, 'Testing‥', 0, 20, 20, 20, 100, 10, 1024, 200);
 
+INSERT INTO problem_types (name, image) VALUES ('nodetest', 'codegrinder/node');
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('nodetest', 'grade', 'make grade', 'jstest', 'Grading‥', 0, 60, 120, 120, 200, 20, 512, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('nodetest', 'test', 'make test', NULL, 'Testing‥', 0, 60, 120, 120, 200, 20, 512, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('nodetest', 'debug', 'make debug', NULL, 'Running node inspect‥', 1, 60, 1800, 300, 200, 20, 512, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('nodetest', 'run', 'make run', NULL, 'Running‥', 1, 60, 1800, 300, 200, 20, 512, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('nodetest', 'repl', 'make repl', NULL, 'Running node REPL‥', 1, 60, 1800, 300, 200, 20, 512, 200);
+
 INSERT INTO problem_types (name, image) VALUES ('prologunittest', 'codegrinder/prolog');
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('prologunittest', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 10, 20, 20, 100, 10, 256, 20);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('prologunittest', 'test', 'make test', NULL, 'Testing‥', 0, 10, 20, 20, 100, 10, 256, 20);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('prologunittest', 'run', 'make run', NULL, 'Running‥', 1, 10, 1800, 300, 100, 10, 256, 20);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('prologunittest', 'shell', 'make shell', NULL, 'Running Prolog shell‥', 1, 10, 1800, 300, 100, 10, 256, 20);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('prologunittest', 'repl', 'make repl', NULL, 'Running Prolog REPL‥', 1, 10, 1800, 300, 100, 10, 256, 20);
 
 INSERT INTO problem_types (name, image) VALUES ('python3inout', 'codegrinder/python');
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('python3inout', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 60, 120, 120, 100, 10, 256, 30);
@@ -78,6 +109,7 @@ INSERT INTO problem_type_actions (problem_type, action, command, parser, message
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('python3inout', 'debug', 'make debug', NULL, 'Running debugger‥', 1, 60, 1800, 300, 100, 10, 256, 30);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('python3inout', 'run', 'make run', NULL, 'Running‥', 1, 60, 1800, 300, 100, 10, 256, 30);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('python3inout', 'shell', 'make shell', NULL, 'Running Python shell‥', 1, 60, 1800, 300, 100, 10, 256, 30);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('python3inout', 'repl', 'make repl', NULL, 'Running Python REPL‥', 1, 60, 1800, 300, 100, 10, 256, 30);
 
 INSERT INTO problem_types (name, image) VALUES ('python3unittest', 'codegrinder/python');
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('python3unittest', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 60, 120, 120, 100, 10, 256, 30);
@@ -86,6 +118,14 @@ INSERT INTO problem_type_actions (problem_type, action, command, parser, message
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('python3unittest', 'debug', 'make debug', NULL, 'Running debugger‥', 1, 60, 1800, 300, 100, 10, 256, 30);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('python3unittest', 'run', 'make run', NULL, 'Running‥', 1, 60, 1800, 300, 100, 10, 256, 30);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('python3unittest', 'shell', 'make shell', NULL, 'Running Python shell‥', 1, 60, 1800, 300, 100, 10, 256, 30);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('python3unittest', 'repl', 'make repl', NULL, 'Running Python REPL‥', 1, 60, 1800, 300, 100, 10, 256, 30);
+
+INSERT INTO problem_types (name, image) VALUES ('rackettest', 'codegrinder/racket');
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rackettest', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 60, 120, 120, 100, 10, 512, 20);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rackettest', 'test', 'make test', NULL, 'Testing‥', 0, 60, 120, 120, 100, 10, 512, 20);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rackettest', 'run', 'make run', NULL, 'Running‥', 1, 60, 1800, 300, 100, 10, 512, 20);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rackettest', 'shell', 'make shell', NULL, 'Running racket‥', 1, 60, 1800, 300, 100, 10, 512, 20);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rackettest', 'repl', 'make repl', NULL, 'Running racket REPL‥', 1, 60, 1800, 300, 100, 10, 512, 20);
 
 INSERT INTO problem_types (name, image) VALUES ('rv64inout', 'codegrinder/riscv');
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rv64inout', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 60, 120, 120, 100, 10, 256, 20);
@@ -94,30 +134,62 @@ INSERT INTO problem_type_actions (problem_type, action, command, parser, message
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rv64inout', 'debug', 'make debug', NULL, 'Running gdb‥', 1, 60, 1800, 300, 100, 10, 256, 20);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rv64inout', 'run', 'make run', NULL, 'Running‥', 1, 60, 1800, 300, 100, 10, 256, 20);
 
+INSERT INTO problem_types (name, image) VALUES ('rv64statetest', 'codegrinder/riscv');
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rv64statetest', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 60, 120, 120, 100, 10, 256, 20);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rv64statetest', 'test', 'make test', NULL, 'Testing‥', 0, 60, 120, 120, 100, 10, 256, 20);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rv64statetest', 'run', 'make run', NULL, 'Running‥', 1, 60, 1800, 300, 100, 10, 256, 20);
+
+INSERT INTO problem_types (name, image) VALUES ('shelltest', 'codegrinder/shell');
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('shelltest', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 60, 120, 120, 100, 10, 256, 50);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('shelltest', 'test', 'make test', NULL, 'Testing‥', 0, 60, 120, 120, 100, 10, 256, 50);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('shelltest', 'run', 'make run', NULL, 'Running‥', 1, 60, 1800, 300, 100, 10, 256, 50);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('shelltest', 'shell', 'make shell', NULL, 'Running bash‥', 1, 60, 1800, 300, 100, 10, 256, 50);
+
 INSERT INTO problem_types (name, image) VALUES ('sqliteinout', 'codegrinder/sqlite');
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('sqliteinout', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 60, 120, 120, 100, 1000, 256, 20);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('sqliteinout', 'test', 'make test', NULL, 'Testing‥', 0, 60, 120, 120, 100, 1000, 256, 20);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('sqliteinout', 'step', 'make step', NULL, 'Stepping‥', 0, 60, 1800, 300, 100, 1000, 256, 20);
 
+INSERT INTO problem_types (name, image) VALUES ('sqltest', 'codegrinder/sql');
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('sqltest', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 60, 120, 120, 200, 1000, 512, 50);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('sqltest', 'test', 'make test', NULL, 'Testing‥', 0, 60, 120, 120, 200, 1000, 512, 50);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('sqltest', 'shell', 'make shell', NULL, 'Running SQL shell‥', 1, 60, 1800, 300, 200, 1000, 512, 50);
+
 INSERT INTO problem_types (name, image) VALUES ('standardmlinout', 'codegrinder/standardml');
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('standardmlinout', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 10, 20, 20, 100, 10, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('standardmlinout', 'test', 'make test', NULL, 'Testing‥', 0, 10, 20, 20, 100, 10, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('standardmlinout', 'step', 'make step', NULL, 'Stepping‥', 0, 10, 1800, 300, 100, 10, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('standardmlinout', 'run', 'make run', NULL, 'Running‥', 1, 10, 1800, 300, 100, 10, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('standardmlinout', 'shell', 'make shell', NULL, 'Running PolyML shell‥', 1, 10, 1800, 300, 100, 10, 256, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('standardmlinout', 'repl', 'make repl', NULL, 'Running PolyML REPL‥', 1, 10, 1800, 300, 100, 10, 256, 200);
 
 INSERT INTO problem_types (name, image) VALUES ('standardmlunittest', 'codegrinder/standardml');
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('standardmlunittest', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 10, 20, 20, 100, 10, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('standardmlunittest', 'test', 'make test', NULL, 'Testing‥', 0, 10, 20, 20, 100, 10, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('standardmlunittest', 'run', 'make run', NULL, 'Running‥', 1, 10, 1800, 300, 100, 10, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('standardmlunittest', 'shell', 'make shell', NULL, 'Running PolyML shell‥', 1, 10, 1800, 300, 100, 10, 256, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('standardmlunittest', 'repl', 'make repl', NULL, 'Running PolyML REPL‥', 1, 10, 1800, 300, 100, 10, 256, 200);
+
+INSERT INTO problem_types (name, image) VALUES ('webtest', 'codegrinder/web');
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('webtest', 'grade', 'make grade', 'jstest', 'Grading‥', 0, 120, 180, 180, 500, 50, 1024, 500);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('webtest', 'test', 'make test', NULL, 'Testing‥', 0, 120, 180, 180, 500, 50, 1024, 500);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('webtest', 'run', 'make run', NULL, 'Serving‥', 1, 60, 1800, 300, 500, 50, 1024, 500);
 
 INSERT INTO problem_types (name, image) VALUES ('rustinout', 'codegrinder/rust');
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rustinout', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 30, 60, 60, 100, 20, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rustinout', 'test', 'make test', NULL, 'Testing‥', 0, 30, 60, 60, 100, 20, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rustinout', 'step', 'make step', NULL, 'Stepping‥', 0, 30, 60, 60, 100, 20, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rustinout', 'run', 'make run', NULL, 'Running‥', 1, 30, 60, 60, 100, 20, 256, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rustinout', 'debug', 'make debug', NULL, 'Running gdb‥', 1, 30, 1800, 300, 100, 20, 256, 200);
+
+INSERT INTO problem_types (name, image) VALUES ('rusttest', 'codegrinder/rusttest');
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rusttest', 'grade', 'make grade', 'cargotest', 'Grading‥', 0, 30, 60, 60, 100, 20, 256, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rusttest', 'test', 'make test', NULL, 'Testing‥', 0, 30, 60, 60, 100, 20, 256, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rusttest', 'debug', 'make debug', NULL, 'Running gdb‥', 1, 30, 1800, 300, 100, 20, 256, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rusttest', 'run', 'make run', NULL, 'Running‥', 1, 30, 1800, 300, 100, 20, 256, 200);
 
 INSERT INTO problem_types (name, image) VALUES ('rustunittest', 'codegrinder/rust');
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rustunittest', 'grade', 'make grade', 'xunit', 'Grading‥', 0, 30, 60, 60, 100, 20, 256, 200);
 INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rustunittest', 'test', 'make test', NULL, 'Testing‥', 0, 30, 60, 60, 100, 20, 256, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rustunittest', 'debug', 'make debug', NULL, 'Running gdb‥', 1, 30, 1800, 300, 100, 20, 256, 200);
+INSERT INTO problem_type_actions (problem_type, action, command, parser, message, interactive, max_cpu, max_session, max_timeout, max_fd, max_file_size, max_memory, max_threads) VALUES ('rustunittest', 'run', 'make run', NULL, 'Running‥', 1, 30, 1800, 300, 100, 20, 256, 200);
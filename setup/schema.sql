@@ -9,7 +9,7 @@ CREATE TABLE problem_type_actions (
     problem_type            text NOT NULL,
     action                  text NOT NULL,
     command                 text NOT NULL,
-    parser                  text CHECK(parser IS NULL OR parser IN ('xunit', 'check')),
+    parser                  text,
     message                 text NOT NULL,
     interactive             boolean NOT NULL,
 
@@ -31,20 +31,31 @@ CREATE TABLE problems (
     note                    text NOT NULL,
     tags                    text NOT NULL,
     options                 text NOT NULL,
+    variants                text NOT NULL,
     created_at              datetime NOT NULL,
-    updated_at              datetime NOT NULL
+    updated_at              datetime NOT NULL,
+    deleted_at              datetime
 );
 CREATE UNIQUE INDEX problems_unique_id ON problems (unique_id);
 
+CREATE TABLE blobs (
+    hash                    text PRIMARY KEY,
+    contents                blob NOT NULL,
+    created_at              datetime NOT NULL
+);
+
 CREATE TABLE problem_steps (
     problem_id              integer NOT NULL,
     step                    integer NOT NULL,
     problem_type            text NOT NULL,
     note                    text NOT NULL,
     instructions            text NOT NULL,
+    translations            text NOT NULL,
     weight                  real NOT NULL,
     files                   text NOT NULL,
     whitelist               text NOT NULL,
+    binary_files            text NOT NULL,
+    assets                  text NOT NULL,
     solution                text NOT NULL,
 
     PRIMARY KEY (problem_id, step),
@@ -58,8 +69,10 @@ CREATE TABLE problem_sets (
     unique_id               text NOT NULL,
     note                    text NOT NULL,
     tags                    text NOT NULL,
+    public                  boolean NOT NULL,
     created_at              datetime NOT NULL,
-    updated_at              datetime NOT NULL
+    updated_at              datetime NOT NULL,
+    deleted_at              datetime
 );
 CREATE UNIQUE INDEX problem_sets_unique_id ON problem_sets (unique_id);
 
@@ -79,30 +92,135 @@ CREATE TABLE courses (
     name                    text NOT NULL,
     lti_label               text NOT NULL,
     lti_id                  text NOT NULL,
+    consumer_key            text NOT NULL,
     canvas_id               integer NOT NULL,
+    memberships_url         text NOT NULL,
+    roster_synced_at        datetime,
+    created_at              datetime NOT NULL,
+    updated_at              datetime NOT NULL,
+    deleted_at              datetime
+);
+CREATE UNIQUE INDEX courses_lti_id ON courses (consumer_key, lti_id);
+CREATE UNIQUE INDEX courses_canvas_id ON courses (consumer_key, canvas_id);
+
+CREATE TABLE course_limits (
+    id                      integer PRIMARY KEY,
+    course_id               integer NOT NULL,
+    problem_types           text NOT NULL,
+    daily_minutes           integer NOT NULL,
+    user_minutes            integer NOT NULL,
+    signed_commits          boolean NOT NULL,
+    created_at              datetime NOT NULL,
+    updated_at              datetime NOT NULL,
+
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE UNIQUE INDEX course_limits_course_id ON course_limits (course_id);
+
+CREATE TABLE lti_consumers (
+    id                      integer PRIMARY KEY,
+    consumer_key            text NOT NULL,
+    secret                  text NOT NULL,
+    name                    text NOT NULL,
     created_at              datetime NOT NULL,
     updated_at              datetime NOT NULL
 );
-CREATE UNIQUE INDEX courses_lti_id ON courses (lti_id);
-CREATE UNIQUE INDEX courses_canvas_id ON courses (canvas_id);
+CREATE UNIQUE INDEX lti_consumers_consumer_key ON lti_consumers (consumer_key);
 
 CREATE TABLE users (
     id                      integer PRIMARY KEY,
     name                    text NOT NULL,
     email                   text NOT NULL,
     lti_id                  text NOT NULL,
+    consumer_key            text NOT NULL,
     lti_image_url           text,
     canvas_login            text NOT NULL,
-    canvas_id               integer NOT NULL,
+    canvas_id               integer,
     author                  boolean NOT NULL,
     admin                   boolean NOT NULL,
+    locale                  text NOT NULL,
+    notifications           text NOT NULL,
     created_at              datetime NOT NULL,
     updated_at              datetime NOT NULL,
     last_signed_in_at       datetime NOT NULL
 );
-CREATE UNIQUE INDEX users_lti_id ON users (lti_id);
-CREATE UNIQUE INDEX users_canvas_login ON users (canvas_login);
-CREATE UNIQUE INDEX users_canvas_id ON users (canvas_id);
+CREATE UNIQUE INDEX users_lti_id ON users (consumer_key, lti_id);
+CREATE UNIQUE INDEX users_canvas_login ON users (consumer_key, canvas_login);
+CREATE UNIQUE INDEX users_canvas_id ON users (consumer_key, canvas_id);
+
+-- public keys that copies of grind registered at login, used to sign commits
+CREATE TABLE client_keys (
+    id                      integer PRIMARY KEY,
+    user_id                 integer NOT NULL,
+    public_key              text NOT NULL,
+    name                    text NOT NULL,
+    created_at              datetime NOT NULL,
+    last_used_at            datetime,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE UNIQUE INDEX client_keys_public_key ON client_keys (public_key);
+CREATE INDEX client_keys_user_id ON client_keys (user_id);
+
+-- LTI user IDs that belong to another user after accounts were merged
+CREATE TABLE user_links (
+    consumer_key            text NOT NULL,
+    lti_id                  text NOT NULL,
+    user_id                 integer NOT NULL,
+    created_at              datetime NOT NULL,
+
+    PRIMARY KEY (consumer_key, lti_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX user_links_user_id ON user_links (user_id);
+
+-- sections and enrollments as reported by the LMS when a roster is synced,
+-- including students who have not launched anything yet
+CREATE TABLE course_sections (
+    id                      integer PRIMARY KEY,
+    course_id               integer NOT NULL,
+    lti_id                  text NOT NULL,
+    name                    text NOT NULL,
+    created_at              datetime NOT NULL,
+    updated_at              datetime NOT NULL,
+
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE UNIQUE INDEX course_sections_course_id_lti_id ON course_sections (course_id, lti_id);
+
+CREATE TABLE course_members (
+    id                      integer PRIMARY KEY,
+    course_id               integer NOT NULL,
+    user_id                 integer NOT NULL,
+    section_id              integer,
+    roles                   text NOT NULL,
+    active                  boolean NOT NULL,
+    synced_at               datetime NOT NULL,
+    created_at              datetime NOT NULL,
+    updated_at              datetime NOT NULL,
+
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (section_id) REFERENCES course_sections (id) ON DELETE SET NULL ON UPDATE CASCADE
+);
+CREATE UNIQUE INDEX course_members_course_id_user_id ON course_members (course_id, user_id);
+CREATE INDEX course_members_user_id ON course_members (user_id);
+
+CREATE TABLE accounts (
+    id                      integer PRIMARY KEY,
+    user_id                 integer NOT NULL,
+    email                   text NOT NULL,
+    password_hash           text NOT NULL,
+    verified                boolean NOT NULL,
+    token                   text NOT NULL,
+    token_expires_at        datetime NOT NULL,
+    created_at              datetime NOT NULL,
+    updated_at              datetime NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE UNIQUE INDEX accounts_user_id ON accounts (user_id);
+CREATE UNIQUE INDEX accounts_email ON accounts (email);
 
 CREATE TABLE assignments (
     id                      integer PRIMARY KEY,
@@ -112,6 +230,7 @@ CREATE TABLE assignments (
     roles                   text NOT NULL,
     instructor              boolean NOT NULL,
     raw_scores              text NOT NULL,
+    variants                text NOT NULL,
     score                   real,
     grade_id                text,
     lti_id                  text NOT NULL,
@@ -146,10 +265,15 @@ CREATE TABLE commits (
     step                    integer NOT NULL,
     action                  text,
     note                    text,
+    message                 text,
     files                   text NOT NULL,
     transcript              text NOT NULL,
     report_card             text NOT NULL,
+    artifacts               text NOT NULL,
     score                   real,
+    active_time             integer,
+    client                  text,
+    client_key_id           integer,
     created_at              datetime NOT NULL,
     updated_at              datetime NOT NULL,
 
@@ -159,27 +283,211 @@ CREATE TABLE commits (
 CREATE UNIQUE INDEX commits_unique_assignment_problem_step ON commits (assignment_id, problem_id, step);
 CREATE INDEX commits_problem_id_step ON commits (problem_id, step);
 
+-- every graded commit and every change to the files of a commit,
+-- since the commits table only keeps the latest one per step
+CREATE TABLE commit_history (
+    id                      integer PRIMARY KEY,
+    assignment_id           integer NOT NULL,
+    problem_id              integer NOT NULL,
+    step                    integer NOT NULL,
+    action                  text,
+    note                    text,
+    message                 text,
+    files                   text NOT NULL,
+    transcript              text NOT NULL,
+    report_card             text NOT NULL,
+    artifacts               text NOT NULL,
+    score                   real,
+    active_time             integer,
+    client                  text,
+    client_key_id           integer,
+    created_at              datetime NOT NULL,
+    updated_at              datetime NOT NULL,
+
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (problem_id, step) REFERENCES problem_steps (problem_id, step) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX commit_history_assignment_problem_step ON commit_history (assignment_id, problem_id, step);
+
+-- graded practice attempts, which never count toward the score
+CREATE TABLE practice_commits (
+    id                      integer PRIMARY KEY,
+    assignment_id           integer NOT NULL,
+    problem_id              integer NOT NULL,
+    step                    integer NOT NULL,
+    action                  text,
+    note                    text,
+    message                 text,
+    files                   text NOT NULL,
+    transcript              text NOT NULL,
+    report_card             text NOT NULL,
+    artifacts               text NOT NULL,
+    score                   real,
+    active_time             integer,
+    client                  text,
+    client_key_id           integer,
+    created_at              datetime NOT NULL,
+    updated_at              datetime NOT NULL,
+
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (problem_id, step) REFERENCES problem_steps (problem_id, step) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX practice_commits_assignment_problem_step ON practice_commits (assignment_id, problem_id, step);
+
+-- read-only links to one saved version of a commit
+CREATE TABLE commit_shares (
+    id                      integer PRIMARY KEY,
+    token                   text NOT NULL,
+    commit_id               integer NOT NULL,
+    history_id              integer NOT NULL,
+    user_id                 integer NOT NULL,
+    expires_at              datetime NOT NULL,
+    created_at              datetime NOT NULL,
+
+    FOREIGN KEY (commit_id) REFERENCES commits (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (history_id) REFERENCES commit_history (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE UNIQUE INDEX commit_shares_token ON commit_shares (token);
+
+CREATE TABLE similarities (
+    id                      integer PRIMARY KEY,
+    course_id               integer NOT NULL,
+    lti_id                  text NOT NULL,
+    problem_id              integer NOT NULL,
+    assignment_a_id         integer NOT NULL,
+    assignment_b_id         integer NOT NULL,
+    commit_a_id             integer NOT NULL,
+    commit_b_id             integer NOT NULL,
+    score                   real NOT NULL,
+    created_at              datetime NOT NULL,
+
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (assignment_a_id) REFERENCES assignments (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (assignment_b_id) REFERENCES assignments (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (commit_a_id) REFERENCES commits (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (commit_b_id) REFERENCES commits (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX similarities_course_id_lti_id ON similarities (course_id, lti_id);
+
+CREATE TABLE moss_reports (
+    id                      integer PRIMARY KEY,
+    course_id               integer NOT NULL,
+    lti_id                  text NOT NULL,
+    problem_id              integer NOT NULL,
+    language                text NOT NULL,
+    url                     text NOT NULL,
+    created_at              datetime NOT NULL,
+
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX moss_reports_course_id_lti_id ON moss_reports (course_id, lti_id);
+
+-- time spent on daycares running actions for each student and assignment
+CREATE TABLE daycare_usage (
+    id                      integer PRIMARY KEY,
+    course_id               integer NOT NULL,
+    assignment_id           integer NOT NULL,
+    user_id                 integer NOT NULL,
+    seconds                 real NOT NULL,
+    created_at              datetime NOT NULL,
+
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX daycare_usage_course_id_created_at ON daycare_usage (course_id, created_at);
+
+CREATE TABLE student_warnings (
+    id                      integer PRIMARY KEY,
+    course_id               integer NOT NULL,
+    lti_id                  text NOT NULL,
+    assignment_id           integer NOT NULL,
+    user_id                 integer NOT NULL,
+    kind                    text NOT NULL,
+    detail                  text NOT NULL,
+    created_at              datetime NOT NULL,
+
+    FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX student_warnings_course_id_lti_id ON student_warnings (course_id, lti_id);
+
+-- email notifications that have been sent, so none is sent twice
+CREATE TABLE notifications (
+    id                      integer PRIMARY KEY,
+    user_id                 integer NOT NULL,
+    kind                    text NOT NULL,
+    assignment_id           integer,
+    subject                 text NOT NULL,
+    created_at              datetime NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX notifications_assignment_id_kind ON notifications (assignment_id, kind);
+CREATE INDEX notifications_user_id ON notifications (user_id);
+
+CREATE TABLE score_overrides (
+    id                      integer PRIMARY KEY,
+    assignment_id           integer NOT NULL,
+    user_id                 integer NOT NULL,
+    score                   real,
+    reason                  text NOT NULL,
+    created_at              datetime NOT NULL,
+
+    FOREIGN KEY (assignment_id) REFERENCES assignments (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX score_overrides_assignment_id ON score_overrides (assignment_id);
+
+-- no foreign keys so the record survives deletion of the objects involved
+CREATE TABLE audit_log (
+    id                      integer PRIMARY KEY,
+    user_id                 integer NOT NULL,
+    action                  text NOT NULL,
+    object_type             text NOT NULL,
+    object_id               integer NOT NULL,
+    detail                  text NOT NULL,
+    created_at              datetime NOT NULL
+);
+CREATE INDEX audit_log_object ON audit_log (object_type, object_id);
+
 CREATE VIEW user_problem_sets AS
     SELECT DISTINCT assignments.user_id, problem_sets.id AS problem_set_id
     FROM assignments
+    JOIN courses ON assignments.course_id = courses.id
     JOIN problem_sets ON assignments.problem_set_id = problem_sets.id
     WHERE assignments.problem_set_id IS NOT NULL
+    AND courses.deleted_at IS NULL
+    AND problem_sets.deleted_at IS NULL
     UNION
     SELECT DISTINCT instructors.id AS user_id, assignments.problem_set_id AS problem_set_id
     FROM users AS instructors
     JOIN assignments AS instructors_assignments ON instructors.id = instructors_assignments.user_id
     JOIN courses ON instructors_assignments.course_id = courses.id
     JOIN assignments ON courses.id = assignments.course_id
+    JOIN problem_sets ON assignments.problem_set_id = problem_sets.id
     WHERE instructors_assignments.instructor
     AND assignments.problem_set_id IS NOT NULL
-    AND instructors_assignments.problem_set_id IS NOT NULL;
+    AND instructors_assignments.problem_set_id IS NOT NULL
+    AND courses.deleted_at IS NULL
+    AND problem_sets.deleted_at IS NULL;
 
 CREATE VIEW user_problems AS
     SELECT DISTINCT assignments.user_id, problem_set_problems.problem_id
     FROM assignments
+    JOIN courses ON assignments.course_id = courses.id
     JOIN problem_sets ON assignments.problem_set_id = problem_sets.id
     JOIN problem_set_problems ON problem_sets.id = problem_set_problems.problem_set_id
+    JOIN problems ON problem_set_problems.problem_id = problems.id
     WHERE assignments.problem_set_id IS NOT NULL
+    AND courses.deleted_at IS NULL
+    AND problem_sets.deleted_at IS NULL
+    AND problems.deleted_at IS NULL
     UNION
     SELECT DISTINCT instructors.id AS user_id, problem_set_problems.problem_id
     FROM users AS instructors
@@ -188,9 +496,44 @@ CREATE VIEW user_problems AS
     JOIN assignments ON courses.id = assignments.course_id
     JOIN problem_sets ON assignments.problem_set_id = problem_sets.id
     JOIN problem_set_problems ON problem_sets.id = problem_set_problems.problem_id
+    JOIN problems ON problem_set_problems.problem_id = problems.id
+    WHERE instructors_assignments.instructor
+    AND assignments.problem_set_id IS NOT NULL
+    AND instructors_assignments.problem_set_id IS NOT NULL
+    AND courses.deleted_at IS NULL
+    AND problem_sets.deleted_at IS NULL
+    AND problems.deleted_at IS NULL
+    UNION
+    SELECT DISTINCT assignments.user_id, variants.id AS problem_id
+    FROM assignments
+    JOIN courses ON assignments.course_id = courses.id
+    JOIN problem_sets ON assignments.problem_set_id = problem_sets.id
+    JOIN problem_set_problems ON problem_sets.id = problem_set_problems.problem_set_id
+    JOIN problems ON problem_set_problems.problem_id = problems.id
+    JOIN json_each(problems.variants)
+    JOIN problems AS variants ON json_each.value = variants.unique_id
+    WHERE assignments.problem_set_id IS NOT NULL
+    AND courses.deleted_at IS NULL
+    AND problem_sets.deleted_at IS NULL
+    AND problems.deleted_at IS NULL
+    AND variants.deleted_at IS NULL
+    UNION
+    SELECT DISTINCT instructors.id AS user_id, variants.id AS problem_id
+    FROM users AS instructors
+    JOIN assignments AS instructors_assignments ON instructors.id = instructors_assignments.user_id
+    JOIN courses ON instructors_assignments.course_id = courses.id
+    JOIN assignments ON courses.id = assignments.course_id
+    JOIN problem_sets ON assignments.problem_set_id = problem_sets.id
+    JOIN problem_set_problems ON problem_sets.id = problem_set_problems.problem_set_id
+    JOIN problems ON problem_set_problems.problem_id = problems.id
+    JOIN json_each(problems.variants)
+    JOIN problems AS variants ON json_each.value = variants.unique_id
     WHERE instructors_assignments.instructor
     AND assignments.problem_set_id IS NOT NULL
-    AND instructors_assignments.problem_set_id IS NOT NULL;
+    AND courses.deleted_at IS NULL
+    AND problem_sets.deleted_at IS NULL
+    AND problems.deleted_at IS NULL
+    AND variants.deleted_at IS NULL;
 
 CREATE VIEW user_users AS
     SELECT DISTINCT instructors.id AS user_id, users.id AS other_user_id
@@ -200,6 +543,7 @@ CREATE VIEW user_users AS
     JOIN assignments ON courses.id = assignments.course_id
     JOIN users ON assignments.user_id = users.id
     WHERE instructors_assignments.instructor
+    AND courses.deleted_at IS NULL
     UNION
     SELECT id as user_id, id AS other_user_id
     FROM users;
@@ -211,9 +555,12 @@ CREATE VIEW user_assignments AS
     JOIN courses ON instructors_assignments.course_id = courses.id
     JOIN assignments ON courses.id = assignments.course_id
     WHERE instructors_assignments.instructor
+    AND courses.deleted_at IS NULL
     UNION
-    SELECT user_id, id as assignment_id
-    FROM assignments;
+    SELECT assignments.user_id, assignments.id as assignment_id
+    FROM assignments
+    JOIN courses ON assignments.course_id = courses.id
+    WHERE courses.deleted_at IS NULL;
 
 CREATE VIEW assignment_search_fields AS
     SELECT assignments.id AS assignment_id,
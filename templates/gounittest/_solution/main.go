@@ -0,0 +1,8 @@
+package main
+
+func Add(a, b int) int {
+	return a + b
+}
+
+func main() {
+}
@@ -0,0 +1,8 @@
+package main
+
+func Add(a, b int) int {
+	return 0
+}
+
+func main() {
+}
@@ -0,0 +1,9 @@
+package main
+
+import "testing"
+
+func TestAdd(t *testing.T) {
+	if got := Add(2, 3); got != 5 {
+		t.Errorf("Add(2, 3) returned %d, expected 5", got)
+	}
+}
@@ -0,0 +1,12 @@
+import unittest
+
+from main import add
+
+
+class TestAdd(unittest.TestCase):
+    def test_add(self):
+        self.assertEqual(add(2, 3), 5)
+
+
+if __name__ == '__main__':
+    unittest.main()
@@ -939,8 +939,17 @@ def do_request(path: str, params: Optional[Dict[str, Any]], method: str, upload:
     if notfoundokay and resp.status_code == 404:
         return None
     if resp.status_code != 200:
+        message = resp.text
+        try:
+            err = json.loads(message)
+            if err.get('code') == 'session_expired':
+                message = err['message'] + '\n\nYour session has expired. Please log in again.'
+            elif err.get('message'):
+                message = err['message']
+        except (ValueError, AttributeError):
+            pass
         raise DialogException('Unexpected status from server',
-            f'Received unexpected status from {url}:\n\n{resp.text}')
+            f'Received unexpected status from {url}:\n\n{message}')
 
     return json.loads(resp.content.decode(encoding='utf-8'))
 
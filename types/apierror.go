@@ -0,0 +1,70 @@
+package types
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// APIError is the body of every error response from the server.
+// Clients should act on the code; the message is for people.
+// The request ID appears in the server log next to the same message.
+type APIError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   string `json:"details,omitempty"`
+	RequestID string `json:"requestID,omitempty"`
+}
+
+// codes for APIError
+const (
+	ErrorBadRequest     = "bad_request"
+	ErrorUnauthorized   = "unauthorized"
+	ErrorSessionExpired = "session_expired"
+	ErrorForbidden      = "forbidden"
+	ErrorReadOnly       = "read_only_session"
+	ErrorLocked         = "assignment_locked"
+	ErrorNotFound       = "not_found"
+	ErrorConflict       = "conflict"
+	ErrorTooLarge       = "too_large"
+	ErrorRateLimited    = "rate_limited"
+	ErrorInternal       = "internal"
+	ErrorUnavailable    = "unavailable"
+	ErrorUpgrade        = "upgrade_required"
+	ErrorUnsigned       = "signature_required"
+	ErrorUnknown        = "error"
+)
+
+// ErrorCodeForStatus gives the code used for an HTTP status
+// when there is no more specific code.
+func ErrorCodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrorBadRequest
+	case http.StatusUnauthorized:
+		return ErrorUnauthorized
+	case http.StatusForbidden:
+		return ErrorForbidden
+	case http.StatusNotFound:
+		return ErrorNotFound
+	case http.StatusConflict:
+		return ErrorConflict
+	case http.StatusRequestEntityTooLarge:
+		return ErrorTooLarge
+	case http.StatusTooManyRequests:
+		return ErrorRateLimited
+	case http.StatusInternalServerError:
+		return ErrorInternal
+	case http.StatusServiceUnavailable:
+		return ErrorUnavailable
+	case http.StatusUpgradeRequired:
+		return ErrorUpgrade
+	}
+	return ErrorUnknown
+}
+
+func (e *APIError) Error() string {
+	if e.Details != "" {
+		return fmt.Sprintf("%s: %s", e.Message, e.Details)
+	}
+	return e.Message
+}
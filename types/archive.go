@@ -0,0 +1,256 @@
+package types
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProblemSetArchive is a self-contained copy of a problem set and all of its
+// problems. It is used to move content between installations.
+//
+// As a tar.gz file, it has the following layout:
+//
+//	problem_set.json
+//	problems/<unique>/problem.json
+//	problems/<unique>/steps/<n>/step.json
+//	problems/<unique>/steps/<n>/files/...
+//	problems/<unique>/steps/<n>/solution/...
+type ProblemSetArchive struct {
+	ProblemSet *ProblemSet       `json:"problemSet"`
+	Problems   []*ProblemArchive `json:"problems"`
+}
+
+// ProblemArchive is a single problem within a ProblemSetArchive.
+type ProblemArchive struct {
+	Problem *Problem       `json:"problem"`
+	Weight  float64        `json:"weight"`
+	Steps   []*ProblemStep `json:"steps"`
+}
+
+type problemSetArchiveIndex struct {
+	ProblemSet *ProblemSet `json:"problemSet"`
+	Problems   []struct {
+		Unique string  `json:"unique"`
+		Weight float64 `json:"weight"`
+	} `json:"problems"`
+}
+
+// WriteTarGz writes the archive to w as a gzipped tar file.
+func (archive *ProblemSetArchive) WriteTarGz(w io.Writer) error {
+	now := time.Now()
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	add := func(name string, contents []byte) error {
+		header := &tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(contents)),
+			ModTime:  now,
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(contents)
+		return err
+	}
+	addJSON := func(name string, elt interface{}) error {
+		raw, err := json.MarshalIndent(elt, "", "    ")
+		if err != nil {
+			return err
+		}
+		return add(name, append(raw, '\n'))
+	}
+	addFiles := func(prefix string, files map[string][]byte) error {
+		var names []string
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := add(path.Join(prefix, name), files[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// the index lists problems by unique ID since IDs are not portable
+	index := new(problemSetArchiveIndex)
+	set := *archive.ProblemSet
+	set.ID = 0
+	index.ProblemSet = &set
+	for _, elt := range archive.Problems {
+		index.Problems = append(index.Problems, struct {
+			Unique string  `json:"unique"`
+			Weight float64 `json:"weight"`
+		}{elt.Problem.Unique, elt.Weight})
+	}
+	if err := addJSON("problem_set.json", index); err != nil {
+		return err
+	}
+
+	for _, elt := range archive.Problems {
+		problem := *elt.Problem
+		problem.ID = 0
+		dir := path.Join("problems", problem.Unique)
+		if err := addJSON(path.Join(dir, "problem.json"), &problem); err != nil {
+			return err
+		}
+		for _, step := range elt.Steps {
+			stepDir := path.Join(dir, "steps", strconv.FormatInt(step.Step, 10))
+
+			// files are stored separately and instructions are rebuilt on import
+			meta := *step
+			meta.ProblemID = 0
+			meta.Instructions = ""
+			meta.Translations = nil
+			meta.Files = nil
+			meta.Solution = nil
+			if err := addJSON(path.Join(stepDir, "step.json"), &meta); err != nil {
+				return err
+			}
+			if err := addFiles(path.Join(stepDir, "files"), step.Files); err != nil {
+				return err
+			}
+			if err := addFiles(path.Join(stepDir, "solution"), step.Solution); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ReadProblemSetArchive parses a gzipped tar file written by WriteTarGz.
+func ReadProblemSetArchive(r io.Reader) (*ProblemSetArchive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("gzip error reading archive: %v", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	var index *problemSetArchiveIndex
+	problems := make(map[string]*ProblemArchive)
+	steps := make(map[string]map[int64]*ProblemStep)
+	getStep := func(unique string, n int64) *ProblemStep {
+		if steps[unique] == nil {
+			steps[unique] = make(map[int64]*ProblemStep)
+		}
+		if steps[unique][n] == nil {
+			steps[unique][n] = &ProblemStep{
+				Step:     n,
+				Files:    make(map[string][]byte),
+				Solution: make(map[string][]byte),
+			}
+		}
+		return steps[unique][n]
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tar error reading archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s from archive: %v", header.Name, err)
+		}
+		name := path.Clean(header.Name)
+		if name == "problem_set.json" {
+			index = new(problemSetArchiveIndex)
+			if err := json.Unmarshal(contents, index); err != nil {
+				return nil, fmt.Errorf("error parsing %s: %v", name, err)
+			}
+			continue
+		}
+
+		// problems/<unique>/...
+		parts := strings.SplitN(name, "/", 3)
+		if len(parts) != 3 || parts[0] != "problems" {
+			return nil, fmt.Errorf("unexpected file in archive: %s", name)
+		}
+		unique, rest := parts[1], parts[2]
+		if rest == "problem.json" {
+			problem := new(Problem)
+			if err := json.Unmarshal(contents, problem); err != nil {
+				return nil, fmt.Errorf("error parsing %s: %v", name, err)
+			}
+			if problem.Unique != unique {
+				return nil, fmt.Errorf("%s has unique ID %q", name, problem.Unique)
+			}
+			problems[unique] = &ProblemArchive{Problem: problem}
+			continue
+		}
+
+		// steps/<n>/...
+		parts = strings.SplitN(rest, "/", 4)
+		if len(parts) < 3 || parts[0] != "steps" {
+			return nil, fmt.Errorf("unexpected file in archive: %s", name)
+		}
+		n, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("bad step number in archive: %s", name)
+		}
+		step := getStep(unique, n)
+		switch {
+		case len(parts) == 3 && parts[2] == "step.json":
+			files, solution := step.Files, step.Solution
+			if err := json.Unmarshal(contents, step); err != nil {
+				return nil, fmt.Errorf("error parsing %s: %v", name, err)
+			}
+			step.Step, step.Files, step.Solution = n, files, solution
+		case len(parts) == 4 && parts[2] == "files":
+			step.Files[parts[3]] = contents
+		case len(parts) == 4 && parts[2] == "solution":
+			step.Solution[parts[3]] = contents
+		default:
+			return nil, fmt.Errorf("unexpected file in archive: %s", name)
+		}
+	}
+
+	// assemble the pieces in the order given by the index
+	if index == nil || index.ProblemSet == nil {
+		return nil, fmt.Errorf("archive is missing problem_set.json")
+	}
+	archive := &ProblemSetArchive{ProblemSet: index.ProblemSet}
+	for _, elt := range index.Problems {
+		problem := problems[elt.Unique]
+		if problem == nil {
+			return nil, fmt.Errorf("archive is missing problem %s", elt.Unique)
+		}
+		problem.Weight = elt.Weight
+		for n := int64(1); steps[elt.Unique][n] != nil; n++ {
+			problem.Steps = append(problem.Steps, steps[elt.Unique][n])
+		}
+		if len(problem.Steps) != len(steps[elt.Unique]) {
+			return nil, fmt.Errorf("steps for problem %s are not numbered consecutively", elt.Unique)
+		}
+		archive.Problems = append(archive.Problems, problem)
+	}
+	if len(archive.Problems) != len(problems) {
+		return nil, fmt.Errorf("archive contains problems not listed in problem_set.json")
+	}
+
+	return archive, nil
+}
@@ -30,6 +30,18 @@ type CommitBundle struct {
 	UserID               int64          `json:"userID"`
 	Commit               *Commit        `json:"commit"`
 	CommitSignature      string         `json:"commitSignature,omitempty"`
+	Ephemeral            bool           `json:"ephemeral,omitempty"` // grade it without saving the commit or the score
+	Practice             bool           `json:"practice,omitempty"`  // grade it as practice, recorded apart from the commits and never scored
+	Locale               string         `json:"locale,omitempty"`    // language for the report card
+	ClientKeyID          int64          `json:"clientKeyID,omitempty"`
+	ClientSignature      string         `json:"clientSignature,omitempty"` // base64 ed25519 signature of Commit.ClientSigningData by the client key
+}
+
+// BlobQuery lists the sha256 hashes of file contents. Before uploading a
+// commit, grind sends the hashes of its files and the server replies with
+// the ones it does not already have, so only those need to be uploaded.
+type BlobQuery struct {
+	Hashes []string `json:"hashes"`
 }
 
 // MaxDaycareRequestAge is the maximum age of a daycare-signed commit to be saved.
@@ -0,0 +1,51 @@
+package types
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ClientKey is the public half of a key pair that grind makes when the user
+// logs in. grind signs each commit it submits with the private half, so the
+// server can tell that the work came from a copy of grind the student
+// signed in to, and not from some other program using their session.
+type ClientKey struct {
+	ID         int64      `json:"id" meddler:"id,pk"`
+	UserID     int64      `json:"userID" meddler:"user_id"`
+	PublicKey  string     `json:"publicKey" meddler:"public_key"` // base64 ed25519 public key
+	Name       string     `json:"name" meddler:"name"`            // describes the computer the key was made on
+	CreatedAt  time.Time  `json:"createdAt" meddler:"created_at,localtime"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" meddler:"last_used_at,localtime"`
+}
+
+// ClientSigningData returns the bytes grind signs for a commit it submits.
+// It covers the fields grind controls, with each file given by its sha256
+// hash so the same data can be computed whether a file was uploaded by
+// contents or by hash.
+func (commit *Commit) ClientSigningData(userID int64) []byte {
+	v := make(url.Values)
+	v.Add("user_id", strconv.FormatInt(userID, 10))
+	v.Add("assignment_id", strconv.FormatInt(commit.AssignmentID, 10))
+	v.Add("problem_id", strconv.FormatInt(commit.ProblemID, 10))
+	v.Add("step", strconv.FormatInt(commit.Step, 10))
+	v.Add("action", commit.Action)
+	v.Add("note", commit.Note)
+	v.Add("message", commit.Message)
+	for name, contents := range commit.Files {
+		sum := sha256.Sum256(contents)
+		v.Add(fmt.Sprintf("file-%s", name), hex.EncodeToString(sum[:]))
+	}
+	for name, hash := range commit.FileHashes {
+		v.Add(fmt.Sprintf("file-%s", name), hash)
+	}
+	if c := commit.Client; c != nil {
+		v.Add("client-version", c.Version)
+		v.Add("client-platform", c.Platform)
+		v.Add("client-host-hash", c.HostHash)
+	}
+	return encode(v)
+}
@@ -0,0 +1,59 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// SocketCompression is the websocket subprotocol used to request
+// zstd-compressed frames. A client that supports it asks for it when
+// dialing the daycare, and frames are compressed only if the daycare
+// agrees; otherwise both ends use plain JSON text frames.
+const SocketCompression = "zstd"
+
+// JSONSocket is the part of a websocket connection
+// used by WriteSocketJSON and ReadSocketJSON.
+type JSONSocket interface {
+	Subprotocol() string
+	WriteMessage(messageType int, data []byte) error
+	ReadMessage() (messageType int, p []byte, err error)
+}
+
+// websocket message types from RFC 6455
+const (
+	socketTextMessage   = 1
+	socketBinaryMessage = 2
+)
+
+var zstdEncoder, _ = zstd.NewWriter(nil)
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// WriteSocketJSON sends a value as a JSON frame,
+// compressing it if that was negotiated for the connection.
+func WriteSocketJSON(socket JSONSocket, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if socket.Subprotocol() != SocketCompression {
+		return socket.WriteMessage(socketTextMessage, raw)
+	}
+	return socket.WriteMessage(socketBinaryMessage, zstdEncoder.EncodeAll(raw, nil))
+}
+
+// ReadSocketJSON reads a JSON frame into a value.
+// Binary frames are zstd-compressed JSON.
+func ReadSocketJSON(socket JSONSocket, v interface{}) error {
+	kind, raw, err := socket.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if kind == socketBinaryMessage {
+		if raw, err = zstdDecoder.DecodeAll(raw, nil); err != nil {
+			return fmt.Errorf("zstd error in websocket frame: %v", err)
+		}
+	}
+	return json.Unmarshal(raw, v)
+}
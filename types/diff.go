@@ -0,0 +1,303 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// CommitDiff is the set of per-file differences between two commits,
+// or between a commit and the starter files for its step.
+type CommitDiff struct {
+	CommitID  int64       `json:"commitID"`
+	AgainstID int64       `json:"againstID,omitempty"` // zero means the starter files
+	Files     []*FileDiff `json:"files"`
+}
+
+// FileDiff describes the changes to a single file.
+type FileDiff struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // "added", "removed", or "modified"
+	Diff   string `json:"diff"`   // unified diff format
+}
+
+// maxDiffCells bounds the work done to compute a diff; larger inputs are
+// reported as a full replacement of the file.
+const maxDiffCells = 4000000
+
+// DiffFiles compares two sets of files and returns a diff for each file that
+// was added, removed, or modified. Unchanged files are omitted.
+func DiffFiles(before, after map[string][]byte) []*FileDiff {
+	names := make(map[string]bool)
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	diffs := []*FileDiff{}
+	for _, name := range sorted {
+		a, inBefore := before[name]
+		b, inAfter := after[name]
+		if inBefore && inAfter && bytes.Equal(a, b) {
+			continue
+		}
+		elt := &FileDiff{Name: name, Status: "modified"}
+		fromName, toName := "a/"+name, "b/"+name
+		if !inBefore {
+			elt.Status = "added"
+			fromName = "/dev/null"
+		} else if !inAfter {
+			elt.Status = "removed"
+			toName = "/dev/null"
+		}
+		if (inBefore && !utf8.Valid(a)) || (inAfter && !utf8.Valid(b)) {
+			elt.Diff = fmt.Sprintf("Binary files %s and %s differ\n", fromName, toName)
+		} else {
+			elt.Diff = UnifiedDiff(fromName, toName, a, b, 3)
+		}
+		diffs = append(diffs, elt)
+	}
+	return diffs
+}
+
+// UnifiedDiff returns a unified diff of two texts,
+// with the given number of lines of context around each change.
+func UnifiedDiff(fromName, toName string, a, b []byte, context int) string {
+	x, y := splitLines(a), splitLines(b)
+	ops := diffLines(x, y)
+
+	out := new(strings.Builder)
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", fromName, toName)
+
+	// group the edit script into hunks
+	for start := 0; start < len(ops); {
+		// find the next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start >= len(ops) {
+			break
+		}
+		first := start - context
+		if first < 0 {
+			first = 0
+		}
+
+		// extend the hunk until there is a long enough run of unchanged lines
+		end, same := start, 0
+		for end < len(ops) && same <= 2*context {
+			if ops[end].kind == ' ' {
+				same++
+			} else {
+				same = 0
+			}
+			end++
+		}
+		if same > context {
+			end -= same - context
+		}
+
+		// compute the header
+		aStart, bStart, aLen, bLen := ops[first].a+1, ops[first].b+1, 0, 0
+		for _, op := range ops[first:end] {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+		if aLen == 0 {
+			aStart--
+		}
+		if bLen == 0 {
+			bStart--
+		}
+		fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+		for _, op := range ops[first:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = end
+	}
+
+	return out.String()
+}
+
+type diffOp struct {
+	kind byte // ' ', '-', or '+'
+	line string
+	a, b int // zero-based line numbers in the old and new texts
+}
+
+func splitLines(s []byte) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(s), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes an edit script using the longest common subsequence.
+func diffLines(x, y []string) []diffOp {
+	// trim common prefix and suffix to keep the table small
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+	mx, my := x[prefix:len(x)-suffix], y[prefix:len(y)-suffix]
+
+	var ops []diffOp
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{' ', x[i], i, i})
+	}
+
+	n, m := len(mx), len(my)
+	if (n+1)*(m+1) > maxDiffCells {
+		// too big: treat the middle as a full replacement
+		for i, line := range mx {
+			ops = append(ops, diffOp{'-', line, prefix + i, prefix})
+		}
+		for j, line := range my {
+			ops = append(ops, diffOp{'+', line, prefix + n, prefix + j})
+		}
+	} else {
+		// lcs[i][j] is the length of the LCS of mx[i:] and my[j:]
+		lcs := make([][]int32, n+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if mx[i] == my[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < n || j < m {
+			switch {
+			case i < n && j < m && mx[i] == my[j]:
+				ops = append(ops, diffOp{' ', mx[i], prefix + i, prefix + j})
+				i++
+				j++
+			case j < m && (i == n || lcs[i][j+1] > lcs[i+1][j]):
+				ops = append(ops, diffOp{'+', my[j], prefix + i, prefix + j})
+				j++
+			default:
+				ops = append(ops, diffOp{'-', mx[i], prefix + i, prefix + j})
+				i++
+			}
+		}
+	}
+
+	for k := 0; k < suffix; k++ {
+		ops = append(ops, diffOp{' ', x[len(x)-suffix+k], len(x) - suffix + k, len(y) - suffix + k})
+	}
+	return ops
+}
+
+// Merge3 combines the changes made from base to ours with the changes made
+// from base to theirs. Where both sides changed the same lines differently,
+// both versions are kept between conflict markers labeled with oursLabel
+// and theirsLabel, and the number of such conflicts is returned.
+func Merge3(base, ours, theirs []byte, oursLabel, theirsLabel string) ([]byte, int) {
+	o, a, b := splitLines(base), splitLines(ours), splitLines(theirs)
+	matchA, matchB := matchLines(o, a), matchLines(o, b)
+
+	out := new(strings.Builder)
+	conflicts := 0
+	i, ia, ib := 0, 0, 0
+	for {
+		// find the next base line that both sides kept
+		k := i
+		for k < len(o) && (matchA[k] < 0 || matchB[k] < 0) {
+			k++
+		}
+		ka, kb := len(a), len(b)
+		if k < len(o) {
+			ka, kb = matchA[k], matchB[k]
+		}
+
+		// merge the changes in between
+		chunkO, chunkA, chunkB := o[i:k], a[ia:ka], b[ib:kb]
+		switch {
+		case equalLines(chunkA, chunkO):
+			writeLines(out, chunkB, false)
+		case equalLines(chunkB, chunkO), equalLines(chunkA, chunkB):
+			writeLines(out, chunkA, false)
+		default:
+			conflicts++
+			out.WriteString("<<<<<<< " + oursLabel + "\n")
+			writeLines(out, chunkA, true)
+			out.WriteString("=======\n")
+			writeLines(out, chunkB, true)
+			out.WriteString(">>>>>>> " + theirsLabel + "\n")
+		}
+
+		if k == len(o) {
+			break
+		}
+		out.WriteString(o[k])
+		i, ia, ib = k+1, ka+1, kb+1
+	}
+	return []byte(out.String()), conflicts
+}
+
+// matchLines maps each line of x to the line of y it is kept as, or -1.
+func matchLines(x, y []string) []int {
+	match := make([]int, len(x))
+	for i := range match {
+		match[i] = -1
+	}
+	for _, op := range diffLines(x, y) {
+		if op.kind == ' ' {
+			match[op.a] = op.b
+		}
+	}
+	return match
+}
+
+func equalLines(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(out *strings.Builder, lines []string, terminate bool) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+	if terminate && len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		out.WriteString("\n")
+	}
+}
@@ -15,22 +15,46 @@ type ReportCard struct {
 	Note     string              `json:"note"`
 	Duration time.Duration       `json:"duration"`
 	Results  []*ReportCardResult `json:"results"`
+	Abuse    string              `json:"abuse,omitempty"`
+
+	// resources used by the action
+	PeakMemory int64         `json:"peakMemory,omitempty"`
+	CPUTime    time.Duration `json:"cpuTime,omitempty"`
+	WallTime   time.Duration `json:"wallTime,omitempty"`
+
+	// percentage of the student's code covered by their tests, if measured
+	Coverage float64 `json:"coverage,omitempty"`
+
+	// bytes of stdout and stderr dropped from the middle of the output
+	OutputDiscarded int64 `json:"outputDiscarded,omitempty"`
 }
 
 // ReportCardResult Outcomes:
 //   passed
 //   failed
+//   timeout: the test or program ran out of time
+//   crash: the test or program was killed by a signal
+//   resource-limit: the test or program used too much memory, CPU, etc.
+//   flaky: the test passed on one run and not on another; counts as passing
 //   error
 //   skipped
 // Details: a multi-line message that should
 //   be displayed in a monospace font
 // Context:
 //   path/to/file.py:line#
+// Weight: how much the result counts toward the score
+//   relative to the others; zero means the default of one
 type ReportCardResult struct {
-	Name    string `json:"name"`
-	Outcome string `json:"outcome"`
-	Details string `json:"details,omitempty"`
-	Context string `json:"context,omitempty"`
+	Name    string  `json:"name"`
+	Outcome string  `json:"outcome"`
+	Details string  `json:"details,omitempty"`
+	Context string  `json:"context,omitempty"`
+	Weight  float64 `json:"weight,omitempty"`
+}
+
+// Passing reports whether a result counts as a pass.
+func (r *ReportCardResult) Passing() bool {
+	return r.Outcome == "passed" || r.Outcome == "flaky"
 }
 
 // EventMessage follows one of these forms:
@@ -41,8 +65,11 @@ type ReportCardResult struct {
 //   stderr StreamData
 //   stdinclosed
 //   error Error
+//   abuse Error
+//   warning Error (a compiler warning; the output is also in the stream data)
 //   reportcard ReportCard
 //   files Files
+//   artifact Artifacts
 type EventMessage struct {
 	Time        time.Time         `json:"time"`
 	Event       string            `json:"event"`
@@ -52,6 +79,12 @@ type EventMessage struct {
 	Error       string            `json:"error,omitempty"`
 	ReportCard  *ReportCard       `json:"reportCard,omitempty"`
 	Files       map[string][]byte `json:"files,omitempty"`
+	Artifacts   []string          `json:"artifacts,omitempty"`
+	DisplayURL  string            `json:"displayURL,omitempty"`
+
+	// milliseconds from the start of the session to the event,
+	// or to the first part of a merged stream event
+	Elapsed int64 `json:"elapsed,omitempty"`
 }
 
 func (e *EventMessage) String() string {
@@ -66,6 +99,10 @@ func (e *EventMessage) String() string {
 		return fmt.Sprintf("event: %s", e.Event)
 	case "error":
 		return fmt.Sprintf("event: error %s", e.Error)
+	case "abuse":
+		return fmt.Sprintf("event: abuse %s", e.Error)
+	case "warning":
+		return fmt.Sprintf("event: warning %s", e.Error)
 	case "reportcard":
 		return fmt.Sprintf("event: reportcard passed=%v %s in %v",
 			e.ReportCard.Passed,
@@ -77,6 +114,10 @@ func (e *EventMessage) String() string {
 			names = append(names, name)
 		}
 		return fmt.Sprintf("event: files %s", strings.Join(names, ", "))
+	case "artifact":
+		return fmt.Sprintf("event: artifact %s", strings.Join(e.Artifacts, ", "))
+	case "display":
+		return fmt.Sprintf("event: display %s", e.DisplayURL)
 	default:
 		return fmt.Sprintf("unknown event: %s", e.Event)
 	}
@@ -98,6 +139,12 @@ func (e *EventMessage) Dump() string {
 		return string(e.StreamData)
 	case "error":
 		return fmt.Sprintf("Error: %s\r\n", e.Error)
+	case "abuse":
+		return fmt.Sprintf("Container killed: %s\r\n", e.Error)
+	case "artifact":
+		return fmt.Sprintf("Saved artifacts: %s\r\n", strings.Join(e.Artifacts, ", "))
+	case "display":
+		return fmt.Sprintf("Program display: %s\r\n", e.DisplayURL)
 	default:
 		return ""
 	}
@@ -114,6 +161,22 @@ func (elt *ReportCard) AddTime(duration time.Duration) {
 	elt.Duration += duration
 }
 
+// Usage describes the resources used by the action, or returns an
+// empty string if they were not measured.
+func (elt *ReportCard) Usage() string {
+	if elt.WallTime == 0 {
+		return ""
+	}
+	usage := fmt.Sprintf("peak memory %.1f MB, CPU time %v, wall time %v",
+		float64(elt.PeakMemory)/(1024*1024),
+		elt.CPUTime.Round(time.Millisecond),
+		elt.WallTime.Round(time.Millisecond))
+	if elt.OutputDiscarded > 0 {
+		usage += fmt.Sprintf(", %d bytes of output discarded", elt.OutputDiscarded)
+	}
+	return usage
+}
+
 func (elt *ReportCard) Failf(note string, params ...interface{}) {
 	elt.Passed = false
 	if elt.Note != "" {
@@ -145,6 +208,22 @@ func (elt *ReportCard) AddFailedResult(name, details, context string) *ReportCar
 	return r
 }
 
+// AddResult adds a result with any outcome,
+// failing the report card unless the outcome is passed.
+func (elt *ReportCard) AddResult(name, outcome, details, context string) *ReportCardResult {
+	if outcome != "passed" {
+		elt.Passed = false
+	}
+	r := &ReportCardResult{
+		Name:    name,
+		Outcome: outcome,
+		Details: details,
+		Context: context,
+	}
+	elt.Results = append(elt.Results, r)
+	return r
+}
+
 func (elt *ReportCard) AddPassedResult(name, details string) *ReportCardResult {
 	r := &ReportCardResult{
 		Name:    name,
@@ -155,19 +234,58 @@ func (elt *ReportCard) AddPassedResult(name, details string) *ReportCardResult {
 	return r
 }
 
+var outcomeLabels = map[string]string{
+	"passed":         "passed",
+	"failed":         "FAILED",
+	"timeout":        "TIMED OUT",
+	"crash":          "CRASHED",
+	"resource-limit": "OVER LIMIT",
+	"flaky":          "flaky",
+	"error":          "ERROR",
+	"skipped":        "skipped",
+}
+
+// Dump lists the results that did not pass, labeled by outcome,
+// in the same form as EventMessage.Dump.
+func (elt *ReportCard) Dump() string {
+	var out strings.Builder
+	for _, result := range elt.Results {
+		if result.Outcome == "passed" {
+			continue
+		}
+		label, ok := outcomeLabels[result.Outcome]
+		if !ok {
+			label = strings.ToUpper(result.Outcome)
+		}
+		fmt.Fprintf(&out, "%-10s %s\r\n", label, result.Name)
+		if result.Outcome != "failed" && result.Details != "" {
+			fmt.Fprintf(&out, "           %s\r\n", strings.Replace(strings.TrimSpace(result.Details), "\n", "\r\n           ", -1))
+		}
+	}
+	if out.Len() == 0 {
+		return ""
+	}
+	return "\r\n" + out.String()
+}
+
 func (elt *ReportCard) ComputeScore() float64 {
 	if len(elt.Results) == 0 {
 		return 0.0
 	}
-	passed := 0
+	passed, total := 0.0, 0.0
 	for _, result := range elt.Results {
-		if result.Outcome == "passed" {
-			passed++
+		weight := result.Weight
+		if weight <= 0.0 {
+			weight = 1.0
+		}
+		total += weight
+		if result.Passing() {
+			passed += weight
 		}
 	}
-	score := float64(passed) / float64(len(elt.Results))
+	score := passed / total
 	if !elt.Passed && score >= 1.0 {
-		score = float64(passed) / float64(len(elt.Results)+1)
+		score = passed / (total + 1.0)
 	}
 	return score
 }
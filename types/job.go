@@ -0,0 +1,14 @@
+package types
+
+import "time"
+
+// BackgroundJob reports the status of a long-running task started by a request.
+type BackgroundJob struct {
+	Name       string     `json:"name"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Done       int        `json:"done,omitempty"`
+	Failed     int        `json:"failed,omitempty"`
+	Total      int        `json:"total,omitempty"`
+}
@@ -0,0 +1,49 @@
+package types
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Locales are language tags such as es or pt-BR, as sent in an LTI launch
+// or an Accept-Language header. They are compared in lower case.
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{1,8})*$`)
+
+// NormalizeLocale returns the lower-case form of a locale, accepting an
+// underscore in place of a hyphen, or "" if it is not a valid language tag.
+func NormalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	locale = strings.Replace(locale, "_", "-", -1)
+	if !localePattern.MatchString(locale) {
+		return ""
+	}
+	return locale
+}
+
+// LocaleFallbacks lists the locales to try for a request, from most to least
+// specific: pt-br-x gives pt-br-x, pt-br, pt.
+func LocaleFallbacks(locale string) []string {
+	locale = NormalizeLocale(locale)
+	var list []string
+	for locale != "" {
+		list = append(list, locale)
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+	return list
+}
+
+// LocalizedInstructions returns the instructions translated for the locale
+// if the problem author supplied a translation, or the default instructions.
+func (step *ProblemStep) LocalizedInstructions(locale string) string {
+	for _, elt := range LocaleFallbacks(locale) {
+		if s, ok := step.Translations[elt]; ok {
+			return s
+		}
+	}
+	return step.Instructions
+}
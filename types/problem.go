@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"path"
 	"path/filepath"
 	"runtime"
 	"sort"
@@ -49,14 +50,30 @@ type ProblemTypeAction struct {
 	MaxThreads  int64 `json:"maxThreads" meddler:"max_threads"`
 }
 
+// ProblemTemplate holds the files used to start a new problem of a given
+// type. Files under _starter and _solution use the same layout as a step
+// directory, and {unique} is replaced by the problem's unique ID.
+type ProblemTemplate struct {
+	ProblemType string            `json:"problemType"`
+	Files       map[string][]byte `json:"files"`
+}
+
 type Problem struct {
-	ID        int64     `json:"id" meddler:"id,pk"`
-	Unique    string    `json:"unique" meddler:"unique_id"`
-	Note      string    `json:"note" meddler:"note"`
-	Tags      []string  `json:"tags" meddler:"tags,json"`
-	Options   []string  `json:"options" meddler:"options,json"`
-	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
-	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+	ID        int64      `json:"id" meddler:"id,pk"`
+	Unique    string     `json:"unique" meddler:"unique_id"`
+	Note      string     `json:"note" meddler:"note"`
+	Tags      []string   `json:"tags" meddler:"tags,json"`
+	Options   []string   `json:"options" meddler:"options,json"`
+	Variants  []string   `json:"variants,omitempty" meddler:"variants,json"` // unique IDs of the same problem in other languages
+	CreatedAt time.Time  `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt time.Time  `json:"updatedAt" meddler:"updated_at,localtime"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty" meddler:"deleted_at,localtime"`
+}
+
+// ProblemVariantChoice is a student's choice of which variant of a
+// multi-language problem to work on, given by its unique ID.
+type ProblemVariantChoice struct {
+	Unique string `json:"unique"`
 }
 
 // ProblemStep represents a single step of a problem.
@@ -69,19 +86,24 @@ type ProblemStep struct {
 	ProblemType  string            `json:"problemType" meddler:"problem_type"`
 	Note         string            `json:"note" meddler:"note"`
 	Instructions string            `json:"instructions" meddler:"instructions"`
+	Translations map[string]string `json:"translations,omitempty" meddler:"translations,json"` // instructions by locale
 	Weight       float64           `json:"weight" meddler:"weight"`
-	Files        map[string][]byte `json:"files" meddler:"files,json"`
+	Files        map[string][]byte `json:"files" meddler:"files,blobs"`
 	Whitelist    map[string]bool   `json:"whitelist" meddler:"whitelist,json"`
-	Solution     map[string][]byte `json:"solution,omitempty" meddler:"solution,json"`
+	Binary       map[string]bool   `json:"binary,omitempty" meddler:"binary_files,json"` // files kept byte for byte
+	Assets       map[string]string `json:"assets,omitempty" meddler:"assets,json"`       // large files kept in the asset store, by sha256 hash
+	Solution     map[string][]byte `json:"solution,omitempty" meddler:"solution,blobs"`
 }
 
 type ProblemSet struct {
-	ID        int64     `json:"id" meddler:"id,pk"`
-	Unique    string    `json:"unique" meddler:"unique_id"`
-	Note      string    `json:"note" meddler:"note"`
-	Tags      []string  `json:"tags" meddler:"tags,json"`
-	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
-	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+	ID        int64      `json:"id" meddler:"id,pk"`
+	Unique    string     `json:"unique" meddler:"unique_id"`
+	Note      string     `json:"note" meddler:"note"`
+	Tags      []string   `json:"tags" meddler:"tags,json"`
+	Public    bool       `json:"public,omitempty" meddler:"public"` // anyone may practice it without an LTI assignment
+	CreatedAt time.Time  `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt time.Time  `json:"updatedAt" meddler:"updated_at,localtime"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty" meddler:"deleted_at,localtime"`
 }
 
 type ProblemSetProblem struct {
@@ -123,14 +145,42 @@ func (problem *Problem) Normalize(now time.Time, steps []*ProblemStep) error {
 	for i, option := range problem.Options {
 		problem.Options[i] = strings.TrimSpace(option)
 	}
+	if _, err := problem.FileNormalization(); err != nil {
+		return err
+	}
 	sort.Strings(problem.Tags)
 
+	// check variants
+	seen := make(map[string]bool)
+	var variants []string
+	for _, variant := range problem.Variants {
+		variant = strings.TrimSpace(variant)
+		if variant == "" || seen[variant] {
+			continue
+		}
+		if variant == problem.Unique {
+			return fmt.Errorf("problem cannot be a variant of itself")
+		}
+		seen[variant] = true
+		variants = append(variants, variant)
+	}
+	sort.Strings(variants)
+	problem.Variants = variants
+
 	// check steps and make sure whitelists never drop names
 	if len(steps) == 0 {
 		return fmt.Errorf("problem must have at least one step")
 	}
+	// files matching binary=<pattern>,<pattern> options are kept byte for byte
+	var binary []string
+	for _, option := range problem.Options {
+		parts := strings.SplitN(option, "=", 2)
+		if len(parts) == 2 && parts[0] == "binary" {
+			binary = append(binary, strings.Split(parts[1], ",")...)
+		}
+	}
 	for n, step := range steps {
-		if err := step.Normalize(int64(n) + 1); err != nil {
+		if err := step.Normalize(int64(n)+1, binary); err != nil {
 			return err
 		}
 
@@ -141,6 +191,9 @@ func (problem *Problem) Normalize(now time.Time, steps []*ProblemStep) error {
 			// make sure everything on the whitelist is carried forward
 			for name := range steps[n-1].Whitelist {
 				step.Whitelist[name] = true
+				if steps[n-1].Binary[name] {
+					step.Binary[name] = true
+				}
 			}
 		}
 	}
@@ -196,6 +249,7 @@ func (problem *Problem) ComputeSignature(secret string, steps []*ProblemStep) st
 	v.Add("note", problem.Note)
 	v["tags"] = problem.Tags
 	v["options"] = problem.Options
+	v["variants"] = problem.Variants
 	v.Add("createdAt", problem.CreatedAt.Round(time.Second).UTC().Format(time.RFC3339))
 	v.Add("updatedAt", problem.UpdatedAt.Round(time.Second).UTC().Format(time.RFC3339))
 	for _, step := range steps {
@@ -208,6 +262,12 @@ func (problem *Problem) ComputeSignature(secret string, steps []*ProblemStep) st
 		for name := range step.Whitelist {
 			v.Add(fmt.Sprintf("step-%d-whitelist-%s", step.Step, name), "true")
 		}
+		for name := range step.Binary {
+			v.Add(fmt.Sprintf("step-%d-binary-%s", step.Step, name), "true")
+		}
+		for name, hash := range step.Assets {
+			v.Add(fmt.Sprintf("step-%d-asset-%s", step.Step, name), hash)
+		}
 	}
 
 	// compute signature
@@ -226,32 +286,55 @@ var ProblemStepDirectoryWhitelist = map[string]bool{
 	"doc":     true,
 }
 
-// fix line endings
-func (step *ProblemStep) Normalize(n int64) error {
+// fix line endings, except in binary files: those that match one of
+// the given patterns, are already flagged, or do not look like text
+func (step *ProblemStep) Normalize(n int64, binary []string) error {
 	step.Step = n
 	step.Note = strings.TrimSpace(step.Note)
 	if step.Note == "" {
 		return fmt.Errorf("missing note for step %d", n+1)
 	}
-	instructions, err := step.BuildInstructions()
+	instructions, translations, err := step.BuildInstructions()
 	if err != nil {
 		return fmt.Errorf("error building instructions for step %d: %v", n, err)
 	}
 	step.Instructions = instructions
+	step.Translations = translations
 	if step.Weight <= 0.0 {
 		// default to 1.0
 		step.Weight = 1.0
 	}
+	if step.Binary == nil {
+		step.Binary = make(map[string]bool)
+	}
+	if step.Assets == nil {
+		step.Assets = make(map[string]string)
+	}
+	for _, pattern := range binary {
+		for name := range step.Files {
+			if matched, _ := path.Match(pattern, name); matched {
+				step.Binary[name] = true
+			}
+		}
+		for name := range step.Whitelist {
+			if matched, _ := path.Match(pattern, name); matched {
+				step.Binary[name] = true
+			}
+		}
+	}
 	clean := make(map[string][]byte)
 	for name, contents := range step.Files {
 		dir := filepath.Dir(filepath.FromSlash(name))
 		fixed := contents
-		if (dir == "." || !ProblemStepDirectoryWhitelist[dir]) && utf8.Valid(contents) {
+		if step.Binary[name] || IsBinary(contents) {
+			// leave binary files alone
+			step.Binary[name] = true
+		} else if dir == "." || !ProblemStepDirectoryWhitelist[dir] {
 			fixed = fixLineEndings(contents)
 			if !bytes.Equal(fixed, contents) {
 				log.Printf("fixed line endings for %s", name)
 			}
-		} else if utf8.Valid(contents) {
+		} else {
 			fixed = fixNewLines(contents)
 			if !bytes.Equal(fixed, contents) {
 				log.Printf("fixed newlines for %s", name)
@@ -263,9 +346,67 @@ func (step *ProblemStep) Normalize(n int64) error {
 	return nil
 }
 
+// ExtraFiles returns the patterns from the extra=<pattern>,<pattern> options
+// of a problem. Students may submit files matching these patterns in
+// addition to the whitelist, such as helper modules of their own.
+func (problem *Problem) ExtraFiles() []string {
+	var patterns []string
+	for _, option := range problem.Options {
+		parts := strings.SplitN(option, "=", 2)
+		if len(parts) == 2 && parts[0] == "extra" {
+			for _, pattern := range strings.Split(parts[1], ",") {
+				if pattern = strings.TrimSpace(pattern); pattern != "" {
+					patterns = append(patterns, pattern)
+				}
+			}
+		}
+	}
+	return patterns
+}
+
+// AcceptsExtraFile reports whether a student may add the named file to a
+// commit for this step using one of the given extra patterns. A student
+// file can never replace a file that belongs to the step.
+func (step *ProblemStep) AcceptsExtraFile(patterns []string, name string) bool {
+	if name != path.Clean(name) || path.IsAbs(name) || strings.HasPrefix(name, "../") || strings.HasPrefix(name, "doc/") {
+		return false
+	}
+	if _, exists := step.Files[name]; exists {
+		return false
+	}
+	if _, exists := step.Assets[name]; exists {
+		return false
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedFiles returns the whitelist for a commit to this step, extended
+// with any files in the commit that the extra patterns accept.
+func (step *ProblemStep) AllowedFiles(patterns []string, files map[string][]byte) map[string]bool {
+	allowed := make(map[string]bool)
+	for name := range step.Whitelist {
+		allowed[name] = true
+	}
+	for name := range files {
+		if !allowed[name] && step.AcceptsExtraFile(patterns, name) {
+			allowed[name] = true
+		}
+	}
+	return allowed
+}
+
 // buildInstructions builds the instructions for a problem step as a single
 // html document. Markdown is processed and images are inlined.
-func (step *ProblemStep) BuildInstructions() (string, error) {
+// BuildInstructions renders doc/doc.html or doc/doc.md as the instructions
+// for the step. Translations are given the same way with the locale in the
+// name, such as doc/doc.es.md or doc/doc.pt-BR.html, and are returned keyed
+// by the normalized locale.
+func (step *ProblemStep) BuildInstructions() (string, map[string]string, error) {
 	// get a list of all files in the doc directory
 	used := make(map[string]bool)
 	for name := range step.Files {
@@ -274,9 +415,57 @@ func (step *ProblemStep) BuildInstructions() (string, error) {
 		}
 	}
 
+	instructions, err := step.renderDoc("doc", used)
+	if err != nil {
+		return "", nil, err
+	}
+	if instructions == "" {
+		return "", nil, loggedErrorf("no documentation found: checked doc/doc.html and doc/doc.md")
+	}
+
+	// look for translations
+	var translations map[string]string
+	for name := range used {
+		base := filepath.Base(name)
+		ext := filepath.Ext(base)
+		stem := strings.TrimSuffix(base, ext)
+		if ext != ".html" && ext != ".md" || !strings.HasPrefix(stem, "doc.") {
+			continue
+		}
+		tag := strings.TrimPrefix(stem, "doc.")
+		locale := NormalizeLocale(tag)
+		if locale == "" {
+			return "", nil, loggedErrorf("%s: %q is not a valid locale for a translation", name, tag)
+		}
+		if _, ok := translations[locale]; ok {
+			continue
+		}
+		translated, err := step.renderDoc("doc."+tag, used)
+		if err != nil {
+			return "", nil, err
+		}
+		if translations == nil {
+			translations = make(map[string]string)
+		}
+		translations[locale] = translated
+	}
+
+	// warn about unused files in doc
+	for name, u := range used {
+		if !u {
+			log.Printf("Warning: %s was not used in the instructions", name)
+		}
+	}
+
+	return instructions, translations, nil
+}
+
+// renderDoc renders doc/<base>.html or doc/<base>.md, marking the files it
+// uses. It returns "" if neither file exists.
+func (step *ProblemStep) renderDoc(base string, used map[string]bool) (string, error) {
 	var justHTML []byte
-	dochtml := filepath.Join("doc", "doc.html")
-	docmd := filepath.Join("doc", "doc.md")
+	dochtml := filepath.Join("doc", base+".html")
+	docmd := filepath.Join("doc", base+".md")
 	if data, ok := step.Files[dochtml]; ok {
 		justHTML = data
 		used[dochtml] = true
@@ -297,18 +486,18 @@ func (step *ProblemStep) BuildInstructions() (string, error) {
 			blackfriday.WithRenderer(renderer))
 		used[docmd] = true
 	} else {
-		return "", loggedErrorf("no documentation found: checked doc/doc.html and doc/doc.md")
+		return "", nil
 	}
 
 	// make sure it is well-formed utf8
 	if !utf8.Valid(justHTML) {
-		return "", loggedErrorf("doc.{html,md} is not valid utf8")
+		return "", loggedErrorf("%s.{html,md} is not valid utf8", base)
 	}
 
 	// parse the html
 	doc, err := html.Parse(bytes.NewReader(justHTML))
 	if err != nil {
-		log.Printf("Error parsing doc.html: %v", err)
+		log.Printf("Error parsing %s: %v", base, err)
 		return "", err
 	}
 	if doc == nil {
@@ -363,13 +552,6 @@ func (step *ProblemStep) BuildInstructions() (string, error) {
 		return "", err
 	}
 
-	// warn about unused files in doc
-	for name, u := range used {
-		if !u {
-			log.Printf("Warning: %s was not used in the instructions", name)
-		}
-	}
-
 	// re-render it
 	var buf bytes.Buffer
 	if err = html.Render(&buf, doc); err != nil {
@@ -414,6 +596,73 @@ func (set *ProblemSet) Normalize(now time.Time) error {
 	return nil
 }
 
+// IsBinary reports whether file contents look like binary data
+// rather than text, in which case they should be stored as is.
+func IsBinary(contents []byte) bool {
+	return !utf8.Valid(contents) || bytes.IndexByte(contents, 0) >= 0
+}
+
+// FileNormalization selects the fixes applied to student files
+// before they are submitted.
+type FileNormalization struct {
+	LineEndings bool // convert CRLF and lone CR line endings to LF
+	BOM         bool // strip a leading byte order mark
+	UTF8        bool // re-encode text that is not valid UTF-8
+}
+
+// FileNormalization reads the normalize=<fix>,<fix> option of a problem,
+// where each fix is lf, bom, or utf8. All fixes apply by default, and
+// normalize=none turns them off for problems where the exact bytes matter.
+func (problem *Problem) FileNormalization() (FileNormalization, error) {
+	norm := FileNormalization{LineEndings: true, BOM: true, UTF8: true}
+	for _, option := range problem.Options {
+		parts := strings.SplitN(option, "=", 2)
+		if len(parts) != 2 || parts[0] != "normalize" {
+			continue
+		}
+		norm = FileNormalization{}
+		for _, fix := range strings.Split(parts[1], ",") {
+			switch strings.TrimSpace(fix) {
+			case "lf":
+				norm.LineEndings = true
+			case "bom":
+				norm.BOM = true
+			case "utf8":
+				norm.UTF8 = true
+			case "none", "":
+			default:
+				return norm, fmt.Errorf("unknown normalize option %q: expected lf, bom, utf8, or none", fix)
+			}
+		}
+	}
+	return norm, nil
+}
+
+// Apply fixes the contents of a file.
+// Files containing NUL bytes are left alone.
+func (norm FileNormalization) Apply(contents []byte) []byte {
+	if bytes.IndexByte(contents, 0) >= 0 {
+		return contents
+	}
+	fixed := contents
+	if norm.BOM {
+		fixed = bytes.TrimPrefix(fixed, []byte("\xef\xbb\xbf"))
+	}
+	if norm.UTF8 && !utf8.Valid(fixed) {
+		// editors that do not write UTF-8 almost always use Latin-1
+		var buf bytes.Buffer
+		for _, b := range fixed {
+			buf.WriteRune(rune(b))
+		}
+		fixed = buf.Bytes()
+	}
+	if norm.LineEndings && bytes.IndexByte(fixed, '\r') >= 0 {
+		fixed = bytes.Replace(fixed, []byte("\r\n"), []byte("\n"), -1)
+		fixed = bytes.Replace(fixed, []byte("\r"), []byte("\n"), -1)
+	}
+	return fixed
+}
+
 func fixLineEndings(s []byte) []byte {
 	s = append(bytes.Replace(s, []byte("\r\n"), []byte("\n"), -1), '\n')
 	for bytes.Contains(s, []byte(" \n")) {
@@ -459,3 +708,10 @@ func logPrefix() string {
 	}
 	return prefix
 }
+
+// DeletedObjects lists everything that has been deleted but not yet purged.
+type DeletedObjects struct {
+	Problems    []*Problem    `json:"problems"`
+	ProblemSets []*ProblemSet `json:"problemSets"`
+	Courses     []*Course     `json:"courses"`
+}
@@ -0,0 +1,112 @@
+package types
+
+import "time"
+
+// Similarity is the similarity score between the final submissions of two
+// students for the same problem in an assignment.
+type Similarity struct {
+	ID            int64     `json:"id" meddler:"id,pk"`
+	CourseID      int64     `json:"courseID" meddler:"course_id"`
+	LtiID         string    `json:"-" meddler:"lti_id"`
+	ProblemID     int64     `json:"problemID" meddler:"problem_id"`
+	AssignmentAID int64     `json:"assignmentAID" meddler:"assignment_a_id"`
+	AssignmentBID int64     `json:"assignmentBID" meddler:"assignment_b_id"`
+	CommitAID     int64     `json:"commitAID" meddler:"commit_a_id"`
+	CommitBID     int64     `json:"commitBID" meddler:"commit_b_id"`
+	Score         float64   `json:"score" meddler:"score"` // fraction of the smaller submission found in the other
+	CreatedAt     time.Time `json:"createdAt" meddler:"created_at,localtime"`
+}
+
+// SimilarityComparison gives both sides of a flagged pair for side-by-side review.
+type SimilarityComparison struct {
+	Similarity *Similarity `json:"similarity"`
+	UserA      *User       `json:"userA"`
+	UserB      *User       `json:"userB"`
+	CommitA    *Commit     `json:"commitA"`
+	CommitB    *Commit     `json:"commitB"`
+}
+
+// MossReport is the URL of a MOSS report comparing the final submissions
+// of every student for one problem in an assignment. Submissions in the
+// report are labeled by user ID; Students maps those labels back to names.
+type MossReport struct {
+	ID        int64             `json:"id" meddler:"id,pk"`
+	CourseID  int64             `json:"courseID" meddler:"course_id"`
+	LtiID     string            `json:"-" meddler:"lti_id"`
+	ProblemID int64             `json:"problemID" meddler:"problem_id"`
+	Language  string            `json:"language" meddler:"language"`
+	URL       string            `json:"url" meddler:"url"`
+	Students  map[string]string `json:"students,omitempty" meddler:"-"`
+	CreatedAt time.Time         `json:"createdAt" meddler:"created_at,localtime"`
+}
+
+// Analytics summarizes the progress of a group of students through a set of problems.
+type Analytics struct {
+	Students          int              `json:"students"`
+	AverageScore      float64          `json:"averageScore"`
+	ScoreDistribution []int            `json:"scoreDistribution"` // count of students in each 10% bucket, 0-9% first
+	Steps             []*StepAnalytics `json:"steps"`
+}
+
+// StepAnalytics summarizes the progress of a group of students on one problem step.
+type StepAnalytics struct {
+	ProblemID         int64   `json:"problemID"`
+	ProblemUnique     string  `json:"problemUnique"`
+	Step              int64   `json:"step"`
+	Started           int     `json:"started"`
+	Passed            int     `json:"passed"`
+	CompletionPercent float64 `json:"completionPercent"`
+	AverageAttempts   float64 `json:"averageAttempts"`            // graded attempts per student who started the step
+	MedianTimeToPass  float64 `json:"medianTimeToPass"`           // seconds from first commit to first passing commit
+	MedianActiveTime  float64 `json:"medianActiveTime,omitempty"` // seconds of tracked editing before the first passing commit
+}
+
+// FlakyTest reports how often one test in a problem step has been marked
+// flaky, i.e., it passed on only one of the two runs of a submission that
+// was graded again after failing.
+type FlakyTest struct {
+	ProblemID int64   `json:"problemID"`
+	Step      int64   `json:"step"`
+	Name      string  `json:"name"`
+	Runs      int     `json:"runs"`  // graded runs that included this test
+	Flaky     int     `json:"flaky"` // runs where it was marked flaky
+	FlakeRate float64 `json:"flakeRate"`
+}
+
+// StudentWarning flags a student who may be struggling with an assignment.
+// Warnings are recomputed by a nightly job.
+type StudentWarning struct {
+	ID           int64     `json:"id" meddler:"id,pk"`
+	CourseID     int64     `json:"courseID" meddler:"course_id"`
+	LtiID        string    `json:"-" meddler:"lti_id"`
+	AssignmentID int64     `json:"assignmentID" meddler:"assignment_id"`
+	UserID       int64     `json:"userID" meddler:"user_id"`
+	Kind         string    `json:"kind" meddler:"kind"` // "attempts", "gap", or "inactive"
+	Detail       string    `json:"detail" meddler:"detail"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
+}
+
+// ScoreOverride is a score set by hand by an instructor, which takes
+// precedence over the computed score when grades are reported.
+// The most recent override for an assignment is the one in effect;
+// an override with no score removes any earlier one.
+type ScoreOverride struct {
+	ID           int64     `json:"id" meddler:"id,pk"`
+	AssignmentID int64     `json:"assignmentID" meddler:"assignment_id"`
+	UserID       int64     `json:"userID" meddler:"user_id"` // the instructor who set it
+	Score        *float64  `json:"score" meddler:"score"`
+	Reason       string    `json:"reason" meddler:"reason"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
+}
+
+// AuditEvent records an action taken by an instructor or administrator
+// that changes or reveals student data.
+type AuditEvent struct {
+	ID         int64     `json:"id" meddler:"id,pk"`
+	UserID     int64     `json:"userID" meddler:"user_id"` // who did it
+	Action     string    `json:"action" meddler:"action"`
+	ObjectType string    `json:"objectType" meddler:"object_type"`
+	ObjectID   int64     `json:"objectID" meddler:"object_id"`
+	Detail     string    `json:"detail" meddler:"detail"`
+	CreatedAt  time.Time `json:"createdAt" meddler:"created_at,localtime"`
+}
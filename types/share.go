@@ -0,0 +1,23 @@
+package types
+
+import "time"
+
+// CommitShare is a link that lets anyone who has it see one saved version
+// of a commit, read only: its files, report card, and transcript. Students
+// make them to ask for help, and they expire.
+type CommitShare struct {
+	ID        int64     `json:"id" meddler:"id,pk"`
+	Token     string    `json:"-" meddler:"token"`
+	CommitID  int64     `json:"commitID" meddler:"commit_id"`
+	HistoryID int64     `json:"historyID" meddler:"history_id"` // the saved version that is shown
+	UserID    int64     `json:"userID" meddler:"user_id"`       // who made the link
+	ExpiresAt time.Time `json:"expiresAt" meddler:"expires_at,localtime"`
+	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	URL       string    `json:"url,omitempty" meddler:"-"`
+}
+
+// default and longest lifetimes of a share link
+const (
+	ShareDefaultHours = 7 * 24
+	ShareMaxHours     = 30 * 24
+)
@@ -18,36 +18,198 @@ import (
 const (
 	TranscriptEventCountLimit = 500
 	TranscriptDataLimit       = 1e5
+	ArtifactDataLimit         = 1e6
 	OpenCommitTimeout         = 6 * time.Hour
 	SignedCommitTimeout       = 15 * time.Minute
 	CookieName                = "codegrinder"
+
+	// stream events that start within this many milliseconds
+	// of each other are merged in transcripts
+	TranscriptMergeWindow = 100
 )
 
 // Course represents a single instance of a course as defined by LTI.
 type Course struct {
+	ID             int64      `json:"id" meddler:"id,pk"`
+	Name           string     `json:"name" meddler:"name"`
+	Label          string     `json:"label" meddler:"lti_label"`
+	LtiID          string     `json:"ltiID" meddler:"lti_id"`
+	ConsumerKey    string     `json:"consumerKey" meddler:"consumer_key"`
+	CanvasID       int64      `json:"canvasID" meddler:"canvas_id"`
+	MembershipsURL string     `json:"membershipsURL,omitempty" meddler:"memberships_url"` // LTI membership service, if the LMS offers one
+	RosterSyncedAt *time.Time `json:"rosterSyncedAt,omitempty" meddler:"roster_synced_at,localtime"`
+	CreatedAt      time.Time  `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt      time.Time  `json:"updatedAt" meddler:"updated_at,localtime"`
+	DeletedAt      *time.Time `json:"deletedAt,omitempty" meddler:"deleted_at,localtime"`
+}
+
+// CourseSection is a section of a course as reported by the LMS.
+// LtiID is the LMS's own identifier for the section.
+type CourseSection struct {
 	ID        int64     `json:"id" meddler:"id,pk"`
-	Name      string    `json:"name" meddler:"name"`
-	Label     string    `json:"label" meddler:"lti_label"`
+	CourseID  int64     `json:"courseID" meddler:"course_id"`
 	LtiID     string    `json:"ltiID" meddler:"lti_id"`
-	CanvasID  int64     `json:"canvasID" meddler:"canvas_id"`
+	Name      string    `json:"name" meddler:"name"`
+	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// CourseMember is an enrollment in a course as reported by the LMS when
+// the roster is synced, whether or not the user has launched anything.
+// Members who are no longer listed by the LMS are kept but marked inactive.
+type CourseMember struct {
+	ID        int64     `json:"id" meddler:"id,pk"`
+	CourseID  int64     `json:"courseID" meddler:"course_id"`
+	UserID    int64     `json:"userID" meddler:"user_id"`
+	SectionID int64     `json:"sectionID,omitempty" meddler:"section_id,zeroisnull"`
+	Roles     string    `json:"roles" meddler:"roles"`
+	Active    bool      `json:"active" meddler:"active"`
+	SyncedAt  time.Time `json:"syncedAt" meddler:"synced_at,localtime"`
+	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// CourseLimits restricts how a course may use the daycares and which
+// commits it accepts. An empty list of problem types allows every type,
+// and a limit of zero minutes per day means there is no limit.
+type CourseLimits struct {
+	ID            int64     `json:"id" meddler:"id,pk"`
+	CourseID      int64     `json:"courseID" meddler:"course_id"`
+	ProblemTypes  []string  `json:"problemTypes" meddler:"problem_types,json"`
+	DailyMinutes  int64     `json:"dailyMinutes" meddler:"daily_minutes"`
+	UserMinutes   int64     `json:"userMinutes" meddler:"user_minutes"`
+	SignedCommits bool      `json:"signedCommits" meddler:"signed_commits"` // only accept commits signed by a registered copy of grind
+	CreatedAt     time.Time `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt     time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
+}
+
+// CourseRoster lists everyone enrolled in a course with their scores.
+// Each user has one score per assignment, in the same order as
+// Assignments, which is null if the user has not opened it.
+type CourseRoster struct {
+	Course      *Course             `json:"course"`
+	Sections    []*CourseSection    `json:"sections"`
+	Assignments []*RosterAssignment `json:"assignments"`
+	Users       []*RosterUser       `json:"users"`
+}
+
+// RosterAssignment is an assignment as it appears in the LMS.
+type RosterAssignment struct {
+	Title        string     `json:"title"`
+	ProblemSetID int64      `json:"problemSetID,omitempty"`
+	DueAt        *time.Time `json:"dueAt,omitempty"`
+}
+
+// RosterUser is one user in a course roster. Roles are the LTI roles
+// from the user's most recent launch, and LastActivity is the most
+// recent launch or commit. Users found by a roster sync who have not
+// launched anything yet have Started set to false and the roles the
+// LMS reported. Section is the name of the user's section, if known.
+type RosterUser struct {
+	ID           int64      `json:"id"`
+	Name         string     `json:"name"`
+	Email        string     `json:"email"`
+	Roles        string     `json:"roles"`
+	Instructor   bool       `json:"instructor"`
+	Started      bool       `json:"started"`
+	Section      string     `json:"section,omitempty"`
+	LastActivity *time.Time `json:"lastActivity,omitempty"`
+	Scores       []*float64 `json:"scores"`
+}
+
+// LTIConsumer is an LMS installation that launches problem sets,
+// identified by its OAuth consumer key and holding its own shared secret.
+type LTIConsumer struct {
+	ID        int64     `json:"id" meddler:"id,pk"`
+	Key       string    `json:"key" meddler:"consumer_key"`
+	Secret    string    `json:"secret" meddler:"secret"`
+	Name      string    `json:"name" meddler:"name"`
 	CreatedAt time.Time `json:"createdAt" meddler:"created_at,localtime"`
 	UpdatedAt time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
 }
 
+// AccountRequest is the body of a request to register, log in,
+// or reset the password of an email/password account, for
+// users who do not come through an LMS. Only the fields relevant
+// to each request are used.
+type AccountRequest struct {
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// DeviceAuthorization is the response to an OAuth2 device authorization
+// request (RFC 8628). The client shows the user code and verification URI
+// to the user, then polls for a token using the device code.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceApproval is sent by the device page to approve a user code.
+type DeviceApproval struct {
+	UserCode string `json:"user_code"`
+}
+
+// DeviceToken is the response when polling for an OAuth2 device token.
+// While the request is pending, only Error is set. The access token is
+// a session cookie to be sent with later requests.
+type DeviceToken struct {
+	AccessToken string `json:"access_token,omitempty"`
+	TokenType   string `json:"token_type,omitempty"`
+	ExpiresIn   int64  `json:"expires_in,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
 // User represents a single user as defined by LTI.
 type User struct {
-	ID             int64     `json:"id" meddler:"id,pk"`
-	Name           string    `json:"name" meddler:"name"`
-	Email          string    `json:"email" meddler:"email"`
-	LtiID          string    `json:"ltiID" meddler:"lti_id"`
-	ImageURL       string    `json:"imageURL" meddler:"lti_image_url"`
-	CanvasLogin    string    `json:"canvasLogin" meddler:"canvas_login"`
-	CanvasID       int64     `json:"canvasID" meddler:"canvas_id"`
-	Author         bool      `json:"author" meddler:"author"`
-	Admin          bool      `json:"admin" meddler:"admin"`
-	CreatedAt      time.Time `json:"createdAt" meddler:"created_at,localtime"`
-	UpdatedAt      time.Time `json:"updatedAt" meddler:"updated_at,localtime"`
-	LastSignedInAt time.Time `json:"lastSignedInAt" meddler:"last_signed_in_at,localtime"`
+	ID             int64              `json:"id" meddler:"id,pk"`
+	Name           string             `json:"name" meddler:"name"`
+	Email          string             `json:"email" meddler:"email"`
+	LtiID          string             `json:"ltiID" meddler:"lti_id"`
+	ConsumerKey    string             `json:"consumerKey" meddler:"consumer_key"` // the LTI consumer the ID came from; empty for local accounts
+	ImageURL       string             `json:"imageURL" meddler:"lti_image_url"`
+	CanvasLogin    string             `json:"canvasLogin" meddler:"canvas_login"`
+	CanvasID       int64              `json:"canvasID" meddler:"canvas_id,zeroisnull"` // zero until the user launches from Canvas
+	Author         bool               `json:"author" meddler:"author"`
+	Admin          bool               `json:"admin" meddler:"admin"`
+	Locale         string             `json:"locale,omitempty" meddler:"locale"`                    // from the most recent LTI launch
+	Notifications  *NotificationPrefs `json:"notifications,omitempty" meddler:"notifications,json"` // nil for the defaults
+	CreatedAt      time.Time          `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt      time.Time          `json:"updatedAt" meddler:"updated_at,localtime"`
+	LastSignedInAt time.Time          `json:"lastSignedInAt" meddler:"last_signed_in_at,localtime"`
+}
+
+// NotificationPrefs records which email notifications a user wants.
+// Everything is on by default; see User.NotificationPrefs.
+type NotificationPrefs struct {
+	DeadlineReminders bool `json:"deadlineReminders"` // an assignment is due soon and not finished
+	RegradeResults    bool `json:"regradeResults"`    // a regrade changed a submission
+	WeeklyDigest      bool `json:"weeklyDigest"`      // instructors only: a summary of each course
+}
+
+// NotificationPrefs returns the user's notification preferences,
+// or the defaults if the user has never set them.
+func (user *User) NotificationPrefs() *NotificationPrefs {
+	if user.Notifications == nil {
+		return &NotificationPrefs{DeadlineReminders: true, RegradeResults: true, WeeklyDigest: true}
+	}
+	return user.Notifications
+}
+
+// Notification records an email notification sent to a user.
+type Notification struct {
+	ID           int64     `json:"id" meddler:"id,pk"`
+	UserID       int64     `json:"userID" meddler:"user_id"`
+	Kind         string    `json:"kind" meddler:"kind"` // "deadline", "regrade", or "digest"
+	AssignmentID int64     `json:"assignmentID,omitempty" meddler:"assignment_id,zeroisnull"`
+	Subject      string    `json:"subject" meddler:"subject"`
+	CreatedAt    time.Time `json:"createdAt" meddler:"created_at,localtime"`
 }
 
 // Assignment represents a single instance of a problem set for a student in a course.
@@ -60,6 +222,7 @@ type Assignment struct {
 	Roles              string               `json:"roles" meddler:"roles"`
 	Instructor         bool                 `json:"instructor" meddler:"instructor"`
 	RawScores          map[string][]float64 `json:"rawScores" meddler:"raw_scores,json"`
+	Variants           map[string]string    `json:"variants,omitempty" meddler:"variants,json"` // the variant chosen for each multi-language problem
 	Score              float64              `json:"score" meddler:"score,zeroisnull"`
 	GradeID            string               `json:"-" meddler:"grade_id,zeroisnull"`
 	LtiID              string               `json:"-" meddler:"lti_id"`
@@ -86,14 +249,34 @@ type Commit struct {
 	Step         int64             `json:"step" meddler:"step"` // note: one-based
 	Action       string            `json:"action" meddler:"action,zeroisnull"`
 	Note         string            `json:"note" meddler:"note,zeroisnull"`
-	Files        map[string][]byte `json:"files" meddler:"files,json"`
+	Message      string            `json:"message,omitempty" meddler:"message,zeroisnull"` // the student's own note about this commit
+	Files        map[string][]byte `json:"files" meddler:"files,blobs"`
+	FileHashes   map[string]string `json:"fileHashes,omitempty" meddler:"-"` // files uploaded by hash only, filled in by the server
 	Transcript   []*EventMessage   `json:"transcript,omitempty" meddler:"transcript,json"`
 	ReportCard   *ReportCard       `json:"reportCard" meddler:"report_card,json"`
+	Artifacts    map[string][]byte `json:"artifacts,omitempty" meddler:"artifacts,json"` // output files saved by the daycare
 	Score        float64           `json:"score" meddler:"score,zeroisnull"`
+	ActiveTime   int64             `json:"activeTime,omitempty" meddler:"active_time,zeroisnull"` // seconds spent editing this step, if the student tracks it
+	Client       *CommitClient     `json:"client,omitempty" meddler:"client,json"`
+	ClientKeyID  int64             `json:"clientKeyID,omitempty" meddler:"client_key_id,zeroisnull"` // the key grind signed the commit with, as verified by the server
 	CreatedAt    time.Time         `json:"createdAt" meddler:"created_at,localtime"`
 	UpdatedAt    time.Time         `json:"updatedAt" meddler:"updated_at,localtime"`
 }
 
+// CommitClient describes the copy of grind that made a commit, as reported
+// by grind itself, to help explain failures that depend on the student's
+// environment and to help instructors look into unusual submissions.
+// None of it is verified.
+type CommitClient struct {
+	Version  string `json:"version,omitempty"`  // grind version
+	Platform string `json:"platform,omitempty"` // GOOS/GOARCH
+	HostHash string `json:"hostHash,omitempty"` // hash of the computer's name, the same for every user
+	Modified *bool  `json:"modified,omitempty"` // files were changed since this step was last graded, if known
+}
+
+// maxClientField is the longest value kept in a CommitClient field.
+const maxClientField = 64
+
 // isInstructorRole returns true if the given LTI Roles field indicates this
 // user is an instructor for a specific course.
 func (asst *Assignment) IsInstructorRole() bool {
@@ -152,9 +335,26 @@ func (commit *Commit) ComputeSignature(secret, problemTypeSignature, problemSign
 	v.Add("step", strconv.FormatInt(commit.Step, 10))
 	v.Add("action", commit.Action)
 	v.Add("note", commit.Note)
+	if commit.Message != "" {
+		v.Add("message", commit.Message)
+	}
+	if commit.ClientKeyID != 0 {
+		v.Add("client_key_id", strconv.FormatInt(commit.ClientKeyID, 10))
+	}
+	if c := commit.Client; c != nil {
+		v.Add("client-version", c.Version)
+		v.Add("client-platform", c.Platform)
+		v.Add("client-host-hash", c.HostHash)
+		if c.Modified != nil {
+			v.Add("client-modified", strconv.FormatBool(*c.Modified))
+		}
+	}
 	for name, contents := range commit.Files {
 		v.Add(fmt.Sprintf("file-%s", name), string(contents))
 	}
+	for name, contents := range commit.Artifacts {
+		v.Add(fmt.Sprintf("artifact-%s", name), string(contents))
+	}
 	for n, event := range commit.Transcript {
 		v.Add(fmt.Sprintf("transcript-%d", n), event.String())
 	}
@@ -162,6 +362,20 @@ func (commit *Commit) ComputeSignature(secret, problemTypeSignature, problemSign
 		v.Add("reportcard-passed", strconv.FormatBool(commit.ReportCard.Passed))
 		v.Add("reportcard-note", commit.ReportCard.Note)
 		v.Add("reportcard-duration", commit.ReportCard.Duration.String())
+		if commit.ReportCard.Abuse != "" {
+			v.Add("reportcard-abuse", commit.ReportCard.Abuse)
+		}
+		if commit.ReportCard.WallTime != 0 {
+			v.Add("reportcard-peak-memory", strconv.FormatInt(commit.ReportCard.PeakMemory, 10))
+			v.Add("reportcard-cpu-time", commit.ReportCard.CPUTime.String())
+			v.Add("reportcard-wall-time", commit.ReportCard.WallTime.String())
+		}
+		if commit.ReportCard.Coverage != 0 {
+			v.Add("reportcard-coverage", strconv.FormatFloat(commit.ReportCard.Coverage, 'g', -1, 64))
+		}
+		if commit.ReportCard.OutputDiscarded != 0 {
+			v.Add("reportcard-output-discarded", strconv.FormatInt(commit.ReportCard.OutputDiscarded, 10))
+		}
 		for n, result := range commit.ReportCard.Results {
 			v.Add(fmt.Sprintf("reportcard-%d-name", n), result.Name)
 			v.Add(fmt.Sprintf("reportcard-%d-outcome", n), result.Outcome)
@@ -171,6 +385,9 @@ func (commit *Commit) ComputeSignature(secret, problemTypeSignature, problemSign
 			if result.Context != "" {
 				v.Add(fmt.Sprintf("reportcard-%d-context", n), result.Context)
 			}
+			if result.Weight != 0 {
+				v.Add(fmt.Sprintf("reportcard-%d-weight", n), strconv.FormatFloat(result.Weight, 'g', -1, 64))
+			}
 		}
 	}
 	v.Add("score", strconv.FormatFloat(commit.Score, 'g', -1, 64))
@@ -190,11 +407,18 @@ func (commit *Commit) ComputeSignature(secret, problemTypeSignature, problemSign
 	return sig
 }
 
-func (commit *Commit) Normalize(now time.Time, whitelist map[string]bool) error {
+func (commit *Commit) Normalize(now time.Time, whitelist, binary map[string]bool) error {
 	// ID, AssignmentID, Step, and UserID are all checked elsewhere
 	commit.Action = strings.TrimSpace(commit.Action)
 	commit.Note = strings.TrimSpace(commit.Note)
-	commit.FilterIncoming(whitelist)
+	if c := commit.Client; c != nil {
+		for _, field := range []*string{&c.Version, &c.Platform, &c.HostHash} {
+			if *field = strings.TrimSpace(*field); len(*field) > maxClientField {
+				*field = (*field)[:maxClientField]
+			}
+		}
+	}
+	commit.FilterIncoming(whitelist, binary)
 	if len(commit.Files) == 0 {
 		return fmt.Errorf("commit must have at least one file")
 	}
@@ -212,12 +436,15 @@ func (commit *Commit) Normalize(now time.Time, whitelist map[string]bool) error
 }
 
 // filter out files in subdirectories/not on whitelist, and clean up line endings
-func (commit *Commit) FilterIncoming(whitelist map[string]bool) {
+// except in binary files
+func (commit *Commit) FilterIncoming(whitelist, binary map[string]bool) {
 	clean := make(map[string][]byte)
 	for name, contents := range commit.Files {
 		// normalize line endings
 		// only keep files on the whitelist
-		if whitelist[name] {
+		if whitelist[name] && (binary[name] || IsBinary(contents)) {
+			clean[name] = contents
+		} else if whitelist[name] {
 			clean[name] = fixLineEndings(contents)
 		} else {
 			log.Printf("filtered out %s, which is not on the problem step whitelist", name)
@@ -232,9 +459,25 @@ func (commit *Commit) DumpTranscript(w io.Writer) error {
 			return err
 		}
 	}
+	if commit.ReportCard != nil {
+		if _, err := fmt.Fprintf(w, "%s", commit.ReportCard.Dump()); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// Warnings returns the compiler warnings recorded in the transcript.
+func (commit *Commit) Warnings() []string {
+	var warnings []string
+	for _, elt := range commit.Transcript {
+		if elt.Event == "warning" {
+			warnings = append(warnings, elt.Error)
+		}
+	}
+	return warnings
+}
+
 // this is url.URL.Encode from the standard library, but using escape instead of url.QueryEscape
 func encode(v url.Values) []byte {
 	if v == nil {
@@ -6,6 +6,32 @@ type Version struct {
 	GrindVersionRecommended  string `json:"grindVersionRecommended"`
 	ThonnyVersionRequired    string `json:"thonnyVersionRequired"`
 	ThonnyVersionRecommended string `json:"thonnyVersionRecommended"`
+	GrindDownloadURL         string `json:"grindDownloadURL,omitempty"` // add /<os>/<arch> to download the newest grind
+	APIVersion               int    `json:"apiVersion,omitempty"`
+	APIVersionMinimum        int    `json:"apiVersionMinimum,omitempty"`
+}
+
+// APIVersion is the revision of the API spoken by this code. It changes
+// within /v2 as endpoints are added, changed, and retired. grind sends it
+// in the APIVersionHeader of every request and the server replies with its
+// own, so each side knows what the other understands.
+const APIVersion = 1
+
+// APIVersionMinimum is the oldest client API revision the server accepts.
+const APIVersionMinimum = 1
+
+const APIVersionHeader = "X-CodeGrinder-API-Version"
+
+// GrindRelease describes a grind binary that can be downloaded from the TA.
+type GrindRelease struct {
+	Version   string `json:"version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature,omitempty"` // base64 ed25519 signature of the binary
+	URL       string `json:"url"`
+	Binary    []byte `json:"binary,omitempty"` // only included when uploading a release
 }
 
 var CurrentVersion = Version{